@@ -3,5 +3,19 @@ module lesson-01-rest-concepts
 go 1.21
 
 require (
+	cachecontrol v0.0.0
 	github.com/gorilla/mux v1.8.1
-)
\ No newline at end of file
+	httpcond v0.0.0
+	logging v0.0.0
+	money v0.0.0
+)
+
+require correlation v0.0.0 // indirect
+
+replace (
+	cachecontrol => ../pkg/cachecontrol
+	correlation => ../pkg/correlation
+	httpcond => ../pkg/httpcond
+	logging => ../pkg/logging
+	money => ../pkg/money
+)
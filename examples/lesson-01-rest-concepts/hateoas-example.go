@@ -2,12 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/gorilla/mux"
+	"money"
 )
 
 // User with HATEOAS links
@@ -20,11 +24,29 @@ type User struct {
 
 // Order represents a user's order
 type Order struct {
-	ID     int    `json:"id"`
-	UserID int    `json:"user_id"`
-	Total  float64 `json:"total"`
-	Status string `json:"status"`
-	Links  Links  `json:"_links"`
+	ID     int         `json:"id"`
+	UserID int         `json:"user_id"`
+	Total  money.Money `json:"total"`
+	Status string      `json:"status"`
+	Items  []OrderItem `json:"items,omitempty"`
+	Links  Links       `json:"_links"`
+}
+
+// OrderItem is one product line of an order
+type OrderItem struct {
+	ProductID int         `json:"product_id"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+}
+
+// Product is the catalog from rest-principles.go with stock levels
+type Product struct {
+	ID       int         `json:"id"`
+	Name     string      `json:"name"`
+	Category string      `json:"category"`
+	Price    money.Money `json:"price"`
+	Stock    int         `json:"stock"`
+	Links    Links       `json:"_links"`
 }
 
 // Links represents hypermedia links
@@ -32,9 +54,10 @@ type Links map[string]Link
 
 // Link represents a single hypermedia link
 type Link struct {
-	Href   string `json:"href"`
-	Method string `json:"method,omitempty"`
-	Type   string `json:"type,omitempty"`
+	Href      string `json:"href"`
+	Method    string `json:"method,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
 }
 
 // CollectionResponse represents a collection with links
@@ -56,10 +79,149 @@ var users = []User{
 	{ID: 2, Name: "Jane Smith", Email: "jane@example.com"},
 }
 
-var orders = []Order{
-	{ID: 1, UserID: 1, Total: 99.99, Status: "pending"},
-	{ID: 2, UserID: 1, Total: 149.99, Status: "completed"},
-	{ID: 3, UserID: 2, Total: 79.99, Status: "shipped"},
+// Store holds products and orders. Every read and write takes the lock, so
+// placing an order checks and decrements stock for all its items atomically.
+type Store struct {
+	mu          sync.RWMutex
+	products    []Product
+	orders      []Order
+	nextOrderID int
+}
+
+var store = &Store{
+	products: []Product{
+		{ID: 1, Name: "Laptop", Category: "Electronics", Price: money.MustParse("999.99", "USD"), Stock: 5},
+		{ID: 2, Name: "Mouse", Category: "Electronics", Price: money.MustParse("29.99", "USD"), Stock: 50},
+		{ID: 3, Name: "Keyboard", Category: "Electronics", Price: money.MustParse("49.99", "USD"), Stock: 0},
+		{ID: 4, Name: "Mechanical Keyboard", Category: "Electronics", Price: money.MustParse("89.99", "USD"), Stock: 12},
+	},
+	orders: []Order{
+		{ID: 1, UserID: 1, Total: money.MustParse("99.99", "USD"), Status: "pending"},
+		{ID: 2, UserID: 1, Total: money.MustParse("149.99", "USD"), Status: "completed"},
+		{ID: 3, UserID: 2, Total: money.MustParse("79.99", "USD"), Status: "shipped"},
+	},
+	nextOrderID: 4,
+}
+
+// Orders returns a snapshot of all orders
+func (s *Store) Orders() []Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Order(nil), s.orders...)
+}
+
+// Products returns a snapshot of the catalog
+func (s *Store) Products() []Product {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Product(nil), s.products...)
+}
+
+// StockShortage describes an item that can't be fulfilled
+type StockShortage struct {
+	ProductID int `json:"product_id"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
+}
+
+// errOrderNotFound is returned by CancelOrder for unknown orders
+var errOrderNotFound = errors.New("order not found")
+
+// OutOfStockError is returned by PlaceOrder when any item is short. No stock is
+// taken in that case.
+type OutOfStockError struct {
+	Shortages []StockShortage
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("%d item(s) out of stock", len(e.Shortages))
+}
+
+// PlaceOrder reserves stock for every item and creates a pending order, or
+// changes nothing if any item can't be fulfilled.
+func (s *Store) PlaceOrder(userID int, items []OrderItem) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate everything before touching stock
+	requested := make(map[int]int)
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return Order{}, fmt.Errorf("quantity must be positive")
+		}
+		requested[item.ProductID] += item.Quantity
+	}
+
+	var shortages []StockShortage
+	for productID, quantity := range requested {
+		product := s.findProduct(productID)
+		if product == nil {
+			return Order{}, fmt.Errorf("product %d not found", productID)
+		}
+		if product.Stock < quantity {
+			shortages = append(shortages, StockShortage{ProductID: productID, Requested: quantity, Available: product.Stock})
+		}
+	}
+	if len(shortages) > 0 {
+		sort.Slice(shortages, func(i, j int) bool { return shortages[i].ProductID < shortages[j].ProductID })
+		return Order{}, &OutOfStockError{Shortages: shortages}
+	}
+
+	// Prices are exact minor units, so the total needs no rounding
+	order := Order{ID: s.nextOrderID, UserID: userID, Status: "pending"}
+	for _, item := range items {
+		item.UnitPrice = s.findProduct(item.ProductID).Price
+		subtotal, err := item.UnitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return Order{}, err
+		}
+		if order.Total, err = order.Total.Add(subtotal); err != nil {
+			return Order{}, err
+		}
+		order.Items = append(order.Items, item)
+	}
+	for _, item := range order.Items {
+		s.findProduct(item.ProductID).Stock -= item.Quantity
+	}
+
+	s.nextOrderID++
+	s.orders = append(s.orders, order)
+	return order, nil
+}
+
+// CancelOrder cancels a pending order and returns its items to stock. It
+// returns the order's current state along with any error.
+func (s *Store) CancelOrder(orderID int) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.orders {
+		order := &s.orders[i]
+		if order.ID != orderID {
+			continue
+		}
+		if order.Status != "pending" {
+			return *order, fmt.Errorf("order cannot be cancelled")
+		}
+		for _, item := range order.Items {
+			if product := s.findProduct(item.ProductID); product != nil {
+				product.Stock += item.Quantity
+			}
+		}
+		order.Status = "cancelled"
+		return *order, nil
+	}
+	return Order{}, errOrderNotFound
+}
+
+// findProduct must be called with the lock held
+func (s *Store) findProduct(id int) *Product {
+	for i := range s.products {
+		if s.products[i].ID == id {
+			return &s.products[i]
+		}
+	}
+	return nil
 }
 
 func addUserLinks(user User, baseURL string) User {
@@ -123,6 +285,29 @@ func addOrderLinks(order Order, baseURL string) Order {
 	return order
 }
 
+func addProductLinks(product Product, baseURL string) Product {
+	product.Links = Links{
+		"self": {
+			Href:   fmt.Sprintf("%s/products/%d", baseURL, product.ID),
+			Method: "GET",
+		},
+		"products": {
+			Href:   baseURL + "/products",
+			Method: "GET",
+		},
+	}
+	// Only products in stock can be ordered
+	if product.Stock > 0 {
+		product.Links["order"] = Link{
+			Href:      baseURL + "/users/{user_id}/orders",
+			Method:    "POST",
+			Type:      "application/json",
+			Templated: true,
+		}
+	}
+	return product
+}
+
 func getBaseURL(r *http.Request) string {
 	scheme := "http"
 	if r.TLS != nil {
@@ -150,6 +335,10 @@ func apiRootHandler(w http.ResponseWriter, r *http.Request) {
 				Href:   baseURL + "/orders",
 				Method: "GET",
 			},
+			"products": {
+				Href:   baseURL + "/products",
+				Method: "GET",
+			},
 			"documentation": {
 				Href: baseURL + "/docs",
 				Type: "text/html",
@@ -260,7 +449,7 @@ func getUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get user's orders
 	userOrders := []Order{}
-	for _, order := range orders {
+	for _, order := range store.Orders() {
 		if order.UserID == userID {
 			userOrders = append(userOrders, addOrderLinks(order, baseURL))
 		}
@@ -294,6 +483,7 @@ func getUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
 // Get all orders with HATEOAS
 func getOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	baseURL := getBaseURL(r)
+	orders := store.Orders()
 	
 	ordersWithLinks := make([]Order, len(orders))
 	for i, order := range orders {
@@ -331,7 +521,7 @@ func getOrderHandler(w http.ResponseWriter, r *http.Request) {
 
 	baseURL := getBaseURL(r)
 
-	for _, order := range orders {
+	for _, order := range store.Orders() {
 		if order.ID == orderID {
 			orderWithLinks := addOrderLinks(order, baseURL)
 			w.Header().Set("Content-Type", "application/json")
@@ -362,34 +552,196 @@ func cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
 
 	baseURL := getBaseURL(r)
 
-	for i, order := range orders {
-		if order.ID == orderID {
-			if order.Status != "pending" {
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"error": "Order cannot be cancelled",
-					"current_status": order.Status,
-					"_links": Links{
-						"order": {
-							Href: fmt.Sprintf("%s/orders/%d", baseURL, orderID),
-						},
-					},
-				})
-				return
-			}
-			
-			orders[i].Status = "cancelled"
-			updatedOrder := addOrderLinks(orders[i], baseURL)
-			
+	// Cancelling returns the order's items to stock
+	order, err := store.CancelOrder(orderID)
+	if err != nil {
+		if errors.Is(err, errOrderNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Order not found",
+			})
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Order cannot be cancelled",
+			"current_status": order.Status,
+			"_links": Links{
+				"order": {
+					Href: fmt.Sprintf("%s/orders/%d", baseURL, orderID),
+				},
+			},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addOrderLinks(order, baseURL))
+}
+
+// Get the product catalog with stock levels
+func getProductsHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL := getBaseURL(r)
+	products := store.Products()
+
+	productsWithLinks := make([]Product, len(products))
+	for i, product := range products {
+		productsWithLinks[i] = addProductLinks(product, baseURL)
+	}
+
+	response := CollectionResponse{
+		Data: productsWithLinks,
+		Links: Links{
+			"self": {
+				Href: baseURL + "/products",
+			},
+		},
+		Meta: Meta{
+			Total: len(products),
+			Count: len(products),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func getProductHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Invalid product ID",
+		})
+		return
+	}
+
+	baseURL := getBaseURL(r)
+
+	for _, product := range store.Products() {
+		if product.ID == productID {
 			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(updatedOrder)
+			json.NewEncoder(w).Encode(addProductLinks(product, baseURL))
 			return
 		}
 	}
 
 	w.WriteHeader(http.StatusNotFound)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": "Order not found",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "Product not found",
+		"_links": Links{
+			"products": {
+				Href: baseURL + "/products",
+			},
+		},
+	})
+}
+
+// CreateOrderRequest is the body of POST /users/{id}/orders
+type CreateOrderRequest struct {
+	Items []OrderItem `json:"items"`
+}
+
+// Place an order: stock for every item is reserved together or not at all
+func createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	baseURL := getBaseURL(r)
+
+	userExists := false
+	for _, user := range users {
+		if user.ID == userID {
+			userExists = true
+			break
+		}
+	}
+	if !userExists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "User not found",
+		})
+		return
+	}
+
+	var req CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Items) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Request must contain at least one item",
+		})
+		return
+	}
+
+	order, err := store.PlaceOrder(userID, req.Items)
+	if err != nil {
+		var outOfStock *OutOfStockError
+		if errors.As(err, &outOfStock) {
+			writeOutOfStock(w, outOfStock, baseURL)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	order = addOrderLinks(order, baseURL)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", order.Links["self"].Href)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
+}
+
+// writeOutOfStock answers 409 with what is short and hypermedia to recover:
+// in-stock products from the same categories and the catalog.
+func writeOutOfStock(w http.ResponseWriter, err *OutOfStockError, baseURL string) {
+	short := make(map[int]bool)
+	categories := make(map[string]bool)
+	products := store.Products()
+	for _, shortage := range err.Shortages {
+		short[shortage.ProductID] = true
+	}
+	for _, product := range products {
+		if short[product.ID] {
+			categories[product.Category] = true
+		}
+	}
+
+	alternatives := []Product{}
+	for _, product := range products {
+		if !short[product.ID] && categories[product.Category] && product.Stock > 0 {
+			alternatives = append(alternatives, addProductLinks(product, baseURL))
+		}
+	}
+
+	links := Links{
+		"products": {
+			Href:   baseURL + "/products",
+			Method: "GET",
+		},
+	}
+	for _, shortage := range err.Shortages {
+		links[fmt.Sprintf("product-%d", shortage.ProductID)] = Link{
+			Href:   fmt.Sprintf("%s/products/%d", baseURL, shortage.ProductID),
+			Method: "GET",
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":        "Insufficient stock",
+		"shortages":    err.Shortages,
+		"alternatives": alternatives,
+		"_links":       links,
 	})
 }
 
@@ -412,6 +764,7 @@ func docsHandler(w http.ResponseWriter, r *http.Request) {
 			"browse_users": "GET " + baseURL + "/users",
 			"user_orders": "GET " + baseURL + "/users/1/orders",
 			"order_details": "GET " + baseURL + "/orders/1",
+			"place_order": "POST " + baseURL + "/users/1/orders",
 		},
 		"hypermedia_features": map[string]interface{}{
 			"navigation": "Follow _links to navigate the API",
@@ -432,9 +785,12 @@ func main() {
 	router.HandleFunc("/users", getUsersHandler).Methods("GET")
 	router.HandleFunc("/users/{id}", getUserHandler).Methods("GET")
 	router.HandleFunc("/users/{id}/orders", getUserOrdersHandler).Methods("GET")
+	router.HandleFunc("/users/{id}/orders", createOrderHandler).Methods("POST")
 	router.HandleFunc("/orders", getOrdersHandler).Methods("GET")
 	router.HandleFunc("/orders/{id}", getOrderHandler).Methods("GET")
 	router.HandleFunc("/orders/{id}/cancel", cancelOrderHandler).Methods("POST")
+	router.HandleFunc("/products", getProductsHandler).Methods("GET")
+	router.HandleFunc("/products/{id}", getProductHandler).Methods("GET")
 	router.HandleFunc("/docs", docsHandler).Methods("GET")
 
 	fmt.Println("HATEOAS API Demo Server")
@@ -448,6 +804,9 @@ func main() {
 	fmt.Println("3. Follow 'self' link for a specific user")
 	fmt.Println("4. Follow 'orders' link to see user's orders")
 	fmt.Println("5. Try cancelling a pending order")
+	fmt.Println("6. Order a product and watch its stock drop:")
+	fmt.Println(`   curl -X POST http://localhost:8081/users/1/orders -d '{"items":[{"product_id":1,"quantity":2}]}'`)
+	fmt.Println("7. Order an out-of-stock product (id 3) to see the 409 with alternatives")
 
 	log.Fatal(http.ListenAndServe(":8081", router))
 }
\ No newline at end of file
@@ -2,25 +2,38 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/big"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"cachecontrol"
 	"github.com/gorilla/mux"
+	"httpcond"
+	"logging"
+	"money"
 )
 
 // Product represents a product in our catalog
 type Product struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Category    string    `json:"category"`
-	InStock     bool      `json:"in_stock"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	Category    string      `json:"category"`
+	InStock     bool        `json:"in_stock"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+
+	// Prices holds explicit prices in other currencies; they take precedence
+	// over converting Price
+	Prices []money.Money `json:"prices,omitempty"`
 }
 
 // Sample data
@@ -29,7 +42,8 @@ var products = []Product{
 		ID:          1,
 		Name:        "Laptop",
 		Description: "High-performance laptop",
-		Price:       999.99,
+		Price:       money.MustParse("999.99", baseCurrency),
+		Prices:      []money.Money{money.MustParse("949.00", "EUR")},
 		Category:    "Electronics",
 		InStock:     true,
 		CreatedAt:   time.Now().Add(-24 * time.Hour),
@@ -39,7 +53,7 @@ var products = []Product{
 		ID:          2,
 		Name:        "Mouse",
 		Description: "Wireless mouse",
-		Price:       29.99,
+		Price:       money.MustParse("29.99", baseCurrency),
 		Category:    "Electronics",
 		InStock:     true,
 		CreatedAt:   time.Now().Add(-48 * time.Hour),
@@ -47,6 +61,99 @@ var products = []Product{
 	},
 }
 
+// Prices are stored in the base currency and converted at read time. They
+// are money.Money values (integer minor units with a currency), never
+// float64, so converting and comparing them doesn't drift by a cent.
+const baseCurrency = "USD"
+
+// ExchangeRateProvider supplies conversion rates between currencies.
+type ExchangeRateProvider interface {
+	Rate(from, to string) (*big.Rat, error)
+}
+
+// StaticExchangeRates holds rates from the base currency as exact decimals.
+// A real service would refresh these from a rates API.
+type StaticExchangeRates map[string]string
+
+func (s StaticExchangeRates) Rate(from, to string) (*big.Rat, error) {
+	fromRate, err := s.rate(from)
+	if err != nil {
+		return nil, err
+	}
+	toRate, err := s.rate(to)
+	if err != nil {
+		return nil, err
+	}
+	return toRate.Quo(toRate, fromRate), nil
+}
+
+func (s StaticExchangeRates) rate(currency string) (*big.Rat, error) {
+	value, ok := s[currency]
+	if !ok {
+		return nil, fmt.Errorf("no exchange rate for %s", currency)
+	}
+	return money.ParseRate(value)
+}
+
+var exchangeRates ExchangeRateProvider = StaticExchangeRates{
+	"USD": "1",
+	"EUR": "0.92",
+	"GBP": "0.79",
+	"CHF": "0.88",
+	"JPY": "151.3",
+}
+
+// requestedCurrency reads ?currency=, then the Accept-Currency header,
+// defaulting to the base currency
+func requestedCurrency(r *http.Request) (string, error) {
+	currency := r.URL.Query().Get("currency")
+	if currency == "" {
+		currency = r.Header.Get("Accept-Currency")
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return baseCurrency, nil
+	}
+	if !money.Supported(currency) {
+		return "", fmt.Errorf("unsupported currency %q", currency)
+	}
+	return currency, nil
+}
+
+// inCurrency returns a copy of the product priced in the given currency. An
+// explicit price wins over conversion.
+func inCurrency(product Product, currency string) (Product, error) {
+	if currency == product.Price.Currency() {
+		return product, nil
+	}
+	for _, price := range product.Prices {
+		if price.Currency() == currency {
+			product.Price = price
+			return product, nil
+		}
+	}
+
+	rate, err := exchangeRates.Rate(product.Price.Currency(), currency)
+	if err != nil {
+		return Product{}, err
+	}
+	price, err := product.Price.Convert(currency, rate)
+	if err != nil {
+		return Product{}, err
+	}
+	product.Price = price
+	return product, nil
+}
+
+func writeCurrencyError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":                err.Error(),
+		"supported_currencies": money.Currencies(),
+	})
+}
+
 // Demonstration of REST Principle 1: Client-Server Architecture
 // Server manages data and business logic, client handles presentation
 
@@ -72,68 +179,268 @@ func principlesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(principles)
 }
 
-// Demonstration of REST Principle 2: Statelessness
-// Each request contains all information needed to process it
-func getProductsHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters for filtering (all state in request)
-	category := r.URL.Query().Get("category")
-	inStockParam := r.URL.Query().Get("in_stock")
-	priceMinParam := r.URL.Query().Get("price_min")
-	priceMaxParam := r.URL.Query().Get("price_max")
+// ProductQuery is everything a product listing request can ask for. All of it
+// comes from the request itself (statelessness).
+type ProductQuery struct {
+	Category string
+	InStock  *bool
+	PriceMin *money.Money
+	PriceMax *money.Money
+	Currency string
+	Sort     []SortField
+	Page     int
+	PerPage  int
+}
 
-	var filteredProducts []Product
+// SortField is one key of ?sort=, e.g. "-price" sorts by price descending
+type SortField struct {
+	Field      string
+	Descending bool
+}
 
-	for _, product := range products {
-		// Apply filters based on request parameters
-		if category != "" && product.Category != category {
-			continue
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// productSorters compare two products by a sortable field
+var productSorters = map[string]func(a, b Product) int{
+	"id":         func(a, b Product) int { return a.ID - b.ID },
+	"name":       func(a, b Product) int { return strings.Compare(a.Name, b.Name) },
+	"price":      func(a, b Product) int { return compareMinor(a.Price, b.Price) },
+	"created_at": func(a, b Product) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"updated_at": func(a, b Product) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+}
+
+// compareMinor orders prices, which searchProducts has already converted to
+// one currency
+func compareMinor(a, b money.Money) int {
+	switch {
+	case a.Minor() < b.Minor():
+		return -1
+	case a.Minor() > b.Minor():
+		return 1
+	}
+	return 0
+}
+
+// parseProductQuery validates the listing parameters once, up front
+func parseProductQuery(r *http.Request, currency string) (ProductQuery, error) {
+	params := r.URL.Query()
+	query := ProductQuery{
+		Category: params.Get("category"),
+		Currency: currency,
+		Page:     1,
+		PerPage:  defaultPerPage,
+	}
+
+	if value := params.Get("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("in_stock must be true or false")
 		}
+		query.InStock = &inStock
+	}
 
-		if inStockParam != "" {
-			inStock, _ := strconv.ParseBool(inStockParam)
-			if product.InStock != inStock {
-				continue
+	// Price filters are in the requested currency, with at most its decimals
+	for name, target := range map[string]**money.Money{"price_min": &query.PriceMin, "price_max": &query.PriceMax} {
+		if value := params.Get(name); value != "" {
+			price, err := money.Parse(value, currency)
+			if err != nil {
+				return query, fmt.Errorf("%s: %v", name, err)
 			}
+			*target = &price
 		}
+	}
 
-		if priceMinParam != "" {
-			priceMin, _ := strconv.ParseFloat(priceMinParam, 64)
-			if product.Price < priceMin {
-				continue
+	if value := params.Get("sort"); value != "" {
+		for _, key := range strings.Split(value, ",") {
+			field := SortField{Field: strings.TrimSpace(key)}
+			if strings.HasPrefix(field.Field, "-") {
+				field.Field, field.Descending = field.Field[1:], true
 			}
+			if _, ok := productSorters[field.Field]; !ok {
+				return query, fmt.Errorf("cannot sort by %q", field.Field)
+			}
+			query.Sort = append(query.Sort, field)
+		}
+	}
+
+	if value := params.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return query, fmt.Errorf("page must be a positive integer")
+		}
+		query.Page = page
+	}
+	if value := params.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return query, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		query.PerPage = perPage
+	}
+
+	return query, nil
+}
+
+// ProductFacets count the products available under each filter value
+type ProductFacets struct {
+	Category map[string]int `json:"category"`
+	InStock  map[string]int `json:"in_stock"`
+}
+
+// searchProducts filters, counts facets and sorts in one pass over the
+// catalog. Each facet ignores its own filter, so clients see how many results
+// picking another value would give.
+func searchProducts(query ProductQuery) ([]Product, ProductFacets, error) {
+	facets := ProductFacets{
+		Category: make(map[string]int),
+		InStock:  map[string]int{"true": 0, "false": 0},
+	}
+	results := []Product{}
+
+	for _, product := range products {
+		product, err := inCurrency(product, query.Currency)
+		if err != nil {
+			return nil, facets, err
+		}
+
+		matchesPrice := (query.PriceMin == nil || compareMinor(product.Price, *query.PriceMin) >= 0) &&
+			(query.PriceMax == nil || compareMinor(product.Price, *query.PriceMax) <= 0)
+		matchesCategory := query.Category == "" || product.Category == query.Category
+		matchesStock := query.InStock == nil || product.InStock == *query.InStock
+
+		if !matchesPrice {
+			continue
+		}
+		if matchesStock {
+			facets.Category[product.Category]++
+		}
+		if matchesCategory {
+			facets.InStock[strconv.FormatBool(product.InStock)]++
 		}
+		if matchesCategory && matchesStock {
+			results = append(results, product)
+		}
+	}
 
-		if priceMaxParam != "" {
-			priceMax, _ := strconv.ParseFloat(priceMaxParam, 64)
-			if product.Price > priceMax {
-				continue
+	sortFields := query.Sort
+	if len(sortFields) == 0 {
+		sortFields = []SortField{{Field: "id"}}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		for _, field := range sortFields {
+			c := productSorters[field.Field](results[i], results[j])
+			if field.Descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
 			}
 		}
+		return false
+	})
 
-		filteredProducts = append(filteredProducts, product)
+	return results, facets, nil
+}
+
+// pageLinks builds RFC 8288 Link header values for the listing's pages
+func pageLinks(r *http.Request, page, totalPages int) []string {
+	link := func(rel string, target int) string {
+		params := r.URL.Query()
+		params.Set("page", strconv.Itoa(target))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, params.Encode(), rel)
 	}
 
-	// Demonstration of REST Principle 3: Cacheability
-	// Set cache headers to indicate this response can be cached
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=300") // Cache for 5 minutes
-	w.Header().Set("ETag", generateETag(filteredProducts))
-	w.Header().Set("Last-Modified", time.Now().Format(http.TimeFormat))
+	links := []string{link("first", 1), link("last", totalPages)}
+	if page > 1 {
+		links = append(links, link("prev", page-1))
+	}
+	if page < totalPages {
+		links = append(links, link("next", page+1))
+	}
+	return links
+}
+
+// Demonstration of REST Principle 2: Statelessness
+// Each request contains all information needed to process it
+func getProductsHandler(w http.ResponseWriter, r *http.Request) {
+	currency, err := requestedCurrency(r)
+	if err != nil {
+		writeCurrencyError(w, err)
+		return
+	}
+
+	// Parse query parameters for filtering, sorting and paging (all state in request)
+	query, err := parseProductQuery(r, currency)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	results, facets, err := searchProducts(query)
+	if err != nil {
+		writeCurrencyError(w, err)
+		return
+	}
+
+	total := len(results)
+	totalPages := (total + query.PerPage - 1) / query.PerPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	start := min((query.Page-1)*query.PerPage, total)
+	end := min(start+query.PerPage, total)
+	page := results[start:end]
 
 	// Return filtered results
 	response := map[string]interface{}{
-		"products": filteredProducts,
-		"count":    len(filteredProducts),
+		"products": page,
+		"count":    len(page),
+		"pagination": map[string]interface{}{
+			"page":        query.Page,
+			"per_page":    query.PerPage,
+			"total":       total,
+			"total_pages": totalPages,
+		},
+		"facets": facets,
 		"filters_applied": map[string]interface{}{
-			"category":  category,
-			"in_stock":  inStockParam,
-			"price_min": priceMinParam,
-			"price_max": priceMaxParam,
+			"category":  query.Category,
+			"in_stock":  r.URL.Query().Get("in_stock"),
+			"price_min": r.URL.Query().Get("price_min"),
+			"price_max": r.URL.Query().Get("price_max"),
+			"currency":  query.Currency,
+			"sort":      r.URL.Query().Get("sort"),
 		},
 		"demonstration": "This response demonstrates statelessness - all filtering logic is based on request parameters",
 	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	json.NewEncoder(w).Encode(response)
+	// Demonstration of REST Principle 3: Cacheability
+	// Cache-Control comes from the route's policy (productListCache)
+	w.Header().Set("Content-Currency", query.Currency)
+	w.Header().Set("Vary", "Accept-Currency")
+	for _, link := range pageLinks(r, query.Page, totalPages) {
+		w.Header().Add("Link", link)
+	}
+
+	// The ETag is computed from the exact bytes sent, so it is a strong validator
+	validators := httpcond.Validators{ETag: httpcond.ForContent(body), LastModified: latestUpdate(page)}
+	if !httpcond.Check(w, r, validators) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
 }
 
 // Demonstration of conditional requests and caching
@@ -165,39 +472,41 @@ func getProductHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate ETag based on product data and last modified time
-	etag := fmt.Sprintf(`"product-%d-%d"`, product.ID, product.UpdatedAt.Unix())
-	lastModified := product.UpdatedAt.Format(http.TimeFormat)
-
-	// Check If-None-Match header (ETag-based conditional request)
-	if r.Header.Get("If-None-Match") == etag {
-		w.WriteHeader(http.StatusNotModified)
+	currency, err := requestedCurrency(r)
+	if err != nil {
+		writeCurrencyError(w, err)
 		return
 	}
-
-	// Check If-Modified-Since header (time-based conditional request)
-	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
-		if t, err := time.Parse(http.TimeFormat, ifModifiedSince); err == nil {
-			if product.UpdatedAt.Before(t) || product.UpdatedAt.Equal(t) {
-				w.WriteHeader(http.StatusNotModified)
-				return
-			}
-		}
+	priced, err := inCurrency(*product, currency)
+	if err != nil {
+		writeCurrencyError(w, err)
+		return
 	}
+	product = &priced
+
+	// Generate ETag from the product in the requested currency; each
+	// representation of the product needs its own validator
+	etag := generateETag(product)
+	lastModified := product.UpdatedAt.UTC().Format(http.TimeFormat)
 
-	// Set cache headers
+	// Set cache headers; Cache-Control comes from the route's policy
+	w.Header().Set("Content-Currency", currency)
+	w.Header().Set("Vary", "Accept-Currency")
+
+	// Evaluate If-None-Match and If-Modified-Since (conditional requests)
+	if !httpcond.Check(w, r, httpcond.Validators{ETag: etag, LastModified: product.UpdatedAt}) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=600") // Cache for 10 minutes
-	w.Header().Set("ETag", etag)
-	w.Header().Set("Last-Modified", lastModified)
 
 	// Return product with caching demonstration info
 	response := map[string]interface{}{
 		"product": product,
 		"cache_info": map[string]interface{}{
-			"etag":          etag,
+			"etag":          etag.String(),
 			"last_modified": lastModified,
-			"cache_control": "public, max-age=600",
+			"cache_control": productCache.String(),
+			"currency":      currency,
 			"demonstration": "This response includes proper cache headers for client-side caching",
 		},
 	}
@@ -209,15 +518,23 @@ func getProductHandler(w http.ResponseWriter, r *http.Request) {
 func createProductHandler(w http.ResponseWriter, r *http.Request) {
 	var newProduct Product
 	if err := json.NewDecoder(r.Body).Decode(&newProduct); err != nil {
+		if errors.Is(err, money.ErrUnsupportedCurrency) {
+			writeCurrencyError(w, err)
+			return
+		}
+		message := "Invalid JSON payload"
+		if errors.Is(err, money.ErrInvalidAmount) || errors.Is(err, money.ErrOverflow) {
+			message = err.Error()
+		}
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid JSON payload",
+			"error": message,
 		})
 		return
 	}
 
 	// Validate required fields (business logic on server)
-	if newProduct.Name == "" || newProduct.Price <= 0 {
+	if newProduct.Name == "" || !newProduct.Price.IsPositive() {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": "Name and positive price are required",
@@ -225,6 +542,16 @@ func createProductHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Store prices in the base currency so conversions start from one place
+	if newProduct.Price.Currency() != baseCurrency {
+		base, err := inCurrency(newProduct, baseCurrency)
+		if err != nil {
+			writeCurrencyError(w, err)
+			return
+		}
+		newProduct.Price = base.Price
+	}
+
 	// Set server-managed fields
 	newProduct.ID = len(products) + 1
 	newProduct.CreatedAt = time.Now()
@@ -247,19 +574,13 @@ func createProductHandler(w http.ResponseWriter, r *http.Request) {
 
 // Demonstration of layered system
 func loggingMiddleware(next http.Handler) http.Handler {
+	// One JSON line per request with its method, path, status and latency
+	logged := logging.Middleware(slog.Default())(next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		
 		// Add layer identifier header
 		w.Header().Set("X-Layer", "Logging-Middleware")
 		
-		next.ServeHTTP(w, r)
-		
-		log.Printf("[%s] %s %s - %v", 
-			time.Now().Format("2006-01-02 15:04:05"),
-			r.Method, 
-			r.URL.Path, 
-			time.Since(start))
+		logged.ServeHTTP(w, r)
 	})
 }
 
@@ -268,7 +589,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 		// Add CORS headers (another layer)
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept-Currency, If-None-Match, If-Modified-Since")
 		w.Header().Set("X-Layer", "CORS-Middleware")
 		
 		if r.Method == "OPTIONS" {
@@ -280,19 +601,22 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Utility function to generate ETag
-func generateETag(products []Product) string {
-	if len(products) == 0 {
-		return `"empty"`
-	}
-	
-	// Simple ETag generation based on product count and last update
-	hash := len(products)
-	for _, p := range products {
-		hash += int(p.UpdatedAt.Unix())
+// Utility function to generate ETag from a JSON representation. Every byte
+// and its position count, so reordered or edited products get a new tag.
+func generateETag(representation interface{}) httpcond.ETag {
+	body, _ := json.Marshal(representation)
+	return httpcond.ForContent(body)
+}
+
+// latestUpdate is the Last-Modified time of a list of products
+func latestUpdate(products []Product) time.Time {
+	var latest time.Time
+	for _, product := range products {
+		if product.UpdatedAt.After(latest) {
+			latest = product.UpdatedAt
+		}
 	}
-	
-	return fmt.Sprintf(`"products-%d"`, hash)
+	return latest
 }
 
 // Demonstration endpoint showing all principles
@@ -310,7 +634,7 @@ func allPrinciplesHandler(w http.ResponseWriter, r *http.Request) {
 		},
 		"response_headers": map[string]string{
 			"content_type": "application/json",
-			"cache_control": "public, max-age=60",
+			"cache_control": demoCache.String(),
 			"x_layer": "Multiple middleware layers processed this request",
 		},
 		"principles_demonstrated": map[string]interface{}{
@@ -325,15 +649,24 @@ func allPrinciplesHandler(w http.ResponseWriter, r *http.Request) {
 			"GET /products/1 - see caching headers",
 			"POST /products - see uniform interface",
 			"GET /products?category=Electronics - see stateless parameters",
+			"GET /products/1?currency=EUR - see prices converted at read time",
 		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=60")
 	json.NewEncoder(w).Encode(demo)
 }
 
+// Cache policies, declared once and applied per route in main. Shared caches
+// may serve a stale product list for a minute while they fetch a fresh one.
+var (
+	productListCache = cachecontrol.Public(5 * time.Minute).StaleWhileRevalidate(time.Minute)
+	productCache     = cachecontrol.Public(10 * time.Minute).StaleWhileRevalidate(time.Minute)
+	demoCache        = cachecontrol.Public(time.Minute)
+)
+
 func main() {
+	slog.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
 	router := mux.NewRouter()
 
 	// Apply middleware layers (demonstrating layered system)
@@ -342,10 +675,10 @@ func main() {
 
 	// REST principles demonstration endpoints
 	router.HandleFunc("/", principlesHandler).Methods("GET")
-	router.HandleFunc("/demo", allPrinciplesHandler).Methods("GET")
-	router.HandleFunc("/products", getProductsHandler).Methods("GET")
+	router.HandleFunc("/demo", demoCache.Wrap(allPrinciplesHandler)).Methods("GET")
+	router.HandleFunc("/products", productListCache.Wrap(getProductsHandler)).Methods("GET")
 	router.HandleFunc("/products", createProductHandler).Methods("POST")
-	router.HandleFunc("/products/{id}", getProductHandler).Methods("GET")
+	router.HandleFunc("/products/{id}", productCache.Wrap(getProductHandler)).Methods("GET")
 
 	fmt.Println("REST Principles Demonstration Server")
 	fmt.Println("===================================")
@@ -360,7 +693,11 @@ func main() {
 	fmt.Println("curl http://localhost:8082/products")
 	fmt.Println("curl http://localhost:8082/products?category=Electronics")
 	fmt.Println("curl -I http://localhost:8082/products/1")
-	fmt.Println(`curl -X POST http://localhost:8082/products -d '{"name":"Keyboard","price":49.99,"category":"Electronics"}' -H "Content-Type: application/json"`)
+	fmt.Println("curl http://localhost:8082/products?currency=JPY")
+	fmt.Println(`curl -X POST http://localhost:8082/products -d '{"name":"Keyboard","price":{"amount":"49.99","currency":"USD"},"category":"Electronics"}' -H "Content-Type: application/json"`)
 
-	log.Fatal(http.ListenAndServe(":8082", router))
+	if err := http.ListenAndServe(":8082", router); err != nil {
+		slog.Error("server failed to start", "error", err)
+		os.Exit(1)
+	}
 }
\ No newline at end of file
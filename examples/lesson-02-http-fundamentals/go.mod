@@ -3,5 +3,12 @@ module lesson-02-http-fundamentals
 go 1.21
 
 require (
+	cachecontrol v0.0.0
 	github.com/gorilla/mux v1.8.1
-)
\ No newline at end of file
+	httpcond v0.0.0
+)
+
+replace (
+	cachecontrol => ../pkg/cachecontrol
+	httpcond => ../pkg/httpcond
+)
@@ -8,7 +8,9 @@ import (
 	"strconv"
 	"time"
 
+	"cachecontrol"
 	"github.com/gorilla/mux"
+	"httpcond"
 )
 
 // Book represents a book resource
@@ -47,12 +49,41 @@ var books = []Book{
 
 var nextID = 3
 
+// bookValidators derive the ETag from the book's JSON, so any change to the
+// book, even within the same second, gives it a new strong ETag
+func bookValidators(book Book) httpcond.Validators {
+	body, _ := json.Marshal(book)
+	return httpcond.Validators{ETag: httpcond.ForContent(body), LastModified: book.UpdatedAt}
+}
+
+// checkPreconditions evaluates conditional headers against the current book.
+// Writes with a stale If-Match are rejected with 412 instead of overwriting
+// changes the client hasn't seen (the "lost update" problem).
+func checkPreconditions(w http.ResponseWriter, r *http.Request, book Book) bool {
+	validators := bookValidators(book)
+	validators.SetHeaders(w.Header())
+
+	switch httpcond.Evaluate(r, validators) {
+	case httpcond.NotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	case httpcond.PreconditionFailed:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Book has been modified; fetch it again and retry with the new ETag",
+			"etag":  validators.ETag.String(),
+		})
+		return false
+	}
+	return true
+}
+
 // GET - Retrieve resources (Safe, Idempotent)
 func getBooksHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("[GET] %s - Safe: Yes, Idempotent: Yes\n", r.URL.Path)
 	
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=300")
 	
 	response := map[string]interface{}{
 		"books": books,
@@ -83,10 +114,11 @@ func getBookHandler(w http.ResponseWriter, r *http.Request) {
 
 	for _, book := range books {
 		if book.ID == id {
+			if !checkPreconditions(w, r, book) {
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Cache-Control", "public, max-age=600")
-			w.Header().Set("ETag", fmt.Sprintf(`"book-%d-%d"`, book.ID, book.UpdatedAt.Unix()))
-			
+
 			response := map[string]interface{}{
 				"book": book,
 				"meta": map[string]interface{}{
@@ -137,6 +169,7 @@ func createBookHandler(w http.ResponseWriter, r *http.Request) {
 
 	books = append(books, book)
 
+	bookValidators(book).SetHeaders(w.Header())
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Location", fmt.Sprintf("/books/%d", book.ID))
 	w.WriteHeader(http.StatusCreated)
@@ -189,6 +222,10 @@ func updateBookHandler(w http.ResponseWriter, r *http.Request) {
 	// Find and replace the book
 	for i, book := range books {
 		if book.ID == id {
+			if !checkPreconditions(w, r, book) {
+				return
+			}
+
 			// Preserve server-managed fields
 			updatedBook.ID = id
 			updatedBook.CreatedAt = book.CreatedAt
@@ -196,6 +233,7 @@ func updateBookHandler(w http.ResponseWriter, r *http.Request) {
 			
 			books[i] = updatedBook
 
+			bookValidators(updatedBook).SetHeaders(w.Header())
 			w.Header().Set("Content-Type", "application/json")
 			
 			response := map[string]interface{}{
@@ -245,6 +283,10 @@ func patchBookHandler(w http.ResponseWriter, r *http.Request) {
 	// Find and partially update the book
 	for i, book := range books {
 		if book.ID == id {
+			if !checkPreconditions(w, r, book) {
+				return
+			}
+
 			// Apply partial updates
 			if title, ok := patch["title"].(string); ok {
 				book.Title = title
@@ -262,6 +304,7 @@ func patchBookHandler(w http.ResponseWriter, r *http.Request) {
 			book.UpdatedAt = time.Now()
 			books[i] = book
 
+			bookValidators(book).SetHeaders(w.Header())
 			w.Header().Set("Content-Type", "application/json")
 			
 			response := map[string]interface{}{
@@ -302,6 +345,10 @@ func deleteBookHandler(w http.ResponseWriter, r *http.Request) {
 
 	for i, book := range books {
 		if book.ID == id {
+			if !checkPreconditions(w, r, book) {
+				return
+			}
+
 			// Remove the book
 			books = append(books[:i], books[i+1:]...)
 			
@@ -347,10 +394,11 @@ func headBookHandler(w http.ResponseWriter, r *http.Request) {
 
 	for _, book := range books {
 		if book.ID == id {
+			if !checkPreconditions(w, r, book) {
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.Header().Set("Content-Length", "0")
-			w.Header().Set("ETag", fmt.Sprintf(`"book-%d-%d"`, book.ID, book.UpdatedAt.Unix()))
-			w.Header().Set("Last-Modified", book.UpdatedAt.Format(http.TimeFormat))
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -424,6 +472,7 @@ func methodsInfoHandler(w http.ResponseWriter, r *http.Request) {
 			`curl -X PATCH http://localhost:8083/books/1 -d '{"title":"Patched Title"}' -H "Content-Type: application/json"`,
 			`curl -X DELETE http://localhost:8083/books/1`,
 			`curl -I http://localhost:8083/books/2`,
+			`curl -X PUT http://localhost:8083/books/2 -H 'If-Match: "<etag from GET>"' -d '{"title":"Safe Update","author":"Author"}' -H "Content-Type: application/json"`,
 			`curl -X OPTIONS http://localhost:8083/books`,
 		},
 	}
@@ -440,6 +489,13 @@ func getUpdatedFields(patch map[string]interface{}) []string {
 	return fields
 }
 
+// Cache policies for the safe methods, applied per route in main. Only
+// successful responses (and 304s) get them, so a 404 is not cached.
+var (
+	bookListCache = cachecontrol.Public(5 * time.Minute)
+	bookCache     = cachecontrol.Public(10 * time.Minute)
+)
+
 func main() {
 	router := mux.NewRouter()
 
@@ -447,15 +503,15 @@ func main() {
 	router.HandleFunc("/", methodsInfoHandler).Methods("GET")
 
 	// Book endpoints demonstrating different HTTP methods
-	router.HandleFunc("/books", getBooksHandler).Methods("GET")
+	router.HandleFunc("/books", bookListCache.Wrap(getBooksHandler)).Methods("GET")
 	router.HandleFunc("/books", createBookHandler).Methods("POST")
 	router.HandleFunc("/books", optionsBookHandler).Methods("OPTIONS")
 	
-	router.HandleFunc("/books/{id}", getBookHandler).Methods("GET")
+	router.HandleFunc("/books/{id}", bookCache.Wrap(getBookHandler)).Methods("GET")
 	router.HandleFunc("/books/{id}", updateBookHandler).Methods("PUT")
 	router.HandleFunc("/books/{id}", patchBookHandler).Methods("PATCH")
 	router.HandleFunc("/books/{id}", deleteBookHandler).Methods("DELETE")
-	router.HandleFunc("/books/{id}", headBookHandler).Methods("HEAD")
+	router.HandleFunc("/books/{id}", bookCache.Wrap(headBookHandler)).Methods("HEAD")
 	router.HandleFunc("/books/{id}", optionsBookHandler).Methods("OPTIONS")
 
 	fmt.Println("HTTP Methods Demonstration Server")
@@ -6,10 +6,10 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"httpcond"
 )
 
 // ErrorResponse represents a structured error response
@@ -133,21 +133,16 @@ func test302Handler(w http.ResponseWriter, r *http.Request) {
 func test304Handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("[304] Not Modified - Resource unchanged\n")
 	
-	// Check If-None-Match header
-	ifNoneMatch := r.Header.Get("If-None-Match")
-	currentETag := `"resource-123-unchanged"`
-	
-	w.Header().Set("ETag", currentETag)
-	
-	if ifNoneMatch == currentETag {
-		w.WriteHeader(http.StatusNotModified)
+	// Check If-None-Match header; it may list several tags, or W/ weak ones
+	currentETag := httpcond.Strong("resource-123-unchanged")
+	if !httpcond.Check(w, r, httpcond.Validators{ETag: currentETag}) {
 		return
 	}
 	
 	// If no matching ETag, return 200 with content
 	respondWithSuccess(w, http.StatusOK, "Resource content", map[string]string{
 		"data": "Resource content here",
-		"etag": currentETag,
+		"etag": currentETag.String(),
 	})
 }
 
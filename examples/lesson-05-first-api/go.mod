@@ -7,10 +7,19 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
 	github.com/stretchr/testify v1.8.4
+	correlation v0.0.0
+	fieldcase v0.0.0
+	logging v0.0.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-)
\ No newline at end of file
+)
+
+replace (
+	correlation => ../pkg/correlation
+	fieldcase => ../pkg/fieldcase
+	logging => ../pkg/logging
+)
@@ -3,7 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
@@ -12,6 +12,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+
+	"correlation"
+	"fieldcase"
+	"logging"
 )
 
 // Task represents a task in our system
@@ -453,7 +457,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Field-Case")
 		
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -464,24 +468,19 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Logging middleware
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		
-		next.ServeHTTP(w, r)
-		
-		log.Printf("[%s] %s %s - %v",
-			time.Now().Format("2006-01-02 15:04:05"),
-			r.Method,
-			r.URL.Path,
-			time.Since(start))
-	})
-}
-
 var startTime = time.Now()
 
 func main() {
+	// Log JSON lines; LOG_LEVEL (debug, info, warn or error) sets the least
+	// severe level written
+	logLevel := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if level, err := logging.ParseLevel(value); err == nil {
+			logLevel = level
+		}
+	}
+	slog.SetDefault(logging.New(os.Stdout, logLevel))
+	
 	// Initialize storage
 	storage := &MemoryStorage{}
 	
@@ -517,7 +516,9 @@ func main() {
 	
 	// Apply middleware
 	router.Use(corsMiddleware)
-	router.Use(loggingMiddleware)
+	// One log line per request with its method, path, status, latency and
+	// request ID
+	router.Use(correlation.Middleware, logging.Middleware(slog.Default()))
 	
 	// Health and info endpoints
 	router.HandleFunc("/health", healthCheckHandler).Methods("GET")
@@ -526,6 +527,16 @@ func main() {
 	// API routes
 	api := router.PathPrefix("/api").Subrouter()
 	
+	// Structs are tagged snake_case; clients sending X-Field-Case: camel get
+	// the same fields in camelCase, and FIELD_CASE changes the default
+	fieldCase := fieldcase.Snake
+	if value := os.Getenv("FIELD_CASE"); value != "" {
+		if c, err := fieldcase.Parse(value); err == nil {
+			fieldCase = c
+		}
+	}
+	api.Use(fieldcase.Middleware(fieldcase.Snake, fieldCase))
+	
 	// Task routes
 	api.HandleFunc("/tasks", taskHandler.GetTasks).Methods("GET")
 	api.HandleFunc("/tasks", taskHandler.CreateTask).Methods("POST")
@@ -552,5 +563,8 @@ func main() {
 	fmt.Printf("curl http://localhost:%s/api/tasks\n", port)
 	fmt.Printf(`curl -X POST http://localhost:%s/api/tasks -H "Content-Type: application/json" -d '{"title":"New Task","description":"Task description"}'`+"\n", port)
 	
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	if err := http.ListenAndServe(":"+port, router); err != nil {
+		slog.Error("server failed to start", "error", err)
+		os.Exit(1)
+	}
 }
\ No newline at end of file
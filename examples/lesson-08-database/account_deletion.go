@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultAccountDeletionGrace = 30 * 24 * time.Hour
+	accountPurgeInterval        = time.Hour
+
+	// AccountRestoreInvalid is returned for unknown restore tokens and for
+	// accounts whose grace period has ended
+	AccountRestoreInvalid = "account_restore_invalid"
+)
+
+// AccountDeletion is an account waiting to be purged. Until PurgeAt the user
+// is only deactivated and can restore the account with the token.
+type AccountDeletion struct {
+	UserID      UserID
+	TokenHash   string
+	RequestedAt time.Time
+	PurgeAt     time.Time
+}
+
+type AccountDeletionRepository interface {
+	Schedule(ctx context.Context, deletion *AccountDeletion) error
+	// Claim removes and returns the deletion with the token if its grace
+	// period hasn't ended
+	Claim(ctx context.Context, tokenHash string) (*AccountDeletion, error)
+	// Due returns users whose grace period has ended
+	Due(ctx context.Context, now time.Time, limit int) ([]UserID, error)
+	// Purge removes the user's tasks, categories and shares, then the user
+	// and everything that cascades from it. It returns the storage keys of
+	// the removed attachments, whose content is deleted after commit.
+	Purge(ctx context.Context, userID UserID) ([]string, error)
+}
+
+type accountDeletionRepository struct {
+	db *sql.DB
+}
+
+func NewAccountDeletionRepository(db *sql.DB) AccountDeletionRepository {
+	return &accountDeletionRepository{db: db}
+}
+
+func (r *accountDeletionRepository) Schedule(ctx context.Context, deletion *AccountDeletion) error {
+	query := `
+		INSERT INTO account_deletions (user_id, token_hash, purge_at)
+		VALUES ($1, $2, $3)
+		RETURNING requested_at`
+
+	err := conn(ctx, r.db).QueryRowContext(ctx, query,
+		deletion.UserID, deletion.TokenHash, deletion.PurgeAt,
+	).Scan(&deletion.RequestedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("account deletion already exists")
+		}
+		return fmt.Errorf("failed to schedule account deletion: %w", err)
+	}
+	return nil
+}
+
+func (r *accountDeletionRepository) Claim(ctx context.Context, tokenHash string) (*AccountDeletion, error) {
+	deletion := &AccountDeletion{}
+	query := `
+		DELETE FROM account_deletions
+		WHERE token_hash = $1 AND purge_at > CURRENT_TIMESTAMP
+		RETURNING user_id, token_hash, requested_at, purge_at`
+
+	err := conn(ctx, r.db).QueryRowContext(ctx, query, tokenHash).Scan(
+		&deletion.UserID, &deletion.TokenHash, &deletion.RequestedAt, &deletion.PurgeAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account deletion not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim account deletion: %w", err)
+	}
+	return deletion, nil
+}
+
+func (r *accountDeletionRepository) Due(ctx context.Context, now time.Time, limit int) ([]UserID, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id FROM account_deletions
+		WHERE purge_at <= $1
+		ORDER BY purge_at
+		LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due account deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []UserID
+	for rows.Next() {
+		var userID UserID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan account deletion: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+func (r *accountDeletionRepository) Purge(ctx context.Context, userID UserID) ([]string, error) {
+	q := conn(ctx, r.db)
+
+	// Lock the deletion, so a restore racing the purge either wins or
+	// finds nothing to claim
+	var purgeAt time.Time
+	err := q.QueryRowContext(ctx, `
+		SELECT purge_at FROM account_deletions WHERE user_id = $1 FOR UPDATE`, userID).Scan(&purgeAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account deletion not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account deletion: %w", err)
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT a.storage_key FROM task_attachments a
+		JOIN tasks t ON t.id = a.task_id
+		WHERE t.user_id = $1
+		UNION ALL
+		SELECT avatar_key FROM users WHERE id = $1 AND avatar_key IS NOT NULL`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	var storageKeys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		storageKeys = append(storageKeys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+
+	// Join rows first, then what they join. Tasks take their attachments,
+	// revisions and collaborators with them; the user's edits of other
+	// users' tasks stay, without the author.
+	statements := []struct{ what, query string }{
+		{"task categories", `
+			DELETE FROM task_categories
+			WHERE task_id IN (SELECT id FROM tasks WHERE user_id = $1)
+			   OR category_id IN (SELECT id FROM categories WHERE user_id = $1)`},
+		{"shares", `DELETE FROM task_collaborators WHERE user_id = $1`},
+		{"tasks", `DELETE FROM tasks WHERE user_id = $1`},
+		{"categories", `DELETE FROM categories WHERE user_id = $1`},
+		{"revisions", `UPDATE task_revisions SET changed_by = NULL WHERE changed_by = $1`},
+		{"attachments", `UPDATE task_attachments SET uploaded_by = NULL WHERE uploaded_by = $1`},
+		{"user", `DELETE FROM users WHERE id = $1`},
+	}
+	for _, statement := range statements {
+		if _, err := q.ExecContext(ctx, statement.query, userID); err != nil {
+			return nil, fmt.Errorf("failed to purge %s: %w", statement.what, err)
+		}
+	}
+	return storageKeys, nil
+}
+
+type AccountDeletionResponse struct {
+	PurgeAt time.Time `json:"purgeAt"`
+	// RestoreToken restores the account with POST /api/auth/restore until
+	// PurgeAt. It is also emailed to the user.
+	RestoreToken string `json:"restoreToken"`
+}
+
+type RestoreAccountRequest struct {
+	Token string `json:"token"`
+}
+
+// DeleteCurrentUser deactivates the account and schedules it to be purged
+// once the grace period has ended. Sessions end right away; until the purge
+// nothing is removed, so the account can be restored as it was.
+func (h *Handler) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	token, err := generateSecret(24)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+	deletion := &AccountDeletion{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		PurgeAt:   time.Now().Add(h.accountDeletionGrace),
+	}
+
+	var user *User
+	err = WithTransactionContext(r.Context(), h.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if user, err = h.userRepo.GetByID(ctx, userID); err != nil {
+			return err
+		}
+		if err := h.accountDeletionRepo.Schedule(ctx, deletion); err != nil {
+			return err
+		}
+		user.IsActive = false
+		return h.userRepo.Update(ctx, user)
+	})
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "user not found"):
+			h.respondWithError(w, http.StatusNotFound, "User not found")
+		case strings.Contains(err.Error(), "already exists"):
+			h.respondWithError(w, http.StatusConflict, "Account is already scheduled for deletion")
+		default:
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to delete account")
+		}
+		return
+	}
+
+	// Refresh tokens are revoked for good; a restore logs in anew
+	if err := h.refreshTokenRepo.RevokeAllForUser(r.Context(), userID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke refresh tokens of deleted user", "user_id", userID, "error", err)
+	}
+	if claims, ok := r.Context().Value("token_claims").(*JWTClaims); ok {
+		if err := h.jwtService.RevokeToken(r.Context(), claims); err != nil {
+			slog.ErrorContext(r.Context(), "failed to revoke access token of deleted user", "user_id", userID, "error", err)
+		}
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nyour account will be deleted on %s. Until then you can restore it by sending\n\n"+
+		"  POST /api/auth/restore\n  {\"token\": \"%s\"}",
+		user.FirstName, deletion.PurgeAt.Format(time.RFC1123), token)
+	if err := h.sendEmail(r.Context(), userID, user.Email, "Your account will be deleted", body); err != nil {
+		slog.ErrorContext(r.Context(), "failed to send deletion notice", "user_id", userID, "error", err)
+	}
+
+	h.recordAudit(r, &AuditEvent{
+		UserID:     userID,
+		Action:     AuditAccountDelete,
+		TargetType: "user",
+		TargetID:   userID.String(),
+		Metadata:   map[string]interface{}{"purgeAt": deletion.PurgeAt},
+	})
+	h.cacheInvalidator.Invalidate(userSurrogateKey(userID))
+	h.respondWithJSON(w, http.StatusAccepted, AccountDeletionResponse{PurgeAt: deletion.PurgeAt, RestoreToken: token})
+}
+
+// RestoreAccount reactivates an account scheduled for deletion and logs the
+// user in.
+func (h *Handler) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	var req RestoreAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Token == "" {
+		h.respondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	var user *User
+	err := WithTransactionContext(r.Context(), h.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+		deletion, err := h.accountDeletionRepo.Claim(ctx, hashToken(req.Token))
+		if err != nil {
+			return err
+		}
+		if user, err = h.userRepo.GetByID(ctx, deletion.UserID); err != nil {
+			return err
+		}
+		user.IsActive = true
+		return h.userRepo.Update(ctx, user)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithErrorCode(w, http.StatusBadRequest, AccountRestoreInvalid,
+				"Restore token is invalid or the account has already been deleted")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to restore account")
+		return
+	}
+
+	h.recordAudit(r, &AuditEvent{UserID: user.ID, Action: AuditAccountRestore, TargetType: "user", TargetID: user.ID.String()})
+
+	response, err := h.issueTokenPair(r.Context(), user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+// PurgeDeletedAccounts removes the accounts whose grace period has ended,
+// each in its own transaction. It returns how many were purged.
+func (h *Handler) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	userIDs, err := h.accountDeletionRepo.Due(ctx, time.Now(), 100)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, userID := range userIDs {
+		var storageKeys []string
+		err := WithTransactionContext(ctx, h.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+			var err error
+			storageKeys, err = h.accountDeletionRepo.Purge(ctx, userID)
+			return err
+		})
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				// Restored in the meantime
+				continue
+			}
+			return purged, err
+		}
+
+		attachments := make([]*TaskAttachment, len(storageKeys))
+		for i, key := range storageKeys {
+			attachments[i] = &TaskAttachment{StorageKey: key}
+		}
+		h.deleteBlobs(ctx, attachments...)
+		h.cacheInvalidator.Invalidate(userSurrogateKey(userID))
+		if h.auditRepo != nil {
+			event := &AuditEvent{UserID: userID, Action: AuditAccountPurge, TargetType: "user", TargetID: userID.String()}
+			if err := h.auditRepo.Log(ctx, event); err != nil {
+				slog.ErrorContext(ctx, "failed to record audit event", "action", event.Action, "error", err)
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// startAccountPurges purges deleted accounts every interval until ctx is
+// done.
+func (h *Handler) startAccountPurges(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := h.PurgeDeletedAccounts(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "account purge failed", "error", err)
+				}
+				if purged > 0 {
+					slog.InfoContext(ctx, "purged deleted accounts", "count", purged)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) deleteAccount(t *testing.T, token string) AccountDeletionResponse {
+	w := env.profileRequest(env.handler.DeleteCurrentUser, http.MethodDelete, token, nil)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var response AccountDeletionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func (env *testEnv) restoreAccount(token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RestoreAccountRequest{Token: token})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/restore", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	env.handler.RestoreAccount(w, req)
+	return w
+}
+
+func TestAccountDeletionRestore(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "leaving@example.com")
+	require.Equal(t, http.StatusCreated, env.createTaskAs(user.Token, "Keep me").Code)
+
+	deletion := env.deleteAccount(t, user.Token)
+	assert.WithinDuration(t, time.Now().Add(defaultAccountDeletionGrace), deletion.PurgeAt, time.Minute)
+	assert.NotEmpty(t, deletion.RestoreToken)
+
+	// Deactivated and logged out
+	assert.Equal(t, http.StatusUnauthorized, env.postLogin("leaving@example.com", "Tasks-Pass-2024").Code)
+	assert.Equal(t, http.StatusUnauthorized, env.refreshTestToken(user.RefreshToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, env.profileRequest(env.handler.GetCurrentUser, http.MethodGet, user.Token, nil).Code)
+	tasks, _, _ := env.countUserRows(t, user.User.ID)
+	assert.Equal(t, 1, tasks, "nothing is removed during the grace period")
+
+	assert.Equal(t, http.StatusBadRequest, env.restoreAccount("wrong").Code)
+	w := env.restoreAccount(deletion.RestoreToken)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var login LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	assert.True(t, login.User.IsActive)
+	assert.NotEmpty(t, login.Token)
+
+	assert.Equal(t, http.StatusOK, env.postLogin("leaving@example.com", "Tasks-Pass-2024").Code)
+	// Tokens are single use
+	assert.Equal(t, AccountRestoreInvalid, errorCode(t, env.restoreAccount(deletion.RestoreToken)))
+
+	// Nothing left to purge
+	purged, err := env.handler.PurgeDeletedAccounts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged)
+}
+
+func TestAccountPurge(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	owner := env.registerTestUser(t, "purged@example.com")
+	other := env.registerTestUser(t, "stays@example.com")
+
+	_, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+		CreateTaskRequest{Title: "Mine", Priority: "medium", CategoryNames: []string{"work", "home"}}, owner.User.ID)
+	require.NoError(t, err)
+	shared, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+		CreateTaskRequest{Title: "Theirs", Priority: "medium"}, other.User.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, env.shareTask(other, shared.ID, "purged@example.com", "write").Code)
+
+	deletion := env.deleteAccount(t, owner.Token)
+	_, err = env.db.ExecContext(ctx, `UPDATE account_deletions SET purge_at = CURRENT_TIMESTAMP - INTERVAL '1 minute'`)
+	require.NoError(t, err)
+
+	// The grace period has ended
+	assert.Equal(t, AccountRestoreInvalid, errorCode(t, env.restoreAccount(deletion.RestoreToken)))
+
+	purged, err := env.handler.PurgeDeletedAccounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	tasks, categories, links := env.countUserRows(t, owner.User.ID)
+	assert.Zero(t, tasks+categories+links)
+	var shares int
+	require.NoError(t, env.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM task_collaborators WHERE user_id = $1", owner.User.ID).Scan(&shares))
+	assert.Zero(t, shares)
+	_, err = env.handler.userRepo.GetByID(ctx, owner.User.ID)
+	assert.ErrorContains(t, err, "not found")
+
+	// Other users keep their tasks, and the address can sign up again
+	tasks, _, _ = env.countUserRows(t, other.User.ID)
+	assert.Equal(t, 1, tasks)
+	env.registerTestUser(t, "purged@example.com")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type AdminCreateUserRequest struct {
+	RegisterRequest
+	Role string `json:"role"`
+}
+
+// requireRole rejects requests whose token does not carry the given role.
+// API keys and OAuth client tokens carry their owner's role but act only
+// within their scopes, none of which covers a role, so they are rejected:
+// an admin's read-only key must not be an admin credential.
+func requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, restricted := r.Context().Value("token_scopes").([]string)
+			if userRole, _ := r.Context().Value("user_role").(string); userRole != role || restricted {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Admin Handlers
+
+// CreateUser lets an admin create an account directly, bypassing the signup
+// email domain rules and invite requirement (e.g. for contractors outside the
+// corporate domain).
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req AdminCreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Email == "" || req.Password == "" || req.FirstName == "" || req.LastName == "" {
+		h.respondWithError(w, http.StatusBadRequest, "All fields are required")
+		return
+	}
+
+	if req.Role != "" && !assignableRole(req.Role) {
+		h.respondWithError(w, http.StatusBadRequest, "Role must be user or admin")
+		return
+	}
+
+	if violations := h.passwordPolicy.Validate(r.Context(), req.Password, req.Email); len(violations) > 0 {
+		h.respondWithPasswordViolations(w, violations)
+		return
+	}
+
+	user, err := h.registration.Register(r.Context(), req.RegisterRequest, RegisterOptions{
+		Role:    req.Role,
+		ByAdmin: true,
+	})
+	if err != nil {
+		h.respondWithRegistrationError(w, err)
+		return
+	}
+	h.recordAudit(r, &AuditEvent{
+		UserID:     UserID(r.Context().Value("user_id").(string)),
+		Action:     AuditUserCreate,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Metadata:   map[string]interface{}{"email": user.Email, "role": user.Role},
+	})
+
+	h.respondWithJSON(w, http.StatusCreated, user)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"logging"
+)
+
+// apiKeyPrefix makes keys recognisable, e.g. for secret scanners
+const apiKeyPrefix = "tk_"
+
+// APIKey lets automation act as a user without the login flow. Only a hash
+// of the key is stored; Scopes restrict it like an OAuth client token.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     UserID     `json:"userId"`
+	Scopes     []string   `json:"scopes"`
+	IsActive   bool       `json:"isActive"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+
+	// Owner details, filled in when authenticating
+	UserEmail string `json:"-"`
+	UserRole  string `json:"-"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey APIKey `json:"apiKey"`
+	// Key is only returned once
+	Key string `json:"key"`
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *APIKey, keyHash string) error
+	ListByUserID(ctx context.Context, userID UserID) ([]*APIKey, error)
+	Authenticate(ctx context.Context, keyHash string) (*APIKey, error)
+	Revoke(ctx context.Context, id string, userID UserID) error
+	TouchLastUsed(ctx context.Context, id string) error
+}
+
+type apiKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *APIKey, keyHash string) error {
+	query := `
+		INSERT INTO api_keys (id, name, key_hash, user_id, permissions, is_active, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`
+
+	return r.db.QueryRowContext(ctx, query,
+		key.ID, key.Name, keyHash, key.UserID, pq.Array(key.Scopes), key.IsActive, key.ExpiresAt,
+	).Scan(&key.CreatedAt)
+}
+
+func (r *apiKeyRepository) ListByUserID(ctx context.Context, userID UserID) ([]*APIKey, error) {
+	query := `
+		SELECT id, name, user_id, COALESCE(permissions, '{}'), is_active, last_used_at, expires_at, created_at
+		FROM api_keys WHERE user_id = $1 AND is_active = true
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key := &APIKey{}
+		var scopes pq.StringArray
+		err := rows.Scan(
+			&key.ID, &key.Name, &key.UserID, &scopes, &key.IsActive,
+			&key.LastUsedAt, &key.ExpiresAt, &key.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		key.Scopes = scopes
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Authenticate looks up an active, unexpired key whose owner is active.
+func (r *apiKeyRepository) Authenticate(ctx context.Context, keyHash string) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes pq.StringArray
+	query := `
+		SELECT k.id, k.name, k.user_id, COALESCE(k.permissions, '{}'), k.is_active,
+		       k.last_used_at, k.expires_at, k.created_at, u.email, u.role
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.is_active = true AND u.is_active = true
+		  AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP)`
+
+	err := r.db.QueryRowContext(ctx, query, keyHash).Scan(
+		&key.ID, &key.Name, &key.UserID, &scopes, &key.IsActive,
+		&key.LastUsedAt, &key.ExpiresAt, &key.CreatedAt, &key.UserEmail, &key.UserRole,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API key not found")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	key.Scopes = scopes
+	return key, nil
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id string, userID UserID) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET is_active = false
+		WHERE id = $1 AND user_id = $2 AND is_active = true`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("API key not found")
+	}
+
+	return nil
+}
+
+func (r *apiKeyRepository) TouchLastUsed(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	return err
+}
+
+// authenticateAPIKey resolves an X-API-Key header value into a request
+// context carrying the key owner's identity and the key's scopes.
+func authenticateAPIKey(ctx context.Context, apiKeys APIKeyRepository, rawKey string) (context.Context, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, fmt.Errorf("API key not found")
+	}
+
+	key, err := apiKeys.Authenticate(ctx, hashToken(rawKey))
+	if err != nil {
+		return nil, err
+	}
+	apiKeys.TouchLastUsed(ctx, key.ID)
+
+	ctx = context.WithValue(ctx, "user_id", key.UserID.String())
+	logging.AddFields(ctx, slog.String("user_id", key.UserID.String()))
+	ctx = context.WithValue(ctx, "user_email", key.UserEmail)
+	ctx = context.WithValue(ctx, "user_role", key.UserRole)
+	ctx = context.WithValue(ctx, "token_scopes", key.Scopes)
+	ctx = context.WithValue(ctx, "api_key_id", key.ID)
+	return ctx, nil
+}
+
+// API Key Handlers
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Name == "" {
+		h.respondWithError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{ScopeTasksRead}
+	}
+	for _, scope := range req.Scopes {
+		if !isSupportedScope(scope) {
+			h.respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Unsupported scope %q, allowed: %s", scope, strings.Join(supportedScopes, ", ")))
+			return
+		}
+	}
+
+	if req.ExpiresInDays < 0 {
+		h.respondWithError(w, http.StatusBadRequest, "expiresInDays must be positive")
+		return
+	}
+
+	secret, err := generateSecret(32)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := &APIKey{
+		ID:       uuid.New().String(),
+		Name:     req.Name,
+		UserID:   userID,
+		Scopes:   req.Scopes,
+		IsActive: true,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := h.apiKeyRepo.Create(r.Context(), key, hashToken(rawKey)); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, CreateAPIKeyResponse{
+		APIKey: *key,
+		Key:    rawKey,
+	})
+}
+
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	keys, err := h.apiKeyRepo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get API keys")
+		return
+	}
+
+	keyList := make([]APIKey, len(keys))
+	for i, key := range keys {
+		keyList[i] = *key
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"apiKeys": keyList,
+		"count":   len(keyList),
+	})
+}
+
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if err := h.apiKeyRepo.Revoke(r.Context(), mux.Vars(r)["id"], userID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) createTestAPIKey(t *testing.T, token string, scopes []string) CreateAPIKeyResponse {
+	body, _ := json.Marshal(CreateAPIKeyRequest{Name: "deploy-bot", Scopes: scopes})
+	req := httptest.NewRequest(http.MethodPost, "/api/users/me/api-keys", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := env.serveWithAuth(env.handler.CreateAPIKey, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created CreateAPIKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created
+}
+
+// serveWithAPIKey runs the handler behind the auth middleware and the given
+// scope check, authenticating with an X-API-Key header.
+func (env *testEnv) serveWithAPIKey(scope string, handler http.HandlerFunc, req *http.Request, key string) *httptest.ResponseRecorder {
+	req.Header.Set("X-API-Key", key)
+	w := httptest.NewRecorder()
+	authMiddleware(env.handler.jwtService, env.handler.apiKeyRepo)(withScope(scope, handler)).ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	token := env.createTestUserAndGetToken(t, "apikey@example.com")
+	created := env.createTestAPIKey(t, token, []string{ScopeTasksRead})
+	assert.True(t, strings.HasPrefix(created.Key, apiKeyPrefix))
+	assert.Equal(t, []string{ScopeTasksRead}, created.APIKey.Scopes)
+
+	// The key can read tasks
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	w := env.serveWithAPIKey(ScopeTasksRead, env.handler.GetTasks, req, created.Key)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// but is limited to its scopes
+	body := strings.NewReader(`{"title": "From automation", "priority": "low"}`)
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks", body)
+	w = env.serveWithAPIKey(ScopeTasksWrite, env.handler.CreateTask, req, created.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// and cannot manage API keys
+	req = httptest.NewRequest(http.MethodGet, "/api/users/me/api-keys", nil)
+	w = env.serveWithAPIKey(ScopeClientsManage, env.handler.GetAPIKeys, req, created.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Unknown keys are rejected
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	w = env.serveWithAPIKey(ScopeTasksRead, env.handler.GetTasks, req, apiKeyPrefix+"unknown")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// An admin's key acts within its scopes, not with the admin role
+func TestAPIKeyOfAdminIsNotAdmin(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	adminToken := env.createTestAdminToken(t)
+	created := env.createTestAPIKey(t, adminToken, []string{ScopeTasksRead})
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/api/admin/invites", "/debug/pprof/"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", created.Key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+func TestAPIKeyListAndRevoke(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	token := env.createTestUserAndGetToken(t, "apikey-revoke@example.com")
+	created := env.createTestAPIKey(t, token, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/me/api-keys", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := env.serveWithAuth(env.handler.GetAPIKeys, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		APIKeys []APIKey `json:"apiKeys"`
+		Count   int      `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Equal(t, 1, list.Count)
+	assert.Equal(t, created.APIKey.ID, list.APIKeys[0].ID)
+	assert.NotContains(t, w.Body.String(), created.Key, "the key itself is never listed")
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/users/me/api-keys/"+created.APIKey.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"id": created.APIKey.ID})
+	w = env.serveWithAuth(env.handler.DeleteAPIKey, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	w = env.serveWithAPIKey(ScopeTasksRead, env.handler.GetTasks, req, created.Key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthMiddleware_MalformedAPIKey(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("X-API-Key", "no-prefix")
+	w := httptest.NewRecorder()
+
+	authMiddleware(NewJWTService("test-secret"), NewAPIKeyRepository(nil))(ok).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
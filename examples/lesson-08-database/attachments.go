@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const defaultAttachmentMaxBytes = 10 << 20
+
+// TaskAttachment is a file uploaded to a task. The content lives in the
+// BlobStore under StorageKey; the database only holds the metadata.
+type TaskAttachment struct {
+	ID          string    `json:"id"`
+	TaskID      TaskID    `json:"taskId"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	SizeBytes   int64     `json:"sizeBytes"`
+	StorageKey  string    `json:"-"`
+	UploadedBy  UserID    `json:"uploadedBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type AttachmentRepository interface {
+	Create(ctx context.Context, attachment *TaskAttachment) error
+	GetByID(ctx context.Context, taskID TaskID, attachmentID string) (*TaskAttachment, error)
+	ListByTask(ctx context.Context, taskID TaskID) ([]*TaskAttachment, error)
+	Delete(ctx context.Context, taskID TaskID, attachmentID string) error
+}
+
+type attachmentRepository struct {
+	db *sql.DB
+}
+
+func NewAttachmentRepository(db *sql.DB) AttachmentRepository {
+	return &attachmentRepository{db: db}
+}
+
+func (r *attachmentRepository) Create(ctx context.Context, attachment *TaskAttachment) error {
+	query := `
+		INSERT INTO task_attachments (id, task_id, filename, content_type, size_bytes, storage_key, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`
+
+	err := r.db.QueryRowContext(ctx, query,
+		attachment.ID, attachment.TaskID, attachment.Filename, attachment.ContentType,
+		attachment.SizeBytes, attachment.StorageKey, attachment.UploadedBy,
+	).Scan(&attachment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+	return nil
+}
+
+const attachmentColumns = `id, task_id, filename, content_type, size_bytes, storage_key,
+	COALESCE(uploaded_by::text, ''), created_at`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*TaskAttachment, error) {
+	attachment := &TaskAttachment{}
+	err := row.Scan(&attachment.ID, &attachment.TaskID, &attachment.Filename, &attachment.ContentType,
+		&attachment.SizeBytes, &attachment.StorageKey, &attachment.UploadedBy, &attachment.CreatedAt)
+	return attachment, err
+}
+
+func (r *attachmentRepository) GetByID(ctx context.Context, taskID TaskID, attachmentID string) (*TaskAttachment, error) {
+	if _, err := uuid.Parse(attachmentID); err != nil {
+		return nil, fmt.Errorf("attachment not found")
+	}
+
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+attachmentColumns+` FROM task_attachments WHERE task_id = $1 AND id = $2`,
+		taskID, attachmentID)
+	attachment, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("attachment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return attachment, nil
+}
+
+func (r *attachmentRepository) ListByTask(ctx context.Context, taskID TaskID) ([]*TaskAttachment, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+attachmentColumns+` FROM task_attachments WHERE task_id = $1 ORDER BY created_at, id`,
+		taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*TaskAttachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func (r *attachmentRepository) Delete(ctx context.Context, taskID TaskID, attachmentID string) error {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM task_attachments WHERE task_id = $1 AND id = $2`, taskID, attachmentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("attachment not found")
+	}
+	return nil
+}
+
+func attachmentStorageKey(taskID TaskID, attachmentID string) string {
+	return "tasks/" + taskID.String() + "/" + attachmentID
+}
+
+// cleanFilename keeps the base name of an uploaded file; browsers on some
+// platforms send the full client path.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:255-len(ext)], "") + ext
+	}
+	return name
+}
+
+// attachmentContentType picks the stored media type: the one the client
+// declared, else one known for the file extension, else one sniffed from the
+// first bytes of the content.
+func attachmentContentType(declared, filename string, head []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return declared
+	}
+	if byExtension := mime.TypeByExtension(filepath.Ext(filename)); byExtension != "" {
+		return byExtension
+	}
+	return http.DetectContentType(head)
+}
+
+// UploadAttachment stores the "file" part of a multipart/form-data request.
+// Anyone who can update the task can attach files to it.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionUpdate)
+	if !ok {
+		return
+	}
+
+	// The limit covers the whole body, so leave room for the multipart framing
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondWithError(w, http.StatusRequestEntityTooLarge, "Attachment is too large")
+			return
+		}
+		h.respondWithError(w, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "A file is required in the \"file\" field")
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.maxAttachmentSize {
+		h.respondWithError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Attachments are limited to %d bytes", h.maxAttachmentSize))
+		return
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to read attachment")
+		return
+	}
+
+	userID := UserID(r.Context().Value("user_id").(string))
+	filename := cleanFilename(header.Filename)
+	attachment := &TaskAttachment{
+		ID:          uuid.NewString(),
+		TaskID:      task.ID,
+		Filename:    filename,
+		ContentType: attachmentContentType(header.Header.Get("Content-Type"), filename, head[:n]),
+		SizeBytes:   header.Size,
+		UploadedBy:  userID,
+	}
+	attachment.StorageKey = attachmentStorageKey(task.ID, attachment.ID)
+
+	// Content first, so a row never points at missing content
+	if err := h.blobs.Put(r.Context(), attachment.StorageKey, file, attachment.SizeBytes, attachment.ContentType); err != nil {
+		slog.ErrorContext(r.Context(), "failed to store attachment", "task_id", task.ID, "error", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to store attachment")
+		return
+	}
+	if err := h.attachmentRepo.Create(r.Context(), attachment); err != nil {
+		if deleteErr := h.blobs.Delete(context.WithoutCancel(r.Context()), attachment.StorageKey); deleteErr != nil {
+			slog.ErrorContext(r.Context(), "failed to remove orphaned attachment", "storage_key", attachment.StorageKey, "error", deleteErr)
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to save attachment")
+		return
+	}
+	h.cacheInvalidator.Invalidate(taskSurrogateKey(task.ID))
+
+	w.Header().Set("Location", "/api/tasks/"+task.ID.String()+"/attachments/"+attachment.ID)
+	h.respondWithJSON(w, http.StatusCreated, attachment)
+}
+
+func (h *Handler) GetAttachments(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionRead)
+	if !ok {
+		return
+	}
+
+	attachments, err := h.attachmentRepo.ListByTask(r.Context(), task.ID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get attachments")
+		return
+	}
+
+	attachmentList := make([]TaskAttachment, len(attachments))
+	for i, attachment := range attachments {
+		attachmentList[i] = *attachment
+	}
+
+	setSurrogateKeys(w, taskSurrogateKey(task.ID))
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"attachments": attachmentList,
+		"count":       len(attachmentList),
+	})
+}
+
+// DownloadAttachment streams the file with its stored media type. It is
+// always sent as an attachment with nosniff, so uploaded HTML or SVG can't
+// run in the API's origin.
+func (h *Handler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionRead)
+	if !ok {
+		return
+	}
+
+	attachment, err := h.attachmentRepo.GetByID(r.Context(), task.ID, mux.Vars(r)["attachmentId"])
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Attachment not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get attachment")
+		return
+	}
+
+	content, err := h.blobs.Get(r.Context(), attachment.StorageKey)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read attachment", "storage_key", attachment.StorageKey, "error", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to read attachment")
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	setSurrogateKeys(w, taskSurrogateKey(task.ID))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		slog.ErrorContext(r.Context(), "failed to send attachment", "storage_key", attachment.StorageKey, "error", err)
+	}
+}
+
+func (h *Handler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionUpdate)
+	if !ok {
+		return
+	}
+
+	attachment, err := h.attachmentRepo.GetByID(r.Context(), task.ID, mux.Vars(r)["attachmentId"])
+	if err == nil {
+		err = h.attachmentRepo.Delete(r.Context(), task.ID, attachment.ID)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Attachment not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to delete attachment")
+		return
+	}
+
+	h.deleteBlobs(r.Context(), attachment)
+	h.cacheInvalidator.Invalidate(taskSurrogateKey(task.ID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBlobs removes attachment content after its rows are gone. Failures
+// are logged: an orphaned blob is invisible to clients.
+func (h *Handler) deleteBlobs(ctx context.Context, attachments ...*TaskAttachment) {
+	ctx = context.WithoutCancel(ctx)
+	for _, attachment := range attachments {
+		if err := h.blobs.Delete(ctx, attachment.StorageKey); err != nil {
+			slog.ErrorContext(ctx, "failed to delete attachment", "storage_key", attachment.StorageKey, "error", err)
+		}
+	}
+}
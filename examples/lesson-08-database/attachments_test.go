@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanFilename(t *testing.T) {
+	assert.Equal(t, "report.pdf", cleanFilename(`C:\Users\me\report.pdf`))
+	assert.Equal(t, "passwd", cleanFilename("../../etc/passwd"))
+	assert.Equal(t, "notes.txt", cleanFilename("notes\r\n.txt"))
+	assert.Equal(t, "attachment", cleanFilename(""))
+	assert.Len(t, cleanFilename(strings.Repeat("a", 300)+".txt"), 255)
+}
+
+func TestAttachmentContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	assert.Equal(t, "application/pdf", attachmentContentType("application/pdf", "report", nil))
+	assert.Equal(t, "application/pdf", attachmentContentType("application/octet-stream", "report.pdf", nil))
+	assert.Equal(t, "image/png", attachmentContentType("", "screenshot", png))
+	assert.Equal(t, "text/plain; charset=utf-8", attachmentContentType("", "notes", []byte("hello")))
+}
+
+func multipartUpload(t *testing.T, filename, contentType, content string) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := writer.CreatePart(header)
+	require.NoError(t, err)
+	part.Write([]byte(content))
+	require.NoError(t, writer.Close())
+	return body, writer.FormDataContentType()
+}
+
+func TestTaskAttachments(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	env.handler.blobs = blobs
+
+	owner := env.registerTestUser(t, "attach-owner@example.com")
+	reader := env.registerTestUser(t, "attach-reader@example.com")
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Task with files", Priority: "medium"}, owner.User.ID)
+	require.NoError(t, err)
+	vars := map[string]string{"id": task.ID.String()}
+
+	upload := func(user LoginResponse, filename, contentType, content string) *http.Response {
+		body, formType := multipartUpload(t, filename, contentType, content)
+		req := taskRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/attachments", user.Token, body.String(), vars)
+		req.Header.Set("Content-Type", formType)
+		return env.serveWithAuth(env.handler.UploadAttachment, req).Result()
+	}
+
+	resp := upload(owner, "Grüße report.pdf", "application/pdf", "%PDF-1.4 fake")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var attachment TaskAttachment
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&attachment))
+	assert.Equal(t, "Grüße report.pdf", attachment.Filename)
+	assert.Equal(t, "application/pdf", attachment.ContentType)
+	assert.EqualValues(t, 13, attachment.SizeBytes)
+	assert.Equal(t, "/api/tasks/"+task.ID.String()+"/attachments/"+attachment.ID, resp.Header.Get("Location"))
+
+	// Only users who can update the task can upload
+	require.Equal(t, http.StatusOK, env.shareTask(owner, task.ID, "attach-reader@example.com", ShareRead).Code)
+	assert.Equal(t, http.StatusForbidden, upload(reader, "notes.txt", "", "hi").StatusCode)
+
+	// Uploads over the limit are rejected
+	env.handler.maxAttachmentSize = 4
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload(owner, "big.txt", "", "too big").StatusCode)
+	env.handler.maxAttachmentSize = defaultAttachmentMaxBytes
+
+	// Readers can list and download
+	req := taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/attachments", reader.Token, "", vars)
+	w := env.serveWithAuth(env.handler.GetAttachments, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"count":1`)
+	assert.NotContains(t, w.Body.String(), "storageKey")
+
+	attachmentVars := map[string]string{"id": task.ID.String(), "attachmentId": attachment.ID}
+	req = taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/attachments/"+attachment.ID, reader.Token, "", attachmentVars)
+	w = env.serveWithAuth(env.handler.DownloadAttachment, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "%PDF-1.4 fake", w.Body.String())
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Equal(t, "13", w.Header().Get("Content-Length"))
+	assert.Equal(t, `attachment; filename*=utf-8''Gr%C3%BC%C3%9Fe%20report.pdf`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	missingVars := map[string]string{"id": task.ID.String(), "attachmentId": "not-a-uuid"}
+	req = taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/attachments/not-a-uuid", owner.Token, "", missingVars)
+	assert.Equal(t, http.StatusNotFound, env.serveWithAuth(env.handler.DownloadAttachment, req).Code)
+
+	// Deleting the task removes the content too
+	req = taskRequest(http.MethodDelete, "/api/tasks/"+task.ID.String(), owner.Token, "", vars)
+	require.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.DeleteTask, req).Code)
+	_, err = blobs.Get(context.Background(), attachmentStorageKey(task.ID, attachment.ID))
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
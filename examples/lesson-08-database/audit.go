@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditLogin       = "auth.login"
+	AuditLoginFailed = "auth.login_failed"
+	AuditRegister    = "auth.register"
+	AuditUserCreate  = "user.create"
+	AuditRoleChange  = "user.role_change"
+	AuditTaskDelete  = "task.delete"
+	AuditTaskShare   = "task.share"
+	AuditTaskUnshare = "task.unshare"
+	AuditCachePurge  = "cache.purge"
+	AuditDrain       = "instance.drain"
+	AuditResume      = "instance.resume"
+
+	AuditEmailChangeRequest = "user.email_change_requested"
+	AuditEmailChange        = "user.email_change"
+	AuditAccountDelete      = "user.delete"
+	AuditAccountRestore     = "user.restore"
+	AuditAccountPurge       = "user.purge"
+	AuditTaskBulkUpdate     = "task.bulk_update"
+)
+
+// AuditEvent records a security-relevant action. UserID is the user the
+// event is about (the actor), empty when unknown, e.g. a failed login for an
+// unregistered email.
+type AuditEvent struct {
+	ID         string                 `json:"id"`
+	UserID     UserID                 `json:"userId,omitempty"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"targetType,omitempty"`
+	TargetID   string                 `json:"targetId,omitempty"`
+	IPAddress  string                 `json:"ipAddress,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+}
+
+// AuditLogger records audit events.
+type AuditLogger interface {
+	Log(ctx context.Context, event *AuditEvent) error
+}
+
+type AuditFilter struct {
+	UserID UserID
+	Action string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// AuditRepository stores audit events and lists them for admins.
+type AuditRepository interface {
+	AuditLogger
+	List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+}
+
+type auditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) Log(ctx context.Context, event *AuditEvent) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit metadata: %w", err)
+	}
+	if event.Metadata == nil {
+		metadata = []byte("{}")
+	}
+
+	var userID interface{}
+	if event.UserID != "" {
+		userID = event.UserID
+	}
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO audit_events (user_id, action, target_type, target_id, ip_address, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		userID, event.Action, event.TargetType, event.TargetID, event.IPAddress, metadata,
+	).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func (r *auditRepository) List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != "" {
+		add("user_id = $%d", filter.UserID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.From != nil {
+		add("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("created_at < $%d", *filter.To)
+	}
+
+	query := `
+		SELECT id, COALESCE(user_id::text, ''), action, target_type, target_id,
+		       ip_address, metadata, created_at
+		FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC"
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		event := &AuditEvent{}
+		var metadata []byte
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Action, &event.TargetType,
+			&event.TargetID, &event.IPAddress, &metadata, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := json.Unmarshal(metadata, &event.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode audit metadata: %w", err)
+		}
+		if len(event.Metadata) == 0 {
+			event.Metadata = nil
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// recordAudit records an event for the request. Failures are logged and never
+// fail the request: losing an audit row is better than refusing a login.
+func (h *Handler) recordAudit(r *http.Request, event *AuditEvent) {
+	if h.auditRepo == nil {
+		return
+	}
+
+	event.IPAddress = clientIP(r)
+	// Record the event even if the client has already gone away
+	if err := h.auditRepo.Log(context.WithoutCancel(r.Context()), event); err != nil {
+		slog.ErrorContext(r.Context(), "failed to record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// GetAuditEvents lists audit events, newest first. Filters: user_id, action,
+// from and to (RFC 3339), limit and offset.
+func (h *Handler) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Action: query.Get("action"),
+		Limit:  50,
+	}
+
+	if value := query.Get("user_id"); value != "" {
+		userID, err := ParseID[userEntity](value)
+		if err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "user_id must be a UUID")
+			return
+		}
+		filter.UserID = userID
+	}
+
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 timestamp", name))
+			return
+		}
+		*target = &t
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil && l > 0 && l <= 500 {
+			filter.Limit = l
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if o, err := strconv.Atoi(offset); err == nil && o >= 0 {
+			filter.Offset = o
+		}
+	}
+
+	events, err := h.auditRepo.List(r.Context(), filter)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get audit events")
+		return
+	}
+
+	eventList := make([]AuditEvent, len(events))
+	for i, event := range events {
+		eventList[i] = *event
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"events": eventList,
+		"count":  len(eventList),
+	})
+}
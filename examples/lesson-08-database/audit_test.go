@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) getAuditEvents(t *testing.T, adminToken, query string) []AuditEvent {
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/audit?"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := env.serveWithAuth(env.handler.GetAuditEvents, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Events []AuditEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Events
+}
+
+func TestAuditLogRecordsSecurityEvents(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	adminToken := env.createTestAdminToken(t)
+
+	registered := env.registerTestUser(t, "audited@example.com")
+	userID := registered.User.ID
+
+	env.postLogin("audited@example.com", "wrong-password")
+	require.Equal(t, http.StatusOK, env.postLogin("audited@example.com", "Tasks-Pass-2024").Code)
+
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Audited task", Priority: "low"}, userID)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodDelete, "/api/tasks/"+task.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+registered.Token)
+	req = mux.SetURLVars(req, map[string]string{"id": task.ID.String()})
+	require.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.DeleteTask, req).Code)
+
+	events := env.getAuditEvents(t, adminToken, "user_id="+userID.String())
+	actions := make([]string, len(events))
+	for i, event := range events {
+		actions[i] = event.Action
+	}
+	assert.Equal(t, []string{AuditTaskDelete, AuditLogin, AuditRegister}, actions, "newest first")
+	assert.Equal(t, task.ID.String(), events[0].TargetID)
+	assert.NotEmpty(t, events[0].IPAddress)
+
+	// Failed logins aren't tied to a user; the email is kept in metadata
+	failed := env.getAuditEvents(t, adminToken, "action="+AuditLoginFailed)
+	require.Len(t, failed, 1)
+	assert.Empty(t, failed[0].UserID)
+	assert.Equal(t, "audited@example.com", failed[0].Metadata["email"])
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	assert.Empty(t, env.getAuditEvents(t, adminToken, "from="+future))
+}
+
+func TestGetAuditEventsRejectsBadFilters(t *testing.T) {
+	handler := &Handler{}
+	for _, query := range []string{"user_id=not-a-uuid", "from=yesterday", "to=2024-01-01"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/audit?"+query, nil)
+		w := httptest.NewRecorder()
+		handler.GetAuditEvents(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Authorization kinds
+const (
+	AuthorizationOAuthClient = "oauth_client"
+	AuthorizationAPIKey      = "api_key"
+)
+
+// Authorization is a credential that can act on the user's account without
+// an interactive login, such as an OAuth client or an API key.
+type Authorization struct {
+	ID         string            `json:"id"`
+	Kind       string            `json:"kind"`
+	Name       string            `json:"name"`
+	ClientID   string            `json:"clientId,omitempty"`
+	Scopes     []string          `json:"scopes"`
+	LastUsedAt *time.Time        `json:"lastUsedAt"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	Links      map[string]string `json:"links"`
+}
+
+type AuthorizationRepository interface {
+	ListByUserID(ctx context.Context, userID UserID) ([]*Authorization, error)
+	Revoke(ctx context.Context, kind, id string, userID UserID) error
+}
+
+type authorizationRepository struct {
+	db *sql.DB
+}
+
+func NewAuthorizationRepository(db *sql.DB) AuthorizationRepository {
+	return &authorizationRepository{db: db}
+}
+
+func (r *authorizationRepository) ListByUserID(ctx context.Context, userID UserID) ([]*Authorization, error) {
+	query := `
+		SELECT id, 'oauth_client' AS kind, name, client_id, scopes, last_used_at, created_at
+		FROM oauth_clients WHERE user_id = $1 AND is_active = true
+		UNION ALL
+		SELECT id, 'api_key' AS kind, name, '' AS client_id,
+		       COALESCE(permissions, '{}'), last_used_at, created_at
+		FROM api_keys WHERE user_id = $1 AND is_active = true
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list authorizations: %w", err)
+	}
+	defer rows.Close()
+
+	var authorizations []*Authorization
+	for rows.Next() {
+		auth := &Authorization{}
+		var scopes pq.StringArray
+		err := rows.Scan(
+			&auth.ID, &auth.Kind, &auth.Name, &auth.ClientID,
+			&scopes, &auth.LastUsedAt, &auth.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan authorization: %w", err)
+		}
+		auth.Scopes = scopes
+		authorizations = append(authorizations, auth)
+	}
+
+	return authorizations, rows.Err()
+}
+
+func (r *authorizationRepository) Revoke(ctx context.Context, kind, id string, userID UserID) error {
+	var query string
+	switch kind {
+	case AuthorizationOAuthClient:
+		query = `UPDATE oauth_clients SET is_active = false WHERE id = $1 AND user_id = $2 AND is_active = true`
+	case AuthorizationAPIKey:
+		query = `UPDATE api_keys SET is_active = false WHERE id = $1 AND user_id = $2 AND is_active = true`
+	default:
+		return fmt.Errorf("unknown authorization kind %q", kind)
+	}
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke authorization: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("authorization not found")
+	}
+
+	return nil
+}
+
+func authorizationRevokeLink(kind, id string) string {
+	return fmt.Sprintf("/api/me/authorizations/%s/%s", kind, id)
+}
+
+// Authorization Handlers
+func (h *Handler) GetAuthorizations(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	authorizations, err := h.authorizationRepo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get authorizations")
+		return
+	}
+
+	authorizationList := make([]Authorization, len(authorizations))
+	for i, auth := range authorizations {
+		auth.Links = map[string]string{
+			"revoke": authorizationRevokeLink(auth.Kind, auth.ID),
+		}
+		authorizationList[i] = *auth
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"authorizations": authorizationList,
+		"count":          len(authorizationList),
+	})
+}
+
+// RevokeAuthorization deactivates an OAuth client or API key. Access tokens
+// already issued to an OAuth client stay valid until they expire.
+func (h *Handler) RevokeAuthorization(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+	vars := mux.Vars(r)
+
+	kind := vars["kind"]
+	if kind != AuthorizationOAuthClient && kind != AuthorizationAPIKey {
+		h.respondWithError(w, http.StatusBadRequest, "Unknown authorization kind")
+		return
+	}
+
+	if err := h.authorizationRepo.Revoke(r.Context(), kind, vars["id"], userID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Authorization not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to revoke authorization")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
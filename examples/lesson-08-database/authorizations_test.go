@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type authorizationListResponse struct {
+	Authorizations []Authorization `json:"authorizations"`
+	Count          int             `json:"count"`
+}
+
+func (env *testEnv) listTestAuthorizations(t *testing.T, token string) authorizationListResponse {
+	req := httptest.NewRequest(http.MethodGet, "/api/me/authorizations", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := env.serveWithAuth(env.handler.GetAuthorizations, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response authorizationListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestAuthorizationsListAndRevoke(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	token := env.createTestUserAndGetToken(t, "consent@example.com")
+	created := env.createTestOAuthClient(t, token, []string{ScopeTasksRead, ScopeTasksWrite})
+
+	// Using the client records last use
+	w := env.requestClientToken(created.Client.ClientID, created.ClientSecret, ScopeTasksRead)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := env.listTestAuthorizations(t, token)
+	require.Equal(t, 1, response.Count)
+
+	auth := response.Authorizations[0]
+	assert.Equal(t, AuthorizationOAuthClient, auth.Kind)
+	assert.Equal(t, created.Client.ClientID, auth.ClientID)
+	assert.ElementsMatch(t, []string{ScopeTasksRead, ScopeTasksWrite}, auth.Scopes)
+	assert.NotNil(t, auth.LastUsedAt)
+	assert.Equal(t, "/api/me/authorizations/oauth_client/"+auth.ID, auth.Links["revoke"])
+
+	// Revoke it
+	req := httptest.NewRequest(http.MethodDelete, auth.Links["revoke"], nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"kind": auth.Kind, "id": auth.ID})
+
+	w = env.serveWithAuth(env.handler.RevokeAuthorization, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.Equal(t, 0, env.listTestAuthorizations(t, token).Count)
+
+	// A revoked client can no longer obtain tokens
+	w = env.requestClientToken(created.Client.ClientID, created.ClientSecret, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Revoking twice reports not found
+	req = httptest.NewRequest(http.MethodDelete, auth.Links["revoke"], nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"kind": auth.Kind, "id": auth.ID})
+
+	w = env.serveWithAuth(env.handler.RevokeAuthorization, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRevokeAuthorization_OtherUser(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	ownerToken := env.createTestUserAndGetToken(t, "owner@example.com")
+	otherToken := env.createTestUserAndGetToken(t, "other@example.com")
+	created := env.createTestOAuthClient(t, ownerToken, nil)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/me/authorizations/oauth_client/"+created.Client.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	req = mux.SetURLVars(req, map[string]string{"kind": AuthorizationOAuthClient, "id": created.Client.ID})
+
+	w := env.serveWithAuth(env.handler.RevokeAuthorization, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 1, env.listTestAuthorizations(t, ownerToken).Count)
+}
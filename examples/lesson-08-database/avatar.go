@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAvatarMaxBytes = 5 << 20
+	// avatarSize is the side of stored avatars; larger images are scaled down
+	avatarSize = 256
+	// Uploads are decoded in full, so their dimensions are bounded before
+	// decoding: a small file can declare a huge image
+	avatarMinSide = 64
+	avatarMaxSide = 4096
+
+	// AvatarInvalid is returned for uploads that aren't a usable image
+	AvatarInvalid = "avatar_invalid"
+)
+
+// avatarFilePattern matches the file part of an avatar URL
+var avatarFilePattern = regexp.MustCompile(`^[0-9a-f-]{36}\.(png|jpg)$`)
+
+// avatarStorageKey is where an avatar is stored. Every upload gets a new
+// key, so its URL never changes content and can be cached forever.
+func avatarStorageKey(userID UserID, file string) string {
+	return "avatars/" + userID.String() + "/" + file
+}
+
+// avatarURL is the path an avatar is served at, from its storage key.
+func avatarURL(key string) string {
+	return "/api/" + key
+}
+
+func avatarSurrogateKey(key string) string { return "avatar:" + path.Base(key) }
+
+// processAvatar checks that an upload is a JPEG, PNG or GIF image of
+// acceptable dimensions, crops it square and scales it down to avatarSize.
+// Re-encoding also drops metadata such as the location a photo was taken.
+// JPEGs stay JPEGs; PNGs and GIFs become PNGs, keeping transparency.
+func processAvatar(upload []byte) (content []byte, ext, contentType string, err error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("avatar must be a JPEG, PNG or GIF image")
+	}
+	if config.Width < avatarMinSide || config.Height < avatarMinSide ||
+		config.Width > avatarMaxSide || config.Height > avatarMaxSide {
+		return nil, "", "", fmt.Errorf("avatar must be between %d and %d pixels wide and high, not %dx%d",
+			avatarMinSide, avatarMaxSide, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("avatar image is corrupt")
+	}
+	resized := resizeAvatar(img, avatarSize)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		ext, contentType = "jpg", "image/jpeg"
+	} else {
+		err = png.Encode(&buf, resized)
+		ext, contentType = "png", "image/png"
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	return buf.Bytes(), ext, contentType, nil
+}
+
+// resizeAvatar crops the middle square of img and scales it down to at most
+// size pixels a side. Each target pixel is the average of the source pixels
+// it covers, which keeps detail that sampling single pixels would alias.
+func resizeAvatar(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	// Premultiplied RGBA, so averaging weighs colors by their opacity
+	src := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(src, src.Bounds(), img, offset, draw.Src)
+	if side <= size {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(src.Pix[i])
+					sum[1] += int(src.Pix[i+1])
+					sum[2] += int(src.Pix[i+2])
+					sum[3] += int(src.Pix[i+3])
+					i += 4
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			j := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[j+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Avatar Handlers
+
+// PutAvatar replaces the user's avatar with the image in the request body,
+// sent with its image/* Content-Type.
+func (h *Handler) PutAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		h.respondWithError(w, http.StatusUnsupportedMediaType,
+			"Send the image as the request body with its Content-Type, e.g. image/jpeg")
+		return
+	}
+	upload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxAvatarSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondWithError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Avatars are limited to %d bytes", h.maxAvatarSize))
+			return
+		}
+		h.respondWithError(w, http.StatusBadRequest, "Failed to read avatar")
+		return
+	}
+
+	content, ext, contentType, err := processAvatar(upload)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "failed") {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to process avatar")
+			return
+		}
+		h.respondWithErrorCode(w, http.StatusBadRequest, AvatarInvalid, err.Error())
+		return
+	}
+
+	// Content first, so a user never points at a missing avatar
+	key := avatarStorageKey(userID, uuid.NewString()+"."+ext)
+	if err := h.blobs.Put(r.Context(), key, bytes.NewReader(content), int64(len(content)), contentType); err != nil {
+		slog.ErrorContext(r.Context(), "failed to store avatar", "user_id", userID, "error", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to store avatar")
+		return
+	}
+	previous, err := h.userRepo.SetAvatarKey(r.Context(), userID, key)
+	if err != nil {
+		h.deleteAvatar(r.Context(), key)
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to save avatar")
+		return
+	}
+	h.deleteAvatar(r.Context(), previous)
+
+	h.respondWithCurrentUser(w, r, userID)
+}
+
+func (h *Handler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	previous, err := h.userRepo.SetAvatarKey(r.Context(), userID, "")
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to delete avatar")
+		return
+	}
+	h.deleteAvatar(r.Context(), previous)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAvatar removes a replaced avatar. Failures are logged: nothing
+// links to it any more.
+func (h *Handler) deleteAvatar(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	h.cacheInvalidator.Invalidate(avatarSurrogateKey(key))
+	if err := h.blobs.Delete(context.WithoutCancel(ctx), key); err != nil {
+		slog.ErrorContext(ctx, "failed to delete avatar", "key", key, "error", err)
+	}
+}
+
+func (h *Handler) respondWithCurrentUser(w http.ResponseWriter, r *http.Request, userID UserID) {
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err == nil {
+		var response ProfileResponse
+		if response, err = h.profile(r.Context(), user); err == nil {
+			h.respondWithJSON(w, http.StatusOK, response)
+			return
+		}
+	}
+	h.respondWithError(w, http.StatusInternalServerError, "Failed to get user")
+}
+
+// GetAvatar serves an avatar without authentication, like the images of a
+// web page: its URL contains a random ID, so it can't be guessed from the
+// user ID. A replaced avatar's URL stops working.
+func (h *Handler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := ParseID[userEntity](vars["userId"])
+	if err != nil || !avatarFilePattern.MatchString(vars["file"]) {
+		h.respondWithError(w, http.StatusNotFound, "Avatar not found")
+		return
+	}
+
+	key := avatarStorageKey(userID, vars["file"])
+	content, err := h.blobs.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			h.respondWithError(w, http.StatusNotFound, "Avatar not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to read avatar", "key", key, "error", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to read avatar")
+		return
+	}
+	defer content.Close()
+
+	contentType := "image/png"
+	if strings.HasSuffix(key, ".jpg") {
+		contentType = "image/jpeg"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	setSurrogateKeys(w, avatarSurrogateKey(key))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		slog.ErrorContext(r.Context(), "failed to send avatar", "key", key, "error", err)
+	}
+}
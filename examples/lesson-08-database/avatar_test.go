@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage is a w x h image, red on the left half and blue on the right.
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, format string, img image.Image) []byte {
+	var buf bytes.Buffer
+	switch format {
+	case "png":
+		require.NoError(t, png.Encode(&buf, img))
+	case "jpeg":
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	case "gif":
+		require.NoError(t, gif.Encode(&buf, img, nil))
+	}
+	return buf.Bytes()
+}
+
+func TestResizeAvatar(t *testing.T) {
+	// A wide image is cropped to its middle square
+	resized := resizeAvatar(testImage(1000, 500), 100)
+	assert.Equal(t, image.Rect(0, 0, 100, 100), resized.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, resized.RGBAAt(10, 50))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, resized.RGBAAt(90, 50))
+
+	// Smaller images aren't scaled up
+	assert.Equal(t, image.Rect(0, 0, 80, 80), resizeAvatar(testImage(80, 120), 100).Bounds())
+
+	// Pixels are averaged: a 2x2 checkerboard of black and white is gray
+	checkerboard := image.NewGray(image.Rect(0, 0, 2, 2))
+	checkerboard.SetGray(0, 0, color.Gray{Y: 255})
+	checkerboard.SetGray(1, 1, color.Gray{Y: 255})
+	assert.Equal(t, color.RGBA{R: 128, G: 128, B: 128, A: 255}, resizeAvatar(checkerboard, 1).RGBAAt(0, 0))
+}
+
+func TestProcessAvatar(t *testing.T) {
+	for format, want := range map[string]string{"jpeg": "image/jpeg", "png": "image/png", "gif": "image/png"} {
+		content, ext, contentType, err := processAvatar(encodeTestImage(t, format, testImage(600, 400)))
+		require.NoError(t, err, format)
+		assert.Equal(t, want, contentType, format)
+		assert.Equal(t, strings.TrimPrefix(want, "image/") == "jpeg", ext == "jpg", format)
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(content))
+		require.NoError(t, err)
+		assert.Equal(t, avatarSize, config.Width)
+		assert.Equal(t, avatarSize, config.Height)
+	}
+
+	for name, upload := range map[string][]byte{
+		"not an image": []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"),
+		"too small":    encodeTestImage(t, "png", testImage(32, 32)),
+		"too large":    encodeTestImage(t, "png", image.NewGray(image.Rect(0, 0, avatarMaxSide+1, 1))),
+		"truncated":    encodeTestImage(t, "png", testImage(100, 100))[:100],
+	} {
+		_, _, _, err := processAvatar(upload)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAvatarUpload(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	env.handler.blobs = blobs
+	user := env.registerTestUser(t, "avatar@example.com")
+	assert.Nil(t, user.User.AvatarURL)
+
+	put := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/users/me/avatar", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Content-Type", contentType)
+		return env.serveWithAuth(env.handler.PutAvatar, req)
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		router := mux.NewRouter()
+		router.HandleFunc("/api/avatars/{userId}/{file}", env.handler.GetAvatar)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := put("image/png", encodeTestImage(t, "png", testImage(512, 512)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var profile ProfileResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	require.NotNil(t, profile.AvatarURL)
+	first := *profile.AvatarURL
+	assert.True(t, strings.HasPrefix(first, "/api/avatars/"+user.User.ID.String()+"/"))
+
+	served := get(first)
+	require.Equal(t, http.StatusOK, served.Code)
+	assert.Equal(t, "image/png", served.Header().Get("Content-Type"))
+	config, err := png.DecodeConfig(served.Body)
+	require.NoError(t, err)
+	assert.Equal(t, avatarSize, config.Width)
+
+	stored, err := env.handler.userRepo.GetByID(context.Background(), user.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, first, *stored.AvatarURL)
+
+	// Replacing it gives a new URL and removes the old image
+	w = put("image/jpeg", encodeTestImage(t, "jpeg", testImage(300, 300)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	assert.NotEqual(t, first, *profile.AvatarURL)
+	assert.True(t, strings.HasSuffix(*profile.AvatarURL, ".jpg"))
+	assert.Equal(t, http.StatusNotFound, get(first).Code)
+	assert.Equal(t, "image/jpeg", get(*profile.AvatarURL).Header().Get("Content-Type"))
+
+	// Invalid uploads leave it alone
+	assert.Equal(t, http.StatusUnsupportedMediaType, put("application/json", []byte(`{}`)).Code)
+	w = put("image/svg+xml", []byte("<svg/>"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, AvatarInvalid, errorCode(t, w))
+	env.handler.maxAvatarSize = 100
+	assert.Equal(t, http.StatusRequestEntityTooLarge, put("image/png", encodeTestImage(t, "png", testImage(256, 256))).Code)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/me/avatar", nil)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	require.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.DeleteAvatar, req).Code)
+	assert.Equal(t, http.StatusNotFound, get(*profile.AvatarURL).Code)
+	stored, err = env.handler.userRepo.GetByID(context.Background(), user.User.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.AvatarURL)
+
+	// Paths that aren't avatars are never read from the store
+	assert.Equal(t, http.StatusNotFound, get("/api/avatars/"+user.User.ID.String()+"/..%2F..%2Fsecret.png").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/avatars/not-a-user/x.png").Code)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"correlation"
+)
+
+const (
+	defaultBatchMaxRequests = 20
+	// maxBatchBodyBytes bounds the whole batch; each sub-request is also
+	// held to the limits of the endpoint it calls
+	maxBatchBodyBytes = 1 << 20
+)
+
+// batchMethods are the methods a sub-request may use
+var batchMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// batchRequestHeaders are not copied from the batch to its sub-requests:
+// they describe the batch body, make a single request conditional or
+// idempotent, or ask for a compressed body, which the batch response can't
+// embed. A sub-request sets them itself when it needs them.
+var batchRequestHeaders = []string{
+	"Accept-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Idempotency-Key",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// batchResponseHeaders are the sub-response headers a client needs; the
+// rest (CORS, caching) belong to the batch response
+var batchResponseHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location", "Retry-After"}
+
+type BatchRequest struct {
+	Requests []BatchItem `json:"requests"`
+}
+
+// BatchItem is one sub-request. Path includes the query string.
+type BatchItem struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type BatchResponse struct {
+	Responses []BatchItemResponse `json:"responses"`
+}
+
+// BatchItemResponse is the result of one sub-request. A JSON body is embedded
+// as is; any other body is a string, and a 204 has none.
+type BatchItemResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+func (item BatchItem) validate() error {
+	if !batchMethods[item.Method] {
+		return fmt.Errorf("method must be one of GET, POST, PUT, PATCH or DELETE")
+	}
+	if !strings.HasPrefix(item.Path, "/api/") {
+		return fmt.Errorf("path must start with /api/")
+	}
+	path, _, _ := strings.Cut(item.Path, "?")
+	if _, rest := versionFromPath(path); path == "/api/batch" || rest == "batch" {
+		return fmt.Errorf("batches can't be nested")
+	}
+	return nil
+}
+
+// Batch runs a list of sub-requests in order and returns their responses in
+// the same order. Mobile clients use it to load a screen in one round trip.
+//
+// Sub-requests go through the same router as any other request, middleware
+// and authentication included, with the batch's Authorization header: a
+// sub-request can't do anything its client couldn't do alone. Each one
+// succeeds or fails on its own, with its own status code; the batch is a 200
+// unless the batch itself is invalid. Sub-requests run one after the other,
+// so a later one sees what an earlier one changed, but they are not a
+// transaction: a failure doesn't undo what came before.
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondWithError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Batches are limited to %d bytes", maxBatchBodyBytes))
+			return
+		}
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Requests) == 0 {
+		h.respondWithError(w, http.StatusBadRequest, "requests must not be empty")
+		return
+	}
+	if len(req.Requests) > h.batchMaxRequests {
+		h.respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("A batch has at most %d requests, not %d", h.batchMaxRequests, len(req.Requests)))
+		return
+	}
+	// Validate everything first, so an invalid batch changes nothing
+	for i, item := range req.Requests {
+		if err := item.validate(); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("requests[%d]: %v", i, err))
+			return
+		}
+	}
+
+	response := BatchResponse{Responses: make([]BatchItemResponse, 0, len(req.Requests))}
+	for _, item := range req.Requests {
+		if r.Context().Err() != nil {
+			// The client is gone or the deadline passed; stop changing things
+			return
+		}
+		response.Responses = append(response.Responses, h.serveBatchItem(r, item))
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+// serveBatchItem runs one sub-request through the router and records its
+// response.
+func (h *Handler) serveBatchItem(batch *http.Request, item BatchItem) BatchItemResponse {
+	sub, err := http.NewRequestWithContext(batch.Context(), item.Method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		return batchItemError(batch, http.StatusBadRequest, "Invalid path")
+	}
+	sub.Header = batch.Header.Clone()
+	for _, name := range batchRequestHeaders {
+		sub.Header.Del(name)
+	}
+	if len(item.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range item.Headers {
+		sub.Header.Set(name, value)
+	}
+	sub.Host = batch.Host
+	sub.RemoteAddr = batch.RemoteAddr
+	sub.TLS = batch.TLS
+
+	recorder := &batchWriter{header: make(http.Header)}
+	h.batchTarget.ServeHTTP(recorder, sub)
+
+	result := BatchItemResponse{Status: recorder.statusCode, Headers: make(map[string]string)}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	for _, name := range batchResponseHeaders {
+		if value := recorder.header.Get(name); value != "" {
+			result.Headers[name] = value
+		}
+	}
+	if body := recorder.body.Bytes(); len(body) > 0 {
+		if json.Valid(body) {
+			result.Body = bytes.TrimSpace(body)
+		} else {
+			result.Body, _ = json.Marshal(string(body))
+		}
+	}
+	return result
+}
+
+// batchItemError is a sub-response for a sub-request of r that couldn't be
+// sent.
+func batchItemError(r *http.Request, status int, message string) BatchItemResponse {
+	id := correlation.RequestID(r.Context())
+	if id == "" {
+		id = newRequestID()
+	}
+	body, _ := json.Marshal(ErrorResponse{
+		Error:     http.StatusText(status),
+		Message:   message,
+		RequestID: id,
+	})
+	return BatchItemResponse{
+		Status:  status,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+	}
+}
+
+// batchWriter keeps a sub-response in memory.
+type batchWriter struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func (bw *batchWriter) Header() http.Header { return bw.header }
+
+func (bw *batchWriter) WriteHeader(code int) {
+	if bw.statusCode == 0 {
+		bw.statusCode = code
+	}
+}
+
+func (bw *batchWriter) Write(b []byte) (int, error) {
+	if bw.statusCode == 0 {
+		bw.statusCode = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchItemValidate(t *testing.T) {
+	for _, valid := range []BatchItem{
+		{Method: "GET", Path: "/api/tasks?completed=false"},
+		{Method: "POST", Path: "/api/tasks", Body: json.RawMessage(`{"title":"x"}`)},
+		{Method: "DELETE", Path: "/api/v1/tasks/1"},
+	} {
+		assert.NoError(t, valid.validate(), "%+v", valid)
+	}
+
+	for _, invalid := range []BatchItem{
+		{Method: "", Path: "/api/tasks"},
+		{Method: "OPTIONS", Path: "/api/tasks"},
+		{Method: "GET", Path: "/health"},
+		{Method: "GET", Path: "https://example.com/api/tasks"},
+		{Method: "POST", Path: "/api/batch"},
+		{Method: "POST", Path: "/api/v1/batch?x=1"},
+	} {
+		assert.Error(t, invalid.validate(), "%+v", invalid)
+	}
+}
+
+// TestBatch sends batches through the router main serves, so sub-requests
+// pass the same middleware and authentication as requests of their own.
+func TestBatch(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "batch@example.com")
+	other := env.registerTestUser(t, "batch-other@example.com")
+	created := env.createTaskAs(other.Token, "Not yours")
+	require.Equal(t, http.StatusCreated, created.Code)
+	var otherTask Task
+	require.NoError(t, json.Unmarshal(created.Body.Bytes(), &otherTask))
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+
+	batch := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("runs sub-requests in order", func(t *testing.T) {
+		w := batch(user.Token, fmt.Sprintf(`{"requests": [
+			{"method": "POST", "path": "/api/tasks", "body": {"title": "From a batch"}},
+			{"method": "GET", "path": "/api/tasks"},
+			{"method": "GET", "path": "/api/tasks/%s"},
+			{"method": "PUT", "path": "/api/tasks/%s", "body": {"title": "Mine now"}},
+			{"method": "GET", "path": "/api/users/me"}
+		]}`, otherTask.ID, otherTask.ID))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response BatchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Responses, 5)
+
+		first := response.Responses[0]
+		assert.Equal(t, http.StatusCreated, first.Status)
+		assert.Contains(t, first.Headers["Content-Type"], "application/json")
+		var task Task
+		require.NoError(t, json.Unmarshal(first.Body, &task))
+		assert.Equal(t, "From a batch", task.Title)
+
+		// The list sees the task created before it
+		assert.Equal(t, http.StatusOK, response.Responses[1].Status)
+		assert.Contains(t, string(response.Responses[1].Body), "From a batch")
+
+		// Someone else's task fails on its own, without failing the batch
+		assert.Equal(t, http.StatusNotFound, response.Responses[2].Status)
+		assert.NotEqual(t, http.StatusOK, response.Responses[3].Status)
+		got, err := env.handler.taskRepo.GetByID(context.Background(), otherTask.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Not yours", got.Title)
+
+		assert.Equal(t, http.StatusOK, response.Responses[4].Status)
+		assert.Contains(t, string(response.Responses[4].Body), "batch@example.com")
+	})
+
+	t.Run("sub-requests authenticate on their own", func(t *testing.T) {
+		w := batch("", `{"requests": [
+			{"method": "GET", "path": "/api/tasks"},
+			{"method": "GET", "path": "/api/tasks", "headers": {"Authorization": "Bearer `+user.Token+`"}}
+		]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response BatchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Responses, 2)
+		assert.Equal(t, http.StatusUnauthorized, response.Responses[0].Status)
+		assert.Equal(t, http.StatusOK, response.Responses[1].Status)
+	})
+
+	t.Run("invalid batches run nothing", func(t *testing.T) {
+		before, err := env.handler.taskRepo.Count(context.Background(), user.User.ID, TaskFilters{})
+		require.NoError(t, err)
+
+		items := make([]string, env.handler.batchMaxRequests+1)
+		for i := range items {
+			items[i] = `{"method": "POST", "path": "/api/tasks", "body": {"title": "Too many"}}`
+		}
+		for _, body := range []string{
+			`{"requests": []}`,
+			`{"requests": [` + strings.Join(items, ",") + `]}`,
+			`{"requests": [{"method": "POST", "path": "/api/tasks", "body": {"title": "x"}}, {"method": "POST", "path": "/api/batch"}]}`,
+			`{"requests": [{"method": "GET", "path": "/health"}]}`,
+			`not json`,
+		} {
+			w := batch(user.Token, body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+
+		after, err := env.handler.taskRepo.Count(context.Background(), user.User.ID, TaskFilters{})
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+}
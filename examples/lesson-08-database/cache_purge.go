@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const jobTypePurgeCache = "purge_cache"
+
+// Surrogate keys tag responses so a caching proxy or CDN in front of the API
+// can drop everything that depends on a record when it changes, whatever
+// the URL: a task's key covers the task itself and every list showing it.
+func taskSurrogateKey(taskID TaskID) string { return "task:" + taskID.String() }
+func userSurrogateKey(userID UserID) string { return "user:" + userID.String() }
+
+// setSurrogateKeys adds keys to the response's Surrogate-Key header. The
+// cache strips the header before the response reaches clients.
+func setSurrogateKeys(w http.ResponseWriter, keys ...string) {
+	if existing := w.Header().Get("Surrogate-Key"); existing != "" {
+		keys = append(strings.Fields(existing), keys...)
+	}
+	w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+}
+
+// CachePurger removes tagged responses from a cache in front of the API.
+type CachePurger interface {
+	Purge(ctx context.Context, keys []string) error
+}
+
+// HTTPCachePurger sends Varnish xkey-style PURGE requests, as understood by
+// the caching proxy of lesson 10.
+type HTTPCachePurger struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPCachePurger(url string) *HTTPCachePurger {
+	return &HTTPCachePurger{url: url, client: newHTTPClient(5 * time.Second)}
+}
+
+func (p *HTTPCachePurger) Purge(ctx context.Context, keys []string) error {
+	req, err := http.NewRequestWithContext(ctx, "PURGE", p.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create purge request: %w", err)
+	}
+	req.Header.Set("xkey-purge", strings.Join(keys, " "))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to purge cache: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cache purge returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CacheInvalidator purges surrogate keys after writes. Purges go through the
+// job queue, so a slow or unavailable cache never holds up the write and
+// failed purges are retried; until a purge lands, the cache serves the old
+// response for at most its s-maxage. A nil invalidator means no cache is
+// configured.
+type CacheInvalidator struct {
+	purger CachePurger
+	queue  *JobQueue
+}
+
+func NewCacheInvalidator(purger CachePurger, queue *JobQueue) *CacheInvalidator {
+	c := &CacheInvalidator{purger: purger, queue: queue}
+	queue.Register(jobTypePurgeCache, c.process)
+	return c
+}
+
+// Invalidate schedules a purge of the keys.
+func (c *CacheInvalidator) Invalidate(keys ...string) {
+	if c == nil || len(keys) == 0 {
+		return
+	}
+
+	key := strings.Join(keys, " ")
+	if err := c.queue.Enqueue(Job{Type: jobTypePurgeCache, Key: key}); err != nil {
+		slog.Error("failed to enqueue cache purge", "key", key, "error", err)
+	}
+}
+
+func (c *CacheInvalidator) process(ctx context.Context, job Job) error {
+	return c.purger.Purge(ctx, strings.Fields(job.Key))
+}
+
+// taskCacheKeys lists the keys to purge when a task changes: the task and
+// the task lists it appears in, its owner's and those of the users it is
+// shared with.
+func (h *Handler) taskCacheKeys(ctx context.Context, task *Task) []string {
+	keys := []string{taskSurrogateKey(task.ID), userSurrogateKey(task.UserID)}
+	if h.cacheInvalidator == nil || h.collaboratorRepo == nil {
+		return keys
+	}
+
+	collaborators, err := h.collaboratorRepo.ListByTask(ctx, task.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list collaborators to purge task", "task_id", task.ID, "error", err)
+	}
+	for _, collaborator := range collaborators {
+		keys = append(keys, userSurrogateKey(collaborator.UserID))
+	}
+	return keys
+}
+
+type PurgeCacheRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// PurgeCache lets an admin purge surrogate keys by hand, e.g. after fixing
+// data directly in the database. Unlike purges after writes, it waits for
+// the cache to confirm.
+func (h *Handler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if h.cacheInvalidator == nil {
+		h.respondWithError(w, http.StatusServiceUnavailable, "No cache purge URL configured")
+		return
+	}
+
+	var req PurgeCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	var keys []string
+	for _, key := range req.Keys {
+		keys = append(keys, strings.Fields(key)...)
+	}
+	if len(keys) == 0 {
+		h.respondWithError(w, http.StatusBadRequest, "At least one key is required")
+		return
+	}
+
+	if err := h.cacheInvalidator.purger.Purge(r.Context(), keys); err != nil {
+		slog.ErrorContext(r.Context(), "cache purge failed", "error", err)
+		h.respondWithError(w, http.StatusBadGateway, "Cache purge failed")
+		return
+	}
+	h.recordAudit(r, &AuditEvent{
+		UserID:     UserID(r.Context().Value("user_id").(string)),
+		Action:     AuditCachePurge,
+		TargetType: "cache",
+		Metadata:   map[string]interface{}{"keys": keys},
+	})
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{"purged": keys})
+}
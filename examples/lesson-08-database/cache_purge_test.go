@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCachePurger struct {
+	mu       sync.Mutex
+	purged   [][]string
+	failures int
+}
+
+func (f *fakeCachePurger) Purge(ctx context.Context, keys []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("cache purge returned 503")
+	}
+	f.purged = append(f.purged, keys)
+	return nil
+}
+
+func (f *fakeCachePurger) calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string(nil), f.purged...)
+}
+
+func newTestInvalidator(t *testing.T, purger CachePurger) *CacheInvalidator {
+	queue := NewJobQueue(10, 3, time.Millisecond)
+	invalidator := NewCacheInvalidator(purger, queue)
+	queue.Start(1)
+	t.Cleanup(queue.Stop)
+	return invalidator
+}
+
+func TestSetSurrogateKeys(t *testing.T) {
+	w := httptest.NewRecorder()
+	setSurrogateKeys(w, taskSurrogateKey("t1"))
+	setSurrogateKeys(w, userSurrogateKey("u1"), taskSurrogateKey("t2"))
+	assert.Equal(t, "task:t1 user:u1 task:t2", w.Header().Get("Surrogate-Key"))
+}
+
+func TestHTTPCachePurger(t *testing.T) {
+	var method, keys string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, keys = r.Method, r.Header.Get("xkey-purge")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	purger := NewHTTPCachePurger(server.URL)
+	require.NoError(t, purger.Purge(context.Background(), []string{"task:t1", "user:u1"}))
+	assert.Equal(t, "PURGE", method)
+	assert.Equal(t, "task:t1 user:u1", keys)
+
+	status = http.StatusForbidden
+	assert.Error(t, purger.Purge(context.Background(), []string{"task:t1"}))
+}
+
+func TestCacheInvalidatorRetries(t *testing.T) {
+	purger := &fakeCachePurger{failures: 1}
+	invalidator := newTestInvalidator(t, purger)
+
+	invalidator.Invalidate(taskSurrogateKey("t1"), userSurrogateKey("u1"))
+	assert.Eventually(t, func() bool {
+		return len(purger.calls()) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"task:t1", "user:u1"}, purger.calls()[0])
+
+	// A nil invalidator means no cache is configured
+	var disabled *CacheInvalidator
+	disabled.Invalidate("task:t1")
+}
+
+func TestPurgeCacheEndpoint(t *testing.T) {
+	purger := &fakeCachePurger{}
+	handler := &Handler{cacheInvalidator: newTestInvalidator(t, purger)}
+
+	purge := func(h *Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/cache/purge", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", "admin-1"))
+		w := httptest.NewRecorder()
+		h.PurgeCache(w, req)
+		return w
+	}
+
+	w := purge(handler, `{"keys": ["task:t1 task:t2", "user:u1"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, [][]string{{"task:t1", "task:t2", "user:u1"}}, purger.calls())
+
+	assert.Equal(t, http.StatusBadRequest, purge(handler, `{"keys": []}`).Code)
+
+	purger.failures = 1
+	assert.Equal(t, http.StatusBadGateway, purge(handler, `{"keys": ["task:t1"]}`).Code)
+
+	assert.Equal(t, http.StatusServiceUnavailable, purge(&Handler{}, `{"keys": ["task:t1"]}`).Code)
+}
+
+func TestTaskWritesPurgeCache(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	purger := &fakeCachePurger{}
+	env.handler.cacheInvalidator = newTestInvalidator(t, purger)
+
+	owner := env.registerTestUser(t, "purge-owner@example.com")
+	collaborator := env.registerTestUser(t, "purge-collaborator@example.com")
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Cached task", Priority: "medium"}, owner.User.ID)
+	require.NoError(t, err)
+	vars := map[string]string{"id": task.ID.String()}
+
+	waitForPurge := func(expected ...string) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			calls := purger.calls()
+			return len(calls) > 0 && assert.ObjectsAreEqual(expected, calls[len(calls)-1])
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	// Reads are tagged
+	req := taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String(), owner.Token, "", vars)
+	w := env.serveWithAuth(env.handler.GetTask, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, taskSurrogateKey(task.ID), w.Header().Get("Surrogate-Key"))
+
+	req = taskRequest(http.MethodGet, "/api/tasks", owner.Token, "", nil)
+	w = env.serveWithAuth(env.handler.GetTasks, req)
+	assert.Equal(t, userSurrogateKey(owner.User.ID)+" "+taskSurrogateKey(task.ID), w.Header().Get("Surrogate-Key"))
+
+	// Sharing purges the task and the collaborator's lists
+	require.Equal(t, http.StatusOK, env.shareTask(owner, task.ID, "purge-collaborator@example.com", ShareWrite).Code)
+	waitForPurge(taskSurrogateKey(task.ID), userSurrogateKey(collaborator.User.ID))
+
+	// Updates purge the task and every list showing it
+	req = taskRequest(http.MethodPut, "/api/tasks/"+task.ID.String(), owner.Token, `{"completed": true}`, vars)
+	require.Equal(t, http.StatusOK, env.serveWithAuth(env.handler.UpdateTask, req).Code)
+	waitForPurge(taskSurrogateKey(task.ID), userSurrogateKey(owner.User.ID), userSurrogateKey(collaborator.User.ID))
+
+	req = taskRequest(http.MethodDelete, "/api/tasks/"+task.ID.String(), owner.Token, "", vars)
+	require.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.DeleteTask, req).Code)
+	waitForPurge(taskSurrogateKey(task.ID), userSurrogateKey(owner.User.ID), userSurrogateKey(collaborator.User.ID))
+}
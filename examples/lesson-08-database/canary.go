@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	canaryHeader        = "X-Canary"
+	canaryVariantHeader = "X-Canary-Variant"
+
+	variantStable = "stable"
+	variantCanary = "canary"
+)
+
+var (
+	canaryRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "canary_requests_total",
+			Help: "Total number of requests on canary-routed endpoints, by variant",
+		},
+		[]string{"method", "endpoint", "variant", "status"},
+	)
+
+	canaryRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "canary_request_duration_seconds",
+			Help: "Duration of requests on canary-routed endpoints, by variant",
+		},
+		[]string{"endpoint", "variant"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(canaryRequestsTotal)
+	prometheus.MustRegister(canaryRequestDuration)
+}
+
+// CanaryRouter sends part of the traffic of an endpoint to an alternate
+// implementation, e.g. a handler backed by a new repository, and records
+// both variants separately so their error rates and latency can be compared.
+//
+// "X-Canary: true" always selects the canary and "X-Canary: false" the stable
+// variant. Other requests go to the canary with the configured percentage;
+// authenticated users are bucketed by ID, so each user consistently sees the
+// same variant.
+type CanaryRouter struct {
+	percent float64
+	sample  func() float64
+}
+
+func NewCanaryRouter(percent float64) (*CanaryRouter, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("canary percentage must be between 0 and 100")
+	}
+	return &CanaryRouter{
+		percent: percent,
+		sample:  func() float64 { return mathrand.Float64() * 100 },
+	}, nil
+}
+
+// Route returns a handler that serves each request with either stable or
+// canary. A nil router always uses stable.
+func (c *CanaryRouter) Route(stable, canary http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return stable
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		variant := c.variant(r)
+		handler := stable
+		if variant == variantCanary {
+			handler = canary
+		}
+
+		w.Header().Set(canaryVariantHeader, variant)
+		start := time.Now()
+		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		handler(ww, r)
+
+		endpoint := routeTemplate(r)
+		canaryRequestsTotal.WithLabelValues(r.Method, endpoint, variant, strconv.Itoa(ww.statusCode)).Inc()
+		canaryRequestDuration.WithLabelValues(endpoint, variant).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (c *CanaryRouter) variant(r *http.Request) string {
+	if forced, err := strconv.ParseBool(r.Header.Get(canaryHeader)); err == nil {
+		if forced {
+			return variantCanary
+		}
+		return variantStable
+	}
+
+	position := c.sample()
+	if userID, ok := r.Context().Value("user_id").(string); ok && userID != "" {
+		position = canaryBucket(userID)
+	}
+	if position < c.percent {
+		return variantCanary
+	}
+	return variantStable
+}
+
+// canaryBucket maps a user ID to a stable position in [0, 100), so raising
+// the percentage only moves users from stable to canary, never back.
+func canaryBucket(userID string) float64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(userID))
+	return float64(hash.Sum32()%10000) / 100
+}
+
+// batchedTaskRepository is the canary task repository. It lists tasks without
+// joining categories, then loads the categories of the whole page in one
+// query, instead of grouping the joined rows and aggregating them into arrays.
+type batchedTaskRepository struct {
+	*taskRepository
+}
+
+func NewBatchedTaskRepository(db *sql.DB) TaskRepository {
+	return &batchedTaskRepository{taskRepository: &taskRepository{db: db}}
+}
+
+func (r *batchedTaskRepository) GetByUserID(ctx context.Context, userID UserID, filters TaskFilters) ([]*Task, error) {
+	ownerCondition := "t.user_id = $1"
+	if filters.IncludeShared {
+		ownerCondition = sharedTasksCondition("t")
+	}
+
+	query := `
+		SELECT t.id, t.title, t.description, t.completed, t.priority,
+		       t.due_date, t.location, t.tags, t.user_id, t.created_at, t.updated_at
+		FROM tasks t
+		WHERE ` + ownerCondition
+	conditions, filterArgs, argIndex := taskFilterConditions("t", filters, 2)
+	args := append([]interface{}{userID}, filterArgs...)
+
+	if filters.Cursor != nil {
+		conditions = append(conditions, keysetCondition("t", argIndex))
+		args = append(args, filters.Cursor.CreatedAt, filters.Cursor.ID)
+		argIndex += 2
+	}
+
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY t.created_at DESC, t.id DESC"
+
+	if filters.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIndex)
+		args = append(args, filters.Limit)
+		argIndex++
+	}
+
+	if filters.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET $%d", argIndex)
+		args = append(args, filters.Offset)
+	}
+
+	rows, err := conn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	byID := make(map[TaskID]*Task)
+	var taskIDs []TaskID
+	for rows.Next() {
+		var row taskRow
+		if err := rows.Scan(row.columns()...); err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		task := taskFromRow(&row)
+		tasks = append(tasks, task)
+		byID[task.ID] = task
+		taskIDs = append(taskIDs, task.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return tasks, nil
+	}
+
+	if err := r.loadCategories(ctx, taskIDs, byID); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (r *batchedTaskRepository) loadCategories(ctx context.Context, taskIDs []TaskID, byID map[TaskID]*Task) error {
+	query := `
+		SELECT tc.task_id, c.id, c.name, c.color
+		FROM task_categories tc
+		JOIN categories c ON tc.category_id = c.id
+		WHERE tc.task_id = ANY($1::uuid[])
+		ORDER BY c.name`
+
+	rows, err := conn(ctx, r.db).QueryContext(ctx, query, pq.Array(taskIDs))
+	if err != nil {
+		return fmt.Errorf("failed to load task categories: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID TaskID
+		var category Category
+		if err := rows.Scan(&taskID, &category.ID, &category.Name, &category.Color); err != nil {
+			return fmt.Errorf("failed to scan task category: %w", err)
+		}
+		if task := byID[taskID]; task != nil {
+			task.Categories = append(task.Categories, category)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func canaryRequest(userID, header string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	if header != "" {
+		req.Header.Set(canaryHeader, header)
+	}
+	if userID != "" {
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", userID))
+	}
+	return req
+}
+
+func TestCanaryRouterVariant(t *testing.T) {
+	canary, err := NewCanaryRouter(0)
+	require.NoError(t, err)
+	canary.sample = func() float64 { return 50 }
+
+	assert.Equal(t, variantStable, canary.variant(canaryRequest("", "")))
+	assert.Equal(t, variantCanary, canary.variant(canaryRequest("", "true")))
+
+	canary.percent = 100
+	assert.Equal(t, variantCanary, canary.variant(canaryRequest("", "")))
+	assert.Equal(t, variantStable, canary.variant(canaryRequest("", "false")))
+
+	// Users are bucketed by ID rather than sampled, so they keep their variant
+	userID := "b5f0c3c8-0000-4000-8000-000000000001"
+	canary.percent = canaryBucket(userID)
+	assert.Equal(t, variantStable, canary.variant(canaryRequest(userID, "")))
+	canary.percent += 0.01
+	assert.Equal(t, variantCanary, canary.variant(canaryRequest(userID, "")))
+	canary.percent = 100
+	assert.Equal(t, variantCanary, canary.variant(canaryRequest(userID, "")))
+
+	_, err = NewCanaryRouter(101)
+	assert.Error(t, err)
+}
+
+func TestCanaryBucketSpread(t *testing.T) {
+	inCanary := 0
+	for i := 0; i < 1000; i++ {
+		if canaryBucket(uuid.NewString()) < 20 {
+			inCanary++
+		}
+	}
+	assert.InDelta(t, 200, inCanary, 60)
+}
+
+func TestCanaryRouterRoute(t *testing.T) {
+	canary, err := NewCanaryRouter(0)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/tasks", canary.Route(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+	))
+	stableOK := canaryRequestsTotal.WithLabelValues("GET", "/api/tasks", variantStable, "200")
+	canaryFailed := canaryRequestsTotal.WithLabelValues("GET", "/api/tasks", variantCanary, "500")
+	stableBefore, canaryBefore := testutil.ToFloat64(stableOK), testutil.ToFloat64(canaryFailed)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, canaryRequest("", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, variantStable, w.Header().Get(canaryVariantHeader))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, canaryRequest("", "true"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, variantCanary, w.Header().Get(canaryVariantHeader))
+
+	assert.Equal(t, stableBefore+1, testutil.ToFloat64(stableOK))
+	assert.Equal(t, canaryBefore+1, testutil.ToFloat64(canaryFailed))
+
+	var disabled *CanaryRouter
+	w = httptest.NewRecorder()
+	disabled.Route(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) },
+	)(w, canaryRequest("", "true"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestBatchedTaskRepositoryMatchesStable checks that the canary repository
+// lists the same tasks as the stable one.
+func TestBatchedTaskRepositoryMatchesStable(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	owner := env.registerTestUser(t, "canary-owner@example.com")
+
+	var release *Task
+	for _, req := range []CreateTaskRequest{
+		{Title: "Plan release", Priority: "high", CategoryNames: []string{"work", "release"}},
+		{Title: "Buy milk", Priority: "low", CategoryNames: []string{"home"}, DueDate: timePtr(time.Now().Add(-time.Hour))},
+		{Title: "Read book", Priority: "medium"},
+	} {
+		task, err := env.handler.taskService.CreateTaskWithCategories(ctx, req, owner.User.ID)
+		require.NoError(t, err)
+		if release == nil {
+			release = task
+		}
+	}
+
+	stable := NewTaskRepository(env.db.DB)
+	batched := NewBatchedTaskRepository(env.db.DB)
+	for _, filters := range []TaskFilters{
+		{Limit: 10, IncludeShared: true},
+		{Limit: 2, Offset: 1},
+		{Limit: 10, Priority: "high"},
+		{Limit: 10, Search: "milk"},
+		{Limit: 10, DueBefore: timePtr(time.Now())},
+		{Limit: 10, CategoryIDs: []CategoryID{release.Categories[0].ID, release.Categories[1].ID}},
+	} {
+		want, err := stable.GetByUserID(ctx, owner.User.ID, filters)
+		require.NoError(t, err)
+		got, err := batched.GetByUserID(ctx, owner.User.ID, filters)
+		require.NoError(t, err)
+
+		require.Len(t, got, len(want))
+		for i := range want {
+			assert.Equal(t, want[i].ID, got[i].ID)
+			assert.Equal(t, want[i].Title, got[i].Title)
+			assert.ElementsMatch(t, want[i].Categories, got[i].Categories)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"respond"
+)
+
+// Categories are per user and unique by name. Tasks are linked to them in
+// task_categories; deleting a category that has tasks is a 409 unless the
+// client asks for the tasks to be detached with ?detach=true.
+//
+// A category may have a parent, up to maxCategoryDepth levels; filtering
+// tasks by a category includes its subcategories. Subcategories of a deleted
+// category become top-level.
+//
+// Colors are hex codes, stored in upper case. A category created without one
+// gets the palette color the user has fewest categories in, the first of
+// those on ties, so the defaults rotate through the palette.
+
+const (
+	defaultCategoryColor  = "#3B82F6"
+	maxCategoryNameLength = 100
+	maxCategoryDepth      = 3
+
+	CategoryExists         = "category_exists"
+	CategoryInUse          = "category_in_use"
+	CategoryParentNotFound = "category_parent_not_found"
+	CategoryCycle          = "category_cycle"
+	CategoryTooDeep        = "category_too_deep"
+)
+
+var categoryColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// PaletteColor is a color offered to clients for their pickers.
+type PaletteColor struct {
+	Name string `json:"name"`
+	Hex  string `json:"hex"`
+}
+
+// categoryPalette is the colors offered to pickers and given to new
+// categories, in rotation order.
+var categoryPalette = []PaletteColor{
+	{Name: "blue", Hex: defaultCategoryColor},
+	{Name: "emerald", Hex: "#10B981"},
+	{Name: "amber", Hex: "#F59E0B"},
+	{Name: "red", Hex: "#EF4444"},
+	{Name: "violet", Hex: "#8B5CF6"},
+	{Name: "pink", Hex: "#EC4899"},
+	{Name: "cyan", Hex: "#06B6D4"},
+	{Name: "lime", Hex: "#84CC16"},
+	{Name: "orange", Hex: "#F97316"},
+	{Name: "slate", Hex: "#64748B"},
+}
+
+// CategoryPaletteResponse is the palette and the color a category the user
+// creates without one would get.
+type CategoryPaletteResponse struct {
+	Colors    []PaletteColor `json:"colors"`
+	NextColor string         `json:"nextColor"`
+}
+
+// nextCategoryColor picks the default color of a new category among the
+// user's categories.
+func nextCategoryColor(categories []*Category) string {
+	used := make(map[string]int, len(categoryPalette))
+	for _, category := range categories {
+		used[strings.ToUpper(category.Color)]++
+	}
+	next := categoryPalette[0].Hex
+	for _, color := range categoryPalette[1:] {
+		if used[color.Hex] < used[next] {
+			next = color.Hex
+		}
+	}
+	return next
+}
+
+// CreateCategoryRequest can carry an ID the client chose, see client_ids.go.
+type CreateCategoryRequest struct {
+	ID       CategoryID  `json:"id,omitempty"`
+	Name     string      `json:"name"`
+	Color    string      `json:"color,omitempty"`
+	ParentID *CategoryID `json:"parentId,omitempty"`
+}
+
+// UpdateCategoryRequest renames, recolors or moves a category; absent fields
+// are kept, and a null parentId makes it top-level.
+type UpdateCategoryRequest struct {
+	Name     *string              `json:"name,omitempty"`
+	Color    *string              `json:"color,omitempty"`
+	ParentID Optional[CategoryID] `json:"parentId"`
+}
+
+func (r UpdateCategoryRequest) MarshalJSON() ([]byte, error) {
+	type plain UpdateCategoryRequest
+	return marshalWithoutAbsent(plain(r))
+}
+
+// CategoryTreeNode is a category with its subcategories, in name order.
+type CategoryTreeNode struct {
+	CategoryResponse
+	Children []CategoryTreeNode `json:"children"`
+}
+
+// CategoryHierarchyError is why a category can't have the parent it was
+// given.
+type CategoryHierarchyError struct {
+	Code string
+}
+
+func (e *CategoryHierarchyError) Error() string {
+	switch e.Code {
+	case CategoryParentNotFound:
+		return "parent category not found"
+	case CategoryCycle:
+		return "a category can't be below itself or one of its subcategories"
+	default:
+		return fmt.Sprintf("categories can be nested at most %d levels deep", maxCategoryDepth)
+	}
+}
+
+// CategoryInUseDetails is the details of a 409 from deleting a category that
+// still has tasks.
+type CategoryInUseDetails struct {
+	TaskCount int `json:"taskCount"`
+}
+
+// validateCategory normalizes the name and color and checks both.
+func validateCategory(category *Category) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return fmt.Errorf("Name is required")
+	}
+	if utf8.RuneCountInString(category.Name) > maxCategoryNameLength {
+		return fmt.Errorf("Name must be at most %d characters", maxCategoryNameLength)
+	}
+	if !categoryColorPattern.MatchString(category.Color) {
+		return fmt.Errorf("Color must be a hex color such as %s", defaultCategoryColor)
+	}
+	category.Color = strings.ToUpper(category.Color)
+	return nil
+}
+
+// checkCategoryParent checks that category can be below its parent among the
+// user's categories: the parent is one of them, the category isn't moved
+// below itself, and neither it nor its subcategories end up more than
+// maxCategoryDepth levels deep.
+func checkCategoryParent(categories []*Category, category *Category) error {
+	if category.ParentID == nil {
+		return nil
+	}
+	byID := make(map[CategoryID]*Category, len(categories))
+	children := make(map[CategoryID][]CategoryID)
+	for _, c := range categories {
+		byID[c.ID] = c
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+
+	parent, ok := byID[*category.ParentID]
+	if !ok {
+		return &CategoryHierarchyError{Code: CategoryParentNotFound}
+	}
+
+	// The parent's level, counting top-level categories as 1
+	depth := 0
+	for p := parent; p != nil; {
+		if p.ID == category.ID {
+			return &CategoryHierarchyError{Code: CategoryCycle}
+		}
+		depth++
+		if depth > len(categories) {
+			// The stored hierarchy has a cycle already
+			return &CategoryHierarchyError{Code: CategoryCycle}
+		}
+		if p.ParentID == nil {
+			break
+		}
+		p = byID[*p.ParentID]
+	}
+
+	// The levels of the category's subtree, itself included; a new category
+	// has none below it
+	var height func(id CategoryID, level int) int
+	height = func(id CategoryID, level int) int {
+		levels := 1
+		if level > maxCategoryDepth {
+			return levels
+		}
+		for _, child := range children[id] {
+			if h := 1 + height(child, level+1); h > levels {
+				levels = h
+			}
+		}
+		return levels
+	}
+
+	if depth+height(category.ID, 1) > maxCategoryDepth {
+		return &CategoryHierarchyError{Code: CategoryTooDeep}
+	}
+	return nil
+}
+
+// buildCategoryTree nests categories below their parents; categories whose
+// parent isn't among them are roots.
+func buildCategoryTree(categories []*Category) []CategoryTreeNode {
+	present := make(map[CategoryID]bool, len(categories))
+	for _, category := range categories {
+		present[category.ID] = true
+	}
+	children := make(map[CategoryID][]*Category)
+	var roots []*Category
+	for _, category := range categories {
+		if category.ParentID != nil && present[*category.ParentID] {
+			children[*category.ParentID] = append(children[*category.ParentID], category)
+		} else {
+			roots = append(roots, category)
+		}
+	}
+
+	var nodes func(categories []*Category) []CategoryTreeNode
+	nodes = func(categories []*Category) []CategoryTreeNode {
+		result := make([]CategoryTreeNode, len(categories))
+		for i, category := range categories {
+			result[i] = CategoryTreeNode{
+				CategoryResponse: newCategoryResponse(category),
+				Children:         nodes(children[category.ID]),
+			}
+		}
+		return result
+	}
+	return nodes(roots)
+}
+
+// saveCategory runs save, which creates or updates the category. When the
+// category has a parent, the user's hierarchy is locked and checked in the
+// same transaction, so concurrent moves can't create a cycle or nest too
+// deep between them.
+func (h *Handler) saveCategory(ctx context.Context, category *Category, save func(ctx context.Context, category *Category) error) error {
+	if category.ParentID == nil {
+		return save(ctx, category)
+	}
+	return WithTransactionContext(ctx, h.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+		if err := h.categoryRepo.LockHierarchy(ctx, category.UserID); err != nil {
+			return err
+		}
+		categories, err := h.categoryRepo.GetByUserID(ctx, category.UserID)
+		if err != nil {
+			return err
+		}
+		if err := checkCategoryParent(categories, category); err != nil {
+			return err
+		}
+		// Caught here, a duplicate name isn't retried as an insert race
+		for _, c := range categories {
+			if c.ID != category.ID && c.Name == category.Name {
+				return ErrCategoryExists
+			}
+		}
+		return save(ctx, category)
+	})
+}
+
+// getCategoryForAction loads the category named in the URL and checks the
+// access policy, responding with 404, 403 or 500 when the request can't go
+// on.
+func (h *Handler) getCategoryForAction(w http.ResponseWriter, r *http.Request, action Action) (*Category, bool) {
+	category, err := h.categoryRepo.GetByID(r.Context(), CategoryID(mux.Vars(r)["id"]))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Category not found")
+			return nil, false
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get category")
+		return nil, false
+	}
+
+	resource := Resource{Type: "category", ID: category.ID.String(), OwnerID: category.UserID}
+	if !h.authorize(w, r, action, resource) {
+		return nil, false
+	}
+	return category, true
+}
+
+// categoryCacheKeys lists the keys to purge when a category changes: those of
+// its tasks, which embed its name and color. The tasks are the category
+// owner's, as categories are only ever linked to their owner's tasks.
+func (h *Handler) categoryCacheKeys(ctx context.Context, category *Category, taskIDs []TaskID) []string {
+	keys := []string{userSurrogateKey(category.UserID)}
+	for _, taskID := range taskIDs {
+		keys = append(keys, h.taskCacheKeys(ctx, &Task{ID: taskID, UserID: category.UserID})...)
+	}
+	return keys
+}
+
+// CreateCategory handles POST /api/categories
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if !h.authorize(w, r, ActionCreate, Resource{Type: "category", OwnerID: userID}) {
+		return
+	}
+
+	var req CreateCategoryRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	category := &Category{ID: NewID[categoryEntity](), Name: req.Name, Color: req.Color, ParentID: req.ParentID, UserID: userID}
+	if req.ID != "" {
+		id, err := ParseClientID[categoryEntity](req.ID.String())
+		if err != nil {
+			h.respondWithErrorCode(w, http.StatusBadRequest, InvalidID, err.Error())
+			return
+		}
+		tombstoned, err := h.clientIDTombstoned(r.Context(), tombstoneCategory, id.String())
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to create category")
+			return
+		}
+		if tombstoned {
+			h.respondWithIDTaken(w, "", nil)
+			return
+		}
+		category.ID = id
+	}
+	if category.Color == "" {
+		categories, err := h.categoryRepo.GetByUserID(r.Context(), userID)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to create category")
+			return
+		}
+		category.Color = nextCategoryColor(categories)
+	}
+	if err := validateCategory(category); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.saveCategory(r.Context(), category, h.categoryRepo.Create); err != nil {
+		if errors.Is(err, ErrIDTaken) {
+			h.respondWithCategoryIDTaken(w, r, userID, category.ID)
+			return
+		}
+		h.respondWithCategoryWriteError(w, err, "Failed to create category")
+		return
+	}
+
+	h.respond(w, r, http.StatusCreated, newCategoryResponse(category))
+}
+
+// UpdateCategory handles PUT /api/categories/{id}
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := h.getCategoryForAction(w, r, ActionUpdate)
+	if !ok {
+		return
+	}
+
+	var req UpdateCategoryRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name != nil {
+		category.Name = *req.Name
+	}
+	if req.Color != nil {
+		category.Color = *req.Color
+	}
+	if req.ParentID.Set {
+		category.ParentID = req.ParentID.Ptr()
+	}
+	if err := validateCategory(category); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.saveCategory(r.Context(), category, h.categoryRepo.Update); err != nil {
+		h.respondWithCategoryWriteError(w, err, "Failed to update category")
+		return
+	}
+	if taskIDs, err := h.categoryRepo.TaskIDs(r.Context(), category.ID); err == nil {
+		h.cacheInvalidator.Invalidate(h.categoryCacheKeys(r.Context(), category, taskIDs)...)
+	}
+
+	h.respond(w, r, http.StatusOK, newCategoryResponse(category))
+}
+
+// DeleteCategory handles DELETE /api/categories/{id}. A category with tasks
+// is only deleted with ?detach=true, which removes it from the tasks.
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := h.getCategoryForAction(w, r, ActionDelete)
+	if !ok {
+		return
+	}
+	detach := r.URL.Query().Get("detach") == "true"
+
+	taskIDs, err := h.categoryRepo.TaskIDs(r.Context(), category.ID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to delete category")
+		return
+	}
+
+	if err := h.categoryRepo.Delete(r.Context(), category.ID, detach); err != nil {
+		if errors.Is(err, ErrCategoryInUse) {
+			h.respondWithJSON(w, http.StatusConflict, ErrorResponse{
+				Error:     http.StatusText(http.StatusConflict),
+				Message:   "The category has tasks; delete it with ?detach=true to remove it from them",
+				Code:      CategoryInUse,
+				RequestID: requestID(w),
+				Details:   CategoryInUseDetails{TaskCount: len(taskIDs)},
+			})
+			return
+		}
+		h.respondWithCategoryWriteError(w, err, "Failed to delete category")
+		return
+	}
+	h.cacheInvalidator.Invalidate(h.categoryCacheKeys(r.Context(), category, taskIDs)...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCategoryTree handles GET /api/categories/tree
+func (h *Handler) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if !h.authorize(w, r, ActionList, Resource{Type: "category", OwnerID: userID}) {
+		return
+	}
+
+	categories, err := h.categoryRepo.GetByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get categories")
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, map[string]interface{}{
+		"categories": buildCategoryTree(categories),
+		"count":      len(categories),
+	})
+}
+
+// GetCategoryPalette handles GET /api/categories/palette
+func (h *Handler) GetCategoryPalette(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if !h.authorize(w, r, ActionList, Resource{Type: "category", OwnerID: userID}) {
+		return
+	}
+
+	categories, err := h.categoryRepo.GetByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get categories")
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, CategoryPaletteResponse{
+		Colors:    categoryPalette,
+		NextColor: nextCategoryColor(categories),
+	})
+}
+
+// respondWithCategoryWriteError maps repository errors of category writes.
+func (h *Handler) respondWithCategoryWriteError(w http.ResponseWriter, err error, message string) {
+	var hierarchyErr *CategoryHierarchyError
+	switch {
+	case errors.As(err, &hierarchyErr) && hierarchyErr.Code == CategoryParentNotFound:
+		h.respondWithErrorCode(w, http.StatusNotFound, hierarchyErr.Code, "Parent category not found")
+	case errors.As(err, &hierarchyErr) && hierarchyErr.Code == CategoryCycle:
+		h.respondWithErrorCode(w, http.StatusBadRequest, hierarchyErr.Code,
+			"A category can't be below itself or one of its subcategories")
+	case errors.As(err, &hierarchyErr):
+		h.respondWithErrorCode(w, http.StatusBadRequest, hierarchyErr.Code,
+			fmt.Sprintf("Categories can be nested at most %d levels deep", maxCategoryDepth))
+	case errors.Is(err, ErrCategoryExists):
+		h.respondWithErrorCode(w, http.StatusConflict, CategoryExists, "A category with this name already exists")
+	case strings.Contains(err.Error(), "not found"):
+		h.respondWithError(w, http.StatusNotFound, "Category not found")
+	default:
+		h.respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCategory(t *testing.T) {
+	category := &Category{Name: "  work  ", Color: "#10b981"}
+	require.NoError(t, validateCategory(category))
+	assert.Equal(t, "work", category.Name)
+	assert.Equal(t, "#10B981", category.Color)
+
+	for _, invalid := range []*Category{
+		{Name: " ", Color: defaultCategoryColor},
+		{Name: strings.Repeat("x", maxCategoryNameLength+1), Color: defaultCategoryColor},
+		{Name: "work", Color: "blue"},
+		{Name: "work", Color: "#12345"},
+		{Name: "work", Color: ""},
+	} {
+		assert.Error(t, validateCategory(invalid), "%+v", invalid)
+	}
+}
+
+// categoryChain returns a top-level category and each further one as the
+// child of the one before.
+func categoryChain(names ...string) []*Category {
+	var categories []*Category
+	for i, name := range names {
+		category := &Category{ID: CategoryID(fmt.Sprintf("00000000-0000-4000-8000-%012d", i+1)), Name: name}
+		if i > 0 {
+			category.ParentID = &categories[i-1].ID
+		}
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+func TestCheckCategoryParent(t *testing.T) {
+	// work > projects > api, work > meetings, and home on its own
+	categories := categoryChain("work", "projects", "api")
+	work, projects, api := categories[0], categories[1], categories[2]
+	meetings := &Category{ID: NewID[categoryEntity](), Name: "meetings", ParentID: &work.ID}
+	home := &Category{ID: NewID[categoryEntity](), Name: "home"}
+	categories = append(categories, meetings, home)
+	below := func(category *Category, parent *Category) *Category {
+		moved := *category
+		moved.ParentID = &parent.ID
+		return &moved
+	}
+	code := func(err error) string {
+		var hierarchyErr *CategoryHierarchyError
+		if !errors.As(err, &hierarchyErr) {
+			return ""
+		}
+		return hierarchyErr.Code
+	}
+
+	assert.NoError(t, checkCategoryParent(categories, &Category{ID: NewID[categoryEntity](), Name: "new"}))
+	assert.NoError(t, checkCategoryParent(categories, below(&Category{ID: NewID[categoryEntity]()}, projects)))
+	assert.NoError(t, checkCategoryParent(categories, below(home, work)))
+	assert.NoError(t, checkCategoryParent(categories, below(api, work)))
+	// Staying where it is is fine too
+	assert.NoError(t, checkCategoryParent(categories, api))
+
+	assert.Equal(t, CategoryTooDeep, code(checkCategoryParent(categories, below(&Category{ID: NewID[categoryEntity]()}, api))))
+	assert.Equal(t, CategoryTooDeep, code(checkCategoryParent(categories, below(home, api))))
+	// projects brings api along, which would be at level 4
+	assert.Equal(t, CategoryTooDeep, code(checkCategoryParent(categories, below(projects, meetings))))
+	assert.Equal(t, CategoryTooDeep, code(checkCategoryParent(categories, below(work, home))))
+
+	assert.Equal(t, CategoryCycle, code(checkCategoryParent(categories, below(work, work))))
+	assert.Equal(t, CategoryCycle, code(checkCategoryParent(categories, below(work, api))))
+	assert.Equal(t, CategoryCycle, code(checkCategoryParent(categories, below(projects, api))))
+
+	other := &Category{ID: NewID[categoryEntity]()}
+	assert.Equal(t, CategoryParentNotFound, code(checkCategoryParent(categories, below(home, other))))
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	categories := categoryChain("work", "projects", "api")
+	meetings := &Category{ID: NewID[categoryEntity](), Name: "meetings", ParentID: &categories[0].ID}
+	// A parent that isn't in the list makes a root
+	orphan := &Category{ID: NewID[categoryEntity](), Name: "orphan", ParentID: new(CategoryID)}
+	categories = append(categories, meetings, orphan)
+
+	tree := buildCategoryTree(categories)
+	require.Len(t, tree, 2)
+	assert.Equal(t, "work", tree[0].Name)
+	assert.Equal(t, "orphan", tree[1].Name)
+	require.Len(t, tree[0].Children, 2)
+	assert.Equal(t, "projects", tree[0].Children[0].Name)
+	assert.Equal(t, "meetings", tree[0].Children[1].Name)
+	require.Len(t, tree[0].Children[0].Children, 1)
+	assert.Equal(t, "api", tree[0].Children[0].Children[0].Name)
+	assert.Empty(t, tree[0].Children[0].Children[0].Children)
+
+	body, err := json.Marshal(tree[0].Children[1])
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"name":"meetings","color":"","parentId":"`+categories[0].ID.String()+`"`)
+	assert.Contains(t, string(body), `"children":[]`)
+}
+
+func TestNextCategoryColor(t *testing.T) {
+	assert.Equal(t, defaultCategoryColor, nextCategoryColor(nil))
+
+	// Defaults rotate through the palette
+	var categories []*Category
+	for _, color := range categoryPalette {
+		next := nextCategoryColor(categories)
+		assert.Equal(t, color.Hex, next)
+		categories = append(categories, &Category{Color: next})
+	}
+	assert.Equal(t, defaultCategoryColor, nextCategoryColor(categories))
+
+	// The color the user has fewest of comes first, in any case; colors
+	// outside the palette don't count
+	categories = []*Category{
+		{Color: "#3b82f6"}, {Color: "#10B981"}, {Color: "#F59E0B"}, {Color: "#123456"},
+	}
+	assert.Equal(t, "#EF4444", nextCategoryColor(categories))
+	for _, color := range categoryPalette {
+		assert.Regexp(t, categoryColorPattern, color.Hex)
+		assert.Equal(t, strings.ToUpper(color.Hex), color.Hex)
+	}
+}
+
+func TestCategoryPalette(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "category-palette@example.com")
+
+	palette := func() CategoryPaletteResponse {
+		w := env.serveWithAuth(env.handler.GetCategoryPalette, taskRequest(http.MethodGet, "/api/categories/palette", user.Token, "", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response CategoryPaletteResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	response := palette()
+	assert.Equal(t, categoryPalette, response.Colors)
+	assert.Equal(t, defaultCategoryColor, response.NextColor)
+
+	create := func(body string) CategoryResponse {
+		w := env.serveWithAuth(env.handler.CreateCategory, taskRequest(http.MethodPost, "/api/categories", user.Token, body, nil))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var category CategoryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &category))
+		return category
+	}
+	assert.Equal(t, "#10B981", create(`{"name": "home", "color": "#10b981"}`).Color)
+	assert.Equal(t, defaultCategoryColor, create(`{"name": "work"}`).Color)
+	assert.Equal(t, categoryPalette[2].Hex, palette().NextColor)
+
+	// Categories created along with a task rotate as well
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Plan trip", Priority: "medium", CategoryNames: []string{"travel", "errands", "work"}}, user.User.ID)
+	require.NoError(t, err)
+	colors := map[string]string{}
+	for _, category := range task.Categories {
+		colors[category.Name] = category.Color
+	}
+	assert.Equal(t, map[string]string{
+		"errands": categoryPalette[2].Hex,
+		"travel":  categoryPalette[3].Hex,
+		"work":    defaultCategoryColor,
+	}, colors)
+}
+
+func TestCategoryCRUD(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	owner := env.registerTestUser(t, "categories@example.com")
+	other := env.registerTestUser(t, "categories-other@example.com")
+
+	create := func(body string) *http.Request {
+		return taskRequest(http.MethodPost, "/api/categories", owner.Token, body, nil)
+	}
+	w := env.serveWithAuth(env.handler.CreateCategory, create(`{"name": "work"}`))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var work CategoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &work))
+	assert.Equal(t, "work", work.Name)
+	assert.Equal(t, defaultCategoryColor, work.Color)
+
+	w = env.serveWithAuth(env.handler.CreateCategory, create(`{"name": "work", "color": "#10B981"}`))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"category_exists"`)
+	w = env.serveWithAuth(env.handler.CreateCategory, create(`{"name": "home", "color": "green"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = env.serveWithAuth(env.handler.CreateCategory, create(`{"name": "home", "color": "#10B981"}`))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var home CategoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &home))
+
+	// A task in "work", created the usual way, finds the category by name
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Report", Priority: "medium", CategoryNames: []string{"work"}}, owner.User.ID)
+	require.NoError(t, err)
+	require.Len(t, task.Categories, 1)
+	assert.Equal(t, work.ID, task.Categories[0].ID)
+
+	update := func(token string, id CategoryID, body string) *httptest.ResponseRecorder {
+		req := taskRequest(http.MethodPut, "/api/categories/"+id.String(), token, body, map[string]string{"id": id.String()})
+		return env.serveWithAuth(env.handler.UpdateCategory, req)
+	}
+	w = update(owner.Token, work.ID, `{"name": "office"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = update(owner.Token, work.ID, `{"color": "#EF4444"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var office CategoryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &office))
+	assert.Equal(t, "office", office.Name)
+	assert.Equal(t, "#EF4444", office.Color)
+
+	// The task shows the renamed, recolored category
+	task, err = env.handler.taskRepo.GetByID(context.Background(), task.ID)
+	require.NoError(t, err)
+	require.Len(t, task.Categories, 1)
+	assert.Equal(t, "office", task.Categories[0].Name)
+	assert.Equal(t, "#EF4444", task.Categories[0].Color)
+
+	assert.Equal(t, http.StatusConflict, update(owner.Token, work.ID, `{"name": "home"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, update(owner.Token, work.ID, `{"name": ""}`).Code)
+	assert.Equal(t, http.StatusForbidden, update(other.Token, work.ID, `{"name": "mine"}`).Code)
+	assert.Equal(t, http.StatusNotFound, update(owner.Token, NewID[categoryEntity](), `{"name": "gone"}`).Code)
+
+	remove := func(token string, id CategoryID, query string) *httptest.ResponseRecorder {
+		req := taskRequest(http.MethodDelete, "/api/categories/"+id.String()+query, token, "", map[string]string{"id": id.String()})
+		return env.serveWithAuth(env.handler.DeleteCategory, req)
+	}
+	assert.Equal(t, http.StatusForbidden, remove(other.Token, work.ID, "").Code)
+
+	// A category with tasks is kept unless they are detached
+	w = remove(owner.Token, work.ID, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"category_in_use"`)
+	assert.Contains(t, w.Body.String(), `"taskCount":1`)
+
+	assert.Equal(t, http.StatusNoContent, remove(owner.Token, work.ID, "?detach=true").Code)
+	task, err = env.handler.taskRepo.GetByID(context.Background(), task.ID)
+	require.NoError(t, err)
+	assert.Empty(t, task.Categories)
+
+	assert.Equal(t, http.StatusNoContent, remove(owner.Token, home.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, remove(owner.Token, home.ID, "").Code)
+
+	categories, err := env.handler.categoryRepo.GetByUserID(context.Background(), owner.User.ID)
+	require.NoError(t, err)
+	assert.Empty(t, categories)
+}
+
+func TestCategoryHierarchy(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	owner := env.registerTestUser(t, "category-tree@example.com")
+	other := env.registerTestUser(t, "category-tree-other@example.com")
+
+	create := func(token, body string) *httptest.ResponseRecorder {
+		return env.serveWithAuth(env.handler.CreateCategory, taskRequest(http.MethodPost, "/api/categories", token, body, nil))
+	}
+	createIn := func(name string, parent *CategoryResponse) CategoryResponse {
+		body := `{"name": "` + name + `"}`
+		if parent != nil {
+			body = `{"name": "` + name + `", "parentId": "` + parent.ID.String() + `"}`
+		}
+		w := create(owner.Token, body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var category CategoryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &category))
+		return category
+	}
+	update := func(id CategoryID, body string) *httptest.ResponseRecorder {
+		req := taskRequest(http.MethodPut, "/api/categories/"+id.String(), owner.Token, body, map[string]string{"id": id.String()})
+		return env.serveWithAuth(env.handler.UpdateCategory, req)
+	}
+
+	work := createIn("work", nil)
+	projects := createIn("projects", &work)
+	api := createIn("api", &projects)
+	home := createIn("home", nil)
+	assert.Nil(t, work.ParentID)
+	require.NotNil(t, api.ParentID)
+	assert.Equal(t, projects.ID, *api.ParentID)
+
+	w := create(owner.Token, `{"name": "v2", "parentId": "`+api.ID.String()+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"category_too_deep"`)
+	w = create(owner.Token, `{"name": "api", "parentId": "`+home.ID.String()+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"category_exists"`)
+
+	// Another user's category can't be a parent, nor be given one of ours
+	othersWork := &Category{ID: NewID[categoryEntity](), Name: "work", Color: defaultCategoryColor, UserID: other.User.ID}
+	require.NoError(t, env.handler.categoryRepo.Create(ctx, othersWork))
+	w = create(owner.Token, `{"name": "borrowed", "parentId": "`+othersWork.ID.String()+`"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"category_parent_not_found"`)
+	w = create(other.Token, `{"name": "borrowed", "parentId": "`+work.ID.String()+`"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = update(work.ID, `{"parentId": "`+api.ID.String()+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"category_cycle"`)
+	w = update(projects.ID, `{"parentId": "`+home.ID.String()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = update(home.ID, `{"parentId": "`+work.ID.String()+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "api would be 4 levels deep")
+	// A rename keeps the parent
+	w = update(projects.ID, `{"name": "side projects"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"parentId":"`+home.ID.String()+`"`)
+	w = update(projects.ID, `{"parentId": "`+work.ID.String()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Filtering by a category includes its subcategories
+	createTask := func(title string, categories ...string) {
+		_, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+			CreateTaskRequest{Title: title, Priority: "medium", CategoryNames: categories}, owner.User.ID)
+		require.NoError(t, err)
+	}
+	createTask("Quarterly review", "work")
+	createTask("Fix endpoint", "api", "urgent")
+	createTask("Paint fence", "home", "urgent")
+	urgent, err := env.handler.categoryRepo.GetByName(ctx, "urgent", owner.User.ID)
+	require.NoError(t, err)
+	listTitles := func(categories ...CategoryID) []string {
+		ids := make([]string, len(categories))
+		for i, id := range categories {
+			ids[i] = id.String()
+		}
+		req := taskRequest(http.MethodGet, "/api/tasks?categories="+strings.Join(ids, ","), owner.Token, "", nil)
+		w := env.serveWithAuth(env.handler.GetTasks, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		titles := []string{}
+		for _, task := range response.Tasks {
+			titles = append(titles, task.Title)
+		}
+		assert.EqualValues(t, len(titles), response.TotalCount)
+		return titles
+	}
+	assert.ElementsMatch(t, []string{"Quarterly review", "Fix endpoint"}, listTitles(work.ID))
+	assert.Equal(t, []string{"Fix endpoint"}, listTitles(projects.ID))
+	assert.Equal(t, []string{"Fix endpoint"}, listTitles(work.ID, urgent.ID))
+	assert.Empty(t, listTitles(projects.ID, home.ID))
+
+	// Tasks embed their categories with the parent, like the categories API
+	req := taskRequest(http.MethodGet, "/api/tasks?categories="+api.ID.String(), owner.Token, "", nil)
+	w = env.serveWithAuth(env.handler.GetTasks, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var listed TaskListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.Tasks, 1)
+	parents := map[string]*CategoryID{}
+	for _, category := range listed.Tasks[0].Categories {
+		parents[category.Name] = category.ParentID
+	}
+	require.NotNil(t, parents["api"])
+	assert.Equal(t, projects.ID, *parents["api"])
+	assert.Nil(t, parents["urgent"])
+
+	req = taskRequest(http.MethodGet, "/api/categories/tree", owner.Token, "", nil)
+	w = env.serveWithAuth(env.handler.GetCategoryTree, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var tree struct {
+		Categories []CategoryTreeNode `json:"categories"`
+		Count      int                `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tree))
+	assert.Equal(t, 5, tree.Count)
+	require.Len(t, tree.Categories, 3)
+	assert.Equal(t, []string{"home", "urgent", "work"},
+		[]string{tree.Categories[0].Name, tree.Categories[1].Name, tree.Categories[2].Name})
+	require.Len(t, tree.Categories[2].Children, 1)
+	assert.Equal(t, "side projects", tree.Categories[2].Children[0].Name)
+	require.Len(t, tree.Categories[2].Children[0].Children, 1)
+	assert.Equal(t, "api", tree.Categories[2].Children[0].Children[0].Name)
+
+	// Subcategories of a deleted category become top-level; a null parentId
+	// does the same
+	req = taskRequest(http.MethodDelete, "/api/categories/"+projects.ID.String(), owner.Token, "", map[string]string{"id": projects.ID.String()})
+	require.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.DeleteCategory, req).Code)
+	moved, err := env.handler.categoryRepo.GetByID(ctx, api.ID)
+	require.NoError(t, err)
+	assert.Nil(t, moved.ParentID)
+	w = update(api.ID, `{"parentId": "`+home.ID.String()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = update(api.ID, `{"parentId": null}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"parentId":null`)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"respond"
+)
+
+// Abuse protection for anonymous endpoints. When the risk heuristics flag a
+// request, the client has to solve a challenge (a CAPTCHA, or a proof of
+// work) and send the answer in the X-Challenge-Response header.
+
+const (
+	challengeHeader      = "X-Challenge-Response"
+	ChallengeRequired    = "challenge_required"
+	ChallengeFailed      = "challenge_failed"
+	defaultPoWDifficulty = 18
+	powChallengeTTL      = 5 * time.Minute
+
+	// challengeRiskWindow is how far back the risk assessor counts requests
+	challengeRiskWindow = 10 * time.Minute
+)
+
+// ChallengeVerifier checks a client's answer to a challenge.
+type ChallengeVerifier interface {
+	// Name identifies the provider to clients, e.g. "hcaptcha" or "pow"
+	Name() string
+	// Issue returns provider-specific parameters the client needs to solve
+	// the challenge (a site key, or a proof-of-work puzzle).
+	Issue() map[string]interface{}
+	Verify(ctx context.Context, response, remoteIP string) (bool, error)
+}
+
+// SiteVerifyChallenge verifies hCaptcha and Cloudflare Turnstile tokens; both
+// use the same siteverify protocol.
+type SiteVerifyChallenge struct {
+	name      string
+	verifyURL string
+	siteKey   string
+	secret    string
+	client    *http.Client
+}
+
+func NewHCaptchaVerifier(siteKey, secret string) *SiteVerifyChallenge {
+	return &SiteVerifyChallenge{
+		name:      "hcaptcha",
+		verifyURL: "https://api.hcaptcha.com/siteverify",
+		siteKey:   siteKey,
+		secret:    secret,
+		client:    newHTTPClient(5 * time.Second),
+	}
+}
+
+func NewTurnstileVerifier(siteKey, secret string) *SiteVerifyChallenge {
+	return &SiteVerifyChallenge{
+		name:      "turnstile",
+		verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		siteKey:   siteKey,
+		secret:    secret,
+		client:    newHTTPClient(5 * time.Second),
+	}
+}
+
+func (c *SiteVerifyChallenge) Name() string { return c.name }
+
+func (c *SiteVerifyChallenge) Issue() map[string]interface{} {
+	return map[string]interface{}{"siteKey": c.siteKey}
+}
+
+func (c *SiteVerifyChallenge) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	form := url.Values{
+		"secret":   {c.secret},
+		"response": {response},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%s verification failed: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("invalid %s response: %w", c.name, err)
+	}
+	return result.Success, nil
+}
+
+// ProofOfWorkChallenge is a dependency-free fallback. The server issues a
+// signed, expiring puzzle; the client must find a nonce such that
+// sha256(puzzle + ":" + nonce) starts with Difficulty zero bits. Puzzles are
+// stateless (HMAC-signed) but can only be redeemed once.
+type ProofOfWorkChallenge struct {
+	secret     []byte
+	difficulty int
+	now        func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func NewProofOfWorkChallenge(secret string, difficulty int) *ProofOfWorkChallenge {
+	if difficulty <= 0 {
+		difficulty = defaultPoWDifficulty
+	}
+	return &ProofOfWorkChallenge{
+		secret:     []byte(secret),
+		difficulty: difficulty,
+		now:        time.Now,
+		used:       make(map[string]time.Time),
+	}
+}
+
+func (p *ProofOfWorkChallenge) Name() string { return "pow" }
+
+func (p *ProofOfWorkChallenge) Issue() map[string]interface{} {
+	nonce, _ := generateSecret(12)
+	expires := p.now().Add(powChallengeTTL).Unix()
+	payload := fmt.Sprintf("%s.%d.%d", nonce, expires, p.difficulty)
+
+	return map[string]interface{}{
+		"challenge":  payload + "." + p.sign(payload),
+		"difficulty": p.difficulty,
+		"algorithm":  "sha256",
+	}
+}
+
+func (p *ProofOfWorkChallenge) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify expects "<challenge>:<nonce>".
+func (p *ProofOfWorkChallenge) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	challenge, nonce, ok := strings.Cut(response, ":")
+	if !ok {
+		return false, nil
+	}
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return false, nil
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(p.sign(payload)), []byte(parts[3])) {
+		return false, nil
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || p.now().Unix() > expires {
+		return false, nil
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return false, nil
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) < difficulty {
+		return false, nil
+	}
+
+	// Each puzzle can only be redeemed once
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, expiry := range p.used {
+		if p.now().After(expiry) {
+			delete(p.used, key)
+		}
+	}
+	if _, seen := p.used[challenge]; seen {
+		return false, nil
+	}
+	p.used[challenge] = time.Unix(expires, 0)
+
+	return true, nil
+}
+
+// SolveProofOfWork brute-forces a nonce for an issued challenge, the same
+// loop a client runs before retrying the request.
+func SolveProofOfWork(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func leadingZeroBits(sum [32]byte) int {
+	count := 0
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// RiskAssessor decides whether a request needs a challenge. It flags clients
+// that hit guarded endpoints more than threshold times within the window, and
+// requests without a User-Agent (typical of naive scripts).
+type RiskAssessor struct {
+	threshold int
+	window    time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+}
+
+func NewRiskAssessor(threshold int, window time.Duration) *RiskAssessor {
+	return &RiskAssessor{
+		threshold: threshold,
+		window:    window,
+		now:       time.Now,
+		attempts:  make(map[string][]time.Time),
+	}
+}
+
+// Record notes an attempt from the client and reports whether the request
+// is risky enough to require a challenge.
+func (a *RiskAssessor) Record(r *http.Request) bool {
+	ip := clientIP(r)
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recent := a.attempts[ip][:0]
+	for _, t := range a.attempts[ip] {
+		if now.Sub(t) < a.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	a.attempts[ip] = recent
+
+	return len(recent) > a.threshold || r.UserAgent() == ""
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ChallengeGuard enforces a challenge on risky requests.
+type ChallengeGuard struct {
+	verifier ChallengeVerifier
+	risk     *RiskAssessor
+}
+
+func NewChallengeGuard(verifier ChallengeVerifier, risk *RiskAssessor) *ChallengeGuard {
+	return &ChallengeGuard{verifier: verifier, risk: risk}
+}
+
+type ChallengeErrorResponse struct {
+	Error     string                 `json:"error"`
+	Message   string                 `json:"message"`
+	Code      string                 `json:"code"`
+	Provider  string                 `json:"provider"`
+	Challenge map[string]interface{} `json:"challenge"`
+}
+
+// requireChallenge wraps a public endpoint. Risky requests without a valid
+// X-Challenge-Response get 403 with the parameters of a fresh challenge.
+func requireChallenge(guard *ChallengeGuard, next http.HandlerFunc) http.HandlerFunc {
+	if guard == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !guard.risk.Record(r) {
+			next(w, r)
+			return
+		}
+
+		code, message := ChallengeRequired, "Complete the challenge and retry"
+		if response := r.Header.Get(challengeHeader); response != "" {
+			ok, err := guard.verifier.Verify(r.Context(), response, clientIP(r))
+			if err == nil && ok {
+				next(w, r)
+				return
+			}
+			code, message = ChallengeFailed, "Challenge response is invalid or expired"
+		}
+
+		respond.JSON(w, http.StatusForbidden, ChallengeErrorResponse{
+			Error:     http.StatusText(http.StatusForbidden),
+			Message:   message,
+			Code:      code,
+			Provider:  guard.verifier.Name(),
+			Challenge: guard.verifier.Issue(),
+		})
+	}
+}
+
+// newChallengeGuard builds the guard from config, or returns nil when
+// challenges are disabled.
+func newChallengeGuard(config Config) *ChallengeGuard {
+	var verifier ChallengeVerifier
+	switch config.ChallengeProvider {
+	case "hcaptcha":
+		verifier = NewHCaptchaVerifier(config.ChallengeSiteKey, config.ChallengeSecret)
+	case "turnstile":
+		verifier = NewTurnstileVerifier(config.ChallengeSiteKey, config.ChallengeSecret)
+	case "pow":
+		verifier = NewProofOfWorkChallenge(config.JWTSecret, config.ChallengeDifficulty)
+	default:
+		return nil
+	}
+	return NewChallengeGuard(verifier, NewRiskAssessor(config.ChallengeThreshold, challengeRiskWindow))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProofOfWorkChallenge(t *testing.T) {
+	pow := NewProofOfWorkChallenge("test-secret", 8)
+
+	issued := pow.Issue()
+	challenge := issued["challenge"].(string)
+	nonce := SolveProofOfWork(challenge, 8)
+
+	ok, err := pow.Verify(context.Background(), challenge+":"+nonce, "")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Puzzles are single use
+	ok, _ = pow.Verify(context.Background(), challenge+":"+nonce, "")
+	assert.False(t, ok)
+
+	// Wrong nonce, tampered difficulty and other servers' puzzles fail
+	other := pow.Issue()["challenge"].(string)
+	wrong := SolveProofOfWork(other, 8) + "0"
+	for leadingZeroBits(sha256.Sum256([]byte(other+":"+wrong))) >= 8 {
+		wrong += "0"
+	}
+	ok, _ = pow.Verify(context.Background(), other+":"+wrong, "")
+	assert.False(t, ok)
+
+	forged := NewProofOfWorkChallenge("other-secret", 1).Issue()["challenge"].(string)
+	ok, _ = pow.Verify(context.Background(), forged+":"+SolveProofOfWork(forged, 1), "")
+	assert.False(t, ok)
+}
+
+func TestProofOfWorkChallenge_Expired(t *testing.T) {
+	pow := NewProofOfWorkChallenge("test-secret", 4)
+	challenge := pow.Issue()["challenge"].(string)
+	nonce := SolveProofOfWork(challenge, 4)
+
+	pow.now = func() time.Time { return time.Now().Add(powChallengeTTL + time.Minute) }
+	ok, _ := pow.Verify(context.Background(), challenge+":"+nonce, "")
+	assert.False(t, ok)
+}
+
+func TestSiteVerifyChallenge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "secret-key", r.PostForm.Get("secret"))
+		fmt.Fprintf(w, `{"success": %t}`, r.PostForm.Get("response") == "good-token")
+	}))
+	defer server.Close()
+
+	verifier := NewTurnstileVerifier("site-key", "secret-key")
+	verifier.verifyURL = server.URL
+
+	ok, err := verifier.Verify(context.Background(), "good-token", "203.0.113.7")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = verifier.Verify(context.Background(), "bad-token", "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRiskAssessor(t *testing.T) {
+	now := time.Now()
+	risk := NewRiskAssessor(2, time.Minute)
+	risk.now = func() time.Time { return now }
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+		req.Header.Set("User-Agent", "test")
+		return req
+	}
+
+	assert.False(t, risk.Record(newRequest()))
+	assert.False(t, risk.Record(newRequest()))
+	assert.True(t, risk.Record(newRequest()), "third attempt in the window is risky")
+
+	// Attempts age out of the window
+	now = now.Add(2 * time.Minute)
+	assert.False(t, risk.Record(newRequest()))
+
+	// Requests without a User-Agent always need a challenge
+	req := newRequest()
+	req.Header.Del("User-Agent")
+	assert.True(t, risk.Record(req))
+}
+
+func TestRequireChallenge(t *testing.T) {
+	pow := NewProofOfWorkChallenge("test-secret", 4)
+	guard := NewChallengeGuard(pow, NewRiskAssessor(0, time.Minute))
+	handler := requireChallenge(guard, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Challenge issued
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	var response ChallengeErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, ChallengeRequired, response.Code)
+	assert.Equal(t, "pow", response.Provider)
+
+	// Solved challenge lets the request through
+	challenge := response.Challenge["challenge"].(string)
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	req.Header.Set(challengeHeader, challenge+":"+SolveProofOfWork(challenge, 4))
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A bad answer is reported as failed
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	req.Header.Set(challengeHeader, "garbage")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, ChallengeFailed, response.Code)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Clients may choose the ID of a task or category they create, as "id" in
+// the body. An offline client can then use the ID, in its own store or in
+// the next request, before the create reaches the server, and a create
+// retried after a lost response can't make a second copy: the retry finds
+// its ID taken. IDs must be version 4 or 7 UUIDs; IDs in use, or of deleted
+// rows still reported as tombstones, are answered with 409 id_taken.
+const (
+	InvalidID = "invalid_id"
+	IDTaken   = "id_taken"
+)
+
+// ErrIDTaken means a client-chosen ID belongs to another row.
+var ErrIDTaken = errors.New("id already taken")
+
+// IDTakenDetails is the details of a 409 for a taken ID. Existing is the
+// resource with the ID when it is the caller's own, so a client retrying a
+// create gets what the first attempt made; for anyone else the ID is just
+// taken.
+type IDTakenDetails struct {
+	Existing interface{} `json:"existing,omitempty"`
+}
+
+// clientIDTombstoned reports whether a client-chosen ID is that of a
+// deleted row: clients following the change feed have been told it is
+// gone, and would drop it again.
+func (h *Handler) clientIDTombstoned(ctx context.Context, resourceType, id string) (bool, error) {
+	return h.tombstoneRepo.Exists(ctx, resourceType, id)
+}
+
+func (h *Handler) respondWithIDTaken(w http.ResponseWriter, location string, existing interface{}) {
+	if existing != nil {
+		w.Header().Set("Location", location)
+	}
+	h.respondWithJSON(w, http.StatusConflict, ErrorResponse{
+		Error:     http.StatusText(http.StatusConflict),
+		Message:   "The ID is already taken",
+		Code:      IDTaken,
+		RequestID: requestID(w),
+		Details:   IDTakenDetails{Existing: existing},
+	})
+}
+
+// respondWithTaskIDTaken answers a create whose task ID is taken.
+func (h *Handler) respondWithTaskIDTaken(w http.ResponseWriter, r *http.Request, userID UserID, id TaskID) {
+	task, err := h.taskRepo.GetByID(r.Context(), id)
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create task")
+		return
+	}
+	if err != nil || task.UserID != userID {
+		h.respondWithIDTaken(w, "", nil)
+		return
+	}
+	h.respondWithIDTaken(w, "/api/tasks/"+id.String(), taskPayload(r, newTaskResponse(task)))
+}
+
+// respondWithCategoryIDTaken answers a create whose category ID is taken.
+func (h *Handler) respondWithCategoryIDTaken(w http.ResponseWriter, r *http.Request, userID UserID, id CategoryID) {
+	category, err := h.categoryRepo.GetByID(r.Context(), id)
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create category")
+		return
+	}
+	if err != nil || category.UserID != userID {
+		h.respondWithIDTaken(w, "", nil)
+		return
+	}
+	h.respondWithIDTaken(w, "/api/categories/"+id.String(), newCategoryResponse(category))
+}
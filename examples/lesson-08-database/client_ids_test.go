@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientChosenIDs(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "client-ids@example.com")
+	other := env.registerTestUser(t, "client-ids-other@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	details := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var response struct {
+			Details map[string]interface{} `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Details
+	}
+
+	id := NewID[taskEntity]()
+	body := `{"id": "` + strings.ToUpper(id.String()) + `", "title": "Made offline"}`
+	w := serve(user.Token, "POST", "/api/tasks", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, id, task.ID)
+
+	// A retry finds what the first attempt made
+	w = serve(user.Token, "POST", "/api/tasks", body)
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, IDTaken, errorCode(t, w))
+	assert.Equal(t, "/api/tasks/"+id.String(), w.Header().Get("Location"))
+	assert.Equal(t, "Made offline", details(w)["existing"].(map[string]interface{})["title"])
+
+	// Someone else only learns that the ID is taken
+	w = serve(other.Token, "POST", "/api/tasks", body)
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, details(w))
+	assert.Empty(t, w.Header().Get("Location"))
+
+	w = serve(user.Token, "POST", "/api/tasks", `{"id": "6f1c2b1e-3d4a-1c5b-9e8f-0a1b2c3d4e5f", "title": "Version 1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, InvalidID, errorCode(t, w))
+	assert.Equal(t, http.StatusBadRequest, serve(user.Token, "POST", "/api/tasks", `{"id": "42", "title": "Not a UUID"}`).Code)
+
+	// A deleted task's ID stays taken while its tombstone is kept
+	require.Equal(t, http.StatusNoContent, serve(user.Token, "DELETE", "/api/tasks/"+id.String(), "").Code)
+	w = serve(user.Token, "POST", "/api/tasks", body)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, IDTaken, errorCode(t, w))
+
+	t.Run("categories", func(t *testing.T) {
+		id := NewID[categoryEntity]()
+		body := `{"id": "` + id.String() + `", "name": "offline"}`
+		w := serve(user.Token, "POST", "/api/categories", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		w = serve(user.Token, "POST", "/api/categories", body)
+		require.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, IDTaken, errorCode(t, w))
+		assert.Equal(t, id.String(), details(w)["existing"].(map[string]interface{})["id"])
+
+		// A taken name is still reported as such
+		w = serve(user.Token, "POST", "/api/categories", `{"id": "`+NewID[categoryEntity]().String()+`", "name": "offline"}`)
+		assert.Equal(t, CategoryExists, errorCode(t, w))
+	})
+}
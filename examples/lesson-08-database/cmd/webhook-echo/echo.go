@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of every delivery; see webhooks.go of the task API
+const (
+	deliveryIDHeader = "X-Webhook-Id"
+	eventHeader      = "X-Webhook-Event"
+	schemaHeader     = "X-Webhook-Schema"
+	signatureHeader  = "X-Webhook-Signature"
+
+	maxBodyBytes = 1 << 20
+)
+
+var (
+	errMissingSignature   = errors.New("missing signature")
+	errMalformedSignature = errors.New("malformed signature")
+	errStaleSignature     = errors.New("signature timestamp outside the tolerance")
+	errSignatureMismatch  = errors.New("signature does not match")
+)
+
+// verifySignature checks an X-Webhook-Signature header against the raw body
+// the way lesson 11's receiver does, and returns when it was signed.
+func verifySignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) (time.Time, error) {
+	if header == "" {
+		return time.Time{}, errMissingSignature
+	}
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return time.Time{}, errMalformedSignature
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				return time.Time{}, errMalformedSignature
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return time.Time{}, errMalformedSignature
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := now.Sub(signedAt); age > tolerance || age < -tolerance {
+		return signedAt, errStaleSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if hmac.Equal(expected, signature) {
+			return signedAt, nil
+		}
+	}
+	return signedAt, errSignatureMismatch
+}
+
+// Simulation makes the echo misbehave like a broken receiver, so the API's
+// retries and deliveries dashboard can be tried out.
+type Simulation struct {
+	// FailFirst attempts of every delivery are answered with FailStatus
+	FailFirst int
+	// FailRate is the share of the other attempts answered with FailStatus
+	FailRate   float64
+	FailStatus int
+	// Delay holds every answer back; longer than the API's 10s timeout, the
+	// attempt times out
+	Delay time.Duration
+}
+
+// Echo prints every delivery it receives with whether its signature is
+// valid, and answers as the simulation says.
+type Echo struct {
+	secret    string
+	tolerance time.Duration
+	sim       Simulation
+	out       io.Writer
+	now       func() time.Time
+	random    func() float64
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func NewEcho(secret string, tolerance time.Duration, sim Simulation, out io.Writer) *Echo {
+	return &Echo{
+		secret:    secret,
+		tolerance: tolerance,
+		sim:       sim,
+		out:       out,
+		now:       time.Now,
+		random:    rand.Float64,
+		attempts:  make(map[string]int),
+	}
+}
+
+// SetSecret sets the secret deliveries are verified with, once the webhook
+// is registered.
+func (e *Echo) SetSecret(secret string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.secret = secret
+}
+
+// attempt counts the attempts at a delivery, which keeps its ID across
+// retries, and returns the secret to verify it with.
+func (e *Echo) attempt(deliveryID string) (int, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attempts[deliveryID]++
+	return e.attempts[deliveryID], e.secret
+}
+
+func (e *Echo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	deliveryID := r.Header.Get(deliveryIDHeader)
+	attempt, secret := e.attempt(deliveryID)
+	var report strings.Builder
+	fmt.Fprintf(&report, "── %s  %s  delivery %s  attempt %d\n",
+		e.now().Format(time.TimeOnly), r.Header.Get(eventHeader), deliveryID, attempt)
+	if schema := r.Header.Get(schemaHeader); schema != "" {
+		fmt.Fprintf(&report, "   schema:    %s\n", schema)
+	}
+
+	status, reason := http.StatusNoContent, ""
+	signedAt, err := verifySignature(secret, r.Header.Get(signatureHeader), body, e.now(), e.tolerance)
+	switch {
+	case secret == "":
+		fmt.Fprintf(&report, "   signature: not checked, no secret\n")
+	case err != nil:
+		fmt.Fprintf(&report, "   signature: INVALID, %v\n", err)
+		// Like a real receiver: the delivery fails and shows in the dashboard
+		status, reason = http.StatusUnauthorized, "invalid signature"
+	default:
+		fmt.Fprintf(&report, "   signature: valid, signed %s ago\n", e.now().Sub(signedAt).Round(time.Second))
+	}
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "   ", "  ") == nil {
+		body = pretty.Bytes()
+	}
+	fmt.Fprintf(&report, "   %s\n", body)
+
+	if status == http.StatusNoContent {
+		switch {
+		case attempt <= e.sim.FailFirst:
+			status, reason = e.sim.FailStatus, fmt.Sprintf("simulated failure %d of %d", attempt, e.sim.FailFirst)
+		case e.sim.FailRate > 0 && e.random() < e.sim.FailRate:
+			status, reason = e.sim.FailStatus, "simulated random failure"
+		}
+	}
+
+	if e.sim.Delay > 0 {
+		select {
+		case <-time.After(e.sim.Delay):
+		case <-r.Context().Done():
+			fmt.Fprintf(&report, "   → no answer, the sender gave up waiting\n")
+			e.print(report.String())
+			return
+		}
+	}
+	if reason != "" {
+		fmt.Fprintf(&report, "   → %d %s (%s)\n", status, http.StatusText(status), reason)
+	} else {
+		fmt.Fprintf(&report, "   → %d %s\n", status, http.StatusText(status))
+	}
+	e.print(report.String())
+	w.WriteHeader(status)
+}
+
+// print writes a whole report at once, so concurrent deliveries don't
+// interleave.
+func (e *Echo) print(report string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	io.WriteString(e.out, report)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "whsec_test"
+
+func signature(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(echo *Echo, deliveryID, signed string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader(body))
+	req.Header.Set(deliveryIDHeader, deliveryID)
+	req.Header.Set(eventHeader, "task.created")
+	req.Header.Set(signatureHeader, signed)
+	w := httptest.NewRecorder()
+	echo.ServeHTTP(w, req)
+	return w
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+
+	signedAt, err := verifySignature(testSecret, signature(testSecret, now.Add(-2*time.Second), body), body, now, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-2*time.Second), signedAt)
+
+	for name, tc := range map[string]struct {
+		header string
+		want   error
+	}{
+		"missing":      {"", errMissingSignature},
+		"malformed":    {"t=1700000000;v1=ab", errMalformedSignature},
+		"no v1":        {"t=1700000000", errMalformedSignature},
+		"stale":        {signature(testSecret, now.Add(-time.Hour), body), errStaleSignature},
+		"other secret": {signature("whsec_other", now, body), errSignatureMismatch},
+		"other body":   {signature(testSecret, now, []byte(`{"id":"2"}`)), errSignatureMismatch},
+	} {
+		_, err := verifySignature(testSecret, tc.header, body, now, time.Minute)
+		assert.ErrorIs(t, err, tc.want, name)
+	}
+}
+
+func TestEchoPrintsDeliveries(t *testing.T) {
+	var out bytes.Buffer
+	echo := NewEcho(testSecret, time.Minute, Simulation{}, &out)
+	body := []byte(`{"type":"task.created","data":{"title":"Echoed"}}`)
+
+	w := deliver(echo, "d1", signature(testSecret, time.Now(), body), body)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	printed := out.String()
+	assert.Contains(t, printed, "task.created  delivery d1  attempt 1")
+	assert.Contains(t, printed, "signature: valid")
+	assert.Contains(t, printed, `"title": "Echoed"`, "bodies are indented")
+
+	// Forged deliveries fail like they would at a real receiver
+	out.Reset()
+	w = deliver(echo, "d2", signature("whsec_other", time.Now(), body), body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, out.String(), "signature: INVALID, signature does not match")
+
+	// Before registering there is no secret to check with
+	out.Reset()
+	w = deliver(NewEcho("", time.Minute, Simulation{}, &out), "d3", "", body)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Contains(t, out.String(), "not checked")
+}
+
+func TestEchoSimulatesFailures(t *testing.T) {
+	var out bytes.Buffer
+	echo := NewEcho(testSecret, time.Minute, Simulation{FailFirst: 2, FailStatus: http.StatusBadGateway}, &out)
+	body := []byte(`{}`)
+	header := signature(testSecret, time.Now(), body)
+
+	// Retries keep the delivery ID, so each delivery fails twice
+	assert.Equal(t, http.StatusBadGateway, deliver(echo, "d1", header, body).Code)
+	assert.Equal(t, http.StatusBadGateway, deliver(echo, "d1", header, body).Code)
+	assert.Equal(t, http.StatusNoContent, deliver(echo, "d1", header, body).Code)
+	assert.Equal(t, http.StatusBadGateway, deliver(echo, "d2", header, body).Code)
+	assert.Contains(t, out.String(), "simulated failure 2 of 2")
+
+	echo = NewEcho(testSecret, time.Minute, Simulation{FailRate: 0.5, FailStatus: http.StatusInternalServerError}, &out)
+	random := []float64{0.2, 0.7}
+	echo.random = func() float64 {
+		value := random[0]
+		random = random[1:]
+		return value
+	}
+	assert.Equal(t, http.StatusInternalServerError, deliver(echo, "d3", header, body).Code)
+	assert.Equal(t, http.StatusNoContent, deliver(echo, "d4", header, body).Code)
+}
+
+func TestEchoDelayOutlastsSender(t *testing.T) {
+	var out bytes.Buffer
+	echo := NewEcho(testSecret, time.Minute, Simulation{Delay: time.Minute}, &out)
+	body := []byte(`{}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader(body)).WithContext(ctx)
+	req.Header.Set(deliveryIDHeader, "d1")
+	req.Header.Set(signatureHeader, signature(testSecret, time.Now(), body))
+	echo.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, out.String(), "the sender gave up")
+}
+
+func TestParseOptions(t *testing.T) {
+	t.Setenv("TASK_API_TOKEN", "")
+
+	opts, err := parseOptions([]string{"-token", "tk_abc", "-listen", "localhost:9000", "-fail-first", "2"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:9000/webhooks", opts.targetURL)
+	assert.Equal(t, http.StatusServiceUnavailable, opts.sim.FailStatus)
+	assert.Equal(t, 2, opts.sim.FailFirst)
+
+	for _, args := range [][]string{
+		{},
+		{"-token", "t", "-fail-rate", "2"},
+		{"-token", "t", "-fail-status", "200"},
+	} {
+		_, err := parseOptions(args)
+		assert.Error(t, err, strings.Join(args, " "))
+	}
+
+	opts, err = parseOptions([]string{"-secret", testSecret})
+	require.NoError(t, err, "an existing webhook needs no token")
+	assert.Equal(t, testSecret, opts.secret)
+}
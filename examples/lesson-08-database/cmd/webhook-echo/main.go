@@ -0,0 +1,209 @@
+// Command webhook-echo is a webhook target for developing against the task
+// API. It registers itself as a webhook of a local server, prints every
+// delivery with whether its signature is valid, and can fail or stall on
+// purpose to exercise the API's retries:
+//
+//	TASK_API_TOKEN=... go run ./cmd/webhook-echo -fail-first 2
+//
+// The webhook is deleted again on exit.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	api       string
+	token     string
+	listen    string
+	targetURL string
+	events    string
+	secret    string
+	keep      bool
+	tolerance time.Duration
+	sim       Simulation
+}
+
+func parseOptions(args []string) (options, error) {
+	var opts options
+	flags := flag.NewFlagSet("webhook-echo", flag.ContinueOnError)
+	flags.StringVar(&opts.api, "api", "http://localhost:8088", "base URL of the task API")
+	flags.StringVar(&opts.token, "token", os.Getenv("TASK_API_TOKEN"), "access token or tk_ API key with clients:manage (default $TASK_API_TOKEN)")
+	flags.StringVar(&opts.listen, "listen", "localhost:8093", "address to receive deliveries on")
+	flags.StringVar(&opts.targetURL, "url", "", "URL the API delivers to, such as a tunnel's (default http://{listen}/webhooks)")
+	flags.StringVar(&opts.events, "events", "", "comma-separated events to subscribe to (default all)")
+	flags.StringVar(&opts.secret, "secret", "", "secret of an existing webhook; skips registering one")
+	flags.BoolVar(&opts.keep, "keep", false, "keep the registered webhook on exit")
+	flags.DurationVar(&opts.tolerance, "tolerance", 5*time.Minute, "how far a signature's timestamp may be from now")
+	flags.IntVar(&opts.sim.FailFirst, "fail-first", 0, "fail the first `n` attempts of every delivery")
+	flags.Float64Var(&opts.sim.FailRate, "fail-rate", 0, "share of the other attempts to fail, from 0 to 1")
+	flags.IntVar(&opts.sim.FailStatus, "fail-status", http.StatusServiceUnavailable, "status code of simulated failures")
+	flags.DurationVar(&opts.sim.Delay, "delay", 0, "hold every answer back; over 10s the API times out")
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+
+	if opts.targetURL == "" {
+		opts.targetURL = "http://" + opts.listen + "/webhooks"
+	}
+	if opts.secret == "" && opts.token == "" {
+		return opts, errors.New("-token or $TASK_API_TOKEN is required to register the webhook, or -secret to use an existing one")
+	}
+	if opts.sim.FailRate < 0 || opts.sim.FailRate > 1 {
+		return opts, errors.New("-fail-rate must be from 0 to 1")
+	}
+	if opts.sim.FailStatus < 300 || opts.sim.FailStatus > 599 {
+		return opts, errors.New("-fail-status must be a status code the API retries, from 300 to 599")
+	}
+	return opts, nil
+}
+
+// apiClient registers and deletes the echo's webhook.
+type apiClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+type webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (c *apiClient) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.baseURL, "/")+path, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.HasPrefix(c.token, "tk_") {
+		req.Header.Set("X-API-Key", c.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.client.Do(req)
+}
+
+func (c *apiClient) register(ctx context.Context, url string, events []string) (webhook, string, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/api/webhooks", map[string]interface{}{"url": url, "events": events})
+	if err != nil {
+		return webhook{}, "", fmt.Errorf("failed to register the webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		var problem struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&problem)
+		return webhook{}, "", fmt.Errorf("failed to register the webhook: API returned %d %s", resp.StatusCode, problem.Message)
+	}
+	var created struct {
+		Webhook webhook `json:"webhook"`
+		Secret  string  `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return webhook{}, "", fmt.Errorf("invalid webhook response: %w", err)
+	}
+	return created.Webhook, created.Secret, nil
+}
+
+func (c *apiClient) delete(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/api/webhooks/"+id, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete the webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete the webhook: API returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func splitEvents(value string) []string {
+	var events []string
+	for _, event := range strings.Split(value, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func main() {
+	opts, err := parseOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "webhook-echo:", err)
+		os.Exit(2)
+	}
+	if err := run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "webhook-echo:", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts options) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	echo := NewEcho(opts.secret, opts.tolerance, opts.sim, os.Stdout)
+	srv := &http.Server{Addr: opts.listen, Handler: echo, ReadHeaderTimeout: 10 * time.Second}
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- srv.ListenAndServe() }()
+
+	// Listening first, so deliveries of events right after registering
+	// arrive
+	if opts.secret == "" {
+		api := &apiClient{baseURL: opts.api, token: opts.token, client: &http.Client{Timeout: 10 * time.Second}}
+		registered, secret, err := api.register(ctx, opts.targetURL, splitEvents(opts.events))
+		if err != nil {
+			srv.Close()
+			return err
+		}
+		echo.SetSecret(secret)
+		fmt.Printf("registered webhook %s for %s (%s)\n", registered.ID, registered.URL, strings.Join(registered.Events, ", "))
+		fmt.Printf("secret %s\n", secret)
+		if !opts.keep {
+			defer func() {
+				cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := api.delete(cleanupCtx, registered.ID); err != nil {
+					fmt.Fprintln(os.Stderr, "webhook-echo:", err)
+					return
+				}
+				fmt.Printf("deleted webhook %s\n", registered.ID)
+			}()
+		}
+	}
+	fmt.Printf("listening on %s, waiting for deliveries (Ctrl-C to stop)\n", opts.listen)
+
+	select {
+	case err := <-listenErr:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
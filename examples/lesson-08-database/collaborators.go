@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Share permissions
+const (
+	ShareRead  = "read"
+	ShareWrite = "write"
+)
+
+// TaskCollaborator is a user a task has been shared with. Read shares can
+// view the task, write shares can also update it; only the owner can delete
+// it or change who it is shared with.
+type TaskCollaborator struct {
+	TaskID     TaskID    `json:"taskId"`
+	UserID     UserID    `json:"userId"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	CreatedBy  UserID    `json:"createdBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type ShareTaskRequest struct {
+	Email      string `json:"email"`
+	Permission string `json:"permission"`
+}
+
+type CollaboratorRepository interface {
+	// Upsert shares the task, or changes the permission of an existing share
+	Upsert(ctx context.Context, collaborator *TaskCollaborator) error
+	Remove(ctx context.Context, taskID TaskID, userID UserID) error
+	ListByTask(ctx context.Context, taskID TaskID) ([]*TaskCollaborator, error)
+	// Permission returns the user's share permission, empty when the task
+	// isn't shared with them
+	Permission(ctx context.Context, taskID TaskID, userID UserID) (string, error)
+}
+
+type collaboratorRepository struct {
+	db *sql.DB
+}
+
+func NewCollaboratorRepository(db *sql.DB) CollaboratorRepository {
+	return &collaboratorRepository{db: db}
+}
+
+func (r *collaboratorRepository) Upsert(ctx context.Context, collaborator *TaskCollaborator) error {
+	query := `
+		INSERT INTO task_collaborators (task_id, user_id, permission, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (task_id, user_id) DO UPDATE SET permission = EXCLUDED.permission
+		RETURNING created_by, created_at`
+
+	err := r.db.QueryRowContext(ctx, query,
+		collaborator.TaskID, collaborator.UserID, collaborator.Permission, collaborator.CreatedBy,
+	).Scan(&collaborator.CreatedBy, &collaborator.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to share task: %w", err)
+	}
+	return nil
+}
+
+func (r *collaboratorRepository) Remove(ctx context.Context, taskID TaskID, userID UserID) error {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM task_collaborators WHERE task_id = $1 AND user_id = $2`, taskID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove collaborator: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("collaborator not found")
+	}
+	return nil
+}
+
+func (r *collaboratorRepository) ListByTask(ctx context.Context, taskID TaskID) ([]*TaskCollaborator, error) {
+	query := `
+		SELECT tc.task_id, tc.user_id, u.email, tc.permission,
+		       COALESCE(tc.created_by::text, ''), tc.created_at
+		FROM task_collaborators tc
+		JOIN users u ON u.id = tc.user_id
+		WHERE tc.task_id = $1
+		ORDER BY tc.created_at`
+
+	rows, err := r.db.QueryContext(ctx, query, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collaborators: %w", err)
+	}
+	defer rows.Close()
+
+	var collaborators []*TaskCollaborator
+	for rows.Next() {
+		collaborator := &TaskCollaborator{}
+		if err := rows.Scan(&collaborator.TaskID, &collaborator.UserID, &collaborator.Email,
+			&collaborator.Permission, &collaborator.CreatedBy, &collaborator.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan collaborator: %w", err)
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+	return collaborators, rows.Err()
+}
+
+func (r *collaboratorRepository) Permission(ctx context.Context, taskID TaskID, userID UserID) (string, error) {
+	var permission string
+	err := r.db.QueryRowContext(ctx,
+		`SELECT permission FROM task_collaborators WHERE task_id = $1 AND user_id = $2`,
+		taskID, userID,
+	).Scan(&permission)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get share permission: %w", err)
+	}
+	return permission, nil
+}
+
+// authorizeTask checks the access policy for a task, taking into account
+// whether it has been shared with the requesting user.
+func (h *Handler) authorizeTask(w http.ResponseWriter, r *http.Request, action Action, task *Task) bool {
+	resource := taskResource(task)
+
+	subject := subjectFromContext(r.Context())
+	if h.collaboratorRepo != nil && subject.UserID != "" && subject.UserID != task.UserID {
+		permission, err := h.collaboratorRepo.Permission(r.Context(), task.ID, subject.UserID)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to evaluate access policy")
+			return false
+		}
+		resource.SharedAccess = permission
+	}
+
+	return h.authorize(w, r, action, resource)
+}
+
+// getTaskForAction loads the task named in the URL and checks the access
+// policy, responding with 404, 403 or 500 when the request can't go on.
+func (h *Handler) getTaskForAction(w http.ResponseWriter, r *http.Request, action Action) (*Task, bool) {
+	task, err := h.taskRepo.GetByID(r.Context(), TaskID(mux.Vars(r)["id"]))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Task not found")
+			return nil, false
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get task")
+		return nil, false
+	}
+
+	if !h.authorizeTask(w, r, action, task) {
+		return nil, false
+	}
+	return task, true
+}
+
+// GetTaskCollaborators lists who a task is shared with. Anyone who can read
+// the task can see its collaborators.
+func (h *Handler) GetTaskCollaborators(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionRead)
+	if !ok {
+		return
+	}
+
+	collaborators, err := h.collaboratorRepo.ListByTask(r.Context(), task.ID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get collaborators")
+		return
+	}
+
+	collaboratorList := make([]TaskCollaborator, len(collaborators))
+	for i, collaborator := range collaborators {
+		collaboratorList[i] = *collaborator
+	}
+
+	setSurrogateKeys(w, taskSurrogateKey(task.ID))
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"collaborators": collaboratorList,
+		"count":         len(collaboratorList),
+	})
+}
+
+// ShareTask shares a task with another user by email, or changes the
+// permission of an existing share. Only the owner can share.
+func (h *Handler) ShareTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionShare)
+	if !ok {
+		return
+	}
+
+	var req ShareTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Permission == "" {
+		req.Permission = ShareRead
+	}
+	if req.Permission != ShareRead && req.Permission != ShareWrite {
+		h.respondWithError(w, http.StatusBadRequest, "Permission must be read or write")
+		return
+	}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), strings.TrimSpace(req.Email))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	if user.ID == task.UserID {
+		h.respondWithError(w, http.StatusBadRequest, "Task owner can't be added as a collaborator")
+		return
+	}
+
+	userID := UserID(r.Context().Value("user_id").(string))
+	collaborator := &TaskCollaborator{
+		TaskID:     task.ID,
+		UserID:     user.ID,
+		Email:      user.Email,
+		Permission: req.Permission,
+		CreatedBy:  userID,
+	}
+	if err := h.collaboratorRepo.Upsert(r.Context(), collaborator); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to share task")
+		return
+	}
+	h.cacheInvalidator.Invalidate(taskSurrogateKey(task.ID), userSurrogateKey(user.ID))
+	h.recordAudit(r, &AuditEvent{
+		UserID:     userID,
+		Action:     AuditTaskShare,
+		TargetType: "task",
+		TargetID:   task.ID.String(),
+		Metadata:   map[string]interface{}{"collaboratorId": user.ID, "permission": req.Permission},
+	})
+
+	h.respondWithJSON(w, http.StatusOK, collaborator)
+}
+
+// UnshareTask removes a collaborator. The owner can remove anyone; a
+// collaborator can remove themselves.
+func (h *Handler) UnshareTask(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+	collaboratorID := UserID(mux.Vars(r)["userId"])
+
+	action := ActionShare
+	if collaboratorID == userID {
+		action = ActionRead
+	}
+	task, ok := h.getTaskForAction(w, r, action)
+	if !ok {
+		return
+	}
+
+	if err := h.collaboratorRepo.Remove(r.Context(), task.ID, collaboratorID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Collaborator not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to remove collaborator")
+		return
+	}
+	h.cacheInvalidator.Invalidate(taskSurrogateKey(task.ID), userSurrogateKey(collaboratorID))
+	h.recordAudit(r, &AuditEvent{
+		UserID:     userID,
+		Action:     AuditTaskUnshare,
+		TargetType: "task",
+		TargetID:   task.ID.String(),
+		Metadata:   map[string]interface{}{"collaboratorId": collaboratorID},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func taskRequest(method, path, token, body string, vars map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return mux.SetURLVars(req, vars)
+}
+
+func (env *testEnv) shareTask(owner LoginResponse, taskID TaskID, email, permission string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ShareTaskRequest{Email: email, Permission: permission})
+	req := taskRequest(http.MethodPost, "/api/tasks/"+taskID.String()+"/collaborators", owner.Token, string(body),
+		map[string]string{"id": taskID.String()})
+	return env.serveWithAuth(env.handler.ShareTask, req)
+}
+
+func TestTaskSharing(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	owner := env.registerTestUser(t, "owner@example.com")
+	collaborator := env.registerTestUser(t, "collaborator@example.com")
+
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Shared task", Priority: "medium"}, owner.User.ID)
+	require.NoError(t, err)
+	vars := map[string]string{"id": task.ID.String()}
+
+	get := func() int {
+		req := taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String(), collaborator.Token, "", vars)
+		return env.serveWithAuth(env.handler.GetTask, req).Code
+	}
+	update := func() int {
+		req := taskRequest(http.MethodPut, "/api/tasks/"+task.ID.String(), collaborator.Token, `{"completed": true}`, vars)
+		return env.serveWithAuth(env.handler.UpdateTask, req).Code
+	}
+	listTasks := func(query string) TaskListResponse {
+		req := taskRequest(http.MethodGet, "/api/tasks?"+query, collaborator.Token, "", nil)
+		w := env.serveWithAuth(env.handler.GetTasks, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	assert.Equal(t, http.StatusForbidden, get(), "not shared yet")
+	assert.Empty(t, listTasks("").Tasks)
+
+	// Read share: visible and listed, but not writable
+	require.Equal(t, http.StatusOK, env.shareTask(owner, task.ID, "collaborator@example.com", ShareRead).Code)
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, http.StatusForbidden, update())
+	shared := listTasks("")
+	require.Len(t, shared.Tasks, 1)
+	assert.Equal(t, task.ID, shared.Tasks[0].ID)
+	assert.EqualValues(t, 1, shared.TotalCount)
+	assert.Empty(t, listTasks("shared=false").Tasks)
+
+	// Collaborators can't re-share or delete
+	assert.Equal(t, http.StatusForbidden, env.shareTask(collaborator, task.ID, "owner@example.com", ShareWrite).Code)
+	req := taskRequest(http.MethodDelete, "/api/tasks/"+task.ID.String(), collaborator.Token, "", vars)
+	assert.Equal(t, http.StatusForbidden, env.serveWithAuth(env.handler.DeleteTask, req).Code)
+
+	// Upgrading to a write share allows updates
+	require.Equal(t, http.StatusOK, env.shareTask(owner, task.ID, "collaborator@example.com", ShareWrite).Code)
+	assert.Equal(t, http.StatusOK, update())
+
+	req = taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/collaborators", owner.Token, "", vars)
+	w := env.serveWithAuth(env.handler.GetTaskCollaborators, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Collaborators []TaskCollaborator `json:"collaborators"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Collaborators, 1)
+	assert.Equal(t, collaborator.User.ID, response.Collaborators[0].UserID)
+	assert.Equal(t, ShareWrite, response.Collaborators[0].Permission)
+
+	// Collaborators can leave a shared task
+	req = taskRequest(http.MethodDelete, "/api/tasks/"+task.ID.String()+"/collaborators/"+collaborator.User.ID.String(),
+		collaborator.Token, "", map[string]string{"id": task.ID.String(), "userId": collaborator.User.ID.String()})
+	assert.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.UnshareTask, req).Code)
+	assert.Equal(t, http.StatusForbidden, get())
+}
+
+func TestShareTaskValidation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	owner := env.registerTestUser(t, "sharer@example.com")
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Private task", Priority: "low"}, owner.User.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotFound, env.shareTask(owner, task.ID, "nobody@example.com", ShareRead).Code)
+	assert.Equal(t, http.StatusBadRequest, env.shareTask(owner, task.ID, "sharer@example.com", ShareRead).Code)
+	assert.Equal(t, http.StatusBadRequest, env.shareTask(owner, task.ID, "sharer@example.com", "admin").Code)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run with -update-compat to regenerate the golden files after an
+// intentional, documented change to a request struct.
+var updateCompat = flag.Bool("update-compat", false, "rewrite request compatibility golden files")
+
+// compatCase describes a request payload captured from a previous API
+// version and the struct the current server decodes it into.
+type compatCase struct {
+	version string
+	file    string
+	target  func() interface{}
+}
+
+var compatCases = []compatCase{
+	{"v1", "register_request.json", func() interface{} { return &RegisterRequest{} }},
+	{"v1", "login_request.json", func() interface{} { return &LoginRequest{} }},
+	{"v1", "create_task_request.json", func() interface{} { return &CreateTaskRequest{} }},
+	{"v1", "create_task_request_minimal.json", func() interface{} { return &CreateTaskRequest{} }},
+	{"v1", "update_task_request.json", func() interface{} { return &UpdateTaskRequest{} }},
+	{"v1", "update_task_request_due_date.json", func() interface{} { return &UpdateTaskRequest{} }},
+}
+
+func compatPath(version, file string) string {
+	return filepath.Join("testdata", "compat", version, file)
+}
+
+func goldenPath(version, file string) string {
+	return compatPath(version, strings.TrimSuffix(file, ".json")+".golden.json")
+}
+
+// decodeCompatPayload decodes a stored payload strictly, so a renamed or
+// removed field shows up as an error instead of being silently dropped.
+func decodeCompatPayload(t *testing.T, payload []byte, target interface{}) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	require.NoError(t, decoder.Decode(target), "stored payload no longer decodes")
+}
+
+func TestRequestSchemaCompatibility(t *testing.T) {
+	for _, tc := range compatCases {
+		tc := tc
+		t.Run(tc.version+"/"+tc.file, func(t *testing.T) {
+			payload, err := os.ReadFile(compatPath(tc.version, tc.file))
+			require.NoError(t, err)
+
+			decoded := tc.target()
+			decodeCompatPayload(t, payload, decoded)
+
+			actual, err := json.MarshalIndent(decoded, "", "  ")
+			require.NoError(t, err)
+			actual = append(actual, '\n')
+
+			golden := goldenPath(tc.version, tc.file)
+			if *updateCompat {
+				require.NoError(t, os.WriteFile(golden, actual, 0644))
+				return
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err, "missing golden file, run with -update-compat")
+			assert.JSONEq(t, string(expected), string(actual),
+				"decoded %s differs from the previous version", tc.file)
+		})
+	}
+}
+
+func TestRequestSchemaCompatibility_CreateTaskBehavior(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	token := env.createTestUserAndGetToken(t, "compat@example.com")
+
+	for _, file := range []string{"create_task_request.json", "create_task_request_minimal.json"} {
+		t.Run(file, func(t *testing.T) {
+			payload, err := os.ReadFile(compatPath("v1", file))
+			require.NoError(t, err)
+
+			var expected CreateTaskRequest
+			decodeCompatPayload(t, payload, &expected)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/tasks", bytes.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+
+			env.handler.CreateTask(w, req)
+			require.Equal(t, http.StatusCreated, w.Code)
+
+			var task TaskResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+			assert.Equal(t, expected.Title, task.Title)
+			assert.Equal(t, expected.Description, task.Description)
+			if expected.Priority == "" {
+				assert.Equal(t, "medium", task.Priority)
+			} else {
+				assert.Equal(t, expected.Priority, task.Priority)
+			}
+			assert.Len(t, task.Categories, len(expected.CategoryNames))
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultCompressionMinBytes is about one TCP segment: smaller bodies
+	// go out in one packet anyway, and compressing them costs CPU and
+	// gzip's 18 bytes of framing for nothing
+	defaultCompressionMinBytes = 1024
+
+	// defaultCompressionLevel is flate's default, level 6
+	defaultCompressionLevel = -1
+
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// CompressionConfig configures response compression. Level is a
+// compress/flate level, from 1 (fastest) to 9 (smallest), or -1 for the
+// default of 6.
+type CompressionConfig struct {
+	Enabled  bool
+	MinBytes int
+	Level    int
+}
+
+// incompressibleTypes are media types, or type prefixes ending in "/",
+// whose content is already compressed: compressing it again costs CPU and
+// usually makes it a little larger.
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/x-bzip2",
+	"application/pdf", "application/octet-stream",
+}
+
+// Compressor compresses responses with gzip or deflate, whichever the
+// client's Accept-Encoding prefers. Responses stay uncompressed when they
+// are smaller than MinBytes, already compressed or encoded, have no body
+// (HEAD, 204, 304), are a byte range, or are Cache-Control: no-store: those
+// carry tokens and secrets, and compressing a secret next to input an
+// attacker controls lets them guess it from the response size (BREACH).
+//
+// ETags are kept: a task's ETag names its version, not the bytes, and
+// If-Match on writes must keep working whichever encoding the client read
+// it in. Vary: Accept-Encoding keeps the encodings apart in caches.
+type Compressor struct {
+	minBytes int
+	gzip     sync.Pool
+	zlib     sync.Pool
+}
+
+func NewCompressor(config CompressionConfig) (*Compressor, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, config.Level); err != nil {
+		return nil, fmt.Errorf("invalid compression level %d", config.Level)
+	}
+	c := &Compressor{minBytes: config.MinBytes}
+	c.gzip.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, config.Level)
+		return w
+	}
+	c.zlib.New = func() interface{} {
+		w, _ := zlib.NewWriterLevel(io.Discard, config.Level)
+		return w
+	}
+	return c, nil
+}
+
+// Middleware compresses the responses of the handlers it wraps.
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Whether a response is compressed depends on Accept-Encoding even
+		// when this one isn't, e.g. because it is small
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding, by q-value
+// and preferring gzip on a tie; "*" stands for both. Empty means identity.
+func negotiateEncoding(accept []string) string {
+	q := map[string]float64{}
+	for _, value := range accept {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			weight := 1.0
+			if raw, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+					weight = parsed
+				}
+			}
+			q[name] = weight
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		weight, ok := q[encoding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = encoding, weight
+		}
+	}
+	return best
+}
+
+// compressWriter holds back the start of the body until it is clear
+// whether the response is worth compressing: once MinBytes have been
+// written, the handler flushes, or the handler finished.
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+	encoding   string
+
+	status      int
+	wroteHeader bool
+	// decided is set once the headers went out, compressed or not
+	decided bool
+	pending []byte
+	encoder interface {
+		io.WriteCloser
+		Reset(io.Writer)
+		Flush() error
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code < 200 {
+		// Informational responses precede the real one
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	if !cw.compressible() {
+		cw.decide(false)
+		return
+	}
+	if length, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && length < cw.compressor.minBytes {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			// net/http would sniff the compressed bytes otherwise
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.pending = append(cw.pending, b...)
+		if len(cw.pending) < cw.compressor.minBytes {
+			return len(b), nil
+		}
+		cw.decide(true)
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// compressible reports whether the response may be compressed at all.
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified ||
+		cw.status == http.StatusPartialContent || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-store") {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// Sniffed from the body when it is compressed
+		return true
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, t := range incompressibleTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return false
+		}
+	}
+	return true
+}
+
+// decide sends the headers, compressed or not, and whatever was held back.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.pending))
+		}
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == encodingGzip {
+			cw.encoder = cw.compressor.gzip.Get().(*gzip.Writer)
+		} else {
+			cw.encoder = cw.compressor.zlib.Get().(*zlib.Writer)
+		}
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.pending) > 0 {
+		if cw.encoder != nil {
+			cw.encoder.Write(cw.pending)
+		} else {
+			cw.ResponseWriter.Write(cw.pending)
+		}
+	}
+	cw.pending = nil
+}
+
+// Flush sends what was written so far, compressed, to streaming clients.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close ends the response: small bodies go out uncompressed, compressed
+// ones get their trailer.
+func (cw *compressWriter) Close() {
+	if cw.wroteHeader && !cw.decided {
+		cw.decide(false)
+	}
+	if cw.encoder == nil {
+		return
+	}
+	cw.encoder.Close()
+	switch encoder := cw.encoder.(type) {
+	case *gzip.Writer:
+		cw.compressor.gzip.Put(encoder)
+	case *zlib.Writer:
+		cw.compressor.zlib.Put(encoder)
+	}
+	cw.encoder = nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
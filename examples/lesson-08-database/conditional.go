@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"httpcond"
+)
+
+// taskValidators describe a task's JSON representation. The ETag is derived
+// from the exact bytes GetTask returns in v1, so it is a strong validator
+// that If-Match can compare. v2 uses the same ETag: it identifies the task's
+// version, so a client can read a task in one API version and update it in
+// another.
+func taskValidators(task *Task) httpcond.Validators {
+	return taskResponseValidators(newTaskResponse(task))
+}
+
+// taskResponseValidators covers a response that embeds more than the task,
+// such as its enrichment.
+func taskResponseValidators(response TaskResponse) httpcond.Validators {
+	body, _ := json.Marshal(response)
+	return httpcond.Validators{ETag: httpcond.ForContent(body), LastModified: response.UpdatedAt}
+}
+
+// checkIfMatchPresent makes If-Match mandatory when requireIfMatch is set.
+// Without it a client that never read the task, or ignores its ETag, would
+// still overwrite other clients' changes (last write wins).
+func (h *Handler) checkIfMatchPresent(w http.ResponseWriter, r *http.Request) bool {
+	if !h.requireIfMatch || r.Header.Get("If-Match") != "" {
+		return true
+	}
+	h.respondWithErrorCode(w, http.StatusPreconditionRequired, "precondition_required",
+		"Send If-Match with the task's ETag to update or delete it")
+	return false
+}
+
+// respondWithTaskWriteError maps the errors of a versioned task write. The
+// precondition check passed, but another write committed before this one.
+func (h *Handler) respondWithTaskWriteError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ErrTaskModified):
+		h.respondWithErrorCode(w, http.StatusPreconditionFailed, "precondition_failed",
+			"The resource has changed; fetch it again and retry with the new ETag")
+	case strings.Contains(err.Error(), "not found"):
+		h.respondWithError(w, http.StatusNotFound, "Task not found")
+	default:
+		h.respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
+
+// checkPreconditions evaluates If-Match, If-None-Match, If-Modified-Since and
+// If-Unmodified-Since against the current representation. It returns false
+// after answering 304, or 412 when a write is based on a stale version.
+func (h *Handler) checkPreconditions(w http.ResponseWriter, r *http.Request, validators httpcond.Validators) bool {
+	validators.SetHeaders(w.Header())
+
+	switch httpcond.Evaluate(r, validators) {
+	case httpcond.NotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	case httpcond.PreconditionFailed:
+		h.respondWithErrorCode(w, http.StatusPreconditionFailed, "precondition_failed",
+			"The resource has changed; fetch it again and retry with the new ETag")
+		return false
+	}
+	return true
+}
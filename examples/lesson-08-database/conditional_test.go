@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"httpcond"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	handler := &Handler{}
+	task := &Task{ID: "task-1", Title: "Write docs", UpdatedAt: time.Now()}
+	etag := taskValidators(task).ETag.String()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/task-1", nil)
+	req.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	assert.False(t, handler.checkPreconditions(w, req, taskValidators(task)))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	// Any change to the task changes its ETag
+	changed := *task
+	changed.Completed = true
+	assert.NotEqual(t, etag, taskValidators(&changed).ETag.String())
+
+	req = httptest.NewRequest(http.MethodPut, "/api/tasks/task-1", nil)
+	req.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	assert.False(t, handler.checkPreconditions(w, req, taskValidators(&changed)))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "precondition_failed", errorCode(t, w))
+}
+
+func TestCheckIfMatchPresent(t *testing.T) {
+	handler := &Handler{}
+	req := httptest.NewRequest(http.MethodPut, "/api/tasks/task-1", nil)
+	assert.True(t, handler.checkIfMatchPresent(httptest.NewRecorder(), req), "optional by default")
+
+	handler.requireIfMatch = true
+	w := httptest.NewRecorder()
+	assert.False(t, handler.checkIfMatchPresent(w, req))
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	assert.Equal(t, "precondition_required", errorCode(t, w))
+
+	req.Header.Set("If-Match", `"v1"`)
+	assert.True(t, handler.checkIfMatchPresent(httptest.NewRecorder(), req))
+}
+
+func TestTaskConditionalRequests(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	registered := env.registerTestUser(t, "conditional@example.com")
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Versioned task", Priority: "low"}, registered.User.ID)
+	require.NoError(t, err)
+
+	request := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/tasks/"+task.ID.String(), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+registered.Token)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": task.ID.String()})
+		switch method {
+		case http.MethodGet:
+			return env.serveWithAuth(env.handler.GetTask, req)
+		case http.MethodPut:
+			return env.serveWithAuth(env.handler.UpdateTask, req)
+		}
+		return env.serveWithAuth(env.handler.DeleteTask, req)
+	}
+
+	w := request(http.MethodGet, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	_, err = httpcond.ParseETag(etag)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotModified, request(http.MethodGet, "", map[string]string{"If-None-Match": etag}).Code)
+
+	w = request(http.MethodPut, `{"completed": true}`, map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusOK, w.Code)
+	newETag := w.Header().Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+
+	// A second writer still holding the old ETag must not overwrite the change
+	w = request(http.MethodPut, `{"completed": false}`, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, http.StatusPreconditionFailed, request(http.MethodDelete, "", map[string]string{"If-Match": etag}).Code)
+
+	// With If-Match required, blind writes are refused
+	env.handler.requireIfMatch = true
+	w = request(http.MethodPut, `{"completed": false}`, nil)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	assert.Equal(t, "precondition_required", errorCode(t, w))
+
+	assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "", map[string]string{"If-Match": newETag}).Code)
+}
+
+// TestTaskVersionedWrites covers writers that both pass the If-Match check
+// before either commits: only the first write may succeed.
+func TestTaskVersionedWrites(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	registered := env.registerTestUser(t, "versioned@example.com")
+	ctx := context.Background()
+	created, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+		CreateTaskRequest{Title: "Contended task", Priority: "low"}, registered.User.ID)
+	require.NoError(t, err)
+
+	first, err := env.handler.taskRepo.GetByID(ctx, created.ID)
+	require.NoError(t, err)
+	second, err := env.handler.taskRepo.GetByID(ctx, created.ID)
+	require.NoError(t, err)
+
+	first.Title = "First writer"
+	require.NoError(t, env.handler.taskRepo.Update(ctx, first))
+
+	second.Title = "Second writer"
+	assert.ErrorIs(t, env.handler.taskRepo.Update(ctx, second), ErrTaskModified)
+	assert.ErrorIs(t, env.handler.taskRepo.Delete(ctx, second), ErrTaskModified)
+
+	stored, err := env.handler.taskRepo.GetByID(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "First writer", stored.Title)
+
+	require.NoError(t, env.handler.taskRepo.Delete(ctx, stored))
+	assert.ErrorContains(t, env.handler.taskRepo.Delete(ctx, stored), "not found")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryableTxError(t *testing.T) {
+	for code, want := range map[pq.ErrorCode]bool{
+		"40001": true,  // serialization_failure
+		"40P01": true,  // deadlock_detected
+		"23505": true,  // unique_violation
+		"23503": false, // foreign_key_violation
+		"42P01": false, // undefined_table
+	} {
+		assert.Equal(t, want, retryableTxError(&pq.Error{Code: code}), code)
+		assert.Equal(t, want, retryableTxError(fmt.Errorf("failed to create: %w", &pq.Error{Code: code})), code)
+	}
+	assert.False(t, retryableTxError(errors.New("task not found")))
+	assert.False(t, retryableTxError(sql.ErrNoRows))
+}
+
+// runConcurrently runs both transactions at once. Each first attempt stops
+// at barrier() until the other has reached it too, so they are guaranteed
+// to overlap; retries run straight through.
+func runConcurrently(t *testing.T, fns ...func(barrier func()) error) {
+	var reached sync.WaitGroup
+	reached.Add(len(fns))
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn func(barrier func()) error) {
+			defer wg.Done()
+			var once sync.Once
+			errs[i] = fn(func() {
+				once.Do(func() {
+					reached.Done()
+					reached.Wait()
+				})
+			})
+		}(i, fn)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestTransactionDeadlockIsRetried(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.registerTestUser(t, "deadlock@example.com")
+	work := &Category{ID: NewID[categoryEntity](), Name: "work", Color: "#000000", UserID: user.User.ID}
+	home := &Category{ID: NewID[categoryEntity](), Name: "home", Color: "#000000", UserID: user.User.ID}
+	require.NoError(t, env.handler.categoryRepo.Create(ctx, work))
+	require.NoError(t, env.handler.categoryRepo.Create(ctx, home))
+
+	// Two transactions recolor both categories, locking them in opposite
+	// order: the anti-pattern WithTransactionContext's comment warns about.
+	// Postgres aborts one with a deadlock; its retry runs after the other
+	// commits.
+	var attempts atomic.Int32
+	recolor := func(first, second *Category, color string) func(barrier func()) error {
+		return func(barrier func()) error {
+			return WithTransactionContext(ctx, env.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+				attempts.Add(1)
+				if _, err := tx.ExecContext(ctx, "UPDATE categories SET color = $2 WHERE id = $1", first.ID, color); err != nil {
+					return err
+				}
+				barrier()
+				_, err := tx.ExecContext(ctx, "UPDATE categories SET color = $2 WHERE id = $1", second.ID, color)
+				return err
+			})
+		}
+	}
+	runConcurrently(t, recolor(work, home, "#111111"), recolor(home, work, "#222222"))
+	assert.Equal(t, int32(3), attempts.Load(), "one transaction should have been retried once")
+
+	// Each transaction applied as a whole, so both have the color of the last
+	categories, err := env.handler.categoryRepo.GetByUserID(ctx, user.User.ID)
+	require.NoError(t, err)
+	require.Len(t, categories, 2)
+	assert.Equal(t, categories[0].Color, categories[1].Color)
+}
+
+func TestTransactionSerializationFailureIsRetried(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.registerTestUser(t, "serializable@example.com")
+	task, err := env.handler.taskService.CreateTaskWithCategories(ctx, CreateTaskRequest{Title: "Draft", Priority: "medium"}, user.User.ID)
+	require.NoError(t, err)
+
+	// Both read the title, then write it back with a suffix. Under
+	// SERIALIZABLE the second writer fails instead of losing the first
+	// update; run again, it reads the first's title.
+	var attempts atomic.Int32
+	appendTitle := func(suffix string) func(barrier func()) error {
+		return func(barrier func()) error {
+			return WithTransactionContext(ctx, env.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+				attempts.Add(1)
+				if _, err := tx.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"); err != nil {
+					return err
+				}
+				var title string
+				if err := tx.QueryRowContext(ctx, "SELECT title FROM tasks WHERE id = $1", task.ID).Scan(&title); err != nil {
+					return err
+				}
+				barrier()
+				_, err := tx.ExecContext(ctx, "UPDATE tasks SET title = $2 WHERE id = $1", task.ID, title+suffix)
+				return err
+			})
+		}
+	}
+	runConcurrently(t, appendTitle(" (a)"), appendTitle(" (b)"))
+	assert.Equal(t, int32(3), attempts.Load(), "one transaction should have been retried once")
+
+	updated, err := env.handler.taskRepo.GetByID(ctx, task.ID)
+	require.NoError(t, err)
+	assert.Contains(t, []string{"Draft (a) (b)", "Draft (b) (a)"}, updated.Title)
+}
+
+func TestConcurrentTaskCreationSharingNewCategories(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "shared-categories@example.com")
+
+	// Every request creates the same three categories, listed in different
+	// orders. The losers of each insert race are retried and find the
+	// winner's category; none of them may surface as a 500.
+	orders := [][]string{
+		{"alpha", "beta", "gamma"},
+		{"gamma", "beta", "alpha"},
+		{"beta", "alpha", "gamma"},
+		{"gamma", "alpha", "beta"},
+	}
+	const requests = 8
+	codes := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"title": "Task %d", "priority": "medium", "categoryNames": ["%s", "%s", "%s"]}`,
+				i, orders[i%len(orders)][0], orders[i%len(orders)][1], orders[i%len(orders)][2])
+			req := taskRequest(http.MethodPost, "/api/tasks", user.Token, body, nil)
+			codes[i] = env.serveWithAuth(env.handler.CreateTask, req).Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		assert.Equal(t, http.StatusCreated, code, "request %d", i)
+	}
+	tasks, categories, links := env.countUserRows(t, user.User.ID)
+	assert.Equal(t, []int{requests, 3, 3 * requests}, []int{tasks, categories, links})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"correlation"
+)
+
+// Browsers only let scripts on other origins call the API when the responses
+// say so. Allowed origins get their own origin echoed back (or "*" when any
+// origin is allowed and no credentials are), the headers they may read, and
+// for preflights the methods and headers they may send. Disallowed origins get
+// no CORS headers at all, so the browser keeps the response from the script.
+//
+// The middleware wraps the whole router rather than being registered with
+// router.Use, which only runs for matched routes: preflights are OPTIONS
+// requests no route accepts, and 404s and 405s need the headers too for the
+// client to read them.
+const (
+	defaultCORSMaxAge = 10 * time.Minute
+
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE"
+)
+
+var (
+	// corsAllowedHeaders are the request headers the API reads
+	corsAllowedHeaders = []string{
+		"Authorization", "Content-Type", "If-Match", "If-None-Match", "X-API-Key", "X-Field-Case",
+		idempotencyKeyHeader, requestTimeoutHeader, requestTimeoutAltHeader, canaryHeader, challengeHeader,
+		correlation.RequestIDHeader,
+	}
+	// corsExposedHeaders are the response headers clients need beyond the
+	// ones browsers always expose
+	corsExposedHeaders = []string{
+		"ETag", "Location", "Retry-After", "Content-Disposition", "WWW-Authenticate",
+		idempotentReplayedHeader, canaryVariantHeader, environmentHeader,
+		APIVersionHeader, "Deprecation", "Sunset", "Link", correlation.RequestIDHeader,
+	}
+)
+
+type CORSConfig struct {
+	// AllowedOrigins are origins such as "https://app.example.com"; "*"
+	// allows any
+	AllowedOrigins []string
+	// AllowCredentials lets browsers send cookies and HTTP auth; it needs
+	// explicit origins
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight; 0 leaves it to them
+	MaxAge time.Duration
+}
+
+type CORS struct {
+	config  CORSConfig
+	any     bool
+	origins map[string]bool
+}
+
+// NewCORS checks the configured origins, which must be "*" or a scheme and
+// host without a path.
+func NewCORS(config CORSConfig) (*CORS, error) {
+	c := &CORS{config: config, origins: make(map[string]bool)}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			c.any = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			return nil, fmt.Errorf("invalid CORS origin %q: must be a scheme and host such as https://app.example.com", origin)
+		}
+		c.origins[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+	if c.any && config.AllowCredentials {
+		return nil, fmt.Errorf("CORS credentials can't be allowed for any origin; list the origins instead of \"*\"")
+	}
+	return c, nil
+}
+
+func (c *CORS) allows(origin string) bool {
+	return c.any || c.origins[strings.ToLower(origin)]
+}
+
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// Unless any origin gets "*", the headers depend on the origin and
+		// caches must keep a response per origin
+		if !c.any || c.config.AllowCredentials {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.allows(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.any && !c.config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			if c.config.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.config.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCORS(t *testing.T) {
+	for _, origins := range [][]string{
+		{"https://app.example.com/"},
+		{"*", "http://localhost:3000"},
+	} {
+		_, err := NewCORS(CORSConfig{AllowedOrigins: origins})
+		assert.NoError(t, err, origins)
+	}
+
+	for _, invalid := range []CORSConfig{
+		{AllowedOrigins: []string{"app.example.com"}},
+		{AllowedOrigins: []string{"https://app.example.com/app"}},
+		{AllowedOrigins: []string{"ftp://app.example.com"}},
+		// Any site could then make requests with the user's cookies
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+	} {
+		_, err := NewCORS(invalid)
+		assert.Error(t, err, "%+v", invalid)
+	}
+}
+
+// TestCORS sends browser requests through the router main serves, so a
+// middleware registered in the wrong place shows up as missing headers on
+// preflights, errors or unmatched routes.
+func TestCORS(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "cors@example.com")
+
+	const app = "https://app.example.com"
+	config := loadConfig()
+	config.CORS = CORSConfig{AllowedOrigins: []string{app, "http://localhost:3000"}, AllowCredentials: true, MaxAge: time.Hour}
+	router, err := newRouter(config, env.handler, env.db)
+	require.NoError(t, err)
+
+	serve := func(method, path, origin string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	preflight := func(path, origin, method string, headers ...string) *httptest.ResponseRecorder {
+		return serve(http.MethodOptions, path, origin, http.Header{
+			"Access-Control-Request-Method":  {method},
+			"Access-Control-Request-Headers": {strings.Join(headers, ",")},
+		})
+	}
+	bearer := http.Header{"Authorization": {"Bearer " + user.Token}}
+
+	t.Run("preflight", func(t *testing.T) {
+		// Routes only accept their own methods, so OPTIONS never matches one
+		for _, path := range []string{"/api/tasks/1/priority", "/api/v1/tasks/1/priority", "/api/no-such-route"} {
+			w := preflight(path, app, http.MethodPatch, "authorization", "content-type", "if-match", "idempotency-key")
+			assert.Equal(t, http.StatusNoContent, w.Code, path)
+			assert.Equal(t, app, w.Header().Get("Access-Control-Allow-Origin"), path)
+			assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"), path)
+			assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPatch, path)
+			for _, name := range []string{"Authorization", "If-Match", idempotencyKeyHeader} {
+				assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), name, path)
+			}
+			assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"), path)
+			assert.Contains(t, w.Header().Values("Vary"), "Origin", path)
+		}
+	})
+
+	t.Run("preflight from a disallowed origin", func(t *testing.T) {
+		for _, origin := range []string{"https://evil.example.com", "https://app.example.com.evil.com", "http://app.example.com"} {
+			w := preflight("/api/tasks", origin, http.MethodPost, "authorization")
+			assert.Equal(t, http.StatusForbidden, w.Code, origin)
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"), origin)
+		}
+	})
+
+	t.Run("credentialed requests", func(t *testing.T) {
+		req := taskRequest(http.MethodPost, "/api/tasks", user.Token, `{"title": "Cross-origin", "priority": "low"}`, nil)
+		req.Header.Set("Origin", app)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var task TaskResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+
+		w = serve(http.MethodGet, "/api/tasks/"+task.ID.String(), "http://localhost:3000", bearer)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		// The origin is echoed as sent; "*" isn't allowed with credentials
+		assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, w.Header().Values("Vary"), "Origin")
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+
+		exposed := strings.Split(w.Header().Get("Access-Control-Expose-Headers"), ", ")
+		for _, name := range []string{"ETag", "Location", "Retry-After", idempotentReplayedHeader, canaryVariantHeader} {
+			assert.Contains(t, exposed, name)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		// The client can only read why a request failed with CORS headers
+		for path, code := range map[string]int{
+			"/api/tasks":         http.StatusUnauthorized,
+			"/api/no-such-route": http.StatusNotFound,
+		} {
+			w := serve(http.MethodGet, path, app, nil)
+			assert.Equal(t, code, w.Code, path)
+			assert.Equal(t, app, w.Header().Get("Access-Control-Allow-Origin"), path)
+			assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"), path)
+		}
+		w := serve(http.MethodDelete, "/api/meta/enums", app, nil)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, app, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		// The request is served, but the browser keeps the response from the
+		// script
+		w := serve(http.MethodGet, "/api/tasks", "https://evil.example.com", bearer)
+		assert.Equal(t, http.StatusOK, w.Code)
+		for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+			assert.Empty(t, w.Header().Get(name), name)
+		}
+		assert.Contains(t, w.Header().Values("Vary"), "Origin")
+	})
+
+	t.Run("same-origin requests", func(t *testing.T) {
+		w := serve(http.MethodGet, "/api/meta/enums", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		// Still varies, so a cache doesn't serve this to a cross-origin request
+		assert.Contains(t, w.Header().Values("Vary"), "Origin")
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		config := loadConfig()
+		config.CORS = CORSConfig{AllowedOrigins: []string{"*"}}
+		router, err := newRouter(config, env.handler, env.db)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodOptions, "/api/tasks", nil)
+		req.Header.Set("Origin", "https://anywhere.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Empty(t, w.Header().Values("Vary"))
+		// Without a MaxAge browsers cache preflights for their default time
+		assert.Empty(t, w.Header().Get("Access-Control-Max-Age"))
+	})
+}
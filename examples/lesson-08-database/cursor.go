@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TaskCursor marks a position in a task list ordered newest first. Pages
+// continue after the cursor with a keyset condition on (created_at, id), so
+// the database seeks straight to the position instead of reading and
+// discarding every row before an offset, and inserts between requests don't
+// shift rows onto the next page twice.
+type TaskCursor struct {
+	CreatedAt time.Time
+	ID        TaskID
+}
+
+func taskCursorAfter(task *Task) *TaskCursor {
+	return &TaskCursor{CreatedAt: task.CreatedAt, ID: task.ID}
+}
+
+// Encode returns the opaque form clients pass back in ?cursor=.
+func (c *TaskCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTaskCursor(value string) (*TaskCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	taskID, err := ParseID[taskEntity](id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &TaskCursor{CreatedAt: t, ID: taskID}, nil
+}
+
+// keysetCondition selects the rows after the cursor in created_at DESC, id
+// DESC order; argIndex is the placeholder of the cursor's created_at, the ID
+// follows it.
+func keysetCondition(table string, argIndex int) string {
+	return fmt.Sprintf("(%[1]s.created_at, %[1]s.id) < ($%[2]d, $%[3]d)", table, argIndex, argIndex+1)
+}
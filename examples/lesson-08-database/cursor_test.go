@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskCursorRoundTrip(t *testing.T) {
+	cursor := &TaskCursor{
+		CreatedAt: time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.FixedZone("CET", 3600)),
+		ID:        "0b8a8f4e-4f5c-4d7e-9b1a-2f3c4d5e6f70",
+	}
+	decoded, err := decodeTaskCursor(cursor.Encode())
+	require.NoError(t, err)
+	assert.True(t, cursor.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, cursor.ID, decoded.ID)
+
+	for _, value := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("2024-03-01T12:30:00Z")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday|0b8a8f4e-4f5c-4d7e-9b1a-2f3c4d5e6f70")),
+		base64.RawURLEncoding.EncodeToString([]byte("2024-03-01T12:30:00Z|1; DROP TABLE tasks")),
+	} {
+		_, err := decodeTaskCursor(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestTaskCursorPagination(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "cursor@example.com")
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+			CreateTaskRequest{Title: fmt.Sprintf("Task %d", i), Priority: "medium"}, user.User.ID)
+		require.NoError(t, err)
+	}
+	// Two tasks with the same created_at, so the ID must break the tie
+	_, err := env.db.DB.ExecContext(ctx, `UPDATE tasks SET created_at = '2024-01-01T00:00:00Z' WHERE title IN ('Task 1', 'Task 2')`)
+	require.NoError(t, err)
+
+	listPage := func(query url.Values) TaskListResponse {
+		req := taskRequest(http.MethodGet, "/api/tasks?"+query.Encode(), user.Token, "", nil)
+		w := env.serveWithAuth(env.handler.GetTasks, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5, "pagination doesn't terminate")
+		page := listPage(url.Values{"limit": {"2"}, "cursor": {cursor}})
+		assert.EqualValues(t, 5, page.TotalCount)
+		for _, task := range page.Tasks {
+			seen = append(seen, task.Title)
+		}
+		if page.NextCursor == "" {
+			assert.LessOrEqual(t, page.Count, 2)
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.ElementsMatch(t, []string{"Task 0", "Task 1", "Task 2", "Task 3", "Task 4"}, seen)
+	assert.Subset(t, seen[3:], []string{"Task 1", "Task 2"}, "the oldest tasks come last")
+
+	// Offset mode is unchanged and has no cursor
+	page := listPage(url.Values{"limit": {"2"}, "offset": {"2"}})
+	assert.Equal(t, seen[2:4], []string{page.Tasks[0].Title, page.Tasks[1].Title})
+	assert.Empty(t, page.NextCursor)
+
+	req := taskRequest(http.MethodGet, "/api/tasks?cursor=garbage", user.Token, "", nil)
+	assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.GetTasks, req).Code)
+}
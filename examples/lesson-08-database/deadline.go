@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"respond"
+)
+
+// Clients can send their remaining time budget in X-Request-Timeout (or
+// Request-Timeout), either in seconds ("2.5") or as a duration ("2500ms").
+// The handler context gets that deadline, capped by the server maximum, so
+// database queries and outgoing calls are cancelled once the client has
+// given up. Requests that run out of time get 504.
+const (
+	requestTimeoutHeader     = "X-Request-Timeout"
+	requestTimeoutAltHeader  = "Request-Timeout"
+	defaultRequestTimeout    = 10 * time.Second
+	defaultMaxRequestTimeout = 25 * time.Second
+
+	DeadlineExceeded      = "deadline_exceeded"
+	InvalidRequestTimeout = "invalid_request_timeout"
+)
+
+// routeTimeouts replace the default and maximum deadline for routes whose
+// requests are meant to outlast them, by path template: long polls wait up
+// to maxChangesWait and exports stream for as long as there are tasks.
+var routeTimeouts = map[string]time.Duration{
+	"/api/tasks/changes": changesTimeout,
+	"/api/tasks/export":  exportTimeout,
+}
+
+// parseRequestTimeout reads the client's budget from the request. ok is false
+// when no header was sent.
+func parseRequestTimeout(r *http.Request) (timeout time.Duration, ok bool, err error) {
+	value := r.Header.Get(requestTimeoutHeader)
+	if value == "" {
+		value = r.Header.Get(requestTimeoutAltHeader)
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		timeout = time.Duration(seconds * float64(time.Second))
+	} else if timeout, err = time.ParseDuration(value); err != nil {
+		return 0, true, fmt.Errorf("invalid request timeout %q", value)
+	}
+
+	if timeout <= 0 {
+		return 0, true, fmt.Errorf("request timeout must be positive")
+	}
+	return timeout, true, nil
+}
+
+// deadlineMiddleware bounds every request by the client's timeout header, or
+// defaultTimeout without one. Timeouts above maxTimeout are capped. Routes
+// in routeTimeouts use their own timeout as both.
+func deadlineMiddleware(defaultTimeout, maxTimeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout, ok, err := parseRequestTimeout(r)
+			if err != nil {
+				writeDeadlineError(w, http.StatusBadRequest, InvalidRequestTimeout, err.Error(), nil)
+				return
+			}
+			routeDefault, routeMax := defaultTimeout, maxTimeout
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					if routeTimeout, ok := routeTimeouts[template]; ok {
+						routeDefault, routeMax = routeTimeout, routeTimeout
+					}
+				}
+			}
+			if !ok {
+				timeout = routeDefault
+			}
+			if timeout > routeMax {
+				timeout = routeMax
+			}
+
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			// Starts with the headers set so far, like X-Request-ID
+			tw := &timeoutWriter{w: w, header: w.Header().Clone(), statusCode: http.StatusOK}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.streaming {
+					// A handler that returned because its deadline ran out
+					// may have stopped halfway
+					if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+						panic(http.ErrAbortHandler)
+					}
+					return
+				}
+				for key, values := range tw.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tw.statusCode)
+				w.Write(tw.body.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+
+				// What was streamed can't become a 504: cut the
+				// connection, so the client sees a truncated transfer
+				if tw.streaming {
+					panic(http.ErrAbortHandler)
+				}
+				// Nobody is left to read a 504
+				if clientDisconnected(ctx) {
+					return
+				}
+
+				elapsed := time.Since(start)
+				writeDeadlineError(w, http.StatusGatewayTimeout, DeadlineExceeded,
+					fmt.Sprintf("Request did not complete within its %s deadline", timeout),
+					map[string]int64{
+						"timeoutMs": timeout.Milliseconds(),
+						"elapsedMs": elapsed.Milliseconds(),
+					})
+			}
+		})
+	}
+}
+
+func writeDeadlineError(w http.ResponseWriter, code int, errorCode, message string, details interface{}) {
+	respond.JSON(w, code, ErrorResponse{
+		Error:     http.StatusText(code),
+		Message:   message,
+		Code:      errorCode,
+		RequestID: requestID(w),
+		Details:   details,
+	})
+}
+
+// timeoutWriter buffers the handler's response so nothing reaches the client
+// once the 504 has been sent. Handlers that flush, like the export, stream
+// instead: the first flush sends what was buffered and later writes go
+// straight to the client.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	timedOut    bool
+	streaming   bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.statusCode = code
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	if tw.streaming {
+		return tw.w.Write(b)
+	}
+	return tw.body.Write(b)
+}
+
+// FlushError sends the response so far and switches to streaming; it is
+// what http.ResponseController.Flush calls.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	if !tw.streaming {
+		tw.streaming = true
+		for key, values := range tw.header {
+			tw.w.Header()[key] = values
+		}
+		tw.w.WriteHeader(tw.statusCode)
+		if _, err := tw.w.Write(tw.body.Bytes()); err != nil {
+			return err
+		}
+		tw.body.Reset()
+	}
+	return http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.FlushError()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// extend the write deadline of a long stream
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"respond"
+)
+
+func TestParseRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"seconds", requestTimeoutHeader, "2.5", 2500 * time.Millisecond, false},
+		{"duration", requestTimeoutHeader, "300ms", 300 * time.Millisecond, false},
+		{"alternate header", requestTimeoutAltHeader, "1", time.Second, false},
+		{"zero", requestTimeoutHeader, "0", 0, true},
+		{"negative", requestTimeoutHeader, "-1s", 0, true},
+		{"garbage", requestTimeoutHeader, "soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			req.Header.Set(tt.header, tt.value)
+
+			got, ok, err := parseRequestTimeout(req)
+			assert.True(t, ok)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, ok, err := parseRequestTimeout(httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestDeadlineMiddlewareTimesOut(t *testing.T) {
+	cancelled := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set(requestTimeoutHeader, "50ms")
+	w := httptest.NewRecorder()
+	deadlineMiddleware(time.Second, time.Second)(slow).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, DeadlineExceeded, response.Code)
+
+	details := response.Details.(map[string]interface{})
+	assert.Equal(t, float64(50), details["timeoutMs"])
+	assert.GreaterOrEqual(t, details["elapsedMs"], float64(50))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+}
+
+func TestDeadlineMiddlewareCapsTimeout(t *testing.T) {
+	var deadline time.Time
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set(requestTimeoutHeader, "3600")
+	w := httptest.NewRecorder()
+	deadlineMiddleware(time.Second, 2*time.Second)(handler).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{}", w.Body.String())
+	assert.WithinDuration(t, time.Now().Add(2*time.Second), deadline, 500*time.Millisecond)
+}
+
+func TestDeadlineMiddlewareRejectsInvalidHeader(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set(requestTimeoutHeader, "whenever")
+	w := httptest.NewRecorder()
+	deadlineMiddleware(time.Second, time.Second)(handler).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, InvalidRequestTimeout, errorCode(t, w))
+	assert.False(t, called)
+}
+
+func TestDeadlineMiddlewareStreamsFlushedResponses(t *testing.T) {
+	next := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, "first\n")
+		http.NewResponseController(w).Flush()
+		if r.URL.Query().Has("stall") {
+			<-r.Context().Done()
+			return
+		}
+		<-next
+		io.WriteString(w, "second\n")
+	})
+	server := httptest.NewServer(deadlineMiddleware(time.Second, time.Second)(handler))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", line, "sent before the handler returned")
+	close(next)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(rest))
+
+	// A stream that runs out of time can't turn into a 504; it is cut off
+	req, err := http.NewRequest(http.MethodGet, server.URL+"?stall", nil)
+	require.NoError(t, err)
+	req.Header.Set(requestTimeoutHeader, "50ms")
+	resp, err = server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err, "truncated transfer")
+}
+
+// The request deadline sits between respond.Int64Middleware and the
+// handlers; the encoder has to see through its writer.
+func TestDeadlineMiddlewareKeepsInt64Strings(t *testing.T) {
+	h := &Handler{}
+	handler := respond.Int64Middleware(deadlineMiddleware(time.Second, time.Second)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.respondWithJSON(w, http.StatusOK, map[string]int64{"totalCount": 9007199254740993})
+		})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks?int64=string", nil))
+	assert.JSONEq(t, `{"totalCount": "9007199254740993"}`, w.Body.String())
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Device authorization grant (RFC 8628). A CLI such as taskctl requests a
+// device code, shows the user code to the user, and polls the token endpoint
+// while the user approves the request in a browser.
+const (
+	deviceCodeGrantType    = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeTTL          = 10 * time.Minute
+	devicePollInterval     = 5 * time.Second
+	deviceSlowDownIncrease = 5 * time.Second
+
+	// Consonants only, so user codes can't spell words and are easy to read
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Device authorization statuses
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+	DeviceStatusConsumed = "consumed"
+)
+
+type DeviceAuthorization struct {
+	ID             string
+	DeviceCodeHash string
+	UserCode       string
+	ClientName     string
+	Status         string
+	UserID         *UserID
+	Interval       time.Duration
+	LastPolledAt   *time.Time
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// DeviceCodeResponse follows RFC 8628 section 3.2
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type DeviceVerifyRequest struct {
+	UserCode string `json:"userCode"`
+	Approve  bool   `json:"approve"`
+}
+
+type DeviceAuthorizationRepository interface {
+	Create(ctx context.Context, auth *DeviceAuthorization) error
+	GetByDeviceCodeHash(ctx context.Context, deviceCodeHash string) (*DeviceAuthorization, error)
+	GetPendingByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	SetDecision(ctx context.Context, id string, userID UserID, status string) error
+	RecordPoll(ctx context.Context, id string, interval time.Duration) error
+	Consume(ctx context.Context, id string) error
+}
+
+type deviceAuthorizationRepository struct {
+	db *sql.DB
+}
+
+func NewDeviceAuthorizationRepository(db *sql.DB) DeviceAuthorizationRepository {
+	return &deviceAuthorizationRepository{db: db}
+}
+
+func (r *deviceAuthorizationRepository) Create(ctx context.Context, auth *DeviceAuthorization) error {
+	query := `
+		INSERT INTO device_authorizations
+		    (id, device_code_hash, user_code, client_name, status, interval_seconds, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`
+
+	return r.db.QueryRowContext(ctx, query,
+		auth.ID, auth.DeviceCodeHash, auth.UserCode, auth.ClientName, auth.Status,
+		int(auth.Interval.Seconds()), auth.ExpiresAt,
+	).Scan(&auth.CreatedAt)
+}
+
+const deviceAuthorizationColumns = `
+		id, device_code_hash, user_code, client_name, status, user_id,
+		interval_seconds, last_polled_at, expires_at, created_at`
+
+func scanDeviceAuthorization(row *sql.Row) (*DeviceAuthorization, error) {
+	auth := &DeviceAuthorization{}
+	var intervalSeconds int
+	err := row.Scan(
+		&auth.ID, &auth.DeviceCodeHash, &auth.UserCode, &auth.ClientName, &auth.Status,
+		&auth.UserID, &intervalSeconds, &auth.LastPolledAt, &auth.ExpiresAt, &auth.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("device authorization not found")
+		}
+		return nil, fmt.Errorf("failed to get device authorization: %w", err)
+	}
+	auth.Interval = time.Duration(intervalSeconds) * time.Second
+	return auth, nil
+}
+
+func (r *deviceAuthorizationRepository) GetByDeviceCodeHash(ctx context.Context, deviceCodeHash string) (*DeviceAuthorization, error) {
+	query := `SELECT` + deviceAuthorizationColumns + `
+		FROM device_authorizations WHERE device_code_hash = $1`
+	return scanDeviceAuthorization(r.db.QueryRowContext(ctx, query, deviceCodeHash))
+}
+
+func (r *deviceAuthorizationRepository) GetPendingByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	query := `SELECT` + deviceAuthorizationColumns + `
+		FROM device_authorizations
+		WHERE user_code = $1 AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP`
+	return scanDeviceAuthorization(r.db.QueryRowContext(ctx, query, userCode))
+}
+
+func (r *deviceAuthorizationRepository) SetDecision(ctx context.Context, id string, userID UserID, status string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE device_authorizations SET status = $3, user_id = $2
+		WHERE id = $1 AND status = 'pending'`, id, userID, status)
+	if err != nil {
+		return fmt.Errorf("failed to update device authorization: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("device authorization not found")
+	}
+	return nil
+}
+
+func (r *deviceAuthorizationRepository) RecordPoll(ctx context.Context, id string, interval time.Duration) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE device_authorizations
+		SET last_polled_at = CURRENT_TIMESTAMP, interval_seconds = $2
+		WHERE id = $1`, id, int(interval.Seconds()))
+	return err
+}
+
+// Consume marks an approved authorization as used so the device code can
+// only be exchanged for tokens once.
+func (r *deviceAuthorizationRepository) Consume(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE device_authorizations SET status = 'consumed'
+		WHERE id = $1 AND status = 'approved'`, id)
+	if err != nil {
+		return fmt.Errorf("failed to consume device authorization: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("device authorization already used")
+	}
+	return nil
+}
+
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// normalizeUserCode accepts codes typed in lower case or without the dash.
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Device Flow Handlers
+func (h *Handler) RequestDeviceCode(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	deviceCode, err := generateSecret(32)
+	if err != nil {
+		h.respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate device code")
+		return
+	}
+
+	userCode, err := generateUserCode()
+	if err != nil {
+		h.respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate user code")
+		return
+	}
+
+	clientName := r.PostForm.Get("client_id")
+	if clientName == "" {
+		clientName = "taskctl"
+	}
+
+	auth := &DeviceAuthorization{
+		ID:             uuid.New().String(),
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCode:       userCode,
+		ClientName:     clientName,
+		Status:         DeviceStatusPending,
+		Interval:       devicePollInterval,
+		ExpiresAt:      time.Now().Add(deviceCodeTTL),
+	}
+
+	if err := h.deviceAuthRepo.Create(r.Context(), auth); err != nil {
+		h.respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to store device code")
+		return
+	}
+
+	verificationURI := requestBaseURL(r) + "/device"
+
+	noStorePolicy.Apply(w.Header())
+	h.respondWithJSON(w, http.StatusOK, DeviceCodeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                int(devicePollInterval.Seconds()),
+	})
+}
+
+// issueDeviceToken handles token endpoint polling for the device code grant.
+// Clients that poll faster than the advertised interval get slow_down and a
+// longer interval.
+func (h *Handler) issueDeviceToken(w http.ResponseWriter, r *http.Request) {
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "device_code is required")
+		return
+	}
+
+	auth, err := h.deviceAuthRepo.GetByDeviceCodeHash(r.Context(), hashToken(deviceCode))
+	if err != nil {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Unknown device code")
+		return
+	}
+
+	if time.Now().After(auth.ExpiresAt) {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "expired_token", "The device code has expired")
+		return
+	}
+
+	if auth.LastPolledAt != nil && time.Since(*auth.LastPolledAt) < auth.Interval {
+		h.deviceAuthRepo.RecordPoll(r.Context(), auth.ID, auth.Interval+deviceSlowDownIncrease)
+		h.respondWithOAuthError(w, http.StatusBadRequest, "slow_down",
+			fmt.Sprintf("Poll at most every %d seconds", int((auth.Interval+deviceSlowDownIncrease).Seconds())))
+		return
+	}
+	h.deviceAuthRepo.RecordPoll(r.Context(), auth.ID, auth.Interval)
+
+	switch auth.Status {
+	case DeviceStatusPending:
+		h.respondWithOAuthError(w, http.StatusBadRequest, "authorization_pending", "The user has not approved the request yet")
+		return
+	case DeviceStatusDenied:
+		h.respondWithOAuthError(w, http.StatusBadRequest, "access_denied", "The user denied the request")
+		return
+	case DeviceStatusConsumed:
+		h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "The device code has already been used")
+		return
+	}
+
+	if err := h.deviceAuthRepo.Consume(r.Context(), auth.ID); err != nil {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "The device code has already been used")
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), *auth.UserID)
+	if err != nil || !user.IsActive {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "access_denied", "Account is disabled")
+		return
+	}
+
+	tokens, err := h.issueTokenPair(r.Context(), user)
+	if err != nil {
+		h.respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	noStorePolicy.Apply(w.Header())
+	h.respondWithJSON(w, http.StatusOK, TokenResponse{
+		AccessToken:  tokens.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+	})
+}
+
+// decideDeviceAuthorization records the user's approval or denial of a
+// pending user code.
+func (h *Handler) decideDeviceAuthorization(ctx context.Context, userCode string, userID UserID, approve bool) error {
+	auth, err := h.deviceAuthRepo.GetPendingByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return err
+	}
+
+	status := DeviceStatusDenied
+	if approve {
+		status = DeviceStatusApproved
+	}
+	return h.deviceAuthRepo.SetDecision(ctx, auth.ID, userID, status)
+}
+
+// VerifyDevice lets an already signed-in client (e.g. the web app) approve a
+// user code with its bearer token.
+func (h *Handler) VerifyDevice(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req DeviceVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if err := h.decideDeviceAuthorization(r.Context(), req.UserCode, userID, req.Approve); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Unknown or expired user code")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to verify device")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var devicePageTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Device Login - Task API</title></head>
+<body>
+  <h1>Connect a device</h1>
+  {{if .Message}}<p><strong>{{.Message}}</strong></p>{{end}}
+  {{if not .Done}}
+  <p>Enter the code shown by your device and sign in to approve it.</p>
+  <form method="POST" action="/device">
+    <p><label>Code <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+    <p><label>Email <input name="email" type="email" required></label></p>
+    <p><label>Password <input name="password" type="password" required></label></p>
+    <button type="submit" name="action" value="approve">Approve</button>
+    <button type="submit" name="action" value="deny">Deny</button>
+  </form>
+  {{end}}
+</body>
+</html>`))
+
+type devicePageData struct {
+	UserCode string
+	Message  string
+	Done     bool
+}
+
+func (h *Handler) renderDevicePage(w http.ResponseWriter, code int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	noStorePolicy.Apply(w.Header())
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(code)
+	devicePageTemplate.Execute(w, data)
+}
+
+// DevicePage serves the verification page linked from verification_uri.
+func (h *Handler) DevicePage(w http.ResponseWriter, r *http.Request) {
+	h.renderDevicePage(w, http.StatusOK, devicePageData{
+		UserCode: r.URL.Query().Get("user_code"),
+	})
+}
+
+// SubmitDevicePage handles the verification form: the user signs in with
+// their password and approves or denies the device.
+func (h *Handler) SubmitDevicePage(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderDevicePage(w, http.StatusBadRequest, devicePageData{Message: "Invalid form submission"})
+		return
+	}
+
+	userCode := r.PostForm.Get("user_code")
+	user, err := h.verifyCredentials(r.Context(), r.PostForm.Get("email"), r.PostForm.Get("password"))
+	if err != nil {
+		var lockedErr *AccountLockedError
+		if errors.As(err, &lockedErr) {
+			h.renderDevicePage(w, http.StatusTooManyRequests, devicePageData{
+				UserCode: userCode,
+				Message:  "Too many failed login attempts, try again later",
+			})
+			return
+		}
+		h.renderDevicePage(w, http.StatusUnauthorized, devicePageData{
+			UserCode: userCode,
+			Message:  "Invalid email or password",
+		})
+		return
+	}
+
+	approve := r.PostForm.Get("action") == "approve"
+	if err := h.decideDeviceAuthorization(r.Context(), userCode, user.ID, approve); err != nil {
+		h.renderDevicePage(w, http.StatusNotFound, devicePageData{
+			UserCode: userCode,
+			Message:  "That code is invalid or has expired",
+		})
+		return
+	}
+
+	message := "Request denied. You can close this window."
+	if approve {
+		message = "Device approved. You can return to your terminal."
+	}
+	h.renderDevicePage(w, http.StatusOK, devicePageData{Message: message, Done: true})
+}
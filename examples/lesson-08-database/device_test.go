@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) requestTestDeviceCode(t *testing.T) DeviceCodeResponse {
+	form := url.Values{"client_id": {"taskctl"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/device/code", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	env.handler.RequestDeviceCode(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response DeviceCodeResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func (env *testEnv) pollDeviceToken(deviceCode string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {deviceCode}}
+	req := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	env.handler.IssueToken(w, req)
+	return w
+}
+
+// resetDevicePolling lets tests poll again without waiting out the interval.
+func (env *testEnv) resetDevicePolling() {
+	env.db.Exec("UPDATE device_authorizations SET last_polled_at = NULL")
+}
+
+func (env *testEnv) submitDevicePage(userCode, email, password, action string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"user_code": {userCode},
+		"email":     {email},
+		"password":  {password},
+		"action":    {action},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	env.handler.SubmitDevicePage(w, req)
+	return w
+}
+
+func oauthErrorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body["error"]
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.registerTestUser(t, "device@example.com")
+
+	code := env.requestTestDeviceCode(t)
+	assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, code.UserCode)
+	assert.Equal(t, "http://example.com/device", code.VerificationURI)
+	assert.Contains(t, code.VerificationURIComplete, code.UserCode)
+	assert.Equal(t, int(devicePollInterval.Seconds()), code.Interval)
+
+	// Not approved yet
+	w := env.pollDeviceToken(code.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "authorization_pending", oauthErrorCode(t, w))
+
+	// Polling again immediately is too fast
+	w = env.pollDeviceToken(code.DeviceCode)
+	assert.Equal(t, "slow_down", oauthErrorCode(t, w))
+
+	// Wrong password does not approve the code
+	w = env.submitDevicePage(code.UserCode, "device@example.com", "wrong", "approve")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// User codes are accepted without the dash and in lower case
+	typed := strings.ToLower(strings.ReplaceAll(code.UserCode, "-", ""))
+	w = env.submitDevicePage(typed, "device@example.com", "Tasks-Pass-2024", "approve")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Device approved")
+
+	env.resetDevicePolling()
+	w = env.pollDeviceToken(code.DeviceCode)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var token TokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &token))
+	assert.NotEmpty(t, token.AccessToken)
+	assert.NotEmpty(t, token.RefreshToken)
+	assert.Equal(t, "Bearer", token.TokenType)
+
+	// The device code can only be exchanged once
+	env.resetDevicePolling()
+	w = env.pollDeviceToken(code.DeviceCode)
+	assert.Equal(t, "invalid_grant", oauthErrorCode(t, w))
+}
+
+func TestDeviceAuthorization_Denied(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	login := env.registerTestUser(t, "device-deny@example.com")
+
+	code := env.requestTestDeviceCode(t)
+
+	body := `{"userCode":"` + code.UserCode + `","approve":false}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/device/verify", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+
+	w := env.serveWithAuth(env.handler.VerifyDevice, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = env.pollDeviceToken(code.DeviceCode)
+	assert.Equal(t, "access_denied", oauthErrorCode(t, w))
+
+	// A decided code can't be approved afterwards
+	w = env.submitDevicePage(code.UserCode, "device-deny@example.com", "Tasks-Pass-2024", "approve")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeviceToken_UnknownCode(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	w := env.pollDeviceToken("not-a-device-code")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", oauthErrorCode(t, w))
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	assert.Equal(t, "BCDF-GHJK", normalizeUserCode("bcdfghjk"))
+	assert.Equal(t, "BCDF-GHJK", normalizeUserCode("bcdf-ghjk"))
+	assert.Equal(t, "BCDF-GHJK", normalizeUserCode(" BCDF GHJK "))
+	assert.Equal(t, "ABC", normalizeUserCode("abc"))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"logging"
+)
+
+// statusClientClosedRequest is the (nginx) status recorded in metrics for
+// requests the client abandoned. It is never sent.
+const statusClientClosedRequest = 499
+
+// clientDisconnected reports whether the request context was cancelled
+// because the client went away, as opposed to running out of time.
+func clientDisconnected(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
+// disconnectMiddleware stops writing responses to clients that have gone
+// away. Repository queries run with the request context and are aborted by
+// the cancellation; whatever error response the handler then produces is
+// dropped instead of being written to a closed connection.
+func disconnectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&disconnectWriter{ResponseWriter: w, ctx: r.Context()}, r)
+		if clientDisconnected(r.Context()) {
+			logging.AddFields(r.Context(), slog.Bool("cancelled", true))
+		}
+	})
+}
+
+type disconnectWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (dw *disconnectWriter) WriteHeader(code int) {
+	if clientDisconnected(dw.ctx) {
+		return
+	}
+	dw.ResponseWriter.WriteHeader(code)
+}
+
+func (dw *disconnectWriter) Write(b []byte) (int, error) {
+	if clientDisconnected(dw.ctx) {
+		return 0, dw.ctx.Err()
+	}
+	return dw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (dw *disconnectWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisconnectedClientGetsNoResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulates a repository call aborted by the disconnect
+		cancel()
+		<-r.Context().Done()
+		http.Error(w, "query cancelled", http.StatusInternalServerError)
+	})
+
+	cancelled := httpRequestsCancelled.WithLabelValues(http.MethodGet, "/api/disconnect")
+	recorded := httpRequestsTotal.WithLabelValues(http.MethodGet, "/api/disconnect", "499")
+	cancelledBefore, recordedBefore := testutil.ToFloat64(cancelled), testutil.ToFloat64(recorded)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/disconnect", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	metricsMiddleware(disconnectMiddleware(handler)).ServeHTTP(w, req)
+
+	assert.Empty(t, w.Body.String(), "nothing is written to a gone client")
+	assert.Equal(t, cancelledBefore+1, testutil.ToFloat64(cancelled))
+	assert.Equal(t, recordedBefore+1, testutil.ToFloat64(recorded))
+}
+
+func TestDeadlineSkipsResponseForDisconnectedClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	deadlineMiddleware(time.Second, time.Second)(handler).ServeHTTP(w, req)
+
+	assert.Equal(t, 0, w.Body.Len(), "no 504 for a client that went away")
+}
+
+func TestClientDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.False(t, clientDisconnected(ctx))
+	cancel()
+	assert.True(t, clientDisconnected(ctx))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	assert.False(t, clientDisconnected(ctx), "timeouts are not disconnects")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// drainRetryAfter is how long clients are told to wait before retrying a
+// long operation refused while draining; by then they reach a new instance.
+const drainRetryAfter = "30"
+
+// Drainer supports blue/green replacement of an instance. Draining flips the
+// readiness probe so the load balancer stops sending new traffic, refuses
+// new long operations, and reports what is still in flight, so the
+// orchestrator knows when the instance can be stopped without cutting
+// anything off. Resume undoes it, e.g. when a rollout is aborted.
+type Drainer struct {
+	draining       atomic.Bool
+	drainingSince  atomic.Pointer[time.Time]
+	requests       atomic.Int64
+	longOperations atomic.Int64
+	jobs           *JobQueue
+}
+
+func NewDrainer(jobs *JobQueue) *Drainer {
+	return &Drainer{jobs: jobs}
+}
+
+// DrainStatus is reported by the readiness probe and the lifecycle
+// endpoints. The in-flight counts exclude the request reporting them.
+type DrainStatus struct {
+	Status                 string     `json:"status"`
+	DrainingSince          *time.Time `json:"drainingSince,omitempty"`
+	InFlightRequests       int64      `json:"inFlightRequests"`
+	InFlightLongOperations int64      `json:"inFlightLongOperations"`
+	QueuedJobs             int        `json:"queuedJobs"`
+}
+
+func (d *Drainer) Drain() {
+	if d.draining.CompareAndSwap(false, true) {
+		now := time.Now()
+		d.drainingSince.Store(&now)
+	}
+}
+
+func (d *Drainer) Resume() {
+	d.draining.Store(false)
+	d.drainingSince.Store(nil)
+}
+
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+func (d *Drainer) Status() DrainStatus {
+	status := DrainStatus{
+		Status:                 "ready",
+		DrainingSince:          d.drainingSince.Load(),
+		InFlightRequests:       d.requests.Load(),
+		InFlightLongOperations: d.longOperations.Load(),
+	}
+	if d.Draining() {
+		status.Status = "draining"
+	}
+	if d.jobs != nil {
+		status.QueuedJobs = d.jobs.Pending()
+	}
+	return status
+}
+
+// Middleware counts in-flight requests.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.requests.Add(1)
+		defer d.requests.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// longOperation wraps handlers that take long enough that they shouldn't
+// start on an instance about to be stopped, such as uploads. While draining
+// they are refused with 503 and Retry-After.
+func (h *Handler) longOperation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := h.drainer
+		if d.Draining() {
+			w.Header().Set("Retry-After", drainRetryAfter)
+			w.Header().Set("Connection", "close")
+			h.respondWithError(w, http.StatusServiceUnavailable, "This instance is draining; retry the request")
+			return
+		}
+
+		d.longOperations.Add(1)
+		defer d.longOperations.Add(-1)
+		next(w, r)
+	}
+}
+
+// callerStatus reports the drain status to a request, which is itself in
+// flight.
+func (d *Drainer) callerStatus() DrainStatus {
+	status := d.Status()
+	if status.InFlightRequests > 0 {
+		status.InFlightRequests--
+	}
+	return status
+}
+
+// Readiness is the load balancer's readiness probe. Unlike /health, which
+// reports whether the process works, it fails while draining so no new
+// traffic is routed here.
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	status := h.drainer.callerStatus()
+	if status.Status == "draining" {
+		h.respondWithJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, status)
+}
+
+func (h *Handler) Drain(w http.ResponseWriter, r *http.Request) {
+	h.drainer.Drain()
+	h.recordAudit(r, &AuditEvent{
+		UserID:     UserID(r.Context().Value("user_id").(string)),
+		Action:     AuditDrain,
+		TargetType: "instance",
+	})
+	h.respondWithJSON(w, http.StatusOK, h.drainer.callerStatus())
+}
+
+func (h *Handler) Resume(w http.ResponseWriter, r *http.Request) {
+	h.drainer.Resume()
+	h.recordAudit(r, &AuditEvent{
+		UserID:     UserID(r.Context().Value("user_id").(string)),
+		Action:     AuditResume,
+		TargetType: "instance",
+	})
+	h.respondWithJSON(w, http.StatusOK, h.drainer.callerStatus())
+}
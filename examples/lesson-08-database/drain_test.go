@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func drainStatus(t *testing.T, w *httptest.ResponseRecorder) DrainStatus {
+	var status DrainStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	return status
+}
+
+func TestDrainAndResume(t *testing.T) {
+	jobs := NewJobQueue(10, 1, 0)
+	require.NoError(t, jobs.Enqueue(Job{Type: "queued"}))
+	h := &Handler{drainer: NewDrainer(jobs)}
+	ready := h.drainer.Middleware(http.HandlerFunc(h.Readiness))
+
+	w := httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := drainStatus(t, w)
+	assert.Equal(t, "ready", status.Status)
+	assert.Zero(t, status.InFlightRequests, "the probe doesn't count itself")
+	assert.Equal(t, 1, status.QueuedJobs)
+
+	// A slow upload is in flight while the instance starts draining
+	started, release := make(chan struct{}), make(chan struct{})
+	upload := h.drainer.Middleware(h.longOperation(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	uploaded := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		upload.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/tasks/1/attachments", nil))
+		uploaded <- w.Code
+	}()
+	<-started
+
+	admin := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/drain", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", "admin-id"))
+		w := httptest.NewRecorder()
+		h.drainer.Middleware(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	w = admin(h.Drain)
+	require.Equal(t, http.StatusOK, w.Code)
+	status = drainStatus(t, w)
+	assert.Equal(t, "draining", status.Status)
+	assert.NotNil(t, status.DrainingSince)
+	assert.EqualValues(t, 1, status.InFlightRequests)
+	assert.EqualValues(t, 1, status.InFlightLongOperations)
+
+	w = httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// New long operations are refused, the running one finishes
+	w = httptest.NewRecorder()
+	upload.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/tasks/1/attachments", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, drainRetryAfter, w.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, <-uploaded)
+	status = h.drainer.Status()
+	assert.Zero(t, status.InFlightRequests)
+	assert.Zero(t, status.InFlightLongOperations)
+
+	w = admin(h.Resume)
+	require.Equal(t, http.StatusOK, w.Code)
+	status = drainStatus(t, w)
+	assert.Equal(t, "ready", status.Status)
+	assert.Nil(t, status.DrainingSince)
+}
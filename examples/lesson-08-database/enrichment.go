@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"correlation"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultWeatherAPIURL   = "https://api.open-meteo.com/v1/forecast"
+	defaultGeocodingAPIURL = "https://geocoding-api.open-meteo.com/v1/search"
+	defaultWeatherCacheTTL = 30 * time.Minute
+
+	jobTypeEnrichTask = "enrich_task"
+)
+
+const (
+	EnrichmentPending = "pending"
+	EnrichmentReady   = "ready"
+	EnrichmentFailed  = "failed"
+)
+
+// ErrLocationNotFound means the location can't be geocoded; retrying won't help.
+var ErrLocationNotFound = errors.New("location not found")
+
+// Weather is the current weather at a task's location.
+type Weather struct {
+	Place        string    `json:"place"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	TemperatureC float64   `json:"temperatureC"`
+	WindSpeedKmh float64   `json:"windSpeedKmh"`
+	WeatherCode  int       `json:"weatherCode"`
+	Summary      string    `json:"summary"`
+	ObservedAt   time.Time `json:"observedAt"`
+}
+
+// TaskEnrichment is third-party data attached to a task, fetched in the
+// background after the task's location is set.
+type TaskEnrichment struct {
+	Status    string     `json:"status"`
+	Location  string     `json:"location"`
+	Weather   *Weather   `json:"weather,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Attempts  int        `json:"attempts"`
+	FetchedAt *time.Time `json:"fetchedAt,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// EnrichmentRepository stores the enrichment state of tasks.
+type EnrichmentRepository interface {
+	MarkPending(ctx context.Context, taskID TaskID, location string) error
+	// SaveResult records an attempt for the given location. It is a no-op when
+	// the task's location changed since the attempt was scheduled.
+	SaveResult(ctx context.Context, taskID TaskID, location string, weather *Weather, errMsg string, final bool) error
+	Get(ctx context.Context, taskID TaskID) (*TaskEnrichment, error)
+	Delete(ctx context.Context, taskID TaskID) error
+}
+
+type enrichmentRepository struct {
+	db *sql.DB
+}
+
+func NewEnrichmentRepository(db *sql.DB) EnrichmentRepository {
+	return &enrichmentRepository{db: db}
+}
+
+func (r *enrichmentRepository) MarkPending(ctx context.Context, taskID TaskID, location string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO task_enrichments (task_id, location, status)
+		VALUES ($1, $2, 'pending')
+		ON CONFLICT (task_id) DO UPDATE
+		SET location = EXCLUDED.location, status = 'pending', weather = NULL,
+			error = '', attempts = 0, fetched_at = NULL, updated_at = CURRENT_TIMESTAMP`,
+		taskID, location)
+	if err != nil {
+		return fmt.Errorf("failed to mark enrichment pending: %w", err)
+	}
+	return nil
+}
+
+func (r *enrichmentRepository) SaveResult(ctx context.Context, taskID TaskID, location string, weather *Weather, errMsg string, final bool) error {
+	status := EnrichmentPending
+	var payload []byte
+	var fetchedAt *time.Time
+	switch {
+	case weather != nil:
+		status = EnrichmentReady
+		data, err := json.Marshal(weather)
+		if err != nil {
+			return fmt.Errorf("failed to encode weather: %w", err)
+		}
+		payload = data
+		now := time.Now()
+		fetchedAt = &now
+	case final:
+		status = EnrichmentFailed
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE task_enrichments
+		SET status = $3, weather = $4, error = $5, attempts = attempts + 1,
+			fetched_at = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE task_id = $1 AND location = $2`,
+		taskID, location, status, payload, errMsg, fetchedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save enrichment: %w", err)
+	}
+	return nil
+}
+
+func (r *enrichmentRepository) Get(ctx context.Context, taskID TaskID) (*TaskEnrichment, error) {
+	enrichment := &TaskEnrichment{}
+	var weather []byte
+	err := r.db.QueryRowContext(ctx, `
+		SELECT status, location, weather, error, attempts, fetched_at, updated_at
+		FROM task_enrichments WHERE task_id = $1`,
+		taskID,
+	).Scan(&enrichment.Status, &enrichment.Location, &weather, &enrichment.Error,
+		&enrichment.Attempts, &enrichment.FetchedAt, &enrichment.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("enrichment not found")
+		}
+		return nil, fmt.Errorf("failed to get enrichment: %w", err)
+	}
+
+	if weather != nil {
+		enrichment.Weather = &Weather{}
+		if err := json.Unmarshal(weather, enrichment.Weather); err != nil {
+			return nil, fmt.Errorf("failed to decode weather: %w", err)
+		}
+	}
+	return enrichment, nil
+}
+
+func (r *enrichmentRepository) Delete(ctx context.Context, taskID TaskID) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM task_enrichments WHERE task_id = $1`, taskID)
+	if err != nil {
+		return fmt.Errorf("failed to delete enrichment: %w", err)
+	}
+	return nil
+}
+
+// WeatherProvider looks up the current weather for a free-form location.
+type WeatherProvider interface {
+	Current(ctx context.Context, location string) (*Weather, error)
+}
+
+// OpenMeteoProvider uses the Open-Meteo geocoding and forecast APIs, which
+// need no API key.
+type OpenMeteoProvider struct {
+	geocodingURL string
+	forecastURL  string
+	client       *http.Client
+}
+
+func NewOpenMeteoProvider(geocodingURL, forecastURL string) *OpenMeteoProvider {
+	return &OpenMeteoProvider{
+		geocodingURL: geocodingURL,
+		forecastURL:  forecastURL,
+		client:       newHTTPClient(10 * time.Second),
+	}
+}
+
+func (p *OpenMeteoProvider) Current(ctx context.Context, location string) (*Weather, error) {
+	var places struct {
+		Results []struct {
+			Name      string  `json:"name"`
+			Country   string  `json:"country"`
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"results"`
+	}
+	query := url.Values{"name": {location}, "count": {"1"}}
+	if err := p.getJSON(ctx, p.geocodingURL+"?"+query.Encode(), &places); err != nil {
+		return nil, err
+	}
+	if len(places.Results) == 0 {
+		return nil, ErrLocationNotFound
+	}
+	place := places.Results[0]
+
+	var forecast struct {
+		CurrentWeather struct {
+			Temperature float64 `json:"temperature"`
+			WindSpeed   float64 `json:"windspeed"`
+			WeatherCode int     `json:"weathercode"`
+			Time        string  `json:"time"`
+		} `json:"current_weather"`
+	}
+	query = url.Values{
+		"latitude":        {fmt.Sprintf("%.4f", place.Latitude)},
+		"longitude":       {fmt.Sprintf("%.4f", place.Longitude)},
+		"current_weather": {"true"},
+		"timezone":        {"UTC"},
+	}
+	if err := p.getJSON(ctx, p.forecastURL+"?"+query.Encode(), &forecast); err != nil {
+		return nil, err
+	}
+
+	current := forecast.CurrentWeather
+	observedAt, _ := time.Parse("2006-01-02T15:04", current.Time)
+	name := place.Name
+	if place.Country != "" {
+		name += ", " + place.Country
+	}
+	return &Weather{
+		Place:        name,
+		Latitude:     place.Latitude,
+		Longitude:    place.Longitude,
+		TemperatureC: current.Temperature,
+		WindSpeedKmh: current.WindSpeed,
+		WeatherCode:  current.WeatherCode,
+		Summary:      weatherSummary(current.WeatherCode),
+		ObservedAt:   observedAt,
+	}, nil
+}
+
+func (p *OpenMeteoProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("weather request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather API returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid weather API response: %w", err)
+	}
+	return nil
+}
+
+// weatherSummary describes a WMO weather interpretation code.
+func weatherSummary(code int) string {
+	switch {
+	case code == 0:
+		return "Clear sky"
+	case code <= 3:
+		return "Partly cloudy"
+	case code == 45 || code == 48:
+		return "Fog"
+	case code >= 51 && code <= 57:
+		return "Drizzle"
+	case code >= 61 && code <= 67, code >= 80 && code <= 82:
+		return "Rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return "Snow"
+	case code >= 95:
+		return "Thunderstorm"
+	}
+	return "Unknown"
+}
+
+// CachedWeatherProvider caches successful lookups per location so many tasks
+// at the same place cost one upstream call per TTL. Failures are not cached.
+type CachedWeatherProvider struct {
+	next WeatherProvider
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedWeather
+}
+
+type cachedWeather struct {
+	weather   *Weather
+	expiresAt time.Time
+}
+
+func NewCachedWeatherProvider(next WeatherProvider, ttl time.Duration) *CachedWeatherProvider {
+	return &CachedWeatherProvider{
+		next:    next,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cachedWeather),
+	}
+}
+
+func (c *CachedWeatherProvider) Current(ctx context.Context, location string) (*Weather, error) {
+	key := strings.ToLower(strings.TrimSpace(location))
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expiresAt) {
+		return entry.weather, nil
+	}
+
+	weather, err := c.next.Current(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedWeather{weather: weather, expiresAt: now.Add(c.ttl)}
+	return weather, nil
+}
+
+// TaskEnricher fetches weather for tasks with a location through the job
+// queue. Enrichment never fails the task request: scheduling and lookup
+// errors are logged and the task is served without it. A nil enricher means
+// enrichment is disabled.
+type TaskEnricher struct {
+	repo     EnrichmentRepository
+	provider WeatherProvider
+	queue    *JobQueue
+	// invalidator purges cached task responses once enrichment finishes
+	invalidator *CacheInvalidator
+}
+
+func NewTaskEnricher(repo EnrichmentRepository, provider WeatherProvider, queue *JobQueue) *TaskEnricher {
+	e := &TaskEnricher{repo: repo, provider: provider, queue: queue}
+	queue.Register(jobTypeEnrichTask, e.process)
+	return e
+}
+
+// Schedule (re)starts enrichment for the task's current location, or drops
+// it when the location was cleared.
+func (e *TaskEnricher) Schedule(ctx context.Context, task *Task) {
+	if e == nil {
+		return
+	}
+
+	if task.Location == "" {
+		if err := e.repo.Delete(ctx, task.ID); err != nil {
+			slog.ErrorContext(ctx, "failed to clear enrichment", "task_id", task.ID, "error", err)
+		}
+		return
+	}
+
+	if err := e.repo.MarkPending(ctx, task.ID, task.Location); err != nil {
+		slog.ErrorContext(ctx, "failed to schedule enrichment", "task_id", task.ID, "error", err)
+		return
+	}
+	job := Job{Type: jobTypeEnrichTask, Key: task.ID.String(), Forwarded: correlation.Forwarded(ctx)}
+	if err := e.queue.Enqueue(job); err != nil {
+		slog.ErrorContext(ctx, "failed to enqueue enrichment", "task_id", task.ID, "error", err)
+	}
+}
+
+// Lookup returns the task's enrichment, or nil when there is none or it
+// can't be loaded.
+func (e *TaskEnricher) Lookup(ctx context.Context, taskID TaskID) *TaskEnrichment {
+	if e == nil {
+		return nil
+	}
+
+	enrichment, err := e.repo.Get(ctx, taskID)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			slog.ErrorContext(ctx, "failed to load enrichment", "task_id", taskID, "error", err)
+		}
+		return nil
+	}
+	return enrichment
+}
+
+func (e *TaskEnricher) process(ctx context.Context, job Job) error {
+	taskID := TaskID(job.Key)
+	current, err := e.repo.Get(ctx, taskID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			// Task deleted or location cleared since scheduling
+			return nil
+		}
+		return err
+	}
+	if current.Status != EnrichmentPending {
+		return nil
+	}
+
+	weather, err := e.provider.Current(ctx, current.Location)
+	if err != nil {
+		final := job.Final() || errors.Is(err, ErrLocationNotFound)
+		if saveErr := e.repo.SaveResult(ctx, taskID, current.Location, nil, err.Error(), final); saveErr != nil {
+			slog.ErrorContext(ctx, "failed to record enrichment failure", "task_id", taskID, "error", saveErr)
+		} else if final {
+			e.invalidator.Invalidate(taskSurrogateKey(taskID))
+		}
+		if errors.Is(err, ErrLocationNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := e.repo.SaveResult(ctx, taskID, current.Location, weather, "", true); err != nil {
+		return err
+	}
+	e.invalidator.Invalidate(taskSurrogateKey(taskID))
+	return nil
+}
+
+func (h *Handler) GetTaskEnrichment(w http.ResponseWriter, r *http.Request) {
+	taskID := TaskID(mux.Vars(r)["id"])
+
+	task, err := h.taskRepo.GetByID(r.Context(), taskID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+
+	if !h.authorizeTask(w, r, ActionRead, task) {
+		return
+	}
+
+	enrichment := h.enricher.Lookup(r.Context(), taskID)
+	if enrichment == nil {
+		h.respondWithError(w, http.StatusNotFound, "Task has no enrichment")
+		return
+	}
+	setSurrogateKeys(w, taskSurrogateKey(task.ID))
+	h.respondWithJSON(w, http.StatusOK, enrichment)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWeatherProvider struct {
+	calls    int32
+	failures int32
+}
+
+func (f *fakeWeatherProvider) Current(ctx context.Context, location string) (*Weather, error) {
+	if atomic.AddInt32(&f.calls, 1) <= atomic.LoadInt32(&f.failures) {
+		return nil, fmt.Errorf("weather API returned 503")
+	}
+	if location == "Atlantis" {
+		return nil, ErrLocationNotFound
+	}
+	return &Weather{Place: location, TemperatureC: 21.5, Summary: "Clear sky"}, nil
+}
+
+func newOpenMeteoServer(t *testing.T) *httptest.Server {
+	routes := http.NewServeMux()
+	routes.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") != "Berlin" {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		fmt.Fprint(w, `{"results":[{"name":"Berlin","country":"Germany","latitude":52.52,"longitude":13.41}]}`)
+	})
+	routes.HandleFunc("/forecast", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "52.5200", r.URL.Query().Get("latitude"))
+		fmt.Fprint(w, `{"current_weather":{"temperature":12.3,"windspeed":9.4,"weathercode":61,"time":"2024-03-01T12:00"}}`)
+	})
+	server := httptest.NewServer(routes)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenMeteoProvider(t *testing.T) {
+	server := newOpenMeteoServer(t)
+	provider := NewOpenMeteoProvider(server.URL+"/search", server.URL+"/forecast")
+
+	weather, err := provider.Current(context.Background(), "Berlin")
+	require.NoError(t, err)
+	assert.Equal(t, "Berlin, Germany", weather.Place)
+	assert.Equal(t, 12.3, weather.TemperatureC)
+	assert.Equal(t, "Rain", weather.Summary)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), weather.ObservedAt)
+
+	_, err = provider.Current(context.Background(), "Atlantis")
+	assert.ErrorIs(t, err, ErrLocationNotFound)
+}
+
+func TestCachedWeatherProvider(t *testing.T) {
+	fake := &fakeWeatherProvider{failures: 1}
+	cache := NewCachedWeatherProvider(fake, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.Current(context.Background(), "Berlin")
+	assert.Error(t, err, "failures are not cached")
+
+	_, err = cache.Current(context.Background(), "Berlin")
+	require.NoError(t, err)
+	_, err = cache.Current(context.Background(), " berlin ")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fake.calls), "same location served from cache")
+
+	now = now.Add(2 * time.Minute)
+	_, err = cache.Current(context.Background(), "Berlin")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fake.calls), "expired entries are refetched")
+}
+
+func TestJobQueueRetries(t *testing.T) {
+	queue := NewJobQueue(10, 3, time.Millisecond)
+	attempts := make(chan int, 3)
+	queue.Register("flaky", func(ctx context.Context, job Job) error {
+		attempts <- job.Attempt
+		if job.Attempt < 3 {
+			return fmt.Errorf("try again")
+		}
+		return nil
+	})
+	queue.Start(1)
+	defer queue.Stop()
+
+	require.NoError(t, queue.Enqueue(Job{Type: "flaky", Key: "1"}))
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-attempts:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d never ran", want)
+		}
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	queue := NewJobQueue(1, 1, time.Millisecond)
+	require.NoError(t, queue.Enqueue(Job{Type: "noop"}))
+	assert.ErrorIs(t, queue.Enqueue(Job{Type: "noop"}), ErrQueueFull)
+}
+
+func (env *testEnv) waitForEnrichment(t *testing.T, taskID TaskID, status string) *TaskEnrichment {
+	var enrichment *TaskEnrichment
+	require.Eventually(t, func() bool {
+		enrichment = env.handler.enricher.Lookup(context.Background(), taskID)
+		return enrichment != nil && enrichment.Status == status
+	}, 2*time.Second, 10*time.Millisecond)
+	return enrichment
+}
+
+func TestTaskEnrichmentFlow(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	token := env.createTestUserAndGetToken(t, "enrich@example.com")
+
+	fake := &fakeWeatherProvider{failures: 1}
+	queue := NewJobQueue(10, 3, time.Millisecond)
+	env.handler.enricher = NewTaskEnricher(NewEnrichmentRepository(env.db.DB), fake, queue)
+	queue.Start(1)
+	defer func() {
+		queue.Stop()
+		env.handler.enricher = nil
+	}()
+
+	body, _ := json.Marshal(CreateTaskRequest{Title: "Picnic", Location: "Berlin"})
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := env.serveWithAuth(env.handler.CreateTask, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, "Berlin", task.Location)
+
+	// The first upstream call fails and is retried by the queue
+	enrichment := env.waitForEnrichment(t, task.ID, EnrichmentReady)
+	assert.Equal(t, 2, enrichment.Attempts)
+	assert.Equal(t, 21.5, enrichment.Weather.TemperatureC)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"?embed=enrichment", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"id": task.ID.String()})
+	w = env.serveWithAuth(env.handler.GetTask, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	require.NotNil(t, task.Enrichment)
+	assert.Equal(t, "Berlin", task.Enrichment.Weather.Place)
+
+	// An unknown location fails without retries
+	body, _ = json.Marshal(UpdateTaskRequest{Location: stringPtr("Atlantis")})
+	req = httptest.NewRequest(http.MethodPut, "/api/tasks/"+task.ID.String(), bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"id": task.ID.String()})
+	w = env.serveWithAuth(env.handler.UpdateTask, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	enrichment = env.waitForEnrichment(t, task.ID, EnrichmentFailed)
+	assert.Equal(t, 1, enrichment.Attempts)
+	assert.Equal(t, ErrLocationNotFound.Error(), enrichment.Error)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/enrichment", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"id": task.ID.String()})
+	w = env.serveWithAuth(env.handler.GetTaskEnrichment, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"failed"`)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Enumerated fields are declared once here. The same registry validates
+// requests, adds "enum" to the generated request schemas (fields tagged
+// `enum:"name"`) and is served from GET /api/meta/enums, so clients can
+// build pickers and validate input without hard-coding the values.
+
+// EnumValue is an allowed value and the label to show for it.
+type EnumValue struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+type Enum struct {
+	Name   string      `json:"-"`
+	Values []EnumValue `json:"values"`
+	// Default is used when a request leaves the field out
+	Default string `json:"default,omitempty"`
+}
+
+// Valid reports whether value is one of the allowed values.
+func (e *Enum) Valid(value string) bool {
+	for _, v := range e.Values {
+		if v.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Index returns the position of value among the allowed values, or -1.
+// Ordered enums such as priorities compare by it.
+func (e *Enum) Index(value string) int {
+	for i, v := range e.Values {
+		if v.Value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// Strings lists the allowed values in order.
+func (e *Enum) Strings() []string {
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = v.Value
+	}
+	return values
+}
+
+// Check returns a client-facing error for a value that isn't allowed.
+func (e *Enum) Check(value string) error {
+	if e.Valid(value) {
+		return nil
+	}
+	return fmt.Errorf("Invalid %s %q: must be one of %s", e.Name, value, strings.Join(e.Strings(), ", "))
+}
+
+// EnumViolation details a 422 for a value that isn't allowed.
+type EnumViolation struct {
+	Field   string   `json:"field"`
+	Value   string   `json:"value"`
+	Allowed []string `json:"allowed"`
+}
+
+// respondWithEnumError rejects a request body field with 422 Unprocessable
+// Entity, listing the allowed values. Query parameters are 400s instead.
+func (h *Handler) respondWithEnumError(w http.ResponseWriter, e *Enum, value string) {
+	h.respondWithJSON(w, http.StatusUnprocessableEntity, ErrorResponse{
+		Error:     http.StatusText(http.StatusUnprocessableEntity),
+		Message:   e.Check(value).Error(),
+		Code:      "invalid_enum_value",
+		RequestID: requestID(w),
+		Details:   EnumViolation{Field: e.Name, Value: value, Allowed: e.Strings()},
+	})
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+
+	TaskStatusOpen      = "open"
+	TaskStatusCompleted = "completed"
+)
+
+var (
+	priorityEnum = &Enum{
+		Name: "priority",
+		Values: []EnumValue{
+			{Value: "low", Label: "Low"},
+			{Value: "medium", Label: "Medium"},
+			{Value: "high", Label: "High"},
+			{Value: "urgent", Label: "Urgent"},
+		},
+		Default: "medium",
+	}
+
+	// statusEnum is the ?status= filter of task lists; it is derived from
+	// the completed flag
+	statusEnum = &Enum{
+		Name: "status",
+		Values: []EnumValue{
+			{Value: TaskStatusOpen, Label: "Open"},
+			{Value: TaskStatusCompleted, Label: "Completed"},
+		},
+	}
+
+	// ruleActionEnum lists what task rules can do, see rules.go
+	ruleActionEnum = &Enum{
+		Name: "ruleAction",
+		Values: []EnumValue{
+			{Value: RuleRaisePriority, Label: "Raise priority"},
+			{Value: RuleAddTag, Label: "Add tag"},
+		},
+	}
+
+	// roleEnum lists every role; guests are created by guest signup, not
+	// assigned through invites or the admin API
+	roleEnum = &Enum{
+		Name: "role",
+		Values: []EnumValue{
+			{Value: RoleUser, Label: "User"},
+			{Value: RoleAdmin, Label: "Administrator"},
+			{Value: RoleGuest, Label: "Guest"},
+		},
+		Default: RoleUser,
+	}
+)
+
+// enumRegistry maps the names used in `enum` tags and in the metadata
+// endpoint to their enums.
+var enumRegistry = map[string]*Enum{
+	priorityEnum.Name:   priorityEnum,
+	statusEnum.Name:     statusEnum,
+	ruleActionEnum.Name: ruleActionEnum,
+	roleEnum.Name:       roleEnum,
+}
+
+// assignableRole reports whether an invite or an admin may give a user role.
+func assignableRole(role string) bool {
+	return roleEnum.Valid(role) && role != RoleGuest
+}
+
+// GetEnums handles GET /api/meta/enums
+func (h *Handler) GetEnums(w http.ResponseWriter, r *http.Request) {
+	h.respondWithJSON(w, http.StatusOK, enumRegistry)
+}
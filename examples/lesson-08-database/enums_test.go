@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnum(t *testing.T) {
+	assert.True(t, priorityEnum.Valid("urgent"))
+	assert.False(t, priorityEnum.Valid("someday"))
+	assert.Equal(t, []string{"low", "medium", "high", "urgent"}, priorityEnum.Strings())
+	assert.NoError(t, priorityEnum.Check("low"))
+	assert.EqualError(t, priorityEnum.Check("someday"), `Invalid priority "someday": must be one of low, medium, high, urgent`)
+
+	assert.True(t, assignableRole(RoleAdmin))
+	assert.False(t, assignableRole(RoleGuest))
+	assert.False(t, assignableRole("root"))
+}
+
+func TestRespondWithEnumError(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Handler{}).respondWithEnumError(w, priorityEnum, "someday")
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var response struct {
+		ErrorResponse
+		Details EnumViolation `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "invalid_enum_value", response.Code)
+	assert.Equal(t, EnumViolation{Field: "priority", Value: "someday", Allowed: priorityEnum.Strings()}, response.Details)
+}
+
+func TestGetEnums(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Handler{}).GetEnums(w, httptest.NewRequest(http.MethodGet, "/api/meta/enums", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var enums map[string]struct {
+		Values  []EnumValue `json:"values"`
+		Default string      `json:"default"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enums))
+	assert.ElementsMatch(t, []string{"priority", "status", "ruleAction", "role"}, keys(enums))
+	assert.Equal(t, EnumValue{Value: "high", Label: "High"}, enums["priority"].Values[2])
+	assert.Equal(t, "medium", enums["priority"].Default)
+	assert.Len(t, enums["status"].Values, 2)
+	assert.Equal(t, EnumValue{Value: RuleAddTag, Label: "Add tag"}, enums["ruleAction"].Values[1])
+}
+
+func TestSchemaEnums(t *testing.T) {
+	schema := jsonSchema(reflect.TypeOf(CreateTaskRequest{}))
+	priority := schema["properties"].(map[string]interface{})["priority"].(map[string]interface{})
+	assert.Equal(t, priorityEnum.Strings(), priority["enum"])
+	assert.Equal(t, "medium", priority["default"])
+
+	// Pointer fields carry the same enum
+	schema = jsonSchema(reflect.TypeOf(UpdateTaskRequest{}))
+	priority = schema["properties"].(map[string]interface{})["priority"].(map[string]interface{})
+	assert.Equal(t, priorityEnum.Strings(), priority["enum"])
+}
+
+func keys[V any](m map[string]V) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestTaskEnumValidation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "enums@example.com")
+
+	req := taskRequest(http.MethodPost, "/api/tasks", user.Token, `{"title": "Pick", "priority": "someday"}`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, env.serveWithAuth(env.handler.CreateTask, req).Code)
+
+	req = taskRequest(http.MethodPost, "/api/tasks", user.Token, `{"title": "Pick"}`, nil)
+	w := env.serveWithAuth(env.handler.CreateTask, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, priorityEnum.Default, task.Priority)
+
+	vars := map[string]string{"id": task.ID.String()}
+	req = taskRequest(http.MethodPut, "/api/tasks/"+task.ID.String(), user.Token, `{"priority": "someday"}`, vars)
+	assert.Equal(t, http.StatusUnprocessableEntity, env.serveWithAuth(env.handler.UpdateTask, req).Code)
+
+	req = taskRequest(http.MethodGet, "/api/tasks?status=open", user.Token, "", nil)
+	w = env.serveWithAuth(env.handler.GetTasks, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"count":1`)
+
+	req = taskRequest(http.MethodGet, "/api/tasks?status=done", user.Token, "", nil)
+	assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.GetTasks, req).Code)
+}
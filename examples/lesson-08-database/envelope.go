@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response envelopes. Endpoints grew different shapes over time: a task is a
+// bare object, a task list is TaskListResponse, other lists are ad-hoc maps
+// such as {"categories": [...], "count": 2}. With RESPONSE_ENVELOPE=true
+// every successful JSON response on /api is rewritten to one shape:
+//
+//	{"data": ..., "meta": {"count": 2, ...}, "links": {"self": "...", "next": "..."}}
+//
+// List metadata (count, totalCount, page, limit, nextCursor) moves to meta and
+// the list itself becomes data. Error responses keep the ErrorResponse shape,
+// which is already the same everywhere, and downloads (Content-Disposition:
+// attachment) are files rather than resources, so they stream unchanged. Clients that still expect the legacy
+// shapes use /api/v1, which serves the same routes without envelopes.
+
+const apiVersionKey = "api_version"
+
+// envelopeMetaFields are lifted out of list responses into meta.
+var envelopeMetaFields = map[string]bool{
+	"count":      true,
+	"totalCount": true,
+	"page":       true,
+	"limit":      true,
+	"nextCursor": true,
+}
+
+type Envelope struct {
+	Data  json.RawMessage            `json:"data"`
+	Meta  map[string]json.RawMessage `json:"meta"`
+	Links map[string]string          `json:"links"`
+}
+
+func envelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiVersion(r) == APIVersion1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
+
+// envelopeWriter holds back successful JSON responses until the handler is
+// done, so they can be wrapped; everything else passes straight through.
+type envelopeWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	buffering   bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (ew *envelopeWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+
+	contentType := ew.Header().Get("Content-Type")
+	if code >= 200 && code < 300 && code != http.StatusNoContent && strings.HasPrefix(contentType, "application/json") &&
+		!strings.HasPrefix(ew.Header().Get("Content-Disposition"), "attachment") {
+		ew.buffering = true
+		ew.status = code
+		return
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *envelopeWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.buffering {
+		return ew.body.Write(b)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *envelopeWriter) finish(r *http.Request) {
+	if !ew.buffering {
+		return
+	}
+
+	body := ew.body.Bytes()
+	if wrapped, err := wrapInEnvelope(body, r); err == nil {
+		body = wrapped
+	}
+	ew.Header().Del("Content-Length")
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(body)
+}
+
+// wrapInEnvelope turns a legacy response body into an Envelope.
+func wrapInEnvelope(body []byte, r *http.Request) ([]byte, error) {
+	envelope := Envelope{
+		Data:  json.RawMessage(bytes.TrimSpace(body)),
+		Meta:  map[string]json.RawMessage{},
+		Links: map[string]string{"self": r.URL.RequestURI()},
+	}
+
+	// A list is an object with exactly one array besides the meta fields
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) == nil {
+		var listField string
+		others := 0
+		for name, value := range fields {
+			if envelopeMetaFields[name] {
+				continue
+			}
+			if trimmed := bytes.TrimSpace(value); len(trimmed) > 0 && trimmed[0] == '[' {
+				listField = name
+			}
+			others++
+		}
+		if others == 1 && listField != "" {
+			envelope.Data = fields[listField]
+			for name, value := range fields {
+				if envelopeMetaFields[name] {
+					envelope.Meta[name] = value
+				}
+			}
+			if next := nextLink(r, fields); next != "" {
+				envelope.Links["next"] = next
+			}
+		}
+	}
+
+	if !json.Valid(envelope.Data) {
+		return nil, errors.New("response body is not JSON")
+	}
+	wrapped, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	return append(wrapped, '\n'), nil
+}
+
+// nextLink points at the following page, by cursor when the list has one,
+// otherwise by offset.
+func nextLink(r *http.Request, fields map[string]json.RawMessage) string {
+	query := r.URL.Query()
+
+	var cursor string
+	if json.Unmarshal(fields["nextCursor"], &cursor) == nil && cursor != "" {
+		query.Set("cursor", cursor)
+		return r.URL.Path + "?" + query.Encode()
+	}
+	if query.Has("cursor") {
+		return ""
+	}
+
+	var limit int
+	var total int64
+	if json.Unmarshal(fields["limit"], &limit) != nil || json.Unmarshal(fields["totalCount"], &total) != nil || limit <= 0 {
+		return ""
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if next := offset + limit; int64(next) < total {
+		query.Set("offset", strconv.Itoa(next))
+		return r.URL.Path + "?" + query.Encode()
+	}
+	return ""
+}
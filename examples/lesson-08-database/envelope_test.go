@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const envelopeTaskID = "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f"
+
+func envelopeRouter() http.Handler {
+	h := &Handler{}
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(envelopeMiddleware)
+	api.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		h.respondWithJSON(w, http.StatusOK, TaskListResponse{
+			Tasks: []TaskResponse{{ID: envelopeTaskID, Title: "First"}}, Count: 1, TotalCount: 3, Page: 1, Limit: 1,
+		})
+	})
+	api.HandleFunc("/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		h.respondWithJSON(w, http.StatusOK, TaskResponse{ID: TaskID(mux.Vars(r)["id"]), Title: "First"})
+	})
+	api.HandleFunc("/categories", func(w http.ResponseWriter, r *http.Request) {
+		h.respondWithJSON(w, http.StatusOK, map[string]interface{}{"categories": []Category{}, "count": 0})
+	})
+	api.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		h.respondWithError(w, http.StatusNotFound, "Task not found")
+	})
+	api.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("plain"))
+	})
+	api.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="tasks.json"`)
+		w.Write([]byte(`[]`))
+	})
+	return apiVersionHandler(router, true, time.Time{})
+}
+
+func serveEnvelope(t *testing.T, path string) (*httptest.ResponseRecorder, Envelope) {
+	w := httptest.NewRecorder()
+	envelopeRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var envelope Envelope
+	json.Unmarshal(w.Body.Bytes(), &envelope)
+	return w, envelope
+}
+
+func TestEnvelopeWrapsObjects(t *testing.T) {
+	w, envelope := serveEnvelope(t, "/api/tasks/"+envelopeTaskID)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(envelope.Data, &task))
+	assert.Equal(t, TaskID(envelopeTaskID), task.ID)
+	assert.Empty(t, envelope.Meta)
+	assert.Equal(t, map[string]string{"self": "/api/tasks/" + envelopeTaskID}, envelope.Links)
+}
+
+func TestEnvelopeLiftsListMeta(t *testing.T) {
+	_, envelope := serveEnvelope(t, "/api/tasks?limit=1&completed=false")
+	var tasks []TaskResponse
+	require.NoError(t, json.Unmarshal(envelope.Data, &tasks))
+	assert.Len(t, tasks, 1)
+	assert.JSONEq(t, "3", string(envelope.Meta["totalCount"]))
+	assert.JSONEq(t, "1", string(envelope.Meta["count"]))
+	assert.Equal(t, "/api/tasks?completed=false&limit=1&offset=1", envelope.Links["next"])
+
+	_, envelope = serveEnvelope(t, "/api/categories")
+	assert.JSONEq(t, "[]", string(envelope.Data))
+	assert.JSONEq(t, "0", string(envelope.Meta["count"]))
+	assert.NotContains(t, envelope.Links, "next")
+}
+
+func TestEnvelopeNextLink(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks?cursor=&limit=2", nil)
+	fields := map[string]json.RawMessage{"nextCursor": json.RawMessage(`"abc"`), "limit": json.RawMessage("2")}
+	assert.Equal(t, "/api/tasks?cursor=abc&limit=2", nextLink(req, fields))
+
+	// The last page in cursor mode has no next link, even with offset fields
+	fields = map[string]json.RawMessage{"limit": json.RawMessage("2"), "totalCount": json.RawMessage("10")}
+	assert.Empty(t, nextLink(req, fields))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks?limit=2&offset=8", nil)
+	assert.Empty(t, nextLink(req, fields))
+}
+
+func TestEnvelopeLeavesOtherResponses(t *testing.T) {
+	w, _ := serveEnvelope(t, "/api/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var errorResponse ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errorResponse))
+	assert.Equal(t, "Task not found", errorResponse.Message)
+
+	w, _ = serveEnvelope(t, "/api/download")
+	assert.Equal(t, "plain", w.Body.String())
+
+	w, _ = serveEnvelope(t, "/api/export")
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+func TestLegacyAPIV1(t *testing.T) {
+	w, _ := serveEnvelope(t, "/api/v1/tasks/"+envelopeTaskID)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, TaskID(envelopeTaskID), task.ID, "v1 keeps the bare object")
+	assert.NotContains(t, w.Body.String(), `"data"`)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Example recording for the API docs. With RECORD_EXAMPLES=true (ignored
+// when APP_ENV=production) the first successful request and response of
+// every route are kept, sanitized, and served as the examples of
+// GET /api/openapi.json, so the samples in the docs come from real traffic
+// and change with the code. They live in memory and are recorded afresh
+// after a restart; exercising the API once (e.g. with the integration
+// tests' flows) fills them in.
+
+const maxExampleBodyBytes = 16 << 10
+
+// Recorded values of sensitive fields are replaced. Keys are compared in
+// lower case without underscores, so both field cases match.
+var (
+	sensitiveExampleKeys     = map[string]bool{"key": true, "apikey": true, "code": true, "devicecode": true, "usercode": true}
+	sensitiveExampleSuffixes = []string{"password", "token", "secret", "hash"}
+)
+
+const (
+	redactedExampleValue = "[redacted]"
+	exampleEmail         = "user@example.com"
+)
+
+// RecordedExample is a request and response pair of one route.
+type RecordedExample struct {
+	Request    json.RawMessage `json:"request,omitempty"`
+	Status     int             `json:"status"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	RecordedAt time.Time       `json:"recordedAt"`
+}
+
+// ExampleRecorder keeps the first example of each route, keyed by method and
+// path template ("GET /api/tasks/{id}").
+type ExampleRecorder struct {
+	mu       sync.Mutex
+	examples map[string]RecordedExample
+}
+
+func NewExampleRecorder() *ExampleRecorder {
+	return &ExampleRecorder{examples: make(map[string]RecordedExample)}
+}
+
+// Examples returns a copy of the recorded examples.
+func (rec *ExampleRecorder) Examples() map[string]RecordedExample {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	examples := make(map[string]RecordedExample, len(rec.examples))
+	for key, example := range rec.examples {
+		examples[key] = example
+	}
+	return examples
+}
+
+func (rec *ExampleRecorder) has(key string) bool {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	_, ok := rec.examples[key]
+	return ok
+}
+
+func (rec *ExampleRecorder) store(key string, example RecordedExample) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if _, ok := rec.examples[key]; !ok {
+		rec.examples[key] = example
+	}
+}
+
+// Middleware records routes that have no example yet. It belongs inside the
+// field case middleware, so examples use the structs' camelCase, and outside
+// the envelope middleware, so they show what clients of /api receive;
+// requests in an explicit version (/api/v1, /api/v2) aren't recorded.
+func (rec *ExampleRecorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if apiVersion(r) != "" || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		key := r.Method + " " + template
+		if err != nil || rec.has(key) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var requestBody []byte
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && r.Body != nil {
+			requestBody, _ = io.ReadAll(io.LimitReader(r.Body, maxExampleBodyBytes+1))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(requestBody), r.Body))
+		}
+
+		ew := &exampleWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+
+		if ew.status < 200 || ew.status >= 300 || ew.truncated || len(requestBody) > maxExampleBodyBytes {
+			return
+		}
+		example := RecordedExample{Status: ew.status, RecordedAt: time.Now().UTC()}
+		if len(requestBody) > 0 {
+			if example.Request = sanitizeExample(requestBody); example.Request == nil {
+				return
+			}
+		}
+		if ew.body.Len() > 0 {
+			// Downloads and other non-JSON bodies aren't useful as examples
+			contentType := ew.Header().Get("Content-Type")
+			if !strings.HasPrefix(contentType, "application/json") || isAttachment(ew.Header()) {
+				return
+			}
+			if example.Response = sanitizeExample(ew.body.Bytes()); example.Response == nil {
+				return
+			}
+		}
+		rec.store(key, example)
+	})
+}
+
+func isAttachment(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Disposition"), "attachment")
+}
+
+// exampleWriter passes the response through while keeping a copy of up to
+// maxExampleBodyBytes of it.
+type exampleWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (ew *exampleWriter) WriteHeader(code int) {
+	if ew.status == 0 {
+		ew.status = code
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *exampleWriter) Write(b []byte) (int, error) {
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+	if ew.body.Len()+len(b) > maxExampleBodyBytes {
+		ew.truncated = true
+	} else {
+		ew.body.Write(b)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (ew *exampleWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// sanitizeExample replaces credentials and email addresses in a JSON body;
+// it returns nil for bodies that aren't JSON.
+func sanitizeExample(body []byte) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	sanitized, err := json.Marshal(sanitizeExampleValue("", value))
+	if err != nil {
+		return nil
+	}
+	return sanitized
+}
+
+func sanitizeExampleValue(key string, value interface{}) interface{} {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "_", ""))
+	if _, isString := value.(string); isString {
+		if sensitiveExampleKeys[normalized] {
+			return redactedExampleValue
+		}
+		for _, suffix := range sensitiveExampleSuffixes {
+			if strings.HasSuffix(normalized, suffix) {
+				return redactedExampleValue
+			}
+		}
+		if strings.HasSuffix(normalized, "email") {
+			return exampleEmail
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			v[k] = sanitizeExampleValue(k, field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = sanitizeExampleValue(key, item)
+		}
+	}
+	return value
+}
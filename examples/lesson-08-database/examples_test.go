@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeExample(t *testing.T) {
+	sanitized := sanitizeExample([]byte(`{
+		"email": "alice@corp.example", "password": "Tasks-Pass-2024", "access_token": "eyJ...",
+		"user": {"id": "u1", "passwordHash": "$argon2id$..."},
+		"apiKeys": [{"key": "tk_live_123", "name": "CI"}],
+		"count": 12345678901234567890, "dueDate": null
+	}`))
+	assert.JSONEq(t, `{
+		"email": "user@example.com", "password": "[redacted]", "access_token": "[redacted]",
+		"user": {"id": "u1", "passwordHash": "[redacted]"},
+		"apiKeys": [{"key": "[redacted]", "name": "CI"}],
+		"count": 12345678901234567890, "dueDate": null
+	}`, string(sanitized))
+
+	assert.Nil(t, sanitizeExample([]byte("not json")))
+}
+
+func TestExampleRecorder(t *testing.T) {
+	recorder := NewExampleRecorder()
+	h := &Handler{}
+	router := mux.NewRouter()
+	router.Use(recorder.Middleware)
+	router.HandleFunc("/api/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			h.respondWithError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		h.respondWithJSON(w, http.StatusOK, map[string]string{"id": mux.Vars(r)["id"]})
+	}).Methods("GET")
+	router.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		h.respondWithJSON(w, http.StatusOK, map[string]string{"accessToken": "eyJ..."})
+	}).Methods("POST")
+	router.HandleFunc("/api/tasks/export", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte("id\n"))
+	}).Methods("GET")
+
+	serve := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve(http.MethodGet, "/api/tasks/missing", "")
+	serve(http.MethodGet, "/api/tasks/t1", "")
+	serve(http.MethodGet, "/api/tasks/t2", "")
+	serve(http.MethodPost, "/api/auth/login", `{"email": "alice@corp.example", "password": "secret"}`)
+	serve(http.MethodGet, "/api/tasks/export", "")
+
+	examples := recorder.Examples()
+	require.Len(t, examples, 2, "errors and non-JSON downloads aren't recorded")
+	assert.JSONEq(t, `{"id": "t1"}`, string(examples["GET /api/tasks/{id}"].Response), "the first success wins")
+	login := examples["POST /api/auth/login"]
+	assert.JSONEq(t, `{"email": "user@example.com", "password": "[redacted]"}`, string(login.Request))
+	assert.JSONEq(t, `{"accessToken": "[redacted]"}`, string(login.Response))
+	assert.Equal(t, http.StatusOK, login.Status)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	endpoints := []APIEndpoint{
+		{Method: "POST", Path: "/api/auth/login", Auth: "none"},
+		{Method: "GET", Path: "/api/tasks/{id}", Auth: "bearer", Scope: ScopeTasksRead},
+		{Method: "PUT", Path: "/api/tasks/{id}", Auth: "bearer", Scope: ScopeTasksWrite},
+	}
+	examples := map[string]RecordedExample{
+		"GET /api/tasks/{id}": {Status: http.StatusOK, Response: json.RawMessage(`{"id": "t1"}`)},
+	}
+
+	body, err := json.Marshal(newOpenAPIDocument(endpoints, examples))
+	require.NoError(t, err)
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters  []map[string]interface{} `json:"parameters"`
+			Security    []map[string][]string    `json:"security"`
+			RequestBody map[string]interface{}   `json:"requestBody"`
+			Responses   map[string]struct {
+				Content map[string]struct {
+					Schema   map[string]interface{} `json:"schema"`
+					Examples map[string]struct {
+						Value interface{} `json:"value"`
+					} `json:"examples"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(body, &document))
+	assert.Equal(t, "3.1.0", document.OpenAPI)
+
+	get := document.Paths["/api/tasks/{id}"]["get"]
+	assert.Equal(t, "id", get.Parameters[0]["name"])
+	assert.Equal(t, []string{ScopeTasksRead}, get.Security[0]["bearer"])
+	assert.Equal(t, map[string]interface{}{"id": "t1"}, get.Responses["200"].Content["application/json"].Examples["recorded"].Value)
+	assert.Contains(t, get.Responses, "default")
+
+	assert.NotNil(t, document.Paths["/api/tasks/{id}"]["put"].RequestBody, "update-task schema")
+	login := document.Paths["/api/auth/login"]["post"]
+	assert.Nil(t, login.Security)
+	assert.NotContains(t, login.Responses, "200", "no example recorded yet")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fieldcase"
+)
+
+// Task exports stream every task the user can see, owned and shared, as a
+// download. Tasks are read in keyset batches of exportBatchSize and each
+// batch is written and flushed before the next is read, so memory use and
+// the time a database connection is held don't grow with the export.
+//
+// Once the first batch has been sent the status can't change anymore; if a
+// later batch fails the connection is aborted, so clients see a truncated
+// transfer instead of a file that merely looks complete.
+//
+// An export has exportTimeout rather than the default request deadline, and
+// the server's 30s write timeout is extended to match.
+
+const (
+	exportBatchSize = 500
+	exportTimeout   = 5 * time.Minute
+)
+
+// exportColumns are the CSV columns, named like the JSON fields.
+var exportColumns = []string{
+	"id", "title", "description", "completed", "priority", "dueDate", "location",
+	"tags", "categories", "userId", "createdAt", "updatedAt",
+}
+
+// taskExporter writes one export format.
+type taskExporter interface {
+	ContentType() string
+	Extension() string
+	Begin() error
+	Write(task *TaskResponse) error
+	// Flush pushes buffered output to the response writer
+	Flush() error
+	End() error
+}
+
+func newTaskExporter(format string, w io.Writer, c fieldcase.Case) (taskExporter, error) {
+	switch format {
+	case "", "json":
+		return &jsonTaskExporter{w: w, fieldCase: c}, nil
+	case "csv":
+		return &csvTaskExporter{w: csv.NewWriter(w), fieldCase: c}, nil
+	}
+	return nil, fmt.Errorf("Invalid format %q: must be csv or json", format)
+}
+
+// jsonTaskExporter writes a JSON array of tasks, in the same shape as
+// GET /api/tasks/{id}.
+type jsonTaskExporter struct {
+	w         io.Writer
+	fieldCase fieldcase.Case
+	written   int
+}
+
+func (e *jsonTaskExporter) ContentType() string { return "application/json" }
+func (e *jsonTaskExporter) Extension() string   { return "json" }
+
+func (e *jsonTaskExporter) Begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonTaskExporter) Write(task *TaskResponse) error {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	// The field case middleware leaves downloads alone
+	if e.fieldCase != fieldcase.Camel {
+		if body, err = fieldcase.Transform(body, e.fieldCase); err != nil {
+			return err
+		}
+	}
+	if e.written > 0 {
+		if _, err := io.WriteString(e.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	e.written++
+	_, err = e.w.Write(body)
+	return err
+}
+
+func (e *jsonTaskExporter) Flush() error { return nil }
+
+func (e *jsonTaskExporter) End() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// csvTaskExporter writes a header row and one row per task. Tags and
+// category names are joined with "|"; times are RFC 3339.
+type csvTaskExporter struct {
+	w         *csv.Writer
+	fieldCase fieldcase.Case
+}
+
+func (e *csvTaskExporter) ContentType() string { return "text/csv; charset=utf-8" }
+func (e *csvTaskExporter) Extension() string   { return "csv" }
+
+func (e *csvTaskExporter) Begin() error {
+	header := make([]string, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = e.fieldCase.Key(column)
+	}
+	return e.w.Write(header)
+}
+
+func (e *csvTaskExporter) Write(task *TaskResponse) error {
+	dueDate := ""
+	if task.DueDate != nil {
+		dueDate = task.DueDate.UTC().Format(time.RFC3339)
+	}
+	categories := make([]string, len(task.Categories))
+	for i, category := range task.Categories {
+		categories[i] = category.Name
+	}
+	return e.w.Write([]string{
+		task.ID.String(),
+		task.Title,
+		task.Description,
+		strconv.FormatBool(task.Completed),
+		task.Priority,
+		dueDate,
+		task.Location,
+		strings.Join(task.Tags, "|"),
+		strings.Join(categories, "|"),
+		task.UserID.String(),
+		task.CreatedAt.UTC().Format(time.RFC3339),
+		task.UpdatedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (e *csvTaskExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvTaskExporter) End() error { return e.Flush() }
+
+// ExportTasks handles GET /api/tasks/export?format=csv|json
+func (h *Handler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	fieldCase, err := fieldcase.Parse(w.Header().Get(fieldcase.Header))
+	if err != nil {
+		fieldCase = fieldcase.Camel
+	}
+	exporter, err := newTaskExporter(r.URL.Query().Get("format"), w, fieldCase)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Read the first batch before committing to a 200
+	filters := TaskFilters{IncludeShared: true, Limit: exportBatchSize}
+	tasks, err := h.taskRepo.GetByUserID(r.Context(), userID, filters)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to export tasks")
+		return
+	}
+
+	w.Header().Set("Content-Type", exporter.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasks-%s.%s"`,
+		time.Now().UTC().Format("20060102"), exporter.Extension()))
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if deadline, ok := r.Context().Deadline(); ok {
+		if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.WarnContext(r.Context(), "failed to extend the write deadline of the export", "error", err)
+		}
+	}
+	exported := 0
+	abort := func(err error) {
+		slog.ErrorContext(r.Context(), "task export failed", "user_id", userID, "exported", exported, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err := exporter.Begin(); err != nil {
+		abort(err)
+	}
+	for {
+		for _, task := range tasks {
+			response := newTaskResponse(task)
+			if err := exporter.Write(&response); err != nil {
+				abort(err)
+			}
+			exported++
+		}
+		if err := exporter.Flush(); err != nil {
+			abort(err)
+		}
+		// Writers that can't flush still stream once net/http's buffer fills
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			abort(err)
+		}
+
+		if len(tasks) < exportBatchSize {
+			break
+		}
+		filters.Cursor = taskCursorAfter(tasks[len(tasks)-1])
+		if tasks, err = h.taskRepo.GetByUserID(r.Context(), userID, filters); err != nil {
+			abort(err)
+		}
+	}
+	if err := exporter.End(); err != nil {
+		abort(err)
+	}
+}
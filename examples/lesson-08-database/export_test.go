@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fieldcase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportTestTasks() []TaskResponse {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []TaskResponse{
+		{
+			ID: "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f", Title: "Write report, draft", Priority: "high", DueDate: &due,
+			Tags:       []string{"work", "q1"},
+			Categories: []CategoryResponse{{Name: "Work"}, {Name: "Urgent"}},
+			UserID:     ownerUserID, CreatedAt: created, UpdatedAt: created,
+		},
+		{ID: "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d", Title: "Say \"hi\"", Priority: "low", Completed: true, UserID: ownerUserID, CreatedAt: created, UpdatedAt: created},
+	}
+}
+
+func runExporter(t *testing.T, format string, c fieldcase.Case) string {
+	var buf bytes.Buffer
+	exporter, err := newTaskExporter(format, &buf, c)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Begin())
+	for _, task := range exportTestTasks() {
+		require.NoError(t, exporter.Write(&task))
+	}
+	require.NoError(t, exporter.End())
+	return buf.String()
+}
+
+func TestCSVTaskExporter(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(runExporter(t, "csv", fieldcase.Snake))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "due_date", records[0][5])
+	assert.Equal(t, []string{
+		"6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f", "Write report, draft", "", "false", "high", "2026-03-01T09:00:00Z", "",
+		"work|q1", "Work|Urgent", ownerUserID, "2026-01-02T03:04:05Z", "2026-01-02T03:04:05Z",
+	}, records[1])
+	assert.Equal(t, `Say "hi"`, records[2][1])
+	assert.Equal(t, "", records[2][5])
+}
+
+func TestJSONTaskExporter(t *testing.T) {
+	var tasks []map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(runExporter(t, "json", fieldcase.Camel)), &tasks))
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "2026-03-01T09:00:00Z", tasks[0]["dueDate"])
+
+	require.NoError(t, json.Unmarshal([]byte(runExporter(t, "", fieldcase.Snake)), &tasks))
+	assert.Contains(t, tasks[1], "due_date")
+
+	var empty bytes.Buffer
+	exporter, _ := newTaskExporter("json", &empty, fieldcase.Camel)
+	exporter.Begin()
+	exporter.End()
+	assert.JSONEq(t, `[]`, empty.String())
+
+	_, err := newTaskExporter("xml", &empty, fieldcase.Camel)
+	assert.Error(t, err)
+}
+
+func TestExportTasks(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "export@example.com")
+	for _, title := range []string{"First", "Second"} {
+		_, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+			CreateTaskRequest{Title: title, Priority: "medium", CategoryNames: []string{"Work"}}, user.User.ID)
+		require.NoError(t, err)
+	}
+
+	req := taskRequest(http.MethodGet, "/api/tasks/export?format=csv", user.Token, "", nil)
+	w := env.serveWithAuth(env.handler.ExportTasks, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "Second", records[1][1], "newest first")
+	assert.Equal(t, "Work", records[1][8])
+
+	req = taskRequest(http.MethodGet, "/api/tasks/export?format=json", user.Token, "", nil)
+	w = env.serveWithAuth(env.handler.ExportTasks, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var tasks []TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tasks))
+	assert.Len(t, tasks, 2)
+
+	req = taskRequest(http.MethodGet, "/api/tasks/export?format=pdf", user.Token, "", nil)
+	assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.ExportTasks, req).Code)
+}
+
+// gatedTaskRepository serves the export's first batch and holds the next
+// one back until release is closed.
+type gatedTaskRepository struct {
+	TaskRepository
+	first   []*Task
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (r *gatedTaskRepository) GetByUserID(ctx context.Context, userID UserID, filters TaskFilters) ([]*Task, error) {
+	if r.calls.Add(1) == 1 {
+		return r.first, nil
+	}
+	select {
+	case <-r.release:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Through the router, so the middlewares in front of the handler have to
+// let each batch through as it is flushed.
+func TestExportTasksStreamsThroughRouter(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "export-stream@example.com")
+
+	repo := &gatedTaskRepository{TaskRepository: env.handler.taskRepo, release: make(chan struct{})}
+	var releaseOnce sync.Once
+	release := func() { releaseOnce.Do(func() { close(repo.release) }) }
+	t.Cleanup(release)
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < exportBatchSize; i++ {
+		repo.first = append(repo.first, &Task{
+			ID: NewID[taskEntity](), Title: fmt.Sprintf("Task %d", i), Priority: "medium",
+			UserID: user.User.ID, CreatedAt: created, UpdatedAt: created,
+		})
+	}
+	handler := *env.handler
+	handler.taskRepo = repo
+	router, err := newRouter(loadConfig(), &handler, env.db)
+	require.NoError(t, err)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/tasks/export?format=csv", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The first batch arrives while the handler waits for the second
+	lines := make(chan int, 1)
+	reader := bufio.NewReader(resp.Body)
+	go func() {
+		read := 0
+		for read <= exportBatchSize {
+			if _, err := reader.ReadString('\n'); err != nil {
+				break
+			}
+			read++
+		}
+		lines <- read
+	}()
+	select {
+	case read := <-lines:
+		assert.Equal(t, exportBatchSize+1, read, "header and first batch")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first batch was held back until the export finished")
+	}
+	assert.Eventually(t, func() bool { return repo.calls.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	release()
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
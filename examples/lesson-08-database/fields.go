@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Sparse fieldsets. ?fields=id,title,dueDate trims each object in a response
+// to the named JSON fields, so clients on slow links only pay for what they
+// display. Field names are the JSON names of the model and are checked
+// against it, so a typo is a 400 rather than an empty object.
+
+// FieldSet holds the requested fields; a nil FieldSet keeps every field.
+type FieldSet map[string]bool
+
+// parseFieldSet parses a ?fields= value against the JSON fields of model.
+func parseFieldSet(raw string, model interface{}) (FieldSet, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	known := jsonFieldNames(reflect.TypeOf(model))
+	fields := FieldSet{}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields[name] = true
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// jsonFieldNames lists the names t's fields are encoded under.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// projectFields encodes payload with the objects under listField trimmed to
+// fields; with an empty listField payload itself is trimmed. The rest of the
+// response, such as the counts of a list, is kept as it is.
+func projectFields(payload interface{}, listField string, fields FieldSet) (json.RawMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil || fields == nil {
+		return body, err
+	}
+	if listField == "" {
+		return projectObject(body, fields)
+	}
+
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(response[listField], &items); err != nil {
+		return nil, fmt.Errorf("field %q is not a list: %w", listField, err)
+	}
+	for i, item := range items {
+		if items[i], err = projectObject(item, fields); err != nil {
+			return nil, err
+		}
+	}
+	if response[listField], err = json.Marshal(items); err != nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
+
+func projectObject(body json.RawMessage, fields FieldSet) (json.RawMessage, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, err
+	}
+	for name := range object {
+		if !fields[name] {
+			delete(object, name)
+		}
+	}
+	return json.Marshal(object)
+}
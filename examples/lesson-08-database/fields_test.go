@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFieldSet(t *testing.T) {
+	fields, err := parseFieldSet(" id, title,,dueDate ", TaskResponse{})
+	require.NoError(t, err)
+	assert.Equal(t, FieldSet{"id": true, "title": true, "dueDate": true}, fields)
+
+	fields, err = parseFieldSet("", TaskResponse{})
+	require.NoError(t, err)
+	assert.Nil(t, fields)
+
+	for _, raw := range []string{"id,titel", "DueDate", "PasswordHash"} {
+		_, err := parseFieldSet(raw, TaskResponse{})
+		assert.Error(t, err, raw)
+	}
+	_, err = parseFieldSet("color", CategoryResponse{})
+	assert.NoError(t, err)
+}
+
+func TestProjectFields(t *testing.T) {
+	due := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	response := TaskListResponse{
+		Tasks:      []TaskResponse{{ID: "t1", Title: "First", DueDate: &due, Description: "long text"}},
+		Count:      1,
+		TotalCount: 1,
+		Page:       1,
+		Limit:      10,
+	}
+	fields := FieldSet{"id": true, "title": true, "dueDate": true}
+
+	body, err := projectFields(response, "tasks", fields)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"tasks": [{"id": "t1", "title": "First", "dueDate": "2024-05-01T00:00:00Z"}],
+		"count": 1, "totalCount": 1, "page": 1, "limit": 10
+	}`, string(body))
+
+	body, err = projectFields(&response.Tasks[0], "", FieldSet{"title": true})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title": "First"}`, string(body))
+
+	// Without a fieldset the payload is encoded unchanged
+	body, err = projectFields(response, "tasks", nil)
+	require.NoError(t, err)
+	full, _ := json.Marshal(response)
+	assert.JSONEq(t, string(full), string(body))
+
+	_, err = projectFields(response, "count", fields)
+	assert.Error(t, err)
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "fields@example.com")
+	ctx := context.Background()
+
+	task, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+		CreateTaskRequest{Title: "Sparse", Description: "Not needed", Priority: "high", CategoryNames: []string{"Work"}}, user.User.ID)
+	require.NoError(t, err)
+
+	req := taskRequest(http.MethodGet, "/api/tasks?fields=id,title", user.Token, "", nil)
+	w := env.serveWithAuth(env.handler.GetTasks, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list struct {
+		Tasks      []map[string]interface{} `json:"tasks"`
+		TotalCount int64                    `json:"totalCount"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.EqualValues(t, 1, list.TotalCount)
+	assert.Equal(t, []map[string]interface{}{{"id": task.ID.String(), "title": "Sparse"}}, list.Tasks)
+
+	req = taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"?fields=priority", user.Token, "", map[string]string{"id": task.ID.String()})
+	w = env.serveWithAuth(env.handler.GetTask, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"priority": "high"}`, w.Body.String())
+
+	req = taskRequest(http.MethodGet, "/api/categories?fields=name", user.Token, "", nil)
+	w = env.serveWithAuth(env.handler.GetCategories, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"categories": [{"name": "Work"}], "count": 1}`, w.Body.String())
+
+	req = taskRequest(http.MethodGet, "/api/tasks?fields=id,secret", user.Token, "", nil)
+	assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.GetTasks, req).Code)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Guest accounts let people try the API before signing up. They are real
+// users with the "guest" role, a placeholder email and no password, limited
+// to a few tasks until upgraded to a full account.
+const (
+	RoleGuest             = "guest"
+	guestEmailDomain      = "guest.invalid"
+	defaultGuestTaskLimit = 10
+
+	// unusablePasswordHash never verifies, so guests can't log in by password
+	unusablePasswordHash = "!"
+
+	GuestTaskLimitReached = "guest_task_limit"
+)
+
+// CreateGuest creates an anonymous guest account.
+func (s *RegistrationService) CreateGuest(ctx context.Context) (*User, error) {
+	id := NewID[userEntity]()
+	user := &User{
+		ID:           id,
+		Email:        fmt.Sprintf("guest-%s@%s", id, guestEmailDomain),
+		PasswordHash: unusablePasswordHash,
+		FirstName:    "Guest",
+		LastName:     "User",
+		Role:         RoleGuest,
+		IsActive:     true,
+	}
+
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpgradeGuest turns a guest into a regular account in place, so tasks and
+// categories created as a guest are kept. Signup rules apply as for Register.
+func (s *RegistrationService) UpgradeGuest(ctx context.Context, userID UserID, req RegisterRequest) (*User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != RoleGuest {
+		return nil, fmt.Errorf("account is not a guest account")
+	}
+
+	if err := s.domainPolicy.Check(req.Email); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// Set the password first: if the update below fails (e.g. the email is
+	// taken) the account is still an unusable guest login.
+	if err := s.userRepo.UpdatePasswordHash(ctx, user.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
+	user.Email = req.Email
+	user.FirstName = req.FirstName
+	user.LastName = req.LastName
+	user.Role = RoleUser
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+
+	user.PasswordHash = hashedPassword
+	return user, nil
+}
+
+// Guest Handlers
+func (h *Handler) CreateGuestSession(w http.ResponseWriter, r *http.Request) {
+	user, err := h.registration.CreateGuest(r.Context())
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create guest session")
+		return
+	}
+
+	response, err := h.issueTokenPair(r.Context(), user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, response)
+}
+
+// UpgradeGuest converts the calling guest into a full account. The guest's
+// tokens are revoked and a new token pair is returned.
+func (h *Handler) UpgradeGuest(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if role, _ := r.Context().Value("user_role").(string); role != RoleGuest {
+		h.respondWithError(w, http.StatusConflict, "Only guest accounts can be upgraded")
+		return
+	}
+
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Email == "" || req.Password == "" || req.FirstName == "" || req.LastName == "" {
+		h.respondWithError(w, http.StatusBadRequest, "All fields are required")
+		return
+	}
+
+	if violations := h.passwordPolicy.Validate(r.Context(), req.Password, req.Email); len(violations) > 0 {
+		h.respondWithPasswordViolations(w, violations)
+		return
+	}
+
+	user, err := h.registration.UpgradeGuest(r.Context(), userID, req)
+	if err != nil {
+		if strings.Contains(err.Error(), "not a guest") {
+			h.respondWithError(w, http.StatusConflict, "Only guest accounts can be upgraded")
+			return
+		}
+		h.respondWithRegistrationError(w, err)
+		return
+	}
+
+	// Tokens issued to the guest carry the old role
+	if claims, ok := r.Context().Value("token_claims").(*JWTClaims); ok {
+		h.jwtService.RevokeToken(r.Context(), claims)
+	}
+	h.refreshTokenRepo.RevokeAllForUser(r.Context(), user.ID)
+	h.recordAudit(r, &AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditRoleChange,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Metadata:   map[string]interface{}{"from": RoleGuest, "to": user.Role},
+	})
+
+	response, err := h.issueTokenPair(r.Context(), user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+// guestTaskLimitReached reports whether a guest already owns the maximum
+// number of tasks.
+func (h *Handler) guestTaskLimitReached(ctx context.Context, userID UserID) (bool, error) {
+	count, err := h.taskRepo.Count(ctx, userID, TaskFilters{})
+	if err != nil {
+		return false, err
+	}
+	return count >= int64(h.guestTaskLimit), nil
+}
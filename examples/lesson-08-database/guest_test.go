@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) createTestGuest(t *testing.T) LoginResponse {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/guest", nil)
+	w := httptest.NewRecorder()
+
+	env.handler.CreateGuestSession(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func (env *testEnv) createTaskAs(token, title string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(CreateTaskRequest{Title: title})
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return env.serveWithAuth(env.handler.CreateTask, req)
+}
+
+func (env *testEnv) upgradeGuest(token string, req RegisterRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/api/auth/upgrade", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	return env.serveWithAuth(env.handler.UpgradeGuest, httpReq)
+}
+
+func TestGuestTaskLimit(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	guest := env.createTestGuest(t)
+	assert.Equal(t, RoleGuest, guest.User.Role)
+
+	for i := 0; i < env.handler.guestTaskLimit; i++ {
+		w := env.createTaskAs(guest.Token, fmt.Sprintf("Guest task %d", i))
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w := env.createTaskAs(guest.Token, "One too many")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, GuestTaskLimitReached, errorCode(t, w))
+}
+
+func TestGuestUpgradeKeepsData(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	guest := env.createTestGuest(t)
+
+	w := env.createTaskAs(guest.Token, "Created as guest")
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = env.upgradeGuest(guest.Token, RegisterRequest{
+		Email:     "upgraded@example.com",
+		Password:  "Tasks-Pass-2024",
+		FirstName: "Up",
+		LastName:  "Graded",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var upgraded LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &upgraded))
+	assert.Equal(t, guest.User.ID, upgraded.User.ID, "the account is upgraded in place")
+	assert.Equal(t, "user", upgraded.User.Role)
+	assert.Equal(t, "upgraded@example.com", upgraded.User.Email)
+
+	// Tasks created as a guest are still there
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+upgraded.Token)
+	w = env.serveWithAuth(env.handler.GetTasks, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Created as guest")
+
+	// The guest token no longer works and the new credentials do
+	w = env.createTaskAs(guest.Token, "Stale token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	_, err := env.handler.verifyCredentials(req.Context(), "upgraded@example.com", "Tasks-Pass-2024")
+	assert.NoError(t, err)
+
+	// Upgrading twice is refused
+	w = env.upgradeGuest(upgraded.Token, RegisterRequest{
+		Email: "again@example.com", Password: "Tasks-Pass-2024", FirstName: "A", LastName: "B",
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestGuestUpgrade_EmailTaken(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.registerTestUser(t, "taken-upgrade@example.com")
+	guest := env.createTestGuest(t)
+
+	w := env.upgradeGuest(guest.Token, RegisterRequest{
+		Email:     "taken-upgrade@example.com",
+		Password:  "Tasks-Pass-2024",
+		FirstName: "Up",
+		LastName:  "Graded",
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Still a guest
+	user, err := env.handler.userRepo.GetByID(context.Background(), guest.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RoleGuest, user.Role)
+}
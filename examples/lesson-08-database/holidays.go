@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Public holidays depend on where a user works: Germany and the US share
+// few, and Bavaria has more than Berlin. A user's working hours name a
+// locale, an ISO 3166 country code such as "DE" or a subdivision such as
+// "DE-BY", and a HolidayProvider lists its holidays. The HOLIDAYS setting
+// still applies to everyone on top, for company-wide days off.
+const defaultHolidayCacheTTL = 24 * time.Hour
+
+// ErrUnknownLocale means the provider has no holidays for the locale.
+var ErrUnknownLocale = errors.New("unknown holiday locale")
+
+var localePattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// parseLocale normalizes a locale such as "de-by" to "DE-BY".
+func parseLocale(value string) (string, error) {
+	locale := strings.ToUpper(strings.TrimSpace(value))
+	if !localePattern.MatchString(locale) {
+		return "", fmt.Errorf("locale %q is not a country code like DE or a subdivision like DE-BY", value)
+	}
+	return locale, nil
+}
+
+// Holiday is a public holiday. Regions lists the subdivisions, such as
+// "DE-BY", that observe it; empty means the whole country does.
+type Holiday struct {
+	Date    string   `json:"date"`
+	Name    string   `json:"name"`
+	Regions []string `json:"regions,omitempty"`
+}
+
+// observedIn reports whether the holiday is a day off in locale.
+func (h Holiday) observedIn(locale string) bool {
+	return len(h.Regions) == 0 || slices.Contains(h.Regions, locale)
+}
+
+// HolidayProvider lists the public holidays of a year in a locale. Due date
+// suggestions use it through holidayCalendar; anything else that has to skip
+// days off, such as recurring tasks, can too.
+type HolidayProvider interface {
+	Holidays(ctx context.Context, locale string, year int) ([]Holiday, error)
+}
+
+// country is the country of a locale: "DE" for "DE-BY".
+func country(locale string) string {
+	code, _, _ := strings.Cut(locale, "-")
+	return code
+}
+
+// StaticHolidayProvider serves holidays from a JSON file, keyed by country:
+//
+//	{"DE": [{"date": "2026-10-03", "name": "German Unity Day"},
+//	        {"date": "2026-01-06", "name": "Epiphany", "regions": ["DE-BW", "DE-BY", "DE-ST"]}]}
+type StaticHolidayProvider struct {
+	countries map[string][]Holiday
+}
+
+// LoadHolidayFile reads and validates a holiday file.
+func LoadHolidayFile(path string) (*StaticHolidayProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read holiday file: %w", err)
+	}
+	var countries map[string][]Holiday
+	if err := json.Unmarshal(data, &countries); err != nil {
+		return nil, fmt.Errorf("invalid holiday file %s: %w", path, err)
+	}
+	for code, holidays := range countries {
+		if len(code) != 2 || !localePattern.MatchString(code) {
+			return nil, fmt.Errorf("invalid holiday file %s: %q is not an uppercase country code", path, code)
+		}
+		for _, holiday := range holidays {
+			if _, err := time.Parse(time.DateOnly, holiday.Date); err != nil {
+				return nil, fmt.Errorf("invalid holiday file %s: %s has date %q, want a date like 2026-12-25", path, code, holiday.Date)
+			}
+		}
+	}
+	return &StaticHolidayProvider{countries: countries}, nil
+}
+
+func (p *StaticHolidayProvider) Holidays(ctx context.Context, locale string, year int) ([]Holiday, error) {
+	holidays, ok := p.countries[country(locale)]
+	if !ok {
+		return nil, ErrUnknownLocale
+	}
+	prefix := strconv.Itoa(year) + "-"
+	var inYear []Holiday
+	for _, holiday := range holidays {
+		if strings.HasPrefix(holiday.Date, prefix) {
+			inYear = append(inYear, holiday)
+		}
+	}
+	return inYear, nil
+}
+
+// NagerDateProvider uses the public holiday API of date.nager.at, which
+// needs no API key, or a server with the same API.
+type NagerDateProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewNagerDateProvider(baseURL string) *NagerDateProvider {
+	return &NagerDateProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  newHTTPClient(10 * time.Second),
+	}
+}
+
+func (p *NagerDateProvider) Holidays(ctx context.Context, locale string, year int) ([]Holiday, error) {
+	endpoint := fmt.Sprintf("%s/PublicHolidays/%d/%s", p.baseURL, year, url.PathEscape(country(locale)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("holiday request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Unknown countries are a 404; some versions of the API answer 204
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNoContent:
+		return nil, ErrUnknownLocale
+	default:
+		return nil, fmt.Errorf("holiday API returned %d", resp.StatusCode)
+	}
+	var days []struct {
+		Date     string   `json:"date"`
+		Name     string   `json:"name"`
+		Counties []string `json:"counties"`
+		Types    []string `json:"types"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&days); err != nil {
+		return nil, fmt.Errorf("invalid holiday API response: %w", err)
+	}
+	holidays := make([]Holiday, 0, len(days))
+	for _, day := range days {
+		// Bank and school holidays aren't days off for everyone
+		if len(day.Types) > 0 && !slices.Contains(day.Types, "Public") {
+			continue
+		}
+		holidays = append(holidays, Holiday{Date: day.Date, Name: day.Name, Regions: day.Counties})
+	}
+	return holidays, nil
+}
+
+// CachedHolidayProvider caches the holidays of a country and year, so due
+// date suggestions that scan many days cost one upstream call per TTL.
+// Failures are not cached.
+type CachedHolidayProvider struct {
+	next HolidayProvider
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedHolidays
+}
+
+type cachedHolidays struct {
+	holidays  []Holiday
+	expiresAt time.Time
+}
+
+func NewCachedHolidayProvider(next HolidayProvider, ttl time.Duration) *CachedHolidayProvider {
+	return &CachedHolidayProvider{
+		next:    next,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cachedHolidays),
+	}
+}
+
+// Holidays caches by country rather than locale: providers answer for the
+// whole country, and its subdivisions share the entry.
+func (c *CachedHolidayProvider) Holidays(ctx context.Context, locale string, year int) ([]Holiday, error) {
+	key := fmt.Sprintf("%s/%d", country(locale), year)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expiresAt) {
+		return entry.holidays, nil
+	}
+
+	holidays, err := c.next.Holidays(ctx, locale, year)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedHolidays{holidays: holidays, expiresAt: now.Add(c.ttl)}
+	return holidays, nil
+}
+
+// holidayCalendar is the days off of a user: the company-wide ones and the
+// public holidays of their locale. Without a provider or a locale only the
+// company-wide ones count.
+type holidayCalendar struct {
+	company  CalendarProvider
+	provider HolidayProvider
+	locale   string
+}
+
+// IsHoliday reports whether the date of day, in its own location, is a
+// day off.
+func (c holidayCalendar) IsHoliday(ctx context.Context, day time.Time) (bool, error) {
+	if holiday, err := c.company.IsHoliday(ctx, day); err != nil || holiday {
+		return holiday, err
+	}
+	if c.provider == nil || c.locale == "" {
+		return false, nil
+	}
+	// A locale the provider no longer knows, say after the holiday file
+	// changed, has no holidays rather than failing every suggestion
+	holidays, err := c.provider.Holidays(ctx, c.locale, day.Year())
+	if errors.Is(err, ErrUnknownLocale) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	date := day.Format(time.DateOnly)
+	for _, holiday := range holidays {
+		if holiday.Date == date && holiday.observedIn(c.locale) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// calendarFor returns the calendar of a user with the given working hours.
+func (h *Handler) calendarFor(hours *WorkingHours) CalendarProvider {
+	return holidayCalendar{company: h.calendar, provider: h.holidays, locale: hours.Locale}
+}
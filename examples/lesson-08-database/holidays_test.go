@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHolidayProvider struct {
+	calls    int32
+	failures int32
+}
+
+func (f *fakeHolidayProvider) Holidays(ctx context.Context, locale string, year int) ([]Holiday, error) {
+	if atomic.AddInt32(&f.calls, 1) <= atomic.LoadInt32(&f.failures) {
+		return nil, fmt.Errorf("holiday API returned 503")
+	}
+	if country(locale) != "DE" {
+		return nil, ErrUnknownLocale
+	}
+	return []Holiday{
+		{Date: fmt.Sprintf("%d-10-03", year), Name: "German Unity Day"},
+		{Date: fmt.Sprintf("%d-01-06", year), Name: "Epiphany", Regions: []string{"DE-BW", "DE-BY", "DE-ST"}},
+	}, nil
+}
+
+func TestParseLocale(t *testing.T) {
+	for value, want := range map[string]string{"DE": "DE", " de-by ": "DE-BY", "us-ca": "US-CA"} {
+		locale, err := parseLocale(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, locale, value)
+	}
+	for _, value := range []string{"", "GER", "de_DE", "DE-", "DE-BAYERN"} {
+		_, err := parseLocale(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestStaticHolidayProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"DE": [
+		{"date": "2026-10-03", "name": "German Unity Day"},
+		{"date": "2026-01-06", "name": "Epiphany", "regions": ["DE-BW", "DE-BY", "DE-ST"]},
+		{"date": "2027-10-03", "name": "German Unity Day"}
+	]}`), 0o600))
+	provider, err := LoadHolidayFile(path)
+	require.NoError(t, err)
+
+	holidays, err := provider.Holidays(context.Background(), "DE-BY", 2026)
+	require.NoError(t, err)
+	assert.Len(t, holidays, 2)
+	_, err = provider.Holidays(context.Background(), "FR", 2026)
+	assert.ErrorIs(t, err, ErrUnknownLocale)
+
+	for name, content := range map[string]string{
+		"not JSON":         `DE: 2026-10-03`,
+		"subdivision key":  `{"DE-BY": []}`,
+		"lowercase key":    `{"de": []}`,
+		"date in any form": `{"DE": [{"date": "03.10.2026", "name": "German Unity Day"}]}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadHolidayFile(path)
+		assert.Error(t, err, name)
+	}
+	_, err = LoadHolidayFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestNagerDateProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/PublicHolidays/2026/DE" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"date":"2026-10-03","localName":"Tag der Deutschen Einheit","name":"German Unity Day","countryCode":"DE","counties":null,"types":["Public"]},
+			{"date":"2026-01-06","localName":"Heilige Drei Könige","name":"Epiphany","countryCode":"DE","counties":["DE-BW","DE-BY","DE-ST"],"types":["Public"]},
+			{"date":"2026-12-24","localName":"Heiligabend","name":"Christmas Eve","countryCode":"DE","counties":null,"types":["Bank"]}
+		]`)
+	}))
+	t.Cleanup(server.Close)
+	provider := NewNagerDateProvider(server.URL + "/api/v3/")
+
+	holidays, err := provider.Holidays(context.Background(), "DE-BY", 2026)
+	require.NoError(t, err)
+	assert.Equal(t, []Holiday{
+		{Date: "2026-10-03", Name: "German Unity Day"},
+		{Date: "2026-01-06", Name: "Epiphany", Regions: []string{"DE-BW", "DE-BY", "DE-ST"}},
+	}, holidays)
+
+	_, err = provider.Holidays(context.Background(), "XX", 2026)
+	assert.ErrorIs(t, err, ErrUnknownLocale)
+}
+
+func TestCachedHolidayProvider(t *testing.T) {
+	fake := &fakeHolidayProvider{failures: 1}
+	cache := NewCachedHolidayProvider(fake, time.Hour)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.Holidays(context.Background(), "DE", 2026)
+	assert.Error(t, err, "failures are not cached")
+
+	_, err = cache.Holidays(context.Background(), "DE", 2026)
+	require.NoError(t, err)
+	_, err = cache.Holidays(context.Background(), "DE-BY", 2026)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fake.calls), "subdivisions share the country's entry")
+
+	_, err = cache.Holidays(context.Background(), "DE", 2027)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fake.calls), "years are cached apart")
+
+	now = now.Add(2 * time.Hour)
+	_, err = cache.Holidays(context.Background(), "DE", 2026)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&fake.calls), "expired entries are refetched")
+}
+
+func TestHolidayCalendar(t *testing.T) {
+	ctx := context.Background()
+	company, err := NewFixedCalendar([]string{"2026-12-31"})
+	require.NoError(t, err)
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 0, 0, 0, 0, time.UTC) }
+
+	for name, tc := range map[string]struct {
+		calendar holidayCalendar
+		day      time.Time
+		holiday  bool
+	}{
+		"company day off":           {holidayCalendar{company: company}, day(12, 31), true},
+		"no provider":               {holidayCalendar{company: company, locale: "DE"}, day(10, 3), false},
+		"no locale":                 {holidayCalendar{company: company, provider: &fakeHolidayProvider{}}, day(10, 3), false},
+		"national holiday":          {holidayCalendar{company: company, provider: &fakeHolidayProvider{}, locale: "DE"}, day(10, 3), true},
+		"regional holiday":          {holidayCalendar{company: company, provider: &fakeHolidayProvider{}, locale: "DE-BY"}, day(1, 6), true},
+		"another region's holiday":  {holidayCalendar{company: company, provider: &fakeHolidayProvider{}, locale: "DE-BE"}, day(1, 6), false},
+		"country-wide locale":       {holidayCalendar{company: company, provider: &fakeHolidayProvider{}, locale: "DE"}, day(1, 6), false},
+		"locale without holidays":   {holidayCalendar{company: company, provider: &fakeHolidayProvider{}, locale: "FR"}, day(10, 3), false},
+		"company day off elsewhere": {holidayCalendar{company: company, provider: &fakeHolidayProvider{}, locale: "FR"}, day(12, 31), true},
+	} {
+		holiday, err := tc.calendar.IsHoliday(ctx, tc.day)
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.holiday, holiday, name)
+	}
+
+	_, err = holidayCalendar{company: company, provider: &fakeHolidayProvider{failures: 1}, locale: "DE"}.IsHoliday(ctx, day(10, 3))
+	assert.Error(t, err)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Idempotency keys make retried creates safe. A client that sends
+// Idempotency-Key with a POST gets the stored response of the first request
+// with that key instead of a second resource, e.g. when a mobile client
+// retries after the connection dropped before the 201 arrived.
+//
+// Keys are scoped to the user and remembered for idempotencyTTL. Reusing a
+// key for a different request is a 422; retrying while the first request is
+// still running is a 409. Server errors are not stored, so the retry runs
+// again.
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	defaultIdempotencyKeyTTL  = 24 * time.Hour
+	idempotencyInProgressWait = "1"
+)
+
+// idempotencyReplayHeaders are the response headers stored with a response
+// and sent again on replay.
+var idempotencyReplayHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// IdempotencyRecord is a key and, once the first request has finished, its
+// response. StatusCode is 0 while the first request is in progress.
+type IdempotencyRecord struct {
+	UserID      UserID
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Headers     map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+type IdempotencyRepository interface {
+	// Reserve claims the key for a new request. When the key is already
+	// taken it returns the existing record and false; records created
+	// before expiresBefore are replaced.
+	Reserve(ctx context.Context, record *IdempotencyRecord, expiresBefore time.Time) (*IdempotencyRecord, bool, error)
+	// Complete stores the response of a reserved key
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	// Release frees a reserved key so the request can be retried
+	Release(ctx context.Context, userID UserID, key string) error
+}
+
+type idempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) IdempotencyRepository {
+	return &idempotencyRepository{db: db}
+}
+
+func (r *idempotencyRepository) Reserve(ctx context.Context, record *IdempotencyRecord, expiresBefore time.Time) (*IdempotencyRecord, bool, error) {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND created_at < $3`,
+		record.UserID, record.Key, expiresBefore)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING
+		RETURNING created_at`,
+		record.UserID, record.Key, record.RequestHash,
+	).Scan(&record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	existing := &IdempotencyRecord{UserID: record.UserID, Key: record.Key}
+	var status sql.NullInt64
+	var headers []byte
+	err = r.db.QueryRowContext(ctx, `
+		SELECT request_hash, status_code, headers, body, created_at
+		FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key,
+	).Scan(&existing.RequestHash, &status, &headers, &existing.Body, &existing.CreatedAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	existing.StatusCode = int(status.Int64)
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &existing.Headers); err != nil {
+			return nil, false, fmt.Errorf("failed to decode idempotent response headers: %w", err)
+		}
+	}
+	return existing, false, nil
+}
+
+func (r *idempotencyRepository) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotent response headers: %w", err)
+	}
+	_, err = r.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status_code = $3, headers = $4, body = $5
+		WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key, record.StatusCode, headers, record.Body)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (r *idempotencyRepository) Release(ctx context.Context, userID UserID, key string) error {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// idempotencyRequestHash identifies what a key was first used for, so that
+// reusing it for another request can be detected.
+func idempotencyRequestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent wraps an authenticated POST handler. Requests without an
+// Idempotency-Key header are served as usual.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			h.respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := UserID(r.Context().Value("user_id").(string))
+		hash := idempotencyRequestHash(r, body)
+		record, reserved, err := h.idempotencyRepo.Reserve(r.Context(), &IdempotencyRecord{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hash,
+		}, time.Now().Add(-h.idempotencyTTL))
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to check idempotency key")
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.RequestHash != hash:
+				h.respondWithErrorCode(w, http.StatusUnprocessableEntity, "idempotency_key_reused",
+					idempotencyKeyHeader+" was already used for a different request")
+			case record.StatusCode == 0:
+				w.Header().Set("Retry-After", idempotencyInProgressWait)
+				h.respondWithErrorCode(w, http.StatusConflict, "idempotency_in_progress",
+					"A request with this "+idempotencyKeyHeader+" is still in progress")
+			default:
+				replayIdempotentResponse(w, record)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+		// The response is already on its way; storing it must not depend on
+		// the client still being connected
+		ctx := context.WithoutCancel(r.Context())
+		defer func() {
+			if !completed {
+				if err := h.idempotencyRepo.Release(ctx, userID, key); err != nil {
+					slog.ErrorContext(ctx, "failed to release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		next(rec, r)
+
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			return
+		}
+		record.StatusCode = rec.status
+		record.Headers = make(map[string]string)
+		for _, name := range idempotencyReplayHeaders {
+			if value := rec.Header().Get(name); value != "" {
+				record.Headers[name] = value
+			}
+		}
+		record.Body = rec.body.Bytes()
+		if err := h.idempotencyRepo.Complete(ctx, record); err != nil {
+			slog.ErrorContext(ctx, "failed to store idempotent response", "error", err)
+			return
+		}
+		completed = true
+	}
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, record *IdempotencyRecord) {
+	for name, value := range record.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// idempotencyRecorder passes the response through while keeping a copy.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryIdempotencyRepository keeps keys in a map, ignoring expiry.
+type memoryIdempotencyRepository struct {
+	mu      sync.Mutex
+	records map[string]*IdempotencyRecord
+}
+
+func (m *memoryIdempotencyRepository) Reserve(ctx context.Context, record *IdempotencyRecord, expiresBefore time.Time) (*IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.records[record.UserID.String()+"/"+record.Key]; ok {
+		copied := *existing
+		return &copied, false, nil
+	}
+	m.records[record.UserID.String()+"/"+record.Key] = record
+	return record, true, nil
+}
+
+func (m *memoryIdempotencyRepository) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *record
+	m.records[record.UserID.String()+"/"+record.Key] = &copied
+	return nil
+}
+
+func (m *memoryIdempotencyRepository) Release(ctx context.Context, userID UserID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, userID.String()+"/"+key)
+	return nil
+}
+
+func TestIdempotentHandler(t *testing.T) {
+	repo := &memoryIdempotencyRepository{records: map[string]*IdempotencyRecord{}}
+	h := &Handler{idempotencyRepo: repo, idempotencyTTL: time.Hour}
+
+	calls, status := 0, http.StatusCreated
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", "/api/tasks/1")
+		h.respondWithJSON(w, status, map[string]int{"call": calls})
+	})
+	serve := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "user_id", ownerUserID))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	first := serve("k1", `{"title": "Buy milk"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	retry := serve("k1", `{"title": "Buy milk"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "/api/tasks/1", retry.Header().Get("Location"))
+	assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, 1, calls)
+
+	w := serve("k1", `{"title": "Buy bread"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, calls)
+
+	// Without a key every request runs
+	serve("", `{"title": "Buy milk"}`)
+	serve("", `{"title": "Buy milk"}`)
+	assert.Equal(t, 3, calls)
+
+	// Server errors free the key for the retry
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, serve("k2", `{}`).Code)
+	status = http.StatusCreated
+	assert.Equal(t, http.StatusCreated, serve("k2", `{}`).Code)
+	assert.Equal(t, 5, calls)
+
+	assert.Equal(t, http.StatusBadRequest, serve(strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`).Code)
+}
+
+func TestIdempotentHandlerInProgress(t *testing.T) {
+	repo := &memoryIdempotencyRepository{records: map[string]*IdempotencyRecord{}}
+	repo.records[ownerUserID+"/k1"] = &IdempotencyRecord{
+		UserID:      ownerUserID,
+		Key:         "k1",
+		RequestHash: idempotencyRequestHash(httptest.NewRequest(http.MethodPost, "/api/tasks", nil), []byte(`{}`)),
+	}
+	h := &Handler{idempotencyRepo: repo, idempotencyTTL: time.Hour}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{}`))
+	req.Header.Set(idempotencyKeyHeader, "k1")
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", ownerUserID))
+	w := httptest.NewRecorder()
+	h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not run while the key is in progress")
+	})(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, idempotencyInProgressWait, w.Header().Get("Retry-After"))
+}
+
+func TestIdempotentTaskCreation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "idempotency@example.com")
+	create := func(key, body string) *httptest.ResponseRecorder {
+		req := taskRequest(http.MethodPost, "/api/tasks", user.Token, body, nil)
+		req.Header.Set(idempotencyKeyHeader, key)
+		return env.serveWithAuth(env.handler.idempotent(env.handler.CreateTask), req)
+	}
+
+	first := create("retry-1", `{"title": "Pay rent"}`)
+	require.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+	retry := create("retry-1", `{"title": "Pay rent"}`)
+	require.Equal(t, http.StatusCreated, retry.Code)
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, create("retry-1", `{"title": "Pay bills"}`).Code)
+	require.Equal(t, http.StatusCreated, create("retry-2", `{"title": "Pay rent"}`).Code)
+
+	req := taskRequest(http.MethodGet, "/api/tasks", user.Token, "", nil)
+	w := env.serveWithAuth(env.handler.GetTasks, req)
+	var list TaskListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.EqualValues(t, 2, list.TotalCount)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// ID is a UUID tagged with the entity it identifies. Passing a UserID where
+// a TaskID is expected is a compile error, which a plain string can't catch.
+// Untyped constants still convert implicitly, so tests can write
+// Task{ID: "..."}.
+type ID[E entity] string
+
+type (
+	UserID     = ID[userEntity]
+	TaskID     = ID[taskEntity]
+	CategoryID = ID[categoryEntity]
+)
+
+type entity interface {
+	entityName() string
+}
+
+type (
+	userEntity     struct{}
+	taskEntity     struct{}
+	categoryEntity struct{}
+)
+
+func (userEntity) entityName() string     { return "user" }
+func (taskEntity) entityName() string     { return "task" }
+func (categoryEntity) entityName() string { return "category" }
+
+// NewID returns a random ID for a new entity.
+func NewID[E entity]() ID[E] {
+	return ID[E](uuid.New().String())
+}
+
+// ParseID checks that s is a UUID, e.g. a path parameter.
+func ParseID[E entity](s string) (ID[E], error) {
+	if _, err := uuid.Parse(s); err != nil {
+		var e E
+		return "", fmt.Errorf("invalid %s ID %q", e.entityName(), s)
+	}
+	return ID[E](s), nil
+}
+
+// clientIDVersions are the UUID versions clients may choose IDs in: random
+// (4) and time-ordered random (7). The others are derived from a clock and
+// MAC address or a name, and collide or leak more than they should.
+var clientIDVersions = []uuid.Version{4, 7}
+
+// ParseClientID checks an ID a client chose for a new entity and returns it
+// in canonical form, lower case as the database returns it.
+func ParseClientID[E entity](s string) (ID[E], error) {
+	var e E
+	parsed, err := uuid.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s ID %q", e.entityName(), s)
+	}
+	if parsed.Variant() != uuid.RFC4122 || !slices.Contains(clientIDVersions, parsed.Version()) {
+		return "", fmt.Errorf("%s ID %q must be a version 4 or 7 UUID", e.entityName(), s)
+	}
+	return ID[E](parsed.String()), nil
+}
+
+func (id ID[E]) String() string { return string(id) }
+
+func (id ID[E]) MarshalText() ([]byte, error) {
+	return []byte(id), nil
+}
+
+// UnmarshalText rejects anything but a UUID or an empty string, so a
+// malformed ID in a request body fails decoding instead of reaching the
+// database.
+func (id *ID[E]) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = ""
+		return nil
+	}
+	parsed, err := ParseID[E](string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+func (id ID[E]) Value() (driver.Value, error) {
+	return string(id), nil
+}
+
+func (id *ID[E]) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*id = ID[E](v)
+	case []byte:
+		*id = ID[E](v)
+	case nil:
+		*id = ""
+	default:
+		var e E
+		return fmt.Errorf("cannot scan %T into a %s ID", src, e.entityName())
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseID(t *testing.T) {
+	id := NewID[taskEntity]()
+	parsed, err := ParseID[taskEntity](id.String())
+	require.NoError(t, err)
+	assert.Equal(t, id, parsed)
+
+	_, err = ParseID[userEntity]("not-a-uuid")
+	assert.EqualError(t, err, `invalid user ID "not-a-uuid"`)
+}
+
+func TestParseClientID(t *testing.T) {
+	id, err := ParseClientID[taskEntity]("6F1C2B1E-3D4A-4C5B-9E8F-0A1B2C3D4E5F")
+	require.NoError(t, err)
+	assert.Equal(t, TaskID("6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f"), id)
+	_, err = ParseClientID[categoryEntity]("018f3c2a-7b1e-7c5d-8e9f-0a1b2c3d4e5f")
+	assert.NoError(t, err)
+
+	_, err = ParseClientID[taskEntity]("42")
+	assert.EqualError(t, err, `invalid task ID "42"`)
+	for _, s := range []string{
+		"00000000-0000-0000-0000-000000000000", // nil
+		"6f1c2b1e-3d4a-1c5b-9e8f-0a1b2c3d4e5f", // version 1
+		"6f1c2b1e-3d4a-4c5b-ce8f-0a1b2c3d4e5f", // Microsoft variant
+	} {
+		_, err := ParseClientID[taskEntity](s)
+		assert.ErrorContains(t, err, "must be a version 4 or 7 UUID", s)
+	}
+}
+
+func TestIDJSON(t *testing.T) {
+	var collaborator TaskCollaborator
+	require.NoError(t, json.Unmarshal([]byte(`{"taskId": "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f", "userId": ""}`), &collaborator))
+	assert.Equal(t, TaskID("6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f"), collaborator.TaskID)
+	assert.Empty(t, collaborator.UserID)
+
+	err := json.Unmarshal([]byte(`{"taskId": "42"}`), &collaborator)
+	assert.ErrorContains(t, err, `invalid task ID "42"`)
+}
+
+func TestIDScan(t *testing.T) {
+	var id CategoryID
+	require.NoError(t, id.Scan([]byte("6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f")))
+	assert.Equal(t, CategoryID("6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f"), id)
+
+	require.NoError(t, id.Scan(nil))
+	assert.Empty(t, id)
+
+	assert.EqualError(t, id.Scan(42), "cannot scan int into a category ID")
+}
@@ -8,9 +8,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -23,9 +25,15 @@ var testConfig = Config{
 	Environment: "test",
 }
 
-// Global test database and handler
-var testDB *Database
-var testHandler *Handler
+// testBaseDB is connected to the public schema, which only holds the tables
+// every test copies
+var testBaseDB *Database
+
+// testSchemaPrefix names the per-test schemas; leftovers of interrupted runs
+// are dropped when the tests start
+const testSchemaPrefix = "test_"
+
+var testSchemaSeq atomic.Int64
 
 func TestMain(m *testing.M) {
 	// Setup test database
@@ -35,44 +43,75 @@ func TestMain(m *testing.M) {
 		fmt.Println("Make sure PostgreSQL is running with test database 'taskapi_test'")
 		os.Exit(1)
 	}
-	testDB = db
-	defer testDB.Close()
-
-	// Initialize handler
-	jwtService := NewJWTService(testConfig.JWTSecret)
-	testHandler = NewHandler(testDB, jwtService)
+	testBaseDB = db
+	dropTestSchemas()
 
 	// Run tests
 	code := m.Run()
 
-	// Cleanup
-	cleanupTestData()
+	testBaseDB.Close()
 	os.Exit(code)
 }
 
-func cleanupTestData() {
+func dropTestSchemas() {
+	rows, err := testBaseDB.Query(`SELECT nspname FROM pg_namespace WHERE starts_with(nspname, $1)`, testSchemaPrefix)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema string
+		if rows.Scan(&schema) == nil {
+			testBaseDB.Exec("DROP SCHEMA " + pq.QuoteIdentifier(schema) + " CASCADE")
+		}
+	}
+}
+
+// testEnv is one test's own copy of the schema with a handler on top, so
+// tests that run in parallel never see each other's users and tasks. The
+// schema is dropped when the test ends.
+type testEnv struct {
+	schema  string
+	db      *Database
+	handler *Handler
+}
+
+func newTestEnv(tb testing.TB) *testEnv {
+	tb.Helper()
 	ctx := context.Background()
-	testDB.ExecContext(ctx, "DELETE FROM task_categories")
-	testDB.ExecContext(ctx, "DELETE FROM tasks")
-	testDB.ExecContext(ctx, "DELETE FROM categories")
-	testDB.ExecContext(ctx, "DELETE FROM users")
+	schema := fmt.Sprintf("%s%d_%d", testSchemaPrefix, os.Getpid(), testSchemaSeq.Add(1))
+	require.NoError(tb, copyPublicSchema(ctx, testBaseDB.DB, schema))
+	tb.Cleanup(func() {
+		testBaseDB.ExecContext(ctx, "DROP SCHEMA "+pq.QuoteIdentifier(schema)+" CASCADE")
+	})
+
+	databaseURL, err := schemaDatabaseURL(testConfig.DatabaseURL, schema)
+	require.NoError(tb, err)
+	db, err := NewDatabase(databaseURL)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { db.Close() })
+
+	return &testEnv{schema: schema, db: db, handler: NewHandler(db, NewJWTService(testConfig.JWTSecret))}
 }
 
 func TestDatabaseConnection(t *testing.T) {
-	err := testDB.HealthCheck()
+	t.Parallel()
+	env := newTestEnv(t)
+	err := env.db.HealthCheck()
 	assert.NoError(t, err, "Database should be accessible")
 
-	stats := testDB.Stats()
+	stats := env.db.Stats()
 	assert.Greater(t, stats.MaxOpenConnections, 0, "Connection pool should be configured")
 }
 
 func TestUserRegistrationFlow(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
 	// Test user registration
 	regReq := RegisterRequest{
 		Email:     "test@example.com",
-		Password:  "password123",
+		Password:  "Tasks-Pass-2024",
 		FirstName: "Test",
 		LastName:  "User",
 	}
@@ -82,7 +121,7 @@ func TestUserRegistrationFlow(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	testHandler.Register(w, req)
+	env.handler.Register(w, req)
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 
@@ -99,15 +138,16 @@ func TestUserRegistrationFlow(t *testing.T) {
 	req2.Header.Set("Content-Type", "application/json")
 	w2 := httptest.NewRecorder()
 
-	testHandler.Register(w2, req2)
+	env.handler.Register(w2, req2)
 	assert.Equal(t, http.StatusConflict, w2.Code)
 }
 
 func TestLoginFlow(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
 	// Create test user first
-	userRepo := NewUserRepository(testDB.DB)
+	userRepo := NewUserRepository(env.db.DB)
 	user := &User{
 		ID:           "test-user-id",
 		Email:        "login@example.com",
@@ -131,7 +171,7 @@ func TestLoginFlow(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	testHandler.Login(w, req)
+	env.handler.Login(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	// Test invalid credentials
@@ -141,15 +181,16 @@ func TestLoginFlow(t *testing.T) {
 	req2.Header.Set("Content-Type", "application/json")
 	w2 := httptest.NewRecorder()
 
-	testHandler.Login(w2, req2)
+	env.handler.Login(w2, req2)
 	assert.Equal(t, http.StatusUnauthorized, w2.Code)
 }
 
 func TestTaskCRUDOperations(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
 	// Create test user and get token
-	token := createTestUserAndGetToken(t, "crud@example.com")
+	token := env.createTestUserAndGetToken(t, "crud@example.com")
 
 	// Test create task
 	createReq := CreateTaskRequest{
@@ -165,21 +206,21 @@ func TestTaskCRUDOperations(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	testHandler.CreateTask(w, req)
+	env.handler.CreateTask(w, req)
 	assert.Equal(t, http.StatusCreated, w.Code)
 
-	var createdTask Task
+	var createdTask TaskResponse
 	err := json.Unmarshal(w.Body.Bytes(), &createdTask)
 	require.NoError(t, err)
 	assert.Equal(t, createReq.Title, createdTask.Title)
 	taskID := createdTask.ID
 
 	// Test get task
-	req2 := httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID, nil)
+	req2 := httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID.String(), nil)
 	req2.Header.Set("Authorization", "Bearer "+token)
 	w2 := httptest.NewRecorder()
 
-	testHandler.GetTask(w2, req2)
+	env.handler.GetTask(w2, req2)
 	assert.Equal(t, http.StatusOK, w2.Code)
 
 	// Test update task
@@ -189,41 +230,42 @@ func TestTaskCRUDOperations(t *testing.T) {
 	}
 
 	body, _ = json.Marshal(updateReq)
-	req3 := httptest.NewRequest(http.MethodPut, "/api/tasks/"+taskID, bytes.NewReader(body))
+	req3 := httptest.NewRequest(http.MethodPut, "/api/tasks/"+taskID.String(), bytes.NewReader(body))
 	req3.Header.Set("Content-Type", "application/json")
 	req3.Header.Set("Authorization", "Bearer "+token)
 	w3 := httptest.NewRecorder()
 
-	testHandler.UpdateTask(w3, req3)
+	env.handler.UpdateTask(w3, req3)
 	assert.Equal(t, http.StatusOK, w3.Code)
 
-	var updatedTask Task
+	var updatedTask TaskResponse
 	err = json.Unmarshal(w3.Body.Bytes(), &updatedTask)
 	require.NoError(t, err)
 	assert.Equal(t, "Updated Task Title", updatedTask.Title)
 	assert.True(t, updatedTask.Completed)
 
 	// Test delete task
-	req4 := httptest.NewRequest(http.MethodDelete, "/api/tasks/"+taskID, nil)
+	req4 := httptest.NewRequest(http.MethodDelete, "/api/tasks/"+taskID.String(), nil)
 	req4.Header.Set("Authorization", "Bearer "+token)
 	w4 := httptest.NewRecorder()
 
-	testHandler.DeleteTask(w4, req4)
+	env.handler.DeleteTask(w4, req4)
 	assert.Equal(t, http.StatusNoContent, w4.Code)
 
 	// Verify task is deleted
-	req5 := httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID, nil)
+	req5 := httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID.String(), nil)
 	req5.Header.Set("Authorization", "Bearer "+token)
 	w5 := httptest.NewRecorder()
 
-	testHandler.GetTask(w5, req5)
+	env.handler.GetTask(w5, req5)
 	assert.Equal(t, http.StatusNotFound, w5.Code)
 }
 
 func TestTaskFiltering(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
-	token := createTestUserAndGetToken(t, "filter@example.com")
+	token := env.createTestUserAndGetToken(t, "filter@example.com")
 
 	// Create multiple tasks
 	tasks := []CreateTaskRequest{
@@ -240,12 +282,12 @@ func TestTaskFiltering(t *testing.T) {
 		req.Header.Set("Authorization", "Bearer "+token)
 		w := httptest.NewRecorder()
 
-		testHandler.CreateTask(w, req)
+		env.handler.CreateTask(w, req)
 		assert.Equal(t, http.StatusCreated, w.Code)
 
-		var createdTask Task
+		var createdTask TaskResponse
 		json.Unmarshal(w.Body.Bytes(), &createdTask)
-		taskIDs = append(taskIDs, createdTask.ID)
+		taskIDs = append(taskIDs, createdTask.ID.String())
 	}
 
 	// Mark third task as completed
@@ -255,14 +297,14 @@ func TestTaskFiltering(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
-	testHandler.UpdateTask(w, req)
+	env.handler.UpdateTask(w, req)
 
 	// Test filter by priority
 	req2 := httptest.NewRequest(http.MethodGet, "/api/tasks?priority=high", nil)
 	req2.Header.Set("Authorization", "Bearer "+token)
 	w2 := httptest.NewRecorder()
 
-	testHandler.GetTasks(w2, req2)
+	env.handler.GetTasks(w2, req2)
 	assert.Equal(t, http.StatusOK, w2.Code)
 
 	var response TaskListResponse
@@ -275,7 +317,7 @@ func TestTaskFiltering(t *testing.T) {
 	req3.Header.Set("Authorization", "Bearer "+token)
 	w3 := httptest.NewRecorder()
 
-	testHandler.GetTasks(w3, req3)
+	env.handler.GetTasks(w3, req3)
 	assert.Equal(t, http.StatusOK, w3.Code)
 
 	json.Unmarshal(w3.Body.Bytes(), &response)
@@ -287,7 +329,7 @@ func TestTaskFiltering(t *testing.T) {
 	req4.Header.Set("Authorization", "Bearer "+token)
 	w4 := httptest.NewRecorder()
 
-	testHandler.GetTasks(w4, req4)
+	env.handler.GetTasks(w4, req4)
 	assert.Equal(t, http.StatusOK, w4.Code)
 
 	json.Unmarshal(w4.Body.Bytes(), &response)
@@ -295,9 +337,10 @@ func TestTaskFiltering(t *testing.T) {
 }
 
 func TestTransactionIntegrity(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
-	token := createTestUserAndGetToken(t, "transaction@example.com")
+	token := env.createTestUserAndGetToken(t, "transaction@example.com")
 
 	// Create task with categories (tests transaction)
 	createReq := CreateTaskRequest{
@@ -313,10 +356,10 @@ func TestTransactionIntegrity(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	testHandler.CreateTask(w, req)
+	env.handler.CreateTask(w, req)
 	assert.Equal(t, http.StatusCreated, w.Code)
 
-	var createdTask Task
+	var createdTask TaskResponse
 	json.Unmarshal(w.Body.Bytes(), &createdTask)
 
 	// Verify categories were created
@@ -327,7 +370,7 @@ func TestTransactionIntegrity(t *testing.T) {
 	req2.Header.Set("Authorization", "Bearer "+token)
 	w2 := httptest.NewRecorder()
 
-	testHandler.GetCategories(w2, req2)
+	env.handler.GetCategories(w2, req2)
 	assert.Equal(t, http.StatusOK, w2.Code)
 
 	var categoryResponse map[string]interface{}
@@ -337,10 +380,11 @@ func TestTransactionIntegrity(t *testing.T) {
 }
 
 func TestDatabaseConstraints(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
 	// Test foreign key constraint
-	taskRepo := NewTaskRepository(testDB.DB)
+	taskRepo := NewTaskRepository(env.db.DB)
 	task := &Task{
 		ID:          "test-task",
 		Title:       "Invalid User Task",
@@ -354,9 +398,10 @@ func TestDatabaseConstraints(t *testing.T) {
 }
 
 func TestConcurrentAccess(t *testing.T) {
-	cleanupTestData()
+	t.Parallel()
+	env := newTestEnv(t)
 
-	token := createTestUserAndGetToken(t, "concurrent@example.com")
+	token := env.createTestUserAndGetToken(t, "concurrent@example.com")
 
 	// Test concurrent task creation
 	const numGoroutines = 10
@@ -376,7 +421,7 @@ func TestConcurrentAccess(t *testing.T) {
 			req.Header.Set("Authorization", "Bearer "+token)
 			w := httptest.NewRecorder()
 
-			testHandler.CreateTask(w, req)
+			env.handler.CreateTask(w, req)
 			if w.Code != http.StatusCreated {
 				results <- fmt.Errorf("failed to create task %d: status %d", index, w.Code)
 				return
@@ -400,7 +445,7 @@ func TestConcurrentAccess(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	testHandler.GetTasks(w, req)
+	env.handler.GetTasks(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	var response TaskListResponse
@@ -409,10 +454,12 @@ func TestConcurrentAccess(t *testing.T) {
 }
 
 func TestHealthCheck(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	w := httptest.NewRecorder()
 
-	testHandler.HealthCheck(w, req)
+	env.handler.HealthCheck(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	var health map[string]interface{}
@@ -422,12 +469,12 @@ func TestHealthCheck(t *testing.T) {
 }
 
 // Helper functions
-func createTestUserAndGetToken(t *testing.T, email string) string {
-	userRepo := NewUserRepository(testDB.DB)
+func (env *testEnv) createTestUserAndGetToken(t *testing.T, email string) string {
+	userRepo := NewUserRepository(env.db.DB)
 	jwtService := NewJWTService(testConfig.JWTSecret)
 
 	user := &User{
-		ID:           fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		ID:           NewID[userEntity](),
 		Email:        email,
 		PasswordHash: "$2a$10$N9qo8uLOickgx2ZMRZoMye", // bcrypt hash for "password123"
 		FirstName:    "Test",
@@ -445,6 +492,14 @@ func createTestUserAndGetToken(t *testing.T, email string) string {
 	return token
 }
 
+// serveWithAuth runs a handler behind authMiddleware so the user context
+// values are populated exactly as they are for routed requests.
+func (env *testEnv) serveWithAuth(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	authMiddleware(env.handler.jwtService, env.handler.apiKeyRepo)(handler).ServeHTTP(w, req)
+	return w
+}
+
 func timePtr(t time.Time) *time.Time {
 	return &t
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Integration kinds
+const (
+	IntegrationWebhook = "webhook"
+	IntegrationEmail   = "email"
+)
+
+// Integration statuses. An integration is failing when its latest attempt
+// failed, and idle until anything was sent through it.
+const (
+	IntegrationHealthy  = "healthy"
+	IntegrationFailing  = "failing"
+	IntegrationIdle     = "idle"
+	IntegrationDisabled = "disabled"
+)
+
+// Integration is something that sends the user's data elsewhere on their
+// behalf, such as a webhook or the emails the API sends them, with how its
+// latest attempts went. Links holds the actions that help a failing one
+// recover, such as "reconnect".
+type Integration struct {
+	Kind          string            `json:"kind"`
+	ID            string            `json:"id,omitempty"`
+	Target        string            `json:"target"`
+	Status        string            `json:"status"`
+	LastSuccessAt *time.Time        `json:"lastSuccessAt"`
+	LastFailureAt *time.Time        `json:"lastFailureAt"`
+	LastError     string            `json:"lastError,omitempty"`
+	Links         map[string]string `json:"links"`
+
+	active bool
+	// failedDelivery is the webhook's latest delivery that gave up
+	failedDelivery string
+}
+
+// status derives the integration's status from its latest attempts.
+func (i *Integration) status() string {
+	switch {
+	case !i.active:
+		return IntegrationDisabled
+	case i.LastFailureAt != nil && (i.LastSuccessAt == nil || i.LastFailureAt.After(*i.LastSuccessAt)):
+		return IntegrationFailing
+	case i.LastSuccessAt == nil:
+		return IntegrationIdle
+	}
+	return IntegrationHealthy
+}
+
+type IntegrationRepository interface {
+	// ListByUserID returns the user's webhooks, in creation order, and their
+	// email
+	ListByUserID(ctx context.Context, userID UserID) ([]*Integration, error)
+	// RecordResult stores the outcome of an attempt at an integration that
+	// has no delivery log of its own, such as email. A nil err is a success
+	RecordResult(ctx context.Context, userID UserID, kind string, err error) error
+}
+
+type integrationRepository struct {
+	db *sql.DB
+}
+
+func NewIntegrationRepository(db *sql.DB) IntegrationRepository {
+	return &integrationRepository{db: db}
+}
+
+// ListByUserID reads the webhooks' outcomes from their delivery attempts, so
+// it covers every attempt the job queue made, retries included.
+func (r *integrationRepository) ListByUserID(ctx context.Context, userID UserID) ([]*Integration, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT w.id, w.url, w.is_active, succeeded.attempted_at, failed.attempted_at,
+		       COALESCE(failed.error, ''), COALESCE(gave_up.id::text, '')
+		FROM webhooks w
+		LEFT JOIN LATERAL (
+			SELECT MAX(a.attempted_at) AS attempted_at
+			FROM webhook_delivery_attempts a JOIN webhook_deliveries d ON d.id = a.delivery_id
+			WHERE d.webhook_id = w.id AND a.status_code BETWEEN 200 AND 299
+		) succeeded ON true
+		LEFT JOIN LATERAL (
+			SELECT a.attempted_at, a.error
+			FROM webhook_delivery_attempts a JOIN webhook_deliveries d ON d.id = a.delivery_id
+			WHERE d.webhook_id = w.id AND (a.status_code IS NULL OR a.status_code NOT BETWEEN 200 AND 299)
+			ORDER BY a.attempted_at DESC LIMIT 1
+		) failed ON true
+		LEFT JOIN LATERAL (
+			SELECT d.id FROM webhook_deliveries d
+			WHERE d.webhook_id = w.id AND d.status = 'failed'
+			ORDER BY d.created_at DESC LIMIT 1
+		) gave_up ON true
+		WHERE w.user_id = $1
+		ORDER BY w.created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook integrations: %w", err)
+	}
+	defer rows.Close()
+
+	var integrations []*Integration
+	for rows.Next() {
+		integration := &Integration{Kind: IntegrationWebhook}
+		err := rows.Scan(&integration.ID, &integration.Target, &integration.active,
+			&integration.LastSuccessAt, &integration.LastFailureAt, &integration.LastError,
+			&integration.failedDelivery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook integration: %w", err)
+		}
+		integrations = append(integrations, integration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	email := &Integration{Kind: IntegrationEmail, active: true}
+	err = r.db.QueryRowContext(ctx, `
+		SELECT u.email, s.last_success_at, s.last_failure_at, COALESCE(s.last_error, '')
+		FROM users u
+		LEFT JOIN integration_results s ON s.user_id = u.id AND s.kind = 'email'
+		WHERE u.id = $1`, userID,
+	).Scan(&email.Target, &email.LastSuccessAt, &email.LastFailureAt, &email.LastError)
+	if err == sql.ErrNoRows {
+		return integrations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email integration: %w", err)
+	}
+	return append(integrations, email), nil
+}
+
+func (r *integrationRepository) RecordResult(ctx context.Context, userID UserID, kind string, err error) error {
+	query := `
+		INSERT INTO integration_results (user_id, kind, last_success_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, kind) DO UPDATE SET last_success_at = EXCLUDED.last_success_at`
+	args := []interface{}{userID, kind}
+	if err != nil {
+		query = `
+			INSERT INTO integration_results (user_id, kind, last_failure_at, last_error)
+			VALUES ($1, $2, CURRENT_TIMESTAMP, $3)
+			ON CONFLICT (user_id, kind) DO UPDATE
+			SET last_failure_at = EXCLUDED.last_failure_at, last_error = EXCLUDED.last_error`
+		args = append(args, err.Error())
+	}
+	if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to record integration result: %w", err)
+	}
+	return nil
+}
+
+// sendEmail sends an email to the user and records how it went, so a
+// mailbox that bounces shows up in the user's integrations.
+func (h *Handler) sendEmail(ctx context.Context, userID UserID, to, subject, body string) error {
+	sendErr := h.emails.Send(ctx, to, subject, body)
+	if err := h.integrationRepo.RecordResult(ctx, userID, IntegrationEmail, sendErr); err != nil {
+		slog.ErrorContext(ctx, "failed to record email result", "user_id", userID, "error", err)
+	}
+	return sendErr
+}
+
+// integrationLinks lists what the user can do about the integration. A
+// failing webhook is reconnected by redelivering the delivery that gave up,
+// once its endpoint is fixed; a failing email by changing the address.
+func integrationLinks(integration *Integration) map[string]string {
+	links := map[string]string{}
+	switch integration.Kind {
+	case IntegrationWebhook:
+		links["deliveries"] = "/api/webhooks/" + integration.ID + "/deliveries"
+		if integration.Status == IntegrationFailing && integration.failedDelivery != "" {
+			links["reconnect"] = "/api/webhooks/" + integration.ID + "/deliveries/" + integration.failedDelivery + "/redeliver"
+		}
+	case IntegrationEmail:
+		if integration.Status == IntegrationFailing {
+			links["reconnect"] = "/api/users/me"
+		}
+	}
+	return links
+}
+
+// GetIntegrations handles GET /api/me/integrations
+func (h *Handler) GetIntegrations(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	integrations, err := h.integrationRepo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get integrations")
+		return
+	}
+
+	list := make([]Integration, len(integrations))
+	for i, integration := range integrations {
+		integration.Status = integration.status()
+		integration.Links = integrationLinks(integration)
+		list[i] = *integration
+	}
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"integrations": list,
+		"count":        len(list),
+	})
+}
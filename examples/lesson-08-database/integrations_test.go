@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type integrationListResponse struct {
+	Integrations []Integration `json:"integrations"`
+	Count        int           `json:"count"`
+}
+
+func (env *testEnv) listTestIntegrations(t *testing.T, token string) integrationListResponse {
+	req := httptest.NewRequest(http.MethodGet, "/api/me/integrations", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := env.serveWithAuth(env.handler.GetIntegrations, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response integrationListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+type failingEmailSender struct{}
+
+func (failingEmailSender) Send(ctx context.Context, to, subject, body string) error {
+	return errors.New("550 mailbox unavailable")
+}
+
+func TestIntegrationStatus(t *testing.T) {
+	earlier := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+
+	for name, tc := range map[string]struct {
+		integration Integration
+		status      string
+	}{
+		"nothing sent":           {Integration{active: true}, IntegrationIdle},
+		"succeeded":              {Integration{active: true, LastSuccessAt: &earlier}, IntegrationHealthy},
+		"failed":                 {Integration{active: true, LastFailureAt: &earlier}, IntegrationFailing},
+		"recovered":              {Integration{active: true, LastSuccessAt: &later, LastFailureAt: &earlier}, IntegrationHealthy},
+		"failed since":           {Integration{active: true, LastSuccessAt: &earlier, LastFailureAt: &later}, IntegrationFailing},
+		"inactive after failing": {Integration{LastFailureAt: &later}, IntegrationDisabled},
+	} {
+		assert.Equal(t, tc.status, tc.integration.status(), name)
+	}
+}
+
+func TestIntegrationLinks(t *testing.T) {
+	webhook := &Integration{Kind: IntegrationWebhook, ID: "hook-1", Status: IntegrationFailing, failedDelivery: "delivery-1"}
+	assert.Equal(t, map[string]string{
+		"deliveries": "/api/webhooks/hook-1/deliveries",
+		"reconnect":  "/api/webhooks/hook-1/deliveries/delivery-1/redeliver",
+	}, integrationLinks(webhook))
+
+	// Still retrying: nothing has given up yet
+	webhook.failedDelivery = ""
+	assert.NotContains(t, integrationLinks(webhook), "reconnect")
+
+	email := &Integration{Kind: IntegrationEmail, Status: IntegrationHealthy}
+	assert.Empty(t, integrationLinks(email))
+	email.Status = IntegrationFailing
+	assert.Equal(t, "/api/users/me", integrationLinks(email)["reconnect"])
+}
+
+func TestGetIntegrations(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.startWebhooks(t)
+	user := env.registerTestUser(t, "integrations@example.com")
+
+	// A new user has their email, which nothing was sent to yet
+	response := env.listTestIntegrations(t, user.Token)
+	require.Equal(t, 1, response.Count)
+	email := response.Integrations[0]
+	assert.Equal(t, IntegrationEmail, email.Kind)
+	assert.Equal(t, "integrations@example.com", email.Target)
+	assert.Equal(t, IntegrationIdle, email.Status)
+
+	receiver, received := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	webhook := env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: receiver.URL, Events: []string{WebhookTaskCreated}}).Webhook
+	require.Equal(t, http.StatusCreated, env.createTaskAs(user.Token, "Unlucky").Code)
+	id := waitForWebhook(t, received).header.Get(WebhookIDHeader)
+	waitForWebhook(t, received)
+	require.Eventually(t, func() bool {
+		stored, err := env.handler.webhookRepo.GetDelivery(context.Background(), id)
+		return err == nil && stored.Status == DeliveryFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A failed email is recorded, and a delivered one later makes it healthy
+	env.handler.emails = failingEmailSender{}
+	w := env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"email": "bounces@example.com"})
+	require.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	response = env.listTestIntegrations(t, user.Token)
+	require.Equal(t, 2, response.Count)
+	hook, email := response.Integrations[0], response.Integrations[1]
+	assert.Equal(t, IntegrationWebhook, hook.Kind)
+	assert.Equal(t, webhook.ID, hook.ID)
+	assert.Equal(t, receiver.URL, hook.Target)
+	assert.Equal(t, IntegrationFailing, hook.Status)
+	assert.Nil(t, hook.LastSuccessAt)
+	assert.NotNil(t, hook.LastFailureAt)
+	assert.Equal(t, "webhook endpoint returned 503", hook.LastError)
+	assert.Equal(t, "/api/webhooks/"+webhook.ID+"/deliveries/"+id+"/redeliver", hook.Links["reconnect"])
+	assert.Equal(t, IntegrationFailing, email.Status)
+	assert.Equal(t, "550 mailbox unavailable", email.LastError)
+	assert.Equal(t, "/api/users/me", email.Links["reconnect"])
+
+	env.handler.emails = &recordingEmailSender{}
+	w = env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"email": "inbox@example.com"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	email = env.listTestIntegrations(t, user.Token).Integrations[1]
+	assert.Equal(t, IntegrationHealthy, email.Status)
+	assert.NotNil(t, email.LastFailureAt, "the failure is kept")
+	assert.NotContains(t, email.Links, "reconnect")
+
+	// Other users see only their own
+	other := env.registerTestUser(t, "other-integrations@example.com")
+	assert.Equal(t, 1, env.listTestIntegrations(t, other.Token).Count)
+}
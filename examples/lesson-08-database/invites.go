@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// Invite error codes
+const (
+	InviteRequired = "invite_required"
+	InviteInvalid  = "invite_invalid"
+)
+
+// InviteError is returned when registration needs an invite and none, or an
+// unusable one, was given.
+type InviteError struct {
+	Code string
+}
+
+func (e *InviteError) Error() string {
+	if e.Code == InviteRequired {
+		return "an invite is required to register"
+	}
+	return "invite is invalid, expired or already used"
+}
+
+// Invite is a single-use registration token created by an admin. Email, when
+// set, restricts the invite to that address; Role is assigned on signup.
+type Invite struct {
+	ID        string     `json:"id"`
+	TokenHash string     `json:"-"`
+	Email     string     `json:"email,omitempty"`
+	Role      string     `json:"role"`
+	CreatedBy UserID     `json:"createdBy"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	UsedBy    *UserID    `json:"usedBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type CreateInviteRequest struct {
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+	ExpiresInHours int    `json:"expiresInHours"`
+}
+
+type CreateInviteResponse struct {
+	Invite Invite `json:"invite"`
+	// Token is only returned once
+	Token string `json:"token"`
+}
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite *Invite) error
+	List(ctx context.Context) ([]*Invite, error)
+	Claim(ctx context.Context, tokenHash string) (*Invite, error)
+	Release(ctx context.Context, id string) error
+	Complete(ctx context.Context, id string, userID UserID) error
+}
+
+type inviteRepository struct {
+	db *sql.DB
+}
+
+func NewInviteRepository(db *sql.DB) InviteRepository {
+	return &inviteRepository{db: db}
+}
+
+func (r *inviteRepository) Create(ctx context.Context, invite *Invite) error {
+	query := `
+		INSERT INTO invites (id, token_hash, email, role, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	return r.db.QueryRowContext(ctx, query,
+		invite.ID, invite.TokenHash, invite.Email, invite.Role, invite.CreatedBy, invite.ExpiresAt,
+	).Scan(&invite.CreatedAt)
+}
+
+func (r *inviteRepository) List(ctx context.Context) ([]*Invite, error) {
+	query := `
+		SELECT id, email, role, created_by, expires_at, used_at, used_by, created_at
+		FROM invites ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invites: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []*Invite
+	for rows.Next() {
+		invite := &Invite{}
+		err := rows.Scan(
+			&invite.ID, &invite.Email, &invite.Role, &invite.CreatedBy,
+			&invite.ExpiresAt, &invite.UsedAt, &invite.UsedBy, &invite.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invite: %w", err)
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// Claim marks an unused, unexpired invite as used and returns it. Concurrent
+// claims of the same invite cannot both succeed.
+func (r *inviteRepository) Claim(ctx context.Context, tokenHash string) (*Invite, error) {
+	invite := &Invite{}
+	query := `
+		UPDATE invites SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, email, role, created_by, expires_at, used_at, created_at`
+
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&invite.ID, &invite.Email, &invite.Role, &invite.CreatedBy,
+		&invite.ExpiresAt, &invite.UsedAt, &invite.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invite not found")
+		}
+		return nil, fmt.Errorf("failed to claim invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+// Release makes a claimed invite usable again when registration fails.
+func (r *inviteRepository) Release(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE invites SET used_at = NULL WHERE id = $1 AND used_by IS NULL`, id)
+	return err
+}
+
+func (r *inviteRepository) Complete(ctx context.Context, id string, userID UserID) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE invites SET used_by = $2 WHERE id = $1`, id, userID)
+	return err
+}
+
+// Invite Handlers
+func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = roleEnum.Default
+	}
+	if !assignableRole(req.Role) {
+		h.respondWithError(w, http.StatusBadRequest, "Role must be user or admin")
+		return
+	}
+
+	ttl := defaultInviteTTL
+	if req.ExpiresInHours < 0 {
+		h.respondWithError(w, http.StatusBadRequest, "expiresInHours must be positive")
+		return
+	}
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	token, err := generateSecret(24)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate invite token")
+		return
+	}
+
+	invite := &Invite{
+		ID:        uuid.New().String(),
+		TokenHash: hashToken(token),
+		Email:     strings.ToLower(strings.TrimSpace(req.Email)),
+		Role:      req.Role,
+		CreatedBy: userID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	if err := h.inviteRepo.Create(r.Context(), invite); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, CreateInviteResponse{
+		Invite: *invite,
+		Token:  token,
+	})
+}
+
+func (h *Handler) GetInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.inviteRepo.List(r.Context())
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get invites")
+		return
+	}
+
+	inviteList := make([]Invite, len(invites))
+	for i, invite := range invites {
+		inviteList[i] = *invite
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"invites": inviteList,
+		"count":   len(inviteList),
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inviteOnlyHandler returns a copy of the test handler with open signup
+// disabled.
+func (env *testEnv) inviteOnlyHandler() *Handler {
+	h := *env.handler
+	h.registration = NewRegistrationService(h.userRepo, h.inviteRepo, h.passwords,
+		NewEmailDomainPolicy(DefaultEmailDomainPolicyConfig), false)
+	return &h
+}
+
+func (env *testEnv) createTestAdminToken(t *testing.T) string {
+	admin := &User{
+		ID:           NewID[userEntity](),
+		Email:        "admin-" + uuid.New().String()[:8] + "@example.com",
+		PasswordHash: "unused",
+		FirstName:    "Admin",
+		LastName:     "User",
+		Role:         "admin",
+		IsActive:     true,
+	}
+	require.NoError(t, env.handler.userRepo.Create(context.Background(), admin))
+
+	token, err := env.handler.jwtService.GenerateToken(admin)
+	require.NoError(t, err)
+	return token
+}
+
+func (env *testEnv) createTestInvite(t *testing.T, adminToken string, req CreateInviteRequest) CreateInviteResponse {
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/api/admin/invites", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+adminToken)
+
+	w := env.serveWithAuth(env.handler.CreateInvite, httpReq)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created CreateInviteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created
+}
+
+func registerWithInvite(h *Handler, email, inviteToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RegisterRequest{
+		Email:       email,
+		Password:    "Tasks-Pass-2024",
+		FirstName:   "Invited",
+		LastName:    "User",
+		InviteToken: inviteToken,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	h.Register(w, req)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Code
+}
+
+func TestInviteOnlyRegistration(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	h := env.inviteOnlyHandler()
+	adminToken := env.createTestAdminToken(t)
+
+	// Without an invite
+	w := registerWithInvite(h, "uninvited@example.com", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, InviteRequired, errorCode(t, w))
+
+	// With an invite carrying a role
+	invite := env.createTestInvite(t, adminToken, CreateInviteRequest{Role: "admin"})
+	require.NotEmpty(t, invite.Token)
+	assert.Equal(t, "admin", invite.Invite.Role)
+
+	w = registerWithInvite(h, "invited@example.com", invite.Token)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var login LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	assert.Equal(t, "admin", login.User.Role)
+
+	// Invites are single use
+	w = registerWithInvite(h, "second@example.com", invite.Token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, InviteInvalid, errorCode(t, w))
+
+	// Unknown tokens are rejected
+	w = registerWithInvite(h, "second@example.com", "not-a-token")
+	assert.Equal(t, InviteInvalid, errorCode(t, w))
+}
+
+func TestInvite_EmailRestricted(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	h := env.inviteOnlyHandler()
+	adminToken := env.createTestAdminToken(t)
+
+	invite := env.createTestInvite(t, adminToken, CreateInviteRequest{Email: "Alex@Example.com"})
+
+	w := registerWithInvite(h, "someone-else@example.com", invite.Token)
+	assert.Equal(t, InviteInvalid, errorCode(t, w))
+
+	// The failed attempt does not burn the invite
+	w = registerWithInvite(h, "alex@example.com", invite.Token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestInvite_ReleasedWhenRegistrationFails(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	h := env.inviteOnlyHandler()
+	adminToken := env.createTestAdminToken(t)
+	env.registerTestUser(t, "taken@example.com")
+
+	invite := env.createTestInvite(t, adminToken, CreateInviteRequest{})
+
+	w := registerWithInvite(h, "taken@example.com", invite.Token)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = registerWithInvite(h, "fresh@example.com", invite.Token)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestRegister_InviteRequired(t *testing.T) {
+	handler := &Handler{
+		passwordPolicy: NewPasswordPolicy(DefaultPasswordPolicyConfig, nil),
+		registration: NewRegistrationService(nil, nil, NewArgon2Hasher(testArgon2Params),
+			NewEmailDomainPolicy(DefaultEmailDomainPolicyConfig), false),
+	}
+
+	w := registerWithInvite(handler, "closed@example.com", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, InviteRequired, errorCode(t, w))
+}
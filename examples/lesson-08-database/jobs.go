@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"correlation"
+)
+
+// ErrQueueFull is returned by Enqueue when the queue can't take more jobs.
+var ErrQueueFull = fmt.Errorf("job queue is full")
+
+// Job is a unit of background work. Key identifies what to work on (e.g. a
+// task ID); the handler registered for Type loads the rest.
+type Job struct {
+	Type        string
+	Key         string
+	Attempt     int
+	MaxAttempts int
+	// Forwarded are the headers of the request that queued the job, for its
+	// outbound calls to forward (correlation.Forwarded)
+	Forwarded http.Header
+}
+
+// Final reports whether a failure of this attempt won't be retried.
+func (j Job) Final() bool { return j.Attempt >= j.MaxAttempts }
+
+type JobHandler func(ctx context.Context, job Job) error
+
+// JobQueue runs jobs on a pool of in-process workers. Failed jobs are retried
+// with exponential backoff up to maxAttempts. Jobs are not persisted: work
+// queued when the process stops is lost, so handlers must be safe to re-run
+// from whatever state the database is in.
+type JobQueue struct {
+	jobs        chan Job
+	maxAttempts int
+	backoff     time.Duration
+	jobTimeout  time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+func NewJobQueue(size, maxAttempts int, backoff time.Duration) *JobQueue {
+	return &JobQueue{
+		jobs:        make(chan Job, size),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		jobTimeout:  30 * time.Second,
+		handlers:    make(map[string]JobHandler),
+	}
+}
+
+func (q *JobQueue) Register(jobType string, handler JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = handler
+}
+
+// Enqueue adds a job without blocking; callers should treat ErrQueueFull as
+// "try again later" rather than failing their own request.
+func (q *JobQueue) Enqueue(job Job) error {
+	if job.Attempt == 0 {
+		job.Attempt = 1
+	}
+	job.MaxAttempts = q.maxAttempts
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.stopped {
+		return fmt.Errorf("job queue is stopped")
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Start launches the workers.
+// Pending returns the number of jobs waiting for a worker.
+func (q *JobQueue) Pending() int {
+	return len(q.jobs)
+}
+
+func (q *JobQueue) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop stops accepting jobs and waits for queued jobs to finish. Pending
+// retries are dropped.
+func (q *JobQueue) Stop() {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	q.stopped = true
+	close(q.jobs)
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+func (q *JobQueue) run(job Job) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		slog.Error("no handler for job type", "job_type", job.Type)
+		backgroundJobsTotal.WithLabelValues(job.Type, "unhandled").Inc()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(correlation.WithForwarded(context.Background(), job.Forwarded), q.jobTimeout)
+	err := handler(ctx, job)
+	cancel()
+
+	if err == nil {
+		backgroundJobsTotal.WithLabelValues(job.Type, "succeeded").Inc()
+		return
+	}
+
+	if job.Final() {
+		slog.Error("job failed", "job_type", job.Type, "job_key", job.Key, "attempts", job.Attempt, "error", err)
+		backgroundJobsTotal.WithLabelValues(job.Type, "failed").Inc()
+		return
+	}
+
+	backgroundJobsTotal.WithLabelValues(job.Type, "retried").Inc()
+	delay := q.backoff * time.Duration(1<<(job.Attempt-1))
+	job.Attempt++
+	time.AfterFunc(delay, func() {
+		if err := q.Enqueue(job); err != nil {
+			slog.Error("failed to retry job", "job_type", job.Type, "job_key", job.Key, "error", err)
+		}
+	})
+}
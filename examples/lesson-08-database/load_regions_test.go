@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The load tests call the handlers in-process, so every request is as fast
+// as a client next to the server. LOAD_REGIONS puts the virtual users in
+// regions behind a simulated network: each request waits a round trip of
+// the region's latency plus or minus its jitter, and a share of them is lost
+// before reaching the server. Users are assigned to the regions in turn and
+// the report breaks the percentiles down per region.
+//
+//	LOAD_REGIONS="local=0,eu-west=30ms/10ms,ap-south=180ms/40ms/2%"
+
+// LoadRegion is the network between one group of virtual users and the
+// server.
+type LoadRegion struct {
+	Name    string
+	Latency time.Duration // round trip
+	Jitter  time.Duration // added to or taken off the latency, uniformly
+	Loss    float64       // share of requests lost, from 0 to 1
+}
+
+// parseLoadRegions parses a comma-separated list of
+// name=latency[/jitter[/loss%]].
+func parseLoadRegions(s string) ([]LoadRegion, error) {
+	var regions []LoadRegion
+	seen := make(map[string]bool)
+	for _, spec := range splitList(s) {
+		name, shape, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid region %q: want name=latency[/jitter[/loss%%]]", spec)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate region %q", name)
+		}
+		seen[name] = true
+
+		region := LoadRegion{Name: name}
+		parts := strings.Split(shape, "/")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid region %q: want name=latency[/jitter[/loss%%]]", spec)
+		}
+		durations := []*time.Duration{&region.Latency, &region.Jitter}
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if i == 2 {
+				loss, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+				if err != nil || loss < 0 || loss > 100 {
+					return nil, fmt.Errorf("invalid loss %q for region %q: want a percentage from 0 to 100", part, name)
+				}
+				region.Loss = loss / 100
+				continue
+			}
+			if part == "0" {
+				continue
+			}
+			d, err := time.ParseDuration(part)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid duration %q for region %q", part, name)
+			}
+			*durations[i] = d
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// roundTrip draws the network's share of one request: the delay it adds and
+// whether the request is lost.
+func (r LoadRegion) roundTrip(rng *rand.Rand) (time.Duration, bool) {
+	if r.Loss > 0 && rng.Float64() < r.Loss {
+		return 0, true
+	}
+	delay := r.Latency
+	if r.Jitter > 0 {
+		delay += time.Duration(rng.Int63n(int64(2*r.Jitter)+1)) - r.Jitter
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, false
+}
+
+// requestRecorder is what the load test helpers record their requests in.
+type requestRecorder interface {
+	AddResponse(endpoint string, status int, duration time.Duration, success bool)
+}
+
+// RegionalMetrics keeps the metrics of every region next to the overall
+// ones.
+type RegionalMetrics struct {
+	Overall *LoadTestMetrics
+	Regions map[string]*LoadTestMetrics
+}
+
+func newRegionalMetrics(overall *LoadTestMetrics, regions []LoadRegion) *RegionalMetrics {
+	m := &RegionalMetrics{Overall: overall, Regions: make(map[string]*LoadTestMetrics)}
+	for _, region := range regions {
+		m.Regions[region.Name] = &LoadTestMetrics{}
+	}
+	return m
+}
+
+// Finalize finalizes every region with the overall duration.
+func (m *RegionalMetrics) Finalize() {
+	m.Overall.Finalize()
+	for _, metrics := range m.Regions {
+		metrics.TotalDuration = m.Overall.TotalDuration
+		metrics.Finalize()
+	}
+}
+
+func (m *RegionalMetrics) Report() {
+	m.Overall.Report()
+	if len(m.Regions) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(m.Regions))
+	for name := range m.Regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("\n=== Results per Region ===\n")
+	fmt.Printf("%-12s %9s %8s %9s %10s %10s %10s\n", "Region", "Requests", "Failed", "Lost", "p50 <=", "p95 <=", "p99 <=")
+	for _, name := range names {
+		metrics := m.Regions[name]
+		fmt.Printf("%-12s %9d %8d %9d %10v %10v %10v\n", name, metrics.TotalRequests, metrics.FailedRequests,
+			metrics.ConnectionErrors, metrics.Percentile(50), metrics.Percentile(95), metrics.Percentile(99))
+	}
+}
+
+// virtualUser is one load test client and the network it sits behind. With
+// no regions configured it records straight into the overall metrics.
+type virtualUser struct {
+	region  *LoadRegion
+	rng     *rand.Rand
+	overall *LoadTestMetrics
+	metrics *LoadTestMetrics
+	delay   time.Duration
+}
+
+// virtualUser returns the index-th user, placed in the regions in turn.
+func (m *RegionalMetrics) virtualUser(regions []LoadRegion, index int) *virtualUser {
+	user := &virtualUser{overall: m.Overall}
+	if len(regions) > 0 {
+		user.region = &regions[index%len(regions)]
+		user.rng = rand.New(rand.NewSource(time.Now().UnixNano() + int64(index)))
+		user.metrics = m.Regions[user.region.Name]
+	}
+	return user
+}
+
+// Do sends one request through the user's network: a lost request never
+// reaches the server and counts as a connection error, the others are
+// slowed down by the round trip.
+func (u *virtualUser) Do(request func(metrics requestRecorder)) {
+	if u.region == nil {
+		request(u.overall)
+		return
+	}
+
+	delay, lost := u.region.roundTrip(u.rng)
+	if lost {
+		u.overall.AddConnectionError()
+		u.metrics.AddConnectionError()
+		return
+	}
+	u.delay = delay
+	request(u)
+}
+
+// AddResponse records a request with the round trip of the user's network
+// added, after waiting for it so the user's pace slows down as well.
+func (u *virtualUser) AddResponse(endpoint string, status int, duration time.Duration, success bool) {
+	time.Sleep(u.delay)
+	duration += u.delay
+	u.overall.AddResponse(endpoint, status, duration, success)
+	u.metrics.AddResponse(endpoint, status, duration, success)
+}
+
+func TestParseLoadRegions(t *testing.T) {
+	regions, err := parseLoadRegions("local=0, eu-west=30ms/10ms, ap-south=180ms/40ms/2.5%")
+	require.NoError(t, err)
+	assert.Equal(t, []LoadRegion{
+		{Name: "local"},
+		{Name: "eu-west", Latency: 30 * time.Millisecond, Jitter: 10 * time.Millisecond},
+		{Name: "ap-south", Latency: 180 * time.Millisecond, Jitter: 40 * time.Millisecond, Loss: 0.025},
+	}, regions)
+
+	regions, err = parseLoadRegions("")
+	require.NoError(t, err)
+	assert.Empty(t, regions)
+
+	for _, invalid := range []string{
+		"eu-west", "=30ms", "eu-west=fast", "eu-west=-5ms", "eu-west=30ms/1ms/2%/1",
+		"eu-west=30ms/1ms/120%", "eu-west=30ms/1ms/lots", "eu=30ms,eu=40ms",
+	} {
+		_, err := parseLoadRegions(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoadRegionRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	region := LoadRegion{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}
+	for i := 0; i < 1000; i++ {
+		delay, lost := region.roundTrip(rng)
+		assert.False(t, lost)
+		assert.GreaterOrEqual(t, delay, 40*time.Millisecond)
+		assert.LessOrEqual(t, delay, 60*time.Millisecond)
+	}
+
+	// Jitter never makes the delay negative
+	region = LoadRegion{Latency: time.Millisecond, Jitter: 10 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		delay, _ := region.roundTrip(rng)
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+	}
+
+	region = LoadRegion{Loss: 0.2}
+	lost := 0
+	for i := 0; i < 10000; i++ {
+		if _, l := region.roundTrip(rng); l {
+			lost++
+		}
+	}
+	assert.InDelta(t, 2000, lost, 200)
+}
+
+func TestRegionalMetrics(t *testing.T) {
+	regions := []LoadRegion{
+		{Name: "near"},
+		{Name: "far", Latency: 20 * time.Millisecond},
+		{Name: "lossy", Loss: 1},
+	}
+	metrics := newRegionalMetrics(&LoadTestMetrics{}, regions)
+
+	for i := 0; i < 6; i++ {
+		metrics.virtualUser(regions, i).Do(func(recorder requestRecorder) {
+			recorder.AddResponse("GET /api/tasks", http.StatusOK, time.Millisecond, true)
+		})
+	}
+	metrics.Overall.TotalDuration = time.Second
+	metrics.Finalize()
+
+	assert.Equal(t, int64(4), metrics.Overall.TotalRequests)
+	assert.Equal(t, int64(2), metrics.Overall.ConnectionErrors)
+	assert.Equal(t, time.Millisecond, metrics.Regions["near"].MaxResponseTime)
+	assert.Equal(t, 21*time.Millisecond, metrics.Regions["far"].MinResponseTime)
+	assert.Equal(t, 21*time.Millisecond, metrics.Regions["far"].Percentile(99))
+	assert.Zero(t, metrics.Regions["lossy"].TotalRequests)
+	assert.Equal(t, int64(2), metrics.Regions["lossy"].ConnectionErrors)
+
+	// Without regions requests go straight to the overall metrics
+	overall := &LoadTestMetrics{}
+	newRegionalMetrics(overall, nil).virtualUser(nil, 3).Do(func(recorder requestRecorder) {
+		assert.Same(t, overall, recorder)
+	})
+}
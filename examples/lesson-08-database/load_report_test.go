@@ -0,0 +1,680 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// With LOAD_REPORT_DIR set, the load tests write a report of each run there
+// to attach to a pull request: <test>.md and <test>.html with a latency
+// histogram (<test>-latency.svg, inlined in the HTML), per-endpoint and
+// per-region tables and the failed responses by endpoint and status code,
+// and <test>.json with the numbers. With LOAD_BASELINE_DIR pointing at the
+// reports of an earlier run, such as one on the main branch, each report
+// compares the run with that baseline.
+//
+//	LOAD_TEST=true LOAD_REPORT_DIR=reports/main go test -run TestLoad -v .
+//	git checkout my-branch
+//	LOAD_TEST=true LOAD_REPORT_DIR=reports/pr LOAD_BASELINE_DIR=reports/main go test -run TestLoad -v .
+
+// EndpointMetrics are the requests to one endpoint, also counted by status
+// code.
+type EndpointMetrics struct {
+	LoadTestMetrics
+	statuses sync.Map // status code -> *atomic.Int64
+}
+
+// AddResponse records a request to endpoint that got status, 0 if it was
+// never sent.
+func (m *LoadTestMetrics) AddResponse(endpoint string, status int, duration time.Duration, success bool) {
+	m.AddRequest(duration, success)
+
+	value, ok := m.endpoints.Load(endpoint)
+	if !ok {
+		value, _ = m.endpoints.LoadOrStore(endpoint, &EndpointMetrics{})
+	}
+	endpointMetrics := value.(*EndpointMetrics)
+	endpointMetrics.AddRequest(duration, success)
+
+	count, ok := endpointMetrics.statuses.Load(status)
+	if !ok {
+		count, _ = endpointMetrics.statuses.LoadOrStore(status, new(atomic.Int64))
+	}
+	count.(*atomic.Int64).Add(1)
+}
+
+// ReportStats summarizes one set of requests. Percentiles are histogram
+// bucket bounds, like LoadTestMetrics.Percentile.
+type ReportStats struct {
+	Requests         int64         `json:"requests"`
+	Failed           int64         `json:"failed"`
+	ConnectionErrors int64         `json:"connectionErrors"`
+	RequestsPerSec   float64       `json:"requestsPerSec"`
+	Avg              time.Duration `json:"avg"`
+	P50              time.Duration `json:"p50"`
+	P95              time.Duration `json:"p95"`
+	P99              time.Duration `json:"p99"`
+	Max              time.Duration `json:"max"`
+}
+
+// ErrorRate is the share of the requests that failed, from 0 to 1.
+func (s ReportStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Requests)
+}
+
+func newReportStats(m *LoadTestMetrics) ReportStats {
+	return ReportStats{
+		Requests:         m.TotalRequests,
+		Failed:           m.FailedRequests,
+		ConnectionErrors: m.ConnectionErrors,
+		RequestsPerSec:   m.RequestsPerSec,
+		Avg:              m.AvgResponseTime.Round(time.Microsecond),
+		P50:              m.Percentile(50),
+		P95:              m.Percentile(95),
+		P99:              m.Percentile(99),
+		Max:              m.MaxResponseTime,
+	}
+}
+
+// StatusCount is how many requests got one status code.
+type StatusCount struct {
+	Status int   `json:"status"`
+	Count  int64 `json:"count"`
+}
+
+// ReportRow is the stats of one endpoint or region.
+type ReportRow struct {
+	Name     string        `json:"name"`
+	Stats    ReportStats   `json:"stats"`
+	Statuses []StatusCount `json:"statuses,omitempty"`
+}
+
+// HistogramBucket counts the requests up to UpperBound, or slower than all
+// bounds when it is 0.
+type HistogramBucket struct {
+	UpperBound time.Duration `json:"upperBound"`
+	Count      int64         `json:"count"`
+}
+
+// LoadTestReport is the outcome of one load test run.
+type LoadTestReport struct {
+	Name        string            `json:"name"`
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Users       int               `json:"users"`
+	Requests    int               `json:"requestsPerUser"`
+	Duration    time.Duration     `json:"duration"`
+	Summary     ReportStats       `json:"summary"`
+	Histogram   []HistogramBucket `json:"histogram"`
+	Endpoints   []ReportRow       `json:"endpoints"`
+	Regions     []ReportRow       `json:"regions,omitempty"`
+}
+
+// newLoadTestReport reports on finalized metrics.
+func newLoadTestReport(name string, config LoadTestConfig, metrics *RegionalMetrics) *LoadTestReport {
+	overall := metrics.Overall
+	report := &LoadTestReport{
+		Name:        name,
+		GeneratedAt: time.Now().UTC(),
+		Users:       config.ConcurrentUsers,
+		Requests:    config.RequestsPerUser,
+		Duration:    overall.TotalDuration,
+		Summary:     newReportStats(overall),
+	}
+	for i := range overall.buckets {
+		bucket := HistogramBucket{Count: overall.buckets[i].Load()}
+		if i < len(latencyBuckets) {
+			bucket.UpperBound = latencyBuckets[i]
+		}
+		report.Histogram = append(report.Histogram, bucket)
+	}
+
+	overall.endpoints.Range(func(key, value interface{}) bool {
+		endpointMetrics := value.(*EndpointMetrics)
+		endpointMetrics.TotalDuration = overall.TotalDuration
+		endpointMetrics.Finalize()
+		row := ReportRow{Name: key.(string), Stats: newReportStats(&endpointMetrics.LoadTestMetrics)}
+		endpointMetrics.statuses.Range(func(status, count interface{}) bool {
+			row.Statuses = append(row.Statuses, StatusCount{Status: status.(int), Count: count.(*atomic.Int64).Load()})
+			return true
+		})
+		sort.Slice(row.Statuses, func(i, j int) bool { return row.Statuses[i].Status < row.Statuses[j].Status })
+		report.Endpoints = append(report.Endpoints, row)
+		return true
+	})
+	sort.Slice(report.Endpoints, func(i, j int) bool { return report.Endpoints[i].Name < report.Endpoints[j].Name })
+
+	for name, regionMetrics := range metrics.Regions {
+		report.Regions = append(report.Regions, ReportRow{Name: name, Stats: newReportStats(regionMetrics)})
+	}
+	sort.Slice(report.Regions, func(i, j int) bool { return report.Regions[i].Name < report.Regions[j].Name })
+	return report
+}
+
+// ReportError is the count of one failing status code of an endpoint.
+type ReportError struct {
+	Endpoint string
+	Status   int
+	Count    int64
+	Share    float64 // of the endpoint's requests
+}
+
+// Errors lists the responses outside 2xx, most frequent first.
+func (r *LoadTestReport) Errors() []ReportError {
+	var errs []ReportError
+	for _, endpoint := range r.Endpoints {
+		for _, status := range endpoint.Statuses {
+			if status.Status >= 200 && status.Status < 300 {
+				continue
+			}
+			errs = append(errs, ReportError{
+				Endpoint: endpoint.Name,
+				Status:   status.Status,
+				Count:    status.Count,
+				Share:    float64(status.Count) / float64(endpoint.Stats.Requests),
+			})
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Count > errs[j].Count })
+	return errs
+}
+
+// ReportComparison is one figure of a run next to the baseline's.
+type ReportComparison struct {
+	Metric   string
+	Baseline string
+	Current  string
+	Change   string
+	// Worse is set when the change is a regression of more than 10%.
+	Worse bool
+}
+
+// compareReports compares the summaries and the endpoints both runs have.
+func compareReports(baseline, current *LoadTestReport) []ReportComparison {
+	var comparisons []ReportComparison
+	compare := func(metric string, old, new float64, format func(float64) string, higherIsBetter bool) {
+		comparison := ReportComparison{Metric: metric, Baseline: format(old), Current: format(new), Change: "n/a"}
+		if old != 0 {
+			change := (new - old) / old
+			comparison.Change = fmt.Sprintf("%+.1f%%", change*100)
+			if higherIsBetter {
+				change = -change
+			}
+			comparison.Worse = change > 0.1
+		} else if new == 0 {
+			comparison.Change = "0.0%"
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	duration := func(v float64) string { return time.Duration(v).String() }
+	rate := func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) }
+	perSec := func(v float64) string { return fmt.Sprintf("%.1f", v) }
+	stats := func(prefix string, old, new ReportStats, throughput bool) {
+		if throughput {
+			compare(prefix+"Requests/s", old.RequestsPerSec, new.RequestsPerSec, perSec, true)
+		}
+		compare(prefix+"Error rate", old.ErrorRate(), new.ErrorRate(), rate, false)
+		compare(prefix+"Avg", float64(old.Avg), float64(new.Avg), duration, false)
+		compare(prefix+"p50", float64(old.P50), float64(new.P50), duration, false)
+		compare(prefix+"p95", float64(old.P95), float64(new.P95), duration, false)
+		compare(prefix+"p99", float64(old.P99), float64(new.P99), duration, false)
+	}
+
+	stats("", baseline.Summary, current.Summary, true)
+	for _, endpoint := range current.Endpoints {
+		for _, old := range baseline.Endpoints {
+			if old.Name == endpoint.Name {
+				stats(endpoint.Name+" ", old.Stats, endpoint.Stats, false)
+			}
+		}
+	}
+	return comparisons
+}
+
+// latencyChartSVG draws the histogram as the share of requests per bucket,
+// next to the baseline's if there is one, so runs of different sizes
+// compare.
+func latencyChartSVG(report, baseline *LoadTestReport) string {
+	const (
+		width, height = 720, 260
+		left, bottom  = 40, 40
+		top           = 30
+	)
+	shares := func(r *LoadTestReport) []float64 {
+		var total int64
+		for _, bucket := range r.Histogram {
+			total += bucket.Count
+		}
+		values := make([]float64, len(r.Histogram))
+		for i, bucket := range r.Histogram {
+			if total > 0 {
+				values[i] = float64(bucket.Count) / float64(total)
+			}
+		}
+		return values
+	}
+	series := [][]float64{shares(report)}
+	colors := []string{"#3B82F6"}
+	if baseline != nil && len(baseline.Histogram) == len(report.Histogram) {
+		series = append([][]float64{shares(baseline)}, series...)
+		colors = append([]string{"#94A3B8"}, colors...)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`, width, height, width, height)
+	fmt.Fprintf(&b, `<text x="%d" y="18" font-size="13">Share of requests by response time</text>`, left)
+	chartHeight := float64(height - top - bottom)
+	for _, tick := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		y := float64(top) + chartHeight*(1-tick)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#E2E8F0"/>`, left, y, width-10, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%.0f%%</text>`, left-4, y+4, tick*100)
+	}
+
+	slot := float64(width-left-10) / float64(len(report.Histogram))
+	barWidth := (slot - 6) / float64(len(series))
+	for i, bucket := range report.Histogram {
+		x := float64(left) + slot*float64(i) + 3
+		for s, values := range series {
+			barHeight := chartHeight * values[i]
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				x+barWidth*float64(s), float64(top)+chartHeight-barHeight, barWidth, barHeight, colors[s])
+		}
+		label := "≤" + bucket.UpperBound.String()
+		if bucket.UpperBound == 0 {
+			label = ">" + latencyBuckets[len(latencyBuckets)-1].String()
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, x+(slot-6)/2, height-bottom+16, label)
+	}
+
+	if len(series) > 1 {
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/><text x="%d" y="%d">baseline</text>`, width-170, height-14, colors[0], width-155, height-5)
+	}
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/><text x="%d" y="%d">this run</text>`, width-85, height-14, colors[len(colors)-1], width-70, height-5)
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// reportView is what the report templates render.
+type reportView struct {
+	*LoadTestReport
+	Baseline    *LoadTestReport
+	Comparisons []ReportComparison
+	ChartFile   string
+	Chart       htmltemplate.HTML
+}
+
+var reportFuncs = map[string]interface{}{
+	"percent": func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) },
+	"status": func(status int) string {
+		if status == 0 {
+			return "not sent"
+		}
+		return fmt.Sprintf("%d", status)
+	},
+	"statuses": func(statuses []StatusCount) string {
+		var parts []string
+		for _, status := range statuses {
+			label := fmt.Sprintf("%d", status.Status)
+			if status.Status == 0 {
+				label = "not sent"
+			}
+			parts = append(parts, fmt.Sprintf("%s ×%d", label, status.Count))
+		}
+		return strings.Join(parts, ", ")
+	},
+	"date": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+}
+
+var markdownReportTemplate = template.Must(template.New("report.md").Funcs(reportFuncs).Parse(`# Load test report: {{.Name}}
+
+Run on {{date .GeneratedAt}}: {{.Users}} concurrent users with {{.Requests}} requests each, in {{.Duration}}.
+{{- if .Baseline}} Compared with the baseline run on {{date .Baseline.GeneratedAt}}.{{end}}
+Percentiles are upper bounds of histogram buckets.
+
+## Summary
+
+| Requests | Failed | Lost | Requests/s | Avg | p50 | p95 | p99 | Max |
+|---:|---:|---:|---:|---:|---:|---:|---:|---:|
+| {{.Summary.Requests}} | {{.Summary.Failed}} ({{percent .Summary.ErrorRate}}) | {{.Summary.ConnectionErrors}} | {{printf "%.1f" .Summary.RequestsPerSec}} | {{.Summary.Avg}} | ≤{{.Summary.P50}} | ≤{{.Summary.P95}} | ≤{{.Summary.P99}} | {{.Summary.Max}} |
+{{- if .Comparisons}}
+
+## Compared with baseline
+
+| Metric | Baseline | This run | Change |
+|---|---:|---:|---:|
+{{- range .Comparisons}}
+| {{.Metric}} | {{.Baseline}} | {{.Current}} | {{.Change}}{{if .Worse}} ⚠️{{end}} |
+{{- end}}
+{{- end}}
+
+## Latency
+
+![Share of requests by response time]({{.ChartFile}})
+
+## Endpoints
+
+| Endpoint | Requests | Error rate | Avg | p50 | p95 | p99 | Status codes |
+|---|---:|---:|---:|---:|---:|---:|---|
+{{- range .Endpoints}}
+| ` + "`{{.Name}}`" + ` | {{.Stats.Requests}} | {{percent .Stats.ErrorRate}} | {{.Stats.Avg}} | ≤{{.Stats.P50}} | ≤{{.Stats.P95}} | ≤{{.Stats.P99}} | {{statuses .Statuses}} |
+{{- end}}
+{{- if .Regions}}
+
+## Regions
+
+| Region | Requests | Error rate | Lost | p50 | p95 | p99 |
+|---|---:|---:|---:|---:|---:|---:|
+{{- range .Regions}}
+| {{.Name}} | {{.Stats.Requests}} | {{percent .Stats.ErrorRate}} | {{.Stats.ConnectionErrors}} | ≤{{.Stats.P50}} | ≤{{.Stats.P95}} | ≤{{.Stats.P99}} |
+{{- end}}
+{{- end}}
+
+## Errors
+{{with .Errors}}
+| Endpoint | Status | Count | Share of endpoint |
+|---|---:|---:|---:|
+{{- range .}}
+| ` + "`{{.Endpoint}}`" + ` | {{status .Status}} | {{.Count}} | {{percent .Share}} |
+{{- end}}
+{{else}}
+No failed responses.
+{{end -}}
+`))
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("report.html").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Load test report: {{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #0F172A; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #CBD5E1; padding: 0.3em 0.6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.worse { color: #DC2626; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Load test report: {{.Name}}</h1>
+<p>Run on {{date .GeneratedAt}}: {{.Users}} concurrent users with {{.Requests}} requests each, in {{.Duration}}.
+{{- if .Baseline}} Compared with the baseline run on {{date .Baseline.GeneratedAt}}.{{end}}
+Percentiles are upper bounds of histogram buckets.</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Requests</th><th>Failed</th><th>Lost</th><th>Requests/s</th><th>Avg</th><th>p50</th><th>p95</th><th>p99</th><th>Max</th></tr>
+<tr><td>{{.Summary.Requests}}</td><td>{{.Summary.Failed}} ({{percent .Summary.ErrorRate}})</td><td>{{.Summary.ConnectionErrors}}</td><td>{{printf "%.1f" .Summary.RequestsPerSec}}</td><td>{{.Summary.Avg}}</td><td>≤{{.Summary.P50}}</td><td>≤{{.Summary.P95}}</td><td>≤{{.Summary.P99}}</td><td>{{.Summary.Max}}</td></tr>
+</table>
+{{- if .Comparisons}}
+
+<h2>Compared with baseline</h2>
+<table>
+<tr><th>Metric</th><th>Baseline</th><th>This run</th><th>Change</th></tr>
+{{- range .Comparisons}}
+<tr><td>{{.Metric}}</td><td>{{.Baseline}}</td><td>{{.Current}}</td><td{{if .Worse}} class="worse"{{end}}>{{.Change}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>Latency</h2>
+{{.Chart}}
+
+<h2>Endpoints</h2>
+<table>
+<tr><th>Endpoint</th><th>Requests</th><th>Error rate</th><th>Avg</th><th>p50</th><th>p95</th><th>p99</th><th>Status codes</th></tr>
+{{- range .Endpoints}}
+<tr><td><code>{{.Name}}</code></td><td>{{.Stats.Requests}}</td><td>{{percent .Stats.ErrorRate}}</td><td>{{.Stats.Avg}}</td><td>≤{{.Stats.P50}}</td><td>≤{{.Stats.P95}}</td><td>≤{{.Stats.P99}}</td><td>{{statuses .Statuses}}</td></tr>
+{{- end}}
+</table>
+{{- if .Regions}}
+
+<h2>Regions</h2>
+<table>
+<tr><th>Region</th><th>Requests</th><th>Error rate</th><th>Lost</th><th>p50</th><th>p95</th><th>p99</th></tr>
+{{- range .Regions}}
+<tr><td>{{.Name}}</td><td>{{.Stats.Requests}}</td><td>{{percent .Stats.ErrorRate}}</td><td>{{.Stats.ConnectionErrors}}</td><td>≤{{.Stats.P50}}</td><td>≤{{.Stats.P95}}</td><td>≤{{.Stats.P99}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>Errors</h2>
+{{- with .Errors}}
+<table>
+<tr><th>Endpoint</th><th>Status</th><th>Count</th><th>Share of endpoint</th></tr>
+{{- range .}}
+<tr><td><code>{{.Endpoint}}</code></td><td>{{status .Status}}</td><td>{{.Count}}</td><td>{{percent .Share}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No failed responses.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+func (r *LoadTestReport) view(baseline *LoadTestReport, chartFile string) reportView {
+	view := reportView{
+		LoadTestReport: r,
+		Baseline:       baseline,
+		ChartFile:      chartFile,
+		Chart:          htmltemplate.HTML(latencyChartSVG(r, baseline)),
+	}
+	if baseline != nil {
+		view.Comparisons = compareReports(baseline, r)
+	}
+	return view
+}
+
+// WriteMarkdown renders the report, linking the chart from chartFile.
+func (r *LoadTestReport) WriteMarkdown(w io.Writer, baseline *LoadTestReport, chartFile string) error {
+	return markdownReportTemplate.Execute(w, r.view(baseline, chartFile))
+}
+
+// WriteHTML renders the report as a page of its own, with the chart inline.
+func (r *LoadTestReport) WriteHTML(w io.Writer, baseline *LoadTestReport) error {
+	return htmlReportTemplate.Execute(w, r.view(baseline, ""))
+}
+
+// WriteFiles writes the Markdown, HTML, SVG and JSON files of the report to
+// dir, compared with baseline if it isn't nil.
+func (r *LoadTestReport) WriteFiles(dir string, baseline *LoadTestReport) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	chartFile := r.Name + "-latency.svg"
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	files := map[string]func(io.Writer) error{
+		r.Name + ".md":   func(w io.Writer) error { return r.WriteMarkdown(w, baseline, chartFile) },
+		r.Name + ".html": func(w io.Writer) error { return r.WriteHTML(w, baseline) },
+		chartFile: func(w io.Writer) error {
+			_, err := io.WriteString(w, latencyChartSVG(r, baseline))
+			return err
+		},
+		r.Name + ".json": func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		},
+	}
+	for name, write := range files {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+		err = write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// readLoadTestReport reads the JSON file of a report.
+func readLoadTestReport(path string) (*LoadTestReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	var report LoadTestReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode report %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// writeLoadTestReport writes the report of a finalized load test to
+// config.ReportDir, if set, compared with the test's report in
+// config.BaselineDir if there is one.
+func writeLoadTestReport(t *testing.T, config LoadTestConfig, metrics *RegionalMetrics) {
+	if config.ReportDir == "" {
+		return
+	}
+	report := newLoadTestReport(t.Name(), config, metrics)
+
+	var baseline *LoadTestReport
+	if config.BaselineDir != "" {
+		var err error
+		baseline, err = readLoadTestReport(filepath.Join(config.BaselineDir, t.Name()+".json"))
+		if err != nil {
+			t.Logf("No baseline to compare with: %v", err)
+		}
+	}
+
+	if err := report.WriteFiles(config.ReportDir, baseline); err != nil {
+		t.Errorf("Failed to write load test report: %v", err)
+		return
+	}
+	t.Logf("Load test report written to %s", filepath.Join(config.ReportDir, t.Name()+".md"))
+}
+
+// sampleLoadTestRun records a small run with a failing endpoint.
+func sampleLoadTestRun(slowdown time.Duration) *RegionalMetrics {
+	metrics := newRegionalMetrics(&LoadTestMetrics{}, []LoadRegion{{Name: "eu-west"}})
+	region := metrics.Regions["eu-west"]
+	for i := 1; i <= 100; i++ {
+		duration := time.Duration(i)*time.Millisecond + slowdown
+		metrics.Overall.AddResponse("GET /api/tasks", http.StatusOK, duration, true)
+		region.AddResponse("GET /api/tasks", http.StatusOK, duration, true)
+		status := http.StatusCreated
+		if i%10 == 0 {
+			status = http.StatusInternalServerError
+		}
+		metrics.Overall.AddResponse("POST /api/tasks", status, duration, status == http.StatusCreated)
+		region.AddResponse("POST /api/tasks", status, duration, status == http.StatusCreated)
+	}
+	metrics.Overall.AddResponse("PUT /api/tasks/{id}", 0, time.Millisecond, false)
+	metrics.Overall.TotalDuration = 2 * time.Second
+	metrics.Finalize()
+	return metrics
+}
+
+func TestLoadTestReport(t *testing.T) {
+	config := LoadTestConfig{ConcurrentUsers: 2, RequestsPerUser: 100}
+	report := newLoadTestReport("TestSample", config, sampleLoadTestRun(0))
+
+	assert.Equal(t, int64(201), report.Summary.Requests)
+	assert.Equal(t, int64(11), report.Summary.Failed)
+	assert.Equal(t, []string{"GET /api/tasks", "POST /api/tasks", "PUT /api/tasks/{id}"},
+		[]string{report.Endpoints[0].Name, report.Endpoints[1].Name, report.Endpoints[2].Name})
+	assert.Equal(t, []StatusCount{{Status: 201, Count: 90}, {Status: 500, Count: 10}}, report.Endpoints[1].Statuses)
+	assert.Equal(t, 0.1, report.Endpoints[1].Stats.ErrorRate())
+	assert.Equal(t, 100*time.Millisecond, report.Endpoints[0].Stats.P95)
+	require.Len(t, report.Regions, 1)
+	assert.Equal(t, int64(200), report.Regions[0].Stats.Requests)
+
+	var histogramTotal int64
+	for _, bucket := range report.Histogram {
+		histogramTotal += bucket.Count
+	}
+	assert.Equal(t, report.Summary.Requests, histogramTotal)
+	assert.Zero(t, report.Histogram[len(report.Histogram)-1].UpperBound)
+
+	assert.Equal(t, []ReportError{
+		{Endpoint: "POST /api/tasks", Status: 500, Count: 10, Share: 0.1},
+		{Endpoint: "PUT /api/tasks/{id}", Status: 0, Count: 1, Share: 1},
+	}, report.Errors())
+}
+
+func TestCompareReports(t *testing.T) {
+	config := LoadTestConfig{ConcurrentUsers: 2, RequestsPerUser: 100}
+	baseline := newLoadTestReport("TestSample", config, sampleLoadTestRun(0))
+	current := newLoadTestReport("TestSample", config, sampleLoadTestRun(200*time.Millisecond))
+
+	comparisons := map[string]ReportComparison{}
+	for _, comparison := range compareReports(baseline, current) {
+		comparisons[comparison.Metric] = comparison
+	}
+	assert.Equal(t, ReportComparison{Metric: "p50", Baseline: "50ms", Current: "250ms", Change: "+400.0%", Worse: true}, comparisons["p50"])
+	assert.Equal(t, ReportComparison{Metric: "Error rate", Baseline: "5.47%", Current: "5.47%", Change: "+0.0%"}, comparisons["Error rate"])
+	assert.False(t, comparisons["Requests/s"].Worse)
+	assert.True(t, comparisons["POST /api/tasks p95"].Worse)
+	assert.Contains(t, comparisons, "PUT /api/tasks/{id} p99")
+
+	// A faster run is no regression
+	for _, comparison := range compareReports(current, baseline) {
+		assert.False(t, comparison.Worse, comparison.Metric)
+	}
+}
+
+func TestLoadTestReportFiles(t *testing.T) {
+	dir := t.TempDir()
+	config := LoadTestConfig{ConcurrentUsers: 2, RequestsPerUser: 100}
+	baseline := newLoadTestReport("TestSample", config, sampleLoadTestRun(0))
+	report := newLoadTestReport("TestSample", config, sampleLoadTestRun(200*time.Millisecond))
+	require.NoError(t, report.WriteFiles(dir, baseline))
+
+	read, err := readLoadTestReport(filepath.Join(dir, "TestSample.json"))
+	require.NoError(t, err)
+	assert.Equal(t, report.Summary, read.Summary)
+	assert.Equal(t, report.Endpoints, read.Endpoints)
+	assert.True(t, report.GeneratedAt.Equal(read.GeneratedAt))
+
+	markdown, err := os.ReadFile(filepath.Join(dir, "TestSample.md"))
+	require.NoError(t, err)
+	assert.Contains(t, string(markdown), "## Compared with baseline")
+	assert.Contains(t, string(markdown), "| p50 | 50ms | 250ms | +400.0% ⚠️ |")
+	assert.Contains(t, string(markdown), "![Share of requests by response time](TestSample-latency.svg)")
+	assert.Contains(t, string(markdown), "| `POST /api/tasks` | 500 | 10 | 10.00% |")
+	assert.Contains(t, string(markdown), "| `PUT /api/tasks/{id}` | not sent | 1 | 100.00% |")
+	assert.Contains(t, string(markdown), "201 ×90, 500 ×10")
+	assert.Contains(t, string(markdown), "| eu-west | 200 |")
+
+	page, err := os.ReadFile(filepath.Join(dir, "TestSample.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "<svg ")
+	assert.Contains(t, string(page), ">baseline</text>")
+	assert.Contains(t, string(page), `<td class="worse">&#43;400.0%</td>`)
+
+	chart, err := os.ReadFile(filepath.Join(dir, "TestSample-latency.svg"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(chart), `<svg xmlns="http://www.w3.org/2000/svg"`))
+
+	// Without a baseline there is nothing to compare
+	var b strings.Builder
+	require.NoError(t, report.WriteMarkdown(&b, nil, "chart.svg"))
+	assert.NotContains(t, b.String(), "baseline")
+	assert.NotContains(t, latencyChartSVG(report, nil), "baseline")
+}
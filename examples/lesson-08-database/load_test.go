@@ -6,15 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
 )
 
 // LoadTestConfig defines load testing parameters
@@ -23,6 +25,9 @@ type LoadTestConfig struct {
 	RequestsPerUser  int
 	ConcurrentUsers  int
 	TestDurationSecs int
+	Regions          []LoadRegion // from LOAD_REGIONS; none means no simulated network
+	ReportDir        string       // from LOAD_REPORT_DIR; reports are written there if set
+	BaselineDir      string       // from LOAD_BASELINE_DIR; reports there are compared with
 }
 
 var defaultLoadConfig = LoadTestConfig{
@@ -32,7 +37,19 @@ var defaultLoadConfig = LoadTestConfig{
 	TestDurationSecs: 30,
 }
 
-// LoadTestMetrics tracks performance metrics
+// latencyBuckets are the upper bounds of the response time histogram; a
+// last, unbounded bucket counts everything slower.
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// LoadTestMetrics tracks performance metrics. Requests are recorded with
+// atomic counters and a fixed-bucket histogram, so recording costs the same
+// at any concurrency and memory doesn't grow with the number of requests;
+// the exported fields are filled in by Finalize.
 type LoadTestMetrics struct {
 	TotalRequests    int64
 	SuccessfulReqs   int64
@@ -43,47 +60,57 @@ type LoadTestMetrics struct {
 	MaxResponseTime  time.Duration
 	RequestsPerSec   float64
 	ConnectionErrors int64
-	mu               sync.RWMutex
-	responseTimes    []time.Duration
+
+	requests         atomic.Int64
+	successes        atomic.Int64
+	connectionErrors atomic.Int64
+	totalNanos       atomic.Int64
+	minNanos         atomic.Int64
+	maxNanos         atomic.Int64
+	buckets          [len(latencyBuckets) + 1]atomic.Int64
+	endpoints        sync.Map // endpoint -> *EndpointMetrics, filled by AddResponse
 }
 
 func (m *LoadTestMetrics) AddRequest(duration time.Duration, success bool) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.TotalRequests++
-	m.responseTimes = append(m.responseTimes, duration)
-
+	m.requests.Add(1)
 	if success {
-		m.SuccessfulReqs++
-	} else {
-		m.FailedRequests++
+		m.successes.Add(1)
 	}
+	m.totalNanos.Add(int64(duration))
 
-	if m.MinResponseTime == 0 || duration < m.MinResponseTime {
-		m.MinResponseTime = duration
+	for {
+		min := m.minNanos.Load()
+		if min != 0 && min <= int64(duration) || m.minNanos.CompareAndSwap(min, int64(duration)) {
+			break
+		}
 	}
-	if duration > m.MaxResponseTime {
-		m.MaxResponseTime = duration
+	for {
+		max := m.maxNanos.Load()
+		if max >= int64(duration) || m.maxNanos.CompareAndSwap(max, int64(duration)) {
+			break
+		}
 	}
+
+	bucket := sort.Search(len(latencyBuckets), func(i int) bool { return duration <= latencyBuckets[i] })
+	m.buckets[bucket].Add(1)
 }
 
 func (m *LoadTestMetrics) AddConnectionError() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.ConnectionErrors++
+	m.connectionErrors.Add(1)
 }
 
+// Finalize copies the counters into the exported fields; call it once the
+// load has stopped.
 func (m *LoadTestMetrics) Finalize() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if len(m.responseTimes) > 0 {
-		var total time.Duration
-		for _, rt := range m.responseTimes {
-			total += rt
-		}
-		m.AvgResponseTime = total / time.Duration(len(m.responseTimes))
+	m.TotalRequests = m.requests.Load()
+	m.SuccessfulReqs = m.successes.Load()
+	m.FailedRequests = m.TotalRequests - m.SuccessfulReqs
+	m.ConnectionErrors = m.connectionErrors.Load()
+	m.MinResponseTime = time.Duration(m.minNanos.Load())
+	m.MaxResponseTime = time.Duration(m.maxNanos.Load())
+
+	if m.TotalRequests > 0 {
+		m.AvgResponseTime = time.Duration(m.totalNanos.Load() / m.TotalRequests)
 	}
 
 	if m.TotalDuration > 0 {
@@ -91,10 +118,35 @@ func (m *LoadTestMetrics) Finalize() {
 	}
 }
 
-func (m *LoadTestMetrics) Report() {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// Percentile returns the upper bound of the histogram bucket that holds the
+// p-th percentile (0 < p <= 100), capped at the slowest request.
+func (m *LoadTestMetrics) Percentile(p float64) time.Duration {
+	var counts [len(latencyBuckets) + 1]int64
+	var total int64
+	for i := range m.buckets {
+		counts[i] = m.buckets[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	max := time.Duration(m.maxNanos.Load())
+	var seen int64
+	for i, count := range counts {
+		if seen += count; seen < rank {
+			continue
+		}
+		if i < len(latencyBuckets) && latencyBuckets[i] < max {
+			return latencyBuckets[i]
+		}
+		break
+	}
+	return max
+}
 
+func (m *LoadTestMetrics) Report() {
 	fmt.Printf("\n=== Load Test Results ===\n")
 	fmt.Printf("Total Requests: %d\n", m.TotalRequests)
 	fmt.Printf("Successful Requests: %d (%.2f%%)\n", m.SuccessfulReqs, float64(m.SuccessfulReqs)/float64(m.TotalRequests)*100)
@@ -106,31 +158,59 @@ func (m *LoadTestMetrics) Report() {
 	fmt.Printf("Requests per Second: %.2f\n", m.RequestsPerSec)
 	fmt.Printf("Test Duration: %v\n", m.TotalDuration)
 
-	// Calculate percentiles
-	if len(m.responseTimes) > 0 {
-		// Sort response times for percentile calculation
-		times := make([]time.Duration, len(m.responseTimes))
-		copy(times, m.responseTimes)
-		
-		// Simple bubble sort (good enough for testing)
-		for i := 0; i < len(times); i++ {
-			for j := 0; j < len(times)-1-i; j++ {
-				if times[j] > times[j+1] {
-					times[j], times[j+1] = times[j+1], times[j]
-				}
-			}
+	// Percentiles are histogram bucket bounds, so read them as "at most"
+	if m.TotalRequests > 0 {
+		for _, p := range []float64{50, 90, 95, 99} {
+			fmt.Printf("%.0fth percentile: <= %v\n", p, m.Percentile(p))
 		}
+	}
+}
 
-		p50 := times[len(times)*50/100]
-		p90 := times[len(times)*90/100]
-		p95 := times[len(times)*95/100]
-		p99 := times[len(times)*99/100]
-
-		fmt.Printf("50th percentile: %v\n", p50)
-		fmt.Printf("90th percentile: %v\n", p90)
-		fmt.Printf("95th percentile: %v\n", p95)
-		fmt.Printf("99th percentile: %v\n", p99)
+func TestLoadTestMetrics(t *testing.T) {
+	metrics := &LoadTestMetrics{}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= 100; i++ {
+				metrics.AddRequest(time.Duration(i)*time.Millisecond, i%10 != 0)
+			}
+		}()
 	}
+	wg.Wait()
+	metrics.TotalDuration = time.Second
+	metrics.Finalize()
+
+	assert.Equal(t, int64(800), metrics.TotalRequests)
+	assert.Equal(t, int64(720), metrics.SuccessfulReqs)
+	assert.Equal(t, int64(80), metrics.FailedRequests)
+	assert.Equal(t, time.Millisecond, metrics.MinResponseTime)
+	assert.Equal(t, 100*time.Millisecond, metrics.MaxResponseTime)
+	assert.Equal(t, 50500*time.Microsecond, metrics.AvgResponseTime)
+	assert.Equal(t, 800.0, metrics.RequestsPerSec)
+
+	assert.Equal(t, 50*time.Millisecond, metrics.Percentile(50))
+	// The 90th percentile (90ms) falls in the 100ms bucket
+	assert.Equal(t, 100*time.Millisecond, metrics.Percentile(90))
+	assert.Equal(t, 100*time.Millisecond, metrics.Percentile(99))
+	assert.Zero(t, (&LoadTestMetrics{}).Percentile(50))
+}
+
+// BenchmarkLoadTestMetrics records from every P at once. Compare its ns/op
+// with the response times of the load tests, which are milliseconds: the
+// collector adds well under a microsecond per request however many
+// goroutines record at the same time.
+func BenchmarkLoadTestMetrics(b *testing.B) {
+	metrics := &LoadTestMetrics{}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		duration := time.Duration(0)
+		for pb.Next() {
+			duration = (duration + 37*time.Microsecond) % (3 * time.Second)
+			metrics.AddRequest(duration, true)
+		}
+	})
 }
 
 func TestLoadUserRegistration(t *testing.T) {
@@ -138,7 +218,7 @@ func TestLoadUserRegistration(t *testing.T) {
 		t.Skip("Skipping load test in short mode")
 	}
 
-	cleanupTestData()
+	env := newTestEnv(t)
 	metrics := &LoadTestMetrics{}
 	
 	startTime := time.Now()
@@ -155,7 +235,7 @@ func TestLoadUserRegistration(t *testing.T) {
 				
 				regReq := RegisterRequest{
 					Email:     fmt.Sprintf("loadtest%d_%d@example.com", userIndex, j),
-					Password:  "password123",
+					Password:  "Tasks-Pass-2024",
 					FirstName: fmt.Sprintf("User%d", userIndex),
 					LastName:  fmt.Sprintf("Test%d", j),
 				}
@@ -165,11 +245,11 @@ func TestLoadUserRegistration(t *testing.T) {
 				req.Header.Set("Content-Type", "application/json")
 				w := httptest.NewRecorder()
 
-				testHandler.Register(w, req)
+				env.handler.Register(w, req)
 				
 				duration := time.Since(reqStart)
 				success := w.Code == http.StatusCreated
-				metrics.AddRequest(duration, success)
+				metrics.AddResponse("POST /api/auth/register", w.Code, duration, success)
 
 				if !success {
 					t.Logf("Registration failed for user %d_%d: %d", userIndex, j, w.Code)
@@ -182,6 +262,7 @@ func TestLoadUserRegistration(t *testing.T) {
 	metrics.TotalDuration = time.Since(startTime)
 	metrics.Finalize()
 	metrics.Report()
+	writeLoadTestReport(t, defaultLoadConfig, newRegionalMetrics(metrics, nil))
 
 	// Assertions
 	assert.Greater(t, metrics.SuccessfulReqs, int64(0), "Should have successful registrations")
@@ -194,16 +275,17 @@ func TestLoadTaskOperations(t *testing.T) {
 		t.Skip("Skipping load test in short mode")
 	}
 
-	cleanupTestData()
+	env := newTestEnv(t)
 	
 	// Create test users and get tokens
 	tokens := make([]string, defaultLoadConfig.ConcurrentUsers)
 	for i := 0; i < defaultLoadConfig.ConcurrentUsers; i++ {
 		email := fmt.Sprintf("taskload%d@example.com", i)
-		tokens[i] = createTestUserAndGetToken(t, email)
+		tokens[i] = env.createTestUserAndGetToken(t, email)
 	}
 
 	metrics := &LoadTestMetrics{}
+	regional := newRegionalMetrics(metrics, defaultLoadConfig.Regions)
 	startTime := time.Now()
 	var wg sync.WaitGroup
 
@@ -213,41 +295,50 @@ func TestLoadTaskOperations(t *testing.T) {
 		go func(userIndex int) {
 			defer wg.Done()
 			token := tokens[userIndex]
+			user := regional.virtualUser(defaultLoadConfig.Regions, userIndex)
 			
 			for j := 0; j < defaultLoadConfig.RequestsPerUser; j++ {
 				// Mix of operations: 60% create, 30% read, 10% update
 				operation := j % 10
 				
-				switch {
-				case operation < 6: // Create task
-					performTaskCreate(t, token, userIndex, j, metrics)
-				case operation < 9: // Read tasks
-					performTaskRead(t, token, metrics)
-				default: // Update task (if any exist)
-					performTaskUpdate(t, token, metrics)
-				}
+				user.Do(func(metrics requestRecorder) {
+					switch {
+					case operation < 6: // Create task
+						env.performTaskCreate(t, token, userIndex, j, metrics)
+					case operation < 9: // Read tasks
+						env.performTaskRead(t, token, metrics)
+					default: // Update task (if any exist)
+						env.performTaskUpdate(t, token, metrics)
+					}
+				})
 			}
 		}(i)
 	}
 
 	wg.Wait()
 	metrics.TotalDuration = time.Since(startTime)
-	metrics.Finalize()
-	metrics.Report()
+	regional.Finalize()
+	regional.Report()
+	writeLoadTestReport(t, defaultLoadConfig, regional)
 
 	// Performance assertions
 	assert.Greater(t, metrics.SuccessfulReqs, int64(0), "Should have successful operations")
 	assert.Less(t, float64(metrics.FailedRequests)/float64(metrics.TotalRequests), 0.10, "Failure rate should be less than 10%")
-	assert.Greater(t, metrics.RequestsPerSec, 10.0, "Should handle at least 10 requests per second")
+	// A simulated network bounds the throughput by its latency rather than
+	// the server's speed
+	if len(defaultLoadConfig.Regions) == 0 {
+		assert.Greater(t, metrics.RequestsPerSec, 10.0, "Should handle at least 10 requests per second")
+	}
 }
 
 func TestDatabaseConnectionPoolUnderLoad(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping load test in short mode")
 	}
+	env := newTestEnv(t)
 
 	// Monitor database connection pool during load
-	startStats := testDB.Stats()
+	startStats := env.db.Stats()
 	
 	var wg sync.WaitGroup
 	const numConcurrentConnections = 50
@@ -266,10 +357,10 @@ func TestDatabaseConnectionPoolUnderLoad(t *testing.T) {
 				case 0:
 					// Read operation
 					var count int
-					testDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+					env.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
 				case 1:
 					// Complex query
-					rows, err := testDB.QueryContext(ctx, `
+					rows, err := env.db.QueryContext(ctx, `
 						SELECT u.id, COUNT(t.id) as task_count 
 						FROM users u 
 						LEFT JOIN tasks t ON u.id = t.user_id 
@@ -284,7 +375,7 @@ func TestDatabaseConnectionPoolUnderLoad(t *testing.T) {
 					}
 				case 2:
 					// Health check
-					testDB.PingContext(ctx)
+					env.db.PingContext(ctx)
 				}
 				
 				cancel()
@@ -295,7 +386,7 @@ func TestDatabaseConnectionPoolUnderLoad(t *testing.T) {
 
 	wg.Wait()
 	
-	endStats := testDB.Stats()
+	endStats := env.db.Stats()
 	
 	// Verify connection pool behaved correctly
 	assert.LessOrEqual(t, endStats.OpenConnections, endStats.MaxOpenConnections, 
@@ -304,7 +395,7 @@ func TestDatabaseConnectionPoolUnderLoad(t *testing.T) {
 	
 	fmt.Printf("\n=== Connection Pool Stats ===\n")
 	fmt.Printf("Max Open Connections: %d\n", endStats.MaxOpenConnections)
-	fmt.Printf("Open Connections: %d\n", endStats.OpenConnections)
+	fmt.Printf("Open Connections: %d (at start: %d)\n", endStats.OpenConnections, startStats.OpenConnections)
 	fmt.Printf("Connections In Use: %d\n", endStats.InUse)
 	fmt.Printf("Idle Connections: %d\n", endStats.Idle)
 	fmt.Printf("Total Opened: %d\n", endStats.MaxLifetimeClosed)
@@ -315,8 +406,8 @@ func TestLongRunningTransactions(t *testing.T) {
 		t.Skip("Skipping load test in short mode")
 	}
 
-	cleanupTestData()
-	token := createTestUserAndGetToken(t, "longtx@example.com")
+	env := newTestEnv(t)
+	token := env.createTestUserAndGetToken(t, "longtx@example.com")
 
 	var wg sync.WaitGroup
 	metrics := &LoadTestMetrics{}
@@ -347,7 +438,7 @@ func TestLongRunningTransactions(t *testing.T) {
 			req.Header.Set("Authorization", "Bearer "+token)
 			w := httptest.NewRecorder()
 
-			testHandler.CreateTask(w, req)
+			env.handler.CreateTask(w, req)
 			
 			duration := time.Since(start)
 			success := w.Code == http.StatusCreated
@@ -369,8 +460,8 @@ func TestLongRunningTransactions(t *testing.T) {
 
 // BenchmarkTaskCreation benchmarks task creation performance
 func BenchmarkTaskCreation(b *testing.B) {
-	cleanupTestData()
-	token := createTestUserAndGetToken(&testing.T{}, "bench@example.com")
+	env := newTestEnv(b)
+	token := env.createTestUserAndGetToken(&testing.T{}, "bench@example.com")
 
 	createReq := CreateTaskRequest{
 		Title:       "Benchmark Task",
@@ -389,7 +480,7 @@ func BenchmarkTaskCreation(b *testing.B) {
 		req.Header.Set("Authorization", "Bearer "+token)
 		w := httptest.NewRecorder()
 
-		testHandler.CreateTask(w, req)
+		env.handler.CreateTask(w, req)
 
 		if w.Code != http.StatusCreated {
 			b.Fatalf("Expected 201, got %d", w.Code)
@@ -399,8 +490,8 @@ func BenchmarkTaskCreation(b *testing.B) {
 
 // BenchmarkTaskRetrieval benchmarks task retrieval performance
 func BenchmarkTaskRetrieval(b *testing.B) {
-	cleanupTestData()
-	token := createTestUserAndGetToken(&testing.T{}, "benchget@example.com")
+	env := newTestEnv(b)
+	token := env.createTestUserAndGetToken(&testing.T{}, "benchget@example.com")
 
 	// Create some test data
 	for i := 0; i < 100; i++ {
@@ -415,7 +506,7 @@ func BenchmarkTaskRetrieval(b *testing.B) {
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
 		w := httptest.NewRecorder()
-		testHandler.CreateTask(w, req)
+		env.handler.CreateTask(w, req)
 	}
 
 	b.ResetTimer()
@@ -426,7 +517,7 @@ func BenchmarkTaskRetrieval(b *testing.B) {
 		req.Header.Set("Authorization", "Bearer "+token)
 		w := httptest.NewRecorder()
 
-		testHandler.GetTasks(w, req)
+		env.handler.GetTasks(w, req)
 
 		if w.Code != http.StatusOK {
 			b.Fatalf("Expected 200, got %d", w.Code)
@@ -435,7 +526,7 @@ func BenchmarkTaskRetrieval(b *testing.B) {
 }
 
 // Helper functions for load testing
-func performTaskCreate(t *testing.T, token string, userIndex, taskIndex int, metrics *LoadTestMetrics) {
+func (env *testEnv) performTaskCreate(t *testing.T, token string, userIndex, taskIndex int, metrics requestRecorder) {
 	start := time.Now()
 	
 	createReq := CreateTaskRequest{
@@ -450,28 +541,28 @@ func performTaskCreate(t *testing.T, token string, userIndex, taskIndex int, met
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	testHandler.CreateTask(w, req)
+	env.handler.CreateTask(w, req)
 	
 	duration := time.Since(start)
 	success := w.Code == http.StatusCreated
-	metrics.AddRequest(duration, success)
+	metrics.AddResponse("POST /api/tasks", w.Code, duration, success)
 }
 
-func performTaskRead(t *testing.T, token string, metrics *LoadTestMetrics) {
+func (env *testEnv) performTaskRead(t *testing.T, token string, metrics requestRecorder) {
 	start := time.Now()
 	
 	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	testHandler.GetTasks(w, req)
+	env.handler.GetTasks(w, req)
 	
 	duration := time.Since(start)
 	success := w.Code == http.StatusOK
-	metrics.AddRequest(duration, success)
+	metrics.AddResponse("GET /api/tasks", w.Code, duration, success)
 }
 
-func performTaskUpdate(t *testing.T, token string, metrics *LoadTestMetrics) {
+func (env *testEnv) performTaskUpdate(t *testing.T, token string, metrics requestRecorder) {
 	start := time.Now()
 	
 	// First, try to get a task to update
@@ -479,7 +570,7 @@ func performTaskUpdate(t *testing.T, token string, metrics *LoadTestMetrics) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
-	testHandler.GetTasks(w, req)
+	env.handler.GetTasks(w, req)
 	
 	if w.Code == http.StatusOK {
 		var response TaskListResponse
@@ -489,27 +580,28 @@ func performTaskUpdate(t *testing.T, token string, metrics *LoadTestMetrics) {
 			taskID := response.Tasks[0].ID
 			
 			updateReq := UpdateTaskRequest{
-				Description: stringPtr("Updated during load test"),
+				Description: Some("Updated during load test"),
 			}
 
 			body, _ := json.Marshal(updateReq)
-			req2 := httptest.NewRequest(http.MethodPut, "/api/tasks/"+taskID, bytes.NewReader(body))
+			req2 := httptest.NewRequest(http.MethodPut, "/api/tasks/"+taskID.String(), bytes.NewReader(body))
 			req2.Header.Set("Content-Type", "application/json")
 			req2.Header.Set("Authorization", "Bearer "+token)
 			w2 := httptest.NewRecorder()
 
-			testHandler.UpdateTask(w2, req2)
+			env.handler.UpdateTask(w2, req2)
 			
 			duration := time.Since(start)
 			success := w2.Code == http.StatusOK
-			metrics.AddRequest(duration, success)
+			metrics.AddResponse("PUT /api/tasks/{id}", w2.Code, duration, success)
 			return
 		}
 	}
 	
-	// If no task to update, record as failed
+	// If no task to update, record as failed, without a status as the update
+	// was never sent
 	duration := time.Since(start)
-	metrics.AddRequest(duration, false)
+	metrics.AddResponse("PUT /api/tasks/{id}", 0, duration, false)
 }
 
 // TestMain for load tests - you can run with: go test -tags=loadtest
@@ -528,5 +620,14 @@ func init() {
 		if concurrent := os.Getenv("LOAD_CONCURRENT"); concurrent != "" {
 			fmt.Sscanf(concurrent, "%d", &defaultLoadConfig.ConcurrentUsers)
 		}
+		if regions := os.Getenv("LOAD_REGIONS"); regions != "" {
+			parsed, err := parseLoadRegions(regions)
+			if err != nil {
+				log.Fatal("Invalid LOAD_REGIONS: ", err)
+			}
+			defaultLoadConfig.Regions = parsed
+		}
+		defaultLoadConfig.ReportDir = os.Getenv("LOAD_REPORT_DIR")
+		defaultLoadConfig.BaselineDir = os.Getenv("LOAD_BASELINE_DIR")
 	}
 }
\ No newline at end of file
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"respond"
+)
+
+const (
+	defaultMaxInFlight             = 500
+	defaultConservativeMaxInFlight = 100
+
+	// loadShedRetryAfter is short: shedding is about the next second's
+	// load, not a drain
+	loadShedRetryAfter = "1"
+
+	Overloaded = "overloaded"
+)
+
+var (
+	loadShedRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_shed_rejected_total",
+			Help: "Total number of requests refused by load shedding, by mode",
+		},
+		[]string{"mode"},
+	)
+
+	loadShedConservative = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "load_shed_conservative",
+			Help: "1 while load shedding uses its conservative limit",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(loadShedRejectedTotal)
+	prometheus.MustRegister(loadShedConservative)
+}
+
+// LoadShedConfig caps concurrent requests. ConservativeMaxInFlight applies
+// instead of MaxInFlight while an error budget is nearly spent.
+type LoadShedConfig struct {
+	MaxInFlight             int
+	ConservativeMaxInFlight int
+}
+
+// LoadShedder refuses requests beyond a concurrency limit with 503 and
+// Retry-After, so an overloaded instance answers some requests quickly
+// instead of all of them slowly. It has two limits: the normal one, and a
+// lower conservative one the SLO tracker switches to when an endpoint is
+// about to exhaust its error budget, trading throughput for reliability.
+type LoadShedder struct {
+	maxInFlight             int64
+	conservativeMaxInFlight int64
+	inFlight                atomic.Int64
+	conservative            atomic.Bool
+}
+
+func NewLoadShedder(config LoadShedConfig) *LoadShedder {
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = defaultMaxInFlight
+	}
+	if config.ConservativeMaxInFlight <= 0 || config.ConservativeMaxInFlight > config.MaxInFlight {
+		config.ConservativeMaxInFlight = config.MaxInFlight
+	}
+	return &LoadShedder{
+		maxInFlight:             int64(config.MaxInFlight),
+		conservativeMaxInFlight: int64(config.ConservativeMaxInFlight),
+	}
+}
+
+// SetConservative switches between the normal and the conservative limit.
+func (l *LoadShedder) SetConservative(conservative bool) {
+	l.conservative.Store(conservative)
+	if conservative {
+		loadShedConservative.Set(1)
+	} else {
+		loadShedConservative.Set(0)
+	}
+}
+
+func (l *LoadShedder) Conservative() bool {
+	return l.conservative.Load()
+}
+
+// Limit is the number of concurrent requests currently allowed.
+func (l *LoadShedder) Limit() int64 {
+	if l.Conservative() {
+		return l.conservativeMaxInFlight
+	}
+	return l.maxInFlight
+}
+
+func (l *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := l.Limit()
+		if l.inFlight.Add(1) > limit {
+			l.inFlight.Add(-1)
+
+			mode := "normal"
+			if l.Conservative() {
+				mode = "conservative"
+			}
+			loadShedRejectedTotal.WithLabelValues(mode).Inc()
+
+			w.Header().Set("Retry-After", loadShedRetryAfter)
+			respond.JSON(w, http.StatusServiceUnavailable, ErrorResponse{
+				Error:     http.StatusText(http.StatusServiceUnavailable),
+				Message:   "The server is overloaded; retry the request",
+				Code:      Overloaded,
+				RequestID: requestID(w),
+				Details:   map[string]interface{}{"limit": limit, "mode": mode},
+			})
+			return
+		}
+		defer l.inFlight.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
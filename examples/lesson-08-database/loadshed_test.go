@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoadShedder(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedConfig{})
+	assert.Equal(t, int64(defaultMaxInFlight), shedder.Limit())
+
+	// A conservative limit above the normal one would be no limit at all
+	shedder = NewLoadShedder(LoadShedConfig{MaxInFlight: 5, ConservativeMaxInFlight: 50})
+	shedder.SetConservative(true)
+	assert.Equal(t, int64(5), shedder.Limit())
+}
+
+func TestLoadShedder(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedConfig{MaxInFlight: 3, ConservativeMaxInFlight: 1})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	// Two slow requests are in flight
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+		}()
+		<-started
+	}
+
+	// Conservative mode refuses a third one that the normal limit allows
+	shedder.SetConservative(true)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, loadShedRetryAfter, w.Header().Get("Retry-After"))
+	var body ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, Overloaded, body.Code)
+	assert.Equal(t, map[string]interface{}{"limit": 1.0, "mode": "conservative"}, body.Details)
+
+	shedder.SetConservative(false)
+	go func() {
+		<-started
+		close(release)
+	}()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	wg.Wait()
+	assert.Zero(t, shedder.inFlight.Load())
+}
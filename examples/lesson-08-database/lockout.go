@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLockoutThreshold = 5
+	defaultLockoutDuration  = 15 * time.Minute
+
+	AccountLocked = "account_locked"
+)
+
+// AccountLockedError is returned for logins to an account that is locked
+// after too many failed attempts.
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("account is locked until %s", e.Until.Format(time.RFC3339))
+}
+
+// LoginLockout locks an email address after threshold failed logins within
+// duration; the lock expires on its own after duration. Attempts for unknown
+// emails are counted too, so lockouts don't reveal which accounts exist.
+// State is kept in memory per server process.
+type LoginLockout struct {
+	threshold int
+	duration  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count       int
+	firstAt     time.Time
+	lockedUntil time.Time
+}
+
+func NewLoginLockout(threshold int, duration time.Duration) *LoginLockout {
+	return &LoginLockout{
+		threshold: threshold,
+		duration:  duration,
+		now:       time.Now,
+		failures:  make(map[string]*loginFailures),
+	}
+}
+
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns an *AccountLockedError while the email is locked.
+func (l *LoginLockout) Check(email string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.failures[lockoutKey(email)]; ok && l.now().Before(f.lockedUntil) {
+		return &AccountLockedError{Until: f.lockedUntil}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login and returns an *AccountLockedError if
+// it locked the email.
+func (l *LoginLockout) RecordFailure(email string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, f := range l.failures {
+		if now.Sub(f.firstAt) > l.duration && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+
+	key := lockoutKey(email)
+	f, ok := l.failures[key]
+	if !ok {
+		f = &loginFailures{firstAt: now}
+		l.failures[key] = f
+	}
+	f.count++
+
+	if f.count >= l.threshold {
+		f.lockedUntil = now.Add(l.duration)
+		// Start counting afresh once the lock expires
+		f.count = 0
+		f.firstAt = f.lockedUntil
+		return &AccountLockedError{Until: f.lockedUntil}
+	}
+	return nil
+}
+
+// Reset clears the failures after a successful login.
+func (l *LoginLockout) Reset(email string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, lockoutKey(email))
+}
+
+func (h *Handler) respondWithAccountLocked(w http.ResponseWriter, err *AccountLockedError) {
+	retryAfter := int(time.Until(err.Until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	h.respondWithJSON(w, http.StatusTooManyRequests, ErrorResponse{
+		Error:     http.StatusText(http.StatusTooManyRequests),
+		Message:   "Too many failed login attempts, try again later",
+		Code:      AccountLocked,
+		RequestID: requestID(w),
+		Details:   map[string]time.Time{"lockedUntil": err.Until},
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLockout(t *testing.T) {
+	now := time.Now()
+	lockout := NewLoginLockout(3, time.Minute)
+	lockout.now = func() time.Time { return now }
+
+	assert.NoError(t, lockout.RecordFailure("a@example.com"))
+	assert.NoError(t, lockout.RecordFailure("A@example.com "))
+	assert.NoError(t, lockout.Check("a@example.com"))
+
+	var lockedErr *AccountLockedError
+	require.True(t, errors.As(lockout.RecordFailure("a@example.com"), &lockedErr))
+	assert.Equal(t, now.Add(time.Minute), lockedErr.Until)
+	assert.Error(t, lockout.Check("a@example.com"))
+	assert.NoError(t, lockout.Check("b@example.com"), "other emails are unaffected")
+
+	// The lock expires on its own
+	now = now.Add(time.Minute + time.Second)
+	assert.NoError(t, lockout.Check("a@example.com"))
+	assert.NoError(t, lockout.RecordFailure("a@example.com"), "counting restarts after the lock")
+}
+
+func TestLoginLockoutWindow(t *testing.T) {
+	now := time.Now()
+	lockout := NewLoginLockout(2, time.Minute)
+	lockout.now = func() time.Time { return now }
+
+	assert.NoError(t, lockout.RecordFailure("a@example.com"))
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, lockout.RecordFailure("a@example.com"), "old failures are forgotten")
+
+	lockout.Reset("a@example.com")
+	assert.NoError(t, lockout.RecordFailure("a@example.com"))
+}
+
+func (env *testEnv) postLogin(email, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(LoginRequest{Email: email, Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	env.handler.Login(w, req)
+	return w
+}
+
+func TestLoginLocksAccountAfterFailures(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.handler.lockout = NewLoginLockout(3, time.Minute)
+
+	env.registerTestUser(t, "locked@example.com")
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, env.postLogin("locked@example.com", "wrong-password").Code)
+	}
+
+	w := env.postLogin("locked@example.com", "wrong-password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, AccountLocked, errorCode(t, w))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Even the right password is refused while locked
+	w = env.postLogin("locked@example.com", "Tasks-Pass-2024")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
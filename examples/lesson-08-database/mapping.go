@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tasks and categories exist in three shapes:
+//
+//   - taskRow / categoryRow: what the queries read and write, in column
+//     types (nullable timestamps, Postgres arrays, categories aggregated
+//     into parallel arrays)
+//   - Task / Category: the domain entities the handlers, services and
+//     policies work with
+//   - TaskResponse / CategoryResponse: the JSON contract of the API
+//
+// The functions in this file are the only places that know about two of
+// them at once, so a column can be renamed or retyped without touching the
+// API, and a response field can be added, renamed or versioned without a
+// migration.
+
+// taskRow is a row of the task queries: the tasks columns, plus the task's
+// categories aggregated into id/name/color/parent arrays when the query joins
+// them. A top-level category's parent is "", as the arrays can't hold NULL.
+type taskRow struct {
+	ID          TaskID
+	Title       string
+	Description string
+	Completed   bool
+	Priority    string
+	DueDate     sql.NullTime
+	Location    string
+	Tags        pq.StringArray
+	UserID      UserID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	CategoryIDs       pq.StringArray
+	CategoryNames     pq.StringArray
+	CategoryColors    pq.StringArray
+	CategoryParentIDs pq.StringArray
+}
+
+// columns are the scan destinations for the tasks columns, in the order the
+// queries select them.
+func (row *taskRow) columns() []interface{} {
+	return []interface{}{
+		&row.ID, &row.Title, &row.Description, &row.Completed, &row.Priority,
+		&row.DueDate, &row.Location, &row.Tags, &row.UserID, &row.CreatedAt, &row.UpdatedAt,
+	}
+}
+
+// columnsWithCategories adds the aggregated category arrays.
+func (row *taskRow) columnsWithCategories() []interface{} {
+	return append(row.columns(), &row.CategoryIDs, &row.CategoryNames, &row.CategoryColors, &row.CategoryParentIDs)
+}
+
+func taskFromRow(row *taskRow) *Task {
+	task := &Task{
+		ID:          row.ID,
+		Title:       row.Title,
+		Description: row.Description,
+		Completed:   row.Completed,
+		Priority:    row.Priority,
+		Location:    row.Location,
+		Tags:        []string(row.Tags),
+		UserID:      row.UserID,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+	}
+	if row.DueDate.Valid {
+		dueDate := row.DueDate.Time
+		task.DueDate = &dueDate
+	}
+
+	for i, id := range row.CategoryIDs {
+		if id == "" || i >= len(row.CategoryNames) {
+			continue
+		}
+		color := ""
+		if i < len(row.CategoryColors) {
+			color = row.CategoryColors[i]
+		}
+		var parentID *CategoryID
+		if i < len(row.CategoryParentIDs) && row.CategoryParentIDs[i] != "" {
+			parent := CategoryID(row.CategoryParentIDs[i])
+			parentID = &parent
+		}
+		task.Categories = append(task.Categories, Category{
+			ID:       CategoryID(id),
+			Name:     row.CategoryNames[i],
+			Color:    color,
+			ParentID: parentID,
+		})
+	}
+	return task
+}
+
+// taskToRow maps the stored fields of a task; categories live in their own
+// tables and the timestamps are set by the database.
+func taskToRow(task *Task) taskRow {
+	row := taskRow{
+		ID:          task.ID,
+		Title:       task.Title,
+		Description: task.Description,
+		Completed:   task.Completed,
+		Priority:    task.Priority,
+		Location:    task.Location,
+		Tags:        pq.StringArray(task.Tags),
+		UserID:      task.UserID,
+	}
+	if task.DueDate != nil {
+		row.DueDate = sql.NullTime{Time: *task.DueDate, Valid: true}
+	}
+	// tags is NOT NULL
+	if row.Tags == nil {
+		row.Tags = pq.StringArray{}
+	}
+	return row
+}
+
+// categoryRow is a row of the categories table.
+type categoryRow struct {
+	ID        CategoryID
+	Name      string
+	Color     string
+	ParentID  *CategoryID
+	UserID    UserID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (row *categoryRow) columns() []interface{} {
+	return []interface{}{&row.ID, &row.Name, &row.Color, &row.ParentID, &row.UserID, &row.CreatedAt, &row.UpdatedAt}
+}
+
+func categoryFromRow(row *categoryRow) *Category {
+	return &Category{
+		ID:        row.ID,
+		Name:      row.Name,
+		Color:     row.Color,
+		ParentID:  row.ParentID,
+		UserID:    row.UserID,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+func newTaskResponse(task *Task) TaskResponse {
+	response := TaskResponse{
+		ID:          task.ID,
+		Title:       task.Title,
+		Description: task.Description,
+		Completed:   task.Completed,
+		Priority:    task.Priority,
+		DueDate:     task.DueDate,
+		Location:    task.Location,
+		UserID:      task.UserID,
+		Tags:        task.Tags,
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+	}
+	for i := range task.Categories {
+		response.Categories = append(response.Categories, newCategoryResponse(&task.Categories[i]))
+	}
+	return response
+}
+
+func newTaskResponses(tasks []*Task) []TaskResponse {
+	responses := make([]TaskResponse, len(tasks))
+	for i, task := range tasks {
+		responses[i] = newTaskResponse(task)
+	}
+	return responses
+}
+
+// newTaskResponseV2 maps a task to API v2, where the completed flag became a
+// status.
+func newTaskResponseV2(response TaskResponse) TaskResponseV2 {
+	status := TaskStatusOpen
+	if response.Completed {
+		status = TaskStatusCompleted
+	}
+	return TaskResponseV2{
+		ID:          response.ID,
+		Title:       response.Title,
+		Description: response.Description,
+		Status:      status,
+		Priority:    response.Priority,
+		DueDate:     response.DueDate,
+		Location:    response.Location,
+		UserID:      response.UserID,
+		Categories:  response.Categories,
+		Tags:        response.Tags,
+		CreatedAt:   response.CreatedAt,
+		UpdatedAt:   response.UpdatedAt,
+		Enrichment:  response.Enrichment,
+	}
+}
+
+func newTaskResponsesV2(responses []TaskResponse) []TaskResponseV2 {
+	v2 := make([]TaskResponseV2, len(responses))
+	for i, response := range responses {
+		v2[i] = newTaskResponseV2(response)
+	}
+	return v2
+}
+
+func newCategoryResponse(category *Category) CategoryResponse {
+	return CategoryResponse{
+		ID:        category.ID,
+		Name:      category.Name,
+		Color:     category.Color,
+		ParentID:  category.ParentID,
+		UserID:    category.UserID,
+		CreatedAt: category.CreatedAt,
+		UpdatedAt: category.UpdatedAt,
+	}
+}
+
+func newCategoryResponses(categories []*Category) []CategoryResponse {
+	responses := make([]CategoryResponse, len(categories))
+	for i, category := range categories {
+		responses[i] = newCategoryResponse(category)
+	}
+	return responses
+}
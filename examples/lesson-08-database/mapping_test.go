@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskFromRow(t *testing.T) {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	row := &taskRow{
+		ID:                "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f",
+		Title:             "Write report",
+		Priority:          "high",
+		DueDate:           sql.NullTime{Time: due, Valid: true},
+		Tags:              pq.StringArray{"work"},
+		CategoryIDs:       pq.StringArray{"c1", "c2", ""},
+		CategoryNames:     pq.StringArray{"Work", "Urgent"},
+		CategoryColors:    pq.StringArray{"#3B82F6"},
+		CategoryParentIDs: pq.StringArray{"", "c1"},
+	}
+
+	task := taskFromRow(row)
+	parent := CategoryID("c1")
+	assert.Equal(t, row.ID, task.ID)
+	require.NotNil(t, task.DueDate)
+	assert.True(t, due.Equal(*task.DueDate))
+	assert.Equal(t, []string{"work"}, task.Tags)
+	assert.Equal(t, []Category{
+		{ID: "c1", Name: "Work", Color: "#3B82F6"},
+		{ID: "c2", Name: "Urgent", ParentID: &parent},
+	}, task.Categories)
+
+	row.DueDate = sql.NullTime{}
+	assert.Nil(t, taskFromRow(row).DueDate)
+}
+
+func TestTaskToRow(t *testing.T) {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	task := &Task{ID: "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f", Title: "Write report", DueDate: &due}
+
+	row := taskToRow(task)
+	assert.Equal(t, sql.NullTime{Time: due, Valid: true}, row.DueDate)
+	assert.NotNil(t, row.Tags, "tags is NOT NULL")
+	assert.Empty(t, row.Tags)
+
+	// Round trip through the row keeps the stored fields
+	task.Tags = []string{"work"}
+	row = taskToRow(task)
+	assert.Equal(t, task, taskFromRow(&row))
+}
+
+// TestTaskResponseContract pins the JSON field names of a task, which are
+// independent of the domain struct and the columns.
+func TestTaskResponseContract(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	task := &Task{
+		ID:         "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f",
+		Title:      "Write report",
+		Priority:   "medium",
+		UserID:     "0c6d5a1e-8f2b-4e3a-9b7c-1d2e3f4a5b6c",
+		Categories: []Category{{ID: "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d", Name: "Work", Color: "#3B82F6"}},
+		Tags:       []string{"work"},
+		CreatedAt:  created,
+		UpdatedAt:  created,
+	}
+
+	body, err := json.Marshal(newTaskResponse(task))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": "6f1c2b1e-3d4a-4c5b-9e8f-0a1b2c3d4e5f",
+		"title": "Write report",
+		"description": "",
+		"completed": false,
+		"priority": "medium",
+		"dueDate": null,
+		"userId": "0c6d5a1e-8f2b-4e3a-9b7c-1d2e3f4a5b6c",
+		"categories": [{
+			"id": "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d",
+			"name": "Work",
+			"color": "#3B82F6",
+			"parentId": null,
+			"userId": "",
+			"createdAt": "0001-01-01T00:00:00Z",
+			"updatedAt": "0001-01-01T00:00:00Z"
+		}],
+		"tags": ["work"],
+		"createdAt": "2026-01-02T03:04:05Z",
+		"updatedAt": "2026-01-02T03:04:05Z"
+	}`, string(body))
+
+	assert.Len(t, newTaskResponses([]*Task{task, task}), 2)
+	assert.Empty(t, newCategoryResponses(nil))
+}
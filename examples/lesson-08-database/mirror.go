@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"lesson-08-database/pkg/httpclient"
+)
+
+// mirroredRequestHeader marks shadow traffic, so the shadow backend can
+// suppress side effects such as emails or webhooks.
+const mirroredRequestHeader = "X-Mirrored-Request"
+
+var (
+	mirroredRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirrored_requests_total",
+			Help: "Total number of requests mirrored to the shadow backend, by how its status compared to production",
+		},
+		[]string{"method", "endpoint", "result"},
+	)
+
+	mirrorSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_skipped_total",
+			Help: "Total number of sampled requests that were not mirrored, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	mirrorRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "mirror_request_duration_seconds",
+			Help: "Duration of mirrored requests on the production and shadow backends",
+		},
+		[]string{"endpoint", "backend"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(mirroredRequestsTotal)
+	prometheus.MustRegister(mirrorSkippedTotal)
+	prometheus.MustRegister(mirrorRequestDuration)
+}
+
+type MirrorConfig struct {
+	// URL is the shadow backend's base URL; empty disables mirroring
+	URL string
+	// Percent of requests to mirror, 0-100
+	Percent float64
+	// Requests with larger bodies are not mirrored
+	MaxBodyBytes int64
+	Timeout      time.Duration
+	// MaxInFlight bounds concurrent shadow requests; requests beyond it are
+	// not mirrored rather than queued
+	MaxInFlight int
+	// Unsafe mirrors POST, PUT, PATCH and DELETE too. Only enable it when the
+	// shadow has its own database, or writes happen twice.
+	Unsafe bool
+}
+
+// TrafficMirror copies a sample of production requests to a shadow backend,
+// e.g. the next API version, and compares the outcomes. Shadow requests are
+// sent in the background and their responses are discarded, so the shadow
+// can never slow down or change what clients get. A nil mirror is disabled.
+type TrafficMirror struct {
+	target *url.URL
+	config MirrorConfig
+	client *http.Client
+	slots  chan struct{}
+	sample func() float64
+}
+
+func NewTrafficMirror(config MirrorConfig) (*TrafficMirror, error) {
+	target, err := url.Parse(config.URL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid mirror URL %q", config.URL)
+	}
+	if config.Percent < 0 || config.Percent > 100 {
+		return nil, fmt.Errorf("mirror percentage must be between 0 and 100")
+	}
+
+	// No retries: a retried shadow request would skew the latency comparison
+	clientConfig := httpclient.DefaultConfig()
+	clientConfig.Timeout = config.Timeout
+	clientConfig.MaxRetries = 0
+	clientConfig.Observer = observeOutboundRequest
+
+	return &TrafficMirror{
+		target: target,
+		config: config,
+		client: httpclient.New(clientConfig),
+		slots:  make(chan struct{}, config.MaxInFlight),
+		sample: func() float64 { return mathrand.Float64() * 100 },
+	}, nil
+}
+
+// primaryResult is what production answered, for comparison with the shadow
+type primaryResult struct {
+	status   int
+	duration time.Duration
+}
+
+// Middleware mirrors sampled requests. The request body is buffered (up to
+// MaxBodyBytes) so both backends can read it.
+func (m *TrafficMirror) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.mirrors(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := m.bufferBody(r)
+		if !ok {
+			mirrorSkippedTotal.WithLabelValues("body_too_large").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			mirrorSkippedTotal.WithLabelValues("max_in_flight").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		shadow, cancel, err := m.shadowRequest(r, body)
+		if err != nil {
+			<-m.slots
+			slog.ErrorContext(r.Context(), "failed to build mirrored request", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		endpoint := routeTemplate(r)
+		primary := make(chan primaryResult, 1)
+		go m.send(shadow, cancel, r.Method, endpoint, primary)
+
+		start := time.Now()
+		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(ww, r)
+		primary <- primaryResult{status: ww.statusCode, duration: time.Since(start)}
+	})
+}
+
+func (m *TrafficMirror) mirrors(r *http.Request) bool {
+	if r.Header.Get(mirroredRequestHeader) != "" {
+		// Never mirror mirrored traffic, e.g. when the shadow mirrors too
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !m.config.Unsafe {
+			return false
+		}
+	}
+	return m.sample() < m.config.Percent
+}
+
+// bufferBody reads the body for replay. Bodies over the limit are put back
+// together unread, and the request is not mirrored.
+func (m *TrafficMirror) bufferBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, m.config.MaxBodyBytes+1))
+	if err != nil || int64(len(body)) > m.config.MaxBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+func (m *TrafficMirror) shadowRequest(r *http.Request, body []byte) (*http.Request, context.CancelFunc, error) {
+	target := *m.target
+	target.Path = strings.TrimRight(m.target.Path, "/") + r.URL.Path
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+
+	// The shadow request outlives the client's, which may end first, but
+	// keeps its request ID
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), m.config.Timeout)
+	shadow, err := http.NewRequestWithContext(ctx, r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	shadow.Header = r.Header.Clone()
+	for _, name := range []string{"Connection", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade"} {
+		shadow.Header.Del(name)
+	}
+	shadow.Header.Set(mirroredRequestHeader, "true")
+	shadow.Header.Set("X-Forwarded-For", clientIP(r))
+	return shadow, cancel, nil
+}
+
+// send runs the shadow request, then compares it with production once the
+// primary handler has finished.
+func (m *TrafficMirror) send(shadow *http.Request, cancel context.CancelFunc, method, endpoint string, primary <-chan primaryResult) {
+	defer func() { <-m.slots }()
+	defer cancel()
+
+	start := time.Now()
+	resp, err := m.client.Do(shadow)
+	shadowDuration := time.Since(start)
+	if err == nil {
+		// Responses are ignored, but read so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+	}
+
+	result := <-primary
+	mirrorRequestDuration.WithLabelValues(endpoint, "primary").Observe(result.duration.Seconds())
+
+	switch {
+	case err != nil:
+		mirroredRequestsTotal.WithLabelValues(method, endpoint, "error").Inc()
+		return
+	case resp.StatusCode == result.status:
+		mirroredRequestsTotal.WithLabelValues(method, endpoint, "match").Inc()
+	default:
+		mirroredRequestsTotal.WithLabelValues(method, endpoint, "status_mismatch").Inc()
+		slog.WarnContext(shadow.Context(), "mirror status mismatch", "method", method, "path", shadow.URL.Path,
+			"primary_status", result.status, "shadow_status", resp.StatusCode)
+	}
+	mirrorRequestDuration.WithLabelValues(endpoint, "shadow").Observe(shadowDuration.Seconds())
+}
+
+// routeTemplate labels metrics with the matched route ("/api/tasks/{id}")
+// rather than the path, so IDs don't create a series per resource.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type shadowHit struct {
+	method, path, body string
+	header             http.Header
+}
+
+// newTestMirror mirrors every request to a shadow server answering status.
+func newTestMirror(t *testing.T, status int, config MirrorConfig) (*TrafficMirror, chan shadowHit) {
+	hits := make(chan shadowHit, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		hits <- shadowHit{method: r.Method, path: r.URL.RequestURI(), body: string(body), header: r.Header}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(shadow.Close)
+
+	config.URL = shadow.URL + "/v2/"
+	config.Percent = 100
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = 1024
+	}
+	config.Timeout = time.Second
+	if config.MaxInFlight == 0 {
+		config.MaxInFlight = 10
+	}
+	mirror, err := NewTrafficMirror(config)
+	require.NoError(t, err)
+	return mirror, hits
+}
+
+func mirroredRouter(mirror *TrafficMirror, status int, bodies chan<- string) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(mirror.Middleware)
+	router.HandleFunc("/api/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bodies != nil {
+			bodies <- string(body)
+		}
+		w.WriteHeader(status)
+	})
+	return router
+}
+
+func receiveHit(t *testing.T, hits <-chan shadowHit) shadowHit {
+	select {
+	case hit := <-hits:
+		return hit
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not mirrored")
+		return shadowHit{}
+	}
+}
+
+func TestNewTrafficMirrorValidatesConfig(t *testing.T) {
+	_, err := NewTrafficMirror(MirrorConfig{URL: "not a url", Percent: 10, MaxInFlight: 1})
+	assert.Error(t, err)
+	_, err = NewTrafficMirror(MirrorConfig{URL: "http://shadow:8080", Percent: 150, MaxInFlight: 1})
+	assert.Error(t, err)
+
+	var disabled *TrafficMirror
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	assert.NotNil(t, disabled.Middleware(next))
+}
+
+func TestTrafficMirrorComparesStatus(t *testing.T) {
+	endpoint := "/api/tasks/{id}"
+	matched := mirroredRequestsTotal.WithLabelValues("GET", endpoint, "match")
+	mismatched := mirroredRequestsTotal.WithLabelValues("GET", endpoint, "status_mismatch")
+
+	mirror, hits := newTestMirror(t, http.StatusOK, MirrorConfig{})
+	matchedBefore, mismatchedBefore := testutil.ToFloat64(matched), testutil.ToFloat64(mismatched)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/42?include=tags", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	mirroredRouter(mirror, http.StatusOK, nil).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	hit := receiveHit(t, hits)
+	assert.Equal(t, "/v2/api/tasks/42?include=tags", hit.path)
+	assert.Equal(t, "Bearer token", hit.header.Get("Authorization"))
+	assert.Equal(t, "true", hit.header.Get(mirroredRequestHeader))
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(matched) == matchedBefore+1 }, time.Second, 10*time.Millisecond)
+
+	// The shadow's answer never reaches the client
+	mirror, hits = newTestMirror(t, http.StatusInternalServerError, MirrorConfig{})
+	w = httptest.NewRecorder()
+	mirroredRouter(mirror, http.StatusOK, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks/42", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	receiveHit(t, hits)
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(mismatched) == mismatchedBefore+1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTrafficMirrorReplaysBody(t *testing.T) {
+	mirror, hits := newTestMirror(t, http.StatusNoContent, MirrorConfig{Unsafe: true})
+	bodies := make(chan string, 1)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/tasks/42", strings.NewReader(`{"title":"Mirrored"}`))
+	mirroredRouter(mirror, http.StatusNoContent, bodies).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, `{"title":"Mirrored"}`, <-bodies)
+	hit := receiveHit(t, hits)
+	assert.Equal(t, http.MethodPut, hit.method)
+	assert.Equal(t, `{"title":"Mirrored"}`, hit.body)
+}
+
+func TestTrafficMirrorSkips(t *testing.T) {
+	t.Run("unsafe methods by default", func(t *testing.T) {
+		mirror, hits := newTestMirror(t, http.StatusOK, MirrorConfig{})
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/42", strings.NewReader("{}"))
+		mirroredRouter(mirror, http.StatusOK, nil).ServeHTTP(httptest.NewRecorder(), req)
+		assert.Empty(t, hits)
+	})
+
+	t.Run("unsampled requests", func(t *testing.T) {
+		mirror, hits := newTestMirror(t, http.StatusOK, MirrorConfig{})
+		mirror.sample = func() float64 { return 100 }
+		mirroredRouter(mirror, http.StatusOK, nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tasks/42", nil))
+		assert.Empty(t, hits)
+	})
+
+	t.Run("mirrored requests", func(t *testing.T) {
+		mirror, hits := newTestMirror(t, http.StatusOK, MirrorConfig{})
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks/42", nil)
+		req.Header.Set(mirroredRequestHeader, "true")
+		mirroredRouter(mirror, http.StatusOK, nil).ServeHTTP(httptest.NewRecorder(), req)
+		assert.Empty(t, hits)
+	})
+
+	t.Run("bodies over the limit", func(t *testing.T) {
+		skipped := mirrorSkippedTotal.WithLabelValues("body_too_large")
+		before := testutil.ToFloat64(skipped)
+		mirror, hits := newTestMirror(t, http.StatusOK, MirrorConfig{Unsafe: true, MaxBodyBytes: 4})
+		bodies := make(chan string, 1)
+
+		req := httptest.NewRequest(http.MethodPut, "/api/tasks/42", strings.NewReader("too large"))
+		mirroredRouter(mirror, http.StatusOK, bodies).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "too large", <-bodies, "production still gets the whole body")
+		assert.Empty(t, hits)
+		assert.Equal(t, before+1, testutil.ToFloat64(skipped))
+	})
+
+	t.Run("too many in flight", func(t *testing.T) {
+		skipped := mirrorSkippedTotal.WithLabelValues("max_in_flight")
+		before := testutil.ToFloat64(skipped)
+		mirror, hits := newTestMirror(t, http.StatusOK, MirrorConfig{MaxInFlight: 1})
+		mirror.slots <- struct{}{}
+
+		w := httptest.NewRecorder()
+		mirroredRouter(mirror, http.StatusOK, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks/42", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, hits)
+		assert.Equal(t, before+1, testutil.ToFloat64(skipped))
+	})
+}
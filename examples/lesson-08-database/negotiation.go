@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"respond"
+)
+
+// Content negotiation. Task and category endpoints answer in the format the
+// Accept header asks for and read bodies in the format of their
+// Content-Type:
+//
+//	Accept: application/xml            Content-Type: application/xml
+//	Accept: application/msgpack        Content-Type: application/msgpack
+//
+// Both formats are spellings of the JSON representation (see package
+// respond), so fields are named and valued as in JSON, and ?fields= works
+// the same. Envelopes and X-Field-Case rewrite JSON bodies and don't apply.
+
+// respond is respondWithJSON in the format the Accept header asks for.
+// Errors and protocol responses such as OAuth tokens stay JSON, the format
+// every client can read them in.
+func (h *Handler) respond(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
+	fallback := ErrorResponse{
+		Error:     http.StatusText(http.StatusInternalServerError),
+		Message:   "Failed to encode response",
+		RequestID: requestID(w),
+	}
+	if err := respond.Write(w, r, code, payload, fallback); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"respond"
+)
+
+// TestTaskContentNegotiation creates, reads and updates a task in XML and
+// MessagePack through the router main serves.
+func TestTaskContentNegotiation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "formats@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(method, path, contentType, accept string, body []byte, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", accept)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("POST", "/api/tasks", respond.MediaTypeXML, respond.MediaTypeMsgpack, []byte(`<task>
+		<title>Ship &lt;formats&gt;</title>
+		<priority>high</priority>
+		<tags><item>api</item><item>xml</item></tags>
+		<dueDate>2026-12-01T09:00:00Z</dueDate>
+	</task>`))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, respond.MediaTypeMsgpack, w.Header().Get("Content-Type"))
+	var created TaskResponse
+	require.NoError(t, respond.UnmarshalMsgpack(w.Body.Bytes(), &created))
+	assert.Equal(t, "Ship <formats>", created.Title)
+	assert.Equal(t, "high", created.Priority)
+	assert.Equal(t, []string{"api", "xml"}, created.Tags)
+	require.NotNil(t, created.DueDate)
+
+	w = serve("GET", "/api/tasks/"+string(created.ID), "", "application/xml", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, respond.MediaTypeXML, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Values("Vary"), "Accept")
+	assert.Contains(t, w.Body.String(), "<title>Ship &lt;formats&gt;</title>")
+	assert.Contains(t, w.Body.String(), "<completed>false</completed>")
+	etag := w.Header().Get("ETag")
+
+	// The v2 shape and ?fields= are the same in every format
+	w = serve("GET", "/api/v2/tasks?fields=id,status", "", "application/xml", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<status>open</status>")
+	assert.NotContains(t, w.Body.String(), "<title>")
+
+	update, err := respond.MarshalMsgpack(map[string]interface{}{"completed": true, "description": nil})
+	require.NoError(t, err)
+	w = serve("PUT", "/api/tasks/"+string(created.ID), respond.MediaTypeMsgpack, "", update, "If-Match", etag)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var updated TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.True(t, updated.Completed)
+
+	// Errors are JSON whatever the client accepts
+	w = serve("POST", "/api/tasks", respond.MediaTypeXML, respond.MediaTypeXML, []byte(`<task><title>unclosed</task>`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// OAuth2 scopes
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+)
+
+// ScopeClientsManage is never granted to machine clients, so only
+// interactive user tokens can register new OAuth clients.
+const ScopeClientsManage = "clients:manage"
+
+var supportedScopes = []string{ScopeTasksRead, ScopeTasksWrite}
+
+const clientTokenTTL = time.Hour
+
+// OAuthClient is a machine client allowed to obtain tokens with the
+// client-credentials grant. Tokens act on behalf of the owning user but are
+// limited to the scopes granted to the client.
+type OAuthClient struct {
+	ID         string     `json:"id"`
+	ClientID   string     `json:"clientId"`
+	SecretHash string     `json:"-"`
+	Name       string     `json:"name"`
+	UserID     UserID     `json:"userId"`
+	Scopes     []string   `json:"scopes"`
+	IsActive   bool       `json:"isActive"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type CreateOAuthClientRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type CreateOAuthClientResponse struct {
+	Client       OAuthClient `json:"client"`
+	ClientSecret string      `json:"clientSecret"`
+}
+
+// TokenResponse follows RFC 6749 section 5.1
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// OAuthErrorResponse follows RFC 6749 section 5.2
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *OAuthClient) error
+	GetByClientID(ctx context.Context, clientID string) (*OAuthClient, error)
+	TouchLastUsed(ctx context.Context, id string) error
+}
+
+type oauthClientRepository struct {
+	db *sql.DB
+}
+
+func NewOAuthClientRepository(db *sql.DB) OAuthClientRepository {
+	return &oauthClientRepository{db: db}
+}
+
+func (r *oauthClientRepository) Create(ctx context.Context, client *OAuthClient) error {
+	query := `
+		INSERT INTO oauth_clients (id, client_id, secret_hash, name, user_id, scopes, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at`
+
+	return r.db.QueryRowContext(ctx, query,
+		client.ID, client.ClientID, client.SecretHash, client.Name,
+		client.UserID, pq.Array(client.Scopes), client.IsActive,
+	).Scan(&client.CreatedAt)
+}
+
+func (r *oauthClientRepository) GetByClientID(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client := &OAuthClient{}
+	query := `
+		SELECT id, client_id, secret_hash, name, user_id, scopes, is_active, last_used_at, created_at
+		FROM oauth_clients WHERE client_id = $1`
+
+	var scopes pq.StringArray
+	err := r.db.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ID, &client.ClientID, &client.SecretHash, &client.Name, &client.UserID,
+		&scopes, &client.IsActive, &client.LastUsedAt, &client.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("oauth client not found")
+		}
+		return nil, fmt.Errorf("failed to get oauth client: %w", err)
+	}
+
+	client.Scopes = scopes
+	return client, nil
+}
+
+func (r *oauthClientRepository) TouchLastUsed(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE oauth_clients SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	return err
+}
+
+// GenerateClientToken issues a short-lived access token for a machine client
+// carrying the granted scopes as a space-delimited "scope" claim.
+func (j *JWTService) GenerateClientToken(client *OAuthClient, scopes []string) (string, error) {
+	claims := JWTClaims{
+		UserID:           client.UserID.String(),
+		Role:             "client",
+		ClientID:         client.ClientID,
+		Scope:            strings.Join(scopes, " "),
+		RegisteredClaims: j.registeredClaims(clientTokenTTL),
+	}
+	claims.Subject = client.ClientID
+
+	return j.signToken(claims)
+}
+
+// Scopes returns the scopes carried by the token. User tokens issued by the
+// login flow carry no scope claim and are not scope-restricted.
+func (c *JWTClaims) Scopes() []string {
+	if c.Scope == "" {
+		return nil
+	}
+	return strings.Fields(c.Scope)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSecret(numBytes int) (string, error) {
+	buf := make([]byte, numBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func isSupportedScope(scope string) bool {
+	for _, s := range supportedScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) respondWithOAuthError(w http.ResponseWriter, code int, errorCode, description string) {
+	noStorePolicy.Apply(w.Header())
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	h.respondWithJSON(w, code, OAuthErrorResponse{
+		Error:            errorCode,
+		ErrorDescription: description,
+	})
+}
+
+// OAuth Handlers
+func (h *Handler) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req CreateOAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Name == "" {
+		h.respondWithError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{ScopeTasksRead}
+	}
+	for _, scope := range req.Scopes {
+		if !isSupportedScope(scope) {
+			h.respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Unsupported scope %q, allowed: %s", scope, strings.Join(supportedScopes, ", ")))
+			return
+		}
+	}
+
+	secret, err := generateSecret(32)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate client secret")
+		return
+	}
+
+	client := &OAuthClient{
+		ID:         uuid.New().String(),
+		ClientID:   "client_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:16],
+		SecretHash: hashToken(secret),
+		Name:       req.Name,
+		UserID:     userID,
+		Scopes:     req.Scopes,
+		IsActive:   true,
+	}
+
+	if err := h.oauthClientRepo.Create(r.Context(), client); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create OAuth client")
+		return
+	}
+
+	// The secret is only ever returned once
+	h.respondWithJSON(w, http.StatusCreated, CreateOAuthClientResponse{
+		Client:       *client,
+		ClientSecret: secret,
+	})
+}
+
+// IssueToken implements the OAuth2 token endpoint and dispatches on the
+// grant type.
+func (h *Handler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		h.issueClientCredentialsToken(w, r)
+	case deviceCodeGrantType:
+		h.issueDeviceToken(w, r)
+	default:
+		h.respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type",
+			"Supported grants: client_credentials, "+deviceCodeGrantType)
+	}
+}
+
+// issueClientCredentialsToken handles the client-credentials grant. Clients
+// authenticate with HTTP Basic or client_id/client_secret form fields.
+func (h *Handler) issueClientCredentialsToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	if clientID == "" || clientSecret == "" {
+		h.respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication required")
+		return
+	}
+
+	client, err := h.oauthClientRepo.GetByClientID(r.Context(), clientID)
+	if err != nil || !client.IsActive ||
+		subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(clientSecret))) != 1 {
+		h.respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	// Grant the requested scopes, or everything the client is allowed when
+	// no scope parameter is sent
+	granted := client.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !containsScope(client.Scopes, scope) {
+				h.respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope",
+					fmt.Sprintf("Scope %q is not granted to this client", scope))
+				return
+			}
+		}
+		granted = requested
+	}
+
+	token, err := h.jwtService.GenerateClientToken(client, granted)
+	if err != nil {
+		h.respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	h.oauthClientRepo.TouchLastUsed(r.Context(), client.ID)
+
+	noStorePolicy.Apply(w.Header())
+	h.respondWithJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(clientTokenTTL.Seconds()),
+		Scope:       strings.Join(granted, " "),
+	})
+}
+
+// requireScope rejects scope-restricted tokens that lack the given scope.
+// Tokens without any scope claim (interactive user logins) pass through.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, restricted := r.Context().Value("token_scopes").([]string)
+			if restricted && !containsScope(scopes, scope) {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				http.Error(w, "Insufficient scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func withScope(scope string, handler http.HandlerFunc) http.Handler {
+	return &scopedHandler{Handler: requireScope(scope)(handler), scope: scope}
+}
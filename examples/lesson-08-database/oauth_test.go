@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) createTestOAuthClient(t *testing.T, token string, scopes []string) CreateOAuthClientResponse {
+	body, _ := json.Marshal(CreateOAuthClientRequest{Name: "ci-bot", Scopes: scopes})
+	req := httptest.NewRequest(http.MethodPost, "/api/oauth/clients", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := env.serveWithAuth(env.handler.CreateOAuthClient, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created CreateOAuthClientResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created
+}
+
+func (env *testEnv) requestClientToken(clientID, secret, scope string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+
+	w := httptest.NewRecorder()
+	env.handler.IssueToken(w, req)
+	return w
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	userToken := env.createTestUserAndGetToken(t, "oauth@example.com")
+	created := env.createTestOAuthClient(t, userToken, []string{ScopeTasksRead})
+	assert.NotEmpty(t, created.ClientSecret)
+	assert.Equal(t, []string{ScopeTasksRead}, created.Client.Scopes)
+
+	// Valid credentials
+	w := env.requestClientToken(created.Client.ClientID, created.ClientSecret, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var tokenResp TokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokenResp))
+	assert.Equal(t, "Bearer", tokenResp.TokenType)
+	assert.Equal(t, ScopeTasksRead, tokenResp.Scope)
+
+	claims, err := NewJWTService(testConfig.JWTSecret).ValidateToken(tokenResp.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeTasksRead}, claims.Scopes())
+	assert.Equal(t, created.Client.ClientID, claims.ClientID)
+
+	// Wrong secret
+	w = env.requestClientToken(created.Client.ClientID, "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_client")
+
+	// Scope the client was never granted
+	w = env.requestClientToken(created.Client.ClientID, created.ClientSecret, ScopeTasksWrite)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_scope")
+}
+
+func TestClientCredentialsGrant_UnsupportedGrantType(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	form := url.Values{"grant_type": {"password"}, "username": {"a"}, "password": {"b"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	env.handler.IssueToken(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported_grant_type")
+}
+
+func TestRequireScope(t *testing.T) {
+	jwtService := NewJWTService(testConfig.JWTSecret)
+	client := &OAuthClient{ClientID: "client_test", UserID: "user-1"}
+
+	readToken, err := jwtService.GenerateClientToken(client, []string{ScopeTasksRead})
+	require.NoError(t, err)
+	userToken, err := jwtService.GenerateToken(&User{ID: "user-1", Email: "u@example.com", Role: "user"})
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		token    string
+		scope    string
+		expected int
+	}{
+		{"client token with scope", readToken, ScopeTasksRead, http.StatusOK},
+		{"client token missing scope", readToken, ScopeTasksWrite, http.StatusForbidden},
+		{"client token cannot manage clients", readToken, ScopeClientsManage, http.StatusForbidden},
+		{"user token is unrestricted", userToken, ScopeTasksWrite, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+
+			authMiddleware(jwtService, nil)(requireScope(tt.scope)(ok)).ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+			if tt.expected == http.StatusForbidden {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), "insufficient_scope")
+			}
+		})
+	}
+}
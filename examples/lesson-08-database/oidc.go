@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// ExternalIdentity is a user as asserted by an external identity provider.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// AuthProvider is an external login provider such as Keycloak or Google.
+type AuthProvider interface {
+	Name() string
+	// AuthCodeURL is where the browser is sent to log in
+	AuthCodeURL(state, nonce string) (string, error)
+	// Exchange redeems the authorization code and returns the verified identity
+	Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error)
+}
+
+type OIDCProviderConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCProvider implements the OpenID Connect authorization code flow using
+// the issuer's discovery document.
+type OIDCProvider struct {
+	config OIDCProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// JSONWebKey is an RSA public key in JWK format.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func NewOIDCProvider(config OIDCProviderConfig) *OIDCProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	config.IssuerURL = strings.TrimSuffix(config.IssuerURL, "/")
+	return &OIDCProvider{
+		config: config,
+		client: newHTTPClient(10 * time.Second),
+	}
+}
+
+// NewGoogleProvider configures Google as an OIDC provider.
+func NewGoogleProvider(clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return NewOIDCProvider(OIDCProviderConfig{
+		Name:         "google",
+		IssuerURL:    "https://accounts.google.com",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	})
+}
+
+// newAuthProviders builds the providers enabled in config, keyed by name. A
+// generic issuer covers Keycloak and other OIDC servers.
+func newAuthProviders(config Config) map[string]AuthProvider {
+	providers := make(map[string]AuthProvider)
+	if config.OIDCIssuerURL != "" && config.OIDCClientID != "" {
+		providers[config.OIDCProviderName] = NewOIDCProvider(OIDCProviderConfig{
+			Name:         config.OIDCProviderName,
+			IssuerURL:    config.OIDCIssuerURL,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  config.OIDCRedirectURL,
+		})
+	}
+	if config.GoogleClientID != "" {
+		providers["google"] = NewGoogleProvider(config.GoogleClientID, config.GoogleClientSecret, config.OIDCRedirectURL)
+	}
+	return providers
+}
+
+func (p *OIDCProvider) Name() string { return p.config.Name }
+
+func (p *OIDCProvider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.config.IssuerURL+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if discovery.Issuer != p.config.IssuerURL {
+		return nil, fmt.Errorf("OIDC issuer mismatch: %s", discovery.Issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) (string, error) {
+	discovery, err := p.discover(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {p.config.RedirectURL},
+		"scope":         {strings.Join(p.config.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	return discovery.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.config.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token exchange failed: %s", tokens.Error)
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+type idTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawToken, nonce string) (*ExternalIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if !hmac.Equal([]byte(claims.Nonce), []byte(nonce)) {
+		return nil, fmt.Errorf("invalid ID token: nonce mismatch")
+	}
+
+	return &ExternalIdentity{
+		Provider:      p.config.Name,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: claims.EmailVerified,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+// publicKey returns the signing key by kid, refetching the JWKS once when
+// the provider has rotated keys.
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set JSONWebKeySet
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys, err := set.RSAPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// RSAPublicKeys decodes the RSA keys of the set, indexed by kid.
+func (s JSONWebKeySet) RSAPublicKeys() (map[string]*rsa.PublicKey, error) {
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range s.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK modulus for %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK exponent for %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// NewJSONWebKey encodes an RSA public key as a JWK.
+func NewJSONWebKey(kid string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// UserIdentityRepository links external identities to local users.
+type UserIdentityRepository interface {
+	GetUserID(ctx context.Context, provider, subject string) (UserID, error)
+	Link(ctx context.Context, userID UserID, identity *ExternalIdentity) error
+}
+
+type userIdentityRepository struct {
+	db *sql.DB
+}
+
+func NewUserIdentityRepository(db *sql.DB) UserIdentityRepository {
+	return &userIdentityRepository{db: db}
+}
+
+func (r *userIdentityRepository) GetUserID(ctx context.Context, provider, subject string) (UserID, error) {
+	var userID UserID
+	err := r.db.QueryRowContext(ctx,
+		`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, subject,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("identity not found")
+		}
+		return "", fmt.Errorf("failed to get identity: %w", err)
+	}
+	return userID, nil
+}
+
+func (r *userIdentityRepository) Link(ctx context.Context, userID UserID, identity *ExternalIdentity) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`,
+		userID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("identity already linked")
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+// LoginExternal maps an external identity to a local user: an already linked
+// user, else an existing user with the same verified email (which gets
+// linked), else a new account. New accounts follow the signup rules.
+func (s *RegistrationService) LoginExternal(ctx context.Context, identities UserIdentityRepository, identity *ExternalIdentity) (*User, error) {
+	if userID, err := identities.GetUserID(ctx, identity.Provider, identity.Subject); err == nil {
+		return s.userRepo.GetByID(ctx, userID)
+	} else if !strings.Contains(err.Error(), "not found") {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, fmt.Errorf("provider did not return a verified email")
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, identity.Email)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			return nil, err
+		}
+		if user, err = s.createExternalUser(ctx, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := identities.Link(ctx, user.ID, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *RegistrationService) createExternalUser(ctx context.Context, identity *ExternalIdentity) (*User, error) {
+	if !s.openSignup {
+		return nil, &InviteError{Code: InviteRequired}
+	}
+	if err := s.domainPolicy.Check(identity.Email); err != nil {
+		return nil, err
+	}
+
+	firstName, lastName := identity.GivenName, identity.FamilyName
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if lastName == "" {
+		lastName = "-"
+	}
+
+	user := &User{
+		ID:            NewID[userEntity](),
+		Email:         identity.Email,
+		PasswordHash:  unusablePasswordHash,
+		FirstName:     firstName,
+		LastName:      lastName,
+		Role:          "user",
+		IsActive:      true,
+		EmailVerified: true,
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// OIDC login state is kept in a short-lived signed cookie, so the callback
+// can check that it belongs to a login this browser started.
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+func (h *Handler) signOIDCState(value string) string {
+	mac := hmac.New(sha256.New, h.jwtService.secret)
+	mac.Write([]byte(value))
+	return value + "|" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) verifyOIDCState(signed string) (provider, state, nonce string, ok bool) {
+	i := strings.LastIndex(signed, "|")
+	if i < 0 || !hmac.Equal([]byte(h.signOIDCState(signed[:i])), []byte(signed)) {
+		return "", "", "", false
+	}
+	parts := strings.Split(signed[:i], "|")
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// OIDC Handlers
+
+// StartOIDCLogin redirects the browser to the provider's login page.
+func (h *Handler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.authProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		h.respondWithError(w, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	state, err := generateSecret(16)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	nonce, err := generateSecret(16)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadGateway, "Login provider is unavailable")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    h.signOIDCState(provider.Name() + "|" + state + "|" + nonce),
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes the login and returns the usual token pair.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		h.respondWithError(w, http.StatusUnauthorized, "Login was not completed: "+errorCode)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Missing login state")
+		return
+	}
+	providerName, state, nonce, ok := h.verifyOIDCState(cookie.Value)
+	if !ok || !hmac.Equal([]byte(state), []byte(query.Get("state"))) {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+
+	// The state is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	provider, ok := h.authProviders[providerName]
+	if !ok {
+		h.respondWithError(w, http.StatusBadRequest, "Unknown login provider")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), nonce)
+	if err != nil {
+		h.respondWithError(w, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	user, err := h.registration.LoginExternal(r.Context(), h.identityRepo, identity)
+	if err != nil {
+		if strings.Contains(err.Error(), "verified email") {
+			h.respondWithError(w, http.StatusForbidden, "The login provider did not return a verified email")
+			return
+		}
+		h.respondWithRegistrationError(w, err)
+		return
+	}
+
+	if !user.IsActive {
+		h.respondWithError(w, http.StatusUnauthorized, "Account is disabled")
+		return
+	}
+	h.recordAudit(r, &AuditEvent{UserID: user.ID, Action: AuditLogin, Metadata: map[string]interface{}{"method": "oidc", "provider": providerName}})
+
+	response, err := h.issueTokenPair(r.Context(), user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOIDCServer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that returns the ID token set up by the test.
+type fakeOIDCServer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	fake := &fakeOIDCServer{key: key}
+	routes := http.NewServeMux()
+	routes.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                fake.URL,
+			AuthorizationEndpoint: fake.URL + "/authorize",
+			TokenEndpoint:         fake.URL + "/token",
+			JWKSURI:               fake.URL + "/jwks",
+		})
+	})
+	routes.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{NewJSONWebKey("test-key", &key.PublicKey)}})
+	})
+	routes.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, fake.claims)
+		token.Header["kid"] = "test-key"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	fake.Server = httptest.NewServer(routes)
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeOIDCServer) setIdentity(subject, email, nonce string) {
+	f.claims = jwt.MapClaims{
+		"iss":            f.URL,
+		"aud":            "lesson-08",
+		"sub":            subject,
+		"email":          email,
+		"email_verified": true,
+		"given_name":     "Ext",
+		"family_name":    "User",
+		"nonce":          nonce,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func (f *fakeOIDCServer) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCProviderConfig{
+		Name:        "keycloak",
+		IssuerURL:   f.URL,
+		ClientID:    "lesson-08",
+		RedirectURL: "http://localhost/api/auth/oidc/callback",
+	})
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	fake := newFakeOIDCServer(t)
+	provider := fake.provider()
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1")
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "nonce-1", parsed.Query().Get("nonce"))
+	assert.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+
+	fake.setIdentity("sub-1", "Ext@Example.com", "nonce-1")
+	identity, err := provider.Exchange(ctx, "good-code", "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", identity.Provider)
+	assert.Equal(t, "sub-1", identity.Subject)
+	assert.Equal(t, "ext@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+
+	_, err = provider.Exchange(ctx, "good-code", "other-nonce")
+	assert.Error(t, err, "nonce must match the login")
+
+	_, err = provider.Exchange(ctx, "bad-code", "nonce-1")
+	assert.Error(t, err)
+
+	fake.claims["aud"] = "someone-else"
+	_, err = provider.Exchange(ctx, "good-code", "nonce-1")
+	assert.Error(t, err, "tokens for other clients are rejected")
+}
+
+func TestOIDCStateIsSigned(t *testing.T) {
+	handler := &Handler{jwtService: NewJWTService("test-secret")}
+
+	signed := handler.signOIDCState("keycloak|state|nonce")
+	provider, state, nonce, ok := handler.verifyOIDCState(signed)
+	require.True(t, ok)
+	assert.Equal(t, []string{"keycloak", "state", "nonce"}, []string{provider, state, nonce})
+
+	_, _, _, ok = handler.verifyOIDCState("google|state|nonce" + signed[len("keycloak|state|nonce"):])
+	assert.False(t, ok)
+}
+
+// oidcLogin runs the browser side of the flow against the test handler.
+func (env *testEnv) oidcLogin(t *testing.T, fake *fakeOIDCServer, subject, email string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/keycloak/login", nil)
+	req = mux.SetURLVars(req, map[string]string{"provider": "keycloak"})
+	w := httptest.NewRecorder()
+	env.handler.StartOIDCLogin(w, req)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	fake.setIdentity(subject, email, location.Query().Get("nonce"))
+
+	callback := httptest.NewRequest(http.MethodGet,
+		"/api/auth/oidc/callback?code=good-code&state="+location.Query().Get("state"), nil)
+	for _, cookie := range w.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	env.handler.OIDCCallback(w, callback)
+	return w
+}
+
+func TestOIDCCallbackCreatesAndLinksUsers(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	fake := newFakeOIDCServer(t)
+	env.handler.authProviders = map[string]AuthProvider{"keycloak": fake.provider()}
+
+	w := env.oidcLogin(t, fake, "sub-new", "new.oidc@example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var first LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.NotEmpty(t, first.Token)
+	assert.Equal(t, "new.oidc@example.com", first.User.Email)
+	assert.True(t, first.User.EmailVerified)
+
+	// The same subject logs into the same account
+	w = env.oidcLogin(t, fake, "sub-new", "new.oidc@example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	var second LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Equal(t, first.User.ID, second.User.ID)
+
+	// An existing local account is linked by verified email
+	existing := env.registerTestUser(t, "local.oidc@example.com")
+	w = env.oidcLogin(t, fake, "sub-local", "local.oidc@example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	var linked LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &linked))
+	assert.Equal(t, existing.User.ID, linked.User.ID)
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?code=good-code&state=x", nil)
+	w := httptest.NewRecorder()
+	env.handler.OIDCCallback(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?code=good-code&state=x", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: env.handler.signOIDCState("keycloak|y|nonce")})
+	w = httptest.NewRecorder()
+	env.handler.OIDCCallback(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
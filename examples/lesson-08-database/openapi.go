@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GET /api/openapi.json is an OpenAPI 3.1 description generated from the
+// same sources as GET /api: the operations come from the router walk, the
+// request bodies from the request schemas and, when examples are being
+// recorded, the examples from real traffic.
+
+// routeRequestSchemas names the request schema of the operations that
+// decode one of requestSchemas.
+var routeRequestSchemas = map[string]string{
+	"POST /api/auth/register": "register",
+	"POST /api/auth/login":    "login",
+	"POST /api/tasks":         "create-task",
+	"PUT /api/tasks/{id}":     "update-task",
+
+	"PATCH /api/tasks/{id}/priority": "priority",
+	"PUT /api/tasks/{id}/categories": "task-categories",
+	"POST /api/categories":           "create-category",
+	"PUT /api/categories/{id}":       "update-category",
+}
+
+// newOpenAPIDocument describes endpoints; examples may be nil.
+func newOpenAPIDocument(endpoints []APIEndpoint, examples map[string]RecordedExample) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, endpoint := range endpoints {
+		operations, ok := paths[endpoint.Path].(map[string]interface{})
+		if !ok {
+			operations = map[string]interface{}{}
+			paths[endpoint.Path] = operations
+		}
+		key := endpoint.Method + " " + endpoint.Path
+		example, hasExample := examples[key]
+		operations[strings.ToLower(endpoint.Method)] = newOpenAPIOperation(endpoint, routeRequestSchemas[key], example, hasExample)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "Task API",
+			"version": serviceVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+func newOpenAPIOperation(endpoint APIEndpoint, schemaName string, example RecordedExample, hasExample bool) map[string]interface{} {
+	operation := map[string]interface{}{
+		"responses": map[string]interface{}{
+			"default": map[string]interface{}{"description": "Error", "content": jsonContent(jsonSchema(reflect.TypeOf(ErrorResponse{})), nil)},
+		},
+	}
+
+	var parameters []interface{}
+	for _, segment := range strings.Split(endpoint.Path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			name, _, _ = strings.Cut(strings.TrimSuffix(name, "}"), ":")
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if parameters != nil {
+		operation["parameters"] = parameters
+	}
+
+	if endpoint.Auth == "bearer" {
+		scopes := []string{}
+		if endpoint.Scope != "" {
+			scopes = append(scopes, endpoint.Scope)
+		}
+		operation["security"] = []interface{}{
+			map[string]interface{}{"bearer": scopes},
+			map[string]interface{}{"apiKey": []string{}},
+		}
+	}
+	if endpoint.Role != "" {
+		operation["description"] = "Requires the " + endpoint.Role + " role."
+	}
+
+	var requestSchema map[string]interface{}
+	if request, ok := requestSchemas[schemaName]; ok {
+		requestSchema = jsonSchema(reflect.TypeOf(request))
+	}
+	if requestSchema != nil || hasExample && example.Request != nil {
+		var value interface{}
+		if hasExample && example.Request != nil {
+			value = example.Request
+		}
+		operation["requestBody"] = map[string]interface{}{"content": jsonContent(requestSchema, value)}
+	}
+
+	if hasExample {
+		response := map[string]interface{}{"description": http.StatusText(example.Status)}
+		if example.Response != nil {
+			response["content"] = jsonContent(nil, example.Response)
+		}
+		operation["responses"].(map[string]interface{})[strconv.Itoa(example.Status)] = response
+	}
+	return operation
+}
+
+// jsonContent is the application/json media type with an optional schema and
+// recorded example.
+func jsonContent(schema map[string]interface{}, example interface{}) map[string]interface{} {
+	mediaType := map[string]interface{}{}
+	if schema != nil {
+		mediaType["schema"] = schema
+	}
+	if example != nil {
+		mediaType["examples"] = map[string]interface{}{
+			"recorded": map[string]interface{}{"value": example},
+		}
+	}
+	return map[string]interface{}{"application/json": mediaType}
+}
+
+// GetOpenAPI handles GET /api/openapi.json
+func (h *Handler) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if h.apiIndex == nil {
+		h.respondWithError(w, http.StatusServiceUnavailable, "API description is not available")
+		return
+	}
+	var examples map[string]RecordedExample
+	if h.examples != nil {
+		examples = h.examples.Examples()
+	}
+	h.respondWithJSON(w, http.StatusOK, newOpenAPIDocument(h.apiIndex.Endpoints, examples))
+}
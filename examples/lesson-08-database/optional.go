@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Optional is a request field that tells an absent field apart from an
+// explicit null. A *T can't: both decode to nil, so a partial update had no
+// way to clear a field. With Optional, {"dueDate": null} clears the due date
+// while leaving dueDate out keeps it.
+type Optional[T any] struct {
+	Value T
+	// Set is true when the field was present, Null when it was present as null
+	Set  bool
+	Null bool
+}
+
+// Some returns a present, non-null Optional.
+func Some[T any](value T) Optional[T] {
+	return Optional[T]{Value: value, Set: true}
+}
+
+// Null returns an Optional that was sent as an explicit null.
+func Null[T any]() Optional[T] {
+	return Optional[T]{Set: true, Null: true}
+}
+
+// Ptr returns the value, or nil when the field is null or absent.
+func (o Optional[T]) Ptr() *T {
+	if !o.Set || o.Null {
+		return nil
+	}
+	return &o.Value
+}
+
+// UnmarshalJSON is only called for fields present in the document.
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	var value T
+	o.Value, o.Set, o.Null = value, true, false
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// MarshalJSON writes null for null and absent fields; structs holding
+// Optionals drop the absent ones with marshalWithoutAbsent.
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if !o.Set || o.Null {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.Value)
+}
+
+func (o Optional[T]) absent() bool { return !o.Set }
+
+// JSONSchema describes the field as its value's schema or null.
+func (o Optional[T]) JSONSchema() map[string]interface{} {
+	schema := jsonSchema(reflect.TypeOf(o.Value))
+	if typ, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typ, "null"}
+	}
+	return schema
+}
+
+type absentChecker interface {
+	absent() bool
+}
+
+// marshalWithoutAbsent encodes the struct v leaving out its absent Optional
+// fields, so encoding a request and decoding it again gives the same request.
+// encoding/json can't do this with tags: omitempty never omits a struct.
+func marshalWithoutAbsent(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	value := reflect.ValueOf(v)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+
+	// Rebuild the object in field order, as json.Marshal would
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i < value.NumField(); i++ {
+		name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = value.Type().Field(i).Name
+		}
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if field, ok := value.Field(i).Interface().(absentChecker); ok && field.absent() {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(raw)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
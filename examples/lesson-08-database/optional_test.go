@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionalDecoding(t *testing.T) {
+	var req UpdateTaskRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"description": null, "dueDate": "2025-01-15T09:00:00Z"}`), &req))
+
+	assert.True(t, req.Description.Set)
+	assert.True(t, req.Description.Null)
+	assert.Nil(t, req.Description.Ptr())
+	require.NotNil(t, req.DueDate.Ptr())
+	assert.Equal(t, time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC), req.DueDate.Value)
+
+	req = UpdateTaskRequest{}
+	require.NoError(t, json.Unmarshal([]byte(`{"title": "Only the title"}`), &req))
+	assert.False(t, req.Description.Set)
+	assert.False(t, req.DueDate.Set)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"dueDate": "next week"}`), &req))
+}
+
+func TestOptionalMarshalRoundTrip(t *testing.T) {
+	for _, req := range []UpdateTaskRequest{
+		{Title: stringPtr("Absent fields stay absent")},
+		{Description: Null[string](), DueDate: Null[time.Time]()},
+		{Description: Some("Set"), DueDate: Some(time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC))},
+	} {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		var decoded UpdateTaskRequest
+		require.NoError(t, json.Unmarshal(body, &decoded))
+		assert.Equal(t, req, decoded, string(body))
+	}
+
+	body, _ := json.Marshal(UpdateTaskRequest{Completed: boolPtr(true)})
+	assert.NotContains(t, string(body), "dueDate")
+	assert.NotContains(t, string(body), "description")
+}
+
+func TestGetSchema(t *testing.T) {
+	h := &Handler{}
+	serve := func(name string) *httptest.ResponseRecorder {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/schemas/"+name, nil), map[string]string{"name": name})
+		w := httptest.NewRecorder()
+		h.GetSchema(w, req)
+		return w
+	}
+
+	w := serve("update-task")
+	require.Equal(t, http.StatusOK, w.Code)
+	var schema struct {
+		Title      string                            `json:"title"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &schema))
+	assert.Equal(t, "UpdateTaskRequest", schema.Title)
+	assert.Equal(t, []interface{}{"string", "null"}, schema.Properties["dueDate"]["type"])
+	assert.Equal(t, "date-time", schema.Properties["dueDate"]["format"])
+	assert.Equal(t, []interface{}{"string", "null"}, schema.Properties["description"]["type"])
+	assert.Equal(t, "boolean", schema.Properties["completed"]["type"])
+	assert.Equal(t, "array", schema.Properties["tags"]["type"])
+
+	assert.Equal(t, http.StatusNotFound, serve("task").Code)
+}
+
+func TestUpdateTaskClearsFields(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "optional@example.com")
+
+	due := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Clear me", Description: "Some details", Priority: "low", DueDate: &due}, user.User.ID)
+	require.NoError(t, err)
+
+	update := func(body string) TaskResponse {
+		req := taskRequest(http.MethodPut, "/api/tasks/"+task.ID.String(), user.Token, body, map[string]string{"id": task.ID.String()})
+		w := env.serveWithAuth(env.handler.UpdateTask, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var updated TaskResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		return updated
+	}
+
+	// Leaving fields out keeps them
+	updated := update(`{"title": "Still due"}`)
+	require.NotNil(t, updated.DueDate)
+	assert.True(t, due.Equal(*updated.DueDate))
+	assert.Equal(t, "Some details", updated.Description)
+
+	// Sending null clears them
+	updated = update(`{"dueDate": null, "description": null}`)
+	assert.Nil(t, updated.DueDate)
+	assert.Empty(t, updated.Description)
+	assert.Equal(t, "Still due", updated.Title)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"correlation"
+	"lesson-08-database/pkg/httpclient"
+)
+
+// newHTTPClient returns the shared outbound client configuration with the
+// given overall timeout. Every attempt is recorded in the outbound metrics,
+// and calls made for a request forward its FORWARD_HEADERS.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	config := httpclient.DefaultConfig()
+	config.Timeout = timeout
+	config.Observer = observeOutboundRequest
+	client := httpclient.New(config)
+	client.Transport = &correlation.Transport{Base: client.Transport}
+	return client
+}
+
+func observeOutboundRequest(event httpclient.Event) {
+	status := strconv.Itoa(event.StatusCode)
+	if event.Err != nil {
+		status = "error"
+	}
+	outboundRequestsTotal.WithLabelValues(event.Host, event.Method, status).Inc()
+	outboundRequestDuration.WithLabelValues(event.Host).Observe(event.Duration.Seconds())
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the argon2id cost parameters. They are embedded in every
+// hash, so changing them only affects new hashes; existing hashes are
+// upgraded the next time the user logs in.
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// DefaultBcryptCost is used when bcrypt is the configured algorithm.
+const DefaultBcryptCost = 12
+
+// Password hashing algorithms selectable with PASSWORD_HASH_ALGORITHM
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+// Hashing faster than this is too cheap to brute force; slower than this
+// makes logins sluggish and invites denial of service.
+const (
+	minPasswordHashDuration = 50 * time.Millisecond
+	maxPasswordHashDuration = time.Second
+)
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies hashes of every supported algorithm. needsRehash reports whether
+// a verified hash uses another algorithm or weaker parameters and should be
+// replaced with a fresh Hash of the password.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (match bool, needsRehash bool, err error)
+}
+
+// Argon2Hasher hashes with argon2id.
+type Argon2Hasher struct {
+	params Argon2Params
+}
+
+func NewArgon2Hasher(params Argon2Params) *Argon2Hasher {
+	return &Argon2Hasher{params: params}
+}
+
+// Hash returns the password encoded in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (p *Argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, p.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt,
+		p.params.Iterations, p.params.Memory, p.params.Parallelism, p.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.params.Memory, p.params.Iterations, p.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (p *Argon2Hasher) Verify(password, encoded string) (bool, bool, error) {
+	if isBcryptHash(encoded) {
+		match, err := verifyBcrypt(password, encoded)
+		return match, match, err
+	}
+
+	params, match, err := verifyArgon2(password, encoded)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, params.weakerThan(p.params), nil
+}
+
+// BcryptHasher hashes with bcrypt at a configurable cost.
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = DefaultBcryptCost
+	}
+	return &BcryptHasher{cost: cost}
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	if !isBcryptHash(encoded) {
+		// Keep accepting argon2id hashes, e.g. after switching algorithms
+		_, match, err := verifyArgon2(password, encoded)
+		return match, match, err
+	}
+
+	match, err := verifyBcrypt(password, encoded)
+	if err != nil || !match {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost < b.cost, nil
+}
+
+// newPasswordHasher builds the hasher selected in config.
+func newPasswordHasher(config Config) PasswordHasher {
+	if config.PasswordHashAlgorithm == HashAlgorithmBcrypt {
+		return NewBcryptHasher(config.BcryptCost)
+	}
+	return NewArgon2Hasher(config.Argon2)
+}
+
+// BenchmarkPasswordHasher hashes a sample password once and logs a warning
+// when the configured cost is outside the recommended range.
+func BenchmarkPasswordHasher(hasher PasswordHasher) time.Duration {
+	start := time.Now()
+	hasher.Hash("benchmark-password")
+	elapsed := time.Since(start)
+
+	switch {
+	case elapsed < minPasswordHashDuration:
+		slog.Warn("password hashing is fast; consider raising BCRYPT_COST or ARGON2_MEMORY_KB and ARGON2_ITERATIONS",
+			"duration", elapsed.String(), "min", minPasswordHashDuration.String())
+	case elapsed > maxPasswordHashDuration:
+		slog.Warn("password hashing is slow; consider lowering BCRYPT_COST or ARGON2_MEMORY_KB and ARGON2_ITERATIONS",
+			"duration", elapsed.String(), "max", maxPasswordHashDuration.String())
+	default:
+		slog.Info("password hashing time", "duration", elapsed.String())
+	}
+
+	return elapsed
+}
+
+// weakerThan reports whether any cost parameter is below target.
+func (a Argon2Params) weakerThan(target Argon2Params) bool {
+	return a.Memory < target.Memory ||
+		a.Iterations < target.Iterations ||
+		a.Parallelism < target.Parallelism ||
+		a.KeyLength < target.KeyLength ||
+		a.SaltLength < target.SaltLength
+}
+
+func verifyBcrypt(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func verifyArgon2(password, encoded string) (Argon2Params, bool, error) {
+	params, salt, key, err := decodeArgon2Hash(encoded)
+	if err != nil {
+		return params, false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt,
+		params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return params, subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func decodeArgon2Hash(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("unsupported password hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("incompatible argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
+		&params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
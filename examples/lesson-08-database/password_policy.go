@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//go:embed data/common_passwords.txt
+var commonPasswordList string
+
+// PasswordViolation describes one way a password fails the policy. Clients
+// can switch on Code; Message is for display.
+type PasswordViolation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Password violation codes
+const (
+	PasswordTooShort      = "password_too_short"
+	PasswordTooLong       = "password_too_long"
+	PasswordTooWeak       = "password_too_weak"
+	PasswordContainsEmail = "password_contains_email"
+	PasswordCommon        = "password_common"
+	PasswordMissingTypes  = "password_missing_character_types"
+	PasswordBreached      = "password_breached"
+)
+
+const defaultHIBPAPIURL = "https://api.pwnedpasswords.com"
+
+type PasswordPolicyConfig struct {
+	MinLength      int
+	MaxLength      int
+	MinEntropyBits float64
+	// MinCharacterTypes is how many of lowercase, uppercase, digits and
+	// symbols the password must use
+	MinCharacterTypes int
+	RejectCommon      bool
+}
+
+var DefaultPasswordPolicyConfig = PasswordPolicyConfig{
+	MinLength:         8,
+	MaxLength:         128,
+	MinEntropyBits:    40,
+	MinCharacterTypes: 2,
+	RejectCommon:      true,
+}
+
+// BreachChecker reports whether a password appears in known data breaches.
+type BreachChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+type PasswordPolicy struct {
+	config  PasswordPolicyConfig
+	common  map[string]bool
+	breach  BreachChecker
+	timeout time.Duration
+}
+
+// NewPasswordPolicy creates a policy. breach may be nil to skip the breach
+// check.
+func NewPasswordPolicy(config PasswordPolicyConfig, breach BreachChecker) *PasswordPolicy {
+	policy := &PasswordPolicy{config: config, breach: breach, timeout: 3 * time.Second}
+	if config.RejectCommon {
+		policy.common = make(map[string]bool)
+		for _, password := range parseList(commonPasswordList) {
+			policy.common[strings.ToLower(password)] = true
+		}
+	}
+	return policy
+}
+
+// Validate returns every policy violation for the password. A failing breach
+// check is logged and otherwise ignored so an outage doesn't block signups.
+func (p *PasswordPolicy) Validate(ctx context.Context, password, email string) []PasswordViolation {
+	var violations []PasswordViolation
+	add := func(code, message string) {
+		violations = append(violations, PasswordViolation{Field: "password", Code: code, Message: message})
+	}
+
+	length := len([]rune(password))
+	if length < p.config.MinLength {
+		add(PasswordTooShort, fmt.Sprintf("Password must be at least %d characters", p.config.MinLength))
+	}
+	if p.config.MaxLength > 0 && length > p.config.MaxLength {
+		add(PasswordTooLong, fmt.Sprintf("Password must be at most %d characters", p.config.MaxLength))
+	}
+
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok && len(local) >= 3 &&
+		strings.Contains(strings.ToLower(password), local) {
+		add(PasswordContainsEmail, "Password must not contain your email address")
+	}
+
+	if p.common[strings.ToLower(password)] {
+		add(PasswordCommon, "Password is too common; choose a different one")
+	}
+
+	if length >= p.config.MinLength && characterTypes(password) < p.config.MinCharacterTypes {
+		add(PasswordMissingTypes, fmt.Sprintf("Password must use at least %d of lowercase letters, uppercase letters, digits and symbols", p.config.MinCharacterTypes))
+	}
+
+	if length >= p.config.MinLength && EstimatePasswordEntropy(password) < p.config.MinEntropyBits {
+		add(PasswordTooWeak, "Password is too easy to guess; use a longer password or mix character types")
+	}
+
+	if p.breach != nil && len(violations) == 0 {
+		ctx, cancel := context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+
+		breached, err := p.breach.IsBreached(ctx, password)
+		if err != nil {
+			slog.ErrorContext(ctx, "password breach check failed", "error", err)
+		} else if breached {
+			add(PasswordBreached, "Password has appeared in a data breach; choose a different one")
+		}
+	}
+
+	return violations
+}
+
+// EstimatePasswordEntropy gives a rough entropy estimate in bits: length times
+// log2 of the character pool the password draws from. Runs of the same
+// character (e.g. "aaaa") count as one.
+func EstimatePasswordEntropy(password string) float64 {
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	effectiveLength := 0
+	var previous rune = -1
+
+	for _, c := range password {
+		switch {
+		case c > unicode.MaxASCII:
+			hasOther = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+		if c != previous {
+			effectiveLength++
+		}
+		previous = c
+	}
+
+	pool := 0
+	if hasLower {
+		pool += 26
+	}
+	if hasUpper {
+		pool += 26
+	}
+	if hasDigit {
+		pool += 10
+	}
+	if hasSymbol {
+		pool += 33
+	}
+	if hasOther {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(effectiveLength) * math.Log2(float64(pool))
+}
+
+// characterTypes counts which of lowercase, uppercase, digits and symbols the
+// password uses. Non-ASCII characters count as symbols.
+func characterTypes(password string) int {
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case c > unicode.MaxASCII:
+			hasSymbol = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	types := 0
+	for _, has := range []bool{hasLower, hasUpper, hasDigit, hasSymbol} {
+		if has {
+			types++
+		}
+	}
+	return types
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// HIBPBreachChecker queries the Have I Been Pwned range API. Only the first
+// five characters of the password's SHA-1 leave the process (k-anonymity).
+type HIBPBreachChecker struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHIBPBreachChecker(baseURL string) *HIBPBreachChecker {
+	if baseURL == "" {
+		baseURL = defaultHIBPAPIURL
+	}
+	return &HIBPBreachChecker{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  newHTTPClient(5 * time.Second),
+	}
+}
+
+func (c *HIBPBreachChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padding hides the real number of matches from observers
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to query breach API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breach API returned status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && strings.EqualFold(candidate, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// BloomFilter is a fixed-size bloom filter over SHA-1 password hashes, used
+// as an offline breach list when the HIBP API is unreachable.
+type BloomFilter struct {
+	bits   []uint64
+	m      uint64
+	hashes uint64
+}
+
+// NewBloomFilter sizes a filter for n entries at the given false positive rate.
+func NewBloomFilter(n int, falsePositiveRate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &BloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		hashes: k,
+	}
+}
+
+// positions derives the filter positions from the SHA-1 digest using double
+// hashing, so no extra hash functions are needed.
+func (f *BloomFilter) positions(sha1Digest []byte) []uint64 {
+	h1 := binary.BigEndian.Uint64(sha1Digest[0:8])
+	h2 := binary.BigEndian.Uint64(sha1Digest[8:16])
+	positions := make([]uint64, f.hashes)
+	for i := uint64(0); i < f.hashes; i++ {
+		positions[i] = (h1 + i*h2) % f.m
+	}
+	return positions
+}
+
+func (f *BloomFilter) AddHash(sha1Digest []byte) {
+	for _, pos := range f.positions(sha1Digest) {
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (f *BloomFilter) ContainsHash(sha1Digest []byte) bool {
+	for _, pos := range f.positions(sha1Digest) {
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *BloomFilter) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	return f.ContainsHash(sum[:]), nil
+}
+
+// LoadBloomFilter builds a filter from a breach list with one SHA-1 hash per
+// line, optionally followed by ":count" as in the HIBP downloads.
+func LoadBloomFilter(r io.Reader, expectedEntries int) (*BloomFilter, error) {
+	filter := NewBloomFilter(expectedEntries, 0.001)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		digest, err := hex.DecodeString(hash)
+		if err != nil || len(digest) != sha1.Size {
+			return nil, fmt.Errorf("invalid SHA-1 hash in breach list: %q", hash)
+		}
+		filter.AddHash(digest)
+	}
+
+	return filter, scanner.Err()
+}
+
+// LoadBloomFilterFile reads a breach list from disk, sizing the filter by the
+// number of lines.
+func LoadBloomFilterFile(path string) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return LoadBloomFilter(file, lines)
+}
+
+// FallbackBreachChecker uses the fallback checker whenever the primary one
+// fails, e.g. when the HIBP API is unreachable.
+type FallbackBreachChecker struct {
+	primary  BreachChecker
+	fallback BreachChecker
+}
+
+func NewFallbackBreachChecker(primary, fallback BreachChecker) *FallbackBreachChecker {
+	return &FallbackBreachChecker{primary: primary, fallback: fallback}
+}
+
+func (c *FallbackBreachChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	breached, err := c.primary.IsBreached(ctx, password)
+	if err == nil {
+		return breached, nil
+	}
+	slog.WarnContext(ctx, "primary breach check failed, using fallback", "error", err)
+	return c.fallback.IsBreached(ctx, password)
+}
+
+// newBreachChecker builds the breach checker from config: the HIBP API, with
+// the offline breach list as a fallback when one is configured.
+func newBreachChecker(config Config) BreachChecker {
+	if !config.BreachCheck {
+		return nil
+	}
+
+	hibp := NewHIBPBreachChecker(config.HIBPAPIURL)
+	if config.BreachListFile == "" {
+		return hibp
+	}
+
+	filter, err := LoadBloomFilterFile(config.BreachListFile)
+	if err != nil {
+		slog.Error("failed to load breach list", "file", config.BreachListFile, "error", err)
+		return hibp
+	}
+	return NewFallbackBreachChecker(hibp, filter)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubBreachChecker struct {
+	breached bool
+	err      error
+}
+
+func (s stubBreachChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	return s.breached, s.err
+}
+
+func violationCodes(violations []PasswordViolation) []string {
+	codes := make([]string, len(violations))
+	for i, v := range violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := NewPasswordPolicy(DefaultPasswordPolicyConfig, nil)
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		expected []string
+	}{
+		{"valid", "correct horse battery", "user@example.com", nil},
+		{"too short", "a1!", "user@example.com", []string{PasswordTooShort}},
+		{"too long", strings.Repeat("ab1", 50), "user@example.com", []string{PasswordTooLong}},
+		{"low entropy", "aaaaaaaaaaaa", "user@example.com", []string{PasswordMissingTypes, PasswordTooWeak}},
+		{"contains email", "janedoe-2024!", "janedoe@example.com", []string{PasswordContainsEmail}},
+		{"common", "Password123", "user@example.com", []string{PasswordCommon}},
+		{"one character type", "tangerinequokkaviolin", "user@example.com", []string{PasswordMissingTypes}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := policy.Validate(context.Background(), tt.password, tt.email)
+			if tt.expected == nil {
+				assert.Empty(t, violations)
+				return
+			}
+			assert.Equal(t, tt.expected, violationCodes(violations))
+		})
+	}
+}
+
+func TestPasswordPolicy_ConfigurableRules(t *testing.T) {
+	lenient := DefaultPasswordPolicyConfig
+	lenient.MinCharacterTypes = 0
+	lenient.RejectCommon = false
+	policy := NewPasswordPolicy(lenient, nil)
+
+	assert.Empty(t, policy.Validate(context.Background(), "password123", "user@example.com"))
+	assert.Empty(t, policy.Validate(context.Background(), "tangerinequokkaviolin", "user@example.com"))
+}
+
+func TestPasswordPolicy_BreachCheck(t *testing.T) {
+	breached := NewPasswordPolicy(DefaultPasswordPolicyConfig, stubBreachChecker{breached: true})
+	violations := breached.Validate(context.Background(), "correct horse battery", "user@example.com")
+	assert.Equal(t, []string{PasswordBreached}, violationCodes(violations))
+
+	// An unavailable breach check does not block the password
+	failing := NewPasswordPolicy(DefaultPasswordPolicyConfig, stubBreachChecker{err: errors.New("offline")})
+	assert.Empty(t, failing.Validate(context.Background(), "correct horse battery", "user@example.com"))
+}
+
+func TestEstimatePasswordEntropy(t *testing.T) {
+	assert.Equal(t, 0.0, EstimatePasswordEntropy(""))
+	assert.InDelta(t, 4.7, EstimatePasswordEntropy("aaaa"), 0.01, "runs of one character count once")
+	assert.Greater(t, EstimatePasswordEntropy("Tr0ub4dor&3"), EstimatePasswordEntropy("troubadour"))
+}
+
+func TestHIBPBreachChecker(t *testing.T) {
+	hash := sha1Hex("password123")
+	var requestedPath, padding string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		padding = r.Header.Get("Add-Padding")
+		fmt.Fprintf(w, "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n%s:24230577\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0\r\n", hash[5:])
+	}))
+	defer server.Close()
+
+	checker := NewHIBPBreachChecker(server.URL)
+
+	breached, err := checker.IsBreached(context.Background(), "password123")
+	require.NoError(t, err)
+	assert.True(t, breached)
+	assert.Equal(t, "/range/"+hash[:5], requestedPath, "only the hash prefix is sent")
+	assert.Equal(t, "true", padding)
+
+	breached, err = checker.IsBreached(context.Background(), "correct horse battery")
+	require.NoError(t, err)
+	assert.False(t, breached)
+}
+
+func TestBloomFilterFallback(t *testing.T) {
+	list := sha1Hex("password123") + ":24230577\n" + sha1Hex("qwerty") + "\n"
+	filter, err := LoadBloomFilter(strings.NewReader(list), 2)
+	require.NoError(t, err)
+
+	checker := NewFallbackBreachChecker(stubBreachChecker{err: errors.New("offline")}, filter)
+
+	breached, err := checker.IsBreached(context.Background(), "qwerty")
+	require.NoError(t, err)
+	assert.True(t, breached)
+
+	breached, err = checker.IsBreached(context.Background(), "correct horse battery")
+	require.NoError(t, err)
+	assert.False(t, breached)
+
+	_, err = LoadBloomFilter(strings.NewReader("not-a-hash\n"), 1)
+	assert.Error(t, err)
+}
+
+func TestRegister_WeakPassword(t *testing.T) {
+	handler := &Handler{passwordPolicy: NewPasswordPolicy(DefaultPasswordPolicyConfig, nil)}
+
+	body, _ := json.Marshal(RegisterRequest{
+		Email:     "weak@example.com",
+		Password:  "short",
+		FirstName: "Weak",
+		LastName:  "Password",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.Register(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response struct {
+		Message string              `json:"message"`
+		Details []PasswordViolation `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Details, 1)
+	assert.Equal(t, "password", response.Details[0].Field)
+	assert.Equal(t, PasswordTooShort, response.Details[0].Code)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast
+var testArgon2Params = Argon2Params{
+	Memory:      8 * 1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestPasswordHasher_Argon2id(t *testing.T) {
+	hasher := NewArgon2Hasher(testArgon2Params)
+
+	hash, err := hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$"), hash)
+
+	match, needsRehash, err := hasher.Verify("password123", hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+
+	match, _, err = hasher.Verify("wrong", hash)
+	require.NoError(t, err)
+	assert.False(t, match)
+
+	// Salts are random
+	other, _ := hasher.Hash("password123")
+	assert.NotEqual(t, hash, other)
+}
+
+func TestPasswordHasher_ParameterUpgrade(t *testing.T) {
+	old := NewArgon2Hasher(testArgon2Params)
+	hash, err := old.Hash("password123")
+	require.NoError(t, err)
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	match, needsRehash, err := NewArgon2Hasher(stronger).Verify("password123", hash)
+	require.NoError(t, err)
+	assert.True(t, match, "old hashes still verify with their embedded parameters")
+	assert.True(t, needsRehash)
+}
+
+func TestPasswordHasher_LegacyBcrypt(t *testing.T) {
+	hasher := NewArgon2Hasher(testArgon2Params)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	match, needsRehash, err := hasher.Verify("password123", string(legacy))
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	match, _, err = hasher.Verify("wrong", string(legacy))
+	require.NoError(t, err)
+	assert.False(t, match)
+}
+
+func TestPasswordHasher_MalformedHash(t *testing.T) {
+	hasher := NewArgon2Hasher(testArgon2Params)
+
+	for _, encoded := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=8192,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=18$m=8192,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=8192,t=1,p=1$!!!$a2V5",
+	} {
+		_, _, err := hasher.Verify("password123", encoded)
+		assert.Error(t, err, encoded)
+	}
+}
+
+func TestPasswordHasher_Bcrypt(t *testing.T) {
+	hasher := NewBcryptHasher(bcrypt.MinCost + 1)
+
+	hash, err := hasher.Hash("password123")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(hash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+
+	match, needsRehash, err := hasher.Verify("password123", hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+
+	// Hashes below the configured cost are upgraded
+	weaker, err := NewBcryptHasher(bcrypt.MinCost).Hash("password123")
+	require.NoError(t, err)
+	match, needsRehash, err = hasher.Verify("password123", weaker)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	// argon2id hashes still verify after switching to bcrypt
+	argon2Hash, err := NewArgon2Hasher(testArgon2Params).Hash("password123")
+	require.NoError(t, err)
+	match, needsRehash, err = hasher.Verify("password123", argon2Hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	assert.Equal(t, DefaultBcryptCost, NewBcryptHasher(99).cost, "out of range costs fall back to the default")
+}
+
+func TestPasswordHasher_WeakerParametersOnly(t *testing.T) {
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	hash, err := NewArgon2Hasher(stronger).Hash("password123")
+	require.NoError(t, err)
+
+	match, needsRehash, err := NewArgon2Hasher(testArgon2Params).Verify("password123", hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash, "stronger hashes are not downgraded")
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	assert.IsType(t, &Argon2Hasher{}, newPasswordHasher(Config{Argon2: testArgon2Params}))
+	assert.IsType(t, &BcryptHasher{}, newPasswordHasher(Config{PasswordHashAlgorithm: HashAlgorithmBcrypt, BcryptCost: 10}))
+}
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	userRepo := NewUserRepository(env.db.DB)
+	user := &User{
+		ID:           NewID[userEntity](),
+		Email:        "legacy@example.com",
+		PasswordHash: string(legacy),
+		FirstName:    "Legacy",
+		LastName:     "User",
+		Role:         "user",
+		IsActive:     true,
+	}
+	require.NoError(t, userRepo.Create(context.Background(), user))
+
+	_, err = env.handler.verifyCredentials(context.Background(), user.Email, "password123")
+	require.NoError(t, err)
+
+	stored, err := userRepo.GetByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.PasswordHash, "$argon2id$"))
+
+	// The upgraded hash still works
+	_, err = env.handler.verifyCredentials(context.Background(), user.Email, "password123")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,251 @@
+// Package httpclient builds the *http.Client used for every outbound call
+// (identity providers, CAPTCHA verification, breach checks, policy engines,
+// webhooks). Clients get timeouts, connection pooling, a User-Agent, retries
+// of idempotent requests, a per-destination circuit breaker and a hook for
+// metrics and tracing.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the destination while its
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("httpclient: circuit breaker open")
+
+type Config struct {
+	// Timeout bounds the whole call, retries included
+	Timeout   time.Duration
+	UserAgent string
+
+	// MaxRetries is the number of extra attempts for idempotent requests that
+	// fail with a network error or a 429, 502, 503 or 504
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	MaxIdleConnsPerHost int
+
+	// The breaker opens after BreakerThreshold consecutive failures (network
+	// errors or 5xx) to a host and lets a probe through after BreakerCooldown.
+	// A zero threshold disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Observer, when set, is called after every attempt
+	Observer func(Event)
+}
+
+// Event describes one attempt of an outbound request.
+type Event struct {
+	Method     string
+	Host       string
+	Attempt    int
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+// DefaultConfig returns the settings new clients should start from.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:             10 * time.Second,
+		UserAgent:           "task-api/1.0",
+		MaxRetries:          2,
+		RetryBackoff:        100 * time.Millisecond,
+		MaxIdleConnsPerHost: 10,
+		BreakerThreshold:    5,
+		BreakerCooldown:     30 * time.Second,
+	}
+}
+
+// New returns a client configured from config.
+func New(config Config) *http.Client {
+	base := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: NewTransport(base, config),
+	}
+}
+
+// Transport adds retries, circuit breaking and observation to a base
+// RoundTripper.
+type Transport struct {
+	base   http.RoundTripper
+	config Config
+	now    func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func NewTransport(base http.RoundTripper, config Config) *Transport {
+	return &Transport{
+		base:     base,
+		config:   config,
+		now:      time.Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.config.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.config.UserAgent)
+	}
+
+	host := req.URL.Host
+	for attempt := 1; ; attempt++ {
+		if !t.allow(host) {
+			t.observe(req, attempt, nil, ErrCircuitOpen, 0)
+			return nil, ErrCircuitOpen
+		}
+
+		start := t.now()
+		resp, err := t.base.RoundTrip(req)
+		t.observe(req, attempt, resp, err, t.now().Sub(start))
+		t.record(host, err == nil && resp.StatusCode < 500, err != nil && req.Context().Err() != nil)
+
+		// Bodies that can't be replayed can't be retried
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		if attempt > t.config.MaxRetries || !replayable || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		if err := sleep(req.Context(), t.config.RetryBackoff*time.Duration(1<<(attempt-1))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) observe(req *http.Request, attempt int, resp *http.Response, err error, duration time.Duration) {
+	if t.config.Observer == nil {
+		return
+	}
+	event := Event{
+		Method:   req.Method,
+		Host:     req.URL.Host,
+		Attempt:  attempt,
+		Err:      err,
+		Duration: duration,
+	}
+	if resp != nil {
+		event.StatusCode = resp.StatusCode
+	}
+	t.config.Observer(event)
+}
+
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	if err != nil {
+		// The caller gave up; retrying can't help
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// breaker is a consecutive-failure circuit breaker for one host.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (t *Transport) allow(host string) bool {
+	if t.config.BreakerThreshold <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.breakers[host]
+	if !ok || b.failures < t.config.BreakerThreshold {
+		return true
+	}
+	if t.now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	// Half-open: let a single probe through
+	b.probing = true
+	return true
+}
+
+// record updates the host's breaker with an attempt's outcome. Attempts the
+// caller abandoned say nothing about the destination and are not counted.
+func (t *Transport) record(host string, success, abandoned bool) {
+	if t.config.BreakerThreshold <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{}
+		t.breakers[host] = b
+	}
+	b.probing = false
+
+	if abandoned {
+		return
+	}
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= t.config.BreakerThreshold {
+		b.openUntil = t.now().Add(t.config.BreakerCooldown)
+	}
+}
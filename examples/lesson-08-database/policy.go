@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Access Control Policies
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionList   Action = "list"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionShare  Action = "share"
+)
+
+type Subject struct {
+	UserID UserID `json:"userId"`
+	Role   string `json:"role"`
+}
+
+type Resource struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	OwnerID UserID `json:"ownerId"`
+	// SharedAccess is the subject's share permission (read or write) on a
+	// resource someone else owns, empty when it isn't shared with them
+	SharedAccess string `json:"sharedAccess,omitempty"`
+}
+
+// PolicyEngine decides whether a subject may perform an action on a resource.
+// Handlers consult it instead of comparing owner IDs inline.
+type PolicyEngine interface {
+	Authorize(ctx context.Context, subject Subject, action Action, resource Resource) (bool, error)
+}
+
+type Effect int
+
+const (
+	EffectAbstain Effect = iota
+	EffectAllow
+	EffectDeny
+)
+
+// PolicyRule inspects a request and returns an effect. Rules that don't
+// apply should abstain so later rules get a chance to decide.
+type PolicyRule func(subject Subject, action Action, resource Resource) Effect
+
+// LocalPolicyEngine evaluates rules in-process. Any deny wins, otherwise at
+// least one allow is required (deny by default).
+type LocalPolicyEngine struct {
+	rules []PolicyRule
+}
+
+func NewLocalPolicyEngine(rules ...PolicyRule) *LocalPolicyEngine {
+	if len(rules) == 0 {
+		rules = DefaultPolicyRules()
+	}
+	return &LocalPolicyEngine{rules: rules}
+}
+
+// DefaultPolicyRules give users access to their own resources and those
+// shared with them. Admins are users like any other here; AdminReadRule is
+// opt-in.
+func DefaultPolicyRules() []PolicyRule {
+	return []PolicyRule{
+		DenyAnonymousRule,
+		OwnerRule,
+		CollaboratorRule,
+	}
+}
+
+func (e *LocalPolicyEngine) Authorize(ctx context.Context, subject Subject, action Action, resource Resource) (bool, error) {
+	allowed := false
+	for _, rule := range e.rules {
+		switch rule(subject, action, resource) {
+		case EffectDeny:
+			return false, nil
+		case EffectAllow:
+			allowed = true
+		}
+	}
+	return allowed, nil
+}
+
+// DenyAnonymousRule rejects requests without an authenticated user.
+func DenyAnonymousRule(subject Subject, action Action, resource Resource) Effect {
+	if subject.UserID == "" {
+		return EffectDeny
+	}
+	return EffectAbstain
+}
+
+// OwnerRule grants full access to resources the subject owns.
+func OwnerRule(subject Subject, action Action, resource Resource) Effect {
+	if resource.OwnerID != "" && resource.OwnerID == subject.UserID {
+		return EffectAllow
+	}
+	return EffectAbstain
+}
+
+// CollaboratorRule grants access to resources shared with the subject: read
+// shares allow reading, write shares also allow updates. Deleting and
+// sharing stay with the owner.
+func CollaboratorRule(subject Subject, action Action, resource Resource) Effect {
+	switch resource.SharedAccess {
+	case ShareWrite:
+		if action == ActionRead || action == ActionUpdate {
+			return EffectAllow
+		}
+	case ShareRead:
+		if action == ActionRead {
+			return EffectAllow
+		}
+	}
+	return EffectAbstain
+}
+
+// AdminReadRule lets admins read (but not modify) any user's resources. It
+// isn't a default rule: add it with ADMIN_READ_ALL=true.
+func AdminReadRule(subject Subject, action Action, resource Resource) Effect {
+	if subject.Role == RoleAdmin && (action == ActionRead || action == ActionList) {
+		return EffectAllow
+	}
+	return EffectAbstain
+}
+
+// OPAPolicyEngine delegates decisions to an Open Policy Agent server using
+// its data API: POST {baseURL}/v1/data/{path} with {"input": {...}}.
+type OPAPolicyEngine struct {
+	url    string
+	client *http.Client
+}
+
+func NewOPAPolicyEngine(baseURL, policyPath string) *OPAPolicyEngine {
+	return &OPAPolicyEngine{
+		url:    strings.TrimRight(baseURL, "/") + "/v1/data/" + strings.Trim(policyPath, "/"),
+		client: newHTTPClient(2 * time.Second),
+	}
+}
+
+type opaRequest struct {
+	Input opaInput `json:"input"`
+}
+
+type opaInput struct {
+	Subject  Subject  `json:"subject"`
+	Action   Action   `json:"action"`
+	Resource Resource `json:"resource"`
+}
+
+type opaResponse struct {
+	Result *bool `json:"result"`
+}
+
+func (e *OPAPolicyEngine) Authorize(ctx context.Context, subject Subject, action Action, resource Resource) (bool, error) {
+	body, err := json.Marshal(opaRequest{Input: opaInput{
+		Subject:  subject,
+		Action:   action,
+		Resource: resource,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode policy input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to build policy request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("policy server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("policy server returned status %d", resp.StatusCode)
+	}
+
+	var decision opaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return false, fmt.Errorf("failed to decode policy decision: %w", err)
+	}
+
+	// An undefined result means no rule matched, which we treat as deny
+	if decision.Result == nil {
+		return false, nil
+	}
+	return *decision.Result, nil
+}
+
+func subjectFromContext(ctx context.Context) Subject {
+	subject := Subject{}
+	if userID, ok := ctx.Value("user_id").(string); ok {
+		subject.UserID = UserID(userID)
+	}
+	if role, ok := ctx.Value("user_role").(string); ok {
+		subject.Role = role
+	}
+	return subject
+}
+
+func taskResource(task *Task) Resource {
+	return Resource{Type: "task", ID: task.ID.String(), OwnerID: task.UserID}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Policy inputs are JSON-encoded for OPA, and IDs only decode from UUIDs
+const (
+	ownerUserID = "0c6d5a1e-8f2b-4e3a-9b7c-1d2e3f4a5b6c"
+	otherUserID = "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d"
+)
+
+func TestLocalPolicyEngine(t *testing.T) {
+	engine := NewLocalPolicyEngine()
+	ctx := context.Background()
+
+	owner := Subject{UserID: ownerUserID, Role: "user"}
+	other := Subject{UserID: otherUserID, Role: "user"}
+	admin := Subject{UserID: "admin-1", Role: "admin"}
+	task := Resource{Type: "task", ID: "task-1", OwnerID: ownerUserID}
+
+	tests := []struct {
+		name     string
+		subject  Subject
+		action   Action
+		expected bool
+	}{
+		{"owner can read", owner, ActionRead, true},
+		{"owner can update", owner, ActionUpdate, true},
+		{"owner can delete", owner, ActionDelete, true},
+		{"other user cannot read", other, ActionRead, false},
+		{"other user cannot delete", other, ActionDelete, false},
+		{"admin cannot read", admin, ActionRead, false},
+		{"admin cannot update", admin, ActionUpdate, false},
+		{"anonymous is denied", Subject{}, ActionRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := engine.Authorize(ctx, tt.subject, tt.action, task)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, allowed)
+		})
+	}
+}
+
+func TestAdminReadRule(t *testing.T) {
+	engine := NewLocalPolicyEngine(append(DefaultPolicyRules(), AdminReadRule)...)
+	admin := Subject{UserID: otherUserID, Role: RoleAdmin}
+	task := Resource{Type: "task", ID: "task-1", OwnerID: ownerUserID}
+
+	for action, expected := range map[Action]bool{
+		ActionRead:   true,
+		ActionList:   true,
+		ActionUpdate: false,
+		ActionDelete: false,
+	} {
+		allowed, err := engine.Authorize(context.Background(), admin, action, task)
+		require.NoError(t, err)
+		assert.Equal(t, expected, allowed, action)
+	}
+}
+
+func TestCollaboratorRule(t *testing.T) {
+	engine := NewLocalPolicyEngine()
+	collaborator := Subject{UserID: otherUserID, Role: "user"}
+
+	tests := []struct {
+		access   string
+		action   Action
+		expected bool
+	}{
+		{ShareRead, ActionRead, true},
+		{ShareRead, ActionUpdate, false},
+		{ShareWrite, ActionRead, true},
+		{ShareWrite, ActionUpdate, true},
+		{ShareWrite, ActionDelete, false},
+		{ShareWrite, ActionShare, false},
+		{"", ActionRead, false},
+	}
+
+	for _, tt := range tests {
+		resource := Resource{Type: "task", ID: "task-1", OwnerID: ownerUserID, SharedAccess: tt.access}
+		allowed, err := engine.Authorize(context.Background(), collaborator, tt.action, resource)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, allowed, "%q share, %s", tt.access, tt.action)
+	}
+}
+
+func TestLocalPolicyEngine_DenyWins(t *testing.T) {
+	denyDeletes := func(subject Subject, action Action, resource Resource) Effect {
+		if action == ActionDelete {
+			return EffectDeny
+		}
+		return EffectAbstain
+	}
+	engine := NewLocalPolicyEngine(OwnerRule, denyDeletes)
+
+	subject := Subject{UserID: ownerUserID}
+	resource := Resource{Type: "task", OwnerID: ownerUserID}
+
+	allowed, err := engine.Authorize(context.Background(), subject, ActionUpdate, resource)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = engine.Authorize(context.Background(), subject, ActionDelete, resource)
+	require.NoError(t, err)
+	assert.False(t, allowed, "a deny rule should override the owner allow")
+}
+
+func TestOPAPolicyEngine(t *testing.T) {
+	var received opaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/taskapi/authz/allow", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		allowed := received.Input.Subject.UserID == received.Input.Resource.OwnerID
+		json.NewEncoder(w).Encode(map[string]bool{"result": allowed})
+	}))
+	defer server.Close()
+
+	engine := NewOPAPolicyEngine(server.URL, "taskapi/authz/allow")
+	resource := Resource{Type: "task", ID: "task-1", OwnerID: ownerUserID}
+
+	allowed, err := engine.Authorize(context.Background(), Subject{UserID: ownerUserID}, ActionRead, resource)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, ActionRead, received.Input.Action)
+	assert.Equal(t, "task", received.Input.Resource.Type)
+
+	allowed, err = engine.Authorize(context.Background(), Subject{UserID: otherUserID}, ActionRead, resource)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestOPAPolicyEngine_UndefinedResultDenies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	engine := NewOPAPolicyEngine(server.URL, "taskapi/authz/allow")
+	allowed, err := engine.Authorize(context.Background(), Subject{UserID: ownerUserID}, ActionRead, Resource{Type: "task"})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestOPAPolicyEngine_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	engine := NewOPAPolicyEngine(server.URL, "taskapi/authz/allow")
+	_, err := engine.Authorize(context.Background(), Subject{UserID: ownerUserID}, ActionRead, Resource{Type: "task"})
+	assert.Error(t, err)
+}
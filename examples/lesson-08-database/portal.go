@@ -0,0 +1,256 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// The developer portal at /portal is a single page served from the binary,
+// like the device login page. It renders the endpoint list of GET /api and
+// talks to the API from the browser with the developer's own token:
+//
+//   - sign in with email and password (the token is kept in sessionStorage)
+//   - list, create and revoke API keys (/api/users/me/api-keys)
+//   - a request console with the Authorization header pre-filled; every
+//     endpoint has a "Try it" button that loads it into the console
+//
+// The page's script is inline and allowed by a per-response CSP nonce, so no
+// other script can run on it.
+
+type portalPageData struct {
+	Nonce     string
+	Version   string
+	Endpoints []APIEndpoint
+	Scopes    []string
+}
+
+var portalPageTemplate = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Developer Portal - Task API</title>
+<style nonce="{{.Nonce}}">
+  body { font-family: sans-serif; max-width: 60rem; margin: 2rem auto; }
+  section { margin-bottom: 2rem; }
+  table { border-collapse: collapse; width: 100%; }
+  td, th { text-align: left; padding: .2rem .5rem; border-bottom: 1px solid #ddd; }
+  textarea, pre { width: 100%; font-family: monospace; }
+  pre { background: #f6f6f6; padding: .5rem; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+  <h1>Task API developer portal</h1>
+  <p>Version {{.Version}} &middot; <a href="/api">API index</a> &middot; <a href="/api/openapi.json">OpenAPI</a></p>
+
+  <section>
+    <h2>Sign in</h2>
+    <form id="login">
+      <label>Email <input name="email" type="email" required></label>
+      <label>Password <input name="password" type="password" required></label>
+      <button type="submit">Sign in</button>
+      <button type="button" id="logout">Sign out</button>
+    </form>
+    <p id="session">Not signed in.</p>
+  </section>
+
+  <section>
+    <h2>API keys</h2>
+    <form id="create-key">
+      <label>Name <input name="keyName" required></label>
+      {{range .Scopes}}<label><input type="checkbox" name="scopes" value="{{.}}" checked> {{.}}</label> {{end}}
+      <label>Expires in days <input name="expiresInDays" type="number" min="0" value="90"></label>
+      <button type="submit">Create key</button>
+    </form>
+    <pre id="new-key" hidden></pre>
+    <table id="keys"><thead><tr><th>Name</th><th>Scopes</th><th>Last used</th><th>Expires</th><th></th></tr></thead><tbody></tbody></table>
+  </section>
+
+  <section>
+    <h2>Console</h2>
+    <form id="console">
+      <select name="httpMethod">
+        <option>GET</option><option>POST</option><option>PUT</option><option>DELETE</option>
+      </select>
+      <input name="path" size="50" value="/api/tasks">
+      <button type="submit">Send</button>
+      <p><label>Headers<textarea name="headers" rows="3"></textarea></label></p>
+      <p><label>Body<textarea name="body" rows="6"></textarea></label></p>
+    </form>
+    <pre id="response">No request sent yet.</pre>
+  </section>
+
+  <section>
+    <h2>Endpoints</h2>
+    <table>
+      <thead><tr><th>Method</th><th>Path</th><th>Auth</th><th></th></tr></thead>
+      <tbody>
+      {{range .Endpoints}}
+        <tr>
+          <td>{{.Method}}</td><td><code>{{.Path}}</code></td>
+          <td>{{.Auth}}{{with .Scope}} ({{.}}){{end}}{{with .Role}}, {{.}} only{{end}}</td>
+          <td><button type="button" class="try" data-method="{{.Method}}" data-path="{{.Path}}">Try it</button></td>
+        </tr>
+      {{end}}
+      </tbody>
+    </table>
+  </section>
+
+<script nonce="{{.Nonce}}">
+const tokenKey = "portal.token";
+const $ = (selector) => document.querySelector(selector);
+
+// Responses may be wrapped in the {data, meta, links} envelope
+function unwrap(body) {
+  return body && body.data !== undefined && body.meta !== undefined ? body.data : body;
+}
+
+function authHeaders() {
+  const token = sessionStorage.getItem(tokenKey);
+  return token ? "Authorization: Bearer " + token : "";
+}
+
+async function call(method, path, body) {
+  // The portal reads camelCase whatever the server's default field case is
+  const init = { method, headers: { "Content-Type": "application/json", "X-Field-Case": "camel" } };
+  const token = sessionStorage.getItem(tokenKey);
+  if (token) init.headers["Authorization"] = "Bearer " + token;
+  if (body !== undefined) init.body = JSON.stringify(body);
+  const response = await fetch(path, init);
+  const text = await response.text();
+  let json = null;
+  try { json = JSON.parse(text); } catch (e) {}
+  if (!response.ok) throw new Error((json && json.message) || response.statusText);
+  return unwrap(json);
+}
+
+function showSession(email) {
+  $("#session").textContent = email ? "Signed in as " + email + "." : "Not signed in.";
+  $("#console").headers.value = authHeaders();
+  if (email) loadKeys();
+}
+
+$("#login").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    const login = await call("POST", "/api/auth/login", { email: form.email.value, password: form.password.value });
+    sessionStorage.setItem(tokenKey, login.token);
+    sessionStorage.setItem(tokenKey + ".email", login.user.email);
+    form.password.value = "";
+    showSession(login.user.email);
+  } catch (error) {
+    $("#session").textContent = "Sign in failed: " + error.message;
+  }
+});
+
+$("#logout").addEventListener("click", () => {
+  sessionStorage.clear();
+  $("#keys tbody").replaceChildren();
+  showSession(null);
+});
+
+async function loadKeys() {
+  const rows = [];
+  try {
+    const list = await call("GET", "/api/users/me/api-keys");
+    for (const key of list.apiKeys) {
+      const row = document.createElement("tr");
+      for (const value of [key.name, key.scopes.join(" "), key.lastUsedAt || "never", key.expiresAt || "never"]) {
+        const cell = document.createElement("td");
+        cell.textContent = value;
+        row.append(cell);
+      }
+      const revoke = document.createElement("button");
+      revoke.textContent = "Revoke";
+      revoke.addEventListener("click", async () => {
+        await call("DELETE", "/api/users/me/api-keys/" + encodeURIComponent(key.id));
+        loadKeys();
+      });
+      const cell = document.createElement("td");
+      cell.append(revoke);
+      row.append(cell);
+      rows.push(row);
+    }
+  } catch (error) {
+    $("#session").textContent = "Could not load API keys: " + error.message;
+  }
+  $("#keys tbody").replaceChildren(...rows);
+}
+
+$("#create-key").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  const scopes = [...form.querySelectorAll("input[name=scopes]:checked")].map((input) => input.value);
+  try {
+    const created = await call("POST", "/api/users/me/api-keys",
+      { name: form.keyName.value, scopes, expiresInDays: Number(form.expiresInDays.value) });
+    $("#new-key").hidden = false;
+    $("#new-key").textContent = "Copy the key now, it is not shown again:\n" + created.key;
+    form.keyName.value = "";
+    loadKeys();
+  } catch (error) {
+    $("#new-key").hidden = false;
+    $("#new-key").textContent = "Could not create the key: " + error.message;
+  }
+});
+
+$("#console").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = event.target;
+  const headers = {};
+  for (const line of form.headers.value.split("\n")) {
+    const colon = line.indexOf(":");
+    if (colon > 0) headers[line.slice(0, colon).trim()] = line.slice(colon + 1).trim();
+  }
+  const init = { method: form.httpMethod.value, headers };
+  if (form.body.value.trim() !== "" && init.method !== "GET") {
+    init.body = form.body.value;
+    headers["Content-Type"] = headers["Content-Type"] || "application/json";
+  }
+  const started = performance.now();
+  try {
+    const response = await fetch(form.path.value, init);
+    let text = await response.text();
+    try { text = JSON.stringify(JSON.parse(text), null, 2); } catch (e) {}
+    const lines = [response.status + " " + response.statusText + " (" + Math.round(performance.now() - started) + " ms)"];
+    response.headers.forEach((value, name) => lines.push(name + ": " + value));
+    $("#response").textContent = lines.join("\n") + "\n\n" + text;
+  } catch (error) {
+    $("#response").textContent = "Request failed: " + error.message;
+  }
+});
+
+for (const button of document.querySelectorAll("button.try")) {
+  button.addEventListener("click", () => {
+    const form = $("#console");
+    form.httpMethod.value = button.dataset.method;
+    form.path.value = button.dataset.path;
+    form.headers.value = authHeaders();
+    form.scrollIntoView();
+  });
+}
+
+showSession(sessionStorage.getItem(tokenKey + ".email"));
+</script>
+</body>
+</html>`))
+
+// Portal handles GET /portal
+func (h *Handler) Portal(w http.ResponseWriter, r *http.Request) {
+	nonce, err := generateSecret(16)
+	if err != nil {
+		http.Error(w, "Failed to render the portal", http.StatusInternalServerError)
+		return
+	}
+	data := portalPageData{Nonce: nonce, Version: serviceVersion, Scopes: supportedScopes}
+	if h.apiIndex != nil {
+		data.Endpoints = h.apiIndex.Endpoints
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'self'; script-src 'nonce-"+nonce+"'; style-src 'nonce-"+nonce+"'; frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	noStorePolicy.Apply(w.Header())
+	portalPageTemplate.Execute(w, data)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortal(t *testing.T) {
+	h := &Handler{apiIndex: &APIIndex{Endpoints: []APIEndpoint{
+		{Method: "GET", Path: "/api/tasks/{id}", Auth: "bearer", Scope: ScopeTasksRead},
+		{Method: "GET", Path: "/api/admin/audit", Auth: "bearer", Role: RoleAdmin},
+	}}}
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Portal(w, httptest.NewRequest(http.MethodGet, "/portal", nil))
+		return w
+	}
+	w := serve()
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `data-path="/api/tasks/{id}"`)
+	assert.Contains(t, body, "bearer (tasks:read)")
+	assert.Contains(t, body, "admin only")
+	assert.Contains(t, body, `value="tasks:write"`)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "no-store")
+
+	// The inline script is the only one the CSP allows
+	nonce := regexp.MustCompile(`script-src 'nonce-([0-9a-f]+)'`).FindStringSubmatch(w.Header().Get("Content-Security-Policy"))
+	require.Len(t, nonce, 2)
+	assert.Contains(t, body, `<script nonce="`+nonce[1]+`">`)
+	assert.NotContains(t, serve().Body.String(), nonce[1], "nonces are per response")
+}
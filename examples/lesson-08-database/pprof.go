@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// Profiles of the running server, for the slow endpoints load_test.go finds:
+// CPU, heap, goroutines, blocking and execution traces from net/http/pprof.
+// They reveal the server's internals and a CPU profile costs while it runs,
+// so the API serves them to admins only. PPROF_ADDR serves them on a port
+// of their own instead, without authentication, for a port that only
+// operators can reach.
+
+// newPprofHandler serves the profiles under /debug/pprof/. The package's
+// handlers on http.DefaultServeMux are never served.
+func newPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// newPprofServer serves the profiles on addr. It has no write timeout, so
+// CPU profiles and traces can run for as long as asked.
+func newPprofServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           newPprofHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
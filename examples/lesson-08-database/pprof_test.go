@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPprofHandler(t *testing.T) {
+	handler := newPprofHandler()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/debug/pprof/")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine")
+
+	w = get("/debug/pprof/goroutine?debug=1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "TestPprofHandler")
+
+	assert.Equal(t, http.StatusNotFound, get("/debug/pprof/unknown").Code)
+}
+
+func TestPprofRequiresAdmin(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "profiler@example.com")
+	adminToken := env.createTestAdminToken(t)
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	get := func(router http.Handler, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/debug/pprof/heap?debug=1", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(router, ""))
+	assert.Equal(t, http.StatusForbidden, get(router, user.Token))
+	assert.Equal(t, http.StatusOK, get(router, adminToken))
+
+	// Served on a port of their own instead
+	config := loadConfig()
+	config.PprofAddr = "localhost:6060"
+	router, err = newRouter(config, env.handler, env.db)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, get(router, adminToken))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"respond"
+)
+
+// PATCH /api/tasks/{id}/priority changes only the priority, for the quick
+// pickers of list views that shouldn't have to send a whole update. It
+// follows the same rules as PUT /api/tasks/{id}: update access, If-Match,
+// a revision in the task's history and a purge of cached copies.
+
+type UpdatePriorityRequest struct {
+	Priority string `json:"priority" enum:"priority"`
+}
+
+// UpdateTaskPriority handles PATCH /api/tasks/{id}/priority
+func (h *Handler) UpdateTaskPriority(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionUpdate)
+	if !ok {
+		return
+	}
+	if !h.checkIfMatchPresent(w, r) || !h.checkPreconditions(w, r, taskValidators(task)) {
+		return
+	}
+
+	var req UpdatePriorityRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !priorityEnum.Valid(req.Priority) {
+		h.respondWithEnumError(w, priorityEnum, req.Priority)
+		return
+	}
+
+	if req.Priority != task.Priority {
+		before := *task
+		task.Priority = req.Priority
+		if err := h.applyRulesOnWrite(r.Context(), task); err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to update task")
+			return
+		}
+		if err := h.taskRepo.Update(r.Context(), task); err != nil {
+			h.respondWithTaskWriteError(w, err, "Failed to update task")
+			return
+		}
+		h.recordTaskRevision(r, &before, task)
+		h.cacheInvalidator.Invalidate(h.taskCacheKeys(r.Context(), task)...)
+
+		var err error
+		if task, err = h.taskRepo.GetByID(r.Context(), task.ID); err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to get updated task")
+			return
+		}
+		h.webhooks.Publish(r, task.UserID, WebhookTaskUpdated, newTaskResponse(task))
+	}
+
+	taskValidators(task).SetHeaders(w.Header())
+	h.respond(w, r, http.StatusOK, taskPayload(r, newTaskResponse(task)))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateTaskPriority(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	owner := env.registerTestUser(t, "priority@example.com")
+	other := env.registerTestUser(t, "priority-other@example.com")
+
+	req := taskRequest(http.MethodPost, "/api/tasks", owner.Token, `{"title": "Triage", "priority": "low"}`, nil)
+	w := env.serveWithAuth(env.handler.CreateTask, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+
+	vars := map[string]string{"id": task.ID.String()}
+	patch := func(token, body string) *http.Request {
+		return taskRequest(http.MethodPatch, "/api/tasks/"+task.ID.String()+"/priority", token, body, vars)
+	}
+
+	w = env.serveWithAuth(env.handler.UpdateTaskPriority, patch(owner.Token, `{"priority": "urgent"}`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, "urgent", updated.Priority)
+	assert.Equal(t, "Triage", updated.Title)
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+
+	w = env.serveWithAuth(env.handler.UpdateTaskPriority, patch(owner.Token, `{"priority": "someday"}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"allowed":["low","medium","high","urgent"]`)
+
+	w = env.serveWithAuth(env.handler.UpdateTaskPriority, patch(other.Token, `{"priority": "low"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The change is recorded like any other update
+	req = taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/history", owner.Token, "", vars)
+	w = env.serveWithAuth(env.handler.GetTaskHistory, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"urgent"`)
+}
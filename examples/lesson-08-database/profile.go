@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEmailChangeTTL = 24 * time.Hour
+	maxNameLength         = 100
+
+	// EmailChangeInvalid is returned for unknown, expired or superseded
+	// email verification tokens
+	EmailChangeInvalid = "email_change_invalid"
+)
+
+// EmailSender delivers messages to users. This lesson has no mail service,
+// so the default sender only logs them.
+type EmailSender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+type logEmailSender struct{}
+
+func (logEmailSender) Send(ctx context.Context, to, subject, body string) error {
+	slog.InfoContext(ctx, "email", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+// EmailChange is a requested but not yet verified email address. A user has
+// at most one; requesting another replaces it.
+type EmailChange struct {
+	UserID    UserID
+	Email     string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type EmailChangeRepository interface {
+	Save(ctx context.Context, change *EmailChange) error
+	// Get returns the user's pending change, or nil when there is none or
+	// it has expired
+	Get(ctx context.Context, userID UserID) (*EmailChange, error)
+	// Claim deletes and returns the user's pending change if the token
+	// matches and it hasn't expired
+	Claim(ctx context.Context, userID UserID, tokenHash string) (*EmailChange, error)
+	Delete(ctx context.Context, userID UserID) error
+}
+
+type emailChangeRepository struct {
+	db *sql.DB
+}
+
+func NewEmailChangeRepository(db *sql.DB) EmailChangeRepository {
+	return &emailChangeRepository{db: db}
+}
+
+func (r *emailChangeRepository) Save(ctx context.Context, change *EmailChange) error {
+	query := `
+		INSERT INTO email_changes (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, token_hash = EXCLUDED.token_hash,
+		    expires_at = EXCLUDED.expires_at, created_at = CURRENT_TIMESTAMP
+		RETURNING created_at`
+
+	err := conn(ctx, r.db).QueryRowContext(ctx, query,
+		change.UserID, change.Email, change.TokenHash, change.ExpiresAt,
+	).Scan(&change.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save email change: %w", err)
+	}
+	return nil
+}
+
+func (r *emailChangeRepository) Get(ctx context.Context, userID UserID) (*EmailChange, error) {
+	change := &EmailChange{}
+	query := `
+		SELECT user_id, email, token_hash, expires_at, created_at
+		FROM email_changes
+		WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP`
+
+	err := conn(ctx, r.db).QueryRowContext(ctx, query, userID).Scan(
+		&change.UserID, &change.Email, &change.TokenHash, &change.ExpiresAt, &change.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email change: %w", err)
+	}
+	return change, nil
+}
+
+func (r *emailChangeRepository) Claim(ctx context.Context, userID UserID, tokenHash string) (*EmailChange, error) {
+	change := &EmailChange{}
+	query := `
+		DELETE FROM email_changes
+		WHERE user_id = $1 AND token_hash = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id, email, token_hash, expires_at, created_at`
+
+	err := conn(ctx, r.db).QueryRowContext(ctx, query, userID, tokenHash).Scan(
+		&change.UserID, &change.Email, &change.TokenHash, &change.ExpiresAt, &change.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("email change not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim email change: %w", err)
+	}
+	return change, nil
+}
+
+func (r *emailChangeRepository) Delete(ctx context.Context, userID UserID) error {
+	_, err := conn(ctx, r.db).ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, userID)
+	return err
+}
+
+// UpdateProfileRequest changes the fields it is sent; the others are kept.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Email     *string `json:"email"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ProfileResponse is the current user along with an email change waiting
+// for verification, if any.
+type ProfileResponse struct {
+	*User
+	PendingEmail          string     `json:"pendingEmail,omitempty"`
+	PendingEmailExpiresAt *time.Time `json:"pendingEmailExpiresAt,omitempty"`
+}
+
+func (h *Handler) profile(ctx context.Context, user *User) (ProfileResponse, error) {
+	response := ProfileResponse{User: user}
+	change, err := h.emailChangeRepo.Get(ctx, user.ID)
+	if err != nil {
+		return response, err
+	}
+	if change != nil {
+		response.PendingEmail = change.Email
+		response.PendingEmailExpiresAt = &change.ExpiresAt
+	}
+	return response, nil
+}
+
+// Profile Handlers
+func (h *Handler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	response, err := h.profile(r.Context(), user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+// UpdateCurrentUser changes the user's name right away. A new email address
+// only takes effect once it is verified: the user keeps logging in with the
+// old one until the token sent to the new one is confirmed with
+// POST /api/users/me/email/verify, so a typo can't lock them out.
+func (h *Handler) UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	nameChanged := false
+	for _, field := range []struct {
+		name  string
+		value *string
+		dst   *string
+	}{
+		{"firstName", req.FirstName, &user.FirstName},
+		{"lastName", req.LastName, &user.LastName},
+	} {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if value == "" || len(value) > maxNameLength {
+			h.respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("%s must be between 1 and %d characters", field.name, maxNameLength))
+			return
+		}
+		if value != *field.dst {
+			*field.dst = value
+			nameChanged = true
+		}
+	}
+
+	var newEmail string
+	if req.Email != nil {
+		newEmail = strings.TrimSpace(*req.Email)
+		if local, domain, ok := strings.Cut(newEmail, "@"); !ok || local == "" || domain == "" {
+			h.respondWithError(w, http.StatusBadRequest, "email must be an email address")
+			return
+		}
+		if strings.EqualFold(newEmail, user.Email) {
+			// Back to the current address: nothing left to verify
+			if err := h.emailChangeRepo.Delete(r.Context(), user.ID); err != nil {
+				h.respondWithError(w, http.StatusInternalServerError, "Failed to update user")
+				return
+			}
+			newEmail = ""
+		} else if err := h.registration.domainPolicy.Check(newEmail); err != nil {
+			var domainErr *EmailDomainError
+			if errors.As(err, &domainErr) {
+				h.respondWithErrorCode(w, http.StatusForbidden, domainErr.Code, "This email domain is not allowed")
+				return
+			}
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		} else if existing, err := h.userRepo.GetByEmail(r.Context(), newEmail); err == nil && existing != nil {
+			h.respondWithError(w, http.StatusConflict, "User with this email already exists")
+			return
+		}
+	}
+
+	if nameChanged {
+		if err := h.userRepo.Update(r.Context(), user); err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+	}
+
+	if newEmail != "" {
+		if err := h.requestEmailChange(r.Context(), user, newEmail); err != nil {
+			slog.ErrorContext(r.Context(), "email change failed", "user_id", user.ID, "error", err)
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to send the verification email")
+			return
+		}
+		h.recordAudit(r, &AuditEvent{
+			UserID:     user.ID,
+			Action:     AuditEmailChangeRequest,
+			TargetType: "user",
+			TargetID:   user.ID.String(),
+			Metadata:   map[string]interface{}{"email": newEmail},
+		})
+	}
+
+	response, err := h.profile(r.Context(), user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+// requestEmailChange stores a pending change with a fresh token and sends the
+// token to the new address, never the old one: receiving it proves the user
+// controls the new address.
+func (h *Handler) requestEmailChange(ctx context.Context, user *User, email string) error {
+	token, err := generateSecret(24)
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	change := &EmailChange{
+		UserID:    user.ID,
+		Email:     email,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(h.emailChangeTTL),
+	}
+	if err := h.emailChangeRepo.Save(ctx, change); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nconfirm %s as the new email address of your account by sending\n\n"+
+		"  POST /api/users/me/email/verify\n  {\"token\": \"%s\"}\n\nwhile signed in. The token expires at %s.",
+		user.FirstName, email, token, change.ExpiresAt.Format(time.RFC1123))
+	return h.sendEmail(ctx, user.ID, email, "Verify your new email address", body)
+}
+
+// VerifyEmailChange applies a pending email change. The new address counts as
+// verified, since the token could only be read from its inbox.
+func (h *Handler) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Token == "" {
+		h.respondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	var user *User
+	var previousEmail string
+	err := WithTransactionContext(r.Context(), h.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+		change, err := h.emailChangeRepo.Claim(ctx, userID, hashToken(req.Token))
+		if err != nil {
+			return err
+		}
+		if user, err = h.userRepo.GetByID(ctx, userID); err != nil {
+			return err
+		}
+		previousEmail = user.Email
+		user.Email = change.Email
+		user.EmailVerified = true
+		return h.userRepo.Update(ctx, user)
+	})
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "email change not found"):
+			h.respondWithErrorCode(w, http.StatusBadRequest, EmailChangeInvalid,
+				"Verification token is invalid, expired or superseded by a newer change")
+		case strings.Contains(err.Error(), "already exists"):
+			h.respondWithError(w, http.StatusConflict, "User with this email already exists")
+		default:
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to change email")
+		}
+		return
+	}
+
+	h.recordAudit(r, &AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditEmailChange,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Metadata:   map[string]interface{}{"from": previousEmail, "to": user.Email},
+	})
+	h.respondWithJSON(w, http.StatusOK, ProfileResponse{User: user})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+// recordingEmailSender keeps sent emails so tests can read the tokens in them.
+type recordingEmailSender struct {
+	mu   sync.Mutex
+	sent []sentEmail
+}
+
+func (s *recordingEmailSender) Send(ctx context.Context, to, subject, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, sentEmail{to: to, subject: subject, body: body})
+	return nil
+}
+
+var emailTokenPattern = regexp.MustCompile(`"token": "([0-9a-f]+)"`)
+
+func (s *recordingEmailSender) lastToken(t *testing.T, to string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.NotEmpty(t, s.sent)
+	last := s.sent[len(s.sent)-1]
+	require.Equal(t, to, last.to)
+	match := emailTokenPattern.FindStringSubmatch(last.body)
+	require.NotNil(t, match, last.body)
+	return match[1]
+}
+
+func (env *testEnv) profileRequest(handler http.HandlerFunc, method, token string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, "/api/users/me", bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return env.serveWithAuth(handler, req)
+}
+
+func decodeProfile(t *testing.T, w *httptest.ResponseRecorder) ProfileResponse {
+	var profile ProfileResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &profile))
+	return profile
+}
+
+func TestUpdateProfileName(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "profile@example.com")
+
+	w := env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"firstName": "  Ada "})
+	require.Equal(t, http.StatusOK, w.Code)
+	profile := decodeProfile(t, w)
+	assert.Equal(t, "Ada", profile.FirstName)
+	assert.Equal(t, "Test", profile.LastName, "fields left out are kept")
+	assert.Empty(t, profile.PendingEmail)
+
+	w = env.profileRequest(env.handler.GetCurrentUser, http.MethodGet, user.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Ada", decodeProfile(t, w).FirstName)
+
+	w = env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"lastName": ""})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestEmailChangeVerification(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	emails := &recordingEmailSender{}
+	env.handler.emails = emails
+	user := env.registerTestUser(t, "old@example.com")
+	env.registerTestUser(t, "taken@example.com")
+
+	w := env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"email": "taken@example.com"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"email": "new@mailinator.com"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, EmailDomainDisposable, errorCode(t, w))
+
+	// The change waits for verification; the old address still logs in
+	w = env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"email": "new@example.com"})
+	require.Equal(t, http.StatusOK, w.Code)
+	profile := decodeProfile(t, w)
+	assert.Equal(t, "old@example.com", profile.Email)
+	assert.Equal(t, "new@example.com", profile.PendingEmail)
+	assert.NotNil(t, profile.PendingEmailExpiresAt)
+	assert.Equal(t, http.StatusOK, env.postLogin("old@example.com", "Tasks-Pass-2024").Code)
+	firstToken := emails.lastToken(t, "new@example.com")
+
+	// A second request supersedes the first token
+	w = env.profileRequest(env.handler.UpdateCurrentUser, http.MethodPut, user.Token,
+		map[string]string{"email": "newer@example.com"})
+	require.Equal(t, http.StatusOK, w.Code)
+	token := emails.lastToken(t, "newer@example.com")
+
+	verify := func(token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(VerifyEmailRequest{Token: token})
+		req := httptest.NewRequest(http.MethodPost, "/api/users/me/email/verify", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		return env.serveWithAuth(env.handler.VerifyEmailChange, req)
+	}
+
+	w = verify(firstToken)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, EmailChangeInvalid, errorCode(t, w))
+
+	w = verify(token)
+	require.Equal(t, http.StatusOK, w.Code)
+	profile = decodeProfile(t, w)
+	assert.Equal(t, "newer@example.com", profile.Email)
+	assert.True(t, profile.EmailVerified)
+	assert.Empty(t, profile.PendingEmail)
+
+	assert.Equal(t, http.StatusOK, env.postLogin("newer@example.com", "Tasks-Pass-2024").Code)
+	assert.Equal(t, http.StatusUnauthorized, env.postLogin("old@example.com", "Tasks-Pass-2024").Code)
+
+	// Tokens are single use
+	assert.Equal(t, http.StatusBadRequest, verify(token).Code)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is an opaque, long-lived credential exchanged for new access
+// tokens. Only a hash of the token is stored. Tokens issued from the same
+// login share a family so that reuse of a rotated token can revoke them all.
+type RefreshToken struct {
+	ID         string
+	UserID     UserID
+	FamilyID   string
+	TokenHash  string
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	ReplacedBy *string
+	CreatedAt  time.Time
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	Rotate(ctx context.Context, oldID string, replacement *RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeAllForUser(ctx context.Context, userID UserID) error
+}
+
+type refreshTokenRepository struct {
+	db *sql.DB
+}
+
+func NewRefreshTokenRepository(db *sql.DB) RefreshTokenRepository {
+	return &refreshTokenRepository{db: db}
+}
+
+func (r *refreshTokenRepository) Create(ctx context.Context, token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+
+	return r.db.QueryRowContext(ctx, query,
+		token.ID, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt,
+	).Scan(&token.CreatedAt)
+}
+
+func (r *refreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	token := &RefreshToken{}
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, revoked_at, replaced_by, created_at
+		FROM refresh_tokens WHERE token_hash = $1`
+
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash,
+		&token.ExpiresAt, &token.RevokedAt, &token.ReplacedBy, &token.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Rotate revokes the old token and stores its replacement atomically. The
+// revoked_at IS NULL guard makes concurrent refreshes with the same token
+// fail instead of both succeeding.
+func (r *refreshTokenRepository) Rotate(ctx context.Context, oldID string, replacement *RefreshToken) error {
+	return WithTransaction(r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by = $2
+			WHERE id = $1 AND revoked_at IS NULL`,
+			oldID, replacement.ID)
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh token: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("refresh token already used")
+		}
+
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING created_at`,
+			replacement.ID, replacement.UserID, replacement.FamilyID,
+			replacement.TokenHash, replacement.ExpiresAt,
+		).Scan(&replacement.CreatedAt)
+	})
+}
+
+func (r *refreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (r *refreshTokenRepository) RevokeAllForUser(ctx context.Context, userID UserID) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// NewRefreshToken creates a refresh token for the user. The plaintext value
+// is returned to the caller and never stored.
+func (j *JWTService) NewRefreshToken(userID UserID, familyID string) (string, *RefreshToken, error) {
+	plaintext, err := generateSecret(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+
+	return plaintext, &RefreshToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(plaintext),
+		ExpiresAt: time.Now().Add(j.refreshTTL),
+	}, nil
+}
+
+// issueTokenPair creates an access token and a new refresh token family for a
+// freshly authenticated user.
+func (h *Handler) issueTokenPair(ctx context.Context, user *User) (*LoginResponse, error) {
+	accessToken, err := h.jwtService.GenerateToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, record, err := h.jwtService.NewRefreshToken(user.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.refreshTokenRepo.Create(ctx, record); err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.jwtService.accessTTL.Seconds()),
+		User:         *user,
+	}, nil
+}
+
+// RefreshToken exchanges a valid refresh token for a new access token and a
+// rotated refresh token. Presenting an already-rotated token is treated as
+// theft and revokes the whole family.
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.RefreshToken == "" {
+		h.respondWithError(w, http.StatusBadRequest, "Refresh token is required")
+		return
+	}
+
+	stored, err := h.refreshTokenRepo.GetByHash(r.Context(), hashToken(req.RefreshToken))
+	if err != nil {
+		h.respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		if stored.ReplacedBy != nil {
+			slog.WarnContext(r.Context(), "refresh token reuse detected, revoking family", "user_id", stored.UserID, "family_id", stored.FamilyID)
+			h.refreshTokenRepo.RevokeFamily(r.Context(), stored.FamilyID)
+		}
+		h.respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked")
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		h.respondWithError(w, http.StatusUnauthorized, "Refresh token has expired")
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), stored.UserID)
+	if err != nil || !user.IsActive {
+		h.respondWithError(w, http.StatusUnauthorized, "Account is disabled")
+		return
+	}
+
+	refreshToken, replacement, err := h.jwtService.NewRefreshToken(user.ID, stored.FamilyID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate refresh token")
+		return
+	}
+
+	if err := h.refreshTokenRepo.Rotate(r.Context(), stored.ID, replacement); err != nil {
+		if strings.Contains(err.Error(), "already used") {
+			h.respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to rotate refresh token")
+		return
+	}
+
+	accessToken, err := h.jwtService.GenerateToken(user)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.jwtService.accessTTL.Seconds()),
+		User:         *user,
+	})
+}
+
+// Logout invalidates the presented access token and revokes the refresh
+// token family of the given refresh token, or every refresh token of the
+// user when none is given. It needs clients:manage, so an automation
+// credential such as a read-only API key can't end every session of its
+// owner.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if claims, ok := r.Context().Value("token_claims").(*JWTClaims); ok {
+		if err := h.jwtService.RevokeToken(r.Context(), claims); err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to log out")
+			return
+		}
+	}
+
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+	}
+
+	if req.RefreshToken == "" {
+		if err := h.refreshTokenRepo.RevokeAllForUser(r.Context(), userID); err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to log out")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	stored, err := h.refreshTokenRepo.GetByHash(r.Context(), hashToken(req.RefreshToken))
+	if err != nil || stored.UserID != userID {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid refresh token")
+		return
+	}
+
+	if err := h.refreshTokenRepo.RevokeFamily(r.Context(), stored.FamilyID); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
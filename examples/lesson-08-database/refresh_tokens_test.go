@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (env *testEnv) registerTestUser(t *testing.T, email string) LoginResponse {
+	body, _ := json.Marshal(RegisterRequest{
+		Email:     email,
+		Password:  "Tasks-Pass-2024",
+		FirstName: "Refresh",
+		LastName:  "Test",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	env.handler.Register(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func (env *testEnv) refreshTestToken(refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	env.handler.RefreshToken(w, req)
+	return w
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	login := env.registerTestUser(t, "refresh@example.com")
+	require.NotEmpty(t, login.RefreshToken)
+	assert.Equal(t, int(defaultAccessTokenTTL.Seconds()), login.ExpiresIn)
+
+	// First refresh succeeds and rotates the token
+	w := env.refreshTestToken(login.RefreshToken)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var refreshed LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+	assert.NotEmpty(t, refreshed.Token)
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+	assert.Equal(t, login.User.ID, refreshed.User.ID)
+
+	// Reusing the rotated token fails and revokes the whole family
+	w = env.refreshTestToken(login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = env.refreshTestToken(refreshed.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "reuse detection should revoke the newest token too")
+}
+
+func TestRefreshToken_Invalid(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	w := env.refreshTestToken("not-a-real-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = env.refreshTestToken("")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	login := env.registerTestUser(t, "logout@example.com")
+
+	body, _ := json.Marshal(LogoutRequest{RefreshToken: login.RefreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+
+	w := env.serveWithAuth(env.handler.Logout, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = env.refreshTestToken(login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The access token used to log out is rejected immediately
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+
+	w = env.serveWithAuth(env.handler.GetTasks, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLogoutNeedsClientsManage(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	login := env.registerTestUser(t, "logout-key@example.com")
+	created := env.createTestAPIKey(t, login.Token, []string{ScopeTasksRead})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	w := env.serveWithAPIKey(ScopeClientsManage, env.handler.Logout, req, created.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The user's sessions survive
+	w = env.refreshTestToken(login.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//go:embed data/disposable_domains.txt
+var disposableDomainList string
+
+// Email domain error codes
+const (
+	EmailDomainNotAllowed = "email_domain_not_allowed"
+	EmailDomainBlocked    = "email_domain_blocked"
+	EmailDomainDisposable = "email_domain_disposable"
+)
+
+// EmailDomainError is returned when registration is refused because of the
+// email domain. Code is one of the EmailDomain* constants.
+type EmailDomainError struct {
+	Code   string
+	Domain string
+}
+
+func (e *EmailDomainError) Error() string {
+	switch e.Code {
+	case EmailDomainNotAllowed:
+		return fmt.Sprintf("email domain %s is not allowed to register", e.Domain)
+	case EmailDomainDisposable:
+		return fmt.Sprintf("disposable email domain %s is not allowed", e.Domain)
+	default:
+		return fmt.Sprintf("email domain %s is blocked", e.Domain)
+	}
+}
+
+type EmailDomainPolicyConfig struct {
+	// Allowlist switches to corporate mode: only these domains may register
+	Allowlist        []string
+	Denylist         []string
+	BlockDisposable  bool
+	ExtraDisposables []string
+}
+
+var DefaultEmailDomainPolicyConfig = EmailDomainPolicyConfig{
+	BlockDisposable: true,
+}
+
+// EmailDomainPolicy decides which email domains may sign up. Entries match the
+// domain itself and any of its subdomains.
+type EmailDomainPolicy struct {
+	allow      map[string]bool
+	deny       map[string]bool
+	disposable map[string]bool
+}
+
+func NewEmailDomainPolicy(config EmailDomainPolicyConfig) *EmailDomainPolicy {
+	policy := &EmailDomainPolicy{
+		allow:      domainSet(config.Allowlist),
+		deny:       domainSet(config.Denylist),
+		disposable: map[string]bool{},
+	}
+
+	if config.BlockDisposable {
+		policy.disposable = domainSet(parseList(disposableDomainList))
+		for domain := range domainSet(config.ExtraDisposables) {
+			policy.disposable[domain] = true
+		}
+	}
+
+	return policy
+}
+
+// Check returns an *EmailDomainError when the email may not register.
+func (p *EmailDomainPolicy) Check(email string) error {
+	_, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !ok || domain == "" {
+		return &EmailDomainError{Code: EmailDomainNotAllowed, Domain: domain}
+	}
+
+	if len(p.allow) > 0 && !matchesDomain(p.allow, domain) {
+		return &EmailDomainError{Code: EmailDomainNotAllowed, Domain: domain}
+	}
+	if matchesDomain(p.deny, domain) {
+		return &EmailDomainError{Code: EmailDomainBlocked, Domain: domain}
+	}
+	if matchesDomain(p.disposable, domain) {
+		return &EmailDomainError{Code: EmailDomainDisposable, Domain: domain}
+	}
+	return nil
+}
+
+func matchesDomain(set map[string]bool, domain string) bool {
+	for {
+		if set[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok || !strings.Contains(parent, ".") {
+			return false
+		}
+		domain = parent
+	}
+}
+
+func domainSet(domains []string) map[string]bool {
+	set := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			set[domain] = true
+		}
+	}
+	return set
+}
+
+// parseList reads one entry per line, ignoring blank lines and # comments.
+func parseList(list string) []string {
+	var entries []string
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries
+}
+
+// splitList parses a comma-separated config value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// RegistrationService creates user accounts and applies signup rules. When
+// openSignup is false, registering requires an invite.
+type RegistrationService struct {
+	userRepo     UserRepository
+	inviteRepo   InviteRepository
+	passwords    PasswordHasher
+	domainPolicy *EmailDomainPolicy
+	openSignup   bool
+}
+
+func NewRegistrationService(userRepo UserRepository, inviteRepo InviteRepository, passwords PasswordHasher, domainPolicy *EmailDomainPolicy, openSignup bool) *RegistrationService {
+	return &RegistrationService{
+		userRepo:     userRepo,
+		inviteRepo:   inviteRepo,
+		passwords:    passwords,
+		domainPolicy: domainPolicy,
+		openSignup:   openSignup,
+	}
+}
+
+type RegisterOptions struct {
+	Role string
+	// ByAdmin skips the signup restrictions (email domain rules and the
+	// invite requirement) for accounts created by an admin
+	ByAdmin bool
+}
+
+func (s *RegistrationService) Register(ctx context.Context, req RegisterRequest, opts RegisterOptions) (*User, error) {
+	var invite *Invite
+	if req.InviteToken != "" && !opts.ByAdmin {
+		claimed, err := s.claimInvite(ctx, req.InviteToken, req.Email)
+		if err != nil {
+			return nil, err
+		}
+		invite = claimed
+		opts.Role = invite.Role
+	} else if !s.openSignup && !opts.ByAdmin {
+		return nil, &InviteError{Code: InviteRequired}
+	}
+
+	// Invites are issued by admins, so invited users skip the domain rules too
+	if invite == nil && !opts.ByAdmin {
+		if err := s.domainPolicy.Check(req.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := s.createUser(ctx, req, opts.Role)
+	if err != nil {
+		if invite != nil {
+			s.inviteRepo.Release(ctx, invite.ID)
+		}
+		return nil, err
+	}
+
+	if invite != nil {
+		s.inviteRepo.Complete(ctx, invite.ID, user.ID)
+	}
+
+	return user, nil
+}
+
+func (s *RegistrationService) claimInvite(ctx context.Context, token, email string) (*Invite, error) {
+	invite, err := s.inviteRepo.Claim(ctx, hashToken(token))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, &InviteError{Code: InviteInvalid}
+		}
+		return nil, err
+	}
+
+	if invite.Email != "" && !strings.EqualFold(invite.Email, strings.TrimSpace(email)) {
+		s.inviteRepo.Release(ctx, invite.ID)
+		return nil, &InviteError{Code: InviteInvalid}
+	}
+
+	return invite, nil
+}
+
+func (s *RegistrationService) createUser(ctx context.Context, req RegisterRequest, role string) (*User, error) {
+
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if role == "" {
+		role = "user"
+	}
+
+	user := &User{
+		ID:            NewID[userEntity](),
+		Email:         req.Email,
+		PasswordHash:  hashedPassword,
+		FirstName:     req.FirstName,
+		LastName:      req.LastName,
+		Role:          role,
+		IsActive:      true,
+		EmailVerified: false,
+	}
+
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (h *Handler) respondWithPasswordViolations(w http.ResponseWriter, violations []PasswordViolation) {
+	h.respondWithJSON(w, http.StatusBadRequest, ErrorResponse{
+		Error:     http.StatusText(http.StatusBadRequest),
+		Message:   "Password does not meet requirements",
+		Code:      "password_policy",
+		RequestID: requestID(w),
+		Details:   violations,
+	})
+}
+
+func (h *Handler) respondWithRegistrationError(w http.ResponseWriter, err error) {
+	var domainErr *EmailDomainError
+	var inviteErr *InviteError
+	switch {
+	case errors.As(err, &domainErr):
+		h.respondWithErrorCode(w, http.StatusForbidden, domainErr.Code, "Registration is not allowed for this email domain")
+	case errors.As(err, &inviteErr) && inviteErr.Code == InviteRequired:
+		h.respondWithErrorCode(w, http.StatusForbidden, inviteErr.Code, "Registration requires an invite")
+	case errors.As(err, &inviteErr):
+		h.respondWithErrorCode(w, http.StatusBadRequest, inviteErr.Code, "Invite is invalid, expired or already used")
+	case strings.Contains(err.Error(), "already exists"):
+		h.respondWithError(w, http.StatusConflict, "User with this email already exists")
+	default:
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailDomainPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   EmailDomainPolicyConfig
+		email    string
+		expected string
+	}{
+		{"open signup", EmailDomainPolicyConfig{}, "user@example.com", ""},
+		{"disposable blocked", DefaultEmailDomainPolicyConfig, "user@mailinator.com", EmailDomainDisposable},
+		{"disposable subdomain", DefaultEmailDomainPolicyConfig, "user@eu.mailinator.com", EmailDomainDisposable},
+		{"disposable case insensitive", DefaultEmailDomainPolicyConfig, "User@YopMail.com", EmailDomainDisposable},
+		{"disposable allowed when disabled", EmailDomainPolicyConfig{}, "user@mailinator.com", ""},
+		{"extra disposable", EmailDomainPolicyConfig{BlockDisposable: true, ExtraDisposables: []string{"burner.test"}}, "user@burner.test", EmailDomainDisposable},
+		{"denylist", EmailDomainPolicyConfig{Denylist: []string{"competitor.com"}}, "spy@competitor.com", EmailDomainBlocked},
+		{"allowlist match", EmailDomainPolicyConfig{Allowlist: []string{"acme.com"}}, "jo@acme.com", ""},
+		{"allowlist subdomain", EmailDomainPolicyConfig{Allowlist: []string{"acme.com"}}, "jo@eng.acme.com", ""},
+		{"allowlist miss", EmailDomainPolicyConfig{Allowlist: []string{"acme.com"}}, "jo@example.com", EmailDomainNotAllowed},
+		{"allowlist lookalike", EmailDomainPolicyConfig{Allowlist: []string{"acme.com"}}, "jo@notacme.com", EmailDomainNotAllowed},
+		{"missing domain", EmailDomainPolicyConfig{}, "not-an-email", EmailDomainNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewEmailDomainPolicy(tt.config).Check(tt.email)
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var domainErr *EmailDomainError
+			require.ErrorAs(t, err, &domainErr)
+			assert.Equal(t, tt.expected, domainErr.Code)
+		})
+	}
+}
+
+func TestRegister_DisposableEmail(t *testing.T) {
+	handler := &Handler{
+		passwordPolicy: NewPasswordPolicy(DefaultPasswordPolicyConfig, nil),
+		registration:   NewRegistrationService(nil, nil, NewArgon2Hasher(testArgon2Params), NewEmailDomainPolicy(DefaultEmailDomainPolicyConfig), true),
+	}
+
+	body, _ := json.Marshal(RegisterRequest{
+		Email:     "throwaway@mailinator.com",
+		Password:  "Tasks-Pass-2024",
+		FirstName: "Throw",
+		LastName:  "Away",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/register", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handler.Register(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, EmailDomainDisposable, response.Code)
+}
+
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for role, expected := range map[string]int{
+		"admin":  http.StatusOK,
+		"user":   http.StatusForbidden,
+		"client": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/users", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "user_role", role))
+		w := httptest.NewRecorder()
+
+		requireRole("admin")(ok).ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Code, role)
+	}
+
+	// Scoped credentials, such as an admin's API key, never pass
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/users", nil)
+	ctx := context.WithValue(req.Context(), "user_role", "admin")
+	req = req.WithContext(context.WithValue(ctx, "token_scopes", []string{ScopeTasksRead}))
+	w := httptest.NewRecorder()
+	requireRole("admin")(ok).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminCreateUser_BypassesDomainPolicy(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+
+	body, _ := json.Marshal(AdminCreateUserRequest{
+		RegisterRequest: RegisterRequest{
+			Email:     "contractor@mailinator.com",
+			Password:  "Tasks-Pass-2024",
+			FirstName: "Con",
+			LastName:  "Tractor",
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/users", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	env.handler.CreateUser(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var user User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+	assert.Equal(t, "contractor@mailinator.com", user.Email)
+	assert.Equal(t, "user", user.Role)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"correlation"
+	"github.com/google/uuid"
+)
+
+// requestID is the ID of the request w answers, which correlation.Middleware
+// echoes in X-Request-ID before any handler runs: an error body names the
+// same ID as the request's log lines, so a client reporting one leads to the
+// other. Responses written without the middleware, as in handler tests, get
+// an ID of their own.
+func requestID(w http.ResponseWriter) string {
+	if id := w.Header().Get(correlation.RequestIDHeader); id != "" {
+		return id
+	}
+	return newRequestID()
+}
+
+func newRequestID() string {
+	return uuid.New().String()[:8]
+}
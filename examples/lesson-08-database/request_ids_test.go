@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"correlation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorsCarryRequestID(t *testing.T) {
+	h := &Handler{}
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed")
+	})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	serve := func(handler http.Handler, requestID string) (string, ErrorResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+		if requestID != "" {
+			req.Header.Set(correlation.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		correlation.Middleware(handler).ServeHTTP(w, req)
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Header().Get(correlation.RequestIDHeader), response
+	}
+
+	header, response := serve(failing, "")
+	require.NotEmpty(t, header)
+	assert.Equal(t, header, response.RequestID)
+
+	// A caller's ID is kept
+	header, response = serve(failing, "client-req-1")
+	assert.Equal(t, "client-req-1", header)
+	assert.Equal(t, "client-req-1", response.RequestID)
+
+	// Through a middleware that buffers the response, and in its own errors
+	_, response = serve(deadlineMiddleware(time.Second, time.Second)(failing), "client-req-2")
+	assert.Equal(t, "client-req-2", response.RequestID)
+	_, response = serve(deadlineMiddleware(10*time.Millisecond, time.Second)(slow), "client-req-3")
+	assert.Equal(t, DeadlineExceeded, response.Code)
+	assert.Equal(t, "client-req-3", response.RequestID)
+
+	// Without the middleware there is still an ID
+	w := httptest.NewRecorder()
+	failing.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.RequestID)
+}
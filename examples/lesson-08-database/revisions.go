@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FieldChange is the value of a task field before and after an update.
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// TaskRevision records one update of a task: which fields changed, from
+// what to what, and who changed them.
+type TaskRevision struct {
+	ID             string                 `json:"id"`
+	TaskID         TaskID                 `json:"taskId"`
+	ChangedBy      UserID                 `json:"changedBy,omitempty"`
+	ChangedByEmail string                 `json:"changedByEmail,omitempty"`
+	Changes        map[string]FieldChange `json:"changes"`
+	CreatedAt      time.Time              `json:"createdAt"`
+}
+
+type TaskRevisionRepository interface {
+	Create(ctx context.Context, revision *TaskRevision) error
+	// ListByTask returns the task's revisions, newest first
+	ListByTask(ctx context.Context, taskID TaskID) ([]*TaskRevision, error)
+}
+
+type taskRevisionRepository struct {
+	db *sql.DB
+}
+
+func NewTaskRevisionRepository(db *sql.DB) TaskRevisionRepository {
+	return &taskRevisionRepository{db: db}
+}
+
+func (r *taskRevisionRepository) Create(ctx context.Context, revision *TaskRevision) error {
+	changes, err := json.Marshal(revision.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode revision: %w", err)
+	}
+
+	var changedBy interface{}
+	if revision.ChangedBy != "" {
+		changedBy = revision.ChangedBy
+	}
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO task_revisions (task_id, changed_by, changes)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`,
+		revision.TaskID, changedBy, changes,
+	).Scan(&revision.ID, &revision.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record revision: %w", err)
+	}
+	return nil
+}
+
+func (r *taskRevisionRepository) ListByTask(ctx context.Context, taskID TaskID) ([]*TaskRevision, error) {
+	query := `
+		SELECT tr.id, tr.task_id, tr.changed_by, COALESCE(u.email, ''), tr.changes, tr.created_at
+		FROM task_revisions tr
+		LEFT JOIN users u ON u.id = tr.changed_by
+		WHERE tr.task_id = $1
+		ORDER BY tr.created_at DESC, tr.id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []*TaskRevision
+	for rows.Next() {
+		revision := &TaskRevision{}
+		var changes []byte
+		if err := rows.Scan(&revision.ID, &revision.TaskID, &revision.ChangedBy, &revision.ChangedByEmail,
+			&changes, &revision.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		if err := json.Unmarshal(changes, &revision.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode revision: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// diffTask lists the user-editable fields that differ between two versions
+// of a task, keyed by their JSON names.
+func diffTask(before, after *Task) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	track := func(field string, old, new interface{}) {
+		if !reflect.DeepEqual(old, new) {
+			changes[field] = FieldChange{Old: old, New: new}
+		}
+	}
+
+	track("title", before.Title, after.Title)
+	track("description", before.Description, after.Description)
+	track("completed", before.Completed, after.Completed)
+	track("priority", before.Priority, after.Priority)
+	track("dueDate", revisionTime(before.DueDate), revisionTime(after.DueDate))
+	track("location", before.Location, after.Location)
+	track("tags", revisionTags(before.Tags), revisionTags(after.Tags))
+	track("categories", revisionCategories(before.Categories), revisionCategories(after.Categories))
+	return changes
+}
+
+// revisionTime compares due dates by instant, not by *time.Time pointer or
+// monotonic clock reading.
+func revisionTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// revisionTags treats nil and empty tag lists as the same value.
+func revisionTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// revisionCategories records categories by name, sorted, as that is how
+// people recognize them in the history.
+func revisionCategories(categories []Category) []string {
+	names := make([]string, len(categories))
+	for i, category := range categories {
+		names[i] = category.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordTaskRevision stores the changes an update made. Like audit events,
+// a failure to record is logged rather than failing the update that has
+// already been applied.
+func (h *Handler) recordTaskRevision(r *http.Request, before, after *Task) {
+	if h.revisionRepo == nil {
+		return
+	}
+
+	changes := diffTask(before, after)
+	if len(changes) == 0 {
+		return
+	}
+
+	revision := &TaskRevision{
+		TaskID:    after.ID,
+		ChangedBy: subjectFromContext(r.Context()).UserID,
+		Changes:   changes,
+	}
+	if err := h.revisionRepo.Create(context.WithoutCancel(r.Context()), revision); err != nil {
+		slog.ErrorContext(r.Context(), "failed to record task revision", "task_id", after.ID, "error", err)
+	}
+}
+
+// GetTaskHistory lists the changes made to a task, newest first. Anyone who
+// can read the task can see its history.
+func (h *Handler) GetTaskHistory(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForAction(w, r, ActionRead)
+	if !ok {
+		return
+	}
+
+	revisions, err := h.revisionRepo.ListByTask(r.Context(), task.ID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get task history")
+		return
+	}
+
+	revisionList := make([]TaskRevision, len(revisions))
+	for i, revision := range revisions {
+		revisionList[i] = *revision
+	}
+
+	setSurrogateKeys(w, taskSurrogateKey(task.ID))
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"revisions": revisionList,
+		"count":     len(revisionList),
+	})
+}
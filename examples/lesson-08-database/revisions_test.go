@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffTask(t *testing.T) {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	sameDue := due.In(time.FixedZone("CET", 3600))
+	before := &Task{Title: "Draft", Priority: "low", DueDate: &due, Tags: nil}
+	after := &Task{Title: "Draft", Priority: "high", DueDate: &sameDue, Tags: []string{}, Completed: true}
+
+	assert.Equal(t, map[string]FieldChange{
+		"priority":  {Old: "low", New: "high"},
+		"completed": {Old: false, New: true},
+	}, diffTask(before, after))
+
+	after.DueDate = nil
+	assert.Equal(t, FieldChange{Old: "2026-03-01T09:00:00Z", New: nil}, diffTask(before, after)["dueDate"])
+	assert.Empty(t, diffTask(before, before))
+
+	// Categories are compared by name, in any order
+	before.Categories = []Category{{Name: "work"}, {Name: "home"}}
+	reordered := *before
+	reordered.Categories = []Category{{Name: "home"}, {Name: "work"}}
+	assert.Empty(t, diffTask(before, &reordered))
+	reordered.Categories = reordered.Categories[:1]
+	assert.Equal(t, FieldChange{Old: []string{"home", "work"}, New: []string{"home"}}, diffTask(before, &reordered)["categories"])
+}
+
+func TestTaskHistory(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	owner := env.registerTestUser(t, "history-owner@example.com")
+	editor := env.registerTestUser(t, "history-editor@example.com")
+	outsider := env.registerTestUser(t, "history-outsider@example.com")
+
+	task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(),
+		CreateTaskRequest{Title: "Write report", Priority: "medium"}, owner.User.ID)
+	require.NoError(t, err)
+	vars := map[string]string{"id": task.ID.String()}
+	require.Equal(t, http.StatusOK, env.shareTask(owner, task.ID, "history-editor@example.com", ShareWrite).Code)
+
+	update := func(user LoginResponse, body string) {
+		req := taskRequest(http.MethodPut, "/api/tasks/"+task.ID.String(), user.Token, body, vars)
+		require.Equal(t, http.StatusOK, env.serveWithAuth(env.handler.UpdateTask, req).Code)
+	}
+	history := func(user LoginResponse) (int, []TaskRevision) {
+		req := taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/history", user.Token, "", vars)
+		w := env.serveWithAuth(env.handler.GetTaskHistory, req)
+		var response struct {
+			Revisions []TaskRevision `json:"revisions"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Revisions
+	}
+
+	update(owner, `{"title": "Write quarterly report"}`)
+	update(editor, `{"completed": true, "priority": "medium"}`)
+	update(owner, `{"title": "Write quarterly report"}`) // no change, no revision
+
+	code, revisions := history(editor)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, revisions, 2)
+
+	assert.Equal(t, editor.User.ID, revisions[0].ChangedBy)
+	assert.Equal(t, "history-editor@example.com", revisions[0].ChangedByEmail)
+	assert.Equal(t, map[string]FieldChange{"completed": {Old: false, New: true}}, revisions[0].Changes)
+
+	assert.Equal(t, owner.User.ID, revisions[1].ChangedBy)
+	assert.Equal(t, map[string]FieldChange{
+		"title": {Old: "Write report", New: "Write quarterly report"},
+	}, revisions[1].Changes)
+
+	code, _ = history(outsider)
+	assert.Equal(t, http.StatusForbidden, code)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevocationStore records access tokens (by their jti claim) that must be
+// rejected before they expire, e.g. after logout. Entries only need to live
+// as long as the token itself.
+type RevocationStore interface {
+	Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// MemoryRevocationStore keeps revoked token IDs in memory until the tokens
+// expire. It is per-process: run a shared store when scaling out.
+type MemoryRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	now     func() time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryRevocationStore) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if expiresAt.After(s.now()) {
+		s.revoked[tokenID] = expiresAt
+	}
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.revoked[tokenID]
+	if !ok {
+		return false, nil
+	}
+	if !expiresAt.After(s.now()) {
+		delete(s.revoked, tokenID)
+		return false, nil
+	}
+	return true, nil
+}
+
+// pruneLocked drops entries for tokens that have expired anyway.
+func (s *MemoryRevocationStore) pruneLocked() {
+	now := s.now()
+	for id, expiresAt := range s.revoked {
+		if !expiresAt.After(now) {
+			delete(s.revoked, id)
+		}
+	}
+}
+
+// RevokeToken blacklists an access token until its expiry.
+func (j *JWTService) RevokeToken(ctx context.Context, claims *JWTClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	return j.revocations.Revoke(ctx, claims.ID, claims.ExpiresAt.Time)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryRevocationStore()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Revoke(ctx, "token-1", now.Add(time.Minute)))
+
+	revoked, err := store.IsRevoked(ctx, "token-1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, _ = store.IsRevoked(ctx, "token-2")
+	assert.False(t, revoked)
+
+	// Entries are dropped once the token would have expired anyway
+	now = now.Add(2 * time.Minute)
+	revoked, _ = store.IsRevoked(ctx, "token-1")
+	assert.False(t, revoked)
+	assert.Empty(t, store.revoked)
+}
+
+func TestValidateToken_Revoked(t *testing.T) {
+	jwtService := NewJWTService("test-secret")
+	token, err := jwtService.GenerateToken(&User{ID: "user-1", Email: "a@example.com", Role: "user"})
+	require.NoError(t, err)
+
+	claims, err := jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.NotEmpty(t, claims.ID)
+
+	require.NoError(t, jwtService.RevokeToken(context.Background(), claims))
+
+	_, err = jwtService.ValidateToken(token)
+	assert.Error(t, err)
+}
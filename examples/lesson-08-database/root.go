@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// GET /api describes the API to clients that only know its base URL, like
+// the HATEOAS entry point of lesson 1. The endpoint list is generated by
+// walking the router once the routes are registered, so it always matches
+// what is actually served: authentication comes from the route group a
+// route belongs to, scopes from withScope.
+
+const serviceVersion = "1.0.0"
+
+// Route groups are named so the index can tell which middleware applies.
+const (
+	routeGroupProtected = "protected"
+	routeGroupAdmin     = "admin"
+)
+
+// scopedHandler is the handler withScope returns; it keeps the scope so the
+// index can report it.
+type scopedHandler struct {
+	http.Handler
+	scope string
+}
+
+type APIEndpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Auth is "none" or "bearer" (a JWT or an X-API-Key header)
+	Auth  string `json:"auth"`
+	Scope string `json:"scope,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+type LoginLockoutPolicy struct {
+	Threshold int    `json:"threshold"`
+	Duration  string `json:"duration"`
+}
+
+type ChallengePolicy struct {
+	Provider  string `json:"provider"`
+	Threshold int    `json:"threshold"`
+	Window    string `json:"window"`
+}
+
+// RateLimitPolicy lists the limits that apply; request rates themselves are
+// not limited by this service.
+type RateLimitPolicy struct {
+	LoginLockout   LoginLockoutPolicy `json:"loginLockout"`
+	Challenge      *ChallengePolicy   `json:"challenge,omitempty"`
+	GuestTaskLimit int                `json:"guestTaskLimit,omitempty"`
+}
+
+// IDPolicy tells clients how IDs are assigned. The server generates them
+// unless the client sends its own on create; Tradeoffs explains when that
+// is worth it.
+type IDPolicy struct {
+	Format         string   `json:"format"`
+	ClientChosen   []string `json:"clientChosen"`
+	ClientVersions []int    `json:"clientVersions"`
+	OnCollision    string   `json:"onCollision"`
+	Tradeoffs      string   `json:"tradeoffs"`
+}
+
+// apiIDPolicy lists the resources whose create takes an "id".
+var apiIDPolicy = IDPolicy{
+	Format:         "uuid",
+	ClientChosen:   []string{"POST /api/tasks", "POST /api/categories"},
+	ClientVersions: []int{4, 7},
+	OnCollision: "409 with code " + IDTaken + "; details.existing is the resource with the ID when it is the caller's own. " +
+		"IDs of deleted resources can't be reused while their tombstones are kept",
+	Tradeoffs: "Server-generated IDs need no trust in the client and can't collide. " +
+		"Client-chosen IDs let an offline client use an ID before the create reaches the server, " +
+		"and make a retried create find its first attempt instead of creating a copy. " +
+		"In return the client's random generator must be good, and the ID is known to whoever generated it, " +
+		"so it must never be treated as a secret",
+}
+
+type APIIndex struct {
+	Service    string            `json:"service"`
+	Version    string            `json:"version"`
+	Versions   []string          `json:"versions"`
+	Auth       map[string]string `json:"auth"`
+	RateLimits RateLimitPolicy   `json:"rateLimits"`
+	IDs        IDPolicy          `json:"ids"`
+	Endpoints  []APIEndpoint     `json:"endpoints"`
+	Links      map[string]string `json:"_links"`
+}
+
+// walkAPIEndpoints lists the routes below prefix in registration order.
+func walkAPIEndpoints(router *mux.Router, prefix string) ([]APIEndpoint, error) {
+	var endpoints []APIEndpoint
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, prefix) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Path prefixes of subrouters
+			return nil
+		}
+
+		endpoint := APIEndpoint{Path: path, Auth: "none"}
+		for _, ancestor := range ancestors {
+			switch ancestor.GetName() {
+			case routeGroupProtected:
+				endpoint.Auth = "bearer"
+			case routeGroupAdmin:
+				endpoint.Role = RoleAdmin
+			}
+		}
+		if scoped, ok := route.GetHandler().(*scopedHandler); ok {
+			endpoint.Scope = scoped.scope
+		}
+		for _, method := range methods {
+			endpoint.Method = method
+			endpoints = append(endpoints, endpoint)
+		}
+		return nil
+	})
+	return endpoints, err
+}
+
+// NewAPIIndex describes the routes registered on router, which must be
+// complete.
+func NewAPIIndex(router *mux.Router, config Config) (*APIIndex, error) {
+	endpoints, err := walkAPIEndpoints(router, "/api/")
+	if err != nil {
+		return nil, err
+	}
+
+	index := &APIIndex{
+		Service:  "task-api",
+		Version:  serviceVersion,
+		Versions: []string{"/api"},
+		Auth: map[string]string{
+			"bearer":  "Authorization: Bearer <access token> from POST /api/auth/login or POST /api/oauth/token",
+			"apiKey":  "X-API-Key: <key> from POST /api/users/me/api-keys",
+			"refresh": "POST /api/auth/refresh",
+		},
+		RateLimits: RateLimitPolicy{
+			LoginLockout: LoginLockoutPolicy{
+				Threshold: config.LockoutThreshold,
+				Duration:  config.LockoutDuration.String(),
+			},
+		},
+		IDs:       apiIDPolicy,
+		Endpoints: endpoints,
+		Links: map[string]string{
+			"self":    "/api",
+			"health":  "/health",
+			"jwks":    "/.well-known/jwks.json",
+			"enums":   "/api/meta/enums",
+			"openapi": "/api/openapi.json",
+			"portal":  "/portal",
+		},
+	}
+	if config.LegacyAPIV1 {
+		index.Versions = append(index.Versions, "/api/v1")
+	}
+	index.Versions = append(index.Versions, "/api/v2")
+	if config.ChallengeProvider != "off" {
+		index.RateLimits.Challenge = &ChallengePolicy{
+			Provider:  config.ChallengeProvider,
+			Threshold: config.ChallengeThreshold,
+			Window:    challengeRiskWindow.String(),
+		}
+	}
+	if config.GuestMode {
+		index.RateLimits.GuestTaskLimit = config.GuestTaskLimit
+	}
+	if config.DocsURL != "" {
+		index.Links["docs"] = config.DocsURL
+	}
+
+	for name := range requestSchemas {
+		index.Links["schema:"+name] = "/api/schemas/" + name
+	}
+	return index, nil
+}
+
+// APIRoot handles GET /api
+func (h *Handler) APIRoot(w http.ResponseWriter, r *http.Request) {
+	if h.apiIndex == nil {
+		h.respondWithError(w, http.StatusServiceUnavailable, "API index is not available")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, h.apiIndex)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIIndex(t *testing.T) {
+	h := &Handler{}
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	// The same route groups as main
+	router := mux.NewRouter()
+	router.HandleFunc("/health", noop).Methods("GET")
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("", h.APIRoot).Methods("GET")
+	api.HandleFunc("/auth/login", noop).Methods("POST")
+	protected := api.PathPrefix("").Name(routeGroupProtected).Subrouter()
+	protected.Handle("/tasks", withScope(ScopeTasksRead, noop)).Methods("GET")
+	protected.Handle("/tasks", withScope(ScopeTasksWrite, noop)).Methods("POST")
+	protected.HandleFunc("/auth/logout", noop).Methods("POST")
+	admin := protected.PathPrefix("/admin").Name(routeGroupAdmin).Subrouter()
+	admin.HandleFunc("/audit", noop).Methods("GET")
+
+	index, err := NewAPIIndex(router, Config{
+		LockoutThreshold:  5,
+		LockoutDuration:   15 * time.Minute,
+		ChallengeProvider: "off",
+		LegacyAPIV1:       true,
+	})
+	require.NoError(t, err)
+	h.apiIndex = index
+
+	assert.Equal(t, []APIEndpoint{
+		{Method: "POST", Path: "/api/auth/login", Auth: "none"},
+		{Method: "GET", Path: "/api/tasks", Auth: "bearer", Scope: ScopeTasksRead},
+		{Method: "POST", Path: "/api/tasks", Auth: "bearer", Scope: ScopeTasksWrite},
+		{Method: "POST", Path: "/api/auth/logout", Auth: "bearer"},
+		{Method: "GET", Path: "/api/admin/audit", Auth: "bearer", Role: RoleAdmin},
+	}, index.Endpoints)
+	assert.Equal(t, []string{"/api", "/api/v1", "/api/v2"}, index.Versions)
+	assert.Nil(t, index.RateLimits.Challenge)
+	assert.Equal(t, "/api/schemas/create-task", index.Links["schema:create-task"])
+	assert.NotContains(t, index.Links, "docs")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, serviceVersion, body["version"])
+	assert.Equal(t, map[string]interface{}{"threshold": 5.0, "duration": "15m0s"},
+		body["rateLimits"].(map[string]interface{})["loginLockout"])
+	ids := body["ids"].(map[string]interface{})
+	assert.Equal(t, []interface{}{4.0, 7.0}, ids["clientVersions"])
+	assert.Contains(t, ids["clientChosen"], "POST /api/tasks")
+}
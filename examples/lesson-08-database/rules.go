@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"respond"
+)
+
+// Task rules change a user's tasks when a condition holds, such as "raise
+// tasks overdue by 3 days to high" or "pin tasks tagged urgent":
+//
+//	POST /api/rules  {"name": "Escalate", "condition": {"overdueDays": 3},
+//	                  "action": {"type": "raise_priority", "priority": "high"}}
+//	POST /api/rules  {"name": "Pin urgent", "condition": {"tag": "urgent"},
+//	                  "action": {"type": "add_tag", "tag": "pinned"}}
+//
+// Rules apply to a task when it is created or updated, before it is saved,
+// and every ruleEvaluationInterval to all open tasks of users with rules,
+// since tasks become overdue and old without being written. Actions only
+// raise a priority or add a tag, so applying the rules again changes
+// nothing and no rule can undo another.
+const (
+	maxTaskRules           = 50
+	maxRuleNameLength      = 100
+	maxRuleDays            = 3650
+	ruleEvaluationInterval = 15 * time.Minute
+
+	RuleRaisePriority = "raise_priority"
+	RuleAddTag        = "add_tag"
+
+	TaskRuleLimitReached = "task_rule_limit_reached"
+)
+
+// RuleCondition holds when all of its fields that are set do. Completed
+// tasks never match.
+type RuleCondition struct {
+	// OverdueDays matches tasks due more than this many days ago; 0 matches
+	// every overdue task
+	OverdueDays *int `json:"overdueDays,omitempty"`
+	// OlderThanDays matches tasks created more than this many days ago
+	OlderThanDays *int   `json:"olderThanDays,omitempty"`
+	Tag           string `json:"tag,omitempty"`
+	Priority      string `json:"priority,omitempty" enum:"priority"`
+}
+
+// RuleAction is what a rule does to the tasks it matches: raise_priority
+// raises the priority to at least Priority, add_tag adds Tag.
+type RuleAction struct {
+	Type     string `json:"type" enum:"ruleAction"`
+	Priority string `json:"priority,omitempty" enum:"priority"`
+	Tag      string `json:"tag,omitempty"`
+}
+
+type TaskRule struct {
+	ID        string        `json:"id"`
+	UserID    UserID        `json:"-"`
+	Name      string        `json:"name"`
+	Enabled   bool          `json:"enabled"`
+	Condition RuleCondition `json:"condition"`
+	Action    RuleAction    `json:"action"`
+	CreatedAt time.Time     `json:"createdAt"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+// TaskRuleRequest creates, replaces or previews a rule. Enabled defaults to
+// true.
+type TaskRuleRequest struct {
+	Name      string        `json:"name"`
+	Enabled   *bool         `json:"enabled,omitempty"`
+	Condition RuleCondition `json:"condition"`
+	Action    RuleAction    `json:"action"`
+}
+
+// RulePreviewTask is a task a rule would change, and how.
+type RulePreviewTask struct {
+	ID      TaskID                 `json:"id"`
+	Title   string                 `json:"title"`
+	Changes map[string]FieldChange `json:"changes"`
+}
+
+// RulePreviewResponse is the dry run of a rule: how many open tasks meet
+// its condition now, and which of them its action would change.
+type RulePreviewResponse struct {
+	Matched  int               `json:"matched"`
+	Affected []RulePreviewTask `json:"affected"`
+}
+
+// matches reports whether the task meets the condition at now.
+func (c RuleCondition) matches(task *Task, now time.Time) bool {
+	if task.Completed {
+		return false
+	}
+	if c.OverdueDays != nil && (task.DueDate == nil || !now.After(task.DueDate.AddDate(0, 0, *c.OverdueDays))) {
+		return false
+	}
+	if c.OlderThanDays != nil && !now.After(task.CreatedAt.AddDate(0, 0, *c.OlderThanDays)) {
+		return false
+	}
+	if c.Tag != "" && !slices.Contains(task.Tags, c.Tag) {
+		return false
+	}
+	return c.Priority == "" || task.Priority == c.Priority
+}
+
+// apply changes the task as the action says and reports whether anything
+// changed. A task with the most tags allowed gets no more.
+func (a RuleAction) apply(task *Task) bool {
+	switch a.Type {
+	case RuleRaisePriority:
+		if priorityEnum.Index(task.Priority) < priorityEnum.Index(a.Priority) {
+			task.Priority = a.Priority
+			return true
+		}
+	case RuleAddTag:
+		if !slices.Contains(task.Tags, a.Tag) && len(task.Tags) < maxTaskTags {
+			task.Tags = append(slices.Clone(task.Tags), a.Tag)
+			return true
+		}
+	}
+	return false
+}
+
+// applyTaskRules applies the rules in order, each seeing the changes of the
+// ones before, and reports whether the task changed.
+func applyTaskRules(rules []*TaskRule, task *Task, now time.Time) bool {
+	changed := false
+	for _, rule := range rules {
+		if rule.Enabled && rule.Condition.matches(task, now) && rule.Action.apply(task) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// invalidEnum returns the first enumerated field of the request that has a
+// value it doesn't allow, or nil.
+func (req TaskRuleRequest) invalidEnum() (*Enum, string) {
+	if req.Condition.Priority != "" && !priorityEnum.Valid(req.Condition.Priority) {
+		return priorityEnum, req.Condition.Priority
+	}
+	if !ruleActionEnum.Valid(req.Action.Type) {
+		return ruleActionEnum, req.Action.Type
+	}
+	if req.Action.Type == RuleRaisePriority && !priorityEnum.Valid(req.Action.Priority) {
+		return priorityEnum, req.Action.Priority
+	}
+	return nil, ""
+}
+
+// rule validates the request, whose enumerated fields are valid, and
+// returns the rule it describes, with tags normalized like task tags.
+func (req TaskRuleRequest) rule() (*TaskRule, error) {
+	rule := &TaskRule{
+		Name:      strings.TrimSpace(req.Name),
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		Condition: req.Condition,
+		Action:    req.Action,
+	}
+	if rule.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(rule.Name) > maxRuleNameLength {
+		return nil, fmt.Errorf("name can be at most %d characters", maxRuleNameLength)
+	}
+
+	c := &rule.Condition
+	if c.OverdueDays == nil && c.OlderThanDays == nil && c.Tag == "" && c.Priority == "" {
+		return nil, fmt.Errorf("condition needs overdueDays, olderThanDays, tag or priority")
+	}
+	for name, days := range map[string]*int{"overdueDays": c.OverdueDays, "olderThanDays": c.OlderThanDays} {
+		if days != nil && (*days < 0 || *days > maxRuleDays) {
+			return nil, fmt.Errorf("%s must be between 0 and %d", name, maxRuleDays)
+		}
+	}
+	if c.Tag != "" {
+		tag, err := parseRuleTag(c.Tag)
+		if err != nil {
+			return nil, err
+		}
+		c.Tag = tag
+	}
+
+	a := &rule.Action
+	switch a.Type {
+	case RuleRaisePriority:
+		a.Tag = ""
+	case RuleAddTag:
+		tag, err := parseRuleTag(a.Tag)
+		if err != nil {
+			return nil, err
+		}
+		a.Tag, a.Priority = tag, ""
+	}
+	return rule, nil
+}
+
+func parseRuleTag(tag string) (string, error) {
+	tags, err := parseTags([]string{tag})
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("tag cannot be empty")
+	}
+	return tags[0], nil
+}
+
+type TaskRuleRepository interface {
+	Create(ctx context.Context, rule *TaskRule) error
+	Get(ctx context.Context, id string) (*TaskRule, error)
+	// ListByUserID returns the user's rules in the order they apply, oldest
+	// first
+	ListByUserID(ctx context.Context, userID UserID) ([]*TaskRule, error)
+	Update(ctx context.Context, rule *TaskRule) error
+	Delete(ctx context.Context, id string, userID UserID) error
+	// UsersWithRules lists the active users who have an enabled rule
+	UsersWithRules(ctx context.Context) ([]UserID, error)
+}
+
+type taskRuleRepository struct {
+	db *sql.DB
+}
+
+func NewTaskRuleRepository(db *sql.DB) TaskRuleRepository {
+	return &taskRuleRepository{db: db}
+}
+
+func (r *taskRuleRepository) Create(ctx context.Context, rule *TaskRule) error {
+	condition, action, err := encodeRule(rule)
+	if err != nil {
+		return err
+	}
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO task_rules (id, user_id, name, enabled, condition, action)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at, updated_at`,
+		rule.ID, rule.UserID, rule.Name, rule.Enabled, condition, action,
+	).Scan(&rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create rule: %w", err)
+	}
+	return nil
+}
+
+func encodeRule(rule *TaskRule) ([]byte, []byte, error) {
+	condition, err := json.Marshal(rule.Condition)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode rule condition: %w", err)
+	}
+	action, err := json.Marshal(rule.Action)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode rule action: %w", err)
+	}
+	return condition, action, nil
+}
+
+const taskRuleColumns = `id, user_id, name, enabled, condition, action, created_at, updated_at`
+
+func scanTaskRule(row interface{ Scan(...interface{}) error }) (*TaskRule, error) {
+	rule := &TaskRule{}
+	var condition, action []byte
+	err := row.Scan(&rule.ID, &rule.UserID, &rule.Name, &rule.Enabled, &condition, &action,
+		&rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(condition, &rule.Condition); err != nil {
+		return nil, fmt.Errorf("failed to decode rule condition: %w", err)
+	}
+	if err := json.Unmarshal(action, &rule.Action); err != nil {
+		return nil, fmt.Errorf("failed to decode rule action: %w", err)
+	}
+	return rule, nil
+}
+
+func (r *taskRuleRepository) Get(ctx context.Context, id string) (*TaskRule, error) {
+	rule, err := scanTaskRule(r.db.QueryRowContext(ctx, `
+		SELECT `+taskRuleColumns+` FROM task_rules WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("rule not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (r *taskRuleRepository) ListByUserID(ctx context.Context, userID UserID) ([]*TaskRule, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+taskRuleColumns+` FROM task_rules
+		WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []*TaskRule{}
+	for rows.Next() {
+		rule, err := scanTaskRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (r *taskRuleRepository) Update(ctx context.Context, rule *TaskRule) error {
+	condition, action, err := encodeRule(rule)
+	if err != nil {
+		return err
+	}
+	err = r.db.QueryRowContext(ctx, `
+		UPDATE task_rules
+		SET name = $2, enabled = $3, condition = $4, action = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING updated_at`,
+		rule.ID, rule.Name, rule.Enabled, condition, action,
+	).Scan(&rule.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("rule not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	return nil
+}
+
+func (r *taskRuleRepository) Delete(ctx context.Context, id string, userID UserID) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM task_rules WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	return nil
+}
+
+func (r *taskRuleRepository) UsersWithRules(ctx context.Context) ([]UserID, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT DISTINCT r.user_id FROM task_rules r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.enabled AND u.is_active`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users with rules: %w", err)
+	}
+	defer rows.Close()
+
+	var users []UserID
+	for rows.Next() {
+		var userID UserID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %w", err)
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+// applyRulesOnWrite applies the owner's rules to a task about to be saved.
+// Rules belong to the task's owner, so a collaborator's update runs the
+// owner's rules.
+func (h *Handler) applyRulesOnWrite(ctx context.Context, task *Task) error {
+	if h.ruleRepo == nil {
+		return nil
+	}
+	rules, err := h.ruleRepo.ListByUserID(ctx, task.UserID)
+	if err != nil {
+		return err
+	}
+	applyTaskRules(rules, task, time.Now())
+	return nil
+}
+
+// openTasks lists the user's own tasks that rules can match.
+func (h *Handler) openTasks(ctx context.Context, userID UserID) ([]*Task, error) {
+	completed := false
+	return h.taskRepo.GetByUserID(ctx, userID, TaskFilters{Completed: &completed})
+}
+
+// EvaluateTaskRules applies every user's rules to their open tasks and
+// returns how many tasks changed. A task written meanwhile is left for the
+// next run. The changes are recorded in the tasks' history without a user;
+// they don't send webhooks.
+func (h *Handler) EvaluateTaskRules(ctx context.Context) (int, error) {
+	users, err := h.ruleRepo.UsersWithRules(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, userID := range users {
+		rules, err := h.ruleRepo.ListByUserID(ctx, userID)
+		if err != nil {
+			return changed, err
+		}
+		tasks, err := h.openTasks(ctx, userID)
+		if err != nil {
+			return changed, err
+		}
+		now := time.Now()
+		for _, task := range tasks {
+			before := *task
+			if !applyTaskRules(rules, task, now) {
+				continue
+			}
+			if err := h.taskRepo.Update(ctx, task); err != nil {
+				if !errors.Is(err, ErrTaskModified) && !strings.Contains(err.Error(), "not found") {
+					slog.ErrorContext(ctx, "failed to apply task rules", "task_id", task.ID, "error", err)
+				}
+				continue
+			}
+			changed++
+			if h.revisionRepo != nil {
+				revision := &TaskRevision{TaskID: task.ID, Changes: diffTask(&before, task)}
+				if err := h.revisionRepo.Create(ctx, revision); err != nil {
+					slog.ErrorContext(ctx, "failed to record task revision", "task_id", task.ID, "error", err)
+				}
+			}
+			h.cacheInvalidator.Invalidate(h.taskCacheKeys(ctx, task)...)
+		}
+	}
+	return changed, nil
+}
+
+// startRuleEvaluation evaluates task rules every interval until ctx is
+// done.
+func (h *Handler) startRuleEvaluation(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed, err := h.EvaluateTaskRules(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "rule evaluation failed", "error", err)
+				}
+				if changed > 0 {
+					slog.InfoContext(ctx, "task rules changed tasks", "count", changed)
+				}
+			}
+		}
+	}()
+}
+
+// GetTaskRules handles GET /api/rules
+func (h *Handler) GetTaskRules(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	rules, err := h.ruleRepo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get rules")
+		return
+	}
+	h.respond(w, r, http.StatusOK, map[string]interface{}{
+		"rules": rules,
+		"count": len(rules),
+	})
+}
+
+// decodeTaskRule reads and validates the rule in the request body.
+func (h *Handler) decodeTaskRule(w http.ResponseWriter, r *http.Request) (*TaskRule, bool) {
+	var req TaskRuleRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	if e, value := req.invalidEnum(); e != nil {
+		h.respondWithEnumError(w, e, value)
+		return nil, false
+	}
+	rule, err := req.rule()
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return rule, true
+}
+
+// CreateTaskRule handles POST /api/rules
+func (h *Handler) CreateTaskRule(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	rule, ok := h.decodeTaskRule(w, r)
+	if !ok {
+		return
+	}
+	rules, err := h.ruleRepo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create rule")
+		return
+	}
+	if len(rules) >= maxTaskRules {
+		h.respondWithErrorCode(w, http.StatusConflict, TaskRuleLimitReached,
+			fmt.Sprintf("A user can have at most %d rules", maxTaskRules))
+		return
+	}
+
+	rule.ID = uuid.New().String()
+	rule.UserID = userID
+	if err := h.ruleRepo.Create(r.Context(), rule); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create rule")
+		return
+	}
+	w.Header().Set("Location", "/api/rules/"+rule.ID)
+	h.respond(w, r, http.StatusCreated, rule)
+}
+
+// userTaskRule loads the rule in the route for its owner, responding 404
+// for other users' rules.
+func (h *Handler) userTaskRule(w http.ResponseWriter, r *http.Request) (*TaskRule, bool) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	id := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(id); err != nil {
+		h.respondWithError(w, http.StatusNotFound, "Rule not found")
+		return nil, false
+	}
+	rule, err := h.ruleRepo.Get(r.Context(), id)
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get rule")
+		return nil, false
+	}
+	if err != nil || rule.UserID != userID {
+		h.respondWithError(w, http.StatusNotFound, "Rule not found")
+		return nil, false
+	}
+	return rule, true
+}
+
+// GetTaskRule handles GET /api/rules/{id}
+func (h *Handler) GetTaskRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.userTaskRule(w, r)
+	if !ok {
+		return
+	}
+	h.respond(w, r, http.StatusOK, rule)
+}
+
+// UpdateTaskRule handles PUT /api/rules/{id}, which replaces the rule.
+func (h *Handler) UpdateTaskRule(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.userTaskRule(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := h.decodeTaskRule(w, r)
+	if !ok {
+		return
+	}
+
+	rule.ID, rule.UserID, rule.CreatedAt = existing.ID, existing.UserID, existing.CreatedAt
+	if err := h.ruleRepo.Update(r.Context(), rule); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to update rule")
+		return
+	}
+	h.respond(w, r, http.StatusOK, rule)
+}
+
+// DeleteTaskRule handles DELETE /api/rules/{id}
+func (h *Handler) DeleteTaskRule(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	rule, ok := h.userTaskRule(w, r)
+	if !ok {
+		return
+	}
+	if err := h.ruleRepo.Delete(r.Context(), rule.ID, userID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Rule not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to delete rule")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewTaskRule handles POST /api/rules/preview, the dry run of the rule
+// in the body. Nothing is saved or changed.
+func (h *Handler) PreviewTaskRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.decodeTaskRule(w, r)
+	if !ok {
+		return
+	}
+	rule.Enabled = true
+	h.previewTaskRule(w, r, rule)
+}
+
+// PreviewSavedTaskRule handles GET /api/rules/{id}/preview, the dry run of
+// a saved rule, whether or not it is enabled.
+func (h *Handler) PreviewSavedTaskRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.userTaskRule(w, r)
+	if !ok {
+		return
+	}
+	rule.Enabled = true
+	h.previewTaskRule(w, r, rule)
+}
+
+// previewTaskRule applies the rule alone to copies of the user's open tasks.
+func (h *Handler) previewTaskRule(w http.ResponseWriter, r *http.Request, rule *TaskRule) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	tasks, err := h.openTasks(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to preview rule")
+		return
+	}
+
+	response := RulePreviewResponse{Affected: []RulePreviewTask{}}
+	now := time.Now()
+	for _, task := range tasks {
+		if !rule.Condition.matches(task, now) {
+			continue
+		}
+		response.Matched++
+		after := *task
+		if rule.Action.apply(&after) {
+			response.Affected = append(response.Affected, RulePreviewTask{
+				ID:      task.ID,
+				Title:   task.Title,
+				Changes: diffTask(task, &after),
+			})
+		}
+	}
+	h.respond(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskRuleMatches(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	days := func(n int) *int { return &n }
+	due := now.AddDate(0, 0, -4)
+	task := &Task{Priority: "low", Tags: []string{"urgent"}, DueDate: &due, CreatedAt: now.AddDate(0, 0, -10)}
+
+	for name, tc := range map[string]struct {
+		condition RuleCondition
+		want      bool
+	}{
+		"overdue by 3 days":    {RuleCondition{OverdueDays: days(3)}, true},
+		"overdue by 5 days":    {RuleCondition{OverdueDays: days(5)}, false},
+		"overdue at all":       {RuleCondition{OverdueDays: days(0)}, true},
+		"older than a week":    {RuleCondition{OlderThanDays: days(7)}, true},
+		"older than a month":   {RuleCondition{OlderThanDays: days(30)}, false},
+		"tagged":               {RuleCondition{Tag: "urgent"}, true},
+		"tagged otherwise":     {RuleCondition{Tag: "later"}, false},
+		"priority and overdue": {RuleCondition{Priority: "low", OverdueDays: days(1)}, true},
+		"priority differs":     {RuleCondition{Priority: "high", OverdueDays: days(1)}, false},
+	} {
+		assert.Equal(t, tc.want, tc.condition.matches(task, now), name)
+	}
+
+	noDueDate := &Task{Priority: "low", CreatedAt: now}
+	assert.False(t, RuleCondition{OverdueDays: days(0)}.matches(noDueDate, now))
+	completed := *task
+	completed.Completed = true
+	assert.False(t, RuleCondition{Tag: "urgent"}.matches(&completed, now))
+}
+
+func TestApplyTaskRules(t *testing.T) {
+	now := time.Now()
+	days := func(n int) *int { return &n }
+	rules := []*TaskRule{
+		{Enabled: true, Condition: RuleCondition{OverdueDays: days(3)}, Action: RuleAction{Type: RuleRaisePriority, Priority: "high"}},
+		// Sees the priority the first rule set
+		{Enabled: true, Condition: RuleCondition{Priority: "high"}, Action: RuleAction{Type: RuleAddTag, Tag: "pinned"}},
+		{Enabled: false, Condition: RuleCondition{Priority: "high"}, Action: RuleAction{Type: RuleAddTag, Tag: "disabled"}},
+	}
+
+	due := now.AddDate(0, 0, -5)
+	task := &Task{Priority: "low", Tags: []string{}, DueDate: &due, CreatedAt: now}
+	assert.True(t, applyTaskRules(rules, task, now))
+	assert.Equal(t, "high", task.Priority)
+	assert.Equal(t, []string{"pinned"}, task.Tags)
+
+	// Applying them again changes nothing
+	assert.False(t, applyTaskRules(rules, task, now))
+
+	// Raising never lowers
+	urgent := &Task{Priority: "urgent", DueDate: &due, CreatedAt: now}
+	applyTaskRules(rules[:1], urgent, now)
+	assert.Equal(t, "urgent", urgent.Priority)
+
+	// A task with the most tags gets no more
+	full := &Task{Priority: "high", CreatedAt: now}
+	for len(full.Tags) < maxTaskTags {
+		full.Tags = append(full.Tags, strings.Repeat("t", len(full.Tags)+1))
+	}
+	assert.False(t, applyTaskRules(rules[1:2], full, now))
+}
+
+func TestTaskRuleRequest(t *testing.T) {
+	days := func(n int) *int { return &n }
+	disabled := false
+
+	rule, err := TaskRuleRequest{
+		Name:      "  Pin urgent ",
+		Enabled:   &disabled,
+		Condition: RuleCondition{Tag: " Urgent"},
+		Action:    RuleAction{Type: RuleAddTag, Tag: "Pinned", Priority: "high"},
+	}.rule()
+	require.NoError(t, err)
+	assert.Equal(t, "Pin urgent", rule.Name)
+	assert.False(t, rule.Enabled)
+	assert.Equal(t, "urgent", rule.Condition.Tag)
+	assert.Equal(t, RuleAction{Type: RuleAddTag, Tag: "pinned"}, rule.Action)
+
+	rule, err = TaskRuleRequest{
+		Name: "Escalate", Condition: RuleCondition{OverdueDays: days(3)}, Action: RuleAction{Type: RuleRaisePriority, Priority: "high"},
+	}.rule()
+	require.NoError(t, err)
+	assert.True(t, rule.Enabled)
+
+	raise := RuleAction{Type: RuleRaisePriority, Priority: "high"}
+	for _, req := range []TaskRuleRequest{
+		{Condition: RuleCondition{Tag: "a"}, Action: raise},
+		{Name: "No condition", Action: raise},
+		{Name: "Negative", Condition: RuleCondition{OverdueDays: days(-1)}, Action: raise},
+		{Name: "Empty tag", Condition: RuleCondition{Tag: "a"}, Action: RuleAction{Type: RuleAddTag, Tag: " "}},
+	} {
+		_, err := req.rule()
+		assert.Error(t, err, req.Name)
+	}
+
+	e, value := TaskRuleRequest{Action: RuleAction{Type: "pin"}}.invalidEnum()
+	assert.Equal(t, ruleActionEnum, e)
+	assert.Equal(t, "pin", value)
+	e, _ = TaskRuleRequest{Action: RuleAction{Type: RuleRaisePriority, Priority: "asap"}}.invalidEnum()
+	assert.Equal(t, priorityEnum, e)
+	e, _ = TaskRuleRequest{Action: raise}.invalidEnum()
+	assert.Nil(t, e)
+}
+
+func TestTaskRules(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.registerTestUser(t, "rules@example.com")
+	other := env.registerTestUser(t, "rules-other@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	createTask := func(body string) Task {
+		w := serve(user.Token, "POST", "/api/tasks", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var task Task
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+		return task
+	}
+
+	overdue := time.Now().AddDate(0, 0, -5).UTC().Format(time.RFC3339)
+	late := createTask(`{"title": "Late", "priority": "low", "dueDate": "` + overdue + `"}`)
+	onTime := createTask(`{"title": "On time", "priority": "low"}`)
+
+	escalate := `{"name": "Escalate", "condition": {"overdueDays": 3}, "action": {"type": "raise_priority", "priority": "high"}}`
+
+	// The dry run shows what the rule would do without doing it
+	w := serve(user.Token, "POST", "/api/rules/preview", escalate)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var preview RulePreviewResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+	assert.Equal(t, 1, preview.Matched)
+	require.Len(t, preview.Affected, 1)
+	assert.Equal(t, late.ID, preview.Affected[0].ID)
+	assert.Equal(t, FieldChange{Old: "low", New: "high"}, preview.Affected[0].Changes["priority"])
+	task, err := env.handler.taskRepo.GetByID(ctx, late.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "low", task.Priority)
+
+	w = serve(user.Token, "POST", "/api/rules", escalate)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var rule TaskRule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rule))
+	assert.Equal(t, "/api/rules/"+rule.ID, w.Header().Get("Location"))
+	w = serve(user.Token, "POST", "/api/rules",
+		`{"name": "Pin urgent", "condition": {"tag": "urgent"}, "action": {"type": "add_tag", "tag": "pinned"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Writes apply the rules before saving
+	pinned := createTask(`{"title": "Urgent", "tags": ["urgent"]}`)
+	assert.Equal(t, []string{"urgent", "pinned"}, pinned.Tags)
+
+	// The scheduled run catches tasks that became overdue
+	changed, err := env.handler.EvaluateTaskRules(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	task, err = env.handler.taskRepo.GetByID(ctx, late.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "high", task.Priority)
+	task, err = env.handler.taskRepo.GetByID(ctx, onTime.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "low", task.Priority)
+	changed, err = env.handler.EvaluateTaskRules(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, changed)
+
+	t.Run("manage", func(t *testing.T) {
+		w := serve(user.Token, "GET", "/api/rules", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"count":2`)
+
+		disabled := `{"name": "Escalate", "enabled": false, "condition": {"overdueDays": 3}, "action": {"type": "raise_priority", "priority": "urgent"}}`
+		w = serve(user.Token, "PUT", "/api/rules/"+rule.ID, disabled)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"enabled":false`)
+		changed, err := env.handler.EvaluateTaskRules(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, changed)
+
+		// Saved rules preview whether or not they are enabled
+		w = serve(user.Token, "GET", "/api/rules/"+rule.ID+"/preview", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"new":"urgent"`)
+
+		assert.Equal(t, http.StatusNotFound, serve(other.Token, "GET", "/api/rules/"+rule.ID, "").Code)
+		assert.Equal(t, http.StatusNotFound, serve(other.Token, "DELETE", "/api/rules/"+rule.ID, "").Code)
+		assert.Equal(t, http.StatusNotFound, serve(user.Token, "GET", "/api/rules/not-a-uuid", "").Code)
+		assert.Equal(t, http.StatusNoContent, serve(user.Token, "DELETE", "/api/rules/"+rule.ID, "").Code)
+		assert.Equal(t, http.StatusNotFound, serve(user.Token, "GET", "/api/rules/"+rule.ID, "").Code)
+
+		w = serve(user.Token, "POST", "/api/rules", `{"name": "Pin", "condition": {"tag": "a"}, "action": {"type": "pin"}}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		w = serve(user.Token, "POST", "/api/rules", `{"name": "Nothing", "action": {"type": "add_tag", "tag": "a"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
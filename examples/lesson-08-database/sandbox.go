@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Sandbox mode (SANDBOX=true) lets client developers try destructive flows
+// against a throwaway copy of the API. The service keeps its data in a
+// schema of its own (SANDBOX_SCHEMA), cloned from the tables of the public
+// schema at startup, seeds it with the same demo data every time and tags
+// every response with X-Environment: sandbox. POST /api/sandbox/reset wipes
+// the schema and seeds it again. See schemas.go for how the schema is
+// copied.
+
+const (
+	defaultSandboxSchema = "sandbox"
+	environmentHeader    = "X-Environment"
+
+	// sandboxDemoPassword signs in every demo user
+	sandboxDemoPassword = "Sandbox-Pass-2024"
+)
+
+// The demo data has fixed IDs, so examples written against one sandbox keep
+// working after a reset.
+var (
+	sandboxUsers = []*User{
+		{ID: "00000000-0000-4000-8000-000000000001", Email: "demo@sandbox.example.com", FirstName: "Demo", LastName: "User", Role: RoleUser},
+		{ID: "00000000-0000-4000-8000-000000000002", Email: "admin@sandbox.example.com", FirstName: "Admin", LastName: "User", Role: RoleAdmin},
+	}
+	sandboxCategories = []*Category{
+		{ID: "00000000-0000-4000-8000-000000000101", Name: "work", Color: "#3B82F6", UserID: "00000000-0000-4000-8000-000000000001"},
+		{ID: "00000000-0000-4000-8000-000000000102", Name: "home", Color: "#10B981", UserID: "00000000-0000-4000-8000-000000000001"},
+	}
+)
+
+// sandboxTask is a demo task; its due date is DueInDays after the reset.
+type sandboxTask struct {
+	Task
+	DueInDays   int
+	CategoryIDs []CategoryID
+	SharedWith  UserID
+}
+
+var sandboxTasks = []sandboxTask{
+	{
+		Task: Task{ID: "00000000-0000-4000-8000-000000000201", Title: "Prepare quarterly report",
+			Description: "Collect the numbers and draft the summary", Priority: "high", Tags: []string{"finance"},
+			UserID: "00000000-0000-4000-8000-000000000001"},
+		DueInDays:   3,
+		CategoryIDs: []CategoryID{"00000000-0000-4000-8000-000000000101"},
+		SharedWith:  "00000000-0000-4000-8000-000000000002",
+	},
+	{
+		Task: Task{ID: "00000000-0000-4000-8000-000000000202", Title: "Review pull requests",
+			Priority: "medium", Tags: []string{"code-review"}, UserID: "00000000-0000-4000-8000-000000000001"},
+		DueInDays:   1,
+		CategoryIDs: []CategoryID{"00000000-0000-4000-8000-000000000101"},
+	},
+	{
+		Task: Task{ID: "00000000-0000-4000-8000-000000000203", Title: "Buy groceries",
+			Priority: "low", Location: "Berlin", UserID: "00000000-0000-4000-8000-000000000001"},
+		CategoryIDs: []CategoryID{"00000000-0000-4000-8000-000000000102"},
+	},
+	{
+		Task: Task{ID: "00000000-0000-4000-8000-000000000204", Title: "Renew passport",
+			Completed: true, Priority: "medium", UserID: "00000000-0000-4000-8000-000000000001"},
+		CategoryIDs: []CategoryID{"00000000-0000-4000-8000-000000000102"},
+	},
+	{
+		Task: Task{ID: "00000000-0000-4000-8000-000000000205", Title: "Rotate signing keys",
+			Priority: "high", Tags: []string{"security"}, UserID: "00000000-0000-4000-8000-000000000002"},
+		DueInDays: 7,
+	},
+}
+
+// Sandbox resets and seeds the sandbox schema.
+type Sandbox struct {
+	mu               sync.Mutex
+	db               *sql.DB
+	schema           string
+	passwords        PasswordHasher
+	userRepo         UserRepository
+	taskRepo         TaskRepository
+	categoryRepo     CategoryRepository
+	collaboratorRepo CollaboratorRepository
+}
+
+func NewSandbox(db *sql.DB, schema string, h *Handler) *Sandbox {
+	return &Sandbox{
+		db:               db,
+		schema:           schema,
+		passwords:        h.passwords,
+		userRepo:         h.userRepo,
+		taskRepo:         h.taskRepo,
+		categoryRepo:     h.categoryRepo,
+		collaboratorRepo: h.collaboratorRepo,
+	}
+}
+
+// SeedIfEmpty seeds a sandbox that has no users, e.g. a new one.
+func (s *Sandbox) SeedIfEmpty(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var empty bool
+	query := fmt.Sprintf("SELECT NOT EXISTS (SELECT 1 FROM %s.users)", pq.QuoteIdentifier(s.schema))
+	if err := s.db.QueryRowContext(ctx, query).Scan(&empty); err != nil {
+		return fmt.Errorf("failed to check sandbox data: %w", err)
+	}
+	if !empty {
+		return nil
+	}
+	return s.seed(ctx, time.Now())
+}
+
+// Reset deletes everything in the sandbox schema and seeds it again.
+func (s *Sandbox) Reset(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tables []string
+	rows, err := s.db.QueryContext(ctx, "SELECT tablename FROM pg_tables WHERE schemaname = $1", s.schema)
+	if err != nil {
+		return fmt.Errorf("failed to list sandbox tables: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		// Qualified, so a reset never touches another schema
+		tables = append(tables, pq.QuoteIdentifier(s.schema)+"."+pq.QuoteIdentifier(table))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(tables) > 0 {
+		if _, err := s.db.ExecContext(ctx, "TRUNCATE "+strings.Join(tables, ", ")+" CASCADE"); err != nil {
+			return fmt.Errorf("failed to clear sandbox: %w", err)
+		}
+	}
+	return s.seed(ctx, time.Now())
+}
+
+func (s *Sandbox) seed(ctx context.Context, now time.Time) error {
+	passwordHash, err := s.passwords.Hash(sandboxDemoPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash demo password: %w", err)
+	}
+	for _, demo := range sandboxUsers {
+		user := *demo
+		user.PasswordHash = passwordHash
+		user.IsActive = true
+		user.EmailVerified = true
+		if err := s.userRepo.Create(ctx, &user); err != nil {
+			return err
+		}
+	}
+	for _, demo := range sandboxCategories {
+		category := *demo
+		if err := s.categoryRepo.Create(ctx, &category); err != nil {
+			return fmt.Errorf("failed to create category: %w", err)
+		}
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	for _, demo := range sandboxTasks {
+		task := demo.Task
+		if demo.DueInDays > 0 {
+			due := today.AddDate(0, 0, demo.DueInDays).Add(17 * time.Hour)
+			task.DueDate = &due
+		}
+		if err := s.taskRepo.Create(ctx, &task); err != nil {
+			return fmt.Errorf("failed to create task: %w", err)
+		}
+		for _, categoryID := range demo.CategoryIDs {
+			if _, err := s.db.ExecContext(ctx,
+				"INSERT INTO task_categories (task_id, category_id) VALUES ($1, $2)", task.ID, categoryID); err != nil {
+				return fmt.Errorf("failed to link category: %w", err)
+			}
+		}
+		if demo.SharedWith != "" {
+			share := &TaskCollaborator{TaskID: task.ID, UserID: demo.SharedWith, Permission: ShareRead, CreatedBy: task.UserID}
+			if err := s.collaboratorRepo.Upsert(ctx, share); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// environmentMiddleware tags every response with the environment it comes
+// from.
+func environmentMiddleware(environment string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(environmentHeader, environment)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type SandboxCredentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type SandboxResetResponse struct {
+	ResetAt time.Time            `json:"resetAt"`
+	Users   []SandboxCredentials `json:"users"`
+}
+
+// ResetSandbox handles POST /api/sandbox/reset. Tokens of accounts created in
+// the sandbox stop working; the demo users keep their IDs, so theirs don't.
+func (h *Handler) ResetSandbox(w http.ResponseWriter, r *http.Request) {
+	if h.sandbox == nil {
+		h.respondWithError(w, http.StatusNotFound, "Sandbox mode is not enabled")
+		return
+	}
+	if err := h.sandbox.Reset(r.Context()); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to reset the sandbox")
+		return
+	}
+
+	response := SandboxResetResponse{ResetAt: time.Now().UTC()}
+	for _, user := range sandboxUsers {
+		response.Users = append(response.Users, SandboxCredentials{Email: user.Email, Password: sandboxDemoPassword, Role: user.Role})
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentMiddleware(t *testing.T) {
+	handler := environmentMiddleware("sandbox")(http.NotFoundHandler())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "sandbox", w.Header().Get(environmentHeader))
+}
+
+func TestResetSandboxDisabled(t *testing.T) {
+	h := &Handler{}
+	w := httptest.NewRecorder()
+	h.ResetSandbox(w, httptest.NewRequest(http.MethodPost, "/api/sandbox/reset", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSandbox(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	// The test's own schema stands in for the sandbox schema
+	h := env.handler
+	h.sandbox = NewSandbox(env.db.DB, env.schema, h)
+	require.NoError(t, h.sandbox.SeedIfEmpty(ctx))
+	require.NoError(t, h.sandbox.SeedIfEmpty(ctx))
+
+	demo := sandboxUsers[0]
+	tasks, err := h.taskRepo.GetByUserID(ctx, demo.ID, TaskFilters{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, tasks, 4)
+
+	// The public schema is untouched
+	var users int
+	require.NoError(t, testBaseDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM public.users").Scan(&users))
+	assert.Zero(t, users)
+
+	// A reset removes what was added and restores what was deleted
+	extra := &Task{ID: NewID[taskEntity](), Title: "Scratch", Priority: "low", UserID: demo.ID}
+	require.NoError(t, h.taskRepo.Create(ctx, extra))
+	require.NoError(t, h.taskRepo.Delete(ctx, tasks[0]))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/sandbox/reset", nil)
+	w := httptest.NewRecorder()
+	h.ResetSandbox(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), sandboxDemoPassword)
+
+	after, err := h.taskRepo.GetByUserID(ctx, demo.ID, TaskFilters{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, after, 4)
+	ids := make([]TaskID, len(after))
+	for i, task := range after {
+		ids[i] = task.ID
+	}
+	assert.Contains(t, ids, tasks[0].ID)
+	assert.NotContains(t, ids, extra.ID)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// JSON Schemas of the request bodies, generated from the structs the
+// handlers decode into, so they can't drift from the code. Clients fetch
+// them from GET /api/schemas/{name} to validate payloads or generate types.
+
+// requestSchemas maps the public schema names to their request structs.
+var requestSchemas = map[string]interface{}{
+	"register":    RegisterRequest{},
+	"login":       LoginRequest{},
+	"create-task": CreateTaskRequest{},
+	"update-task": UpdateTaskRequest{},
+	"priority":    UpdatePriorityRequest{},
+
+	"create-category": CreateCategoryRequest{},
+	"update-category": UpdateCategoryRequest{},
+	"task-categories": SetTaskCategoriesRequest{},
+
+	"task-rule":     TaskRuleRequest{},
+	"working-hours": WorkingHoursRequest{},
+}
+
+// schemaProvider is implemented by types that describe themselves, such as
+// Optional.
+type schemaProvider interface {
+	JSONSchema() map[string]interface{}
+}
+
+var (
+	schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+	timeType           = reflect.TypeOf(time.Time{})
+)
+
+// jsonSchema describes how values of type t are encoded.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(schemaProvider).JSONSchema()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := jsonSchema(field.Type)
+			if enum, ok := enumRegistry[field.Tag.Get("enum")]; ok {
+				property["enum"] = enum.Strings()
+				if enum.Default != "" {
+					property["default"] = enum.Default
+				}
+			}
+			properties[name] = property
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
+
+// GetSchema handles GET /api/schemas/{name}
+func (h *Handler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	request, ok := requestSchemas[name]
+	if !ok {
+		h.respondWithError(w, http.StatusNotFound, "Schema not found")
+		return
+	}
+
+	schema := jsonSchema(reflect.TypeOf(request))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = reflect.TypeOf(request).Name()
+	h.respondWithJSON(w, http.StatusOK, schema)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+// Copies of the database schema. Sandbox mode and the integration tests run
+// on a Postgres schema of their own instead of public: the tables are copied
+// from public and the connection's search_path puts the copy first, so the
+// repositories work unchanged. public stays on the path for functions like
+// uuid_generate_v4(); since every table has a copy, no query reaches the
+// tables in public.
+
+var schemaNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// schemaDatabaseURL points databaseURL, a URL or key=value connection
+// string, at schema.
+func schemaDatabaseURL(databaseURL, schema string) (string, error) {
+	if !schemaNamePattern.MatchString(schema) || schema == "public" {
+		return "", fmt.Errorf("invalid schema name %q", schema)
+	}
+	searchPath := schema + ",public"
+	if u, err := url.Parse(databaseURL); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		query := u.Query()
+		query.Set("search_path", searchPath)
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	return databaseURL + " search_path=" + searchPath, nil
+}
+
+// copyPublicSchema creates schema and copies the tables of the public schema
+// it doesn't have yet, with their indexes, foreign keys and triggers.
+func copyPublicSchema(ctx context.Context, db *sql.DB, schema string) error {
+	return WithTransaction(db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(schema)); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+		// The definitions below are read with names relative to public and
+		// replayed with schema first on the path
+		if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO public"); err != nil {
+			return err
+		}
+
+		var tables []string
+		rows, err := tx.QueryContext(ctx, `
+			SELECT tablename FROM pg_tables WHERE schemaname = 'public'
+			EXCEPT
+			SELECT tablename FROM pg_tables WHERE schemaname = $1
+			ORDER BY 1`, schema)
+		if err != nil {
+			return fmt.Errorf("failed to list tables: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var table string
+			if err := rows.Scan(&table); err != nil {
+				return err
+			}
+			tables = append(tables, table)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(tables) == 0 {
+			return nil
+		}
+
+		statements, err := queryStrings(ctx, tx, `
+			SELECT format('ALTER TABLE %I ADD CONSTRAINT %I %s', c.relname, con.conname, pg_get_constraintdef(con.oid))
+			FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid
+			WHERE con.contype = 'f' AND c.relnamespace = 'public'::regnamespace AND c.relname = ANY($1)
+			UNION ALL
+			SELECT pg_get_triggerdef(t.oid)
+			FROM pg_trigger t JOIN pg_class c ON c.oid = t.tgrelid
+			WHERE NOT t.tgisinternal AND c.relnamespace = 'public'::regnamespace AND c.relname = ANY($1)`,
+			pq.Array(tables))
+		if err != nil {
+			return fmt.Errorf("failed to read table definitions: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+pq.QuoteIdentifier(schema)+", public"); err != nil {
+			return err
+		}
+		for _, table := range tables {
+			quoted := pq.QuoteIdentifier(table)
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (LIKE public.%s INCLUDING ALL)", quoted, quoted)); err != nil {
+				return fmt.Errorf("failed to copy table %s: %w", table, err)
+			}
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to copy table definitions: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
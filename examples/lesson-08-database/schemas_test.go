@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaDatabaseURL(t *testing.T) {
+	got, err := schemaDatabaseURL("postgres://u:p@localhost:5432/taskapi?sslmode=disable", "sandbox")
+	require.NoError(t, err)
+	assert.Equal(t, "postgres://u:p@localhost:5432/taskapi?search_path=sandbox%2Cpublic&sslmode=disable", got)
+
+	got, err = schemaDatabaseURL("host=localhost dbname=taskapi", "demo_1")
+	require.NoError(t, err)
+	assert.Equal(t, "host=localhost dbname=taskapi search_path=demo_1,public", got)
+
+	for _, schema := range []string{"public", "Sandbox", "sandbox; DROP TABLE users", ""} {
+		_, err := schemaDatabaseURL("postgres://localhost/taskapi", schema)
+		assert.Error(t, err, schema)
+	}
+}
@@ -27,6 +27,8 @@ CREATE TABLE users (
     role VARCHAR(50) NOT NULL DEFAULT 'user',
     is_active BOOLEAN NOT NULL DEFAULT true,
     email_verified BOOLEAN NOT NULL DEFAULT false,
+    -- BlobStore key of the user's avatar
+    avatar_key VARCHAR(255),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -39,6 +41,8 @@ CREATE TABLE tasks (
     completed BOOLEAN NOT NULL DEFAULT false,
     priority VARCHAR(20) NOT NULL DEFAULT 'medium',
     due_date TIMESTAMP WITH TIME ZONE,
+    location VARCHAR(255) NOT NULL DEFAULT '',
+    tags TEXT[] NOT NULL DEFAULT '{}',
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
@@ -48,7 +52,9 @@ CREATE TABLE tasks (
 CREATE TABLE categories (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     name VARCHAR(100) NOT NULL,
-    color VARCHAR(7), -- Hex color code
+    color VARCHAR(7) CHECK (color ~ '^#[0-9A-F]{6}$'), -- Hex color code, upper case
+    -- Subcategories of a deleted category become top-level
+    parent_id UUID REFERENCES categories(id) ON DELETE SET NULL CHECK (parent_id <> id),
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
@@ -79,6 +85,9 @@ CREATE TABLE api_keys (
 CREATE INDEX idx_tasks_user_id ON tasks(user_id);
 CREATE INDEX idx_tasks_completed ON tasks(completed);
 CREATE INDEX idx_tasks_created_at ON tasks(created_at);
+CREATE INDEX idx_tasks_user_id_created_at ON tasks(user_id, created_at DESC, id DESC);
+CREATE INDEX idx_tasks_tags ON tasks USING GIN (tags);
+CREATE INDEX idx_categories_parent_id ON categories(parent_id);
 CREATE INDEX idx_users_email ON users(email);
 CREATE INDEX idx_api_keys_key_hash ON api_keys(key_hash);
 CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
@@ -100,4 +109,302 @@ CREATE TRIGGER update_tasks_updated_at BEFORE UPDATE ON tasks
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
 CREATE TRIGGER update_categories_updated_at BEFORE UPDATE ON categories 
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+-- OAuth clients (client-credentials grant)
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_id VARCHAR(64) NOT NULL UNIQUE,
+    secret_hash VARCHAR(255) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth_clients_user_id ON oauth_clients(user_id);
+
+-- Refresh tokens (hashed, rotated on use)
+CREATE TABLE refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id UUID NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    replaced_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+-- Device authorization grant (CLI login)
+CREATE TABLE device_authorizations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_code_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_code VARCHAR(9) NOT NULL UNIQUE,
+    client_name VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'denied', 'consumed')),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    interval_seconds INTEGER NOT NULL DEFAULT 5,
+    last_polled_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Registration invites (single use)
+CREATE TABLE invites (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(50) NOT NULL DEFAULT 'user',
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    used_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Email addresses users asked to change to, applied once verified; at most
+-- one per user
+CREATE TABLE email_changes (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Deleted accounts, deactivated until purge_at and restorable with the
+-- token until then
+CREATE TABLE account_deletions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    requested_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    purge_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_account_deletions_purge_at ON account_deletions(purge_at);
+
+-- External identities (OIDC login)
+CREATE TABLE user_identities (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+-- Asynchronous task enrichment (weather at the task's location)
+CREATE TABLE task_enrichments (
+    task_id UUID PRIMARY KEY REFERENCES tasks(id) ON DELETE CASCADE,
+    location VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'ready', 'failed')),
+    weather JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    fetched_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit log of security-relevant events. No foreign keys: events outlive the
+-- users and tasks they mention.
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(50) NOT NULL DEFAULT '',
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id, created_at);
+CREATE INDEX idx_audit_events_action ON audit_events(action, created_at);
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+
+-- Tasks shared with other users (read or write access)
+CREATE TABLE task_collaborators (
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission VARCHAR(10) NOT NULL CHECK (permission IN ('read', 'write')),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (task_id, user_id)
+);
+
+CREATE INDEX idx_task_collaborators_user_id ON task_collaborators(user_id);
+
+-- Files attached to tasks; the content lives in the configured blob store
+CREATE TABLE task_attachments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size_bytes BIGINT NOT NULL CHECK (size_bytes >= 0),
+    storage_key VARCHAR(512) NOT NULL UNIQUE,
+    uploaded_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_attachments_task_id ON task_attachments(task_id);
+
+-- Changes made to tasks by updates; changes maps field names to old/new values
+CREATE TABLE task_revisions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    changes JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_revisions_task_id ON task_revisions(task_id, created_at DESC);
+
+-- Responses of requests sent with an Idempotency-Key, replayed on retries;
+-- status_code is NULL while the first request is still running
+CREATE TABLE idempotency_keys (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER,
+    headers JSONB,
+    body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, key)
+);
+
+-- Webhooks registered by users; the secret signs deliveries, so it is
+-- stored as is
+CREATE TABLE webhooks (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    events TEXT[] NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhooks_user_id ON webhooks(user_id);
+
+-- One row per event sent to a webhook, with the payload as signed
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event VARCHAR(100) NOT NULL,
+    schema_url VARCHAR(2048) NOT NULL,
+    payload BYTEA NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at DESC);
+
+-- Every attempt at sending a delivery with the receiver's answer, for the
+-- deliveries dashboard
+CREATE TABLE webhook_delivery_attempts (
+    id UUID PRIMARY KEY,
+    delivery_id UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    status_code INTEGER,
+    error TEXT NOT NULL DEFAULT '',
+    latency_ms BIGINT NOT NULL,
+    response_snippet TEXT NOT NULL DEFAULT '',
+    attempted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts(delivery_id, attempted_at DESC);
+
+-- Position of each user's task change log. Every write to the user's tasks
+-- bumps it and keeps its row locked until commit, so the versions of one
+-- user's log commit in order. compacted_version is the latest deletion
+-- compacted out of the log; older sync tokens have to start over
+CREATE TABLE task_sync_versions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    compacted_version BIGINT NOT NULL DEFAULT 0
+);
+
+-- Writes to each user's tasks, for delta sync. A write to several tasks logs
+-- a row per task with the same version; rows of deleted tasks stay
+CREATE TABLE task_change_log (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    task_id UUID NOT NULL,
+    change VARCHAR(10) NOT NULL CHECK (change IN ('created', 'updated', 'deleted')),
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, version, task_id)
+);
+
+-- For compaction, which drops the entries a later one for the task supersedes
+CREATE INDEX idx_task_change_log_task ON task_change_log(user_id, task_id, version);
+
+-- Deleted tasks and categories, for the change feed. visible_to lists the
+-- users a task was shared with when it was deleted. Compacted after the
+-- tombstone retention
+CREATE TABLE tombstones (
+    resource_type VARCHAR(10) NOT NULL CHECK (resource_type IN ('task', 'category')),
+    resource_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    visible_to UUID[] NOT NULL DEFAULT '{}',
+    deleted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (resource_type, resource_id)
+);
+
+CREATE INDEX idx_tombstones_user_id ON tombstones(user_id, deleted_at, resource_id);
+CREATE INDEX idx_tombstones_visible_to ON tombstones USING GIN (visible_to);
+CREATE INDEX idx_tombstones_deleted_at ON tombstones(deleted_at);
+
+-- Rules that change a user's tasks when a condition holds, applied in
+-- created_at order. condition and action are the JSON of RuleCondition and
+-- RuleAction
+CREATE TABLE task_rules (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    condition JSONB NOT NULL,
+    action JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_rules_user_id ON task_rules(user_id, created_at);
+
+-- When each user works, for due date suggestions. Users without a row work
+-- Monday to Friday, 09:00 to 17:00 UTC. start_time and end_time are "15:04"
+-- times in time_zone, an IANA name; the public holidays of locale, such as
+-- DE-BY, are days off, and an empty locale has none
+CREATE TABLE working_hours (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    time_zone VARCHAR(64) NOT NULL,
+    days TEXT[] NOT NULL,
+    start_time VARCHAR(5) NOT NULL,
+    end_time VARCHAR(5) NOT NULL,
+    locale VARCHAR(6) NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Latest outcomes of the integrations without a delivery log of their own,
+-- such as the emails sent to a user; webhooks use their delivery attempts
+CREATE TABLE integration_results (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    last_success_at TIMESTAMP WITH TIME ZONE,
+    last_failure_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, kind)
+);
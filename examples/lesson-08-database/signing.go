@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Tokens are signed with HS256 and the shared JWT secret by default. With an
+// RSA key configured they are signed with RS256 instead and carry a kid
+// header, so other services can verify them from /.well-known/jwks.json
+// without knowing the secret.
+//
+// To rotate, make the new key active and keep the old public key for
+// verification until the tokens it signed have expired.
+
+type signingKey struct {
+	id         string
+	privateKey *rsa.PrivateKey
+}
+
+// keyID derives a stable kid from the public key.
+func keyID(key *rsa.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(key)
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// UseRSAKey makes key the active signing key and returns its kid. The
+// previously active key is kept for verification.
+func (j *JWTService) UseRSAKey(key *rsa.PrivateKey) string {
+	j.keysMu.Lock()
+	defer j.keysMu.Unlock()
+
+	if j.verificationKeys == nil {
+		j.verificationKeys = make(map[string]*rsa.PublicKey)
+	}
+
+	kid := keyID(&key.PublicKey)
+	j.signingKey = &signingKey{id: kid, privateKey: key}
+	j.verificationKeys[kid] = &key.PublicKey
+	return kid
+}
+
+// AddVerificationKey accepts tokens signed by key (e.g. a retired key whose
+// tokens may still be valid) and publishes it in the JWKS.
+func (j *JWTService) AddVerificationKey(key *rsa.PublicKey) string {
+	j.keysMu.Lock()
+	defer j.keysMu.Unlock()
+
+	if j.verificationKeys == nil {
+		j.verificationKeys = make(map[string]*rsa.PublicKey)
+	}
+
+	kid := keyID(key)
+	j.verificationKeys[kid] = key
+	return kid
+}
+
+// RemoveVerificationKey retires a key completely. The active key can't be
+// removed.
+func (j *JWTService) RemoveVerificationKey(kid string) error {
+	j.keysMu.Lock()
+	defer j.keysMu.Unlock()
+
+	if j.signingKey != nil && j.signingKey.id == kid {
+		return fmt.Errorf("cannot remove the active signing key")
+	}
+	delete(j.verificationKeys, kid)
+	return nil
+}
+
+// JWKS returns the public keys tokens may be signed with.
+func (j *JWTService) JWKS() JSONWebKeySet {
+	j.keysMu.RLock()
+	defer j.keysMu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for kid, key := range j.verificationKeys {
+		set.Keys = append(set.Keys, NewJSONWebKey(kid, key))
+	}
+	sort.Slice(set.Keys, func(a, b int) bool { return set.Keys[a].Kid < set.Keys[b].Kid })
+	return set
+}
+
+// signToken signs claims with the active RSA key, or the HMAC secret when no
+// key is configured.
+func (j *JWTService) signToken(claims jwt.Claims) (string, error) {
+	j.keysMu.RLock()
+	key := j.signingKey
+	j.keysMu.RUnlock()
+
+	if key == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(j.secret)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.privateKey)
+}
+
+// verificationKey picks the key for a token by its algorithm and kid. HS256
+// tokens are still accepted after switching to RS256 so existing sessions
+// survive the change.
+func (j *JWTService) verificationKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return j.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+
+		j.keysMu.RLock()
+		defer j.keysMu.RUnlock()
+		if key, ok := j.verificationKeys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// loadSigningKeys configures RS256 from PEM files: the active private key and
+// the public (or private) keys of previous keys still being verified.
+func loadSigningKeys(j *JWTService, privateKeyFile string, previousKeyFiles []string) error {
+	if privateKeyFile == "" {
+		return nil
+	}
+
+	for _, path := range previousKeyFiles {
+		key, err := loadRSAPublicKey(path)
+		if err != nil {
+			return err
+		}
+		j.AddVerificationKey(key)
+	}
+
+	pemBytes, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read signing key: %w", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+	if err != nil {
+		return fmt.Errorf("invalid signing key %s: %w", privateKeyFile, err)
+	}
+	j.UseRSAKey(key)
+	return nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read verification key: %w", err)
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification key %s: %w", path, err)
+	}
+	return &privateKey.PublicKey, nil
+}
+
+// JWKS serves the token verification keys.
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	h.respondWithJSON(w, http.StatusOK, h.jwtService.JWKS())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestRS256TokensVerifyAgainstJWKS(t *testing.T) {
+	jwtService := NewJWTService("test-secret")
+	kid := jwtService.UseRSAKey(testRSAKey(t))
+
+	token, err := jwtService.GenerateToken(&User{ID: "user-1", Email: "rs@example.com", Role: "user"})
+	require.NoError(t, err)
+
+	claims, err := jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+
+	// Another service only needs the published keys
+	keys, err := jwtService.JWKS().RSAPublicKeys()
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		return keys[token.Header["kid"].(string)], nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	require.NoError(t, err)
+	assert.Equal(t, kid, parsed.Header["kid"])
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	jwtService := NewJWTService("test-secret")
+	user := &User{ID: "user-1", Email: "rotate@example.com", Role: "user"}
+
+	hmacToken, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+
+	oldKid := jwtService.UseRSAKey(testRSAKey(t))
+	oldToken, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+
+	newKid := jwtService.UseRSAKey(testRSAKey(t))
+	newToken, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKid, newKid)
+
+	// Tokens from before the switch and the rotation stay valid
+	for _, token := range []string{hmacToken, oldToken, newToken} {
+		_, err := jwtService.ValidateToken(token)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, jwtService.JWKS().Keys, 2)
+
+	require.NoError(t, jwtService.RemoveVerificationKey(oldKid))
+	_, err = jwtService.ValidateToken(oldToken)
+	assert.Error(t, err, "tokens of a retired key are rejected")
+	assert.Len(t, jwtService.JWKS().Keys, 1)
+
+	assert.Error(t, jwtService.RemoveVerificationKey(newKid), "the active key can't be removed")
+}
+
+func TestValidateTokenRejectsUnexpectedAlgorithms(t *testing.T) {
+	jwtService := NewJWTService("test-secret")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, JWTClaims{UserID: "user-1"})
+	unsigned, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	_, err = jwtService.ValidateToken(unsigned)
+	assert.Error(t, err)
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	handler := &Handler{jwtService: NewJWTService("test-secret")}
+	kid := handler.jwtService.UseRSAKey(testRSAKey(t))
+
+	w := httptest.NewRecorder()
+	handler.JWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var set JSONWebKeySet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, kid, set.Keys[0].Kid)
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+}
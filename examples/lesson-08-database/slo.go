@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultSLOTarget            = 0.995
+	defaultSLOWindow            = time.Hour
+	defaultSLOMinRequests       = 100
+	defaultSLOConservativeBelow = 0.1
+
+	// sloBuckets is how many slices a window is kept in; a window rolls
+	// forward one slice at a time
+	sloBuckets = 60
+)
+
+var (
+	sloErrorBudgetRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_error_budget_remaining",
+			Help: "Fraction of the rolling window's error budget left per endpoint; negative once overspent",
+		},
+		[]string{"method", "endpoint"},
+	)
+
+	sloSuccessRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_success_ratio",
+			Help: "Share of requests in the rolling window that did not fail with a 5xx, per endpoint",
+		},
+		[]string{"method", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(sloErrorBudgetRemaining)
+	prometheus.MustRegister(sloSuccessRatio)
+}
+
+// SLOConfig is the objective every /api endpoint is held to.
+type SLOConfig struct {
+	// Target is the share of requests that must succeed, e.g. 0.995
+	Target float64
+	// Window is how far back requests count
+	Window time.Duration
+	// MinRequests is how many requests a window needs before its budget
+	// can be considered exhausted, so a single early failure doesn't
+	MinRequests int
+	// ConservativeBelow is the remaining budget fraction at which load
+	// shedding switches to its conservative limit
+	ConservativeBelow float64
+}
+
+// ErrorBudget is one endpoint's standing in the current window.
+type ErrorBudget struct {
+	Method       string  `json:"method"`
+	Route        string  `json:"route"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	SuccessRatio float64 `json:"successRatio"`
+	// BudgetRemaining is the fraction of allowed errors not yet spent:
+	// 1 with no errors, 0 when the endpoint is exactly at its target
+	BudgetRemaining float64 `json:"budgetRemaining"`
+	Exhausted       bool    `json:"exhausted"`
+}
+
+type SLOReport struct {
+	Target       float64       `json:"target"`
+	Window       string        `json:"window"`
+	Conservative bool          `json:"conservative"`
+	Endpoints    []ErrorBudget `json:"endpoints"`
+}
+
+type sloKey struct {
+	method string
+	route  string
+}
+
+type sloBucket struct {
+	slot     int64
+	requests int64
+	errors   int64
+}
+
+// sloWindow counts requests in sloBuckets slices of the window. A slice is
+// reused, and reset, once the window has moved past it.
+type sloWindow struct {
+	buckets [sloBuckets]sloBucket
+}
+
+func (w *sloWindow) add(slot int64, failed bool) {
+	b := &w.buckets[slot%sloBuckets]
+	if b.slot != slot {
+		*b = sloBucket{slot: slot}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+}
+
+func (w *sloWindow) totals(slot int64) (requests, errors int64) {
+	for _, b := range w.buckets {
+		if b.requests > 0 && slot-b.slot < sloBuckets {
+			requests += b.requests
+			errors += b.errors
+		}
+	}
+	return requests, errors
+}
+
+// SLOTracker records the success ratio of every route over a rolling window
+// and derives how much of its error budget is left: with a 99.5% target,
+// 0.5% of the window's requests may fail. Once any endpoint has less than
+// ConservativeBelow of its budget left, the load shedder goes conservative
+// until every endpoint has recovered, so the instance takes less load
+// rather than failing the remaining budget away.
+type SLOTracker struct {
+	config  SLOConfig
+	shedder *LoadShedder
+	now     func() time.Time
+
+	mu        sync.Mutex
+	windows   map[sloKey]*sloWindow
+	exhausted map[sloKey]bool
+}
+
+func NewSLOTracker(config SLOConfig, shedder *LoadShedder) *SLOTracker {
+	if config.Target <= 0 || config.Target >= 1 {
+		config.Target = defaultSLOTarget
+	}
+	if config.Window <= 0 {
+		config.Window = defaultSLOWindow
+	}
+	// Slices of at least a second
+	if config.Window < sloBuckets*time.Second {
+		config.Window = sloBuckets * time.Second
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaultSLOMinRequests
+	}
+	return &SLOTracker{
+		config:    config,
+		shedder:   shedder,
+		now:       time.Now,
+		windows:   make(map[sloKey]*sloWindow),
+		exhausted: make(map[sloKey]bool),
+	}
+}
+
+func (t *SLOTracker) slot() int64 {
+	return t.now().UnixNano() / int64(t.config.Window/sloBuckets)
+}
+
+// Record counts one finished request. Server errors spend the budget;
+// client errors are the client's problem and count as successes.
+func (t *SLOTracker) Record(method, route string, statusCode int) {
+	key := sloKey{method: method, route: route}
+	slot := t.slot()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	window := t.windows[key]
+	if window == nil {
+		window = &sloWindow{}
+		t.windows[key] = window
+	}
+	window.add(slot, statusCode >= 500)
+	t.update(key, slot)
+	t.applyMode()
+}
+
+// update recomputes one endpoint's budget and gauges. Callers hold t.mu.
+func (t *SLOTracker) update(key sloKey, slot int64) ErrorBudget {
+	requests, errors := t.windows[key].totals(slot)
+	budget := ErrorBudget{
+		Method:          key.method,
+		Route:           key.route,
+		Requests:        requests,
+		Errors:          errors,
+		SuccessRatio:    1,
+		BudgetRemaining: 1,
+	}
+	if requests > 0 {
+		budget.SuccessRatio = 1 - float64(errors)/float64(requests)
+		allowed := (1 - t.config.Target) * float64(requests)
+		budget.BudgetRemaining = 1 - float64(errors)/allowed
+	}
+	budget.Exhausted = requests >= int64(t.config.MinRequests) &&
+		budget.BudgetRemaining < t.config.ConservativeBelow
+
+	if budget.Exhausted {
+		t.exhausted[key] = true
+	} else {
+		delete(t.exhausted, key)
+	}
+	sloErrorBudgetRemaining.WithLabelValues(key.method, key.route).Set(budget.BudgetRemaining)
+	sloSuccessRatio.WithLabelValues(key.method, key.route).Set(budget.SuccessRatio)
+	return budget
+}
+
+// applyMode switches the shedder. Callers hold t.mu.
+func (t *SLOTracker) applyMode() {
+	if t.shedder != nil {
+		t.shedder.SetConservative(len(t.exhausted) > 0)
+	}
+}
+
+// Refresh recomputes every endpoint, so budgets recover as failures age
+// out of the window even while an endpoint gets no traffic.
+func (t *SLOTracker) Refresh() {
+	slot := t.slot()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.windows {
+		t.update(key, slot)
+	}
+	t.applyMode()
+}
+
+// Start refreshes the budgets once per window slice until ctx is done.
+func (t *SLOTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(t.config.Window / sloBuckets)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.Refresh()
+			}
+		}
+	}()
+}
+
+// Report lists every endpoint seen in the window, least budget left first.
+func (t *SLOTracker) Report() SLOReport {
+	slot := t.slot()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := SLOReport{
+		Target:    t.config.Target,
+		Window:    t.config.Window.String(),
+		Endpoints: []ErrorBudget{},
+	}
+	for key := range t.windows {
+		budget := t.update(key, slot)
+		if budget.Requests > 0 {
+			report.Endpoints = append(report.Endpoints, budget)
+		}
+	}
+	t.applyMode()
+	report.Conservative = len(t.exhausted) > 0
+
+	sort.Slice(report.Endpoints, func(i, j int) bool {
+		a, b := report.Endpoints[i], report.Endpoints[j]
+		if a.BudgetRemaining != b.BudgetRemaining {
+			return a.BudgetRemaining < b.BudgetRemaining
+		}
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		return a.Method < b.Method
+	})
+	return report
+}
+
+// Middleware records the status of every request under its route template.
+// It sits inside the load shedder, so requests refused by shedding don't
+// spend the budget of the endpoint they were meant for.
+func (t *SLOTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(ww, r)
+
+		// Nobody saw the outcome of an abandoned request
+		if clientDisconnected(r.Context()) {
+			return
+		}
+		t.Record(r.Method, routeTemplate(r), ww.statusCode)
+	})
+}
+
+// GetSLO reports the error budget of every endpoint.
+func (h *Handler) GetSLO(w http.ResponseWriter, r *http.Request) {
+	h.respondWithJSON(w, http.StatusOK, h.slo.Report())
+}
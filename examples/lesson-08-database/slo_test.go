@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSLOTracker(shedder *LoadShedder) (*SLOTracker, *time.Time) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewSLOTracker(SLOConfig{
+		Target:            0.99,
+		Window:            time.Hour,
+		MinRequests:       100,
+		ConservativeBelow: 0.25,
+	}, shedder)
+	tracker.now = func() time.Time { return now }
+	return tracker, &now
+}
+
+func recordRequests(tracker *SLOTracker, route string, successes, failures int) {
+	for i := 0; i < successes; i++ {
+		tracker.Record(http.MethodGet, route, http.StatusOK)
+	}
+	for i := 0; i < failures; i++ {
+		tracker.Record(http.MethodGet, route, http.StatusInternalServerError)
+	}
+}
+
+func TestSLOTrackerBudget(t *testing.T) {
+	tracker, _ := newTestSLOTracker(nil)
+
+	// 1% of 200 requests may fail; one failure spends half of that
+	recordRequests(tracker, "/api/tasks", 199, 1)
+	tracker.Record(http.MethodGet, "/api/categories", http.StatusNotFound)
+
+	report := tracker.Report()
+	assert.Equal(t, 0.99, report.Target)
+	assert.Equal(t, "1h0m0s", report.Window)
+	require.Len(t, report.Endpoints, 2)
+
+	tasks := report.Endpoints[0]
+	assert.Equal(t, "/api/tasks", tasks.Route)
+	assert.Equal(t, int64(200), tasks.Requests)
+	assert.Equal(t, int64(1), tasks.Errors)
+	assert.InDelta(t, 0.995, tasks.SuccessRatio, 1e-9)
+	assert.InDelta(t, 0.5, tasks.BudgetRemaining, 1e-9)
+	assert.False(t, tasks.Exhausted)
+	assert.InDelta(t, 0.5, testutil.ToFloat64(sloErrorBudgetRemaining.WithLabelValues(http.MethodGet, "/api/tasks")), 1e-9)
+
+	// Client errors don't spend the budget
+	categories := report.Endpoints[1]
+	assert.Equal(t, int64(0), categories.Errors)
+	assert.Equal(t, 1.0, categories.BudgetRemaining)
+}
+
+func TestSLOTrackerTripsLoadShedder(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedConfig{MaxInFlight: 10, ConservativeMaxInFlight: 2})
+	tracker, now := newTestSLOTracker(shedder)
+
+	// Too few requests to judge, however many failed
+	recordRequests(tracker, "/api/tasks/{id}", 0, 50)
+	assert.False(t, shedder.Conservative())
+
+	// 50 failures in 250 requests overspend a 1% budget many times over
+	recordRequests(tracker, "/api/tasks/{id}", 200, 0)
+	assert.True(t, shedder.Conservative())
+	assert.Equal(t, int64(2), shedder.Limit())
+	report := tracker.Report()
+	assert.True(t, report.Conservative)
+	assert.True(t, report.Endpoints[0].Exhausted)
+	assert.Less(t, report.Endpoints[0].BudgetRemaining, 0.0)
+
+	// The failures age out of the window without further traffic
+	*now = now.Add(30 * time.Minute)
+	tracker.Refresh()
+	assert.True(t, shedder.Conservative())
+	*now = now.Add(31 * time.Minute)
+	tracker.Refresh()
+	assert.False(t, shedder.Conservative())
+	assert.Equal(t, int64(10), shedder.Limit())
+	assert.Empty(t, tracker.Report().Endpoints)
+}
+
+func TestSLOTrackerRollingWindow(t *testing.T) {
+	tracker, now := newTestSLOTracker(nil)
+
+	recordRequests(tracker, "/api/tasks", 100, 1)
+	*now = now.Add(40 * time.Minute)
+	recordRequests(tracker, "/api/tasks", 100, 0)
+
+	budget := tracker.Report().Endpoints[0]
+	assert.Equal(t, int64(201), budget.Requests)
+	assert.Equal(t, int64(1), budget.Errors)
+
+	// Only the slices of the last hour count
+	*now = now.Add(21 * time.Minute)
+	budget = tracker.Report().Endpoints[0]
+	assert.Equal(t, int64(100), budget.Requests)
+	assert.Equal(t, int64(0), budget.Errors)
+}
+
+func TestSLOMiddleware(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedConfig{MaxInFlight: 10})
+	tracker, _ := newTestSLOTracker(shedder)
+	h := &Handler{slo: tracker}
+
+	router := mux.NewRouter()
+	router.Use(shedder.Middleware)
+	router.Use(tracker.Middleware)
+	router.HandleFunc("/api/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "broken" {
+			http.Error(w, "boom", http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.HandleFunc("/api/admin/slo", h.GetSLO)
+
+	for _, id := range []string{"1", "2", "broken"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tasks/"+id, nil))
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/slo", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var report SLOReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+
+	// Requests are grouped by route template, not by URL
+	require.Len(t, report.Endpoints, 1)
+	assert.Equal(t, "/api/tasks/{id}", report.Endpoints[0].Route)
+	assert.Equal(t, int64(3), report.Endpoints[0].Requests)
+	assert.Equal(t, int64(1), report.Endpoints[0].Errors)
+}
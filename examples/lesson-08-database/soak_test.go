@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The soak test keeps a steady load on the API for hours and samples the
+// metrics endpoint as it goes. Leaks don't fail a short load test: a
+// goroutine that never stops or a connection that is never returned costs
+// little per request. Over hours they show up as a series that only ever
+// grows, which is what the test fails on.
+//
+//	SOAK_DURATION=2h SOAK_SAMPLE_INTERVAL=1m go test -run TestSoak -timeout 3h -v .
+
+// soakSeries are the metrics sampled during a soak test
+var soakSeries = []string{
+	"go_goroutines",
+	"go_memstats_heap_inuse_bytes",
+	"go_sql_open_connections",
+	"go_sql_in_use_connections",
+}
+
+const (
+	// soakWindows is how many slices a series is cut into; it leaks if the
+	// low of every slice is above the one before
+	soakWindows = 4
+	// soakMinGrowth ignores growth smaller than this fraction of the first
+	// low, e.g. a heap that is still warming up
+	soakMinGrowth = 0.1
+)
+
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping soak test in short mode")
+	}
+	duration := getDurationEnv("SOAK_DURATION", 0)
+	if duration == 0 {
+		t.Skip("Set SOAK_DURATION to run the soak test")
+	}
+	interval := getDurationEnv("SOAK_SAMPLE_INTERVAL", time.Minute)
+	require.GreaterOrEqual(t, duration, 2*soakWindows*interval,
+		"SOAK_DURATION must allow at least %d samples", 2*soakWindows)
+
+	env := newTestEnv(t)
+	ctx, stop := context.WithTimeout(context.Background(), duration)
+	defer stop()
+
+	// The same collectors as the service's /metrics, for this test's pool
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewDBStatsCollector(env.db.DB, env.schema))
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+	updateDatabaseMetrics(ctx, env.db)
+
+	metrics := &LoadTestMetrics{}
+	var wg sync.WaitGroup
+	for i := 0; i < defaultLoadConfig.ConcurrentUsers; i++ {
+		token := env.createTestUserAndGetToken(t, fmt.Sprintf("soak%d@example.com", i))
+		wg.Add(1)
+		go func(userIndex int) {
+			defer wg.Done()
+			for j := 0; ctx.Err() == nil; j++ {
+				env.performTaskCreate(t, token, userIndex, j, metrics)
+				env.performTaskRead(t, token, metrics)
+				env.performTaskUpdate(t, token, metrics)
+			}
+		}(i)
+	}
+
+	samples := make(map[string][]float64)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+sampling:
+	for {
+		select {
+		case <-ctx.Done():
+			break sampling
+		case <-ticker.C:
+			values, err := scrapeMetrics(server.URL, soakSeries...)
+			require.NoError(t, err)
+			for _, name := range soakSeries {
+				samples[name] = append(samples[name], values[name])
+			}
+		}
+	}
+	wg.Wait()
+
+	metrics.Finalize()
+	metrics.Report()
+	assert.Zero(t, metrics.FailedRequests, "No request should fail during the soak test")
+	for _, name := range soakSeries {
+		series := samples[name]
+		t.Logf("%s: %v", name, series)
+		assert.False(t, growsMonotonically(series, soakWindows, soakMinGrowth),
+			"%s grew over the whole soak test, from %v to %v", name, series[0], series[len(series)-1])
+	}
+}
+
+// growsMonotonically reports whether the samples leak: cut into windows
+// slices, the lowest value of each slice is above that of the slice before
+// and the last is more than minGrowth above the first. Comparing lows keeps
+// the garbage collector's sawtooth from hiding or faking a trend.
+func growsMonotonically(samples []float64, windows int, minGrowth float64) bool {
+	size := len(samples) / windows
+	if size == 0 {
+		return false
+	}
+	lows := make([]float64, windows)
+	for i := range lows {
+		lows[i] = samples[i*size]
+		for _, v := range samples[i*size : (i+1)*size] {
+			if v < lows[i] {
+				lows[i] = v
+			}
+		}
+		if i > 0 && lows[i] <= lows[i-1] {
+			return false
+		}
+	}
+	return lows[windows-1] > lows[0]*(1+minGrowth)
+}
+
+// scrapeMetrics fetches a Prometheus text exposition and sums the samples of
+// each of names across their labels.
+func scrapeMetrics(url string, names ...string) (map[string]float64, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to scrape metrics: status %d", resp.StatusCode)
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	values := make(map[string]float64, len(names))
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line[:strings.IndexAny(line, "{ ")]
+		if !wanted[name] {
+			continue
+		}
+		sample := line[len(name):]
+		if strings.HasPrefix(sample, "{") {
+			sample = sample[strings.LastIndex(sample, "}")+1:]
+		}
+		fields := strings.Fields(sample)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample %q: %w", line, err)
+		}
+		values[name] += value
+	}
+	return values, scanner.Err()
+}
+
+func TestGrowsMonotonically(t *testing.T) {
+	// A goroutine leaked every sample, under some noise
+	leaking := []float64{20, 22, 21, 23, 25, 24, 26, 28, 27, 29, 31, 30}
+	assert.True(t, growsMonotonically(leaking, 4, 0.1))
+
+	// Steady with noise, or grew once and stayed there
+	assert.False(t, growsMonotonically([]float64{20, 22, 20, 21, 20, 23, 21, 20, 22, 20, 21, 22}, 4, 0.1))
+	assert.False(t, growsMonotonically([]float64{20, 20, 20, 30, 30, 30, 30, 30, 30, 30, 30, 30}, 4, 0.1))
+
+	// Rising by less than minGrowth
+	assert.False(t, growsMonotonically([]float64{100, 101, 102, 103}, 4, 0.1))
+
+	// Too few samples to tell
+	assert.False(t, growsMonotonically([]float64{1, 2, 3}, 4, 0.1))
+}
+
+func TestScrapeMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 42
+go_sql_open_connections{db_name="a"} 3
+go_sql_open_connections{db_name="b"} 4
+go_sql_open_connections_total 99
+`)
+	}))
+	defer server.Close()
+
+	values, err := scrapeMetrics(server.URL, "go_goroutines", "go_sql_open_connections")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"go_goroutines": 42, "go_sql_open_connections": 7}, values)
+}
+
+func TestUpdateDatabaseMetricsStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	updateDatabaseMetrics(ctx, &Database{})
+	cancel()
+
+	// Eventually polls from a goroutine of its own, so this is a plain loop
+	running := func() bool {
+		buf := make([]byte, 1<<20)
+		return strings.Contains(string(buf[:runtime.Stack(buf, true)]), "updateDatabaseMetrics")
+	}
+	deadline := time.Now().Add(time.Second)
+	for running() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, running(), "updateDatabaseMetrics is still running")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// GET /api/tasks/{id}/suggest-due is a computed sub-resource: nothing is
+// stored, the suggestions are worked out from the caller's working hours
+// and holiday calendar on every request. ?effort= is how much work the task
+// takes (4h unless given) and the suggestions are:
+//
+//	earliest     when the work is done if it starts now, counting only
+//	             working hours
+//	recommended  the end of the working day by which the work is done with
+//	             half of it again as slack, since work rarely goes to plan
+const (
+	defaultSuggestEffort = 4 * time.Hour
+	maxSuggestEffort     = 1000 * time.Hour
+	// suggestSlack is the share of the effort the recommendation adds
+	suggestSlack = 0.5
+	// maxSuggestDays bounds the days scanned for working time, for working
+	// weeks thinned out by holidays
+	maxSuggestDays = 3 * 366
+
+	SuggestEarliest    = "earliest"
+	SuggestRecommended = "recommended"
+)
+
+// errNoWorkingTime means the working hours leave too little time for the
+// effort within maxSuggestDays.
+var errNoWorkingTime = fmt.Errorf("not enough working time within %d days", maxSuggestDays)
+
+// DueSuggestion is a due date the task could have.
+type DueSuggestion struct {
+	Kind    string    `json:"kind"`
+	DueDate time.Time `json:"dueDate"`
+}
+
+// DueSuggestionsResponse is the suggestions for a task. DueDate is the
+// task's current due date; OnTrack tells whether the effort still fits
+// before it.
+type DueSuggestionsResponse struct {
+	TaskID       TaskID          `json:"taskId"`
+	Effort       string          `json:"effort"`
+	WorkingHours *WorkingHours   `json:"workingHours"`
+	Suggestions  []DueSuggestion `json:"suggestions"`
+	DueDate      *time.Time      `json:"dueDate"`
+	OnTrack      *bool           `json:"onTrack,omitempty"`
+}
+
+// workSchedule is working hours ready to compute with.
+type workSchedule struct {
+	location *time.Location
+	days     [7]bool
+	start    time.Duration
+	end      time.Duration
+	calendar CalendarProvider
+}
+
+func newWorkSchedule(hours *WorkingHours, calendar CalendarProvider) (*workSchedule, error) {
+	location, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	s := &workSchedule{location: location, calendar: calendar}
+	for _, day := range hours.Days {
+		if i := slices.Index(weekdayNames, day); i >= 0 {
+			s.days[i] = true
+		}
+	}
+	if s.start, err = parseClock(hours.Start); err != nil {
+		return nil, err
+	}
+	if s.end, err = parseClock(hours.End); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// workingDay returns the working hours of the day at, which are empty when
+// nobody works that day. Times are built from the date rather than added
+// to midnight, so days with a DST change keep their opening hours.
+func (s *workSchedule) workingDay(ctx context.Context, at time.Time) (opening, closing time.Time, err error) {
+	year, month, day := at.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, s.location)
+	if !s.days[midnight.Weekday()] {
+		return midnight, midnight, nil
+	}
+	holiday, err := s.calendar.IsHoliday(ctx, midnight)
+	if err != nil || holiday {
+		return midnight, midnight, err
+	}
+	clock := func(d time.Duration) time.Time {
+		return time.Date(year, month, day, int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, s.location)
+	}
+	return clock(s.start), clock(s.end), nil
+}
+
+// addWorkingTime returns when effort of work started at from is done,
+// counting only working hours, and the end of that working day.
+func (s *workSchedule) addWorkingTime(ctx context.Context, from time.Time, effort time.Duration) (done, dayEnd time.Time, err error) {
+	at := from.In(s.location)
+	for i := 0; i < maxSuggestDays; i++ {
+		opening, closing, err := s.workingDay(ctx, at)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		begin := opening
+		if at.After(opening) {
+			begin = at
+		}
+		if available := closing.Sub(begin); available > 0 {
+			if effort <= available {
+				return begin.Add(effort), closing, nil
+			}
+			effort -= available
+		}
+		year, month, day := at.Date()
+		at = time.Date(year, month, day+1, 0, 0, 0, 0, s.location)
+	}
+	return time.Time{}, time.Time{}, errNoWorkingTime
+}
+
+// suggestDueDates computes the suggestions for effort of work starting now.
+func (s *workSchedule) suggestDueDates(ctx context.Context, now time.Time, effort time.Duration) ([]DueSuggestion, error) {
+	earliest, _, err := s.addWorkingTime(ctx, now, effort)
+	if err != nil {
+		return nil, err
+	}
+	slack := time.Duration(float64(effort) * suggestSlack).Round(time.Minute)
+	_, recommended, err := s.addWorkingTime(ctx, now, effort+slack)
+	if err != nil {
+		return nil, err
+	}
+	return []DueSuggestion{
+		{Kind: SuggestEarliest, DueDate: earliest},
+		{Kind: SuggestRecommended, DueDate: recommended},
+	}, nil
+}
+
+// parseSuggestEffort reads ?effort=, such as 90m or 2h30m.
+func parseSuggestEffort(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultSuggestEffort, nil
+	}
+	effort, err := time.ParseDuration(value)
+	if err != nil || effort < time.Minute {
+		return 0, fmt.Errorf("effort must be a duration of at least 1m, such as 90m or 2h30m")
+	}
+	if effort > maxSuggestEffort {
+		return 0, fmt.Errorf("effort must be at most %v", maxSuggestEffort)
+	}
+	return effort, nil
+}
+
+// SuggestTaskDue handles GET /api/tasks/{id}/suggest-due
+func (h *Handler) SuggestTaskDue(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	effort, err := parseSuggestEffort(r.URL.Query().Get("effort"))
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	task, ok := h.getTaskForAction(w, r, ActionRead)
+	if !ok {
+		return
+	}
+
+	// The caller's working hours: a collaborator plans their own time
+	hours, err := h.workingHoursRepo.Get(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get working hours")
+		return
+	}
+	schedule, err := newWorkSchedule(hours, h.calendarFor(hours))
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to read working hours")
+		return
+	}
+	now := time.Now()
+	suggestions, err := schedule.suggestDueDates(r.Context(), now, effort)
+	if errors.Is(err, errNoWorkingTime) {
+		h.respondWithError(w, http.StatusUnprocessableEntity, "The working hours leave too little time for the effort")
+		return
+	}
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to read the holiday calendar")
+		return
+	}
+
+	response := DueSuggestionsResponse{
+		TaskID:       task.ID,
+		Effort:       effort.String(),
+		WorkingHours: hours,
+		Suggestions:  suggestions,
+		DueDate:      task.DueDate,
+	}
+	if task.DueDate != nil && !task.Completed {
+		onTrack := !suggestions[0].DueDate.After(*task.DueDate)
+		response.OnTrack = &onTrack
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// everyDayOff is a calendar on which nobody ever works.
+type everyDayOff struct{}
+
+func (everyDayOff) IsHoliday(ctx context.Context, day time.Time) (bool, error) { return true, nil }
+
+func TestAddWorkingTime(t *testing.T) {
+	ctx := context.Background()
+	calendar, err := NewFixedCalendar([]string{"2026-12-25"})
+	require.NoError(t, err)
+	schedule, err := newWorkSchedule(defaultWorkingHours(), calendar)
+	require.NoError(t, err)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for name, tc := range map[string]struct {
+		from   time.Time
+		effort time.Duration
+		done   time.Time
+	}{
+		"same day":           {at(10, 14, 10, 0), 4 * time.Hour, at(10, 14, 14, 0)},
+		"until closing":      {at(10, 14, 13, 0), 4 * time.Hour, at(10, 14, 17, 0)},
+		"before opening":     {at(10, 14, 7, 0), 4 * time.Hour, at(10, 14, 13, 0)},
+		"after closing":      {at(10, 14, 18, 0), 90 * time.Minute, at(10, 15, 10, 30)},
+		"over the weekend":   {at(10, 16, 15, 0), 4 * time.Hour, at(10, 19, 11, 0)},
+		"from a saturday":    {at(10, 17, 12, 0), 4 * time.Hour, at(10, 19, 13, 0)},
+		"over a holiday":     {at(12, 24, 16, 0), 4 * time.Hour, at(12, 28, 12, 0)},
+		"several days":       {at(10, 14, 9, 0), 20 * time.Hour, at(10, 16, 13, 0)},
+		"a full working day": {at(10, 14, 9, 0), 8 * time.Hour, at(10, 14, 17, 0)},
+	} {
+		done, dayEnd, err := schedule.addWorkingTime(ctx, tc.from, tc.effort)
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.done, done, name)
+		year, month, day := tc.done.Date()
+		assert.Equal(t, time.Date(year, month, day, 17, 0, 0, 0, time.UTC), dayEnd, name)
+	}
+
+	t.Run("daylight saving time", func(t *testing.T) {
+		// Clocks in Berlin go back on Sunday 2026-10-25
+		hours, err := WorkingHoursRequest{TimeZone: "Europe/Berlin", Days: weekdayNames}.workingHours()
+		require.NoError(t, err)
+		schedule, err := newWorkSchedule(hours, fixedCalendar{})
+		require.NoError(t, err)
+		berlin := schedule.location
+
+		done, _, err := schedule.addWorkingTime(ctx, time.Date(2026, 10, 24, 16, 0, 0, 0, berlin), 2*time.Hour)
+		require.NoError(t, err)
+		assert.True(t, time.Date(2026, 10, 25, 10, 0, 0, 0, berlin).Equal(done), done)
+	})
+
+	t.Run("no working time", func(t *testing.T) {
+		schedule, err := newWorkSchedule(defaultWorkingHours(), everyDayOff{})
+		require.NoError(t, err)
+		_, _, err = schedule.addWorkingTime(ctx, at(10, 14, 9, 0), time.Hour)
+		assert.ErrorIs(t, err, errNoWorkingTime)
+	})
+}
+
+func TestSuggestDueDates(t *testing.T) {
+	schedule, err := newWorkSchedule(defaultWorkingHours(), fixedCalendar{})
+	require.NoError(t, err)
+
+	// Friday afternoon: 4h of work is done on Monday morning; with 2h of
+	// slack it is done by Monday 13:00, so due at the end of Monday
+	now := time.Date(2026, 10, 16, 15, 0, 0, 0, time.UTC)
+	suggestions, err := schedule.suggestDueDates(context.Background(), now, 4*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []DueSuggestion{
+		{Kind: SuggestEarliest, DueDate: time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC)},
+		{Kind: SuggestRecommended, DueDate: time.Date(2026, 10, 19, 17, 0, 0, 0, time.UTC)},
+	}, suggestions)
+}
+
+func TestParseSuggestEffort(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      defaultSuggestEffort,
+		"90m":   90 * time.Minute,
+		"2h30m": 150 * time.Minute,
+	} {
+		effort, err := parseSuggestEffort(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, effort, value)
+	}
+
+	for _, value := range []string{"soon", "30s", "-1h", "1001h"} {
+		_, err := parseSuggestEffort(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSuggestDue(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "suggest-due@example.com")
+	other := env.registerTestUser(t, "suggest-due-other@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(user.Token, "GET", "/api/users/me/working-hours", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var hours WorkingHours
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hours))
+	assert.Equal(t, "UTC", hours.TimeZone)
+	assert.Nil(t, hours.UpdatedAt)
+
+	w = serve(user.Token, "PUT", "/api/users/me/working-hours",
+		`{"timeZone": "America/New_York", "days": ["monday", "wednesday"], "start": "08:00", "end": "12:00", "locale": "us-ny"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(user.Token, "GET", "/api/users/me/working-hours", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hours))
+	assert.Equal(t, []string{"monday", "wednesday"}, hours.Days)
+	assert.Equal(t, "US-NY", hours.Locale)
+	assert.NotNil(t, hours.UpdatedAt)
+	w = serve(user.Token, "PUT", "/api/users/me/working-hours", `{"days": ["someday"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(user.Token, "PUT", "/api/users/me/working-hours", `{"locale": "New York"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	due := time.Now().AddDate(0, 1, 0).UTC().Format(time.RFC3339)
+	w = serve(user.Token, "POST", "/api/tasks", `{"title": "Plan", "dueDate": "`+due+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var task Task
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+
+	w = serve(user.Token, "GET", "/api/tasks/"+task.ID.String()+"/suggest-due?effort=6h", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var response DueSuggestionsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "6h0m0s", response.Effort)
+	require.Len(t, response.Suggestions, 2)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	for _, suggestion := range response.Suggestions {
+		// Due dates fall on the working days
+		weekday := suggestion.DueDate.In(newYork).Weekday()
+		assert.Contains(t, []time.Weekday{time.Monday, time.Wednesday}, weekday)
+		assert.True(t, suggestion.DueDate.After(time.Now()))
+	}
+	require.NotNil(t, response.OnTrack)
+	assert.True(t, *response.OnTrack)
+
+	assert.Equal(t, http.StatusBadRequest, serve(user.Token, "GET", "/api/tasks/"+task.ID.String()+"/suggest-due?effort=soon", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(user.Token, "GET", "/api/tasks/"+NewID[taskEntity]().String()+"/suggest-due", "").Code)
+	assert.NotEqual(t, http.StatusOK, serve(other.Token, "GET", "/api/tasks/"+task.ID.String()+"/suggest-due", "").Code)
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delta sync lets offline-first clients keep a copy of their tasks. A client
+// pulls what changed since its sync token and pushes the changes it made
+// offline:
+//
+//	GET  /api/tasks/sync                  every task ID and a token
+//	GET  /api/tasks/sync?token=...        IDs created, updated and deleted since
+//	POST /api/tasks/sync                  changes made offline, with conflicts resolved
+//
+// Pulls read the user's change log (task_change_log), which every task write
+// appends to in the same statement (logTaskChanges). Each user's log has its
+// own version counter, and a write keeps the counter's row locked until it
+// commits, so versions commit in order: a token never skips a write that
+// commits later, as a timestamp could. Sync covers the user's own tasks;
+// tasks shared with them come through the change feed.
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+
+	maxSyncPushChanges = 100
+	maxSyncPushBytes   = 1 << 20
+	// syncPushAttempts bounds how often a push change is resolved again
+	// when another write gets in between reading the task and writing it
+	syncPushAttempts = 3
+)
+
+// Entries of the change log
+const (
+	syncCreated = "created"
+	syncUpdated = "updated"
+	syncDeleted = "deleted"
+)
+
+// Operations of a pushed change
+const (
+	SyncOpCreate = "create"
+	SyncOpUpdate = "update"
+	SyncOpDelete = "delete"
+)
+
+// Outcomes of a pushed change
+const (
+	// SyncApplied means the change was saved
+	SyncApplied = "applied"
+	// SyncConflict means the server's version is newer and was kept
+	SyncConflict = "conflict"
+	// SyncGone means the task no longer exists on the server
+	SyncGone = "gone"
+	// SyncFailed means the change was rejected, e.g. as invalid
+	SyncFailed = "error"
+)
+
+// logTaskChanges returns the common table expressions that append a write
+// to the change log of the owners of the written tasks. source names an
+// earlier expression returning the tasks' id and user_id. The tasks of one
+// owner share a version, and the upsert locks the owner's counter until the
+// transaction ends.
+func logTaskChanges(source, change string) string {
+	return fmt.Sprintf(`sync_version AS (
+			INSERT INTO task_sync_versions AS v (user_id, version)
+			SELECT DISTINCT user_id, 1 FROM %[1]s
+			ON CONFLICT (user_id) DO UPDATE SET version = v.version + 1
+			RETURNING user_id, version
+		), logged AS (
+			INSERT INTO task_change_log (user_id, version, task_id, change)
+			SELECT s.user_id, sync_version.version, s.id, '%[2]s'
+			FROM %[1]s s JOIN sync_version USING (user_id)
+		)`, source, change)
+}
+
+// SyncToken is a position in a user's change log: after the entry of TaskID
+// in Version, or after all of Version when TaskID is empty.
+type SyncToken struct {
+	Version int64
+	TaskID  TaskID
+}
+
+// Encode returns the opaque form clients pass back in ?token=.
+func (t SyncToken) Encode() string {
+	raw := strconv.FormatInt(t.Version, 10) + "|" + t.TaskID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSyncToken(value string) (SyncToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return SyncToken{}, fmt.Errorf("invalid sync token")
+	}
+	version, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return SyncToken{}, fmt.Errorf("invalid sync token")
+	}
+	token := SyncToken{}
+	if token.Version, err = strconv.ParseInt(version, 10, 64); err != nil || token.Version < 0 {
+		return SyncToken{}, fmt.Errorf("invalid sync token")
+	}
+	if id != "" {
+		if token.TaskID, err = ParseID[taskEntity](id); err != nil {
+			return SyncToken{}, fmt.Errorf("invalid sync token")
+		}
+	}
+	return token, nil
+}
+
+// TaskChangeLogEntry is a write to one task.
+// ErrSyncTokenExpired is returned for tokens from before deletions that
+// were compacted away: the client can't learn about them from the log and
+// has to sync from scratch.
+var ErrSyncTokenExpired = errors.New("sync token expired")
+
+type TaskChangeLogEntry struct {
+	Version int64
+	TaskID  TaskID
+	Change  string
+}
+
+type TaskSyncRepository interface {
+	// Version returns the latest version of the user's change log, 0 before
+	// their first write
+	Version(ctx context.Context, userID UserID) (int64, error)
+	// ListChanges returns the entries after the token, oldest first, or
+	// ErrSyncTokenExpired
+	ListChanges(ctx context.Context, userID UserID, after SyncToken, limit int) ([]TaskChangeLogEntry, error)
+	// TaskIDs lists the IDs of the user's own tasks
+	TaskIDs(ctx context.Context, userID UserID) ([]TaskID, error)
+	// Compact removes the entries before cutoff that a later entry for the
+	// same task supersedes, and those of deletions
+	Compact(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type taskSyncRepository struct {
+	db *sql.DB
+}
+
+func NewTaskSyncRepository(db *sql.DB) TaskSyncRepository {
+	return &taskSyncRepository{db: db}
+}
+
+func (r *taskSyncRepository) Version(ctx context.Context, userID UserID) (int64, error) {
+	var version int64
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT version FROM task_sync_versions WHERE user_id = $1`, userID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sync version: %w", err)
+	}
+	return version, nil
+}
+
+func (r *taskSyncRepository) ListChanges(ctx context.Context, userID UserID, after SyncToken, limit int) ([]TaskChangeLogEntry, error) {
+	query := `
+		SELECT version, task_id, change FROM task_change_log
+		WHERE user_id = $1 AND version > $2
+		ORDER BY version, task_id
+		LIMIT $3`
+	args := []interface{}{userID, after.Version, limit}
+	if after.TaskID != "" {
+		query = `
+			SELECT version, task_id, change FROM task_change_log
+			WHERE user_id = $1 AND (version, task_id) > ($2, $4)
+			ORDER BY version, task_id
+			LIMIT $3`
+		args = append(args, after.TaskID)
+	}
+
+	rows, err := conn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task changes: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []TaskChangeLogEntry
+	for rows.Next() {
+		var entry TaskChangeLogEntry
+		if err := rows.Scan(&entry.Version, &entry.TaskID, &entry.Change); err != nil {
+			return nil, fmt.Errorf("failed to scan task change: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Checked after listing: entries compacted since are missing from the
+	// list, and the horizon has moved past them
+	var compacted int64
+	err = conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT compacted_version FROM task_sync_versions WHERE user_id = $1`, userID).Scan(&compacted)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get sync horizon: %w", err)
+	}
+	if after.Version < compacted || (after.Version == compacted && after.TaskID != "") {
+		return nil, ErrSyncTokenExpired
+	}
+	return entries, nil
+}
+
+func (r *taskSyncRepository) TaskIDs(ctx context.Context, userID UserID) ([]TaskID, error) {
+	rows, err := conn(ctx, r.db).QueryContext(ctx,
+		`SELECT id FROM tasks WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task ids: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []TaskID{}
+	for rows.Next() {
+		var id TaskID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan task id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Compact moves each user's compacted_version up to the latest deletion it
+// removed: tokens before it have missed that deletion.
+func (r *taskSyncRepository) Compact(ctx context.Context, cutoff time.Time) (int64, error) {
+	var removed int64
+	err := conn(ctx, r.db).QueryRowContext(ctx, `
+		WITH removed AS (
+			DELETE FROM task_change_log l
+			WHERE l.changed_at < $1 AND (l.change = 'deleted' OR EXISTS (
+				SELECT 1 FROM task_change_log later
+				WHERE later.user_id = l.user_id AND later.task_id = l.task_id AND later.version > l.version
+			))
+			RETURNING user_id, version, change
+		), horizon AS (
+			UPDATE task_sync_versions v
+			SET compacted_version = GREATEST(v.compacted_version, d.version)
+			FROM (
+				SELECT user_id, MAX(version) AS version FROM removed
+				WHERE change = 'deleted' GROUP BY user_id
+			) d
+			WHERE v.user_id = d.user_id
+		)
+		SELECT COUNT(*) FROM removed`, cutoff).Scan(&removed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact task change log: %w", err)
+	}
+	return removed, nil
+}
+
+// SyncResponse lists the tasks that changed since the request's token, each
+// once, by its latest change. The client fetches created and updated tasks,
+// drops deleted ones, and passes Token next time; with HasMore it asks again
+// right away.
+type SyncResponse struct {
+	Created []TaskID `json:"created"`
+	Updated []TaskID `json:"updated"`
+	Deleted []TaskID `json:"deleted"`
+	Token   string   `json:"token"`
+	HasMore bool     `json:"hasMore"`
+}
+
+// newSyncResponse folds a page of log entries into one change per task. A
+// task that was created and deleted within the page is still listed as
+// deleted: the client may have it from an earlier sync, and dropping a task
+// it doesn't have costs nothing.
+func newSyncResponse(entries []TaskChangeLogEntry, token SyncToken, limit int) SyncResponse {
+	response := SyncResponse{Created: []TaskID{}, Updated: []TaskID{}, Deleted: []TaskID{}}
+	if len(entries) > limit {
+		entries, response.HasMore = entries[:limit], true
+	}
+
+	var order []TaskID
+	created := map[TaskID]bool{}
+	latest := map[TaskID]string{}
+	for _, entry := range entries {
+		if _, seen := latest[entry.TaskID]; !seen {
+			order = append(order, entry.TaskID)
+		}
+		latest[entry.TaskID] = entry.Change
+		if entry.Change == syncCreated {
+			created[entry.TaskID] = true
+		}
+	}
+	for _, id := range order {
+		switch {
+		case latest[id] == syncDeleted:
+			response.Deleted = append(response.Deleted, id)
+		case created[id]:
+			response.Created = append(response.Created, id)
+		default:
+			response.Updated = append(response.Updated, id)
+		}
+	}
+
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		token = SyncToken{Version: last.Version, TaskID: last.TaskID}
+	}
+	response.Token = token.Encode()
+	return response
+}
+
+// SyncTasks handles GET /api/tasks/sync. Without a token it starts a sync:
+// every task ID as created, and the token of the current version. The token
+// is read before the IDs, so a write in between may be listed twice, but
+// none is missed.
+func (h *Handler) SyncTasks(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+	query := r.URL.Query()
+
+	limit := defaultSyncLimit
+	if value := query.Get("limit"); value != "" {
+		if l, err := strconv.Atoi(value); err == nil && l > 0 && l <= maxSyncLimit {
+			limit = l
+		}
+	}
+
+	value := query.Get("token")
+	if value == "" {
+		version, err := h.syncRepo.Version(r.Context(), userID)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to sync tasks")
+			return
+		}
+		ids, err := h.syncRepo.TaskIDs(r.Context(), userID)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to sync tasks")
+			return
+		}
+		h.respond(w, r, http.StatusOK, SyncResponse{
+			Created: ids,
+			Updated: []TaskID{},
+			Deleted: []TaskID{},
+			Token:   SyncToken{Version: version}.Encode(),
+		})
+		return
+	}
+
+	token, err := decodeSyncToken(value)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid sync token")
+		return
+	}
+	// One extra entry tells whether there is another page
+	entries, err := h.syncRepo.ListChanges(r.Context(), userID, token, limit+1)
+	if errors.Is(err, ErrSyncTokenExpired) {
+		h.respondWithErrorCode(w, http.StatusGone, ResyncRequired,
+			"Sync token is older than the retained deletions; sync again without a token")
+		return
+	}
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to sync tasks")
+		return
+	}
+	h.respond(w, r, http.StatusOK, newSyncResponse(entries, token, limit))
+}
+
+type SyncPushRequest struct {
+	Changes []SyncPushChange `json:"changes"`
+}
+
+// SyncPushChange is a change a client made offline. Task is the body of the
+// create or update, as POST /api/tasks and PUT /api/tasks/{id} take it.
+// BaseUpdatedAt is the updatedAt of the version the client changed, and
+// ChangedAt when the user made the change.
+type SyncPushChange struct {
+	Op            string          `json:"op"`
+	ID            TaskID          `json:"id,omitempty"`
+	ClientID      string          `json:"clientId,omitempty"`
+	BaseUpdatedAt *time.Time      `json:"baseUpdatedAt,omitempty"`
+	ChangedAt     time.Time       `json:"changedAt"`
+	Task          json.RawMessage `json:"task,omitempty"`
+}
+
+func (c SyncPushChange) validate() error {
+	switch c.Op {
+	case SyncOpCreate:
+		if c.ClientID == "" {
+			return fmt.Errorf("clientId is required to create a task")
+		}
+		if len(c.Task) == 0 {
+			return fmt.Errorf("task is required to create a task")
+		}
+	case SyncOpUpdate, SyncOpDelete:
+		if c.ID == "" {
+			return fmt.Errorf("id is required to %s a task", c.Op)
+		}
+		if c.Op == SyncOpUpdate && len(c.Task) == 0 {
+			return fmt.Errorf("task is required to update a task")
+		}
+	default:
+		return fmt.Errorf("op must be create, update or delete")
+	}
+	return nil
+}
+
+type SyncPushResponse struct {
+	Results []SyncPushResult `json:"results"`
+}
+
+// SyncPushResult is the outcome of one pushed change. Task is the task as
+// the server has it now: the saved one when the change was applied, the
+// server's when it won a conflict. Conflict is also set when the client's
+// change won one. Error is the error response of a failed change.
+type SyncPushResult struct {
+	Status   string          `json:"status"`
+	ID       TaskID          `json:"id,omitempty"`
+	ClientID string          `json:"clientId,omitempty"`
+	Conflict bool            `json:"conflict,omitempty"`
+	Task     json.RawMessage `json:"task,omitempty"`
+	Error    json.RawMessage `json:"error,omitempty"`
+}
+
+// PushTaskChanges handles POST /api/tasks/sync. Changes are applied in
+// order, each on its own like the sub-requests of a batch, through the same
+// endpoints and with the same checks as any other write.
+//
+// A change based on the server's current version is applied. Otherwise the
+// task changed on the server too, and the later change wins: the client's
+// if its ChangedAt is after the server's updatedAt, which overwrites the
+// fields the client sent, or else the server's, which is kept and returned.
+// ChangedAt is capped at the time of the push, so a client with a clock
+// ahead can't win every conflict. Edits of a task deleted on the server are
+// gone with it.
+//
+// Creates are idempotent by clientId for IDEMPOTENCY_KEY_TTL, so a client
+// that didn't get the response pushes the same changes again.
+func (h *Handler) PushTaskChanges(w http.ResponseWriter, r *http.Request) {
+	var req SyncPushRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSyncPushBytes))
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondWithError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Pushes are limited to %d bytes", maxSyncPushBytes))
+			return
+		}
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Changes) > maxSyncPushChanges {
+		h.respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("A push has at most %d changes, not %d", maxSyncPushChanges, len(req.Changes)))
+		return
+	}
+	// Validate everything first, so an invalid push changes nothing
+	for i, change := range req.Changes {
+		if err := change.validate(); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("changes[%d]: %v", i, err))
+			return
+		}
+	}
+
+	response := SyncPushResponse{Results: make([]SyncPushResult, 0, len(req.Changes))}
+	pushedAt := time.Now()
+	for _, change := range req.Changes {
+		if r.Context().Err() != nil {
+			// The client is gone or the deadline passed; stop changing things
+			return
+		}
+		if change.ChangedAt.After(pushedAt) {
+			change.ChangedAt = pushedAt
+		}
+		response.Results = append(response.Results, h.pushTaskChange(r, change))
+	}
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+// pushTaskChange applies one change through the task endpoints.
+func (h *Handler) pushTaskChange(r *http.Request, change SyncPushChange) SyncPushResult {
+	pushed := SyncPushResult{ID: change.ID, ClientID: change.ClientID}
+	headers := map[string]string{}
+	if version := apiVersion(r); version != "" {
+		// Answer in the version of the push, whichever path it came in on
+		headers["Accept"] = "application/vnd.task-api." + version + "+json"
+	}
+
+	if change.Op == SyncOpCreate {
+		headers[idempotencyKeyHeader] = "sync:" + change.ClientID
+		sub := h.serveBatchItem(r, BatchItem{Method: http.MethodPost, Path: "/api/tasks", Headers: headers, Body: change.Task})
+		result := pushed.from(sub, http.StatusCreated)
+		var created struct {
+			ID TaskID `json:"id"`
+		}
+		if result.Status == SyncApplied && json.Unmarshal(result.Task, &created) == nil {
+			result.ID = created.ID
+		}
+		return result
+	}
+
+	path := "/api/tasks/" + change.ID.String()
+	for attempt := 1; ; attempt++ {
+		result := pushed
+		current, err := h.taskRepo.GetByID(r.Context(), change.ID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				result.Status = SyncGone
+				return result
+			}
+			return result.from(batchItemError(r, http.StatusInternalServerError, "Failed to get task"), http.StatusOK)
+		}
+
+		result.Conflict = change.BaseUpdatedAt == nil || !change.BaseUpdatedAt.Equal(current.UpdatedAt)
+		if result.Conflict && !change.ChangedAt.After(current.UpdatedAt) {
+			// The server's version wins; reading it checks the user may see it
+			sub := h.serveBatchItem(r, BatchItem{Method: http.MethodGet, Path: path, Headers: headers})
+			result = result.from(sub, http.StatusOK)
+			if result.Status == SyncApplied {
+				result.Status = SyncConflict
+			}
+			return result
+		}
+
+		// If-Match turns a write that got in since the read into a 412
+		headers["If-Match"] = taskValidators(current).ETag.String()
+		var sub BatchItemResponse
+		if change.Op == SyncOpUpdate {
+			sub = h.serveBatchItem(r, BatchItem{Method: http.MethodPut, Path: path, Headers: headers, Body: change.Task})
+			result = result.from(sub, http.StatusOK)
+		} else {
+			sub = h.serveBatchItem(r, BatchItem{Method: http.MethodDelete, Path: path, Headers: headers})
+			result = result.from(sub, http.StatusNoContent)
+		}
+		if sub.Status != http.StatusPreconditionFailed || attempt == syncPushAttempts {
+			return result
+		}
+	}
+}
+
+// from fills the result from the sub-response of its change, which
+// succeeded with the status ok.
+func (result SyncPushResult) from(sub BatchItemResponse, ok int) SyncPushResult {
+	switch sub.Status {
+	case ok:
+		result.Status = SyncApplied
+		result.Task = sub.Body
+		result.Error = nil
+	case http.StatusNotFound:
+		result.Status = SyncGone
+	default:
+		result.Status = SyncFailed
+		result.Task = nil
+		result.Error = sub.Body
+	}
+	return result
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncToken(t *testing.T) {
+	for _, token := range []SyncToken{{}, {Version: 42}, {Version: 7, TaskID: NewID[taskEntity]()}} {
+		decoded, err := decodeSyncToken(token.Encode())
+		require.NoError(t, err)
+		assert.Equal(t, token, decoded)
+	}
+
+	for _, value := range []string{"not-a-token", (&TaskCursor{CreatedAt: time.Now(), ID: NewID[taskEntity]()}).Encode()} {
+		_, err := decodeSyncToken(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNewSyncResponse(t *testing.T) {
+	a, b, c, d := NewID[taskEntity](), NewID[taskEntity](), NewID[taskEntity](), NewID[taskEntity]()
+	entries := []TaskChangeLogEntry{
+		{Version: 3, TaskID: a, Change: syncUpdated},
+		{Version: 4, TaskID: b, Change: syncCreated},
+		{Version: 5, TaskID: b, Change: syncUpdated},
+		{Version: 6, TaskID: c, Change: syncCreated},
+		{Version: 7, TaskID: c, Change: syncDeleted},
+		{Version: 8, TaskID: a, Change: syncDeleted},
+		{Version: 9, TaskID: d, Change: syncUpdated},
+	}
+
+	response := newSyncResponse(entries, SyncToken{Version: 2}, 10)
+	assert.Equal(t, []TaskID{b}, response.Created)
+	assert.Equal(t, []TaskID{d}, response.Updated)
+	assert.Equal(t, []TaskID{a, c}, response.Deleted)
+	assert.False(t, response.HasMore)
+	assert.Equal(t, SyncToken{Version: 9, TaskID: d}.Encode(), response.Token)
+
+	// The extra entry only tells there is more
+	response = newSyncResponse(entries, SyncToken{Version: 2}, 6)
+	assert.True(t, response.HasMore)
+	assert.Equal(t, []TaskID{a, c}, response.Deleted)
+	assert.Empty(t, response.Updated)
+	assert.Equal(t, SyncToken{Version: 8, TaskID: a}.Encode(), response.Token)
+
+	empty := newSyncResponse(nil, SyncToken{Version: 2}, 10)
+	assert.Equal(t, SyncToken{Version: 2}.Encode(), empty.Token)
+	assert.NotNil(t, empty.Created)
+}
+
+func TestSyncPushChangeValidate(t *testing.T) {
+	id := NewID[taskEntity]()
+	for _, valid := range []SyncPushChange{
+		{Op: SyncOpCreate, ClientID: "local-1", Task: json.RawMessage(`{"title":"x"}`)},
+		{Op: SyncOpUpdate, ID: id, Task: json.RawMessage(`{"title":"y"}`)},
+		{Op: SyncOpDelete, ID: id},
+	} {
+		assert.NoError(t, valid.validate(), "%+v", valid)
+	}
+
+	for _, invalid := range []SyncPushChange{
+		{Op: "upsert", ID: id},
+		{Op: SyncOpCreate, Task: json.RawMessage(`{"title":"x"}`)},
+		{Op: SyncOpCreate, ClientID: "local-1"},
+		{Op: SyncOpUpdate, Task: json.RawMessage(`{"title":"y"}`)},
+		{Op: SyncOpUpdate, ID: id},
+		{Op: SyncOpDelete},
+	} {
+		assert.Error(t, invalid.validate(), "%+v", invalid)
+	}
+}
+
+// TestTaskSync runs a client through a first sync, delta syncs and pushes
+// with and without conflicts.
+func TestTaskSync(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "sync@example.com")
+	other := env.registerTestUser(t, "sync-other@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	pull := func(query string) SyncResponse {
+		w := serve("GET", "/api/tasks/sync?"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		var response SyncResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	push := func(changes ...string) []SyncPushResult {
+		w := serve("POST", "/api/tasks/sync", `{"changes": [`+strings.Join(changes, ",")+`]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response SyncPushResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Results, len(changes))
+		return response.Results
+	}
+	create := func(title string) Task {
+		w := env.createTaskAs(user.Token, title)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var task Task
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+		return task
+	}
+	get := func(id TaskID) *Task {
+		task, err := env.handler.taskRepo.GetByID(context.Background(), id)
+		require.NoError(t, err)
+		return task
+	}
+
+	first, second := create("First"), create("Second")
+	require.Equal(t, http.StatusCreated, env.createTaskAs(other.Token, "Not synced").Code)
+
+	start := pull("")
+	assert.Equal(t, []TaskID{first.ID, second.ID}, start.Created)
+	assert.Empty(t, start.Deleted)
+	assert.Empty(t, pull("token="+start.Token).Created)
+
+	// Changes since the first sync, each task once by its latest change
+	require.Equal(t, http.StatusOK, serve("PUT", "/api/tasks/"+first.ID.String(), `{"title": "First, edited"}`).Code)
+	require.Equal(t, http.StatusNoContent, serve("DELETE", "/api/tasks/"+second.ID.String(), "").Code)
+	third := create("Third")
+	require.Equal(t, http.StatusOK, serve("PATCH", "/api/tasks?confirm=true&priority=medium", `{"priority": "high"}`).Code)
+
+	delta := pull("token=" + start.Token)
+	assert.Equal(t, []TaskID{third.ID}, delta.Created)
+	assert.Equal(t, []TaskID{first.ID}, delta.Updated)
+	assert.Equal(t, []TaskID{second.ID}, delta.Deleted)
+	assert.False(t, delta.HasMore)
+	assert.Empty(t, pull("token="+delta.Token).Updated)
+
+	// Paging through the log one entry at a time sees every change
+	seen := map[TaskID]bool{}
+	token := start.Token
+	for page := 0; page < 10; page++ {
+		response := pull("limit=1&token=" + token)
+		for _, ids := range [][]TaskID{response.Created, response.Updated, response.Deleted} {
+			for _, id := range ids {
+				seen[id] = true
+			}
+		}
+		token = response.Token
+		if !response.HasMore {
+			break
+		}
+	}
+	assert.Equal(t, map[TaskID]bool{first.ID: true, second.ID: true, third.ID: true}, seen)
+	assert.Equal(t, http.StatusBadRequest, serve("GET", "/api/tasks/sync?token=nope", "").Code)
+
+	t.Run("push", func(t *testing.T) {
+		current := get(first.ID)
+		base := current.UpdatedAt.Format(time.RFC3339Nano)
+		longAgo := current.UpdatedAt.Add(-time.Hour).Format(time.RFC3339Nano)
+		now := time.Now().Format(time.RFC3339Nano)
+
+		created := fmt.Sprintf(`{"op": "create", "clientId": "local-1", "changedAt": %q, "task": {"title": "Offline"}}`, now)
+		results := push(
+			created,
+			fmt.Sprintf(`{"op": "update", "id": %q, "baseUpdatedAt": %q, "changedAt": %q, "task": {"title": "Pushed"}}`, first.ID, base, longAgo),
+			fmt.Sprintf(`{"op": "delete", "id": %q, "baseUpdatedAt": %q, "changedAt": %q}`, second.ID, base, now),
+			`{"op": "create", "clientId": "local-2", "task": {"title": ""}}`,
+		)
+		assert.Equal(t, SyncApplied, results[0].Status)
+		assert.Equal(t, "local-1", results[0].ClientID)
+		assert.NotEmpty(t, results[0].ID)
+
+		// Based on the current version, so applied however old
+		assert.Equal(t, SyncApplied, results[1].Status)
+		assert.False(t, results[1].Conflict)
+		assert.Equal(t, "Pushed", get(first.ID).Title)
+
+		assert.Equal(t, SyncGone, results[2].Status)
+		assert.Equal(t, SyncFailed, results[3].Status)
+		assert.Contains(t, string(results[3].Error), "Title is required")
+
+		// Pushing a create again doesn't create the task twice
+		again := push(created)
+		assert.Equal(t, SyncApplied, again[0].Status)
+		assert.Equal(t, results[0].ID, again[0].ID)
+
+		// Stale base: the later change wins
+		results = push(
+			fmt.Sprintf(`{"op": "update", "id": %q, "baseUpdatedAt": %q, "changedAt": %q, "task": {"title": "Too late"}}`, first.ID, base, longAgo),
+			fmt.Sprintf(`{"op": "update", "id": %q, "baseUpdatedAt": %q, "changedAt": %q, "task": {"description": "Later"}}`, first.ID, base, now),
+		)
+		assert.Equal(t, SyncConflict, results[0].Status)
+		assert.Contains(t, string(results[0].Task), `"title":"Pushed"`)
+		assert.Equal(t, SyncApplied, results[1].Status)
+		assert.True(t, results[1].Conflict)
+		assert.Equal(t, "Pushed", get(first.ID).Title)
+		assert.Equal(t, "Later", get(first.ID).Description)
+
+		pushed := pull("token=" + delta.Token)
+		assert.Contains(t, pushed.Created, again[0].ID)
+		assert.Contains(t, pushed.Updated, first.ID)
+
+		w := serve("POST", "/api/tasks/sync", `{"changes": [{"op": "upsert", "id": "`+first.ID.String()+`"}]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
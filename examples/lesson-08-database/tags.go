@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTaskTags   = 20
+	maxTagLength  = 50
+	tagsSeparator = ","
+)
+
+// normalizeTags trims and lowercases tags and drops empty and duplicate
+// ones, so "Urgent" and " urgent" are the same tag. The result is never nil.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// parseTags normalizes the tags of a create or update request and checks
+// them against the limits.
+func parseTags(tags []string) ([]string, error) {
+	normalized := normalizeTags(tags)
+	if len(normalized) > maxTaskTags {
+		return nil, fmt.Errorf("a task can have at most %d tags", maxTaskTags)
+	}
+	for _, tag := range normalized {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags can be at most %d characters", maxTagLength)
+		}
+		// Commas separate tags in the ?tags= filter
+		if strings.Contains(tag, tagsSeparator) {
+			return nil, fmt.Errorf("tags cannot contain %q", tagsSeparator)
+		}
+	}
+	return normalized, nil
+}
+
+// parseTagsFilter reads ?tags=a,b; tasks must have all of the listed tags.
+func parseTagsFilter(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return normalizeTags(strings.Split(value, tagsSeparator))
+}
+
+// TagCount is a tag of the user's vocabulary and the number of their tasks
+// that have it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TagRepository interface {
+	GetByUserID(ctx context.Context, userID UserID) ([]TagCount, error)
+}
+
+type tagRepository struct {
+	db *sql.DB
+}
+
+func NewTagRepository(db *sql.DB) TagRepository {
+	return &tagRepository{db: db}
+}
+
+// GetByUserID lists the tags on the user's own tasks, most used first.
+func (r *tagRepository) GetByUserID(ctx context.Context, userID UserID) ([]TagCount, error) {
+	query := `
+		SELECT tag, COUNT(*)
+		FROM tasks, unnest(tags) AS tag
+		WHERE user_id = $1
+		GROUP BY tag
+		ORDER BY COUNT(*) DESC, tag`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (h *Handler) GetTags(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if !h.authorize(w, r, ActionList, Resource{Type: "tag", OwnerID: userID}) {
+		return
+	}
+
+	tags, err := h.tagRepo.GetByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get tags")
+		return
+	}
+
+	// The vocabulary changes with the user's tasks, which purge this key
+	setSurrogateKeys(w, userSurrogateKey(userID))
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"tags":  tags,
+		"count": len(tags),
+	})
+}
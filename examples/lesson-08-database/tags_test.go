@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{" Urgent", "urgent", "", "home "})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"urgent", "home"}, tags)
+
+	tags, err = parseTags(nil)
+	require.NoError(t, err)
+	assert.NotNil(t, tags, "no tags are stored as an empty array")
+
+	_, err = parseTags([]string{"a,b"})
+	assert.Error(t, err)
+	_, err = parseTags([]string{strings.Repeat("x", maxTagLength+1)})
+	assert.Error(t, err)
+
+	tooMany := make([]string, maxTaskTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag-%d", i)
+	}
+	_, err = parseTags(tooMany)
+	assert.Error(t, err)
+
+	assert.Nil(t, parseTagsFilter(""))
+	assert.Equal(t, []string{"work", "q4"}, parseTagsFilter("Work,,q4, work"))
+}
+
+func TestTaskTags(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "tags@example.com")
+
+	createTask := func(body string) TaskResponse {
+		req := taskRequest(http.MethodPost, "/api/tasks", user.Token, body, nil)
+		w := env.serveWithAuth(env.handler.CreateTask, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var task TaskResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+		return task
+	}
+
+	release := createTask(`{"title": "Ship release", "tags": ["Work", "urgent"]}`)
+	assert.Equal(t, []string{"work", "urgent"}, release.Tags)
+	createTask(`{"title": "Plan sprint", "tags": ["work"]}`)
+	untagged := createTask(`{"title": "Read book"}`)
+	assert.Equal(t, []string{}, untagged.Tags)
+
+	req := taskRequest(http.MethodPost, "/api/tasks", user.Token, `{"title": "Bad", "tags": ["a,b"]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.CreateTask, req).Code)
+
+	listTitles := func(query string) []string {
+		req := taskRequest(http.MethodGet, "/api/tasks?"+query, user.Token, "", nil)
+		w := env.serveWithAuth(env.handler.GetTasks, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		titles := make([]string, len(response.Tasks))
+		for i, task := range response.Tasks {
+			titles[i] = task.Title
+		}
+		assert.EqualValues(t, len(titles), response.TotalCount)
+		return titles
+	}
+	assert.ElementsMatch(t, []string{"Ship release", "Plan sprint"}, listTitles("tags=work"))
+	assert.Equal(t, []string{"Ship release"}, listTitles("tags=work,Urgent"))
+	assert.Empty(t, listTitles("tags=missing"))
+
+	// Updating tags replaces them; leaving them out keeps them
+	vars := map[string]string{"id": untagged.ID.String()}
+	req = taskRequest(http.MethodPut, "/api/tasks/"+untagged.ID.String(), user.Token, `{"tags": ["home"]}`, vars)
+	require.Equal(t, http.StatusOK, env.serveWithAuth(env.handler.UpdateTask, req).Code)
+	req = taskRequest(http.MethodPut, "/api/tasks/"+untagged.ID.String(), user.Token, `{"completed": true}`, vars)
+	w := env.serveWithAuth(env.handler.UpdateTask, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"tags":["home"]`)
+
+	req = taskRequest(http.MethodGet, "/api/tags", user.Token, "", nil)
+	w = env.serveWithAuth(env.handler.GetTags, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var vocabulary struct {
+		Tags  []TagCount `json:"tags"`
+		Count int        `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &vocabulary))
+	assert.Equal(t, []TagCount{{"work", 2}, {"home", 1}, {"urgent", 1}}, vocabulary.Tags)
+	assert.Equal(t, 3, vocabulary.Count)
+}
+
+func TestTaskCategoryFilter(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "category-filter@example.com")
+
+	createTask := func(title string, categories ...string) *Task {
+		req := CreateTaskRequest{Title: title, Priority: "medium", CategoryNames: categories}
+		task, err := env.handler.taskService.CreateTaskWithCategories(context.Background(), req, user.User.ID)
+		require.NoError(t, err)
+		return task
+	}
+	release := createTask("Ship release", "work", "urgent")
+	createTask("Plan sprint", "work")
+	createTask("Read book")
+	ids := map[string]string{}
+	for _, category := range release.Categories {
+		ids[category.Name] = category.ID.String()
+	}
+	work, urgent := ids["work"], ids["urgent"]
+
+	listTitles := func(query string) []string {
+		req := taskRequest(http.MethodGet, "/api/tasks?"+query, user.Token, "", nil)
+		w := env.serveWithAuth(env.handler.GetTasks, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		titles := make([]string, len(response.Tasks))
+		for i, task := range response.Tasks {
+			titles[i] = task.Title
+		}
+		assert.EqualValues(t, len(titles), response.TotalCount)
+		return titles
+	}
+	assert.ElementsMatch(t, []string{"Ship release", "Plan sprint"}, listTitles("categories="+work))
+	assert.Equal(t, []string{"Ship release"}, listTitles("categories="+work+","+urgent))
+	// Repeating a category doesn't change the result
+	assert.Equal(t, []string{"Ship release"}, listTitles("categories="+urgent+","+urgent))
+
+	req := taskRequest(http.MethodGet, "/api/tasks?categories=nope", user.Token, "", nil)
+	assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.GetTasks, req).Code)
+}
+
+func TestTaskDueDateFilters(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.registerTestUser(t, "due-filter@example.com")
+
+	now := time.Now().UTC()
+	for _, task := range []*Task{
+		{Title: "Late report", DueDate: timePtr(now.Add(-48 * time.Hour))},
+		{Title: "Late but done", DueDate: timePtr(now.Add(-24 * time.Hour)), Completed: true},
+		{Title: "Due tomorrow", DueDate: timePtr(now.Add(24 * time.Hour))},
+		{Title: "Due next week", DueDate: timePtr(now.Add(7 * 24 * time.Hour))},
+		{Title: "Someday"},
+	} {
+		task.ID = NewID[taskEntity]()
+		task.Priority = "medium"
+		task.UserID = user.User.ID
+		require.NoError(t, env.handler.taskRepo.Create(ctx, task))
+	}
+
+	listTitles := func(query string) []string {
+		req := taskRequest(http.MethodGet, "/api/tasks?"+query, user.Token, "", nil)
+		w := env.serveWithAuth(env.handler.GetTasks, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		titles := make([]string, len(response.Tasks))
+		for i, task := range response.Tasks {
+			titles[i] = task.Title
+		}
+		assert.EqualValues(t, len(titles), response.TotalCount)
+		return titles
+	}
+	format := func(t time.Time) string { return url.QueryEscape(t.Format(time.RFC3339)) }
+
+	assert.ElementsMatch(t, []string{"Late report", "Late but done"}, listTitles("dueBefore="+format(now)))
+	assert.ElementsMatch(t, []string{"Due tomorrow", "Due next week"}, listTitles("dueAfter="+format(now)))
+	assert.Equal(t, []string{"Due tomorrow"},
+		listTitles("dueAfter="+format(now)+"&dueBefore="+format(now.Add(48*time.Hour))))
+	assert.Equal(t, []string{"Late report"}, listTitles("overdue=true"))
+	// An earlier dueBefore narrows overdue further
+	assert.Empty(t, listTitles("overdue=true&dueBefore="+format(now.Add(-72*time.Hour))))
+
+	for _, query := range []string{"dueBefore=tomorrow", "dueAfter=2024-01-01", "overdue=true&status=completed"} {
+		req := taskRequest(http.MethodGet, "/api/tasks?"+query, user.Token, "", nil)
+		assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.GetTasks, req).Code, query)
+	}
+}
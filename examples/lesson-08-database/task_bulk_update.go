@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"respond"
+)
+
+// ConfirmationRequired rejects a bulk update without confirm=true
+const ConfirmationRequired = "confirmation_required"
+
+// BulkUpdateTasksRequest are the changes of PATCH /api/tasks. Status is the
+// v2 form of Completed and wins over it; v2 only accepts status.
+type BulkUpdateTasksRequest struct {
+	Completed *bool   `json:"completed,omitempty"`
+	Status    *string `json:"status,omitempty" enum:"status"`
+	Priority  *string `json:"priority,omitempty" enum:"priority"`
+}
+
+type BulkUpdateTasksResponse struct {
+	Updated int `json:"updated"`
+}
+
+// bulkUpdateParams are the query parameters of PATCH /api/tasks: confirm and
+// the filters of GET /api/tasks
+var bulkUpdateParams = map[string]bool{
+	"confirm":    true,
+	"search":     true,
+	"completed":  true,
+	"status":     true,
+	"priority":   true,
+	"tags":       true,
+	"categories": true,
+	"dueBefore":  true,
+	"dueAfter":   true,
+	"overdue":    true,
+}
+
+// BulkUpdateTasks handles PATCH /api/tasks?{filters}&confirm=true: it
+// changes every task of the user that matches the filters of GET /api/tasks
+// in a single UPDATE, so the tasks change together or not at all.
+//
+// Each changed task is then treated like one written by PUT: the user's
+// rules are applied to it in the same transaction, and once that commits
+// its revision is recorded and a task.updated webhook sent.
+//
+// A list ignores a filter it doesn't understand; here that would widen the
+// update to more tasks than the client meant, so unknown parameters and
+// unparsable values are rejected. Only the user's own tasks are changed,
+// never ones shared with them.
+func (h *Handler) BulkUpdateTasks(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	query := r.URL.Query()
+	if query.Get("confirm") != "true" {
+		h.respondWithErrorCode(w, http.StatusBadRequest, ConfirmationRequired,
+			"PATCH /api/tasks changes every task matching the filters; add confirm=true to the query")
+		return
+	}
+	for name := range query {
+		if !bulkUpdateParams[name] {
+			h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown filter %q", name))
+			return
+		}
+	}
+	for _, name := range []string{"completed", "overdue"} {
+		if value := query.Get(name); value != "" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s must be true or false", name))
+				return
+			}
+		}
+	}
+	filters, err := parseTaskFilters(query)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var req BulkUpdateTasksRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var changes TaskChanges
+	if req.Completed != nil {
+		if apiVersion(r) == APIVersion2 {
+			h.respondWithErrorCode(w, http.StatusBadRequest, FieldRemoved,
+				"completed was replaced by status in v2: send \"status\": \"open\" or \"completed\"")
+			return
+		}
+		changes.Completed = req.Completed
+	}
+	if req.Status != nil {
+		if !statusEnum.Valid(*req.Status) {
+			h.respondWithEnumError(w, statusEnum, *req.Status)
+			return
+		}
+		completed := *req.Status == TaskStatusCompleted
+		changes.Completed = &completed
+	}
+	if req.Priority != nil {
+		if !priorityEnum.Valid(*req.Priority) {
+			h.respondWithEnumError(w, priorityEnum, *req.Priority)
+			return
+		}
+		changes.Priority = req.Priority
+	}
+	if changes.Completed == nil && changes.Priority == nil {
+		h.respondWithError(w, http.StatusBadRequest, "Nothing to update: send status, completed or priority")
+		return
+	}
+
+	updated, err := h.updateMatchingTasks(r.Context(), userID, filters, changes)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to update tasks")
+		return
+	}
+
+	if len(updated) > 0 {
+		// Lists that show a changed task carry its key, also collaborators'
+		keys := []string{userSurrogateKey(userID)}
+		for _, update := range updated {
+			keys = append(keys, taskSurrogateKey(update.after.ID))
+		}
+		h.cacheInvalidator.Invalidate(keys...)
+	}
+	for _, update := range updated {
+		h.recordTaskRevision(r, update.before, update.after)
+		h.webhooks.Publish(r, update.after.UserID, WebhookTaskUpdated, newTaskResponse(update.after))
+	}
+	h.recordAudit(r, &AuditEvent{
+		UserID:     userID,
+		Action:     AuditTaskBulkUpdate,
+		TargetType: "task",
+		Metadata:   map[string]interface{}{"filters": r.URL.RawQuery, "updated": len(updated)},
+	})
+
+	h.respond(w, r, http.StatusOK, BulkUpdateTasksResponse{Updated: len(updated)})
+}
+
+// bulkUpdatedTask is a task a bulk update changed, as it was and is.
+type bulkUpdatedTask struct {
+	before, after *Task
+}
+
+// updateMatchingTasks runs UpdateMatching and applies the user's rules to
+// the tasks it changed, in one transaction.
+func (h *Handler) updateMatchingTasks(ctx context.Context, userID UserID, filters TaskFilters, changes TaskChanges) ([]bulkUpdatedTask, error) {
+	var updated []bulkUpdatedTask
+	err := WithTransactionContext(ctx, h.db.DB, func(ctx context.Context, tx *sql.Tx) error {
+		updated = nil
+		changed, err := h.taskRepo.UpdateMatching(ctx, userID, filters, changes)
+		if err != nil || len(changed) == 0 {
+			return err
+		}
+		// Only the user's own tasks change, so their rules are the owner's
+		var rules []*TaskRule
+		if h.ruleRepo != nil {
+			if rules, err = h.ruleRepo.ListByUserID(ctx, userID); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		for _, change := range changed {
+			task, err := h.taskRepo.GetByID(ctx, change.ID)
+			if err != nil {
+				return err
+			}
+			before := *task
+			before.Completed, before.Priority = change.OldCompleted, change.OldPriority
+			if applyTaskRules(rules, task, now) {
+				if err := h.taskRepo.Update(ctx, task); err != nil {
+					return err
+				}
+			}
+			updated = append(updated, bulkUpdatedTask{before: &before, after: task})
+		}
+		return nil
+	})
+	return updated, err
+}
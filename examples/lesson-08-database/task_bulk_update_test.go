@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkUpdateTasks(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "bulk@example.com")
+	other := env.registerTestUser(t, "bulk-other@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	create := func(token, title, priority string) TaskID {
+		w := serve("POST", "/api/tasks", token, `{"title": "`+title+`", "priority": "`+priority+`"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var task TaskResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+		return task.ID
+	}
+	priorityOf := func(id TaskID) string {
+		task, err := env.handler.taskRepo.GetByID(context.Background(), id)
+		require.NoError(t, err)
+		return task.Priority
+	}
+
+	lowA := create(user.Token, "Low A", "low")
+	lowB := create(user.Token, "Low B", "low")
+	medium := create(user.Token, "Medium", "medium")
+	othersLow := create(other.Token, "Not mine", "low")
+
+	t.Run("needs confirmation", func(t *testing.T) {
+		w := serve("PATCH", "/api/tasks?priority=low", user.Token, `{"priority": "high"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, ConfirmationRequired, errorCode(t, w))
+		assert.Equal(t, "low", priorityOf(lowA))
+	})
+
+	t.Run("rejects filters it can't apply", func(t *testing.T) {
+		for _, query := range []string{
+			"priority=low&confirm=true&prio=high",
+			"completed=maybe&confirm=true",
+			"priority=lowest&confirm=true",
+		} {
+			w := serve("PATCH", "/api/tasks?"+query, user.Token, `{"priority": "high"}`)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+		w := serve("PATCH", "/api/tasks?priority=low&confirm=true", user.Token, `{}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = serve("PATCH", "/api/tasks?priority=low&confirm=true", user.Token, `{"priority": "someday"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, "low", priorityOf(lowA))
+	})
+
+	t.Run("updates the matching tasks", func(t *testing.T) {
+		w := serve("PATCH", "/api/tasks?priority=low&completed=false&confirm=true", user.Token, `{"priority": "high"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response BulkUpdateTasksResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 2, response.Updated)
+
+		assert.Equal(t, "high", priorityOf(lowA))
+		assert.Equal(t, "high", priorityOf(lowB))
+		assert.Equal(t, "medium", priorityOf(medium))
+		assert.Equal(t, "low", priorityOf(othersLow), "other users' tasks are never touched")
+
+		// Tasks that already have the values don't count and keep their ETag
+		before, err := env.handler.taskRepo.GetByID(context.Background(), lowA)
+		require.NoError(t, err)
+		w = serve("PATCH", "/api/tasks?confirm=true", user.Token, `{"priority": "high"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 1, response.Updated)
+		after, err := env.handler.taskRepo.GetByID(context.Background(), lowA)
+		require.NoError(t, err)
+		assert.Equal(t, before.UpdatedAt, after.UpdatedAt)
+	})
+
+	t.Run("status in v2", func(t *testing.T) {
+		w := serve("PATCH", "/api/v2/tasks?priority=high&confirm=true", user.Token, `{"completed": true}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, FieldRemoved, errorCode(t, w))
+
+		w = serve("PATCH", "/api/v2/tasks?priority=high&confirm=true", user.Token, `{"status": "completed"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		count, err := env.handler.taskRepo.Count(context.Background(), user.User.ID, TaskFilters{Completed: boolPtr(true)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+	})
+}
+
+// A bulk update treats each task it changes like PUT does
+func TestBulkUpdateTasksHistoryRulesAndWebhooks(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.startWebhooks(t)
+	user := env.registerTestUser(t, "bulk-hooks@example.com")
+	receiver, received := newWebhookReceiver(t, http.StatusNoContent)
+	env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: receiver.URL, Events: []string{WebhookTaskUpdated}})
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := serve("POST", "/api/rules",
+		`{"name": "Pin", "condition": {"priority": "high"}, "action": {"type": "add_tag", "tag": "pinned"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = serve("POST", "/api/tasks", `{"title": "Low", "priority": "low"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+
+	w = serve("PATCH", "/api/tasks?priority=low&confirm=true", `{"priority": "high"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The rule ran in the same transaction
+	stored, err := env.handler.taskRepo.GetByID(context.Background(), task.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "high", stored.Priority)
+	assert.Equal(t, []string{"pinned"}, stored.Tags)
+
+	revisions, err := env.handler.revisionRepo.ListByTask(context.Background(), task.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, user.User.ID, revisions[0].ChangedBy)
+	assert.Equal(t, FieldChange{Old: "low", New: "high"}, revisions[0].Changes["priority"])
+	assert.Contains(t, revisions[0].Changes, "tags")
+
+	delivery := waitForWebhook(t, received)
+	assert.Equal(t, WebhookTaskUpdated, delivery.header.Get(WebhookEventHeader))
+	var payload WebhookPayload
+	require.NoError(t, json.Unmarshal(delivery.body, &payload))
+	assert.Equal(t, task.ID.String(), payload.Data.(map[string]interface{})["id"])
+	assert.Equal(t, "high", payload.Data.(map[string]interface{})["priority"])
+}
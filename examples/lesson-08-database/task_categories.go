@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"respond"
+)
+
+// A task's categories can be changed after creation: PUT
+// /api/tasks/{id}/categories replaces the set, and POST and DELETE
+// /api/tasks/{id}/categories/{categoryId} add or remove one. Each is an
+// update of the task: update access, If-Match, a revision in the task's
+// history and a purge of cached copies. The categories must be the task
+// owner's, also when a collaborator makes the change.
+
+type SetTaskCategoriesRequest struct {
+	CategoryIDs []CategoryID `json:"categoryIds"`
+}
+
+// getTaskCategory loads a category to link to the task, responding with 404
+// unless it is the task owner's.
+func (h *Handler) getTaskCategory(w http.ResponseWriter, r *http.Request, task *Task, id CategoryID) (*Category, bool) {
+	category, err := h.categoryRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Category not found")
+			return nil, false
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get category")
+		return nil, false
+	}
+	if category.UserID != task.UserID {
+		h.respondWithError(w, http.StatusNotFound, "Category not found")
+		return nil, false
+	}
+	return category, true
+}
+
+// hasCategory reports whether the task is in the category.
+func hasCategory(task *Task, id CategoryID) bool {
+	for _, category := range task.Categories {
+		if category.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// getTaskForCategoryChange loads the task named in the URL for an update of
+// its categories.
+func (h *Handler) getTaskForCategoryChange(w http.ResponseWriter, r *http.Request) (*Task, bool) {
+	task, ok := h.getTaskForAction(w, r, ActionUpdate)
+	if !ok {
+		return nil, false
+	}
+	if !h.checkIfMatchPresent(w, r) || !h.checkPreconditions(w, r, taskValidators(task)) {
+		return nil, false
+	}
+	return task, true
+}
+
+// changeTaskCategories applies change and responds with the updated task.
+func (h *Handler) changeTaskCategories(w http.ResponseWriter, r *http.Request, task *Task, change func(ctx context.Context) error) {
+	before := *task
+	updated, err := h.taskService.ChangeTaskCategories(r.Context(), task, change)
+	if err != nil {
+		h.respondWithTaskWriteError(w, err, "Failed to update task categories")
+		return
+	}
+	h.recordTaskRevision(r, &before, updated)
+	h.cacheInvalidator.Invalidate(h.taskCacheKeys(r.Context(), updated)...)
+	h.webhooks.Publish(r, updated.UserID, WebhookTaskUpdated, newTaskResponse(updated))
+
+	h.respondWithTask(w, r, updated)
+}
+
+func (h *Handler) respondWithTask(w http.ResponseWriter, r *http.Request, task *Task) {
+	taskValidators(task).SetHeaders(w.Header())
+	h.respond(w, r, http.StatusOK, taskPayload(r, newTaskResponse(task)))
+}
+
+// SetTaskCategories handles PUT /api/tasks/{id}/categories
+func (h *Handler) SetTaskCategories(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForCategoryChange(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetTaskCategoriesRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.CategoryIDs == nil {
+		h.respondWithError(w, http.StatusBadRequest, "categoryIds is required; send [] to remove all categories")
+		return
+	}
+
+	var ids []CategoryID
+	seen := make(map[CategoryID]bool)
+	unchanged := true
+	for _, id := range req.CategoryIDs {
+		if id == "" {
+			h.respondWithError(w, http.StatusBadRequest, "categoryIds must not contain empty IDs")
+			return
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, ok := h.getTaskCategory(w, r, task, id); !ok {
+			return
+		}
+		ids = append(ids, id)
+		unchanged = unchanged && hasCategory(task, id)
+	}
+	if unchanged && len(ids) == len(task.Categories) {
+		h.respondWithTask(w, r, task)
+		return
+	}
+
+	h.changeTaskCategories(w, r, task, func(ctx context.Context) error {
+		return h.categoryRepo.SetTaskCategories(ctx, task.ID, ids)
+	})
+}
+
+// AddTaskCategory handles POST /api/tasks/{id}/categories/{categoryId}
+func (h *Handler) AddTaskCategory(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForCategoryChange(w, r)
+	if !ok {
+		return
+	}
+	category, ok := h.getTaskCategory(w, r, task, CategoryID(mux.Vars(r)["categoryId"]))
+	if !ok {
+		return
+	}
+	if hasCategory(task, category.ID) {
+		h.respondWithTask(w, r, task)
+		return
+	}
+
+	h.changeTaskCategories(w, r, task, func(ctx context.Context) error {
+		return h.categoryRepo.AddTask(ctx, category.ID, task.ID)
+	})
+}
+
+// RemoveTaskCategory handles DELETE /api/tasks/{id}/categories/{categoryId}
+func (h *Handler) RemoveTaskCategory(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.getTaskForCategoryChange(w, r)
+	if !ok {
+		return
+	}
+	categoryID := CategoryID(mux.Vars(r)["categoryId"])
+	if !hasCategory(task, categoryID) {
+		h.respondWithError(w, http.StatusNotFound, "Category not found on task")
+		return
+	}
+
+	h.changeTaskCategories(w, r, task, func(ctx context.Context) error {
+		return h.categoryRepo.RemoveTask(ctx, categoryID, task.ID)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskCategories(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	owner := env.registerTestUser(t, "task-categories@example.com")
+	other := env.registerTestUser(t, "task-categories-other@example.com")
+
+	task, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+		CreateTaskRequest{Title: "Plan trip", Priority: "medium", CategoryNames: []string{"work"}}, owner.User.ID)
+	require.NoError(t, err)
+	work := task.Categories[0].ID
+	newCategory := func(userID UserID, name string) CategoryID {
+		category := &Category{ID: NewID[categoryEntity](), Name: name, Color: defaultCategoryColor, UserID: userID}
+		require.NoError(t, env.handler.categoryRepo.Create(ctx, category))
+		return category.ID
+	}
+	home, errands := newCategory(owner.User.ID, "home"), newCategory(owner.User.ID, "errands")
+	othersHome := newCategory(other.User.ID, "home")
+
+	serve := func(handler http.HandlerFunc, method, token string, categoryID CategoryID, body string) *httptest.ResponseRecorder {
+		path := "/api/tasks/" + task.ID.String() + "/categories"
+		vars := map[string]string{"id": task.ID.String()}
+		if categoryID != "" {
+			path += "/" + categoryID.String()
+			vars["categoryId"] = categoryID.String()
+		}
+		return env.serveWithAuth(handler, taskRequest(method, path, token, body, vars))
+	}
+	categoryNames := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TaskResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		names := []string{}
+		for _, category := range response.Categories {
+			names = append(names, category.Name)
+		}
+		return names
+	}
+
+	w := serve(env.handler.AddTaskCategory, http.MethodPost, owner.Token, home, "")
+	assert.ElementsMatch(t, []string{"work", "home"}, categoryNames(w))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Adding it again changes nothing, so the version stays the same
+	w = serve(env.handler.AddTaskCategory, http.MethodPost, owner.Token, home, "")
+	assert.ElementsMatch(t, []string{"work", "home"}, categoryNames(w))
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusNotFound, serve(env.handler.AddTaskCategory, http.MethodPost, owner.Token, othersHome, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(env.handler.AddTaskCategory, http.MethodPost, owner.Token, NewID[categoryEntity](), "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(env.handler.AddTaskCategory, http.MethodPost, other.Token, othersHome, "").Code)
+
+	// Duplicates are ignored
+	w = serve(env.handler.SetTaskCategories, http.MethodPut, owner.Token, "",
+		`{"categoryIds": ["`+errands.String()+`", "`+home.String()+`", "`+errands.String()+`"]}`)
+	assert.ElementsMatch(t, []string{"home", "errands"}, categoryNames(w))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	w = serve(env.handler.SetTaskCategories, http.MethodPut, owner.Token, "", `{"categoryIds": ["`+othersHome.String()+`"]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, http.StatusBadRequest, serve(env.handler.SetTaskCategories, http.MethodPut, owner.Token, "", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(env.handler.SetTaskCategories, http.MethodPut, owner.Token, "", `{"categoryIds": ["work"]}`).Code)
+
+	// A change based on an old version of the task is refused
+	req := taskRequest(http.MethodDelete, "/api/tasks/"+task.ID.String()+"/categories/"+home.String(), owner.Token, "",
+		map[string]string{"id": task.ID.String(), "categoryId": home.String()})
+	req.Header.Set("If-Match", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, env.serveWithAuth(env.handler.RemoveTaskCategory, req).Code)
+
+	w = serve(env.handler.RemoveTaskCategory, http.MethodDelete, owner.Token, home, "")
+	assert.Equal(t, []string{"errands"}, categoryNames(w))
+	w = serve(env.handler.RemoveTaskCategory, http.MethodDelete, owner.Token, work, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(env.handler.SetTaskCategories, http.MethodPut, owner.Token, "", `{"categoryIds": []}`)
+	assert.Empty(t, categoryNames(w))
+
+	// The categories themselves are untouched
+	categories, err := env.handler.categoryRepo.GetByUserID(ctx, owner.User.ID)
+	require.NoError(t, err)
+	assert.Len(t, categories, 3)
+
+	// Every change is in the history
+	req = taskRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/history", owner.Token, "", map[string]string{"id": task.ID.String()})
+	w = env.serveWithAuth(env.handler.GetTaskHistory, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var history struct {
+		Revisions []TaskRevision `json:"revisions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history.Revisions, 4)
+	assert.Equal(t, FieldChange{Old: []interface{}{"errands", "home"}, New: []interface{}{"errands"}}, history.Revisions[1].Changes["categories"])
+}
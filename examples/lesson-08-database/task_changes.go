@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GET /api/tasks/changes is a change feed for clients that can't keep an
+// SSE or WebSocket connection open, such as scripts, embedded devices and
+// proxies that buffer streams. Each response lists the tasks that changed
+// and the tasks and categories deleted after ?since=, with the cursor to
+// pass next; with ?wait= the request blocks
+// until something changes or the wait elapses:
+//
+//	GET /api/tasks/changes                      every task, oldest change first
+//	GET /api/tasks/changes?since=...&wait=20s   blocks until the next change
+//
+// Waiting requests poll the database every changesInterval rather than
+// listening for writes in this process, so changes made through any
+// instance are seen. Deletions are kept for TOMBSTONE_RETENTION: a client
+// whose cursor is older may have missed some and should start over.
+const (
+	defaultChangesLimit      = 100
+	maxChangesLimit          = 100
+	maxChangesWait           = 25 * time.Second
+	defaultChangesInterval   = time.Second
+	defaultMaxChangesWaiters = 100
+
+	// changesDeadlineMargin is left of the request deadline to answer in:
+	// a wait that runs into the deadline would be a 504 instead of an
+	// empty page
+	changesDeadlineMargin = 500 * time.Millisecond
+
+	// changesTimeout is the deadline of the route, in place of the default:
+	// long enough for the longest wait and under the server's 30s write
+	// timeout
+	changesTimeout = maxChangesWait + 2*time.Second
+)
+
+// ChangeCursor is a position in the change feed: the last change sent,
+// ordered by (updated_at, id) for tasks and (deleted_at, id) for
+// tombstones. It is encoded like a TaskCursor.
+type ChangeCursor struct {
+	UpdatedAt time.Time
+	ID        TaskID
+}
+
+// Encode returns the opaque form clients pass back in ?since=.
+func (c *ChangeCursor) Encode() string {
+	return (&TaskCursor{CreatedAt: c.UpdatedAt, ID: c.ID}).Encode()
+}
+
+func decodeChangeCursor(value string) (*ChangeCursor, error) {
+	cursor, err := decodeTaskCursor(value)
+	if err != nil {
+		return nil, err
+	}
+	return &ChangeCursor{UpdatedAt: cursor.CreatedAt, ID: cursor.ID}, nil
+}
+
+// TaskChangesResponse is a page of the change feed. Changes are tasks in the
+// request's API version and Deleted the tombstones of deleted tasks and
+// categories, both oldest first. With HasMore the client asks again right
+// away; otherwise it waits for the next change with ?wait=.
+type TaskChangesResponse struct {
+	Changes []interface{} `json:"changes"`
+	Deleted []*Tombstone  `json:"deleted"`
+	Cursor  string        `json:"cursor,omitempty"`
+	HasMore bool          `json:"hasMore"`
+}
+
+// parseChangesWait reads ?wait= as a duration ("20s") or in seconds ("20").
+// Waits above maxChangesWait are capped; the route's deadline of
+// changesTimeout leaves room for the longest.
+func parseChangesWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.ParseFloat(value, 64)
+		if convErr != nil {
+			return 0, fmt.Errorf("wait must be a duration such as 30s, got %q", value)
+		}
+		wait = time.Duration(seconds * float64(time.Second))
+	}
+	if wait < 0 {
+		return 0, fmt.Errorf("wait must not be negative")
+	}
+	if wait > maxChangesWait {
+		wait = maxChangesWait
+	}
+	return wait, nil
+}
+
+// GetTaskChanges handles GET /api/tasks/changes. A change is a created or
+// updated task the user can see, shared ones included, or the tombstone of
+// a task or category deleted since.
+//
+// Tasks are ordered by updated_at, the time their writing transaction
+// started, so a transaction that commits after a later one was already
+// reported can be missed. The window is as long as the longest task write,
+// milliseconds for the writes of this API.
+func (h *Handler) GetTaskChanges(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+	query := r.URL.Query()
+
+	var since *ChangeCursor
+	if value := query.Get("since"); value != "" {
+		cursor, err := decodeChangeCursor(value)
+		if err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "Invalid since cursor")
+			return
+		}
+		since = cursor
+	}
+	limit := defaultChangesLimit
+	if value := query.Get("limit"); value != "" {
+		if l, err := strconv.Atoi(value); err == nil && l > 0 && l <= maxChangesLimit {
+			limit = l
+		}
+	}
+	wait, err := parseChangesWait(query.Get("wait"))
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if deadline, ok := r.Context().Deadline(); ok {
+		wait = min(wait, time.Until(deadline)-changesDeadlineMargin)
+	}
+	waitUntil := time.Now().Add(wait)
+
+	waiting := false
+	for {
+		// One extra row tells whether there is another page
+		tasks, err := h.taskRepo.ListChangedSince(r.Context(), userID, since, limit+1)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to list changes")
+			return
+		}
+		tombstones, err := h.tombstoneRepo.ListSince(r.Context(), userID, since, limit+1)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to list changes")
+			return
+		}
+		// A draining instance answers now, so the next poll reaches another one
+		if len(tasks) > 0 || len(tombstones) > 0 || !time.Now().Before(waitUntil) || h.drainer.Draining() {
+			h.respond(w, r, http.StatusOK, newTaskChangesResponse(r, tasks, tombstones, since, limit))
+			return
+		}
+
+		if !waiting {
+			// Waiting requests hold a connection and poll the database; past
+			// the limit they answer right away instead
+			select {
+			case h.changesWaiters <- struct{}{}:
+				defer func() { <-h.changesWaiters }()
+				waiting = true
+			default:
+				h.respond(w, r, http.StatusOK, newTaskChangesResponse(r, nil, nil, since, limit))
+				return
+			}
+		}
+
+		timer := time.NewTimer(min(h.changesInterval, time.Until(waitUntil)))
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// newTaskChangesResponse merges changed tasks and tombstones, each sorted
+// the way the feed is, into a page of up to limit.
+func newTaskChangesResponse(r *http.Request, tasks []*Task, tombstones []*Tombstone, since *ChangeCursor, limit int) TaskChangesResponse {
+	response := TaskChangesResponse{Changes: []interface{}{}, Deleted: []*Tombstone{}}
+	for n := 0; len(tasks) > 0 || len(tombstones) > 0; n++ {
+		if n == limit {
+			response.HasMore = true
+			break
+		}
+		if len(tombstones) == 0 || (len(tasks) > 0 && changedBefore(tasks[0], tombstones[0])) {
+			task := tasks[0]
+			tasks = tasks[1:]
+			response.Changes = append(response.Changes, taskPayload(r, newTaskResponse(task)))
+			since = &ChangeCursor{UpdatedAt: task.UpdatedAt, ID: task.ID}
+			continue
+		}
+		tombstone := tombstones[0]
+		tombstones = tombstones[1:]
+		response.Deleted = append(response.Deleted, tombstone)
+		since = &ChangeCursor{UpdatedAt: tombstone.DeletedAt, ID: TaskID(tombstone.ID)}
+	}
+	if since != nil {
+		response.Cursor = since.Encode()
+	}
+	return response
+}
+
+// changedBefore reports whether the task's change comes before the
+// tombstone in the feed.
+func changedBefore(task *Task, tombstone *Tombstone) bool {
+	if !task.UpdatedAt.Equal(tombstone.DeletedAt) {
+		return task.UpdatedAt.Before(tombstone.DeletedAt)
+	}
+	return string(task.ID) < tombstone.ID
+}
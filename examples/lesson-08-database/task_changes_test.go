@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChangesWait(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      0,
+		"0":     0,
+		"20s":   20 * time.Second,
+		"30s":   maxChangesWait,
+		"2.5":   2500 * time.Millisecond,
+		"500ms": 500 * time.Millisecond,
+		"10m":   maxChangesWait,
+	} {
+		wait, err := parseChangesWait(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, wait, value)
+	}
+
+	for _, value := range []string{"soon", "-1s", "-3"} {
+		_, err := parseChangesWait(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestChangeCursor(t *testing.T) {
+	cursor := &ChangeCursor{UpdatedAt: time.Date(2026, 10, 16, 12, 0, 0, 123456000, time.UTC), ID: NewID[taskEntity]()}
+	decoded, err := decodeChangeCursor(cursor.Encode())
+	require.NoError(t, err)
+	assert.True(t, cursor.UpdatedAt.Equal(decoded.UpdatedAt))
+	assert.Equal(t, cursor.ID, decoded.ID)
+
+	_, err = decodeChangeCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+func TestNewTaskChangesResponse(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	id := func(name string) string { return "00000000-0000-0000-0000-00000000000" + name }
+	task := func(name string, minute int) *Task {
+		return &Task{ID: TaskID(id(name)), Title: name, Priority: "medium", UpdatedAt: at.Add(time.Duration(minute) * time.Minute)}
+	}
+	tombstone := func(name string, minute int) *Tombstone {
+		return &Tombstone{Type: tombstoneTask, ID: id(name), DeletedAt: at.Add(time.Duration(minute) * time.Minute)}
+	}
+	r := httptest.NewRequest("GET", "/api/tasks/changes", nil)
+
+	tasks := []*Task{task("a", 1), task("c", 2), task("e", 4)}
+	tombstones := []*Tombstone{tombstone("b", 2), tombstone("d", 3)}
+	response := newTaskChangesResponse(r, tasks, tombstones, nil, 3)
+	assert.Len(t, response.Changes, 2)
+	assert.Equal(t, []*Tombstone{tombstones[0]}, response.Deleted)
+	assert.True(t, response.HasMore)
+	// Same time: ordered by ID, so b comes before c
+	cursor, err := decodeChangeCursor(response.Cursor)
+	require.NoError(t, err)
+	assert.Equal(t, TaskID(id("c")), cursor.ID)
+
+	response = newTaskChangesResponse(r, tasks, tombstones, nil, 5)
+	assert.Len(t, response.Changes, 3)
+	assert.Equal(t, tombstones, response.Deleted)
+	assert.False(t, response.HasMore)
+	cursor, err = decodeChangeCursor(response.Cursor)
+	require.NoError(t, err)
+	assert.Equal(t, TaskID(id("e")), cursor.ID)
+
+	// An empty page keeps the cursor it was asked with
+	since := &ChangeCursor{UpdatedAt: at, ID: TaskID(id("a"))}
+	response = newTaskChangesResponse(r, nil, nil, since, 5)
+	assert.Empty(t, response.Changes)
+	assert.NotNil(t, response.Deleted)
+	assert.Equal(t, since.Encode(), response.Cursor)
+}
+
+// TestTaskChanges follows the change feed the way a client does: a first
+// page without a cursor, then long polls with the cursor of the last one.
+func TestTaskChanges(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.handler.changesInterval = 10 * time.Millisecond
+	user := env.registerTestUser(t, "changes@example.com")
+	other := env.registerTestUser(t, "changes-other@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	poll := func(query string) (TaskChangesResponse, time.Duration) {
+		req := httptest.NewRequest("GET", "/api/tasks/changes?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("X-Request-Timeout", "5s")
+		w := httptest.NewRecorder()
+		start := time.Now()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		var response TaskChangesResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response, time.Since(start)
+	}
+	titles := func(response TaskChangesResponse) []string {
+		var titles []string
+		for _, change := range response.Changes {
+			titles = append(titles, change.(map[string]interface{})["title"].(string))
+		}
+		return titles
+	}
+
+	for _, title := range []string{"First", "Second", "Third"} {
+		require.Equal(t, http.StatusCreated, env.createTaskAs(user.Token, title).Code)
+	}
+	require.Equal(t, http.StatusCreated, env.createTaskAs(other.Token, "Not mine").Code)
+
+	page, _ := poll("limit=2")
+	assert.Equal(t, []string{"First", "Second"}, titles(page))
+	assert.True(t, page.HasMore)
+	page, _ = poll("limit=2&since=" + page.Cursor)
+	assert.Equal(t, []string{"Third"}, titles(page))
+	assert.False(t, page.HasMore)
+
+	// Nothing new: the wait elapses and the cursor stays
+	empty, elapsed := poll("wait=200ms&since=" + page.Cursor)
+	assert.Empty(t, empty.Changes)
+	assert.Equal(t, page.Cursor, empty.Cursor)
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+
+	// A change while waiting ends the wait
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		env.createTaskAs(user.Token, "Fourth")
+	}()
+	changed, elapsed := poll("wait=3s&since=" + page.Cursor)
+	assert.Equal(t, []string{"Fourth"}, titles(changed))
+	assert.Less(t, elapsed, 3*time.Second)
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"since=bogus", "wait=soon"} {
+			req := httptest.NewRequest("GET", "/api/tasks/changes?"+query, nil)
+			req.Header.Set("Authorization", "Bearer "+user.Token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+		}
+	})
+}
+
+// Long polls have a deadline of their own: the server's default would end
+// every wait=30s early.
+func TestTaskChangesOutlastDefaultDeadline(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.handler.changesInterval = 10 * time.Millisecond
+	user := env.registerTestUser(t, "changes-deadline@example.com")
+
+	config := loadConfig()
+	config.RequestTimeout = 200 * time.Millisecond
+	config.MaxRequestTimeout = 200 * time.Millisecond
+	router, err := newRouter(config, env.handler, env.db)
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(time.Second)
+		env.createTaskAs(user.Token, "Late")
+	}()
+	req := httptest.NewRequest("GET", "/api/tasks/changes?wait=30s", nil)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response TaskChangesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Changes, 1, "still waiting when the task was created")
+	assert.Equal(t, "Late", response.Changes[0].(map[string]interface{})["title"])
+}
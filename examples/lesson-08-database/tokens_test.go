@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenValidationMatrix runs tokens with expired, early, skewed, foreign
+// and wrongly signed claims through ValidateToken and the auth middleware,
+// with the service's clock fixed and the default options.
+func TestTokenValidationMatrix(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	jwtService := NewJWTService("test-secret")
+	jwtService.now = func() time.Time { return now }
+
+	// An RS256 service, to check HS256 tokens can't be signed with its
+	// public key (key confusion)
+	rsaService := NewJWTService("test-secret")
+	rsaService.now = jwtService.now
+	rsaKey := testRSAKey(t)
+	rsaService.UseRSAKey(rsaKey)
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	// claims are valid for an access token issued at issuedAt by a server
+	// whose clock reads issuedAt
+	claims := func(issuedAt time.Time) JWTClaims {
+		return JWTClaims{UserID: "user-1", Email: "matrix@example.com", Role: RoleUser, RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-1",
+			Issuer:    defaultJWTIssuer,
+			Audience:  jwt.ClaimStrings{defaultJWTAudience},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(defaultAccessTokenTTL)),
+		}}
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, claims JWTClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	hs256 := func(claims JWTClaims) string { return sign(jwt.SigningMethodHS256, []byte("test-secret"), claims) }
+	with := func(c JWTClaims, change func(*JWTClaims)) JWTClaims {
+		change(&c)
+		return c
+	}
+
+	rsaToken, err := rsaService.signToken(claims(now))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		service *JWTService
+		token   string
+		wantErr error // nil for a valid token
+	}{
+		{name: "valid", token: hs256(claims(now))},
+		{name: "expired", token: hs256(claims(now.Add(-defaultAccessTokenTTL - time.Minute))), wantErr: jwt.ErrTokenExpired},
+		{name: "expired within the clock skew", token: hs256(claims(now.Add(-defaultAccessTokenTTL - 10*time.Second)))},
+		{name: "without expiry", token: hs256(with(claims(now), func(c *JWTClaims) { c.ExpiresAt = nil })), wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "not yet valid", token: hs256(with(claims(now), func(c *JWTClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) })), wantErr: jwt.ErrTokenNotValidYet},
+		{name: "valid within the clock skew", token: hs256(with(claims(now), func(c *JWTClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second)) }))},
+		// Tokens from an issuer whose clock is ahead of ours
+		{name: "issuer clock slightly ahead", token: hs256(claims(now.Add(10 * time.Second)))},
+		{name: "issuer clock far ahead", token: hs256(claims(now.Add(5 * time.Minute))), wantErr: jwt.ErrTokenUsedBeforeIssued},
+		// And behind: the token expires early, but is otherwise fine
+		{name: "issuer clock behind", token: hs256(claims(now.Add(-5 * time.Minute)))},
+		{name: "other issuer", token: hs256(with(claims(now), func(c *JWTClaims) { c.Issuer = "other" })), wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "without issuer", token: hs256(with(claims(now), func(c *JWTClaims) { c.Issuer = "" })), wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "other audience", token: hs256(with(claims(now), func(c *JWTClaims) { c.Audience = jwt.ClaimStrings{"other-api"} })), wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "one of several audiences", token: hs256(with(claims(now), func(c *JWTClaims) { c.Audience = jwt.ClaimStrings{"other-api", defaultJWTAudience} }))},
+		{name: "without jti", token: hs256(with(claims(now), func(c *JWTClaims) { c.ID = "" })), wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "wrong secret", token: sign(jwt.SigningMethodHS256, []byte("other-secret"), claims(now)), wantErr: jwt.ErrTokenSignatureInvalid},
+		{name: "alg none", token: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(now)), wantErr: jwt.ErrTokenSignatureInvalid},
+		{name: "HS512 with the right secret", token: sign(jwt.SigningMethodHS512, []byte("test-secret"), claims(now)), wantErr: jwt.ErrTokenSignatureInvalid},
+		{name: "RS256 against an HS256 service", token: sign(jwt.SigningMethodRS256, testRSAKey(t), claims(now)), wantErr: jwt.ErrTokenUnverifiable},
+		{name: "RS256 from the active key", service: rsaService, token: rsaToken},
+		{name: "HS256 signed with the RSA public key", service: rsaService, token: sign(jwt.SigningMethodHS256, publicPEM, claims(now)), wantErr: jwt.ErrTokenSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := tt.service
+			if service == nil {
+				service = jwtService
+			}
+			_, err := service.ValidateToken(tt.token)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+
+			// The middleware lets exactly the valid tokens through
+			protected := authMiddleware(service, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			protected.ServeHTTP(w, req)
+			if tt.wantErr == nil {
+				assert.Equal(t, http.StatusNoContent, w.Code)
+			} else {
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+			}
+		})
+	}
+}
+
+func TestGeneratedTokensUseServiceClock(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	jwtService := NewJWTService("test-secret")
+	jwtService.now = func() time.Time { return now }
+
+	token, err := jwtService.GenerateToken(&User{ID: "user-1", Email: "clock@example.com", Role: RoleUser})
+	require.NoError(t, err)
+	claims, err := jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, now, claims.IssuedAt.Time.UTC())
+	assert.Equal(t, now.Add(defaultAccessTokenTTL), claims.ExpiresAt.Time.UTC())
+	assert.Equal(t, now, claims.NotBefore.Time.UTC())
+	assert.Equal(t, defaultJWTIssuer, claims.Issuer)
+	assert.Equal(t, jwt.ClaimStrings{defaultJWTAudience}, claims.Audience)
+	assert.NotEmpty(t, claims.ID)
+
+	// Once the clock passes expiry and the skew, the token is rejected
+	now = now.Add(defaultAccessTokenTTL + defaultClockSkew + time.Second)
+	_, err = jwtService.ValidateToken(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
+
+func TestJWTServiceOptions(t *testing.T) {
+	options := DefaultJWTOptions()
+	options.AccessTTL = time.Minute
+	options.Issuer = "https://auth.example.com"
+	options.Audience = "reports-api"
+	reports := NewJWTServiceWithOptions("test-secret", options)
+
+	token, err := reports.GenerateToken(&User{ID: "user-1", Email: "options@example.com", Role: RoleUser})
+	require.NoError(t, err)
+	claims, err := reports.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com", claims.Issuer)
+	assert.Equal(t, time.Minute, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+
+	// A service with the same secret but another audience rejects it
+	_, err = NewJWTService("test-secret").ValidateToken(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+}
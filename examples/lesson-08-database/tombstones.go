@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Tombstones record deleted tasks and categories, so clients that keep
+// copies learn about deletions: the change feed lists them next to changed
+// tasks, and delta sync lists deleted task IDs from its change log. Both are
+// kept for tombstoneRetention and then compacted away; a client that was
+// away longer starts over.
+const (
+	defaultTombstoneRetention   = 30 * 24 * time.Hour
+	tombstoneCompactionInterval = time.Hour
+
+	tombstoneTask     = "task"
+	tombstoneCategory = "category"
+
+	// ResyncRequired answers a sync token whose deletions were compacted
+	ResyncRequired = "resync_required"
+)
+
+// Tombstone is a deleted task or category.
+type Tombstone struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// tombstoneVisibleTo lists who else could see a deleted row s: the users a
+// task was shared with. The statement sees the rows as they were before the
+// delete cascaded to them.
+var tombstoneVisibleTo = map[string]string{
+	tombstoneTask:     "ARRAY(SELECT tc.user_id FROM task_collaborators tc WHERE tc.task_id = s.id)",
+	tombstoneCategory: "'{}'::uuid[]",
+}
+
+// recordTombstones returns the common table expression that records the
+// rows of source, an earlier expression returning their id and user_id, as
+// deleted.
+func recordTombstones(source, resourceType string) string {
+	return fmt.Sprintf(`tombstone AS (
+			INSERT INTO tombstones (resource_type, resource_id, user_id, visible_to)
+			SELECT '%[2]s', s.id, s.user_id, %[3]s FROM %[1]s s
+			ON CONFLICT (resource_type, resource_id) DO UPDATE
+			SET user_id = EXCLUDED.user_id, visible_to = EXCLUDED.visible_to, deleted_at = CURRENT_TIMESTAMP
+		)`, source, resourceType, tombstoneVisibleTo[resourceType])
+}
+
+type TombstoneRepository interface {
+	// ListSince returns the tombstones the user can see after the cursor,
+	// ordered by (deleted_at, id); a nil cursor starts at the beginning
+	ListSince(ctx context.Context, userID UserID, since *ChangeCursor, limit int) ([]*Tombstone, error)
+	// Exists reports whether there is a tombstone for the row
+	Exists(ctx context.Context, resourceType, id string) (bool, error)
+	// Compact removes the tombstones of deletions before cutoff
+	Compact(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type tombstoneRepository struct {
+	db *sql.DB
+}
+
+func NewTombstoneRepository(db *sql.DB) TombstoneRepository {
+	return &tombstoneRepository{db: db}
+}
+
+func (r *tombstoneRepository) ListSince(ctx context.Context, userID UserID, since *ChangeCursor, limit int) ([]*Tombstone, error) {
+	query := `
+		SELECT resource_type, resource_id, deleted_at FROM tombstones
+		WHERE (user_id = $1 OR $1 = ANY(visible_to))`
+	args := []interface{}{userID}
+	if since != nil {
+		query += " AND (deleted_at, resource_id) > ($2, $3)"
+		args = append(args, since.UpdatedAt, since.ID)
+	}
+	query += fmt.Sprintf(" ORDER BY deleted_at, resource_id LIMIT $%d", len(args)+1)
+	args = append(args, limit)
+
+	rows, err := conn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var tombstones []*Tombstone
+	for rows.Next() {
+		tombstone := &Tombstone{}
+		if err := rows.Scan(&tombstone.Type, &tombstone.ID, &tombstone.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, rows.Err()
+}
+
+func (r *tombstoneRepository) Exists(ctx context.Context, resourceType, id string) (bool, error) {
+	var exists bool
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM tombstones WHERE resource_type = $1 AND resource_id = $2)`,
+		resourceType, id).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check tombstone: %w", err)
+	}
+	return exists, nil
+}
+
+func (r *tombstoneRepository) Compact(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := conn(ctx, r.db).ExecContext(ctx, `DELETE FROM tombstones WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact tombstones: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// CompactTombstones removes the tombstones and change log deletions older
+// than tombstoneRetention, and the change log entries that later ones for
+// the same task supersede.
+func (h *Handler) CompactTombstones(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-h.tombstoneRetention)
+	tombstones, err := h.tombstoneRepo.Compact(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := h.syncRepo.Compact(ctx, cutoff)
+	if err != nil {
+		return tombstones, err
+	}
+	return tombstones + entries, nil
+}
+
+// startTombstoneCompaction compacts tombstones every interval until ctx is
+// done.
+func (h *Handler) startTombstoneCompaction(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				compacted, err := h.CompactTombstones(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "tombstone compaction failed", "error", err)
+				}
+				if compacted > 0 {
+					slog.InfoContext(ctx, "compacted tombstones and change log entries", "count", compacted)
+				}
+			}
+		}
+	}()
+}
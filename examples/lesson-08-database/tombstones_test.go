@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTombstones(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	ctx := context.Background()
+	owner := env.registerTestUser(t, "tombstones@example.com")
+	friend := env.registerTestUser(t, "tombstones-friend@example.com")
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(token, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	changes := func(token string) TaskChangesResponse {
+		w := serve(token, "GET", "/api/tasks/changes")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TaskChangesResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	task, err := env.handler.taskService.CreateTaskWithCategories(ctx,
+		CreateTaskRequest{Title: "Shared, then deleted", Priority: "medium"}, owner.User.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, env.shareTask(owner, task.ID, "tombstones-friend@example.com", ShareRead).Code)
+	category := &Category{ID: NewID[categoryEntity](), Name: "Deleted", Color: "#000000", UserID: owner.User.ID}
+	require.NoError(t, env.handler.categoryRepo.Create(ctx, category))
+
+	sync := pullSync(t, router, owner.Token, "")
+	require.Equal(t, http.StatusNoContent, serve(owner.Token, "DELETE", "/api/tasks/"+task.ID.String()).Code)
+	require.Equal(t, http.StatusNoContent, serve(owner.Token, "DELETE", "/api/categories/"+category.ID.String()).Code)
+
+	// Both deletions reach the owner, the task's also the user it was shared with
+	feed := changes(owner.Token)
+	require.Len(t, feed.Deleted, 2)
+	assert.Equal(t, Tombstone{Type: tombstoneTask, ID: task.ID.String(), DeletedAt: feed.Deleted[0].DeletedAt}, *feed.Deleted[0])
+	assert.Equal(t, tombstoneCategory, feed.Deleted[1].Type)
+	assert.Equal(t, category.ID.String(), feed.Deleted[1].ID)
+	shared := changes(friend.Token)
+	require.Len(t, shared.Deleted, 1)
+	assert.Equal(t, task.ID.String(), shared.Deleted[0].ID)
+
+	// Nothing is compacted within the retention
+	compacted, err := env.handler.CompactTombstones(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, compacted)
+	assert.Len(t, changes(owner.Token).Deleted, 2)
+	assert.Equal(t, http.StatusOK, serve(owner.Token, "GET", "/api/tasks/sync?token="+sync.Token).Code)
+
+	t.Run("compaction", func(t *testing.T) {
+		_, err := env.db.ExecContext(ctx, `UPDATE tombstones SET deleted_at = deleted_at - INTERVAL '31 days'`)
+		require.NoError(t, err)
+		_, err = env.db.ExecContext(ctx, `UPDATE task_change_log SET changed_at = changed_at - INTERVAL '31 days'`)
+		require.NoError(t, err)
+
+		compacted, err := env.handler.CompactTombstones(ctx)
+		require.NoError(t, err)
+		assert.Positive(t, compacted)
+		assert.Empty(t, changes(owner.Token).Deleted)
+		assert.Empty(t, changes(friend.Token).Deleted)
+
+		// The token is from before the compacted deletion
+		w := serve(owner.Token, "GET", "/api/tasks/sync?token="+sync.Token)
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Equal(t, ResyncRequired, errorCode(t, w))
+
+		// Starting over works, and so does the new token
+		fresh := pullSync(t, router, owner.Token, "")
+		assert.Empty(t, fresh.Created)
+		pullSync(t, router, owner.Token, "token="+fresh.Token)
+	})
+}
+
+func pullSync(t *testing.T, router http.Handler, token, query string) SyncResponse {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/tasks/sync?"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response SyncResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
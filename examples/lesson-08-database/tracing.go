@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"correlation"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records a request as a tree of spans: the handler's span, the
+// service calls it makes and every SQL statement they run, exported over
+// OTLP to a collector such as Jaeger or Tempo. The spans reuse the trace and
+// span IDs correlation.Middleware gave the request, so the trace_id of a log
+// line finds its trace and the traceparent sent to other services names the
+// exported span.
+//
+// Until setupTracing installs a provider the tracer is a no-op, and so are
+// the spans of tests and of servers without OTEL_EXPORTER_OTLP_ENDPOINT.
+var tracer = otel.Tracer("lesson-08-database")
+
+// TracingConfig enables tracing when Endpoint is set. The exporter reads the
+// other standard OTEL_EXPORTER_OTLP_* variables, such as headers, itself.
+type TracingConfig struct {
+	Endpoint    string
+	ServiceName string
+	// SampleRatio of traces is recorded, from 0 to 1
+	SampleRatio float64
+}
+
+// setupTracing installs the OTLP exporter. The returned function flushes the
+// spans not yet exported; call it on shutdown.
+func setupTracing(ctx context.Context, config TracingConfig) (func(context.Context) error, error) {
+	if config.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+	// Traces continued from a caller are sampled at the same ratio: the
+	// caching proxy of lesson 10 sends a traceparent but exports nothing
+	ratio := sdktrace.TraceIDRatioBased(config.SampleRatio)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(ratio, sdktrace.WithRemoteParentSampled(ratio))),
+		sdktrace.WithIDGenerator(correlationIDs{}),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// correlationIDs gives the span of a request the IDs in its correlation
+// context. Every other span gets random IDs.
+type correlationIDs struct{}
+
+// NewIDs is asked for the IDs of a span without a parent: a request that
+// started a new trace.
+func (correlationIDs) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if ids, ok := correlation.FromContext(ctx); ok {
+		traceID, traceErr := trace.TraceIDFromHex(ids.TraceID)
+		spanID, spanErr := trace.SpanIDFromHex(ids.SpanID)
+		if traceErr == nil && spanErr == nil {
+			return traceID, spanID
+		}
+	}
+	var traceID trace.TraceID
+	for !traceID.IsValid() {
+		rand.Read(traceID[:])
+	}
+	return traceID, randomSpanID()
+}
+
+// NewSpanID is asked for the ID of a span with a parent. Only the span of a
+// request continuing its caller's trace has a remote one, set by
+// tracingMiddleware.
+func (correlationIDs) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	parent := trace.SpanContextFromContext(ctx)
+	if ids, ok := correlation.FromContext(ctx); ok && parent.IsRemote() && parent.SpanID().String() == ids.ParentSpanID {
+		if spanID, err := trace.SpanIDFromHex(ids.SpanID); err == nil {
+			return spanID
+		}
+	}
+	return randomSpanID()
+}
+
+func randomSpanID() trace.SpanID {
+	var spanID trace.SpanID
+	for !spanID.IsValid() {
+		rand.Read(spanID[:])
+	}
+	return spanID
+}
+
+// tracingMiddleware starts the span of a request, named after its route so
+// requests for different tasks group together. It is router middleware,
+// since unmatched requests have no route; they aren't traced.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if ids, ok := correlation.FromContext(ctx); ok && ids.ParentSpanID != "" {
+			traceID, traceErr := trace.TraceIDFromHex(ids.TraceID)
+			parentID, parentErr := trace.SpanIDFromHex(ids.ParentSpanID)
+			if traceErr == nil && parentErr == nil {
+				ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID:    traceID,
+					SpanID:     parentID,
+					TraceFlags: trace.FlagsSampled,
+					Remote:     true,
+				}))
+			}
+		}
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String(string(semconv.HTTPRequestMethodKey), r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			))
+		defer span.End()
+
+		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(ww.statusCode))
+		if ww.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(ww.statusCode))
+		}
+	})
+}
+
+// spanError records err on span and returns it, for the error returns of
+// traced functions.
+func spanError(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
+
+// tracedConnector wraps the Postgres connector so every statement run with
+// a context inside a traced request gets a span. Statements outside of one,
+// such as the metrics refresh, aren't traced, and neither are prepared
+// statements, which the repositories don't use.
+type tracedConnector struct {
+	driver.Connector
+}
+
+func (c tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn}, nil
+}
+
+// tracedConn passes everything on to the driver's connection, which
+// implements all of the optional interfaces below.
+type tracedConn struct {
+	driver.Conn
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := startSQLSpan(ctx, query)
+	if span == nil {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	defer span.End()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil && err != driver.ErrSkip {
+		spanError(span, err)
+	}
+	return rows, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := startSQLSpan(ctx, query)
+	if span == nil {
+		return execer.ExecContext(ctx, query, args)
+	}
+	defer span.End()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != nil && err != driver.ErrSkip {
+		spanError(span, err)
+	}
+	return result, err
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// startSQLSpan starts the span of a statement, named after its operation
+// such as SELECT, or returns a nil span outside of a recorded trace.
+func startSQLSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ctx, nil
+	}
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	operation = strings.ToUpper(operation)
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(statement),
+		))
+}
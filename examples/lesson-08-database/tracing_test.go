@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"correlation"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+var (
+	spanExporter    = tracetest.NewInMemoryExporter()
+	installExporter sync.Once
+)
+
+// recordSpans installs a provider exporting to memory. The tracer delegates
+// to the first provider installed, so every test shares it.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	installExporter.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(
+			sdktrace.WithSyncer(spanExporter),
+			sdktrace.WithIDGenerator(correlationIDs{}),
+		))
+	})
+	spanExporter.Reset()
+	t.Cleanup(spanExporter.Reset)
+	return spanExporter
+}
+
+func tracedRouter(handler http.HandlerFunc) http.Handler {
+	router := mux.NewRouter()
+	router.Use(tracingMiddleware)
+	router.HandleFunc("/api/tasks/{id}", handler).Methods("GET")
+	return correlation.Middleware(router)
+}
+
+func TestTracingMiddlewareSharesCorrelationIDs(t *testing.T) {
+	exporter := recordSpans(t)
+	var ids correlation.IDs
+	router := tracedRouter(func(w http.ResponseWriter, r *http.Request) {
+		ids, _ = correlation.FromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tasks/task-1", nil))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /api/tasks/{id}", span.Name, "named after the route")
+	assert.Equal(t, ids.TraceID, span.SpanContext.TraceID().String())
+	assert.Equal(t, ids.SpanID, span.SpanContext.SpanID().String())
+	assert.False(t, span.Parent.IsValid())
+	assert.Equal(t, codes.Error, span.Status.Code)
+}
+
+func TestTracingMiddlewareContinuesCallersTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	var ids correlation.IDs
+	router := tracedRouter(func(w http.ResponseWriter, r *http.Request) {
+		ids, _ = correlation.FromContext(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/task-1", nil)
+	req.Header.Set(correlation.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	assert.Equal(t, ids.SpanID, span.SpanContext.SpanID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.True(t, span.Parent.IsRemote())
+	assert.Equal(t, codes.Unset, span.Status.Code)
+}
+
+func TestTracingMiddlewareSkipsUnmatchedRequests(t *testing.T) {
+	exporter := recordSpans(t)
+	router := tracedRouter(func(w http.ResponseWriter, r *http.Request) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Empty(t, exporter.GetSpans())
+}
+
+type fakeConn struct {
+	driver.Conn
+	queries []string
+	err     error
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	return nil, c.err
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	return driver.RowsAffected(1), c.err
+}
+
+func TestTracedConn(t *testing.T) {
+	exporter := recordSpans(t)
+	fake := &fakeConn{}
+	conn := &tracedConn{Conn: fake}
+
+	_, err := conn.QueryContext(context.Background(), "SELECT 1", nil)
+	require.NoError(t, err)
+	assert.Empty(t, exporter.GetSpans(), "statements outside of a trace have no span")
+
+	ctx, parent := tracer.Start(context.Background(), "TaskService.CreateTaskWithCategories")
+	_, err = conn.QueryContext(ctx, "select id, title\n\t\tFROM tasks WHERE id = $1", nil)
+	require.NoError(t, err)
+	fake.err = errors.New("duplicate key")
+	_, err = conn.ExecContext(ctx, "INSERT INTO tasks (id) VALUES ($1)", nil)
+	assert.Error(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	query, exec := spans[0], spans[1]
+	assert.Equal(t, "SELECT", query.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent.SpanID())
+	assert.Contains(t, query.Attributes, semconv.DBQueryText("select id, title FROM tasks WHERE id = $1"))
+	assert.Equal(t, "INSERT", exec.Name)
+	assert.Equal(t, codes.Error, exec.Status.Code)
+	assert.Len(t, fake.queries, 3)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errInjected = errors.New("injected fault")
+
+// A fault replaces a repository write; create performs the real one.
+type fault func(create func() error) error
+
+var (
+	failAfterWrite fault = func(create func() error) error {
+		if err := create(); err != nil {
+			return err
+		}
+		return errInjected
+	}
+	// skipWrite reports success without writing anything
+	skipWrite fault = func(create func() error) error {
+		return nil
+	}
+	panicAfterWrite fault = func(create func() error) error {
+		if err := create(); err != nil {
+			return err
+		}
+		panic(errInjected)
+	}
+)
+
+// faultyTaskRepository injects fault into Create
+type faultyTaskRepository struct {
+	TaskRepository
+	fault fault
+}
+
+func (r *faultyTaskRepository) Create(ctx context.Context, task *Task) error {
+	create := func() error { return r.TaskRepository.Create(ctx, task) }
+	if r.fault == nil {
+		return create()
+	}
+	return r.fault(create)
+}
+
+// faultyCategoryRepository injects fault into the failOn-th call of Create
+type faultyCategoryRepository struct {
+	CategoryRepository
+	failOn int
+	fault  fault
+	calls  int
+}
+
+func (r *faultyCategoryRepository) Create(ctx context.Context, category *Category) error {
+	r.calls++
+	create := func() error { return r.CategoryRepository.Create(ctx, category) }
+	if r.calls != r.failOn {
+		return create()
+	}
+	return r.fault(create)
+}
+
+// countUserRows counts what a user has in each table CreateTaskWithCategories
+// writes to.
+func (env *testEnv) countUserRows(t *testing.T, userID UserID) (tasks, categories, links int) {
+	ctx := context.Background()
+	require.NoError(t, env.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tasks WHERE user_id = $1", userID).Scan(&tasks))
+	require.NoError(t, env.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM categories WHERE user_id = $1", userID).Scan(&categories))
+	require.NoError(t, env.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM task_categories tc
+		LEFT JOIN tasks t ON t.id = tc.task_id
+		LEFT JOIN categories c ON c.id = tc.category_id
+		WHERE t.user_id = $1 OR c.user_id = $1`, userID).Scan(&links))
+	return tasks, categories, links
+}
+
+func TestCreateTaskWithCategoriesRollsBack(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	req := CreateTaskRequest{Title: "Atomic", Priority: "medium", CategoryNames: []string{"work", "home", "errands"}}
+
+	tests := []struct {
+		name           string
+		taskFault      fault
+		categoryFailOn int
+		categoryFault  fault
+		wantErr        error
+		wantPanic      bool
+	}{
+		{name: "task insert fails after writing", taskFault: failAfterWrite, wantErr: errInjected},
+		{name: "first category fails", categoryFailOn: 1, categoryFault: failAfterWrite, wantErr: errInjected},
+		{name: "later category fails after a link", categoryFailOn: 3, categoryFault: failAfterWrite, wantErr: errInjected},
+		// The category is never written, so linking to it violates the foreign key
+		{name: "link fails", categoryFailOn: 2, categoryFault: skipWrite},
+		{name: "panic mid-way", categoryFailOn: 2, categoryFault: panicAfterWrite, wantPanic: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := env.registerTestUser(t, fmt.Sprintf("rollback%d@example.com", i))
+			service := NewTaskService(
+				&faultyTaskRepository{TaskRepository: env.handler.taskRepo, fault: tt.taskFault},
+				&faultyCategoryRepository{CategoryRepository: env.handler.categoryRepo, failOn: tt.categoryFailOn, fault: tt.categoryFault},
+				env.db.DB,
+			)
+
+			create := func() error {
+				_, err := service.CreateTaskWithCategories(context.Background(), req, user.User.ID)
+				return err
+			}
+			if tt.wantPanic {
+				assert.PanicsWithValue(t, errInjected, func() { create() })
+			} else if err := create(); tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.Error(t, err)
+			}
+
+			tasks, categories, links := env.countUserRows(t, user.User.ID)
+			assert.Zero(t, tasks, "orphan tasks")
+			assert.Zero(t, categories, "orphan categories")
+			assert.Zero(t, links, "orphan links")
+		})
+	}
+
+	// The same wrappers without a fault commit everything
+	user := env.registerTestUser(t, "rollback-none@example.com")
+	service := NewTaskService(
+		&faultyTaskRepository{TaskRepository: env.handler.taskRepo},
+		&faultyCategoryRepository{CategoryRepository: env.handler.categoryRepo},
+		env.db.DB,
+	)
+	task, err := service.CreateTaskWithCategories(context.Background(), req, user.User.ID)
+	require.NoError(t, err)
+	assert.Len(t, task.Categories, 3)
+	tasks, categories, links := env.countUserRows(t, user.User.ID)
+	assert.Equal(t, []int{1, 3, 3}, []int{tasks, categories, links})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"respond"
+)
+
+// API versions. A client picks one with the path (/api/v2/tasks) or, on the
+// unversioned paths, with the Accept header:
+//
+//	Accept: application/vnd.task-api.v2+json
+//	Accept: application/json; version=2
+//
+// The path wins over the header. Unversioned requests without either get
+// the current default: v1 payloads, enveloped when RESPONSE_ENVELOPE=true.
+// Every version is served by the same routes and handlers; the handlers
+// only differ in how they map a task to and from JSON (see taskPayload).
+//
+//   - v1: the legacy shapes, without envelopes. Deprecated: responses carry
+//     Deprecation, Sunset (API_V1_SUNSET) and a successor-version link
+//   - v2: tasks have a status ("open" or "completed") instead of the
+//     completed flag, so more states can follow without another break
+const (
+	APIVersion1 = "v1"
+	APIVersion2 = "v2"
+
+	// APIVersionHeader names the version a response was served in
+	APIVersionHeader = "API-Version"
+	// APIVersionUnsupported answers an Accept header asking for an unknown
+	// version
+	APIVersionUnsupported = "api_version_unsupported"
+	// FieldRemoved rejects a request field that a newer version replaced
+	FieldRemoved = "field_removed"
+)
+
+// apiVersions are the versions with a path prefix, oldest first
+var apiVersions = []string{APIVersion1, APIVersion2}
+
+// apiV1DeprecatedAt is when v2 was released and v1 deprecated
+var apiV1DeprecatedAt = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// vendorMediaType matches the versioned media type of the API
+var vendorMediaType = regexp.MustCompile(`^application/vnd\.task-api\.v(\d+)\+json$`)
+
+// apiVersion is the version a request was made in; empty for unversioned
+// requests.
+func apiVersion(r *http.Request) string {
+	version, _ := r.Context().Value(apiVersionKey).(string)
+	return version
+}
+
+// apiVersionHandler resolves the API version of each request and serves
+// /api/{version}/... as /api/..., marked with the version. /api/v1 is only
+// served while legacyV1 is set; sunset can be zero when no date is set yet.
+func apiVersionHandler(next http.Handler, legacyV1 bool, sunset time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path != "/api" && !strings.HasPrefix(path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		version, rest := versionFromPath(path)
+		if version == APIVersion1 && !legacyV1 {
+			version = ""
+		}
+		if version == "" {
+			// Same URL, different representation: caches must key on Accept
+			w.Header().Add("Vary", "Accept")
+			var err error
+			if version, err = versionFromAccept(r.Header.Values("Accept")); err != nil {
+				respond.JSON(w, http.StatusNotAcceptable, ErrorResponse{
+					Error:     http.StatusText(http.StatusNotAcceptable),
+					Message:   err.Error(),
+					Code:      APIVersionUnsupported,
+					RequestID: requestID(w),
+					Details:   map[string][]string{"versions": supportedVersions(legacyV1)},
+				})
+				return
+			}
+		} else {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/api"
+			if rest != "" {
+				r.URL.Path += "/" + rest
+			}
+			r.URL.RawPath = ""
+		}
+
+		if version != "" {
+			r = r.WithContext(context.WithValue(r.Context(), apiVersionKey, version))
+			w.Header().Set(APIVersionHeader, version)
+		}
+		if version == APIVersion1 {
+			setDeprecationHeaders(w, r, sunset)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// versionFromPath splits /api/v2/tasks into v2 and tasks. Paths without a
+// known version return an empty version.
+func versionFromPath(path string) (version, rest string) {
+	for _, v := range apiVersions {
+		if path == "/api/"+v {
+			return v, ""
+		}
+		if rest, ok := strings.CutPrefix(path, "/api/"+v+"/"); ok {
+			return v, rest
+		}
+	}
+	return "", ""
+}
+
+// versionFromAccept finds a version in the Accept header. Media ranges
+// without one, such as application/json or */*, leave the choice to the
+// server; a version that doesn't exist is an error rather than a silent
+// fallback the client would misread.
+func versionFromAccept(accept []string) (string, error) {
+	for _, value := range accept {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			var number string
+			if match := vendorMediaType.FindStringSubmatch(mediaType); match != nil {
+				number = match[1]
+			} else if mediaType == "application/json" && params["version"] != "" {
+				number = strings.TrimPrefix(params["version"], "v")
+			} else {
+				continue
+			}
+			if n, err := strconv.Atoi(number); err == nil && n >= 1 && n <= len(apiVersions) {
+				return apiVersions[n-1], nil
+			}
+			return "", fmt.Errorf("API version %q doesn't exist", number)
+		}
+	}
+	return "", nil
+}
+
+func supportedVersions(legacyV1 bool) []string {
+	if legacyV1 {
+		return apiVersions
+	}
+	return apiVersions[1:]
+}
+
+// setDeprecationHeaders tells v1 clients that v1 is deprecated (RFC 9745),
+// when it goes away (RFC 8594), and where the same resource lives in v2.
+func setDeprecationHeaders(w http.ResponseWriter, r *http.Request, sunset time.Time) {
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(apiV1DeprecatedAt.Unix(), 10))
+	if !sunset.IsZero() {
+		w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+	successor := "/api/" + APIVersion2 + strings.TrimPrefix(r.URL.Path, "/api")
+	if r.URL.RawQuery != "" {
+		successor += "?" + r.URL.RawQuery
+	}
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+}
+
+// taskPayload is a task's response body in the request's API version.
+func taskPayload(r *http.Request, response TaskResponse) interface{} {
+	if apiVersion(r) == APIVersion2 {
+		return newTaskResponseV2(response)
+	}
+	return response
+}
+
+// taskListPayload is a task list's response body in the request's API
+// version.
+func taskListPayload(r *http.Request, response TaskListResponse) interface{} {
+	if apiVersion(r) != APIVersion2 {
+		return response
+	}
+	return TaskListResponseV2{
+		Tasks:      newTaskResponsesV2(response.Tasks),
+		Count:      response.Count,
+		TotalCount: response.TotalCount,
+		Page:       response.Page,
+		Limit:      response.Limit,
+		NextCursor: response.NextCursor,
+	}
+}
+
+// taskFieldModel is the struct whose fields ?fields= may select.
+func taskFieldModel(r *http.Request) interface{} {
+	if apiVersion(r) == APIVersion2 {
+		return TaskResponseV2{}
+	}
+	return TaskResponse{}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionFromAccept(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                   "",
+		"*/*":                                "",
+		"application/json":                   "",
+		"application/vnd.task-api.v2+json":   APIVersion2,
+		"application/vnd.task-api.v1+json":   APIVersion1,
+		"application/json; version=2":        APIVersion2,
+		"application/json;version=v1":        APIVersion1,
+		"text/html, application/json; q=0.9": "",
+		"text/html;q=0.5, application/vnd.task-api.v2+json": APIVersion2,
+	} {
+		version, err := versionFromAccept([]string{accept})
+		require.NoError(t, err, accept)
+		assert.Equal(t, want, version, accept)
+	}
+
+	for _, accept := range []string{"application/vnd.task-api.v3+json", "application/json; version=0", "application/json; version=two"} {
+		_, err := versionFromAccept([]string{accept})
+		assert.Error(t, err, accept)
+	}
+}
+
+func TestAPIVersionHandler(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("index " + apiVersion(r)))
+	})
+	router.HandleFunc("/api/tasks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tasks " + apiVersion(r)))
+	})
+	sunset := time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+	handler := apiVersionHandler(router, true, sunset)
+
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/api/tasks", "")
+	assert.Equal(t, "tasks ", w.Body.String())
+	assert.Empty(t, w.Header().Get(APIVersionHeader))
+	assert.Contains(t, w.Header().Values("Vary"), "Accept")
+	assert.Empty(t, w.Header().Get("Deprecation"))
+
+	w = serve("/api/v2/tasks", "")
+	assert.Equal(t, "tasks v2", w.Body.String())
+	assert.Equal(t, APIVersion2, w.Header().Get(APIVersionHeader))
+	assert.Empty(t, w.Header().Get("Deprecation"))
+
+	w = serve("/api/v2", "")
+	assert.Equal(t, "index v2", w.Body.String())
+
+	// The path wins over Accept
+	w = serve("/api/v2/tasks", "application/vnd.task-api.v1+json")
+	assert.Equal(t, "tasks v2", w.Body.String())
+
+	w = serve("/api/tasks", "application/vnd.task-api.v2+json")
+	assert.Equal(t, "tasks v2", w.Body.String())
+
+	for _, w := range []*httptest.ResponseRecorder{
+		serve("/api/v1/tasks?completed=false", ""),
+		serve("/api/tasks?completed=false", "application/json; version=1"),
+	} {
+		assert.Equal(t, "tasks v1", w.Body.String())
+		assert.Equal(t, "@1792108800", w.Header().Get("Deprecation"))
+		assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+		assert.Equal(t, `</api/v2/tasks?completed=false>; rel="successor-version"`, w.Header().Get("Link"))
+	}
+
+	w = serve("/api/tasks", "application/vnd.task-api.v9+json")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, APIVersionUnsupported, errorCode(t, w))
+
+	// Without legacy v1, /api/v1 is just an unknown path
+	w = httptest.NewRecorder()
+	apiVersionHandler(router, false, time.Time{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTaskResponseV2(t *testing.T) {
+	v1 := TaskResponse{ID: "t1", Title: "Ship v2", Completed: true, Priority: "high", Tags: []string{"api"}}
+	body, err := json.Marshal(newTaskResponseV2(v1))
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &fields))
+	assert.Equal(t, "completed", fields["status"])
+	assert.NotContains(t, fields, "completed")
+	assert.Equal(t, "Ship v2", fields["title"])
+
+	v1.Completed = false
+	assert.Equal(t, TaskStatusOpen, newTaskResponseV2(v1).Status)
+}
+
+// TestTaskAPIV2 reads and updates a task in v2 through the router main
+// serves.
+func TestTaskAPIV2(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "v2@example.com")
+	created := env.createTaskAs(user.Token, "Versioned")
+	require.Equal(t, http.StatusCreated, created.Code)
+	var task TaskResponse
+	require.NoError(t, json.Unmarshal(created.Body.Bytes(), &task))
+
+	router, err := newRouter(loadConfig(), env.handler, env.db)
+	require.NoError(t, err)
+	serve := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/api/v2/tasks/"+string(task.ID), "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var v2 map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &v2))
+	assert.Equal(t, TaskStatusOpen, v2["status"])
+	assert.NotContains(t, v2, "completed")
+	etag := w.Header().Get("ETag")
+
+	w = serve("GET", "/api/v2/tasks?fields=id,status", "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"status":"open"`)
+
+	w = serve("PUT", "/api/v2/tasks/"+string(task.ID), etag, `{"completed": true}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, FieldRemoved, errorCode(t, w))
+
+	w = serve("PUT", "/api/v2/tasks/"+string(task.ID), etag, `{"status": "done"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = serve("PUT", "/api/v2/tasks/"+string(task.ID), etag, `{"status": "completed"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"status":"completed"`)
+
+	// v1 sees the same task with its flag
+	w = serve("GET", "/api/v1/tasks/"+string(task.ID), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"completed":true`)
+	assert.NotEmpty(t, w.Header().Get("Deprecation"))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Webhook payloads are versioned JSON Schemas, one file per event and
+// version. A breaking change to an event adds a new version; published
+// versions never change, so integrators can pin to the URL in a delivery.
+//
+//go:embed data/webhook_schemas/*.json
+var webhookSchemaFiles embed.FS
+
+// WebhookSchema is one version of an event's payload schema.
+type WebhookSchema struct {
+	Event    string
+	Version  int
+	Document map[string]interface{}
+	raw      []byte
+}
+
+// WebhookSchemaRegistry holds the schemas of every event the API sends.
+type WebhookSchemaRegistry struct {
+	schemas map[string]map[int]*WebhookSchema
+}
+
+// NewWebhookSchemaRegistry loads the embedded schemas, named
+// {event}.v{version}.json.
+func NewWebhookSchemaRegistry() (*WebhookSchemaRegistry, error) {
+	entries, err := webhookSchemaFiles.ReadDir("data/webhook_schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &WebhookSchemaRegistry{schemas: make(map[string]map[int]*WebhookSchema)}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		dot := strings.LastIndex(name, ".v")
+		if dot < 0 {
+			return nil, fmt.Errorf("webhook schema %s is not named {event}.v{version}.json", entry.Name())
+		}
+		version, err := strconv.Atoi(name[dot+2:])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("webhook schema %s has an invalid version", entry.Name())
+		}
+
+		raw, err := webhookSchemaFiles.ReadFile(path.Join("data/webhook_schemas", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		schema := &WebhookSchema{Event: name[:dot], Version: version, raw: raw}
+		if err := json.Unmarshal(raw, &schema.Document); err != nil {
+			return nil, fmt.Errorf("webhook schema %s: %w", entry.Name(), err)
+		}
+
+		if registry.schemas[schema.Event] == nil {
+			registry.schemas[schema.Event] = make(map[int]*WebhookSchema)
+		}
+		registry.schemas[schema.Event][version] = schema
+	}
+	return registry, nil
+}
+
+// MustWebhookSchemaRegistry is for the embedded schemas, which are checked
+// by the tests.
+func MustWebhookSchemaRegistry() *WebhookSchemaRegistry {
+	registry, err := NewWebhookSchemaRegistry()
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+func (r *WebhookSchemaRegistry) Get(event string, version int) (*WebhookSchema, bool) {
+	schema, ok := r.schemas[event][version]
+	return schema, ok
+}
+
+// Latest returns the newest version of the event's schema, which is the
+// one deliveries are built for.
+func (r *WebhookSchemaRegistry) Latest(event string) (*WebhookSchema, bool) {
+	var latest *WebhookSchema
+	for _, schema := range r.schemas[event] {
+		if latest == nil || schema.Version > latest.Version {
+			latest = schema
+		}
+	}
+	return latest, latest != nil
+}
+
+// Events lists the events with a schema, sorted.
+func (r *WebhookSchemaRegistry) Events() []string {
+	events := make([]string, 0, len(r.schemas))
+	for event := range r.schemas {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// webhookSchemaURL is where a schema version is served.
+func webhookSchemaURL(baseURL, event string, version int) string {
+	return fmt.Sprintf("%s/api/webhooks/schemas/%s/%d", baseURL, event, version)
+}
+
+// Validate checks a payload against the schema and returns the violations,
+// e.g. "data.priority: must be one of [low medium high urgent]".
+func (s *WebhookSchema) Validate(payload []byte) []string {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return []string{"payload is not JSON: " + err.Error()}
+	}
+	var violations []string
+	validateSchema(s.Document, value, "", &violations)
+	return violations
+}
+
+// validateSchema implements the part of JSON Schema the webhook schemas use:
+// type, const, enum, required, properties, additionalProperties: false,
+// items, minLength and the date-time and uri formats.
+func validateSchema(schema map[string]interface{}, value interface{}, at string, violations *[]string) {
+	fail := func(format string, args ...interface{}) {
+		where := at
+		if where == "" {
+			where = "payload"
+		}
+		*violations = append(*violations, where+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types, ok := schema["type"]; ok && !matchesSchemaType(types, value) {
+		fail("must be of type %v", types)
+		return
+	}
+	if expected, ok := schema["const"]; ok && !reflect.DeepEqual(expected, value) {
+		fail("must be %v", expected)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(len(v)) < minLength {
+			fail("must be at least %v characters", minLength)
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				fail("must be an RFC 3339 date-time")
+			}
+		case "uri":
+			if u, err := url.Parse(v); err != nil || !u.IsAbs() {
+				fail("must be an absolute URI")
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := v[name.(string)]; !present {
+					fail("%s is required", name)
+				}
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, defined := properties[name].(map[string]interface{})
+			if !defined {
+				if schema["additionalProperties"] == false {
+					fail("%s is not allowed", name)
+				}
+				continue
+			}
+			validateSchema(property, v[name], joinSchemaPath(at, name), violations)
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateSchema(items, item, fmt.Sprintf("%s[%d]", at, i), violations)
+			}
+		}
+	}
+}
+
+func matchesSchemaType(types, value interface{}) bool {
+	list, ok := types.([]interface{})
+	if !ok {
+		list = []interface{}{types}
+	}
+	for _, t := range list {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || t == "integer" && v == float64(int64(v)) {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinSchemaPath(at, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+// Webhook Schema Handlers
+func (h *Handler) GetWebhookSchemas(w http.ResponseWriter, r *http.Request) {
+	baseURL := requestBaseURL(r)
+	type schemaVersion struct {
+		Version int    `json:"version"`
+		URL     string `json:"url"`
+	}
+	events := make(map[string][]schemaVersion)
+	for _, event := range h.webhookSchemas.Events() {
+		versions := make([]schemaVersion, 0, len(h.webhookSchemas.schemas[event]))
+		for version := range h.webhookSchemas.schemas[event] {
+			versions = append(versions, schemaVersion{version, webhookSchemaURL(baseURL, event, version)})
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		events[event] = versions
+	}
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+}
+
+func (h *Handler) GetWebhookSchema(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	version, err := strconv.Atoi(vars["version"])
+	schema, ok := h.webhookSchemas.Get(vars["event"], version)
+	if err != nil || !ok {
+		h.respondWithError(w, http.StatusNotFound, "Webhook schema not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(schema.raw)
+}
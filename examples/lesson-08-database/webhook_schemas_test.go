@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWebhookPayload(t *testing.T, event string, data interface{}) []byte {
+	body, err := json.Marshal(WebhookPayload{
+		ID:        "evt-1",
+		Type:      event,
+		Version:   1,
+		Schema:    webhookSchemaURL("http://localhost:8088", event, 1),
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return body
+}
+
+func TestWebhookSchemasMatchPayloads(t *testing.T) {
+	registry, err := NewWebhookSchemaRegistry()
+	require.NoError(t, err)
+	assert.Equal(t, []string{WebhookTaskCreated, WebhookTaskDeleted, WebhookTaskUpdated}, registry.Events())
+
+	now := time.Now()
+	task := &Task{
+		ID: "task-1", Title: "Write the schema", Priority: "high", UserID: "user-1", DueDate: &now,
+		Categories: []Category{{ID: "cat-1", Name: "work", Color: "#FF0000", UserID: "user-1"}},
+		CreatedAt:  now, UpdatedAt: now,
+	}
+	for _, event := range []string{WebhookTaskCreated, WebhookTaskUpdated} {
+		schema, ok := registry.Latest(event)
+		require.True(t, ok)
+		assert.Empty(t, schema.Validate(testWebhookPayload(t, event, newTaskResponse(task))))
+		// New tasks have no due date, categories or tags
+		assert.Empty(t, schema.Validate(testWebhookPayload(t, event, newTaskResponse(&Task{
+			ID: "task-2", Title: "Bare", Priority: "low", UserID: "user-1",
+		}))))
+	}
+
+	deleted, _ := registry.Latest(WebhookTaskDeleted)
+	assert.Empty(t, deleted.Validate(testWebhookPayload(t, WebhookTaskDeleted,
+		map[string]interface{}{"id": task.ID, "title": task.Title, "userId": task.UserID})))
+}
+
+func TestWebhookSchemaValidate(t *testing.T) {
+	schema, _ := MustWebhookSchemaRegistry().Get(WebhookTaskCreated, 1)
+
+	response := newTaskResponse(&Task{ID: "task-1", Title: "Broken", Priority: "someday", UserID: "user-1"})
+	violations := schema.Validate(testWebhookPayload(t, WebhookTaskCreated, response))
+	assert.Equal(t, []string{"data.priority: must be one of [low medium high urgent]"}, violations)
+
+	// The event type is part of the schema, and so is the envelope
+	violations = schema.Validate(testWebhookPayload(t, WebhookTaskDeleted, map[string]interface{}{"id": "task-1"}))
+	assert.Contains(t, violations, "type: must be task.created")
+	assert.Contains(t, violations, "data: title is required")
+
+	violations = schema.Validate([]byte(`{"id":"","type":"task.created","version":1,"schema":"/relative",` +
+		`"createdAt":"yesterday","data":{},"extra":true}`))
+	assert.Contains(t, violations, "id: must be at least 1 characters")
+	assert.Contains(t, violations, "schema: must be an absolute URI")
+	assert.Contains(t, violations, "createdAt: must be an RFC 3339 date-time")
+	assert.Contains(t, violations, "payload: extra is not allowed")
+}
+
+func TestGetWebhookSchema(t *testing.T) {
+	h := &Handler{webhookSchemas: MustWebhookSchemaRegistry()}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/webhooks/schemas", h.GetWebhookSchemas)
+	router.HandleFunc("/api/webhooks/schemas/{event}/{version:[0-9]+}", h.GetWebhookSchema)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/webhooks/schemas/task.updated/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+	assert.Equal(t, "task.updated", document["title"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/webhooks/schemas/task.updated/2", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/webhooks/schemas", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"url":"http://example.com/api/webhooks/schemas/task.deleted/1"`)
+}
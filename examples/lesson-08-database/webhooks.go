@@ -0,0 +1,787 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"correlation"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Webhook events
+const (
+	WebhookTaskCreated = "task.created"
+	WebhookTaskUpdated = "task.updated"
+	WebhookTaskDeleted = "task.deleted"
+)
+
+// Webhook request headers. The signature covers the timestamp and the body:
+// t={unix seconds},v1={hex HMAC-SHA256 of "{t}.{body}" with the secret}.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookSchemaHeader    = "X-Webhook-Schema"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+
+	jobTypeDeliverWebhook = "deliver_webhook"
+	webhookSecretPrefix   = "whsec_"
+	webhookTimeout        = 10 * time.Second
+
+	// webhookSnippetBytes is how much of a receiver's response is kept
+	webhookSnippetBytes = 512
+	// DeliveryPendingCode is returned when redelivering a delivery that
+	// hasn't finished yet
+	DeliveryPendingCode = "delivery_pending"
+)
+
+var (
+	webhookDeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Webhook delivery attempts by event and result",
+		},
+		[]string{"event", "result"},
+	)
+	webhookPayloadsInvalidTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_payloads_invalid_total",
+			Help: "Webhook payloads not sent because they failed their schema",
+		},
+		[]string{"event"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(webhookDeliveriesTotal, webhookPayloadsInvalidTotal)
+}
+
+// Webhook is a URL a user registered for some of their events. The secret
+// signs deliveries, so unlike API keys it is stored as is.
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    UserID    `json:"userId"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	IsActive  bool      `json:"isActive"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	Secret string `json:"-"`
+}
+
+// WebhookDelivery is one event sent to one webhook. Payload is kept as sent,
+// so retries carry the same body.
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	WebhookID      string     `json:"webhookId"`
+	Event          string     `json:"event"`
+	SchemaURL      string     `json:"schema"`
+	Status         string     `json:"status"`
+	AttemptCount   int        `json:"attemptCount"`
+	LastStatusCode *int       `json:"lastStatusCode"`
+	LastError      string     `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+
+	// Attempts is only loaded for the deliveries dashboard, newest first
+	Attempts []*WebhookAttempt `json:"attempts,omitempty"`
+	Payload  []byte            `json:"-"`
+}
+
+// WebhookAttempt is one try at sending a delivery, with what the receiver
+// answered, so integrators can debug failures without server logs.
+type WebhookAttempt struct {
+	ID              string    `json:"id"`
+	StatusCode      *int      `json:"statusCode"`
+	Error           string    `json:"error,omitempty"`
+	LatencyMs       int64     `json:"latencyMs"`
+	ResponseSnippet string    `json:"responseSnippet"`
+	AttemptedAt     time.Time `json:"attemptedAt"`
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookPayload is the body of every delivery. Its schema is the document
+// at Schema, for the event in Type at Version.
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Version   int         `json:"version"`
+	Schema    string      `json:"schema"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type CreateWebhookResponse struct {
+	Webhook Webhook `json:"webhook"`
+	// Secret is only returned once
+	Secret string `json:"secret"`
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, webhook *Webhook) error
+	ListByUserID(ctx context.Context, userID UserID) ([]*Webhook, error)
+	Get(ctx context.Context, id string) (*Webhook, error)
+	Delete(ctx context.Context, id string, userID UserID) error
+	// Subscribed returns the user's active webhooks for the event
+	Subscribed(ctx context.Context, userID UserID, event string) ([]*Webhook, error)
+
+	CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetDelivery(ctx context.Context, id string) (*WebhookDelivery, error)
+	// ListDeliveries returns the webhook's latest deliveries with their
+	// attempts, optionally only those with the status
+	ListDeliveries(ctx context.Context, webhookID, status string, limit int) ([]*WebhookDelivery, error)
+	// RecordAttempt stores an attempt and the delivery's resulting status
+	RecordAttempt(ctx context.Context, deliveryID, status string, attempt *WebhookAttempt) error
+	// Redeliver marks a finished delivery pending again
+	Redeliver(ctx context.Context, id string) error
+}
+
+type webhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+func (r *webhookRepository) Create(ctx context.Context, webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (id, user_id, url, secret, events, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	err := r.db.QueryRowContext(ctx, query,
+		webhook.ID, webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.IsActive,
+	).Scan(&webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+const webhookColumns = `id, user_id, url, secret, events, is_active, created_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	webhook := &Webhook{}
+	var events pq.StringArray
+	err := row.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret,
+		&events, &webhook.IsActive, &webhook.CreatedAt)
+	webhook.Events = events
+	return webhook, err
+}
+
+func (r *webhookRepository) listWebhooks(ctx context.Context, query string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *webhookRepository) ListByUserID(ctx context.Context, userID UserID) ([]*Webhook, error) {
+	return r.listWebhooks(ctx, `
+		SELECT `+webhookColumns+` FROM webhooks
+		WHERE user_id = $1 ORDER BY created_at`, userID)
+}
+
+func (r *webhookRepository) Subscribed(ctx context.Context, userID UserID, event string) ([]*Webhook, error) {
+	return r.listWebhooks(ctx, `
+		SELECT `+webhookColumns+` FROM webhooks
+		WHERE user_id = $1 AND is_active = true AND $2 = ANY(events)`, userID, event)
+}
+
+func (r *webhookRepository) Get(ctx context.Context, id string) (*Webhook, error) {
+	webhook, err := scanWebhook(r.db.QueryRowContext(ctx, `
+		SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("webhook not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+func (r *webhookRepository) Delete(ctx context.Context, id string, userID UserID) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+func (r *webhookRepository) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (id, webhook_id, event, schema_url, payload, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	err := r.db.QueryRowContext(ctx, query,
+		delivery.ID, delivery.WebhookID, delivery.Event, delivery.SchemaURL, delivery.Payload, delivery.Status,
+	).Scan(&delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+	return nil
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event, schema_url, payload, status, attempts,
+	last_status_code, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var statusCode sql.NullInt64
+	err := row.Scan(
+		&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.SchemaURL, &delivery.Payload,
+		&delivery.Status, &delivery.AttemptCount, &statusCode, &delivery.LastError,
+		&delivery.CreatedAt, &delivery.DeliveredAt,
+	)
+	if statusCode.Valid {
+		code := int(statusCode.Int64)
+		delivery.LastStatusCode = &code
+	}
+	return delivery, err
+}
+
+func (r *webhookRepository) GetDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	delivery, err := scanWebhookDelivery(r.db.QueryRowContext(ctx, `
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("webhook delivery not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+func (r *webhookRepository) ListDeliveries(ctx context.Context, webhookID, status string, limit int) ([]*WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id
+		LIMIT $3`, webhookID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	byID := make(map[string]*WebhookDelivery)
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		delivery.Attempts = []*WebhookAttempt{}
+		deliveries = append(deliveries, delivery)
+		byID[delivery.ID] = delivery
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	ids := make([]string, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	attemptRows, err := r.db.QueryContext(ctx, `
+		SELECT id, delivery_id, status_code, error, latency_ms, response_snippet, attempted_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = ANY($1)
+		ORDER BY attempted_at DESC, id`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook attempts: %w", err)
+	}
+	defer attemptRows.Close()
+
+	for attemptRows.Next() {
+		attempt := &WebhookAttempt{}
+		var deliveryID string
+		var statusCode sql.NullInt64
+		if err := attemptRows.Scan(&attempt.ID, &deliveryID, &statusCode, &attempt.Error,
+			&attempt.LatencyMs, &attempt.ResponseSnippet, &attempt.AttemptedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			attempt.StatusCode = &code
+		}
+		byID[deliveryID].Attempts = append(byID[deliveryID].Attempts, attempt)
+	}
+	return deliveries, attemptRows.Err()
+}
+
+func (r *webhookRepository) RecordAttempt(ctx context.Context, deliveryID, status string, attempt *WebhookAttempt) error {
+	return WithTransactionContext(ctx, r.db, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO webhook_delivery_attempts (id, delivery_id, status_code, error, latency_ms, response_snippet)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING attempted_at`,
+			attempt.ID, deliveryID, attempt.StatusCode, attempt.Error, attempt.LatencyMs, attempt.ResponseSnippet,
+		).Scan(&attempt.AttemptedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record webhook attempt: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = attempts + 1, last_status_code = $3, last_error = $4,
+			    delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP ELSE delivered_at END
+			WHERE id = $1`, deliveryID, status, attempt.StatusCode, attempt.Error)
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *webhookRepository) Redeliver(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = 'pending'
+		WHERE id = $1 AND status <> 'pending'`, id)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook delivery is already pending")
+	}
+	return nil
+}
+
+// WebhookDispatcher turns events into deliveries and sends them from the
+// job queue, which retries failed attempts with backoff. A nil dispatcher
+// sends nothing.
+type WebhookDispatcher struct {
+	repo    WebhookRepository
+	schemas *WebhookSchemaRegistry
+	queue   *JobQueue
+	client  *http.Client
+	now     func() time.Time
+}
+
+func NewWebhookDispatcher(repo WebhookRepository, schemas *WebhookSchemaRegistry, queue *JobQueue) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		repo:    repo,
+		schemas: schemas,
+		queue:   queue,
+		client:  newHTTPClient(webhookTimeout),
+		now:     time.Now,
+	}
+	queue.Register(jobTypeDeliverWebhook, d.process)
+	return d
+}
+
+// Publish sends the event to every active webhook of the user subscribed to
+// it. The payload is validated against the event's latest schema first; a
+// payload that doesn't match is a bug in the API, so it is logged and not
+// sent rather than breaking integrations. Failures never fail the request
+// that caused the event.
+func (d *WebhookDispatcher) Publish(r *http.Request, userID UserID, event string, data interface{}) {
+	if d == nil {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+
+	webhooks, err := d.repo.Subscribed(ctx, userID, event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to find webhooks", "event", event, "error", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	schema, ok := d.schemas.Latest(event)
+	if !ok {
+		slog.ErrorContext(r.Context(), "no webhook schema for event", "event", event)
+		webhookPayloadsInvalidTotal.WithLabelValues(event).Inc()
+		return
+	}
+	payload := WebhookPayload{
+		ID:        uuid.New().String(),
+		Type:      event,
+		Version:   schema.Version,
+		Schema:    webhookSchemaURL(requestBaseURL(r), event, schema.Version),
+		CreatedAt: d.now().UTC(),
+		Data:      data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode webhook payload", "event", event, "error", err)
+		return
+	}
+	if violations := schema.Validate(body); len(violations) > 0 {
+		slog.ErrorContext(r.Context(), "webhook payload does not match its schema, not sent",
+			"event", event, "schema_version", schema.Version, "violations", violations)
+		webhookPayloadsInvalidTotal.WithLabelValues(event).Inc()
+		return
+	}
+
+	for _, webhook := range webhooks {
+		delivery := &WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: webhook.ID,
+			Event:     event,
+			SchemaURL: payload.Schema,
+			Payload:   body,
+			Status:    DeliveryPending,
+		}
+		if err := d.repo.CreateDelivery(ctx, delivery); err != nil {
+			slog.ErrorContext(r.Context(), "failed to store webhook delivery", "webhook_id", webhook.ID, "error", err)
+			continue
+		}
+		job := Job{Type: jobTypeDeliverWebhook, Key: delivery.ID, Forwarded: correlation.Forwarded(ctx)}
+		if err := d.queue.Enqueue(job); err != nil {
+			slog.ErrorContext(r.Context(), "failed to queue webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+	}
+}
+
+// process sends one delivery. Any 2xx response counts as delivered; other
+// responses and network errors are retried until the last attempt.
+func (d *WebhookDispatcher) process(ctx context.Context, job Job) error {
+	delivery, err := d.repo.GetDelivery(ctx, job.Key)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			// The webhook was deleted along with its deliveries
+			return nil
+		}
+		return err
+	}
+	webhook, err := d.repo.Get(ctx, delivery.WebhookID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil
+		}
+		return err
+	}
+	if !webhook.IsActive {
+		return nil
+	}
+
+	attempt, sendErr := d.send(ctx, webhook, delivery)
+	status := DeliverySucceeded
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+		status = DeliveryPending
+		if job.Final() {
+			status = DeliveryFailed
+		}
+	}
+	webhookDeliveriesTotal.WithLabelValues(delivery.Event, status).Inc()
+	if err := d.repo.RecordAttempt(ctx, delivery.ID, status, attempt); err != nil {
+		slog.ErrorContext(ctx, "failed to record webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+	return sendErr
+}
+
+// send makes one attempt. The attempt is returned also when it failed.
+func (d *WebhookDispatcher) send(ctx context.Context, webhook *Webhook, delivery *WebhookDelivery) (*WebhookAttempt, error) {
+	attempt := &WebhookAttempt{ID: uuid.New().String()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return attempt, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookSchemaHeader, delivery.SchemaURL)
+	req.Header.Set(WebhookSignatureHeader, signWebhook(webhook.Secret, d.now(), delivery.Payload))
+
+	started := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.LatencyMs = time.Since(started).Milliseconds()
+		return attempt, fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookSnippetBytes))
+	attempt.LatencyMs = time.Since(started).Milliseconds()
+	attempt.ResponseSnippet = responseSnippet(snippet)
+
+	statusCode := resp.StatusCode
+	attempt.StatusCode = &statusCode
+	if statusCode < 200 || statusCode > 299 {
+		return attempt, fmt.Errorf("webhook endpoint returned %d", statusCode)
+	}
+	return attempt, nil
+}
+
+// responseSnippet makes the start of a response body storable as text: a
+// rune cut off at the end is dropped, as are bytes Postgres text can't hold.
+func responseSnippet(body []byte) string {
+	snippet := strings.ToValidUTF8(string(body), "")
+	return strings.ReplaceAll(snippet, "\x00", "")
+}
+
+// Redeliver sends a delivery again, with its original body and ID so
+// receivers that already processed it recognize it. The signature is new.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, delivery *WebhookDelivery) error {
+	if err := d.repo.Redeliver(ctx, delivery.ID); err != nil {
+		return err
+	}
+	delivery.Status = DeliveryPending
+	job := Job{Type: jobTypeDeliverWebhook, Key: delivery.ID, Forwarded: correlation.Forwarded(ctx)}
+	if err := d.queue.Enqueue(job); err != nil {
+		// Leave it failed rather than pending forever, so it can be retried
+		attempt := &WebhookAttempt{ID: uuid.New().String(), Error: "not queued: " + err.Error()}
+		if recordErr := d.repo.RecordAttempt(ctx, delivery.ID, DeliveryFailed, attempt); recordErr != nil {
+			slog.ErrorContext(ctx, "failed to record webhook delivery", "delivery_id", delivery.ID, "error", recordErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// signWebhook signs a payload for the X-Webhook-Signature header. The
+// timestamp is signed too, so receivers can reject old deliveries replayed
+// by someone who captured one.
+func signWebhook(secret string, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhookURL accepts absolute http and https URLs.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("url must be an absolute URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url must use http or https")
+	}
+	return nil
+}
+
+// Webhook Handlers
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if err := validateWebhookURL(req.URL); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(req.Events) == 0 {
+		req.Events = h.webhookSchemas.Events()
+	}
+	for _, event := range req.Events {
+		if _, ok := h.webhookSchemas.Latest(event); !ok {
+			h.respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Unsupported event %q, allowed: %s", event, strings.Join(h.webhookSchemas.Events(), ", ")))
+			return
+		}
+	}
+
+	secret, err := generateSecret(32)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate webhook secret")
+		return
+	}
+
+	webhook := &Webhook{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		URL:      req.URL,
+		Events:   req.Events,
+		IsActive: true,
+		Secret:   webhookSecretPrefix + secret,
+	}
+	if err := h.webhookRepo.Create(r.Context(), webhook); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, CreateWebhookResponse{Webhook: *webhook, Secret: webhook.Secret})
+}
+
+func (h *Handler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	webhooks, err := h.webhookRepo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get webhooks")
+		return
+	}
+
+	list := make([]Webhook, len(webhooks))
+	for i, webhook := range webhooks {
+		list[i] = *webhook
+	}
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": list,
+		"count":    len(list),
+	})
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	if err := h.webhookRepo.Delete(r.Context(), mux.Vars(r)["id"], userID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.respondWithError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userWebhook loads the webhook in the route for its owner, responding 404
+// for other users' webhooks.
+func (h *Handler) userWebhook(w http.ResponseWriter, r *http.Request) (*Webhook, bool) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	webhook, err := h.webhookRepo.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get webhook")
+		return nil, false
+	}
+	if err != nil || webhook.UserID != userID {
+		h.respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return nil, false
+	}
+	return webhook, true
+}
+
+// webhookDeliveryView shows a delivery's payload as the JSON it was sent as.
+type webhookDeliveryView struct {
+	*WebhookDelivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.userWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	switch status {
+	case "", DeliveryPending, DeliverySucceeded, DeliveryFailed:
+	default:
+		h.respondWithError(w, http.StatusBadRequest, "status must be pending, succeeded or failed")
+		return
+	}
+	limit := 20
+	if value := query.Get("limit"); value != "" {
+		if l, err := strconv.Atoi(value); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	deliveries, err := h.webhookRepo.ListDeliveries(r.Context(), webhook.ID, status, limit)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get webhook deliveries")
+		return
+	}
+
+	views := make([]webhookDeliveryView, len(deliveries))
+	for i, delivery := range deliveries {
+		views[i] = webhookDeliveryView{WebhookDelivery: delivery, Payload: delivery.Payload}
+	}
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"deliveries": views,
+		"count":      len(views),
+	})
+}
+
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.userWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhookRepo.GetDelivery(r.Context(), mux.Vars(r)["deliveryId"])
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get webhook delivery")
+		return
+	}
+	if err != nil || delivery.WebhookID != webhook.ID {
+		h.respondWithError(w, http.StatusNotFound, "Webhook delivery not found")
+		return
+	}
+	if h.webhooks == nil {
+		h.respondWithError(w, http.StatusServiceUnavailable, "Webhook deliveries are not running")
+		return
+	}
+
+	if err := h.webhooks.Redeliver(r.Context(), delivery); err != nil {
+		if strings.Contains(err.Error(), "already pending") {
+			h.respondWithErrorCode(w, http.StatusConflict, DeliveryPendingCode,
+				"The delivery is still being sent; redeliver it once it has succeeded or failed")
+			return
+		}
+		if errors.Is(err, ErrQueueFull) {
+			h.respondWithError(w, http.StatusServiceUnavailable, "Too many pending jobs, try again later")
+			return
+		}
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to redeliver webhook")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusAccepted, webhookDeliveryView{WebhookDelivery: delivery, Payload: delivery.Payload})
+}
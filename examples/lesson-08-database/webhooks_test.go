@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"correlation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver records the deliveries it gets and answers with status.
+func newWebhookReceiver(t *testing.T, status int) (*httptest.Server, chan receivedWebhook) {
+	received := make(chan receivedWebhook, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+// startWebhooks sends the handler's webhooks from a queue of its own, with
+// two attempts per delivery.
+func (env *testEnv) startWebhooks(t *testing.T) *WebhookDispatcher {
+	queue := NewJobQueue(10, 2, time.Millisecond)
+	dispatcher := NewWebhookDispatcher(env.handler.webhookRepo, env.handler.webhookSchemas, queue)
+	env.handler.webhooks = dispatcher
+	queue.Start(1)
+	t.Cleanup(queue.Stop)
+	return dispatcher
+}
+
+func (env *testEnv) createTestWebhook(t *testing.T, token string, req CreateWebhookRequest) CreateWebhookResponse {
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/api/webhooks", bytes.NewReader(body))
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	w := env.serveWithAuth(env.handler.CreateWebhook, httpReq)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var response CreateWebhookResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func waitForWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	select {
+	case webhook := <-received:
+		return webhook
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivered")
+		return receivedWebhook{}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	sentAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	env.startWebhooks(t).now = func() time.Time { return sentAt }
+	user := env.registerTestUser(t, "hooks@example.com")
+	receiver, received := newWebhookReceiver(t, http.StatusNoContent)
+
+	created := env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: receiver.URL, Events: []string{WebhookTaskCreated}})
+	assert.True(t, strings.HasPrefix(created.Secret, webhookSecretPrefix))
+
+	w := env.createTaskAs(user.Token, "Hooked")
+	require.Equal(t, http.StatusCreated, w.Code)
+	delivery := waitForWebhook(t, received)
+
+	assert.Equal(t, WebhookTaskCreated, delivery.header.Get(WebhookEventHeader))
+	assert.NotEmpty(t, delivery.header.Get(WebhookIDHeader))
+	var payload WebhookPayload
+	require.NoError(t, json.Unmarshal(delivery.body, &payload))
+	assert.Equal(t, WebhookTaskCreated, payload.Type)
+	assert.Equal(t, 1, payload.Version)
+	assert.Equal(t, "http://example.com/api/webhooks/schemas/task.created/1", payload.Schema)
+	assert.Equal(t, payload.Schema, delivery.header.Get(WebhookSchemaHeader))
+	assert.Equal(t, "Hooked", payload.Data.(map[string]interface{})["title"])
+
+	assert.Empty(t, env.handler.webhookSchemas.schemas[WebhookTaskCreated][1].Validate(delivery.body))
+	assert.Equal(t, signWebhook(created.Secret, sentAt, delivery.body), delivery.header.Get(WebhookSignatureHeader))
+
+	// Only subscribed events are sent
+	taskID := payload.Data.(map[string]interface{})["id"].(string)
+	req := taskRequest(http.MethodDelete, "/api/tasks/"+taskID, user.Token, "", map[string]string{"id": taskID})
+	require.Equal(t, http.StatusNoContent, env.serveWithAuth(env.handler.DeleteTask, req).Code)
+	select {
+	case unexpected := <-received:
+		t.Fatalf("unsubscribed event delivered: %s", unexpected.header.Get(WebhookEventHeader))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stored, err := env.handler.webhookRepo.GetDelivery(req.Context(), delivery.header.Get(WebhookIDHeader))
+	require.NoError(t, err)
+	assert.Equal(t, DeliverySucceeded, stored.Status)
+	assert.Equal(t, 1, stored.AttemptCount)
+	require.NotNil(t, stored.LastStatusCode)
+	assert.Equal(t, http.StatusNoContent, *stored.LastStatusCode)
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.startWebhooks(t)
+	user := env.registerTestUser(t, "failing-hooks@example.com")
+	receiver, received := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: receiver.URL})
+
+	require.Equal(t, http.StatusCreated, env.createTaskAs(user.Token, "Unlucky").Code)
+	first := waitForWebhook(t, received)
+	second := waitForWebhook(t, received)
+
+	// Retries are the same delivery with the same body
+	id := first.header.Get(WebhookIDHeader)
+	assert.Equal(t, id, second.header.Get(WebhookIDHeader))
+	assert.Equal(t, first.body, second.body)
+
+	require.Eventually(t, func() bool {
+		stored, err := env.handler.webhookRepo.GetDelivery(context.Background(), id)
+		return err == nil && stored.Status == DeliveryFailed && stored.AttemptCount == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// Deliveries pass on the trace context and request ID of the request that
+// caused them, though they are sent from the job queue after it ended.
+func TestWebhookDeliveryForwardsHeaders(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.startWebhooks(t)
+	user := env.registerTestUser(t, "forwarded@example.com")
+	receiver, received := newWebhookReceiver(t, http.StatusNoContent)
+	env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: receiver.URL, Events: []string{WebhookTaskCreated}})
+
+	var w *httptest.ResponseRecorder
+	handler := correlation.Middleware(correlation.Passthrough(correlation.DefaultForwardHeaders)(
+		http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			body, _ := json.Marshal(CreateTaskRequest{Title: "Traced"})
+			req := httptest.NewRequest(http.MethodPost, "/api/tasks", bytes.NewReader(body)).WithContext(r.Context())
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+user.Token)
+			w = env.serveWithAuth(env.handler.CreateTask, req)
+		})))
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", nil)
+	req.Header.Set(correlation.RequestIDHeader, "req-forwarded")
+	req.Header.Set(correlation.BaggageHeader, "tenant=acme")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	delivery := waitForWebhook(t, received)
+	assert.Equal(t, "req-forwarded", delivery.header.Get(correlation.RequestIDHeader))
+	assert.Equal(t, "tenant=acme", delivery.header.Get(correlation.BaggageHeader))
+	assert.NotEmpty(t, delivery.header.Get(correlation.TraceparentHeader))
+	assert.Empty(t, delivery.header.Get("Authorization"))
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "bad-hooks@example.com")
+
+	for name, req := range map[string]CreateWebhookRequest{
+		"relative url":  {URL: "/hooks"},
+		"other scheme":  {URL: "ftp://example.com/hooks"},
+		"unknown event": {URL: "https://example.com/hooks", Events: []string{"task.exploded"}},
+	} {
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest(http.MethodPost, "/api/webhooks", bytes.NewReader(body))
+		httpReq.Header.Set("Authorization", "Bearer "+user.Token)
+		assert.Equal(t, http.StatusBadRequest, env.serveWithAuth(env.handler.CreateWebhook, httpReq).Code, name)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	signature := signWebhook("whsec_test", at, []byte(`{"id":"1"}`))
+	assert.Equal(t, "t=1700000000,v1=", signature[:16])
+	assert.Len(t, signature, 16+64)
+
+	// Body and timestamp are both signed
+	assert.NotEqual(t, signature, signWebhook("whsec_test", at, []byte(`{"id":"2"}`)))
+	assert.NotEqual(t, signature, signWebhook("whsec_test", at.Add(time.Second), []byte(`{"id":"1"}`)))
+}
+
+func (env *testEnv) webhookRequest(handler http.HandlerFunc, method, path, token string, vars map[string]string) *httptest.ResponseRecorder {
+	req := taskRequest(method, path, token, "", vars)
+	return env.serveWithAuth(handler, req)
+}
+
+func TestWebhookDeliveriesDashboard(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	env.startWebhooks(t)
+	user := env.registerTestUser(t, "dashboard-hooks@example.com")
+	other := env.registerTestUser(t, "other-dashboard-hooks@example.com")
+
+	// The receiver fails until it is fixed
+	var fixed atomic.Bool
+	received := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(WebhookIDHeader)
+		if !fixed.Load() {
+			http.Error(w, `{"error":"database is down"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(receiver.Close)
+	webhook := env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: receiver.URL, Events: []string{WebhookTaskCreated}}).Webhook
+	path := "/api/webhooks/" + webhook.ID + "/deliveries"
+	vars := map[string]string{"id": webhook.ID}
+
+	require.Equal(t, http.StatusCreated, env.createTaskAs(user.Token, "Undelivered").Code)
+	id := <-received
+	<-received
+	require.Eventually(t, func() bool {
+		stored, err := env.handler.webhookRepo.GetDelivery(context.Background(), id)
+		return err == nil && stored.Status == DeliveryFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w := env.webhookRequest(env.handler.GetWebhookDeliveries, http.MethodGet, path+"?status=failed", user.Token, vars)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list struct {
+		Deliveries []webhookDeliveryView `json:"deliveries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Deliveries, 1)
+	delivery := list.Deliveries[0]
+	assert.Equal(t, id, delivery.ID)
+	assert.Equal(t, 2, delivery.AttemptCount)
+	require.Len(t, delivery.Attempts, 2)
+	for _, attempt := range delivery.Attempts {
+		require.NotNil(t, attempt.StatusCode)
+		assert.Equal(t, http.StatusInternalServerError, *attempt.StatusCode)
+		assert.Contains(t, attempt.ResponseSnippet, "database is down")
+		assert.Equal(t, "webhook endpoint returned 500", attempt.Error)
+		assert.GreaterOrEqual(t, attempt.LatencyMs, int64(0))
+	}
+	assert.Contains(t, string(delivery.Payload), `"title":"Undelivered"`)
+
+	// Other users can't see or redeliver it
+	redeliverPath := path + "/" + id + "/redeliver"
+	redeliverVars := map[string]string{"id": webhook.ID, "deliveryId": id}
+	assert.Equal(t, http.StatusNotFound, env.webhookRequest(env.handler.GetWebhookDeliveries, http.MethodGet, path, other.Token, vars).Code)
+	assert.Equal(t, http.StatusNotFound, env.webhookRequest(env.handler.RedeliverWebhook, http.MethodPost, redeliverPath, other.Token, redeliverVars).Code)
+
+	// Once the receiver is fixed, a redelivery sends the same delivery again
+	fixed.Store(true)
+	w = env.webhookRequest(env.handler.RedeliverWebhook, http.MethodPost, redeliverPath, user.Token, redeliverVars)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, id, <-received)
+	require.Eventually(t, func() bool {
+		stored, err := env.handler.webhookRepo.GetDelivery(context.Background(), id)
+		return err == nil && stored.Status == DeliverySucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w = env.webhookRequest(env.handler.GetWebhookDeliveries, http.MethodGet, path, user.Token, vars)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Deliveries, 1)
+	require.Len(t, list.Deliveries[0].Attempts, 3)
+	latest := list.Deliveries[0].Attempts[0]
+	assert.Equal(t, http.StatusOK, *latest.StatusCode)
+	assert.Equal(t, "ok", latest.ResponseSnippet)
+	assert.Empty(t, latest.Error)
+}
+
+func TestRedeliverPendingWebhook(t *testing.T) {
+	t.Parallel()
+	env := newTestEnv(t)
+	user := env.registerTestUser(t, "pending-hooks@example.com")
+	webhook := env.createTestWebhook(t, user.Token, CreateWebhookRequest{URL: "https://hooks.example.com/tasks"}).Webhook
+
+	// Without workers the delivery stays pending
+	queue := NewJobQueue(10, 1, time.Millisecond)
+	env.handler.webhooks = NewWebhookDispatcher(env.handler.webhookRepo, env.handler.webhookSchemas, queue)
+	require.Equal(t, http.StatusCreated, env.createTaskAs(user.Token, "Queued").Code)
+	deliveries, err := env.handler.webhookRepo.ListDeliveries(context.Background(), webhook.ID, DeliveryPending, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	id := deliveries[0].ID
+	w := env.webhookRequest(env.handler.RedeliverWebhook, http.MethodPost,
+		"/api/webhooks/"+webhook.ID+"/deliveries/"+id+"/redeliver", user.Token,
+		map[string]string{"id": webhook.ID, "deliveryId": id})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, DeliveryPendingCode, errorCode(t, w))
+}
+
+func TestResponseSnippet(t *testing.T) {
+	assert.Equal(t, "caf", responseSnippet([]byte("caf\xc3")))
+	assert.Equal(t, "ab", responseSnippet([]byte("a\x00b")))
+}
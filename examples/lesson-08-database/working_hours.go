@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"respond"
+)
+
+// Working hours are when a user gets work done: some days of the week,
+// between a start and an end time in their time zone. Due date suggestions
+// count only this time, so "four hours of work" started on Friday afternoon
+// is due on Monday rather than on Friday night. Users who haven't set them
+// work Monday to Friday, 09:00 to 17:00 UTC.
+const (
+	defaultWorkStart = "09:00"
+	defaultWorkEnd   = "17:00"
+)
+
+var weekdayNames = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// WorkingHours is a user's working week. Days are lowercase English day
+// names; Start and End are "15:04" times in TimeZone, an IANA name. The
+// public holidays of Locale, such as "DE-BY", are days off too; empty
+// means none.
+type WorkingHours struct {
+	TimeZone  string     `json:"timeZone"`
+	Days      []string   `json:"days"`
+	Start     string     `json:"start"`
+	End       string     `json:"end"`
+	Locale    string     `json:"locale"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+func defaultWorkingHours() *WorkingHours {
+	return &WorkingHours{
+		TimeZone: "UTC",
+		Days:     []string{"monday", "tuesday", "wednesday", "thursday", "friday"},
+		Start:    defaultWorkStart,
+		End:      defaultWorkEnd,
+	}
+}
+
+// WorkingHoursRequest replaces the working hours. Fields left out keep
+// their defaults, not their previous values.
+type WorkingHoursRequest struct {
+	TimeZone string   `json:"timeZone"`
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Locale   string   `json:"locale"`
+}
+
+// workingHours validates the request and returns the working hours it sets,
+// with days in week order.
+func (req WorkingHoursRequest) workingHours() (*WorkingHours, error) {
+	hours := defaultWorkingHours()
+	if req.TimeZone != "" {
+		if _, err := time.LoadLocation(req.TimeZone); err != nil || req.TimeZone == "Local" {
+			return nil, fmt.Errorf("unknown time zone %q", req.TimeZone)
+		}
+		hours.TimeZone = req.TimeZone
+	}
+	if req.Days != nil {
+		days := make(map[string]bool)
+		for _, day := range req.Days {
+			name := strings.ToLower(strings.TrimSpace(day))
+			if !slices.Contains(weekdayNames, name) {
+				return nil, fmt.Errorf("unknown day %q", day)
+			}
+			days[name] = true
+		}
+		if len(days) == 0 {
+			return nil, fmt.Errorf("days needs at least one day")
+		}
+		hours.Days = []string{}
+		for _, name := range weekdayNames {
+			if days[name] {
+				hours.Days = append(hours.Days, name)
+			}
+		}
+	}
+	if req.Start != "" {
+		hours.Start = req.Start
+	}
+	if req.End != "" {
+		hours.End = req.End
+	}
+	start, err := parseClock(hours.Start)
+	if err != nil {
+		return nil, fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClock(hours.End)
+	if err != nil {
+		return nil, fmt.Errorf("end: %w", err)
+	}
+	if end <= start {
+		return nil, fmt.Errorf("end must be after start")
+	}
+	if req.Locale != "" {
+		if hours.Locale, err = parseLocale(req.Locale); err != nil {
+			return nil, err
+		}
+	}
+	return hours, nil
+}
+
+// parseClock parses a "15:04" time of day as the time since midnight.
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 09:30", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// CalendarProvider tells which days nobody works, on top of the days each
+// user's working hours leave out.
+type CalendarProvider interface {
+	IsHoliday(ctx context.Context, day time.Time) (bool, error)
+}
+
+// fixedCalendar is a list of holiday dates, such as the HOLIDAYS setting.
+// The zero value has none.
+type fixedCalendar struct {
+	dates map[string]bool
+}
+
+// NewFixedCalendar builds a calendar from dates such as 2026-12-25.
+func NewFixedCalendar(dates []string) (CalendarProvider, error) {
+	calendar := fixedCalendar{dates: make(map[string]bool, len(dates))}
+	for _, date := range dates {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return nil, fmt.Errorf("invalid holiday %q: want a date like 2026-12-25", date)
+		}
+		calendar.dates[date] = true
+	}
+	return calendar, nil
+}
+
+// IsHoliday reports whether the date of day, in its own location, is listed.
+func (c fixedCalendar) IsHoliday(ctx context.Context, day time.Time) (bool, error) {
+	return c.dates[day.Format(time.DateOnly)], nil
+}
+
+type WorkingHoursRepository interface {
+	// Get returns the user's working hours, or the defaults when they have
+	// none
+	Get(ctx context.Context, userID UserID) (*WorkingHours, error)
+	Put(ctx context.Context, userID UserID, hours *WorkingHours) error
+}
+
+type workingHoursRepository struct {
+	db *sql.DB
+}
+
+func NewWorkingHoursRepository(db *sql.DB) WorkingHoursRepository {
+	return &workingHoursRepository{db: db}
+}
+
+func (r *workingHoursRepository) Get(ctx context.Context, userID UserID) (*WorkingHours, error) {
+	hours := &WorkingHours{}
+	var updatedAt time.Time
+	err := r.db.QueryRowContext(ctx, `
+		SELECT time_zone, days, start_time, end_time, locale, updated_at
+		FROM working_hours WHERE user_id = $1`, userID,
+	).Scan(&hours.TimeZone, pq.Array(&hours.Days), &hours.Start, &hours.End, &hours.Locale, &updatedAt)
+	if err == sql.ErrNoRows {
+		return defaultWorkingHours(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working hours: %w", err)
+	}
+	hours.UpdatedAt = &updatedAt
+	return hours, nil
+}
+
+func (r *workingHoursRepository) Put(ctx context.Context, userID UserID, hours *WorkingHours) error {
+	var updatedAt time.Time
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO working_hours (user_id, time_zone, days, start_time, end_time, locale)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET time_zone = EXCLUDED.time_zone, days = EXCLUDED.days,
+			start_time = EXCLUDED.start_time, end_time = EXCLUDED.end_time,
+			locale = EXCLUDED.locale, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`,
+		userID, hours.TimeZone, pq.Array(hours.Days), hours.Start, hours.End, hours.Locale,
+	).Scan(&updatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save working hours: %w", err)
+	}
+	hours.UpdatedAt = &updatedAt
+	return nil
+}
+
+// GetWorkingHours handles GET /api/users/me/working-hours
+func (h *Handler) GetWorkingHours(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	hours, err := h.workingHoursRepo.Get(r.Context(), userID)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to get working hours")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, hours)
+}
+
+// PutWorkingHours handles PUT /api/users/me/working-hours
+func (h *Handler) PutWorkingHours(w http.ResponseWriter, r *http.Request) {
+	userID := UserID(r.Context().Value("user_id").(string))
+
+	var req WorkingHoursRequest
+	if err := respond.Decode(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	hours, err := req.workingHours()
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Catch typos now rather than with suggestions that skip no holidays.
+	// When the provider can't be reached the locale is taken on trust
+	if hours.Locale != "" && h.holidays != nil {
+		_, err := h.holidays.Holidays(r.Context(), hours.Locale, time.Now().Year())
+		if errors.Is(err, ErrUnknownLocale) {
+			h.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("no holidays are known for locale %q", hours.Locale))
+			return
+		}
+	}
+	if err := h.workingHoursRepo.Put(r.Context(), userID, hours); err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to save working hours")
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, hours)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkingHoursRequest(t *testing.T) {
+	hours, err := WorkingHoursRequest{}.workingHours()
+	require.NoError(t, err)
+	assert.Equal(t, defaultWorkingHours(), hours)
+
+	hours, err = WorkingHoursRequest{
+		TimeZone: "Europe/Berlin",
+		Days:     []string{"Saturday", " sunday", "saturday"},
+		Start:    "10:30",
+		End:      "14:00",
+	}.workingHours()
+	require.NoError(t, err)
+	assert.Equal(t, &WorkingHours{TimeZone: "Europe/Berlin", Days: []string{"sunday", "saturday"}, Start: "10:30", End: "14:00"}, hours)
+
+	hours, err = WorkingHoursRequest{Locale: "de-by"}.workingHours()
+	require.NoError(t, err)
+	assert.Equal(t, "DE-BY", hours.Locale)
+
+	for name, req := range map[string]WorkingHoursRequest{
+		"unknown time zone": {TimeZone: "Mars/Olympus"},
+		"local time zone":   {TimeZone: "Local"},
+		"unknown day":       {Days: []string{"monday", "funday"}},
+		"no days":           {Days: []string{}},
+		"bad start":         {Start: "9am"},
+		"end before start":  {Start: "17:00", End: "09:00"},
+		"empty day":         {Start: "12:00", End: "12:00"},
+		"unknown locale":    {Locale: "Bavaria"},
+	} {
+		_, err := req.workingHours()
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFixedCalendar(t *testing.T) {
+	calendar, err := NewFixedCalendar([]string{"2026-12-25"})
+	require.NoError(t, err)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// Dates are read where the day is, not in UTC
+	holiday, err := calendar.IsHoliday(context.Background(), time.Date(2026, 12, 25, 0, 0, 0, 0, berlin))
+	require.NoError(t, err)
+	assert.True(t, holiday)
+	holiday, err = calendar.IsHoliday(context.Background(), time.Date(2026, 12, 24, 0, 0, 0, 0, berlin))
+	require.NoError(t, err)
+	assert.False(t, holiday)
+
+	_, err = NewFixedCalendar([]string{"25.12.2026"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// entry is a stored response. Entries are never modified once stored;
+// revalidation stores a new entry in place of the old one.
+type entry struct {
+	key string
+	// varyValues are the request header values selected by the response's
+	// Vary header; a request must match them all to reuse the entry
+	varyValues map[string]string
+
+	status int
+	header http.Header
+	body   []byte
+
+	storedAt time.Time
+	// initialAge is the Age the upstream (or a cache in front of it) reported
+	initialAge           time.Duration
+	freshFor             time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	mustRevalidate       bool
+
+	// surrogateKeys tag the entry for purging, e.g. "task:42"
+	surrogateKeys []string
+
+	element *list.Element
+}
+
+func (e *entry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.storedAt)
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return e.age(now) < e.freshFor
+}
+
+// servableWhileRevalidating reports whether a stale entry may be served
+// while it is revalidated in the background (stale-while-revalidate).
+func (e *entry) servableWhileRevalidating(now time.Time) bool {
+	return !e.mustRevalidate && e.age(now) < e.freshFor+e.staleWhileRevalidate
+}
+
+// servableOnError reports whether a stale entry may be served because the
+// upstream failed (stale-if-error).
+func (e *entry) servableOnError(now time.Time) bool {
+	return !e.mustRevalidate && e.age(now) < e.freshFor+e.staleIfError
+}
+
+func (e *entry) matches(req *http.Request) bool {
+	for name, value := range e.varyValues {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *entry) sameVariant(other *entry) bool {
+	if len(e.varyValues) != len(other.varyValues) {
+		return false
+	}
+	for name, value := range e.varyValues {
+		if other.varyValues[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Cache is an in-memory response store with least-recently-used eviction.
+// Entries are found by key (the request URI) and Vary, and can be purged by
+// key or by surrogate key.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	variants   map[string][]*entry
+	tagged     map[string]map[*entry]struct{}
+	lru        *list.List
+}
+
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		variants:   make(map[string][]*entry),
+		tagged:     make(map[string]map[*entry]struct{}),
+		lru:        list.New(),
+	}
+}
+
+// Get returns the stored variant matching the request, or nil.
+func (c *Cache) Get(key string, req *http.Request) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.variants[key] {
+		if e.matches(req) {
+			c.lru.MoveToFront(e.element)
+			return e
+		}
+	}
+	return nil
+}
+
+// Put stores an entry, replacing the variant it matches.
+func (c *Cache) Put(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, existing := range c.variants[e.key] {
+		if existing.sameVariant(e) {
+			c.removeLocked(existing)
+			break
+		}
+	}
+
+	e.element = c.lru.PushFront(e)
+	c.variants[e.key] = append(c.variants[e.key], e)
+	for _, tag := range e.surrogateKeys {
+		if c.tagged[tag] == nil {
+			c.tagged[tag] = make(map[*entry]struct{})
+		}
+		c.tagged[tag][e] = struct{}{}
+	}
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back().Value.(*entry))
+	}
+}
+
+// Remove drops an entry unless it has already been replaced.
+func (c *Cache) Remove(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, existing := range c.variants[e.key] {
+		if existing == e {
+			c.removeLocked(e)
+			return
+		}
+	}
+}
+
+// PurgeKey drops every variant stored for a request URI.
+func (c *Cache) PurgeKey(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	variants := append([]*entry(nil), c.variants[key]...)
+	for _, e := range variants {
+		c.removeLocked(e)
+	}
+	return len(variants)
+}
+
+// PurgeSurrogateKeys drops every entry tagged with any of the keys.
+func (c *Cache) PurgeSurrogateKeys(keys ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for _, tag := range keys {
+		for e := range c.tagged[tag] {
+			c.removeLocked(e)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) removeLocked(e *entry) {
+	c.lru.Remove(e.element)
+
+	variants := c.variants[e.key]
+	for i, existing := range variants {
+		if existing == e {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.variants, e.key)
+	} else {
+		c.variants[e.key] = variants
+	}
+
+	for _, tag := range e.surrogateKeys {
+		delete(c.tagged[tag], e)
+		if len(c.tagged[tag]) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+}
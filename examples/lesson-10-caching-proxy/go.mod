@@ -0,0 +1,27 @@
+module lesson-10-caching-proxy
+
+go 1.21
+
+require (
+	cachecontrol v0.0.0
+	correlation v0.0.0
+	github.com/gorilla/mux v1.8.1
+	github.com/stretchr/testify v1.8.4
+	httpcond v0.0.0
+	logging v0.0.0
+	respond v0.0.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	cachecontrol => ../pkg/cachecontrol
+	correlation => ../pkg/correlation
+	httpcond => ../pkg/httpcond
+	logging => ../pkg/logging
+	respond => ../pkg/respond
+)
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"correlation"
+	"logging"
+)
+
+// newLogger writes JSON lines at level and above tagged with the service
+// name, so the proxy's and the origin's logs can be merged and searched by
+// request_id.
+func newLogger(w io.Writer, service string, level slog.Leveler) *slog.Logger {
+	return logging.New(w, level).With("service", service)
+}
+
+// accessLog continues the caller's request ID and trace and logs one line
+// per request with them, and with whether the cache answered it.
+func accessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return correlation.Middleware(logging.Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if cache := w.Header().Get("X-Cache"); cache != "" {
+			logging.AddFields(r.Context(), slog.String("cache", cache))
+		}
+	})))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"correlation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logBuffer collects the JSON lines of one service.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) logger(service string) *slog.Logger {
+	return newLogger(b, service, slog.LevelInfo)
+}
+
+// requests returns the access log lines for method and path.
+func (b *logBuffer) requests(t *testing.T, method, path string) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(b.buf.String()))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		if line["msg"] == "request" && line["method"] == method && line["path"] == path {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// TestCorrelatedLogs runs the demo origin behind the proxy and follows one
+// request through the logs of both.
+func TestCorrelatedLogs(t *testing.T) {
+	var proxyLogs, originLogs logBuffer
+	purger := &HTTPPurger{}
+	origin := NewOrigin(purger)
+	origin.logger = originLogs.logger("origin")
+	tp := newTestProxy(t, origin.Routes())
+	tp.proxy.logger = proxyLogs.logger("proxy")
+
+	proxyServer := httptest.NewServer(tp.proxy)
+	t.Cleanup(proxyServer.Close)
+	purger.URL = proxyServer.URL
+
+	resp, err := http.Get(proxyServer.URL + "/tasks/1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	requestID := resp.Header.Get(correlation.RequestIDHeader)
+	require.NotEmpty(t, requestID)
+	assert.Len(t, resp.Header.Values(correlation.RequestIDHeader), 1)
+
+	atProxy := proxyLogs.requests(t, "GET", "/tasks/1")
+	atOrigin := originLogs.requests(t, "GET", "/tasks/1")
+	require.Len(t, atProxy, 1)
+	require.Len(t, atOrigin, 1)
+	assert.Equal(t, requestID, atProxy[0]["request_id"])
+	assert.Equal(t, requestID, atOrigin[0]["request_id"])
+	assert.Equal(t, atProxy[0]["trace_id"], atOrigin[0]["trace_id"])
+	assert.Equal(t, atProxy[0]["span_id"], atOrigin[0]["parent_span_id"])
+	assert.Equal(t, "MISS", atProxy[0]["cache"])
+	assert.Equal(t, float64(http.StatusOK), atOrigin[0]["status"])
+	assert.Contains(t, atOrigin[0], "latency_ms")
+
+	// A hit gets its own request ID, not the one stored with the response
+	resp, err = http.Get(proxyServer.URL + "/tasks/1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.NotEqual(t, requestID, resp.Header.Get(correlation.RequestIDHeader))
+
+	// A caller's request ID is kept, through the write and the origin's
+	// purge back to the proxy
+	req, err := http.NewRequest("PUT", proxyServer.URL+"/tasks/1", strings.NewReader(`{"done":true}`))
+	require.NoError(t, err)
+	req.Header.Set(correlation.RequestIDHeader, "client-req-42")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "client-req-42", resp.Header.Get(correlation.RequestIDHeader))
+
+	require.Len(t, originLogs.requests(t, "PUT", "/tasks/1"), 1)
+	assert.Equal(t, "client-req-42", originLogs.requests(t, "PUT", "/tasks/1")[0]["request_id"])
+	purges := proxyLogs.requests(t, "PURGE", "/")
+	require.Len(t, purges, 1)
+	assert.Equal(t, "client-req-42", purges[0]["request_id"])
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"logging"
+)
+
+type Config struct {
+	Port       string
+	OriginPort string
+	// UpstreamURL is the API behind the proxy. When empty, the demo origin
+	// is started on OriginPort.
+	UpstreamURL string
+	MaxEntries  int
+	// PurgeAllow lists the networks allowed to send PURGE requests
+	PurgeAllow string
+	// LogLevel is the least severe level logged: debug, info, warn or error
+	LogLevel slog.Level
+}
+
+func loadConfig() Config {
+	return Config{
+		Port:        getEnv("PORT", "8090"),
+		OriginPort:  getEnv("ORIGIN_PORT", "8091"),
+		UpstreamURL: getEnv("UPSTREAM_URL", ""),
+		MaxEntries:  getIntEnv("CACHE_MAX_ENTRIES", 1000),
+		PurgeAllow:  getEnv("PURGE_ALLOW", "127.0.0.0/8,::1/128"),
+		LogLevel:    getLogLevelEnv("LOG_LEVEL", slog.LevelInfo),
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+		slog.Warn("invalid integer, using the default", "key", key, "value", value, "default", defaultValue)
+	}
+	return defaultValue
+}
+
+func getLogLevelEnv(key string, defaultValue slog.Level) slog.Level {
+	if value := os.Getenv(key); value != "" {
+		if level, err := logging.ParseLevel(value); err == nil {
+			return level
+		}
+		slog.Warn("invalid log level, using the default", "key", key, "value", value, "default", defaultValue)
+	}
+	return defaultValue
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func main() {
+	var logLevel slog.LevelVar
+	slog.SetDefault(logging.New(os.Stdout, &logLevel))
+	config := loadConfig()
+	logLevel.Set(config.LogLevel)
+
+	upstreamURL := config.UpstreamURL
+	if upstreamURL == "" {
+		upstreamURL = "http://localhost:" + config.OriginPort
+		origin := NewOrigin(&HTTPPurger{URL: "http://localhost:" + config.Port + "/"})
+		origin.logger = newLogger(os.Stdout, "origin", config.LogLevel)
+		go func() {
+			origin.logger.Info("demo origin listening", "port", config.OriginPort)
+			if err := http.ListenAndServe(":"+config.OriginPort, origin.Routes()); err != nil {
+				fatal("origin failed to start", err)
+			}
+		}()
+	}
+
+	upstream, err := url.Parse(upstreamURL)
+	if err != nil {
+		fatal("invalid UPSTREAM_URL", err)
+	}
+	purgeAllow, err := parseNetworks(config.PurgeAllow)
+	if err != nil {
+		fatal("invalid PURGE_ALLOW", err)
+	}
+
+	proxy := NewProxy(upstream, NewCache(config.MaxEntries), purgeAllow)
+	proxy.logger = newLogger(os.Stdout, "proxy", config.LogLevel)
+
+	proxy.logger.Info("🚀 Caching proxy listening",
+		"port", config.Port,
+		"upstream", upstream.String(),
+		"try", "curl -i http://localhost:"+config.Port+"/tasks (twice, and watch X-Cache)")
+	if err := http.ListenAndServe(":"+config.Port, proxy); err != nil {
+		fatal("proxy failed to start", err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cachecontrol"
+	"correlation"
+	"httpcond"
+
+	"github.com/gorilla/mux"
+)
+
+// Cache policies of the demo origin. Browsers keep responses briefly while
+// the proxy keeps them for minutes (s-maxage): purges on every mutation keep
+// the proxy's copies correct, but nothing can purge a browser cache.
+var (
+	taskListPolicy = cachecontrol.Public(10 * time.Second).SharedMaxAge(time.Minute).StaleWhileRevalidate(30 * time.Second)
+	taskPolicy     = cachecontrol.Public(10 * time.Second).SharedMaxAge(5 * time.Minute).StaleIfError(time.Hour)
+	profilePolicy  = cachecontrol.Private(time.Minute)
+)
+
+type Task struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Done      bool      `json:"done"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Purger invalidates cached responses by surrogate key.
+type Purger interface {
+	Purge(ctx context.Context, keys ...string) error
+}
+
+// HTTPPurger sends Varnish xkey-style PURGE requests to a caching proxy.
+type HTTPPurger struct {
+	URL    string
+	Client *http.Client
+}
+
+func (p *HTTPPurger) Purge(ctx context.Context, keys ...string) error {
+	req, err := http.NewRequestWithContext(ctx, "PURGE", p.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("xkey-purge", strings.Join(keys, " "))
+	correlation.Inject(req)
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to purge %v: %w", keys, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to purge %v: %s", keys, resp.Status)
+	}
+	return nil
+}
+
+func taskKey(id int) string {
+	return "task:" + strconv.Itoa(id)
+}
+
+// Origin is a small task API that tags its responses with surrogate keys
+// and purges them from the proxy whenever tasks change.
+type Origin struct {
+	mu     sync.RWMutex
+	tasks  map[int]*Task
+	nextID int
+	purger Purger
+	logger *slog.Logger
+}
+
+func NewOrigin(purger Purger) *Origin {
+	now := time.Now().UTC()
+	return &Origin{
+		tasks: map[int]*Task{
+			1: {ID: 1, Title: "Put the API behind a cache", UpdatedAt: now},
+			2: {ID: 2, Title: "Purge on every write", UpdatedAt: now},
+		},
+		nextID: 3,
+		purger: purger,
+		logger: slog.Default(),
+	}
+}
+
+func (o *Origin) Routes() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/tasks", taskListPolicy.Wrap(o.listTasks)).Methods("GET")
+	router.HandleFunc("/tasks", o.createTask).Methods("POST")
+	router.HandleFunc("/tasks/{id:[0-9]+}", taskPolicy.Wrap(o.getTask)).Methods("GET")
+	router.HandleFunc("/tasks/{id:[0-9]+}", o.updateTask).Methods("PUT")
+	router.HandleFunc("/tasks/{id:[0-9]+}", o.deleteTask).Methods("DELETE")
+	router.HandleFunc("/me", profilePolicy.Wrap(o.getProfile)).Methods("GET")
+	return accessLog(o.logger, router)
+}
+
+// listTasks is tagged with the key of every task it contains, so changing
+// any of them purges the list too. Creating a task purges "tasks".
+func (o *Origin) listTasks(w http.ResponseWriter, r *http.Request) {
+	o.mu.RLock()
+	tasks := make([]Task, 0, len(o.tasks))
+	keys := []string{"tasks"}
+	for _, task := range o.tasks {
+		tasks = append(tasks, *task)
+	}
+	o.mu.RUnlock()
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	for _, task := range tasks {
+		keys = append(keys, taskKey(task.ID))
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"tasks": tasks, "count": len(tasks)})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "Failed to encode tasks"})
+		return
+	}
+
+	w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+	if !httpcond.Check(w, r, httpcond.Validators{ETag: httpcond.ForContent(body)}) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// getTask negotiates JSON or plain text, so its responses vary on Accept.
+func (o *Origin) getTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := o.findTask(w, r)
+	if !ok {
+		return
+	}
+
+	contentType := "application/json"
+	body, _ := json.Marshal(task)
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		contentType = "text/plain; charset=utf-8"
+		body = []byte(fmt.Sprintf("#%d %s (done: %t)\n", task.ID, task.Title, task.Done))
+	}
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Surrogate-Key", taskKey(task.ID))
+	validators := httpcond.Validators{ETag: httpcond.ForContent(body), LastModified: task.UpdatedAt}
+	if !httpcond.Check(w, r, validators) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+func (o *Origin) createTask(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Title) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Title is required"})
+		return
+	}
+
+	o.mu.Lock()
+	task := &Task{ID: o.nextID, Title: strings.TrimSpace(req.Title), UpdatedAt: time.Now().UTC()}
+	o.tasks[task.ID] = task
+	o.nextID++
+	created := *task
+	o.mu.Unlock()
+
+	o.purge(r.Context(), "tasks")
+	w.Header().Set("Location", fmt.Sprintf("/tasks/%d", created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (o *Origin) updateTask(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title *string `json:"title"`
+		Done  *bool   `json:"done"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid JSON"})
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	o.mu.Lock()
+	task, exists := o.tasks[id]
+	if exists {
+		if req.Title != nil {
+			task.Title = strings.TrimSpace(*req.Title)
+		}
+		if req.Done != nil {
+			task.Done = *req.Done
+		}
+		task.UpdatedAt = time.Now().UTC()
+	}
+	var updated Task
+	if exists {
+		updated = *task
+	}
+	o.mu.Unlock()
+
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Task not found"})
+		return
+	}
+
+	o.purge(r.Context(), taskKey(id))
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (o *Origin) deleteTask(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	o.mu.Lock()
+	_, exists := o.tasks[id]
+	delete(o.tasks, id)
+	o.mu.Unlock()
+
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Task not found"})
+		return
+	}
+
+	o.purge(r.Context(), taskKey(id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getProfile is personal, so its private policy keeps it out of the proxy.
+func (o *Origin) getProfile(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user":     r.Header.Get("X-User"),
+		"servedAt": time.Now().UTC(),
+	})
+}
+
+func (o *Origin) findTask(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	task, exists := o.tasks[id]
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Task not found"})
+		return Task{}, false
+	}
+	return *task, true
+}
+
+// purge runs after the write has succeeded. A failed purge is logged rather
+// than failing the write; the stale copy expires with its s-maxage.
+func (o *Origin) purge(ctx context.Context, keys ...string) {
+	if o.purger == nil {
+		return
+	}
+	if err := o.purger.Purge(ctx, keys...); err != nil {
+		o.logger.ErrorContext(ctx, "cache purge failed", "error", err)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cachecontrol"
+	"correlation"
+	"httpcond"
+	"respond"
+)
+
+// maxBodySize is the largest response body the cache stores; larger bodies
+// are streamed through
+const maxBodySize = 1 << 20
+
+// surrogateHeaders tag responses for purging. They are meant for the cache
+// and are removed before responses reach clients.
+var surrogateHeaders = []string{"Surrogate-Key", "xkey"}
+
+// CachingTransport is an http.RoundTripper that serves GET requests from a
+// shared cache, following the Cache-Control, ETag and Vary headers of the
+// upstream responses. Like Varnish, it ignores Cache-Control sent by
+// clients; only the origin decides what is cached and for how long.
+type CachingTransport struct {
+	Cache     *Cache
+	Transport http.RoundTripper
+
+	now func() time.Time
+
+	mu           sync.Mutex
+	revalidating map[*entry]bool
+}
+
+func NewCachingTransport(cache *Cache, transport http.RoundTripper) *CachingTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &CachingTransport{
+		Cache:        cache,
+		Transport:    transport,
+		now:          time.Now,
+		revalidating: make(map[*entry]bool),
+	}
+}
+
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.passThrough(req)
+	}
+
+	key := cacheKey(req.URL)
+	cached := t.Cache.Get(key, req)
+	now := t.now()
+
+	switch {
+	case cached == nil:
+		return t.fetch(req)
+	case cached.fresh(now):
+		return t.serve(req, cached, "HIT"), nil
+	case cached.servableWhileRevalidating(now):
+		t.revalidateInBackground(req, cached)
+		return t.serve(req, cached, "STALE"), nil
+	default:
+		return t.revalidate(req, cached)
+	}
+}
+
+// passThrough forwards requests the cache doesn't serve. A successful
+// unsafe request invalidates what is stored for its URL (RFC 9111
+// section 4.4).
+func (t *CachingTransport) passThrough(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	safe := req.Method == http.MethodHead || req.Method == http.MethodOptions
+	if !safe && resp.StatusCode < 400 {
+		t.Cache.PurgeKey(cacheKey(req.URL))
+	}
+
+	stripSurrogateHeaders(resp.Header)
+	resp.Header.Set("X-Cache", "PASS")
+	return resp, nil
+}
+
+// fetch handles a miss. The client's own conditionals are not forwarded, so
+// the upstream sends a full response the cache can store; they are
+// evaluated against the stored response instead.
+func (t *CachingTransport) fetch(req *http.Request) (*http.Response, error) {
+	upstream := upstreamRequest(req.Context(), req)
+
+	resp, err := t.Transport.RoundTrip(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return t.store(req, resp, "MISS")
+}
+
+// store caches a full upstream response when it is storable and serves it.
+func (t *CachingTransport) store(req *http.Request, resp *http.Response, status string) (*http.Response, error) {
+	body, complete, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		stripSurrogateHeaders(resp.Header)
+		resp.Header.Set("X-Cache", status)
+		return resp, nil
+	}
+
+	e := newEntry(req, resp.StatusCode, resp.Header, body, t.now())
+	if e == nil {
+		// The response is served once as it came in
+		e = &entry{status: resp.StatusCode, header: resp.Header, body: body, storedAt: t.now()}
+		return t.serve(req, e, status), nil
+	}
+
+	t.Cache.Put(e)
+	return t.serve(req, e, status), nil
+}
+
+// revalidate asks the upstream whether a stale entry is still current. On
+// 304 the entry is refreshed with the new headers; if the upstream fails
+// and stale-if-error allows it, the stale entry is served.
+func (t *CachingTransport) revalidate(req *http.Request, cached *entry) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(conditionalRequest(req.Context(), req, cached))
+	if err != nil || resp.StatusCode >= 500 {
+		if cached.servableOnError(t.now()) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return t.serve(req, cached, "STALE"), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		refreshed := t.refresh(req, cached, resp.Header)
+		if refreshed == nil {
+			return t.serve(req, cached, "REVALIDATED"), nil
+		}
+		return t.serve(req, refreshed, "REVALIDATED"), nil
+	}
+
+	t.Cache.Remove(cached)
+	return t.store(req, resp, "MISS")
+}
+
+// refresh stores a copy of the entry with the headers of a 304 response
+// merged in (RFC 9111 section 4.3.4). It returns nil when the new headers
+// make the response unstorable; the entry is then dropped.
+func (t *CachingTransport) refresh(req *http.Request, cached *entry, updates http.Header) *entry {
+	header := cached.header.Clone()
+	for name, values := range updates {
+		if name == "Content-Length" {
+			continue
+		}
+		header[name] = values
+	}
+
+	refreshed := newEntry(req, cached.status, header, cached.body, t.now())
+	if refreshed == nil {
+		t.Cache.Remove(cached)
+		return nil
+	}
+	t.Cache.Put(refreshed)
+	return refreshed
+}
+
+// revalidateInBackground refreshes a stale entry without making the client
+// wait. Only one revalidation per entry runs at a time.
+func (t *CachingTransport) revalidateInBackground(req *http.Request, cached *entry) {
+	t.mu.Lock()
+	if t.revalidating[cached] {
+		t.mu.Unlock()
+		return
+	}
+	t.revalidating[cached] = true
+	t.mu.Unlock()
+
+	// The client request's context ends when its response is written
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	background := req.Clone(ctx)
+
+	go func() {
+		defer cancel()
+		defer func() {
+			t.mu.Lock()
+			delete(t.revalidating, cached)
+			t.mu.Unlock()
+		}()
+
+		resp, err := t.revalidate(background, cached)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+}
+
+// serve builds the client response from an entry, answering the client's
+// conditional request with 304 when its validators still match.
+func (t *CachingTransport) serve(req *http.Request, e *entry, status string) *http.Response {
+	header := e.header.Clone()
+	stripSurrogateHeaders(header)
+	header.Set("X-Cache", status)
+	if status != "MISS" {
+		header.Set("Age", strconv.Itoa(int(e.age(t.now()).Seconds())))
+	}
+
+	resp := &http.Response{
+		Status:     http.StatusText(e.status),
+		StatusCode: e.status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Request:    req,
+	}
+
+	if e.status == http.StatusOK && httpcond.Evaluate(req, storedValidators(e.header)) == httpcond.NotModified {
+		resp.StatusCode = http.StatusNotModified
+		resp.Status = http.StatusText(http.StatusNotModified)
+		header.Del("Content-Length")
+		resp.Body = http.NoBody
+		return resp
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	resp.ContentLength = int64(len(e.body))
+	resp.Body = io.NopCloser(bytes.NewReader(e.body))
+	return resp
+}
+
+// newEntry returns a response as a cache entry, or nil when a shared cache
+// must not store it. Only 200 responses to GET with explicit freshness (or
+// no-cache and a validator) are stored; there are no heuristic lifetimes.
+func newEntry(req *http.Request, status int, header http.Header, body []byte, now time.Time) *entry {
+	if req.Method != http.MethodGet || status != http.StatusOK {
+		return nil
+	}
+
+	directives := cachecontrol.Parse(strings.Join(header.Values("Cache-Control"), ","))
+	if directives.Has("no-store") || directives.Has("private") {
+		return nil
+	}
+	// A response to an authenticated request may be personal; it is shared
+	// only when the origin says so (RFC 9111 section 3.5)
+	if req.Header.Get("Authorization") != "" &&
+		!directives.Has("public") && !directives.Has("s-maxage") && !directives.Has("must-revalidate") {
+		return nil
+	}
+
+	varyValues := make(map[string]string)
+	for _, name := range headerTokens(header, "Vary") {
+		if name == "*" {
+			return nil
+		}
+		varyValues[http.CanonicalHeaderKey(name)] = req.Header.Get(name)
+	}
+
+	freshFor, explicit := directives.Duration("s-maxage")
+	if !explicit {
+		freshFor, explicit = directives.Duration("max-age")
+	}
+	if directives.Has("no-cache") {
+		freshFor = 0
+		explicit = header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	}
+	if !explicit {
+		return nil
+	}
+
+	e := &entry{
+		key:           cacheKey(req.URL),
+		varyValues:    varyValues,
+		status:        status,
+		header:        header.Clone(),
+		body:          body,
+		storedAt:      now,
+		freshFor:      freshFor,
+		surrogateKeys: surrogateKeys(header),
+		// proxy-revalidate applies to shared caches only, so it counts here
+		mustRevalidate: directives.Has("must-revalidate") || directives.Has("proxy-revalidate"),
+	}
+	e.staleWhileRevalidate, _ = directives.Duration("stale-while-revalidate")
+	e.staleIfError, _ = directives.Duration("stale-if-error")
+	if seconds, err := strconv.Atoi(header.Get("Age")); err == nil && seconds > 0 {
+		e.initialAge = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+func storedValidators(header http.Header) httpcond.Validators {
+	var validators httpcond.Validators
+	if etag, err := httpcond.ParseETag(header.Get("ETag")); err == nil {
+		validators.ETag = etag
+	}
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		validators.LastModified = lastModified
+	}
+	return validators
+}
+
+// upstreamRequest copies the client request without its conditionals.
+func upstreamRequest(ctx context.Context, req *http.Request) *http.Request {
+	upstream := req.Clone(ctx)
+	upstream.Header.Del("If-None-Match")
+	upstream.Header.Del("If-Modified-Since")
+	return upstream
+}
+
+// conditionalRequest asks the upstream whether an entry is still current.
+func conditionalRequest(ctx context.Context, req *http.Request, cached *entry) *http.Request {
+	upstream := upstreamRequest(ctx, req)
+	if etag := cached.header.Get("ETag"); etag != "" {
+		upstream.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := cached.header.Get("Last-Modified"); lastModified != "" {
+		upstream.Header.Set("If-Modified-Since", lastModified)
+	}
+	return upstream
+}
+
+// readBody reads a response body up to maxBodySize. Larger bodies are left
+// readable on the response and reported as incomplete.
+func readBody(resp *http.Response) ([]byte, bool, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, false, err
+	}
+	if len(body) > maxBodySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, false, nil
+	}
+	resp.Body.Close()
+	return body, true, nil
+}
+
+// cacheKey identifies stored responses by path and query. The proxy has a
+// single upstream, so the host is not part of the key.
+func cacheKey(u *url.URL) string {
+	return u.RequestURI()
+}
+
+func surrogateKeys(header http.Header) []string {
+	var keys []string
+	for _, name := range surrogateHeaders {
+		for _, value := range header.Values(name) {
+			keys = append(keys, strings.Fields(value)...)
+		}
+	}
+	return keys
+}
+
+func stripSurrogateHeaders(header http.Header) {
+	for _, name := range surrogateHeaders {
+		header.Del(name)
+	}
+}
+
+// headerTokens splits a comma-separated header such as Vary
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, value := range header.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// Proxy is a caching reverse proxy in front of a single upstream. Besides
+// proxying, it answers Varnish-style PURGE requests from trusted networks.
+// Requests reach the upstream with the X-Request-ID and traceparent headers
+// the proxy logged them under.
+type Proxy struct {
+	cache      *Cache
+	proxy      *httputil.ReverseProxy
+	purgeAllow []*net.IPNet
+	logger     *slog.Logger
+}
+
+func NewProxy(upstream *url.URL, cache *Cache, purgeAllow []*net.IPNet) *Proxy {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.Transport = NewCachingTransport(cache, http.DefaultTransport)
+	// The proxy has already set the request ID; a stored response would
+	// otherwise repeat the ID of the request that filled the cache
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Del(correlation.RequestIDHeader)
+		return nil
+	}
+	return &Proxy{cache: cache, proxy: proxy, purgeAllow: purgeAllow, logger: slog.Default()}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accessLog(p.logger, http.HandlerFunc(p.serve)).ServeHTTP(w, r)
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PURGE" {
+		p.purge(w, r)
+		return
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+// purge removes stored responses. With an xkey-purge or Surrogate-Key
+// header it purges every response tagged with one of the listed keys;
+// otherwise it purges all variants of the request URL.
+func (p *Proxy) purge(w http.ResponseWriter, r *http.Request) {
+	if !p.purgeAllowed(r) {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{"error": "Purging not allowed from this address"})
+		return
+	}
+
+	var keys []string
+	for _, name := range []string{"xkey-purge", "Surrogate-Key"} {
+		for _, value := range r.Header.Values(name) {
+			keys = append(keys, strings.Fields(value)...)
+		}
+	}
+
+	var purged int
+	if len(keys) > 0 {
+		purged = p.cache.PurgeSurrogateKeys(keys...)
+	} else {
+		purged = p.cache.PurgeKey(cacheKey(r.URL))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"purged": purged})
+}
+
+func (p *Proxy) purgeAllowed(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.purgeAllow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetworks parses a comma-separated list of CIDR networks
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	respond.JSON(w, status, data)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testProxy struct {
+	proxy    *Proxy
+	cache    *Cache
+	upstream *httptest.Server
+	hits     *int64
+	now      time.Time
+}
+
+// newTestProxy puts a proxy with a controllable clock in front of handler.
+func newTestProxy(t *testing.T, handler http.Handler) *testProxy {
+	var hits int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	loopback, err := parseNetworks("127.0.0.0/8")
+	require.NoError(t, err)
+
+	tp := &testProxy{cache: NewCache(100), upstream: upstream, hits: &hits, now: time.Now()}
+	tp.proxy = NewProxy(upstreamURL, tp.cache, loopback)
+	tp.proxy.proxy.Transport.(*CachingTransport).now = func() time.Time { return tp.now }
+	return tp
+}
+
+func (tp *testProxy) do(method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	tp.proxy.ServeHTTP(rec, req)
+	return rec
+}
+
+func (tp *testProxy) upstreamHits() int64 {
+	return atomic.LoadInt64(tp.hits)
+}
+
+func cachedHandler(cacheControl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Write([]byte("hello " + r.URL.Path))
+	}
+}
+
+func TestProxyHitAndMiss(t *testing.T) {
+	tp := newTestProxy(t, cachedHandler("public, max-age=60"))
+
+	first := tp.do("GET", "/tasks", nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "MISS", first.Header().Get("X-Cache"))
+
+	tp.now = tp.now.Add(10 * time.Second)
+	second := tp.do("GET", "/tasks", nil)
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, "10", second.Header().Get("Age"))
+	assert.Equal(t, "hello /tasks", second.Body.String())
+
+	// The query string is part of the key
+	assert.Equal(t, "MISS", tp.do("GET", "/tasks?page=2", nil).Header().Get("X-Cache"))
+	assert.EqualValues(t, 2, tp.upstreamHits())
+}
+
+func TestProxySharedMaxAge(t *testing.T) {
+	tp := newTestProxy(t, cachedHandler("public, max-age=10, s-maxage=300"))
+
+	tp.do("GET", "/tasks", nil)
+	tp.now = tp.now.Add(time.Minute)
+	assert.Equal(t, "HIT", tp.do("GET", "/tasks", nil).Header().Get("X-Cache"))
+	assert.EqualValues(t, 1, tp.upstreamHits())
+}
+
+func TestProxyDoesNotStore(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		header       http.Header
+	}{
+		{name: "no-store", cacheControl: "no-store"},
+		{name: "private", cacheControl: "private, max-age=60"},
+		{name: "no freshness", cacheControl: ""},
+		{name: "authorization", cacheControl: "max-age=60", header: http.Header{"Authorization": {"Bearer token"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := newTestProxy(t, cachedHandler(tt.cacheControl))
+
+			tp.do("GET", "/me", tt.header)
+			rec := tp.do("GET", "/me", tt.header)
+			assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+			assert.Equal(t, 0, tp.cache.Len())
+			assert.EqualValues(t, 2, tp.upstreamHits())
+		})
+	}
+}
+
+func TestProxyStoresPublicAuthorizedResponses(t *testing.T) {
+	tp := newTestProxy(t, cachedHandler("public, max-age=60"))
+	auth := http.Header{"Authorization": {"Bearer token"}}
+
+	tp.do("GET", "/tasks", auth)
+	assert.Equal(t, "HIT", tp.do("GET", "/tasks", auth).Header().Get("X-Cache"))
+}
+
+func TestProxyVary(t *testing.T) {
+	tp := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept")
+		w.Write([]byte(r.Header.Get("Accept")))
+	}))
+	jsonHeader := http.Header{"Accept": {"application/json"}}
+	textHeader := http.Header{"Accept": {"text/plain"}}
+
+	assert.Equal(t, "MISS", tp.do("GET", "/tasks/1", jsonHeader).Header().Get("X-Cache"))
+	assert.Equal(t, "MISS", tp.do("GET", "/tasks/1", textHeader).Header().Get("X-Cache"))
+
+	rec := tp.do("GET", "/tasks/1", textHeader)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "text/plain", rec.Body.String())
+	assert.Equal(t, 2, tp.cache.Len())
+}
+
+func TestProxyRevalidatesStaleEntries(t *testing.T) {
+	tp := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("task list"))
+	}))
+
+	tp.do("GET", "/tasks", nil)
+	tp.now = tp.now.Add(2 * time.Minute)
+
+	rec := tp.do("GET", "/tasks", nil)
+	assert.Equal(t, "REVALIDATED", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "task list", rec.Body.String())
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+
+	// The refreshed entry is fresh again
+	assert.Equal(t, "HIT", tp.do("GET", "/tasks", nil).Header().Get("X-Cache"))
+	assert.EqualValues(t, 2, tp.upstreamHits())
+}
+
+func TestProxyAnswersClientConditionals(t *testing.T) {
+	tp := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("task list"))
+	}))
+	conditional := http.Header{"If-None-Match": {`"v1"`}}
+
+	// Even on a miss the upstream is asked for the full response
+	rec := tp.do("GET", "/tasks", conditional)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, 1, tp.cache.Len())
+
+	rec = tp.do("GET", "/tasks", conditional)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestProxyStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Value
+	version.Store("v1")
+	tp := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=30")
+		w.Write([]byte(version.Load().(string)))
+	}))
+
+	tp.do("GET", "/tasks", nil)
+	version.Store("v2")
+	tp.now = tp.now.Add(70 * time.Second)
+
+	rec := tp.do("GET", "/tasks", nil)
+	assert.Equal(t, "STALE", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "v1", rec.Body.String())
+
+	assert.Eventually(t, func() bool {
+		rec := tp.do("GET", "/tasks", nil)
+		return rec.Header().Get("X-Cache") == "HIT" && rec.Body.String() == "v2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestProxyStaleIfError(t *testing.T) {
+	var failing atomic.Bool
+	tp := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60, stale-if-error=300")
+		w.Write([]byte("task list"))
+	}))
+
+	tp.do("GET", "/tasks", nil)
+	failing.Store(true)
+
+	tp.now = tp.now.Add(2 * time.Minute)
+	rec := tp.do("GET", "/tasks", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "STALE", rec.Header().Get("X-Cache"))
+
+	tp.now = tp.now.Add(10 * time.Minute)
+	assert.Equal(t, http.StatusServiceUnavailable, tp.do("GET", "/tasks", nil).Code)
+}
+
+func TestProxyUnsafeMethodsInvalidate(t *testing.T) {
+	tp := newTestProxy(t, cachedHandler("max-age=60"))
+
+	tp.do("GET", "/tasks/1", nil)
+	rec := tp.do("PUT", "/tasks/1", nil)
+	assert.Equal(t, "PASS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "MISS", tp.do("GET", "/tasks/1", nil).Header().Get("X-Cache"))
+}
+
+func TestProxyPurge(t *testing.T) {
+	tp := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Surrogate-Key", "tasks task:1")
+		w.Header().Add("Vary", "Accept")
+		w.Write([]byte("tasks"))
+	}))
+
+	rec := tp.do("GET", "/tasks", nil)
+	assert.Empty(t, rec.Header().Get("Surrogate-Key"), "surrogate keys must not reach clients")
+	tp.do("GET", "/tasks", http.Header{"Accept": {"text/plain"}})
+	tp.do("GET", "/tasks?page=2", nil)
+
+	t.Run("by surrogate key", func(t *testing.T) {
+		rec := tp.do("PURGE", "/", http.Header{"Xkey-Purge": {"task:1"}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"purged":3}`, rec.Body.String())
+		assert.Equal(t, 0, tp.cache.Len())
+	})
+
+	t.Run("by URL", func(t *testing.T) {
+		tp.do("GET", "/tasks", nil)
+		tp.do("GET", "/tasks?page=2", nil)
+
+		rec := tp.do("PURGE", "/tasks", nil)
+		assert.JSONEq(t, `{"purged":1}`, rec.Body.String())
+		assert.Equal(t, 1, tp.cache.Len())
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		req := httptest.NewRequest("PURGE", "/tasks", nil)
+		req.RemoteAddr = "203.0.113.7:40000"
+		rec := httptest.NewRecorder()
+		tp.proxy.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := NewCache(2)
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, key := range []string{"/a", "/b"} {
+		cache.Put(&entry{key: key, surrogateKeys: []string{"all"}})
+	}
+
+	// Using /a makes /b the least recently used
+	require.NotNil(t, cache.Get("/a", req))
+	cache.Put(&entry{key: "/c", surrogateKeys: []string{"all"}})
+
+	assert.NotNil(t, cache.Get("/a", req))
+	assert.Nil(t, cache.Get("/b", req))
+	assert.Equal(t, 2, cache.PurgeSurrogateKeys("all"))
+}
+
+// TestOriginPurgesProxy runs the demo origin behind the proxy: writes go
+// through the proxy and the origin purges what they change.
+func TestOriginPurgesProxy(t *testing.T) {
+	purger := &HTTPPurger{}
+	tp := newTestProxy(t, NewOrigin(purger).Routes())
+
+	proxyServer := httptest.NewServer(tp.proxy)
+	t.Cleanup(proxyServer.Close)
+	purger.URL = proxyServer.URL
+
+	get := func(path string) *http.Response {
+		resp, err := http.Get(proxyServer.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	get("/tasks")
+	get("/tasks/1")
+	get("/tasks/2")
+	assert.Equal(t, "HIT", get("/tasks").Header.Get("X-Cache"))
+
+	req, err := http.NewRequest("PUT", proxyServer.URL+"/tasks/2", strings.NewReader(`{"done":true}`))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// task:2 tagged the task and the list; task 1 is untouched
+	assert.Equal(t, "MISS", get("/tasks").Header.Get("X-Cache"))
+	assert.Equal(t, "MISS", get("/tasks/2").Header.Get("X-Cache"))
+	assert.Equal(t, "HIT", get("/tasks/1").Header.Get("X-Cache"))
+
+	// Personal responses are never shared
+	get("/me")
+	assert.Equal(t, "MISS", get("/me").Header.Get("X-Cache"))
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks("127.0.0.0/8, ::1/128")
+	require.NoError(t, err)
+	assert.Len(t, networks, 2)
+	assert.True(t, networks[1].Contains(net.ParseIP("::1")))
+
+	_, err = parseNetworks("localhost")
+	assert.Error(t, err)
+}
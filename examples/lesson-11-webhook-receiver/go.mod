@@ -0,0 +1,20 @@
+module lesson-11-webhook-receiver
+
+go 1.21
+
+require (
+	correlation v0.0.0
+	github.com/stretchr/testify v1.8.4
+	logging v0.0.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	correlation => ../pkg/correlation
+	logging => ../pkg/logging
+)
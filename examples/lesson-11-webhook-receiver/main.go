@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"logging"
+)
+
+type Config struct {
+	Port string
+	// Secrets are the webhook secrets to accept, comma-separated; more than
+	// one while a secret is rotated
+	Secrets   []string
+	Tolerance time.Duration
+	// Retention is how long delivery IDs are remembered for deduplication
+	Retention time.Duration
+	// LogLevel is the least severe level logged: debug, info, warn or error
+	LogLevel slog.Level
+}
+
+func loadConfig() Config {
+	var secrets []string
+	for _, secret := range strings.Split(os.Getenv("WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return Config{
+		Port:      getEnv("PORT", "8092"),
+		Secrets:   secrets,
+		Tolerance: getDurationEnv("WEBHOOK_TOLERANCE", 5*time.Minute),
+		Retention: getDurationEnv("DELIVERY_RETENTION", 24*time.Hour),
+		LogLevel:  getLogLevelEnv("LOG_LEVEL", slog.LevelInfo),
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		slog.Warn("invalid duration, using the default", "key", key, "value", value, "default", defaultValue.String())
+	}
+	return defaultValue
+}
+
+func getLogLevelEnv(key string, defaultValue slog.Level) slog.Level {
+	if value := os.Getenv(key); value != "" {
+		if level, err := logging.ParseLevel(value); err == nil {
+			return level
+		}
+		slog.Warn("invalid log level, using the default", "key", key, "value", value, "default", defaultValue)
+	}
+	return defaultValue
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func main() {
+	var logLevel slog.LevelVar
+	slog.SetDefault(logging.New(os.Stdout, &logLevel))
+	config := loadConfig()
+	logLevel.Set(config.LogLevel)
+	if len(config.Secrets) == 0 {
+		fatal("invalid configuration", errors.New("WEBHOOK_SECRETS is required: the secret returned when the webhook was created"))
+	}
+
+	receiver := NewReceiver(NewVerifier(config.Tolerance, config.Secrets...), NewStore(config.Retention))
+
+	slog.Info("🚀 Webhook receiver listening",
+		"port", config.Port,
+		"register", "http://localhost:"+config.Port+"/webhooks/tasks with POST /api/webhooks of lesson 8")
+	if err := http.ListenAndServe(":"+config.Port, receiver.Routes()); err != nil {
+		fatal("receiver failed to start", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"correlation"
+	"logging"
+)
+
+const (
+	// DeliveryIDHeader stays the same across retries of a delivery
+	DeliveryIDHeader = "X-Webhook-Id"
+	EventHeader      = "X-Webhook-Event"
+
+	maxBodyBytes = 1 << 20
+)
+
+// Receiver is the endpoint the task API delivers webhooks to.
+type Receiver struct {
+	verifier *Verifier
+	store    *Store
+}
+
+func NewReceiver(verifier *Verifier, store *Store) *Receiver {
+	return &Receiver{verifier: verifier, store: store}
+}
+
+func (rc *Receiver) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhooks/tasks", rc.HandleWebhook)
+	mux.HandleFunc("/tasks", rc.GetTasks)
+	return correlation.Middleware(logging.Middleware(slog.Default())(mux))
+}
+
+// HandleWebhook verifies and applies one delivery. The status code is the
+// answer to the sender: 2xx means done, anything else means try again later.
+// Deliveries that will never succeed (bad signature, unsupported version)
+// still fail, so they show up in the sender's delivery log.
+func (rc *Receiver) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// The signature covers the raw bytes, so read them before any decoding
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Body too large")
+		return
+	}
+	if err := rc.verifier.Verify(r.Header.Get(SignatureHeader), body); err != nil {
+		slog.WarnContext(r.Context(), "rejected delivery", "delivery_id", r.Header.Get(DeliveryIDHeader), "error", err)
+		respondWithError(w, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	deliveryID := r.Header.Get(DeliveryIDHeader)
+	if deliveryID == "" {
+		respondWithError(w, http.StatusBadRequest, DeliveryIDHeader+" is required")
+		return
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	logging.AddFields(r.Context(), slog.String("delivery_id", deliveryID), slog.String("event", event.Type))
+
+	result, err := rc.store.Apply(deliveryID, event)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed delivery", "error", err)
+		if errors.Is(err, ErrUnsupportedVersion) {
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.InfoContext(r.Context(), "delivery", "result", result)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": string(result)})
+}
+
+// GetTasks shows the projection, to compare with the sender's tasks.
+func (rc *Receiver) GetTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	tasks := rc.store.Tasks()
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"tasks": tasks,
+		"count": len(tasks),
+	})
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	respondWithJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "whsec_test"
+
+func newTestReceiver() *Receiver {
+	store := NewStore(time.Hour)
+	store.now = func() time.Time { return signedAt }
+	return NewReceiver(testVerifier(testSecret), store)
+}
+
+func taskEvent(t *testing.T, eventType string, at time.Time, task Task) []byte {
+	data, err := json.Marshal(task)
+	require.NoError(t, err)
+	body, err := json.Marshal(Event{
+		ID:        "evt-" + at.Format(time.RFC3339Nano),
+		Type:      eventType,
+		Version:   1,
+		Schema:    "http://localhost:8088/api/webhooks/schemas/" + eventType + "/1",
+		CreatedAt: at,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return body
+}
+
+func deliver(receiver *Receiver, deliveryID string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/tasks", bytes.NewReader(body))
+	req.Header.Set(DeliveryIDHeader, deliveryID)
+	req.Header.Set(SignatureHeader, SignatureFor(testSecret, signedAt, body))
+	w := httptest.NewRecorder()
+	receiver.Routes().ServeHTTP(w, req)
+	return w
+}
+
+func deliveryResult(t *testing.T, w *httptest.ResponseRecorder) Result {
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return Result(response["result"])
+}
+
+func TestReceiverProjectsEvents(t *testing.T) {
+	receiver := newTestReceiver()
+	created := signedAt.Add(-3 * time.Second)
+	updated := created.Add(time.Second)
+
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d1", taskEvent(t, "task.created", created,
+		Task{ID: "t1", Title: "Write docs", Priority: "medium"}))))
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d2", taskEvent(t, "task.updated", updated,
+		Task{ID: "t1", Title: "Write docs", Priority: "high", Completed: true}))))
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d3", taskEvent(t, "task.created", updated,
+		Task{ID: "t2", Title: "Review"}))))
+
+	tasks := receiver.store.Tasks()
+	require.Len(t, tasks, 2)
+	byID := map[string]Task{tasks[0].ID: tasks[0], tasks[1].ID: tasks[1]}
+	assert.True(t, byID["t1"].Completed)
+	assert.Equal(t, "high", byID["t1"].Priority)
+
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d4", taskEvent(t, "task.deleted", updated.Add(time.Second),
+		Task{ID: "t1"}))))
+	require.Len(t, receiver.store.Tasks(), 1)
+
+	// Events the receiver doesn't project are acknowledged
+	assert.Equal(t, Ignored, deliveryResult(t, deliver(receiver, "d5", taskEvent(t, "comment.created", updated, Task{ID: "c1"}))))
+}
+
+func TestReceiverRetries(t *testing.T) {
+	receiver := newTestReceiver()
+	at := signedAt.Add(-time.Minute)
+	create := taskEvent(t, "task.created", at, Task{ID: "t1", Title: "Original"})
+
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d1", create)))
+	assert.Equal(t, Duplicate, deliveryResult(t, deliver(receiver, "d1", create)))
+
+	// A newer update, then a retry of an older one that arrives late
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d3", taskEvent(t, "task.updated", at.Add(2*time.Second),
+		Task{ID: "t1", Title: "Newest"}))))
+	assert.Equal(t, Outdated, deliveryResult(t, deliver(receiver, "d2", taskEvent(t, "task.updated", at.Add(time.Second),
+		Task{ID: "t1", Title: "Older"}))))
+	assert.Equal(t, "Newest", receiver.store.Tasks()[0].Title)
+
+	// A late create can't bring back a deleted task
+	assert.Equal(t, Applied, deliveryResult(t, deliver(receiver, "d4", taskEvent(t, "task.deleted", at.Add(3*time.Second), Task{ID: "t1"}))))
+	assert.Equal(t, Duplicate, deliveryResult(t, deliver(receiver, "d1", create)))
+	assert.Equal(t, Outdated, deliveryResult(t, deliver(receiver, "d1-redelivered", create)))
+	assert.Empty(t, receiver.store.Tasks())
+}
+
+func TestReceiverConcurrentRetries(t *testing.T) {
+	receiver := newTestReceiver()
+	body := taskEvent(t, "task.created", signedAt, Task{ID: "t1", Title: "Once"})
+
+	responses := make(chan *httptest.ResponseRecorder, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses <- deliver(receiver, "d1", body)
+		}()
+	}
+	wg.Wait()
+	close(responses)
+
+	counts := map[Result]int{}
+	for w := range responses {
+		counts[deliveryResult(t, w)]++
+	}
+	assert.Equal(t, map[Result]int{Applied: 1, Duplicate: 9}, counts)
+}
+
+func TestReceiverRejects(t *testing.T) {
+	receiver := newTestReceiver()
+	body := taskEvent(t, "task.created", signedAt, Task{ID: "t1", Title: "Forged"})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/tasks", bytes.NewReader(body))
+	req.Header.Set(DeliveryIDHeader, "d1")
+	req.Header.Set(SignatureHeader, SignatureFor("whsec_guess", signedAt, body))
+	w := httptest.NewRecorder()
+	receiver.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	assert.Equal(t, http.StatusBadRequest, deliver(receiver, "", body).Code)
+
+	// A version the receiver doesn't know fails, so it stays in the sender's
+	// log until the receiver is upgraded; the delivery can then be retried
+	var event Event
+	require.NoError(t, json.Unmarshal(body, &event))
+	event.Version = 2
+	v2, _ := json.Marshal(event)
+	assert.Equal(t, http.StatusUnprocessableEntity, deliver(receiver, "d2", v2).Code)
+	assert.Empty(t, receiver.store.Tasks())
+}
+
+func TestStoreForgetsOldDeliveries(t *testing.T) {
+	store := NewStore(time.Hour)
+	now := signedAt
+	store.now = func() time.Time { return now }
+
+	event := Event{Type: "comment.created"}
+	_, err := store.Apply("d1", event)
+	require.NoError(t, err)
+	result, _ := store.Apply("d1", event)
+	assert.Equal(t, Duplicate, result)
+
+	now = now.Add(2 * time.Hour)
+	result, _ = store.Apply("d1", event)
+	assert.Equal(t, Ignored, result)
+}
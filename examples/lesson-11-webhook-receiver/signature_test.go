@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var signedAt = time.Unix(1700000000, 0)
+
+func testVerifier(secrets ...string) *Verifier {
+	verifier := NewVerifier(5*time.Minute, secrets...)
+	verifier.now = func() time.Time { return signedAt.Add(time.Minute) }
+	return verifier
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	// The header lesson 8 sends for this body, secret and time
+	header := "t=1700000000,v1=11bf4466ea17c3df3fd743af0b435368e16b7a05eb8eced85e8c4670767bdec5"
+	assert.Equal(t, header, SignatureFor("whsec_test", signedAt, body))
+
+	assert.NoError(t, testVerifier("whsec_test").Verify(header, body))
+	// During a rotation either secret is accepted
+	assert.NoError(t, testVerifier("whsec_new", "whsec_test").Verify(header, body))
+	// Unknown schemes are skipped
+	assert.NoError(t, testVerifier("whsec_test").Verify(header+",v2=abc", body))
+}
+
+func TestVerifyRejects(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	valid := SignatureFor("whsec_test", signedAt, body)
+
+	for name, tc := range map[string]struct {
+		header string
+		body   string
+		err    error
+	}{
+		"missing":         {"", `{"id":"1"}`, ErrMissingSignature},
+		"no timestamp":    {"v1=11bf", `{"id":"1"}`, ErrMalformedSignature},
+		"no signature":    {"t=1700000000", `{"id":"1"}`, ErrMalformedSignature},
+		"not hex":         {"t=1700000000,v1=zz", `{"id":"1"}`, ErrMalformedSignature},
+		"changed body":    {valid, `{"id":"2"}`, ErrSignatureMismatch},
+		"wrong secret":    {SignatureFor("whsec_other", signedAt, body), `{"id":"1"}`, ErrSignatureMismatch},
+		"replayed":        {SignatureFor("whsec_test", signedAt.Add(-10*time.Minute), body), `{"id":"1"}`, ErrStaleSignature},
+		"from the future": {SignatureFor("whsec_test", signedAt.Add(10*time.Minute), body), `{"id":"1"}`, ErrStaleSignature},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, testVerifier("whsec_test").Verify(tc.header, []byte(tc.body)), tc.err)
+		})
+	}
+}
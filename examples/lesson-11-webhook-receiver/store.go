@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event is the envelope of every delivery. Data is decoded by Type.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	Schema    string          `json:"schema"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Task is the receiver's copy of a task: only the fields it uses. Fields the
+// sender adds later are ignored, which is what lets it add them without a
+// new schema version.
+type Task struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Completed bool       `json:"completed"`
+	Priority  string     `json:"priority"`
+	DueDate   *time.Time `json:"dueDate"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// Result says what Apply did with a delivery.
+type Result string
+
+const (
+	Applied   Result = "applied"
+	Duplicate Result = "duplicate"
+	// Outdated events are older than one already applied to the task
+	Outdated Result = "outdated"
+	// Ignored events are of a type the receiver doesn't project
+	Ignored Result = "ignored"
+)
+
+// supportedVersion is the payload schema version this receiver was written
+// against
+const supportedVersion = 1
+
+var ErrUnsupportedVersion = errors.New("unsupported payload version")
+
+// Store projects task events into a local copy of the sender's tasks.
+//
+// Deliveries are at least once: a timeout after the receiver processed one
+// makes the sender retry it. The store remembers the delivery IDs it has
+// processed and applies each one once. A retry can also arrive after a newer
+// event for the same task, so each task keeps the time of the last event
+// applied to it, deletions included, and older events are skipped.
+type Store struct {
+	mu    sync.Mutex
+	tasks map[string]*Task
+	// lastEvent is when the last event applied to each task was sent
+	lastEvent map[string]time.Time
+
+	// processed holds delivery IDs until retention has passed, longer than
+	// the sender keeps retrying; seen keeps them in order for pruning
+	processed map[string]time.Time
+	seen      []string
+	retention time.Duration
+	now       func() time.Time
+}
+
+func NewStore(retention time.Duration) *Store {
+	return &Store{
+		tasks:     make(map[string]*Task),
+		lastEvent: make(map[string]time.Time),
+		processed: make(map[string]time.Time),
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// Apply processes one delivery. Checking the delivery ID and projecting the
+// event happen under one lock, so two copies of a delivery arriving at once
+// still apply it once; a database-backed receiver does both in a single
+// transaction. A delivery that fails is not recorded, so its retry can
+// succeed.
+func (s *Store) Apply(deliveryID string, event Event) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+
+	if _, ok := s.processed[deliveryID]; ok {
+		return Duplicate, nil
+	}
+	result, err := s.project(event)
+	if err != nil {
+		return "", err
+	}
+	s.processed[deliveryID] = s.now()
+	s.seen = append(s.seen, deliveryID)
+	return result, nil
+}
+
+func (s *Store) project(event Event) (Result, error) {
+	switch event.Type {
+	case "task.created", "task.updated", "task.deleted":
+	default:
+		return Ignored, nil
+	}
+	if event.Version != supportedVersion {
+		return "", fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, event.Type, event.Version)
+	}
+
+	var task Task
+	if err := json.Unmarshal(event.Data, &task); err != nil {
+		return "", fmt.Errorf("invalid %s data: %w", event.Type, err)
+	}
+	if task.ID == "" {
+		return "", fmt.Errorf("invalid %s data: id is missing", event.Type)
+	}
+	if last, ok := s.lastEvent[task.ID]; ok && !event.CreatedAt.After(last) {
+		return Outdated, nil
+	}
+
+	s.lastEvent[task.ID] = event.CreatedAt
+	if event.Type == "task.deleted" {
+		delete(s.tasks, task.ID)
+	} else {
+		s.tasks[task.ID] = &task
+	}
+	return Applied, nil
+}
+
+// prune forgets delivery IDs older than the retention
+func (s *Store) prune() {
+	cutoff := s.now().Add(-s.retention)
+	for len(s.seen) > 0 && s.processed[s.seen[0]].Before(cutoff) {
+		delete(s.processed, s.seen[0])
+		s.seen = s.seen[1:]
+	}
+}
+
+// Tasks returns the projected tasks, most recently updated first.
+func (s *Store) Tasks() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, *task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].UpdatedAt.Equal(tasks[j].UpdatedAt) {
+			return tasks[i].ID < tasks[j].ID
+		}
+		return tasks[i].UpdatedAt.After(tasks[j].UpdatedAt)
+	})
+	return tasks
+}
@@ -0,0 +1,247 @@
+// Package cachecontrol composes Cache-Control policies (RFC 9111 and
+// RFC 5861) and applies them to routes, so cache lifetimes are declared once
+// next to the routes instead of as header strings inside handlers. Caches
+// read the header back with Parse.
+//
+//	products := cachecontrol.Public(5 * time.Minute).StaleWhileRevalidate(time.Minute)
+//	router.HandleFunc("/products", products.Wrap(getProductsHandler))
+package cachecontrol
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is an immutable set of Cache-Control directives. Methods return a
+// modified copy, so policies can be shared and extended per route.
+type Policy struct {
+	private        bool
+	public         bool
+	noStore        bool
+	noCache        bool
+	mustRevalidate bool
+	immutable      bool
+
+	maxAge               *time.Duration
+	sharedMaxAge         *time.Duration
+	staleWhileRevalidate *time.Duration
+	staleIfError         *time.Duration
+}
+
+// Public allows any cache, including shared ones such as proxies and CDNs,
+// to store the response for maxAge.
+func Public(maxAge time.Duration) Policy {
+	return Policy{public: true, maxAge: &maxAge}
+}
+
+// Private allows only the client's own cache to store the response, for
+// per-user data.
+func Private(maxAge time.Duration) Policy {
+	return Policy{private: true, maxAge: &maxAge}
+}
+
+// NoStore forbids storing the response anywhere, for tokens and other
+// secrets. Other directives are ignored.
+func NoStore() Policy {
+	return Policy{noStore: true}
+}
+
+// NoCache lets caches store the response but requires revalidation (with
+// ETag or Last-Modified) before each reuse. It replaces max-age.
+func (p Policy) NoCache() Policy {
+	p.noCache = true
+	return p
+}
+
+// SharedMaxAge overrides max-age for shared caches (s-maxage).
+func (p Policy) SharedMaxAge(d time.Duration) Policy {
+	p.sharedMaxAge = &d
+	return p
+}
+
+// StaleWhileRevalidate lets caches serve a stale response for up to d while
+// they revalidate it in the background.
+func (p Policy) StaleWhileRevalidate(d time.Duration) Policy {
+	p.staleWhileRevalidate = &d
+	return p
+}
+
+// StaleIfError lets caches serve a stale response for up to d when the
+// origin fails.
+func (p Policy) StaleIfError(d time.Duration) Policy {
+	p.staleIfError = &d
+	return p
+}
+
+// MustRevalidate forbids serving the response once stale.
+func (p Policy) MustRevalidate() Policy {
+	p.mustRevalidate = true
+	return p
+}
+
+// Immutable tells clients the response never changes while fresh, so they
+// skip revalidation even on reload.
+func (p Policy) Immutable() Policy {
+	p.immutable = true
+	return p
+}
+
+// String returns the Cache-Control header value.
+func (p Policy) String() string {
+	if p.noStore {
+		return "no-store"
+	}
+
+	var directives []string
+	switch {
+	case p.private:
+		directives = append(directives, "private")
+	case p.public:
+		directives = append(directives, "public")
+	}
+	if p.noCache {
+		directives = append(directives, "no-cache")
+	} else if p.maxAge != nil {
+		directives = append(directives, "max-age="+seconds(*p.maxAge))
+	}
+	if p.sharedMaxAge != nil {
+		directives = append(directives, "s-maxage="+seconds(*p.sharedMaxAge))
+	}
+	if p.mustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if p.staleWhileRevalidate != nil {
+		directives = append(directives, "stale-while-revalidate="+seconds(*p.staleWhileRevalidate))
+	}
+	if p.staleIfError != nil {
+		directives = append(directives, "stale-if-error="+seconds(*p.staleIfError))
+	}
+	if p.immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+func seconds(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+// Apply sets the Cache-Control header.
+func (p Policy) Apply(h http.Header) {
+	h.Set("Cache-Control", p.String())
+}
+
+// appliesTo reports whether the policy covers a response status. Only
+// successful responses and 304s are cached with the route's policy, so an
+// error isn't served from cache for the lifetime of the data; no-store
+// covers every response.
+func (p Policy) appliesTo(status int) bool {
+	return p.noStore || (status >= 200 && status < 300) || status == http.StatusNotModified
+}
+
+// Middleware applies the policy to every route of a router, e.g.
+// router.Use(policy.Middleware).
+func (p Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&policyWriter{ResponseWriter: w, policy: p}, r)
+	})
+}
+
+// Wrap applies the policy to a single route. A Cache-Control header set by
+// the handler itself takes precedence.
+func (p Policy) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return p.Middleware(next).ServeHTTP
+}
+
+// policyWriter sets Cache-Control just before the headers are written, when
+// the status is known and the handler had its chance to set its own.
+type policyWriter struct {
+	http.ResponseWriter
+	policy      Policy
+	wroteHeader bool
+}
+
+func (w *policyWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.Header().Get("Cache-Control") == "" && w.policy.appliesTo(code) {
+			w.policy.Apply(w.Header())
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *policyWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *policyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Directives are parsed Cache-Control directives keyed by lower-case name;
+// directives without an argument map to "".
+type Directives map[string]string
+
+// Parse parses a Cache-Control header value, e.g. as received by a cache.
+// Quoted arguments such as private="Set-Cookie, Authorization" are kept
+// whole, without the quotes.
+func Parse(value string) Directives {
+	directives := make(Directives)
+	for value != "" {
+		var part string
+		part, value = nextDirective(value)
+
+		name, arg, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
+
+// nextDirective splits at the first comma outside a quoted string
+func nextDirective(value string) (string, string) {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return value[:i], value[i+1:]
+			}
+		}
+	}
+	return value, ""
+}
+
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Duration returns a delta-seconds argument such as max-age. Missing or
+// invalid arguments report false.
+func (d Directives) Duration(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
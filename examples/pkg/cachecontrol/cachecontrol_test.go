@@ -0,0 +1,100 @@
+package cachecontrol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyString(t *testing.T) {
+	tests := []struct {
+		policy   Policy
+		expected string
+	}{
+		{Public(5 * time.Minute), "public, max-age=300"},
+		{Private(0), "private, max-age=0"},
+		{Private(time.Hour).NoCache(), "private, no-cache"},
+		{NoStore().StaleIfError(time.Hour), "no-store"},
+		{
+			Public(time.Minute).SharedMaxAge(time.Hour).StaleWhileRevalidate(30 * time.Second).StaleIfError(24 * time.Hour),
+			"public, max-age=60, s-maxage=3600, stale-while-revalidate=30, stale-if-error=86400",
+		},
+		{Public(365 * 24 * time.Hour).Immutable(), "public, max-age=31536000, immutable"},
+		{Public(0).MustRevalidate(), "public, max-age=0, must-revalidate"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.policy.String())
+	}
+}
+
+func TestPolicyIsImmutable(t *testing.T) {
+	base := Public(time.Minute)
+	extended := base.StaleWhileRevalidate(time.Minute)
+	assert.Equal(t, "public, max-age=60", base.String())
+	assert.Equal(t, "public, max-age=60, stale-while-revalidate=60", extended.String())
+}
+
+func serve(policy Policy, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	policy.Wrap(handler)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func TestWrap(t *testing.T) {
+	policy := Public(time.Minute)
+
+	w := serve(policy, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+
+	w = serve(policy, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+
+	w = serve(policy, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	assert.Empty(t, w.Header().Get("Cache-Control"), "errors aren't cached with the route's policy")
+
+	w = serve(NoStore(), func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+	})
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	w = serve(policy, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte("ok"))
+	})
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"), "handler wins")
+}
+
+func TestParse(t *testing.T) {
+	directives := Parse(`public, Max-Age=300, s-maxage="600", private="Set-Cookie, Authorization", no-cache,`)
+
+	assert.True(t, directives.Has("public"))
+	assert.True(t, directives.Has("no-cache"))
+	assert.False(t, directives.Has("no-store"))
+	assert.Equal(t, "Set-Cookie, Authorization", directives["private"])
+
+	maxAge, ok := directives.Duration("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Minute, maxAge)
+	sharedMaxAge, ok := directives.Duration("s-maxage")
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, sharedMaxAge)
+
+	_, ok = Parse("max-age=soon").Duration("max-age")
+	assert.False(t, ok)
+
+	// Policies round-trip
+	policy := Public(time.Minute).StaleWhileRevalidate(30 * time.Second)
+	swr, ok := Parse(policy.String()).Duration("stale-while-revalidate")
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, swr)
+}
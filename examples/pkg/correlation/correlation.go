@@ -0,0 +1,172 @@
+// Package correlation carries a request ID and a W3C trace context from the
+// first service a request reaches to every service behind it, so the log
+// lines one client request leaves in a gateway and its backends can be found
+// with a single search.
+//
+// Middleware reads X-Request-ID and traceparent from the incoming request,
+// or starts new ones, stores them in the context and writes them back onto
+// the request, so a reverse proxy forwards them unchanged. Services that
+// make their own outbound calls copy them with Inject, or forward an
+// allowlist of headers, baggage included, with Passthrough and Transport.
+//
+//	handler = correlation.Middleware(handler)
+//	...
+//	logger.InfoContext(ctx, "request", correlation.LogAttrs(ctx)...)
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceparentHeader = "traceparent"
+
+	// maxRequestIDLength bounds client-chosen request IDs, which end up in
+	// every log line of the request
+	maxRequestIDLength = 128
+)
+
+// IDs identify one request across services. RequestID and TraceID are the
+// same in every service; SpanID is this service's part of the request and
+// ParentSpanID the caller's, empty at the first service.
+type IDs struct {
+	RequestID    string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+}
+
+// Traceparent formats the IDs as a W3C traceparent header naming this
+// service's span, for the next service to continue.
+func (ids IDs) Traceparent() string {
+	return "00-" + ids.TraceID + "-" + ids.SpanID + "-01"
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, ids IDs) context.Context {
+	return context.WithValue(ctx, contextKey{}, ids)
+}
+
+func FromContext(ctx context.Context) (IDs, bool) {
+	ids, ok := ctx.Value(contextKey{}).(IDs)
+	return ids, ok
+}
+
+// RequestID returns the request ID in ctx, or "" outside of a request.
+func RequestID(ctx context.Context) string {
+	ids, _ := FromContext(ctx)
+	return ids.RequestID
+}
+
+// FromRequest continues the caller's request ID and trace, starting new
+// ones where the headers are missing or malformed. The span is always new.
+func FromRequest(r *http.Request) IDs {
+	ids := IDs{
+		RequestID: r.Header.Get(RequestIDHeader),
+		SpanID:    randomHex(8),
+	}
+	if !validRequestID(ids.RequestID) {
+		ids.RequestID = randomHex(16)
+	}
+	if traceID, parentID, ok := parseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+		ids.TraceID, ids.ParentSpanID = traceID, parentID
+	} else {
+		ids.TraceID = randomHex(16)
+	}
+	return ids
+}
+
+// Middleware puts the request's IDs into its context, echoes the request ID
+// to the client and rewrites the headers of the request itself, so handlers
+// that forward it (httputil.ReverseProxy) pass them on.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids := FromRequest(r)
+		w.Header().Set(RequestIDHeader, ids.RequestID)
+
+		r = r.WithContext(NewContext(r.Context(), ids))
+		r.Header.Set(RequestIDHeader, ids.RequestID)
+		r.Header.Set(TraceparentHeader, ids.Traceparent())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Inject copies the IDs in the request's context onto its headers. Use it
+// on outbound requests built with the incoming request's context.
+func Inject(req *http.Request) {
+	if ids, ok := FromContext(req.Context()); ok {
+		req.Header.Set(RequestIDHeader, ids.RequestID)
+		req.Header.Set(TraceparentHeader, ids.Traceparent())
+	}
+}
+
+// LogAttrs returns the IDs as key-value pairs for log/slog, under the
+// names every service logs them with.
+func LogAttrs(ctx context.Context) []any {
+	ids, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	attrs := []any{"request_id", ids.RequestID, "trace_id", ids.TraceID, "span_id", ids.SpanID}
+	if ids.ParentSpanID != "" {
+		attrs = append(attrs, "parent_span_id", ids.ParentSpanID)
+	}
+	return attrs
+}
+
+// validRequestID accepts visible ASCII without spaces, so a client can't
+// forge log lines or headers with its request ID.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' || id[i] == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent reads a version 00 traceparent header. All-zero IDs are
+// invalid, as are unknown versions of the same length.
+func parseTraceparent(header string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", false
+	}
+	traceID, parentID = parts[1], parts[2]
+	if !lowerHex(traceID, 32) || !lowerHex(parentID, 16) || !lowerHex(parts[3], 2) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, parentID, true
+}
+
+func lowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(numBytes int) string {
+	buf := make([]byte, numBytes)
+	if _, err := rand.Read(buf); err != nil {
+		panic("correlation: no randomness: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
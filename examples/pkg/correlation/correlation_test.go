@@ -0,0 +1,91 @@
+package correlation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const callerTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "client-req-1")
+	req.Header.Set(TraceparentHeader, callerTraceparent)
+
+	ids := FromRequest(req)
+	assert.Equal(t, "client-req-1", ids.RequestID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", ids.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", ids.ParentSpanID)
+	assert.Len(t, ids.SpanID, 16)
+	assert.NotEqual(t, ids.ParentSpanID, ids.SpanID)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+ids.SpanID+"-01", ids.Traceparent())
+}
+
+func TestFromRequestStartsNewIDs(t *testing.T) {
+	for name, header := range map[string]http.Header{
+		"missing": {},
+		"malformed": {
+			RequestIDHeader:   {"has spaces\r\nX-Injected: 1"},
+			TraceparentHeader: {"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		},
+		"too long": {RequestIDHeader: {strings.Repeat("a", maxRequestIDLength+1)}},
+		"zero trace": {
+			TraceparentHeader: {"00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		},
+		"unknown version": {TraceparentHeader: {"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, values := range header {
+				req.Header[key] = values
+			}
+			ids := FromRequest(req)
+			assert.Len(t, ids.RequestID, 32)
+			assert.Len(t, ids.TraceID, 32)
+			assert.Empty(t, ids.ParentSpanID)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen IDs
+	var forwarded http.Header
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = FromContext(r.Context())
+		forwarded = r.Header.Clone()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeader, callerTraceparent)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	require.NotEmpty(t, seen.RequestID)
+	assert.Equal(t, seen.RequestID, w.Header().Get(RequestIDHeader))
+	// The next hop continues this service's span
+	assert.Equal(t, seen.RequestID, forwarded.Get(RequestIDHeader))
+	assert.Equal(t, seen.Traceparent(), forwarded.Get(TraceparentHeader))
+	assert.Equal(t, "00f067aa0ba902b7", seen.ParentSpanID)
+}
+
+func TestInject(t *testing.T) {
+	ids := IDs{RequestID: "req-1", TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "b7ad6b7169203331"}
+	req := httptest.NewRequest(http.MethodPost, "http://hooks.example.com/", nil)
+	req = req.WithContext(NewContext(req.Context(), ids))
+
+	Inject(req)
+	assert.Equal(t, "req-1", req.Header.Get(RequestIDHeader))
+	assert.Equal(t, ids.Traceparent(), req.Header.Get(TraceparentHeader))
+	assert.Equal(t, []any{"request_id", "req-1", "trace_id", ids.TraceID, "span_id", ids.SpanID}, LogAttrs(req.Context()))
+
+	// Outside of a request there is nothing to inject
+	outside := httptest.NewRequest(http.MethodPost, "http://hooks.example.com/", nil)
+	Inject(outside)
+	assert.Empty(t, outside.Header.Get(RequestIDHeader))
+	assert.Nil(t, LogAttrs(outside.Context()))
+}
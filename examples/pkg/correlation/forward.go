@@ -0,0 +1,95 @@
+package correlation
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	TracestateHeader = "tracestate"
+	BaggageHeader    = "baggage"
+
+	// maxForwardedLength bounds each forwarded header, so a client can't
+	// make every outbound call carry a huge one. W3C Baggage allows 8192
+	// bytes.
+	maxForwardedLength = 8192
+)
+
+// DefaultForwardHeaders are the headers worth passing on to every service a
+// request calls: the trace context, its baggage and the request ID.
+var DefaultForwardHeaders = []string{TraceparentHeader, TracestateHeader, BaggageHeader, RequestIDHeader}
+
+type forwardedKey struct{}
+
+// WithForwarded returns ctx carrying headers for Transport to send. Use it
+// for work that outlives the request, such as a queued job, with the
+// headers Forwarded returned while serving it.
+func WithForwarded(ctx context.Context, headers http.Header) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, forwardedKey{}, headers)
+}
+
+// Forwarded returns the headers Passthrough kept from the request ctx
+// belongs to, or nil outside of one.
+func Forwarded(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(forwardedKey{}).(http.Header)
+	return headers.Clone()
+}
+
+// Passthrough keeps the headers of each request named in allowlist, such as
+// DefaultForwardHeaders, for Transport to send on the calls made while
+// serving it. Only the allowlist is passed on: Authorization, cookies and
+// anything else a client sends stay in this service. Wrap it in Middleware,
+// so the traceparent passed on names this service's span rather than the
+// caller's and the request ID is one Middleware accepted.
+func Passthrough(allowlist []string) func(http.Handler) http.Handler {
+	names := make([]string, 0, len(allowlist))
+	for _, name := range allowlist {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers := http.Header{}
+			for _, name := range names {
+				for _, value := range r.Header.Values(name) {
+					if len(value) <= maxForwardedLength {
+						headers.Add(name, value)
+					}
+				}
+			}
+			if len(headers) > 0 {
+				r = r.WithContext(WithForwarded(r.Context(), headers))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Transport sets the headers forwarded in a request's context on it, unless
+// the request sets them itself. A nil Base is http.DefaultTransport.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	headers, _ := req.Context().Value(forwardedKey{}).(http.Header)
+	cloned := false
+	for name, values := range headers {
+		if _, ok := req.Header[name]; ok {
+			continue
+		}
+		// A RoundTripper must not modify the request it was given
+		if !cloned {
+			req = req.Clone(req.Context())
+			cloned = true
+		}
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return base.RoundTrip(req)
+}
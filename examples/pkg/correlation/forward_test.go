@@ -0,0 +1,87 @@
+package correlation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassthrough(t *testing.T) {
+	var outbound http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outbound = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	var seen IDs
+	handler := Middleware(Passthrough(DefaultForwardHeaders)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = FromContext(r.Context())
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstream.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set(BaggageHeader, "set=by-the-call")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeader, callerTraceparent)
+	req.Header.Set(TracestateHeader, "vendor=abc")
+	req.Header.Add(BaggageHeader, "tenant=acme")
+	req.Header.Set(RequestIDHeader, "client-req-1")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Custom", "kept here")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The next service continues this service's span, not the caller's
+	assert.Equal(t, seen.Traceparent(), outbound.Get(TraceparentHeader))
+	assert.Equal(t, "vendor=abc", outbound.Get(TracestateHeader))
+	assert.Equal(t, "client-req-1", outbound.Get(RequestIDHeader))
+	assert.Equal(t, []string{"set=by-the-call"}, outbound.Values(BaggageHeader), "the call's own headers win")
+	assert.Empty(t, outbound.Get("Authorization"))
+	assert.Empty(t, outbound.Get("X-Custom"))
+}
+
+func TestPassthroughLimits(t *testing.T) {
+	var forwarded http.Header
+	handler := Passthrough([]string{"x-tenant", BaggageHeader})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = Forwarded(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set(BaggageHeader, strings.Repeat("a", maxForwardedLength+1))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, http.Header{"X-Tenant": {"acme"}}, forwarded)
+
+	// Work outliving the request takes the headers along
+	ctx := WithForwarded(context.Background(), forwarded)
+	assert.Equal(t, "acme", Forwarded(ctx).Get("X-Tenant"))
+	assert.Nil(t, Forwarded(context.Background()))
+}
+
+func TestTransportLeavesRequestAlone(t *testing.T) {
+	var sent http.Header
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	ctx := WithForwarded(context.Background(), http.Header{"X-Request-Id": {"req-1"}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://hooks.example.com/", nil)
+	require.NoError(t, err)
+
+	_, err = (&Transport{Base: base}).RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", sent.Get(RequestIDHeader))
+	assert.Empty(t, req.Header.Get(RequestIDHeader))
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
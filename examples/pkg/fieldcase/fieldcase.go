@@ -0,0 +1,232 @@
+// Package fieldcase serves one set of structs under either JSON naming
+// convention. lesson-05 tags its fields in snake_case and lesson-08 in
+// camelCase; rather than duplicating every struct, the API keeps its native
+// tags and a middleware renames object keys on the way in and out:
+//
+//	api.Use(fieldcase.Middleware(fieldcase.Camel, fieldcase.Snake))
+//
+// Clients pick a convention per request with the X-Field-Case header
+// ("camel" or "snake"); without it they get the configured default. Every
+// object key is renamed, including keys of maps that hold data rather than
+// fields, so APIs returning user-chosen keys should exclude those routes.
+//
+// Downloads (Content-Disposition: attachment) are streamed unchanged rather
+// than held back; handlers that stream JSON convert it themselves with
+// Transform, using the case the middleware put in the Header response header.
+package fieldcase
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Header selects the naming convention of a request and its response.
+const Header = "X-Field-Case"
+
+// Case is a JSON field naming convention.
+type Case string
+
+const (
+	Camel Case = "camel"
+	Snake Case = "snake"
+)
+
+// Parse accepts "camel" or "snake", in any letter case.
+func Parse(value string) (Case, error) {
+	switch c := Case(strings.ToLower(strings.TrimSpace(value))); c {
+	case Camel, Snake:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown field case %q, want %q or %q", value, Camel, Snake)
+}
+
+// Key renames a single field: "totalCount" <-> "total_count".
+func (c Case) Key(name string) string {
+	if c == Snake {
+		return toSnake(name)
+	}
+	return toCamel(name)
+}
+
+func toSnake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a word after a lower-case letter or digit, or at the
+			// last capital of an acronym ("requestID", "HTTPStatus")
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) && runes[i-1] != '_' ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func toCamel(name string) string {
+	if !strings.Contains(strings.TrimLeft(name, "_"), "_") {
+		return name
+	}
+	parts := strings.Split(name, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+// Transform renames every object key in body to c, at any depth. Values,
+// including numbers, are kept exactly.
+func Transform(body []byte, c Case) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(rename(value, c)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func rename(value interface{}, c Case) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			renamed[c.Key(key)] = rename(field, c)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range v {
+			v[i] = rename(item, c)
+		}
+		return v
+	}
+	return value
+}
+
+// Negotiate returns the case the request asks for in the X-Field-Case
+// header, or fallback when it asks for none or an unknown one.
+func Negotiate(r *http.Request, fallback Case) Case {
+	if c, err := Parse(r.Header.Get(Header)); err == nil {
+		return c
+	}
+	return fallback
+}
+
+// Middleware converts JSON request bodies to the native case the handlers'
+// structs are tagged in, and JSON responses to the case the client asked
+// for, defaulting to fallback.
+func Middleware(native, fallback Case) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := Negotiate(r, fallback)
+			w.Header().Add("Vary", Header)
+			w.Header().Set(Header, string(c))
+
+			if c != native && isJSON(r.Header.Get("Content-Type")) && r.Body != nil {
+				body, err := io.ReadAll(r.Body)
+				r.Body.Close()
+				if err == nil {
+					if converted, err := Transform(body, native); err == nil {
+						body = converted
+					}
+				}
+				// Bodies that don't parse reach the handler unchanged, so
+				// it reports the syntax error as usual
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+			}
+
+			if c == native {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &caseWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			cw.finish(c)
+		})
+	}
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json")
+}
+
+func isAttachment(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Disposition"), "attachment")
+}
+
+// caseWriter holds back JSON responses until the handler is done so their
+// keys can be renamed; everything else passes straight through.
+type caseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	buffering   bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (cw *caseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	if code != http.StatusNoContent && code != http.StatusNotModified &&
+		isJSON(cw.Header().Get("Content-Type")) && !isAttachment(cw.Header()) {
+		cw.buffering = true
+		cw.status = code
+		return
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *caseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		return cw.body.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *caseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *caseWriter) finish(c Case) {
+	if !cw.buffering {
+		return
+	}
+
+	body := cw.body.Bytes()
+	if converted, err := Transform(body, c); err == nil {
+		body = converted
+	}
+	cw.Header().Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.ResponseWriter.Write(body)
+}
@@ -0,0 +1,117 @@
+package fieldcase
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	for camel, snake := range map[string]string{
+		"id":         "id",
+		"totalCount": "total_count",
+		"dueDate":    "due_date",
+		"requestID":  "request_id",
+		"httpStatus": "http_status",
+		"userID2":    "user_id2",
+	} {
+		assert.Equal(t, snake, Snake.Key(camel), camel)
+	}
+	for snake, camel := range map[string]string{
+		"created_at":  "createdAt",
+		"request_id":  "requestId",
+		"totalCount":  "totalCount",
+		"_links":      "_links",
+		"next_cursor": "nextCursor",
+	} {
+		assert.Equal(t, camel, Camel.Key(snake), snake)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	body := `{"totalCount":12345678901234567890,"tasks":[{"dueDate":null,"title":"<b>"}],"meta":{"requestId":"r1"}}`
+
+	snake, err := Transform([]byte(body), Snake)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"total_count":12345678901234567890,"tasks":[{"due_date":null,"title":"<b>"}],"meta":{"request_id":"r1"}}`, string(snake))
+	assert.Contains(t, string(snake), "12345678901234567890", "numbers are kept exactly")
+
+	camel, err := Transform(snake, Camel)
+	require.NoError(t, err)
+	assert.JSONEq(t, body, string(camel))
+
+	_, err = Transform([]byte("not json"), Snake)
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(Camel, Camel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			DueDate string `json:"dueDate"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"dueDate": req.DueDate})
+	}))
+
+	serve := func(fieldCase, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if fieldCase != "" {
+			req.Header.Set(Header, fieldCase)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("snake", `{"due_date":"tomorrow"}`)
+	assert.JSONEq(t, `{"due_date":"tomorrow"}`, w.Body.String())
+	assert.Equal(t, "snake", w.Header().Get(Header))
+	assert.Equal(t, Header, w.Header().Get("Vary"))
+
+	w = serve("", `{"dueDate":"today"}`)
+	assert.JSONEq(t, `{"dueDate":"today"}`, w.Body.String())
+	assert.Equal(t, "camel", w.Header().Get(Header))
+
+	// Unknown values fall back to the default
+	w = serve("kebab", `{"dueDate":"today"}`)
+	assert.JSONEq(t, `{"dueDate":"today"}`, w.Body.String())
+}
+
+func TestMiddlewarePassesThroughNonJSON(t *testing.T) {
+	handler := Middleware(Snake, Camel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("raw_text"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "raw_text", w.Body.String())
+}
+
+func TestMiddlewareStreamsAttachments(t *testing.T) {
+	handler := Middleware(Camel, Camel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="tasks.json"`)
+		w.Write([]byte(`[{"dueDate":`))
+		assert.NoError(t, http.NewResponseController(w).Flush())
+		w.Write([]byte(`null}]`))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(Header, "snake")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, `[{"dueDate":null}]`, w.Body.String(), "the handler converts attachments itself")
+}
@@ -0,0 +1,236 @@
+// Package httpcond evaluates conditional requests as specified in RFC 9110
+// section 13: entity tags with strong and weak comparison, If-Match,
+// If-None-Match, If-Modified-Since and If-Unmodified-Since.
+//
+// Handlers describe the current representation with Validators and call
+// Evaluate (or Check) after the checks that would answer 4xx anyway, such as
+// authentication and "not found", and before doing any work.
+package httpcond
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag is an entity tag. Opaque is the tag without quotes or the W/ prefix.
+// The zero value means "no entity tag".
+type ETag struct {
+	Opaque string
+	Weak   bool
+}
+
+// Strong returns a strong entity tag: it changes whenever the bytes of the
+// representation change.
+func Strong(opaque string) ETag {
+	return ETag{Opaque: opaque}
+}
+
+// Weak returns a weak entity tag: representations sharing it are
+// semantically equivalent but not necessarily byte-identical.
+func Weak(opaque string) ETag {
+	return ETag{Opaque: opaque, Weak: true}
+}
+
+// ForContent returns a strong entity tag derived from the representation's
+// bytes. Unlike sums of timestamps or lengths it depends on every byte and
+// their order, so different representations can't share a tag by accident.
+func ForContent(content []byte) ETag {
+	sum := sha256.Sum256(content)
+	return Strong(base64.RawURLEncoding.EncodeToString(sum[:18]))
+}
+
+func (e ETag) IsZero() bool {
+	return e == ETag{}
+}
+
+// String formats the tag as a header value, e.g. "abc" or W/"abc".
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Opaque + `"`
+	}
+	return `"` + e.Opaque + `"`
+}
+
+// ParseETag parses a single entity tag such as "abc" or W/"abc".
+func ParseETag(value string) (ETag, error) {
+	tag, rest, ok := scanETag(strings.TrimSpace(value))
+	if !ok || rest != "" {
+		return ETag{}, fmt.Errorf("httpcond: invalid entity tag %q", value)
+	}
+	return tag, nil
+}
+
+// StrongMatch reports whether both tags are strong and identical; If-Match
+// uses it.
+func StrongMatch(a, b ETag) bool {
+	return !a.Weak && !b.Weak && a.Opaque == b.Opaque
+}
+
+// WeakMatch reports whether the tags are identical ignoring weakness;
+// If-None-Match uses it.
+func WeakMatch(a, b ETag) bool {
+	return a.Opaque == b.Opaque
+}
+
+// scanETag reads one entity tag from the start of s and returns the rest.
+func scanETag(s string) (ETag, string, bool) {
+	var tag ETag
+	if strings.HasPrefix(s, "W/") {
+		tag.Weak = true
+		s = s[2:]
+	}
+	if len(s) < 2 || s[0] != '"' {
+		return ETag{}, s, false
+	}
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return ETag{}, s, false
+	}
+	tag.Opaque = s[1 : end+1]
+	// etagc = %x21 / %x23-7E / obs-text
+	for i := 0; i < len(tag.Opaque); i++ {
+		if c := tag.Opaque[i]; c < 0x21 || c == 0x7f {
+			return ETag{}, s, false
+		}
+	}
+	return tag, s[end+2:], true
+}
+
+// parseList parses an If-Match or If-None-Match value. wildcard reports "*".
+// Malformed members are skipped: they can never match.
+func parseList(value string) (tags []ETag, wildcard bool) {
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags, false
+		}
+		if value[0] == '*' {
+			return nil, true
+		}
+
+		tag, rest, ok := scanETag(value)
+		if !ok {
+			next := strings.IndexByte(value, ',')
+			if next < 0 {
+				return tags, false
+			}
+			value = value[next+1:]
+			continue
+		}
+		tags = append(tags, tag)
+		value = rest
+	}
+}
+
+// Validators describe the current representation of the target resource.
+// Zero fields are not sent and not compared.
+type Validators struct {
+	ETag         ETag
+	LastModified time.Time
+}
+
+// SetHeaders sets the ETag and Last-Modified response headers.
+func (v Validators) SetHeaders(h http.Header) {
+	if !v.ETag.IsZero() {
+		h.Set("ETag", v.ETag.String())
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Result is the outcome of evaluating a request's preconditions.
+type Result int
+
+const (
+	// Proceed means the request method should be performed.
+	Proceed Result = iota
+	// NotModified means a GET or HEAD should be answered with 304.
+	NotModified
+	// PreconditionFailed means the request should be answered with 412.
+	PreconditionFailed
+)
+
+// Evaluate applies the request's preconditions to the current representation
+// in the order given by RFC 9110 section 13.2.2. The resource is assumed to
+// exist, so "*" always matches.
+func Evaluate(r *http.Request, v Validators) Result {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	lastModified := v.LastModified.Truncate(time.Second)
+
+	if ifMatch := headerList(r, "If-Match"); ifMatch != "" {
+		if !matches(ifMatch, v.ETag, StrongMatch) {
+			return PreconditionFailed
+		}
+	} else if since, ok := parseDate(r, "If-Unmodified-Since"); ok && !v.LastModified.IsZero() {
+		if lastModified.After(since) {
+			return PreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := headerList(r, "If-None-Match"); ifNoneMatch != "" {
+		if matches(ifNoneMatch, v.ETag, WeakMatch) {
+			if safe {
+				return NotModified
+			}
+			return PreconditionFailed
+		}
+	} else if since, ok := parseDate(r, "If-Modified-Since"); ok && safe && !v.LastModified.IsZero() {
+		if !lastModified.After(since) {
+			return NotModified
+		}
+	}
+
+	return Proceed
+}
+
+// Check sets the validator headers and evaluates the preconditions. When it
+// returns false it has answered with a bare 304 or 412 and the handler must
+// stop; handlers with their own error format can use Evaluate instead.
+func Check(w http.ResponseWriter, r *http.Request, v Validators) bool {
+	v.SetHeaders(w.Header())
+	switch Evaluate(r, v) {
+	case NotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	case PreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func matches(value string, current ETag, match func(a, b ETag) bool) bool {
+	tags, wildcard := parseList(value)
+	if wildcard {
+		return true
+	}
+	if current.IsZero() {
+		return false
+	}
+	for _, tag := range tags {
+		if match(tag, current) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerList joins repeated header lines into one list
+func headerList(r *http.Request, name string) string {
+	return strings.Join(r.Header.Values(name), ",")
+}
+
+// parseDate parses an HTTP-date header; invalid dates are ignored
+func parseDate(r *http.Request, name string) (time.Time, bool) {
+	value := r.Header.Get(name)
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
@@ -0,0 +1,130 @@
+package httpcond
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseETag(t *testing.T) {
+	tag, err := ParseETag(`"abc"`)
+	require.NoError(t, err)
+	assert.Equal(t, Strong("abc"), tag)
+
+	tag, err = ParseETag(` W/"abc" `)
+	require.NoError(t, err)
+	assert.Equal(t, Weak("abc"), tag)
+	assert.Equal(t, `W/"abc"`, tag.String())
+
+	for _, value := range []string{`abc`, `"abc`, `w/"abc"`, `"a b"`, `"abc" "def"`} {
+		_, err := ParseETag(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestComparison(t *testing.T) {
+	// RFC 9110 section 8.8.3.2
+	cases := []struct {
+		a, b         ETag
+		strong, weak bool
+	}{
+		{Weak("1"), Weak("1"), false, true},
+		{Weak("1"), Weak("2"), false, false},
+		{Weak("1"), Strong("1"), false, true},
+		{Strong("1"), Strong("1"), true, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.strong, StrongMatch(c.a, c.b), "%s %s", c.a, c.b)
+		assert.Equal(t, c.weak, WeakMatch(c.a, c.b), "%s %s", c.a, c.b)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	tags, wildcard := parseList(`"a", W/"b",,"c,d" , bogus, "e"`)
+	assert.False(t, wildcard)
+	assert.Equal(t, []ETag{Strong("a"), Weak("b"), Strong("c,d"), Strong("e")}, tags)
+
+	_, wildcard = parseList(" * ")
+	assert.True(t, wildcard)
+}
+
+func TestForContent(t *testing.T) {
+	a := ForContent([]byte(`[{"id":1},{"id":2}]`))
+	b := ForContent([]byte(`[{"id":2},{"id":1}]`))
+	assert.False(t, a.Weak)
+	assert.NotEqual(t, a, b, "order matters")
+	assert.Equal(t, a, ForContent([]byte(`[{"id":1},{"id":2}]`)))
+}
+
+func TestEvaluate(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	current := Validators{ETag: Strong("v2"), LastModified: modified}
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	at := modified.Format(http.TimeFormat)
+
+	cases := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    Result
+	}{
+		{"no conditions", http.MethodGet, nil, Proceed},
+		{"if-none-match hit", http.MethodGet, map[string]string{"If-None-Match": `"v1", "v2"`}, NotModified},
+		{"if-none-match weak hit", http.MethodHead, map[string]string{"If-None-Match": `W/"v2"`}, NotModified},
+		{"if-none-match miss", http.MethodGet, map[string]string{"If-None-Match": `"v1"`}, Proceed},
+		{"if-none-match on write", http.MethodPut, map[string]string{"If-None-Match": `*`}, PreconditionFailed},
+		{"if-match hit", http.MethodPut, map[string]string{"If-Match": `"v2"`}, Proceed},
+		{"if-match weak", http.MethodPut, map[string]string{"If-Match": `W/"v2"`}, PreconditionFailed},
+		{"if-match stale", http.MethodDelete, map[string]string{"If-Match": `"v1"`}, PreconditionFailed},
+		{"if-match any", http.MethodPatch, map[string]string{"If-Match": `*`}, Proceed},
+		{"if-modified-since unchanged", http.MethodGet, map[string]string{"If-Modified-Since": at}, NotModified},
+		{"if-modified-since changed", http.MethodGet, map[string]string{"If-Modified-Since": before}, Proceed},
+		{"if-modified-since invalid", http.MethodGet, map[string]string{"If-Modified-Since": "yesterday"}, Proceed},
+		{"if-modified-since on write", http.MethodPut, map[string]string{"If-Modified-Since": at}, Proceed},
+		{"if-none-match wins over date", http.MethodGet, map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": at}, Proceed},
+		{"if-unmodified-since stale", http.MethodPut, map[string]string{"If-Unmodified-Since": before}, PreconditionFailed},
+		{"if-unmodified-since ok", http.MethodPut, map[string]string{"If-Unmodified-Since": at}, Proceed},
+		{"if-match wins over date", http.MethodPut, map[string]string{"If-Match": `"v2"`, "If-Unmodified-Since": before}, Proceed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, "/", nil)
+			for name, value := range c.headers {
+				req.Header.Set(name, value)
+			}
+			assert.Equal(t, c.want, Evaluate(req, current))
+		})
+	}
+}
+
+func TestEvaluateWithoutETag(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	req.Header.Set("If-Match", `"v1"`)
+	assert.Equal(t, PreconditionFailed, Evaluate(req, Validators{}))
+}
+
+func TestCheck(t *testing.T) {
+	current := Validators{ETag: Strong("v2"), LastModified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"v2"`)
+	w := httptest.NewRecorder()
+	assert.False(t, Check(w, req, current))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"v2"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", w.Header().Get("Last-Modified"))
+
+	req = httptest.NewRequest(http.MethodPut, "/", nil)
+	req.Header.Set("If-Match", `"v1"`)
+	w = httptest.NewRecorder()
+	assert.False(t, Check(w, req, current))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = httptest.NewRecorder()
+	assert.True(t, Check(w, httptest.NewRequest(http.MethodGet, "/", nil), current))
+}
@@ -0,0 +1,138 @@
+// Package logging sets up log/slog the way the examples log: JSON lines on
+// stdout at a configured level, where every line logged with a request's
+// context carries that request's fields. Those are its request ID and
+// trace (see correlation) and whatever the handlers add along the way,
+// such as the user once authenticated:
+//
+//	slog.SetDefault(logging.New(os.Stdout, level))
+//	handler = correlation.Middleware(logging.Middleware(slog.Default())(handler))
+//	...
+//	logging.AddFields(ctx, slog.String("user_id", userID))
+//	slog.ErrorContext(ctx, "failed to store avatar", "error", err)
+//
+// Middleware writes one access log line per request with its method, path,
+// status and latency.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"correlation"
+)
+
+// ParseLevel reads a level name: debug, info, warn or error.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q: want debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// New returns a logger writing JSON lines at level and above to w.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(&contextHandler{Handler: slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// contextHandler adds the fields of the request in the context to every
+// record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		record.AddAttrs(f.list()...)
+	}
+	record.Add(correlation.LogAttrs(ctx)...)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+type fieldsKey struct{}
+
+// fields are shared by every context derived from the request's, so fields
+// added deep in the handler chain still reach the access log line written
+// on the way out.
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (f *fields) list() []slog.Attr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+// AddFields adds attrs to every later line logged with the request's
+// context, and to its access log line. Outside of Middleware it does
+// nothing.
+func AddFields(ctx context.Context, attrs ...slog.Attr) {
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		f.mu.Lock()
+		f.attrs = append(f.attrs, attrs...)
+		f.mu.Unlock()
+	}
+}
+
+// Middleware logs a line per request to logger once it has been served.
+// Wrap it in correlation.Middleware, so the line has the request's IDs.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx := context.WithValue(r.Context(), fieldsKey{}, &fields{})
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			logger.LogAttrs(ctx, slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000))
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
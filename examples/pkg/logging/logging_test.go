@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"correlation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields), line)
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		level, err := ParseLevel(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, level, name)
+	}
+
+	_, err := ParseLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestNewFiltersLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelWarn)
+	logger.Info("hidden")
+	logger.Warn("shown", "key", "value")
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 1)
+	assert.Equal(t, "shown", logged[0]["msg"])
+	assert.Equal(t, "WARN", logged[0]["level"])
+	assert.Equal(t, "value", logged[0]["key"])
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Added deep in the chain, on a context of its own
+		ctx := context.WithValue(r.Context(), struct{}{}, "auth")
+		AddFields(ctx, slog.String("user_id", "user-1"))
+		logger.ErrorContext(r.Context(), "failed to store avatar")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks?limit=5", nil)
+	req.Header.Set(correlation.RequestIDHeader, "req-1")
+	correlation.Middleware(Middleware(logger)(handler)).ServeHTTP(httptest.NewRecorder(), req)
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "failed to store avatar", logged[0]["msg"])
+	assert.Equal(t, "user-1", logged[0]["user_id"])
+	assert.Equal(t, "req-1", logged[0]["request_id"])
+
+	access := logged[1]
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, "POST", access["method"])
+	assert.Equal(t, "/api/tasks", access["path"])
+	assert.Equal(t, float64(http.StatusTeapot), access["status"])
+	assert.Contains(t, access, "latency_ms")
+	assert.Equal(t, "user-1", access["user_id"])
+	assert.Equal(t, "req-1", access["request_id"])
+	assert.NotEmpty(t, access["trace_id"])
+}
+
+func TestMiddlewareDefaultsToOK(t *testing.T) {
+	var buf bytes.Buffer
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	Middleware(New(&buf, slog.LevelInfo))(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 1)
+	assert.Equal(t, float64(http.StatusOK), logged[0]["status"])
+	assert.NotContains(t, logged[0], "request_id")
+}
+
+func TestAddFieldsOutsideMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := context.Background()
+	AddFields(ctx, slog.String("user_id", "user-1"))
+	New(&buf, slog.LevelInfo).InfoContext(ctx, "started")
+	assert.NotContains(t, lines(t, &buf)[0], "user_id")
+}
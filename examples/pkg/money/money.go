@@ -0,0 +1,289 @@
+// Package money models amounts of money as integer minor units (cents)
+// with their currency. float64 can't represent 0.10 exactly, so prices
+// stored as floats drift: 0.1+0.2 != 0.3, and sums of line items end up a
+// cent off after rounding. Money never rounds implicitly; arithmetic is
+// exact, mixing currencies is an error, and the only rounding step is an
+// explicit Convert.
+//
+// Amounts are exchanged as decimal strings so no client parses them as
+// floats either:
+//
+//	{"amount": "999.99", "currency": "USD"}
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrInvalidAmount       = errors.New("invalid amount")
+	ErrCurrencyMismatch    = errors.New("currency mismatch")
+	ErrOverflow            = errors.New("amount out of range")
+)
+
+// decimals are the minor units of each supported ISO 4217 currency.
+var decimals = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"JPY": 0,
+}
+
+// Supported reports whether currency is a supported ISO 4217 code.
+func Supported(currency string) bool {
+	_, ok := decimals[currency]
+	return ok
+}
+
+// Currencies lists the supported currency codes in order.
+func Currencies() []string {
+	currencies := make([]string, 0, len(decimals))
+	for currency := range decimals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// Money is an amount in a currency's minor units. The zero Money has no
+// currency and acts as zero in Add and Sub, so totals can start from it.
+type Money struct {
+	minor    int64
+	currency string
+}
+
+// New returns minor units of currency, e.g. New(999, "USD") is 9.99 USD.
+func New(minor int64, currency string) (Money, error) {
+	if !Supported(currency) {
+		return Money{}, fmt.Errorf("%w %q", ErrUnsupportedCurrency, currency)
+	}
+	return Money{minor: minor, currency: currency}, nil
+}
+
+// Parse reads a decimal amount such as "999.99" or "-5" in currency. It
+// rejects more decimal places than the currency has rather than rounding.
+func Parse(amount, currency string) (Money, error) {
+	places, ok := decimals[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnsupportedCurrency, currency)
+	}
+
+	digits, negative := strings.CutPrefix(amount, "-")
+	whole, fraction, hasPoint := strings.Cut(digits, ".")
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) || hasPoint && fraction == "" {
+		return Money{}, fmt.Errorf("%w %q: want a decimal like 12.34", ErrInvalidAmount, amount)
+	}
+	if len(fraction) > places {
+		return Money{}, fmt.Errorf("%w %q: %s has %d decimal places", ErrInvalidAmount, amount, currency, places)
+	}
+
+	minor, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", places-len(fraction)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w %q", ErrOverflow, amount)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{minor: minor, currency: currency}, nil
+}
+
+// MustParse is Parse for amounts known to be valid, such as constants.
+func MustParse(amount, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Minor returns the amount in minor units.
+func (m Money) Minor() int64 { return m.minor }
+
+// Currency returns the ISO 4217 currency code.
+func (m Money) Currency() string { return m.currency }
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool { return m.minor == 0 }
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool { return m.minor > 0 }
+
+// Amount formats the amount as a decimal with the currency's minor units,
+// e.g. "999.99" or "150".
+func (m Money) Amount() string {
+	places := decimals[m.currency]
+	minor := m.minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(absUint(minor), 10)
+	if places == 0 {
+		return sign + digits
+	}
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+}
+
+func absUint(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
+
+func (m Money) String() string {
+	return m.Amount() + " " + m.currency
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if m.currency != other.currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, other.currency)
+	}
+	return nil
+}
+
+// Add returns m + other. Both must be in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	switch {
+	case m == (Money{}):
+		return other, nil
+	case other == (Money{}):
+		return m, nil
+	}
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	sum := m.minor + other.minor
+	if (sum > m.minor) != (other.minor > 0) {
+		return Money{}, ErrOverflow
+	}
+	return Money{minor: sum, currency: m.currency}, nil
+}
+
+// Sub returns m - other. Both must be in the same currency.
+func (m Money) Sub(other Money) (Money, error) {
+	if other.minor == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{minor: -other.minor, currency: other.currency})
+}
+
+// Mul returns m times n, e.g. a unit price times a quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	if n != 0 && (m.minor*n/n != m.minor || m.minor == math.MinInt64 && n == -1) {
+		return Money{}, ErrOverflow
+	}
+	return Money{minor: m.minor * n, currency: m.currency}, nil
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than
+// other. Both must be in the same currency.
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.minor < other.minor:
+		return -1, nil
+	case m.minor > other.minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Convert returns m in currency at rate, the price of one unit of m's
+// currency in currency. This is the one place Money rounds: to the target
+// currency's minor units, half away from zero.
+func (m Money) Convert(currency string, rate *big.Rat) (Money, error) {
+	places, ok := decimals[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnsupportedCurrency, currency)
+	}
+
+	// minor / 10^from * rate * 10^to
+	amount := new(big.Rat).SetFrac(big.NewInt(m.minor), pow10(decimals[m.currency]))
+	amount.Mul(amount, rate)
+	amount.Mul(amount, new(big.Rat).SetInt(pow10(places)))
+
+	quotient, remainder := new(big.Int).QuoRem(amount.Num(), amount.Denom(), new(big.Int))
+	if remainder.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(remainder, 1)).Cmp(amount.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(amount.Sign())))
+	}
+	if !quotient.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{minor: quotient.Int64(), currency: currency}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// ParseRate reads an exchange rate such as "0.92" exactly; a float64 rate
+// would bring back the rounding errors Money avoids.
+func ParseRate(rate string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", rate)
+	}
+	return r, nil
+}
+
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	amount, _ := json.Marshal(m.Amount())
+	return json.Marshal(moneyJSON{Amount: amount, Currency: m.currency})
+}
+
+// UnmarshalJSON accepts the amount as a decimal string or a JSON number;
+// numbers are read from their text, never through float64.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw moneyJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var amount string
+	if err := json.Unmarshal(raw.Amount, &amount); err != nil {
+		var number json.Number
+		decoder := json.NewDecoder(bytes.NewReader(raw.Amount))
+		decoder.UseNumber()
+		if decoder.Decode(&number) != nil || number == "" {
+			return fmt.Errorf("%w: amount must be a decimal string", ErrInvalidAmount)
+		}
+		amount = number.String()
+	}
+
+	parsed, err := Parse(amount, strings.ToUpper(raw.Currency))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,147 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for amount, minor := range map[string]int64{
+		"999.99": 99999,
+		"0.1":    10,
+		"5":      500,
+		"-12.30": -1230,
+		"0.00":   0,
+	} {
+		m, err := Parse(amount, "USD")
+		require.NoError(t, err, amount)
+		assert.Equal(t, minor, m.Minor(), amount)
+	}
+
+	m, err := Parse("1500", "JPY")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1500, m.Minor())
+
+	for _, amount := range []string{"", "1.", ".5", "1.999", "1e3", "+1", "12,50", "NaN", "99999999999999999999"} {
+		_, err := Parse(amount, "USD")
+		assert.Error(t, err, amount)
+	}
+	_, err = Parse("1.5", "JPY")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = Parse("1", "XXX")
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+}
+
+func TestAmount(t *testing.T) {
+	for _, tc := range []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{99999, "USD", "999.99"},
+		{5, "USD", "0.05"},
+		{-5, "EUR", "-0.05"},
+		{-1230, "USD", "-12.30"},
+		{1500, "JPY", "1500"},
+		{math.MinInt64, "USD", "-92233720368547758.08"},
+	} {
+		m, err := New(tc.minor, tc.currency)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, m.Amount())
+	}
+	assert.Equal(t, "9.99 USD", MustParse("9.99", "USD").String())
+}
+
+func TestArithmetic(t *testing.T) {
+	// The float64 version of this sum is 0.30000000000000004
+	sum, err := MustParse("0.10", "USD").Add(MustParse("0.20", "USD"))
+	require.NoError(t, err)
+	assert.Equal(t, MustParse("0.30", "USD"), sum)
+
+	total := Money{}
+	for _, line := range []struct {
+		price    string
+		quantity int64
+	}{{"29.99", 3}, {"0.01", 7}} {
+		subtotal, err := MustParse(line.price, "USD").Mul(line.quantity)
+		require.NoError(t, err)
+		total, err = total.Add(subtotal)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "90.04 USD", total.String())
+
+	diff, err := total.Sub(MustParse("100", "USD"))
+	require.NoError(t, err)
+	assert.Equal(t, "-9.96", diff.Amount())
+
+	_, err = total.Add(MustParse("1", "EUR"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+	_, err = total.Cmp(MustParse("1", "EUR"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	c, err := MustParse("9.99", "USD").Cmp(MustParse("10", "USD"))
+	require.NoError(t, err)
+	assert.Equal(t, -1, c)
+
+	huge, _ := New(math.MaxInt64, "USD")
+	_, err = huge.Add(MustParse("0.01", "USD"))
+	assert.ErrorIs(t, err, ErrOverflow)
+	_, err = huge.Mul(2)
+	assert.ErrorIs(t, err, ErrOverflow)
+	smallest, _ := New(math.MinInt64, "USD")
+	_, err = smallest.Mul(-1)
+	assert.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestConvert(t *testing.T) {
+	rate, err := ParseRate("0.92")
+	require.NoError(t, err)
+
+	// 999.99 * 0.92 = 919.9908
+	eur, err := MustParse("999.99", "USD").Convert("EUR", rate)
+	require.NoError(t, err)
+	assert.Equal(t, "919.99 EUR", eur.String())
+
+	// Half away from zero: 0.125 -> 0.13, -0.125 -> -0.13
+	half, _ := ParseRate("1.25")
+	up, _ := MustParse("0.10", "USD").Convert("EUR", half)
+	down, _ := MustParse("-0.10", "USD").Convert("EUR", half)
+	assert.Equal(t, "0.13", up.Amount())
+	assert.Equal(t, "-0.13", down.Amount())
+
+	yenRate, _ := ParseRate("151.3")
+	yen, err := MustParse("29.99", "USD").Convert("JPY", yenRate)
+	require.NoError(t, err)
+	assert.Equal(t, "4537 JPY", yen.String())
+
+	_, err = ParseRate("-1")
+	assert.Error(t, err)
+}
+
+func TestJSON(t *testing.T) {
+	body, err := json.Marshal(MustParse("949", "EUR"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"amount": "949.00", "currency": "EUR"}`, string(body))
+
+	var m Money
+	require.NoError(t, json.Unmarshal([]byte(`{"amount": "49.99", "currency": "usd"}`), &m))
+	assert.Equal(t, MustParse("49.99", "USD"), m)
+
+	// Numbers are read from their text, not through float64
+	require.NoError(t, json.Unmarshal([]byte(`{"amount": 0.3, "currency": "USD"}`), &m))
+	assert.EqualValues(t, 30, m.Minor())
+
+	for _, body := range []string{
+		`{"amount": "1.999", "currency": "USD"}`,
+		`{"amount": "1", "currency": ""}`,
+		`{"amount": true, "currency": "USD"}`,
+		`{"currency": "USD"}`,
+		`"9.99"`,
+	} {
+		assert.Error(t, json.Unmarshal([]byte(body), &m), body)
+	}
+}
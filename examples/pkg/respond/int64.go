@@ -0,0 +1,235 @@
+package respond
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Int64Param asks for 64-bit integers as JSON strings, as a query parameter
+// (?int64=string) or a parameter of a JSON media type in Accept:
+//
+//	Accept: application/vnd.task-api.v2+json; int64=string
+//
+// JavaScript reads every JSON number into a float64, which holds integers
+// exactly only up to 2^53; counters and IDs above that silently change.
+// Quoted, they reach the client intact and it parses them as it sees fit.
+const Int64Param = "int64"
+
+// WantsInt64Strings reports whether the request asks for 64-bit integers as
+// strings.
+func WantsInt64Strings(r *http.Request) bool {
+	if r.URL.Query().Get(Int64Param) == "string" {
+		return true
+	}
+	for _, value := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && formats[0].names(mediaType) && params[Int64Param] == "string" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Int64Middleware makes JSON, JSONWithFallback and Write quote 64-bit
+// integers in responses to requests that ask for it. Fields of the Go types
+// int, int64, uint and uint64 are quoted, whatever their value, so a field
+// has one type in every response; floats and values with their own
+// MarshalJSON, such as json.RawMessage, are written as they are. Writers
+// between the middleware and the handler must implement Unwrap, as
+// http.ResponseController expects them to.
+func Int64Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Whether integers are quoted depends on Accept
+		addVary(w.Header(), "Accept")
+		if WantsInt64Strings(r) {
+			w = &int64Writer{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// int64Writer marks a response whose 64-bit integers are quoted.
+type int64Writer struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (iw *int64Writer) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// quotesInt64s reports whether w, or a writer it wraps, is an int64Writer.
+func quotesInt64s(w http.ResponseWriter) bool {
+	for {
+		switch t := w.(type) {
+		case *int64Writer:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// marshalJSONInt64Strings is marshalJSON with the 64-bit integers of payload
+// quoted. The types of the payload tell them apart: in the JSON, 3 could
+// as well be a float64.
+func marshalJSONInt64Strings(payload interface{}) ([]byte, error) {
+	body, err := marshalJSON(payload)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	quoteInt64s(&v, reflect.ValueOf(payload), 0)
+
+	var buf bytes.Buffer
+	if err := v.appendJSON(&buf); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// quoteInt64s turns the numbers of v that rv holds in 64-bit integers into
+// strings, walking both alongside.
+func quoteInt64s(v *value, rv reflect.Value, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() || marshalsItself(rv.Type()) {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || marshalsItself(rv.Type()) {
+		return
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if v.kind == kindNumber && rv.Type().Size() == 8 {
+			v.kind = kindString
+		}
+	case reflect.Slice, reflect.Array:
+		if v.kind != kindArray || rv.Len() != len(v.items) {
+			return
+		}
+		for i := range v.items {
+			quoteInt64s(&v.items[i], rv.Index(i), depth+1)
+		}
+	case reflect.Map:
+		if v.kind != kindObject {
+			return
+		}
+		values := make(map[string]reflect.Value, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			if key, ok := mapKey(iter.Key()); ok {
+				values[key] = iter.Value()
+			}
+		}
+		for i := range v.fields {
+			if item, ok := values[v.fields[i].name]; ok {
+				quoteInt64s(&v.fields[i].value, item, depth+1)
+			}
+		}
+	case reflect.Struct:
+		if v.kind != kindObject {
+			return
+		}
+		values := make(map[string]reflect.Value)
+		structFields(rv, values, 0)
+		for i := range v.fields {
+			if item, ok := values[v.fields[i].name]; ok {
+				quoteInt64s(&v.fields[i].value, item, depth+1)
+			}
+		}
+	}
+}
+
+// marshalsItself reports whether encoding/json leaves t to its own
+// MarshalJSON or MarshalText, like time.Time and json.RawMessage.
+func marshalsItself(t reflect.Type) bool {
+	return t.Implements(jsonMarshaler) || t.Implements(textMarshaler) ||
+		reflect.PointerTo(t).Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(textMarshaler)
+}
+
+// mapKey is the object key encoding/json writes for a map key.
+func mapKey(key reflect.Value) (string, bool) {
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), true
+	}
+	return "", false
+}
+
+// structFields adds the fields of rv to values under the names encoding/json
+// writes them with. Fields of embedded structs are promoted unless a
+// shallower field has the same name.
+func structFields(rv reflect.Value, values map[string]reflect.Value, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	t := rv.Type()
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			inner := rv.Field(i)
+			if inner.Kind() == reflect.Pointer {
+				if inner.IsNil() {
+					continue
+				}
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct && !marshalsItself(inner.Type()) {
+				embedded = append(embedded, inner)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		values[name] = rv.Field(i)
+	}
+
+	for _, inner := range embedded {
+		promoted := make(map[string]reflect.Value)
+		structFields(inner, promoted, depth+1)
+		for name, field := range promoted {
+			if _, ok := values[name]; !ok {
+				values[name] = field
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package respond
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type counters struct {
+	Requests int64 `json:"requests"`
+}
+
+type int64Payload struct {
+	counters
+	ID        uint64           `json:"id"`
+	Count     int              `json:"count"`
+	Small     int32            `json:"small"`
+	Ratio     float64          `json:"ratio"`
+	Total     *int64           `json:"total,omitempty"`
+	Quoted    int64            `json:"quoted,string"`
+	Sizes     []int64          `json:"sizes"`
+	ByName    map[string]int64 `json:"byName"`
+	Extra     interface{}      `json:"extra"`
+	Raw       json.RawMessage  `json:"raw"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Skipped   int64            `json:"-"`
+}
+
+func TestWantsInt64Strings(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                               false,
+		"application/json":               false,
+		"application/json; int64=string": true,
+		"application/vnd.task-api.v2+json; int64=string": true,
+		"text/html, application/json;q=0.9;int64=string": true,
+		"application/json; int64=number":                 false,
+		"application/xml; int64=string":                  false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		assert.Equal(t, want, WantsInt64Strings(r), accept)
+	}
+
+	assert.True(t, WantsInt64Strings(httptest.NewRequest(http.MethodGet, "/tasks?int64=string", nil)))
+	assert.False(t, WantsInt64Strings(httptest.NewRequest(http.MethodGet, "/tasks?int64=number", nil)))
+}
+
+func TestInt64Middleware(t *testing.T) {
+	total := int64(1 << 60)
+	payload := int64Payload{
+		counters:  counters{Requests: 9007199254740993},
+		ID:        18446744073709551615,
+		Count:     3,
+		Small:     7,
+		Ratio:     2,
+		Total:     &total,
+		Quoted:    5,
+		Sizes:     []int64{1, 2},
+		ByName:    map[string]int64{"a": 1},
+		Extra:     map[string]interface{}{"n": int64(4), "f": 1.0},
+		Raw:       json.RawMessage(`{"n":8}`),
+		CreatedAt: time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC),
+		Skipped:   6,
+	}
+	handler := Int64Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusOK, payload, nil)
+	}))
+	serve := func(target, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/tasks?int64=string", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"requests": "9007199254740993",
+		"id": "18446744073709551615",
+		"count": "3",
+		"small": 7,
+		"ratio": 2,
+		"total": "1152921504606846976",
+		"quoted": "5",
+		"sizes": ["1", "2"],
+		"byName": {"a": "1"},
+		"extra": {"f": 1, "n": "4"},
+		"raw": {"n": 8},
+		"createdAt": "2026-10-16T00:00:00Z"
+	}`, w.Body.String())
+	assert.Contains(t, w.Header().Values("Vary"), "Accept")
+
+	w = serve("/tasks", "application/vnd.task-api.v2+json; int64=string")
+	assert.Contains(t, w.Body.String(), `"id":"18446744073709551615"`)
+
+	// Numbers stay numbers unless asked, and in formats that have integers
+	w = serve("/tasks", "")
+	assert.Contains(t, w.Body.String(), `"id":18446744073709551615`)
+	w = serve("/tasks?int64=string", MediaTypeMsgpack)
+	assert.Equal(t, MediaTypeMsgpack, w.Header().Get("Content-Type"))
+	var decoded map[string]interface{}
+	require.NoError(t, UnmarshalMsgpack(w.Body.Bytes(), &decoded))
+	assert.Equal(t, 3.0, decoded["count"])
+}
+
+// Writers in between are seen through, so the handler's writer needn't be
+// the middleware's.
+func TestInt64MiddlewareThroughWrappers(t *testing.T) {
+	handler := Int64Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		JSON(&unwrapper{ResponseWriter: w}, http.StatusOK, map[string]int64{"id": 1})
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?int64=string", nil))
+	assert.Equal(t, "{\"id\":\"1\"}\n", w.Body.String())
+}
+
+type unwrapper struct {
+	http.ResponseWriter
+}
+
+func (u *unwrapper) Unwrap() http.ResponseWriter { return u.ResponseWriter }
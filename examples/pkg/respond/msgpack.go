@@ -0,0 +1,324 @@
+package respond
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MessagePack (https://msgpack.org) documents mirror the JSON ones as well:
+// objects are maps with string keys, and numbers are integers when they have
+// no fraction and fit 64 bits, float64 otherwise. Decoding accepts every
+// type except extensions; binary data becomes a base64 string, as []byte
+// is in JSON.
+
+// MarshalMsgpack encodes the JSON representation of payload as MessagePack.
+func MarshalMsgpack(payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := v.appendMsgpack(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v value) appendMsgpack(buf *bytes.Buffer) error {
+	switch v.kind {
+	case kindNull:
+		buf.WriteByte(0xc0)
+	case kindBool:
+		if v.bool {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case kindNumber:
+		return appendMsgpackNumber(buf, v.text)
+	case kindString:
+		n := len(v.text)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+		default:
+			buf.WriteByte(0xdb)
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+		}
+		buf.WriteString(v.text)
+	case kindArray:
+		appendMsgpackLength(buf, len(v.items), 0x90, 0xdc)
+		for _, item := range v.items {
+			if err := item.appendMsgpack(buf); err != nil {
+				return err
+			}
+		}
+	case kindObject:
+		appendMsgpackLength(buf, len(v.fields), 0x80, 0xde)
+		for _, f := range v.fields {
+			if err := (value{kind: kindString, text: f.name}).appendMsgpack(buf); err != nil {
+				return err
+			}
+			if err := f.value.appendMsgpack(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendMsgpackLength writes the header of an array or map: fix is the
+// fixarray/fixmap prefix, wide the 16-bit form, wide+1 the 32-bit one.
+func appendMsgpackLength(buf *bytes.Buffer, n int, fix, wide byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(wide)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(wide + 1)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func appendMsgpackNumber(buf *bytes.Buffer, text string) error {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		switch {
+		case i >= 0 && i < 128:
+			buf.WriteByte(byte(i))
+		case i >= -32 && i < 0:
+			buf.WriteByte(byte(int8(i)))
+		case i >= math.MinInt8 && i <= math.MaxInt8:
+			buf.Write([]byte{0xd0, byte(int8(i))})
+		case i >= math.MinInt16 && i <= math.MaxInt16:
+			buf.WriteByte(0xd1)
+			buf.Write(binary.BigEndian.AppendUint16(nil, uint16(int16(i))))
+		case i >= math.MinInt32 && i <= math.MaxInt32:
+			buf.WriteByte(0xd2)
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(int32(i))))
+		default:
+			buf.WriteByte(0xd3)
+			buf.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+		}
+		return nil
+	}
+	if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+		buf.WriteByte(0xcf)
+		buf.Write(binary.BigEndian.AppendUint64(nil, u))
+		return nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", text)
+	}
+	buf.WriteByte(0xcb)
+	buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	return nil
+}
+
+// UnmarshalMsgpack decodes a MessagePack document into v like json.Unmarshal
+// would decode the same document in JSON.
+func UnmarshalMsgpack(data []byte, v interface{}) error {
+	d := msgpackDecoder{data: data}
+	doc, err := d.read(0)
+	if err != nil {
+		return err
+	}
+	if len(d.data) > 0 {
+		return errors.New("msgpack: unexpected data after value")
+	}
+	var buf bytes.Buffer
+	if err := doc.appendJSON(&buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+type msgpackDecoder struct {
+	data []byte
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) read(depth int) (value, error) {
+	if depth > maxDepth {
+		return value{}, fmt.Errorf("document nested deeper than %d levels", maxDepth)
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return value{}, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return number(strconv.Itoa(int(int8(c)))), nil
+	case c&0xf0 == 0x80:
+		return d.readMap(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.readArray(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.readString(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return value{kind: kindNull}, nil
+	case 0xc2, 0xc3:
+		return value{kind: kindBool, bool: c == 0xc3}, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return value{}, err
+		}
+		raw, err := d.next(int(n))
+		if err != nil {
+			return value{}, err
+		}
+		return value{kind: kindString, text: base64.StdEncoding.EncodeToString(raw)}, nil
+	case 0xca:
+		n, err := d.uint(4)
+		if err != nil {
+			return value{}, err
+		}
+		return float(float64(math.Float32frombits(uint32(n))))
+	case 0xcb:
+		n, err := d.uint(8)
+		if err != nil {
+			return value{}, err
+		}
+		return float(math.Float64frombits(n))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return value{}, err
+		}
+		return number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return value{}, err
+		}
+		// Sign-extend from the encoded width
+		shift := 64 - 8*size
+		return number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return value{}, err
+		}
+		return d.readString(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return value{}, err
+		}
+		return d.readArray(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return value{}, err
+		}
+		return d.readMap(int(n), depth)
+	}
+	return value{}, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+func (d *msgpackDecoder) readString(n int) (value, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return value{}, err
+	}
+	return value{kind: kindString, text: string(b)}, nil
+}
+
+func (d *msgpackDecoder) readArray(n int, depth int) (value, error) {
+	// Every item takes at least a byte, so a forged length fails here
+	// instead of allocating
+	if n > len(d.data) {
+		return value{}, errMsgpackTruncated
+	}
+	v := value{kind: kindArray, items: make([]value, 0, n)}
+	for i := 0; i < n; i++ {
+		item, err := d.read(depth + 1)
+		if err != nil {
+			return value{}, err
+		}
+		v.items = append(v.items, item)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) readMap(n int, depth int) (value, error) {
+	if n > len(d.data)/2 {
+		return value{}, errMsgpackTruncated
+	}
+	v := value{kind: kindObject, fields: make([]field, 0, n)}
+	for i := 0; i < n; i++ {
+		key, err := d.read(depth + 1)
+		if err != nil {
+			return value{}, err
+		}
+		if key.kind != kindString {
+			return value{}, errors.New("msgpack: map keys must be strings")
+		}
+		item, err := d.read(depth + 1)
+		if err != nil {
+			return value{}, err
+		}
+		v.fields = append(v.fields, field{name: key.text, value: item})
+	}
+	return v, nil
+}
+
+func number(text string) value {
+	return value{kind: kindNumber, text: text}
+}
+
+func float(f float64) (value, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return value{}, errors.New("msgpack: NaN and infinity have no JSON representation")
+	}
+	return number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
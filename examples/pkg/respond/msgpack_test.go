@@ -0,0 +1,67 @@
+package respond
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalMsgpack(t *testing.T) {
+	for name, tc := range map[string]struct {
+		payload interface{}
+		want    string
+	}{
+		"nil":         {nil, "c0"},
+		"bools":       {[]bool{true, false}, "92c3c2"},
+		"fixint":      {127, "7f"},
+		"negative":    {-33, "d0df"},
+		"int16":       {200, "d100c8"},
+		"int64":       {int64(math.MinInt64), "d38000000000000000"},
+		"uint64":      {uint64(math.MaxUint64), "cfffffffffffffffff"},
+		"float":       {1.5, "cb3ff8000000000000"},
+		"string":      {"hi", "a26869"},
+		"map":         {map[string]int{"a": 1}, "81a16101"},
+	} {
+		body, err := MarshalMsgpack(tc.payload)
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.want, hex.EncodeToString(body), name)
+	}
+
+	long, err := MarshalMsgpack(strings.Repeat("x", 300))
+	require.NoError(t, err)
+	assert.Equal(t, "da012c", hex.EncodeToString(long[:3]))
+}
+
+func TestUnmarshalMsgpack(t *testing.T) {
+	var got map[string]interface{}
+	// {"n": -1 (int16), "f": 0.5 (float32), "b": bin "hi", "s": str8 "ok", "a": [nil]}
+	body, _ := hex.DecodeString("85" + "a16e" + "d1ffff" + "a166" + "ca3f000000" + "a162" + "c4026869" +
+		"a173" + "d9026f6b" + "a161" + "dc0001c0")
+	require.NoError(t, UnmarshalMsgpack(body, &got))
+	assert.Equal(t, map[string]interface{}{
+		"n": float64(-1),
+		"f": 0.5,
+		"b": "aGk=",
+		"s": "ok",
+		"a": []interface{}{nil},
+	}, got)
+
+	for name, invalid := range map[string]string{
+		"empty":          "",
+		"truncated":      "a568",
+		"forged length":  "ddffffffff",
+		"integer key":    "810101",
+		"extension":      "d40100",
+		"NaN":            "cb7ff8000000000001",
+		"trailing bytes": "c0c0",
+		"too deep":       strings.Repeat("91", maxDepth+2) + "c0",
+	} {
+		body, err := hex.DecodeString(invalid)
+		require.NoError(t, err)
+		assert.Error(t, UnmarshalMsgpack(body, &got), name)
+	}
+}
@@ -0,0 +1,190 @@
+package respond
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types Write and Decode support besides JSON.
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeXML     = "application/xml"
+	MediaTypeMsgpack = "application/msgpack"
+)
+
+// MaxBodyBytes limits the XML and MessagePack request bodies Decode reads;
+// they are read in full before decoding.
+const MaxBodyBytes = 1 << 20
+
+// format is a representation respond can write and read.
+type format struct {
+	mediaType string
+	// aliases are other names clients use for the same format
+	aliases []string
+	// suffix matches structured syntax suffixes such as
+	// application/vnd.task-api.v2+json
+	suffix    string
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte, interface{}) error
+}
+
+// formats are in the order of preference when a client accepts several
+// equally; JSON also answers clients that accept none of them.
+var formats = []*format{
+	{
+		mediaType: MediaTypeJSON,
+		suffix:    "+json",
+		marshal:   marshalJSON,
+		unmarshal: json.Unmarshal,
+	},
+	{
+		mediaType: MediaTypeXML,
+		aliases:   []string{"text/xml"},
+		suffix:    "+xml",
+		marshal:   MarshalXML,
+		unmarshal: UnmarshalXML,
+	},
+	{
+		mediaType: MediaTypeMsgpack,
+		aliases:   []string{"application/x-msgpack", "application/vnd.msgpack"},
+		marshal:   MarshalMsgpack,
+		unmarshal: UnmarshalMsgpack,
+	},
+}
+
+// marshalJSON matches json.Encoder, which JSON has always written with: a
+// trailing newline and HTML characters escaped.
+func marshalJSON(payload interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// names reports whether mediaType is one of the format's names.
+func (f *format) names(mediaType string) bool {
+	if mediaType == f.mediaType || (f.suffix != "" && strings.HasSuffix(mediaType, f.suffix)) {
+		return true
+	}
+	for _, alias := range f.aliases {
+		if mediaType == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate picks the media type to answer an Accept header with: the
+// format with the highest q-value, where the most specific media range
+// that matches a format sets its q-value (RFC 9110, section 12.5.1). Ties
+// go to the more specific match, then to JSON. Without an Accept header, or
+// when the client accepts none of the formats, it is JSON: an error body
+// the client can read beats a 406.
+func Negotiate(accept []string) string {
+	best, bestQ, bestSpecificity := formats[0], -1.0, -1
+	for _, f := range formats {
+		q, specificity := -1.0, -1
+		for _, value := range accept {
+			for _, mediaRange := range strings.Split(value, ",") {
+				mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+				if err != nil {
+					continue
+				}
+				s := matchSpecificity(f, mediaType)
+				if s <= specificity {
+					continue
+				}
+				specificity, q = s, 1
+				if raw, ok := params["q"]; ok {
+					if q, err = strconv.ParseFloat(raw, 64); err != nil {
+						q = 0
+					}
+				}
+			}
+		}
+		if q > 0 && (q > bestQ || (q == bestQ && specificity > bestSpecificity)) {
+			best, bestQ, bestSpecificity = f, q, specificity
+		}
+	}
+	return best.mediaType
+}
+
+// matchSpecificity is 2 when mediaType names the format, 1 for its type
+// with a wildcard subtype, 0 for */* and -1 when it doesn't match.
+func matchSpecificity(f *format, mediaType string) int {
+	switch {
+	case f.names(mediaType):
+		return 2
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		prefix := strings.TrimSuffix(mediaType, "*")
+		if strings.HasPrefix(f.mediaType, prefix) {
+			return 1
+		}
+		for _, alias := range f.aliases {
+			if strings.HasPrefix(alias, prefix) {
+				return 1
+			}
+		}
+	}
+	return -1
+}
+
+func formatOf(mediaType string) *format {
+	for _, f := range formats {
+		if f.names(mediaType) {
+			return f
+		}
+	}
+	return formats[0]
+}
+
+// Write is JSONWithFallback in the format the request's Accept header asks
+// for: JSON, XML or MessagePack. The payload is encoded by its JSON
+// representation, so the formats only differ in syntax. The response
+// varies by Accept.
+func Write(w http.ResponseWriter, r *http.Request, status int, payload, fallback interface{}) error {
+	addVary(w.Header(), "Accept")
+	return encode(w, formatOf(Negotiate(r.Header.Values("Accept"))), status, payload, fallback)
+}
+
+// Decode reads the request body into v in the format its Content-Type
+// names. Bodies without a Content-Type, or with one that is neither XML nor
+// MessagePack, are read as JSON, as they always were.
+func Decode(r *http.Request, v interface{}) error {
+	f := formats[0]
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		f = formatOf(mediaType)
+	}
+	if f.mediaType == MediaTypeJSON {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxBodyBytes {
+		return fmt.Errorf("request body is larger than %d bytes", MaxBodyBytes)
+	}
+	return f.unmarshal(body, v)
+}
+
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,113 @@
+package respond
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                 MediaTypeJSON,
+		"*/*":                              MediaTypeJSON,
+		"text/html":                        MediaTypeJSON,
+		"application/json":                 MediaTypeJSON,
+		"application/vnd.task-api.v2+json": MediaTypeJSON,
+		"application/xml":                  MediaTypeXML,
+		"text/xml":                         MediaTypeXML,
+		"application/msgpack":              MediaTypeMsgpack,
+		"application/x-msgpack":            MediaTypeMsgpack,
+		"application/xml, */*":             MediaTypeXML,
+		"application/*, application/xml":   MediaTypeXML,
+		"application/json;q=0.5, application/xml":          MediaTypeXML,
+		"application/xml;q=0.9, application/msgpack;q=0.9": MediaTypeXML,
+		"application/xml;q=0, */*":                         MediaTypeJSON,
+		"application/json;q=0, application/msgpack;q=0.1":  MediaTypeMsgpack,
+	} {
+		assert.Equal(t, want, Negotiate([]string{accept}), accept)
+	}
+}
+
+type task struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Completed bool       `json:"completed"`
+	Position  int        `json:"position"`
+	Tags      []string   `json:"tags"`
+	DueDate   *time.Time `json:"dueDate"`
+}
+
+func TestWrite(t *testing.T) {
+	payload := task{ID: "t1", Title: "Write <docs> & tests", Tags: []string{"api"}, Position: 3}
+
+	for accept, contentType := range map[string]string{
+		"":                    MediaTypeJSON,
+		"application/xml":     MediaTypeXML,
+		"application/msgpack": MediaTypeMsgpack,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		require.NoError(t, Write(w, req, http.StatusOK, payload, nil))
+
+		assert.Equal(t, contentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+		// Clients read back what they were sent
+		var got task
+		f := formatOf(contentType)
+		require.NoError(t, f.unmarshal(w.Body.Bytes(), &got), accept)
+		assert.Equal(t, payload, got, accept)
+	}
+}
+
+func TestWriteEncodeFailure(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", MediaTypeXML)
+	w := httptest.NewRecorder()
+	w.Header().Set("Vary", "Origin, accept")
+
+	err := Write(w, req, http.StatusOK, failingMarshaler{}, map[string]string{"error": "Internal Server Error"})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, MediaTypeXML, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<error>Internal Server Error</error>")
+	assert.Equal(t, []string{"Origin, accept"}, w.Header().Values("Vary"))
+}
+
+func TestDecode(t *testing.T) {
+	due := time.Date(2026, time.November, 1, 9, 0, 0, 0, time.UTC)
+	want := task{ID: "t1", Title: "true", Completed: true, Position: -2, Tags: []string{"a", "b"}, DueDate: &due}
+	msgpack, err := MarshalMsgpack(want)
+	require.NoError(t, err)
+
+	for contentType, body := range map[string]string{
+		"":                 `{"id":"t1","title":"true","completed":true,"position":-2,"tags":["a","b"],"dueDate":"2026-11-01T09:00:00Z"}`,
+		"application/json": `{"id":"t1","title":"true","completed":true,"position":-2,"tags":["a","b"],"dueDate":"2026-11-01T09:00:00Z"}`,
+		"application/xml; charset=utf-8": `<?xml version="1.0"?>
+			<task>
+				<id>t1</id>
+				<title>true</title>
+				<completed>true</completed>
+				<position>-2</position>
+				<tags><item>a</item><item>b</item></tags>
+				<dueDate>2026-11-01T09:00:00Z</dueDate>
+			</task>`,
+		"application/msgpack": string(msgpack),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		var got task
+		require.NoError(t, Decode(req, &got), contentType)
+		assert.Equal(t, want, got, contentType)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat(" ", MaxBodyBytes+1)))
+	req.Header.Set("Content-Type", MediaTypeXML)
+	assert.Error(t, Decode(req, &task{}))
+}
@@ -0,0 +1,82 @@
+// Package respond writes JSON, XML and MessagePack responses without ever
+// sending a truncated body. Encoding straight to the ResponseWriter commits
+// the status and headers before the payload is known to encode, so a
+// failure halfway through (an unsupported value, a NaN, a failing
+// MarshalJSON) leaves the client with a 200 and half a document. respond
+// encodes into a buffer first and only writes once encoding succeeded;
+// otherwise it sends a well-formed 500 error body instead.
+//
+//	if err := respond.JSON(w, http.StatusOK, tasks); err != nil {
+//		slog.ErrorContext(r.Context(), "failed to encode response", "error", err)
+//	}
+//
+// Write picks the format from the request's Accept header, and Decode reads
+// request bodies in the format their Content-Type names. Behind
+// Int64Middleware, JSON quotes 64-bit integers for clients that ask.
+package respond
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// genericErrorBody is sent when neither the payload nor the fallback encode.
+var genericErrorBody = []byte(`{"error":"Internal Server Error","message":"Failed to encode response"}` + "\n")
+
+// EncodeError is returned when the payload could not be encoded. The client
+// got a 500 with the fallback body instead.
+type EncodeError struct {
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("failed to encode response: %v", e.Err)
+}
+
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// JSON writes payload as JSON with the given status. If the payload doesn't
+// encode, it writes a 500 with a generic error body and returns an
+// *EncodeError; nothing of the payload reaches the client.
+func JSON(w http.ResponseWriter, status int, payload interface{}) error {
+	return JSONWithFallback(w, status, payload, nil)
+}
+
+// JSONWithFallback is JSON with the body to send when payload doesn't encode,
+// e.g. the API's own error format. A nil or unencodable fallback is replaced
+// by the generic error body.
+func JSONWithFallback(w http.ResponseWriter, status int, payload, fallback interface{}) error {
+	return encode(w, formats[0], status, payload, fallback)
+}
+
+func encode(w http.ResponseWriter, f *format, status int, payload, fallback interface{}) error {
+	marshal := f.marshal
+	if f == formats[0] && quotesInt64s(w) {
+		marshal = marshalJSONInt64Strings
+	}
+	body, err := marshal(payload)
+	if err == nil {
+		write(w, f.mediaType, status, body)
+		return nil
+	}
+
+	// Validators describe the payload that was never sent
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+
+	var fallbackBody []byte
+	if fallback != nil {
+		fallbackBody, _ = marshal(fallback)
+	}
+	if fallbackBody == nil {
+		f, fallbackBody = formats[0], genericErrorBody
+	}
+	write(w, f.mediaType, http.StatusInternalServerError, fallbackBody)
+	return &EncodeError{Err: err}
+}
+
+func write(w http.ResponseWriter, contentType string, status int, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,64 @@
+package respond
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("boom")
+}
+
+func TestJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	require.NoError(t, JSON(w, http.StatusCreated, map[string]string{"id": "1"}))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"id\":\"1\"}\n", w.Body.String())
+}
+
+func TestJSONEncodeFailure(t *testing.T) {
+	for name, payload := range map[string]interface{}{
+		"unsupported value": map[string]float64{"ratio": math.NaN()},
+		"unsupported type":  map[string]interface{}{"ch": make(chan int)},
+		"failing marshaler": []interface{}{"first", failingMarshaler{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("ETag", `"v1"`)
+
+			err := JSON(w, http.StatusOK, payload)
+			var encodeErr *EncodeError
+			require.ErrorAs(t, err, &encodeErr)
+
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.True(t, json.Valid(w.Body.Bytes()), "body is a complete JSON document")
+			assert.NotContains(t, w.Body.String(), "first")
+			assert.Empty(t, w.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestJSONWithFallback(t *testing.T) {
+	fallback := map[string]string{"error": "Internal Server Error", "requestId": "abc"}
+
+	w := httptest.NewRecorder()
+	assert.Error(t, JSONWithFallback(w, http.StatusOK, failingMarshaler{}, fallback))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error":"Internal Server Error","requestId":"abc"}`, w.Body.String())
+
+	// A fallback that fails too is replaced by the generic body
+	w = httptest.NewRecorder()
+	assert.Error(t, JSONWithFallback(w, http.StatusOK, failingMarshaler{}, failingMarshaler{}))
+	assert.Equal(t, string(genericErrorBody), w.Body.String())
+}
@@ -0,0 +1,156 @@
+package respond
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// maxDepth bounds the nesting of decoded documents, so a body of a million
+// opening tags can't exhaust the stack.
+const maxDepth = 64
+
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindNumber
+	kindString
+	kindArray
+	kindObject
+)
+
+// value is a JSON document with the order of object keys kept. Every format
+// goes through it: a payload is marshaled to JSON first, so field names,
+// omitempty, IDs and times look the same in XML and MessagePack, and
+// decoded XML and MessagePack are turned back into JSON for the handler.
+type value struct {
+	kind   kind
+	bool   bool
+	text   string // number or string
+	items  []value
+	fields []field
+}
+
+type field struct {
+	name  string
+	value value
+}
+
+// parseJSON reads a JSON document into a value.
+func parseJSON(data []byte) (value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readJSON(dec, 0)
+	if err != nil {
+		return value{}, err
+	}
+	if dec.More() {
+		return value{}, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+func readJSON(dec *json.Decoder, depth int) (value, error) {
+	if depth > maxDepth {
+		return value{}, fmt.Errorf("document nested deeper than %d levels", maxDepth)
+	}
+	token, err := dec.Token()
+	if err != nil {
+		return value{}, err
+	}
+	switch t := token.(type) {
+	case nil:
+		return value{kind: kindNull}, nil
+	case bool:
+		return value{kind: kindBool, bool: t}, nil
+	case json.Number:
+		return value{kind: kindNumber, text: t.String()}, nil
+	case string:
+		return value{kind: kindString, text: t}, nil
+	case json.Delim:
+		v := value{kind: kindArray}
+		if t == '{' {
+			v.kind = kindObject
+		}
+		for dec.More() {
+			var name string
+			if v.kind == kindObject {
+				key, err := dec.Token()
+				if err != nil {
+					return value{}, err
+				}
+				name = key.(string)
+			}
+			item, err := readJSON(dec, depth+1)
+			if err != nil {
+				return value{}, err
+			}
+			if v.kind == kindObject {
+				v.fields = append(v.fields, field{name: name, value: item})
+			} else {
+				v.items = append(v.items, item)
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return value{}, err
+		}
+		return v, nil
+	}
+	return value{}, fmt.Errorf("unexpected JSON token %v", token)
+}
+
+// appendJSON writes the value as JSON.
+func (v value) appendJSON(buf *bytes.Buffer) error {
+	switch v.kind {
+	case kindNull:
+		buf.WriteString("null")
+	case kindBool:
+		buf.WriteString(strconv.FormatBool(v.bool))
+	case kindNumber:
+		// Numbers come from JSON, MessagePack or XML text; only valid
+		// literals may reach the document
+		if !isJSONNumber(v.text) {
+			return fmt.Errorf("%q is not a number", v.text)
+		}
+		buf.WriteString(v.text)
+	case kindString:
+		text, _ := json.Marshal(v.text)
+		buf.Write(text)
+	case kindArray:
+		buf.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := item.appendJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case kindObject:
+		buf.WriteByte('{')
+		for i, f := range v.fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(f.name)
+			buf.Write(name)
+			buf.WriteByte(':')
+			if err := f.value.appendJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	}
+	return nil
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+func isJSONNumber(text string) bool {
+	return jsonNumber.MatchString(text)
+}
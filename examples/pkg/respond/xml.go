@@ -0,0 +1,322 @@
+package respond
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// XML documents mirror the JSON ones. The payload is the <response>
+// element, object fields are child elements named like the JSON fields,
+// array items are <item> elements and null is an empty element with
+// null="true":
+//
+//	<response>
+//	  <id>tsk_1</id>
+//	  <tags><item>api</item></tags>
+//	  <dueDate null="true"></dueDate>
+//	</response>
+//
+// Keys that aren't valid element names, such as the keys of maps holding
+// data, become <entry key="...">.
+const (
+	xmlRoot  = "response"
+	xmlItem  = "item"
+	xmlEntry = "entry"
+)
+
+// MarshalXML encodes the JSON representation of payload as XML.
+func MarshalXML(payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXML(&buf, xmlRoot, v)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeXML(buf *bytes.Buffer, name string, v value) {
+	tag := name
+	if isXMLName(name) {
+		buf.WriteString("<" + name)
+	} else {
+		tag = xmlEntry
+		buf.WriteString(`<` + xmlEntry + ` key="`)
+		xml.EscapeText(buf, []byte(name))
+		buf.WriteString(`"`)
+	}
+	if v.kind == kindNull {
+		buf.WriteString(` null="true"></` + tag + `>`)
+		return
+	}
+	buf.WriteByte('>')
+
+	switch v.kind {
+	case kindBool:
+		buf.WriteString(strconv.FormatBool(v.bool))
+	case kindNumber, kindString:
+		xml.EscapeText(buf, []byte(v.text))
+	case kindArray:
+		for _, item := range v.items {
+			writeXML(buf, xmlItem, item)
+		}
+	case kindObject:
+		for _, f := range v.fields {
+			writeXML(buf, f.name, f.value)
+		}
+	}
+	buf.WriteString("</" + tag + ">")
+}
+
+// isXMLName reports whether name can be used as an element name as it is.
+// It is stricter than XML itself: ASCII letters, digits, '-', '_' and '.',
+// not starting with a digit, '-', '.' or "xml".
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case (r >= '0' && r <= '9') || r == '-' || r == '.':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// UnmarshalXML decodes an XML document in the format MarshalXML writes into
+// v, which is then filled like json.Unmarshal would. XML text has no types,
+// so v's Go types decide whether <completed>true</completed> is the boolean
+// or the string "true". The name of the root element doesn't matter.
+func UnmarshalXML(data []byte, v interface{}) error {
+	root, err := parseXML(data)
+	if err != nil {
+		return err
+	}
+	doc, err := fromXML(root, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := doc.appendJSON(&buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+type xmlElement struct {
+	name     string
+	null     bool
+	text     strings.Builder
+	children []*xmlElement
+}
+
+// parseXML reads the element tree of a document. encoding/xml doesn't load
+// external entities or DTDs, so the usual XML attacks don't apply.
+func parseXML(data []byte) (*xmlElement, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlElement
+	var open []*xmlElement
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if root != nil && len(open) == 0 {
+				return nil, errors.New("XML document has more than one root element")
+			}
+			if len(open) > maxDepth {
+				return nil, fmt.Errorf("document nested deeper than %d levels", maxDepth)
+			}
+			el := &xmlElement{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Local == "key" && t.Name.Local == xmlEntry:
+					el.name = attr.Value
+				case attr.Name.Local == "null":
+					el.null = attr.Value == "true"
+				}
+			}
+			if root == nil {
+				root = el
+			} else {
+				parent := open[len(open)-1]
+				parent.children = append(parent.children, el)
+			}
+			open = append(open, el)
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if len(open) > 0 {
+				open[len(open)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("empty XML document")
+	}
+	return root, nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// fromXML turns an element into the value that decodes into t. A nil t
+// means no type is known, as for interface{} fields and unknown elements.
+func fromXML(el *xmlElement, t reflect.Type) (value, error) {
+	if el.null {
+		return value{kind: kindNull}, nil
+	}
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	text := el.text.String()
+
+	// Types that decode themselves, such as times, take the text as a string
+	if t == nil || t.Kind() == reflect.Interface ||
+		reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		if len(el.children) == 0 {
+			return value{kind: kindString, text: text}, nil
+		}
+		if isXMLList(el) {
+			return listFromXML(el, nil)
+		}
+		return objectFromXML(el, func(string) reflect.Type { return nil })
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		switch strings.TrimSpace(text) {
+		case "true":
+			return value{kind: kindBool, bool: true}, nil
+		case "false":
+			return value{kind: kindBool}, nil
+		}
+		return value{}, fmt.Errorf("<%s>: %q is not true or false", el.name, text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if !isJSONNumber(strings.TrimSpace(text)) {
+			return value{}, fmt.Errorf("<%s>: %q is not a number", el.name, text)
+		}
+		return value{kind: kindNumber, text: strings.TrimSpace(text)}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is base64 text, as in JSON
+			return value{kind: kindString, text: strings.TrimSpace(text)}, nil
+		}
+		return listFromXML(el, t.Elem())
+	case reflect.Map:
+		return objectFromXML(el, func(string) reflect.Type { return t.Elem() })
+	case reflect.Struct:
+		fields := jsonFieldTypes(t)
+		return objectFromXML(el, func(name string) reflect.Type {
+			if ft, ok := fields[name]; ok {
+				return ft
+			}
+			for fieldName, ft := range fields {
+				if strings.EqualFold(fieldName, name) {
+					return ft
+				}
+			}
+			return nil
+		})
+	}
+	return value{kind: kindString, text: text}, nil
+}
+
+func isXMLList(el *xmlElement) bool {
+	for _, child := range el.children {
+		if child.name != xmlItem {
+			return false
+		}
+	}
+	return true
+}
+
+func listFromXML(el *xmlElement, itemType reflect.Type) (value, error) {
+	v := value{kind: kindArray, items: []value{}}
+	for _, child := range el.children {
+		item, err := fromXML(child, itemType)
+		if err != nil {
+			return value{}, err
+		}
+		v.items = append(v.items, item)
+	}
+	return v, nil
+}
+
+func objectFromXML(el *xmlElement, fieldType func(name string) reflect.Type) (value, error) {
+	v := value{kind: kindObject}
+	for _, child := range el.children {
+		item, err := fromXML(child, fieldType(child.name))
+		if err != nil {
+			return value{}, err
+		}
+		v.fields = append(v.fields, field{name: child.name, value: item})
+	}
+	return v, nil
+}
+
+// jsonFieldTypes maps the JSON names of a struct's fields to their types,
+// including the fields of embedded structs.
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for embeddedName, ft := range jsonFieldTypes(embedded) {
+					if _, ok := fields[embeddedName]; !ok {
+						fields[embeddedName] = ft
+					}
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
@@ -0,0 +1,61 @@
+package respond
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalXML(t *testing.T) {
+	body, err := MarshalXML(map[string]interface{}{
+		"title":   "a < b",
+		"done":    false,
+		"dueDate": nil,
+		"tags":    []string{"x", "y"},
+		"meta":    map[string]int{"per page": 10},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<response><done>false</done><dueDate null="true"></dueDate>`+
+		`<meta><entry key="per page">10</entry></meta>`+
+		`<tags><item>x</item><item>y</item></tags><title>a &lt; b</title></response>`+"\n", string(body))
+}
+
+func TestUnmarshalXML(t *testing.T) {
+	var req struct {
+		Title       *string                `json:"title"`
+		Description *string                `json:"description"`
+		Tags        *[]string              `json:"tags"`
+		Count       int                    `json:"count"`
+		Labels      map[string]string      `json:"labels"`
+		Extra       map[string]interface{} `json:"extra"`
+	}
+	require.NoError(t, UnmarshalXML([]byte(`<request>
+		<title>1234</title>
+		<description null="true"/>
+		<tags></tags>
+		<count>7</count>
+		<labels><entry key="a b">c</entry></labels>
+		<extra><list><item>1</item></list></extra>
+		<unknown><deep>ignored</deep></unknown>
+	</request>`), &req))
+	assert.Equal(t, "1234", *req.Title)
+	assert.Nil(t, req.Description)
+	assert.Equal(t, []string{}, *req.Tags)
+	assert.Equal(t, 7, req.Count)
+	assert.Equal(t, map[string]string{"a b": "c"}, req.Labels)
+	assert.Equal(t, map[string]interface{}{"list": []interface{}{"1"}}, req.Extra)
+
+	for _, invalid := range []string{
+		``,
+		`<request><count>7, "title": "x"</count></request>`,
+		`<request><count>seven</count></request>`,
+		`<request><title>unclosed</request>`,
+		`<a></a><b></b>`,
+		strings.Repeat("<a>", maxDepth+2) + strings.Repeat("</a>", maxDepth+2),
+	} {
+		assert.Error(t, UnmarshalXML([]byte(invalid), &req), invalid)
+	}
+}